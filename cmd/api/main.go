@@ -17,6 +17,7 @@ import (
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/config"
 	"reup-goals-backend/internal/db"
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/goals"
 	"reup-goals-backend/internal/migrations"
 	"reup-goals-backend/internal/privacy"
@@ -38,6 +39,7 @@ import (
 	"reup-goals-backend/internal/v2/navigation"
 	"reup-goals-backend/internal/v2/operations"
 	"reup-goals-backend/internal/v2/profile"
+	"reup-goals-backend/internal/v2/scim"
 	"reup-goals-backend/internal/v2/strategicmemory"
 	"reup-goals-backend/internal/v2/strategy"
 	"reup-goals-backend/internal/v2/tactics"
@@ -50,7 +52,6 @@ func main() {
 	if err := cfg.Validate(); err != nil {
 		log.Fatal("Configuration error: ", err)
 	}
-	jwtSecret := []byte(cfg.JWTSecret)
 	secureCookie := cfg.SecureCookies || cfg.Environment == "production" || cfg.Environment == "staging"
 
 	database, err := db.Connect(cfg.ConnString(), db.PoolOptions{
@@ -75,7 +76,48 @@ func main() {
 	rootCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stopSignals()
 
-	billingService := billing.NewService(database, cfg.BillingEnforcementEnabled)
+	tokenKeys := auth.NewKeySet(database, []byte(cfg.JWTSecret), cfg.JWTSigningAlgorithm, !cfg.JWTRejectLegacyHS256)
+	if err := tokenKeys.Load(rootCtx); err != nil {
+		log.Fatal("Signing keys error:", err)
+	}
+	tokenKeys.Start(rootCtx)
+
+	if cfg.DataEncryptionKey != "" {
+		keyVault := database
+		if cfg.DataKeyVaultURL != "" {
+			keyVault, err = db.Connect(cfg.DataKeyVaultURL, db.PoolOptions{MaxOpenConns: 4, MaxIdleConns: 1})
+			if err != nil {
+				log.Fatal("Key vault error:", err)
+			}
+			defer keyVault.Close()
+		} else {
+			log.Println("[WARN] DATA_KEY_VAULT_URL is not set; deleted workspaces stay readable in database backups")
+		}
+		if err := encryption.EnsureVaultSchema(rootCtx, keyVault); err != nil {
+			log.Fatal("Key vault migration error:", err)
+		}
+		dataKeys := encryption.NewKeyring(database, cfg.DataEncryptionKey, cfg.DataEncryptionPreviousKeys).WithVault(keyVault)
+		encryption.Install(dataKeys)
+		encryption.NewRekeyer(dataKeys, encryption.RekeyPolicy{
+			Interval:    cfg.DataRekeyInterval,
+			KeyLifetime: cfg.DataKeyLifetime,
+		}).Start(rootCtx)
+	} else {
+		log.Println("[WARN] DATA_ENCRYPTION_KEY is not set; business content is stored unencrypted")
+	}
+	auth.InstallLegacySecretKey([]byte(cfg.JWTSecret))
+	auth.OnSessionRevoked(v2api.ForgetSession)
+	if err := auth.SealStoredSecrets(rootCtx, database); err != nil {
+		log.Fatal("Auth secrets error:", err)
+	}
+
+	billingService := billing.NewService(database, cfg.BillingEnforcementEnabled).WithDunningPolicy(billing.DunningPolicy{
+		GraceDays: cfg.DunningGraceDays, RetryDays: cfg.DunningRetryDays, EmailDays: cfg.DunningEmailDays,
+	})
+	if err := billingService.LoadCatalog(rootCtx); err != nil {
+		log.Printf("[WARN] billing catalog load failed, using built-in plans: %v", err)
+	}
+	billingService.StartCatalogRefresh(rootCtx)
 	billingAdminHandler := billing.NewAdminHandler(billingService, cfg.BillingAdminKey)
 	aiGovernance := aiplatform.NewGovernance(database, aiplatform.Limits{
 		RequestsPerMinute: cfg.AIRequestsPerMinute,
@@ -106,12 +148,16 @@ func main() {
 	taskAI := tasks.New(aiClient, database)
 	emailService := auth.NewEmailService(cfg)
 	cloudPayments := subscriptions.NewCloudPaymentsClient(cfg)
-	subscriptionHandler := subscriptions.NewHandler(database, cloudPayments, billingService)
+	yooKassa := subscriptions.NewYooKassaClient(cfg)
+	paymentProviders := subscriptions.NewProviders(cloudPayments, yooKassa)
+	subscriptionHandler := subscriptions.NewHandler(database, cloudPayments, billingService).
+		WithProviders(paymentProviders)
+	billingAdminHandler.WithRefunds(paymentProviders, profile.NewStore(database))
 	audioHandler := audioapi.NewHandler(database, transcriptionAIClient)
 	aiActionsHandler := aiactions.NewHandler(database)
 	aiPlatformHandler := aiplatform.NewHandler(database, cfg.AIAdminKey)
 	bootstrapHandler := bootstrap.NewHandler(database, cfg.BillingEnforcementEnabled)
-	courseHandler := course.NewHandler(database)
+	courseHandler := course.NewHandler(database, auditorAIClient)
 	departmentHandler := departments.NewHandler(database, strategicSourceRecorder)
 	metricsHandler := metrics.NewHandler(database)
 	navigationHandler := navigation.NewHandler(database)
@@ -126,7 +172,7 @@ func main() {
 		database,
 		agentapi.ServiceConfig{
 			Enabled: cfg.AgentRuntimeEnabled, Model: cfg.OpenAIAdvisorModel,
-			Secret: cfg.AgentRuntimeSecret, MaxTurns: cfg.AgentRuntimeMaxTurns,
+			Secret: cfg.AgentRuntimeSecret, Keys: tokenKeys, MaxTurns: cfg.AgentRuntimeMaxTurns,
 			Timeout: cfg.AgentRuntimeTimeout, ReleaseID: cfg.AgentReleaseID,
 		},
 		agentapi.NewRuntimeClient(cfg.AgentRuntimeURL, cfg.AgentRuntimeSecret, cfg.AgentRuntimeTimeout),
@@ -136,9 +182,14 @@ func main() {
 	agentHandler := agentapi.NewHandler(agentService)
 	operationsHandler := operations.NewHandler(database, jobManager)
 	privacyHandler := privacy.NewHandler(database)
+	scimHandler := scim.NewHandler(database)
+	signInMonitor := auth.NewSignInMonitor(database, emailService, secureCookie, cfg)
+	ssoService := auth.NewSSOService(database, tokenKeys, secureCookie, cfg).WithSignInMonitor(signInMonitor)
 	profileHandler := profile.NewHandler(database, cfg, emailService, cloudPayments, billingService).
-		WithWorkspaceDataCleaner(strategicMemoryHandler)
-	operationsCollector := operations.NewCollector(database, jwtSecret)
+		WithWorkspaceDataCleaner(strategicMemoryHandler).
+		WithSSO(ssoService).
+		WithCardProvider(paymentProviders.Default(cfg.PaymentProvider))
+	operationsCollector := operations.NewCollector(database, tokenKeys)
 	operationsCollector.Start(rootCtx)
 	defer operationsCollector.Stop()
 	jobManager.StartPartitioned(rootCtx, cfg.AIJobWorkers, cfg.AIAgentJobWorkers, agentapi.InteractiveJobPriority)
@@ -152,20 +203,29 @@ func main() {
 		BackgroundJobs:  cfg.BackgroundJobRetention,
 		LegalEvidence:   cfg.LegalEvidenceRetention,
 		PrivacyRequests: cfg.PrivacyRequestRetention,
+		AuditEvents:     cfg.AuditLogRetention,
+		SignInHistory:   cfg.SignInHistoryRetention,
 	}).Start(rootCtx)
+	profile.NewClosingDocumentRunner(database, emailService, cfg.ClosingDocumentsInterval).Start(rootCtx)
+	subscriptions.NewDunningRunner(
+		database, billingService, paymentProviders, emailService, cfg.FrontendBaseURL, cfg.DunningInterval,
+	).Start(rootCtx)
+	if yooKassa.Configured() {
+		subscriptions.NewRenewalRunner(database, billingService, yooKassa, cfg.DunningInterval).Start(rootCtx)
+	}
 
 	mux := http.NewServeMux()
 	paidProduct := func(next http.HandlerFunc) http.HandlerFunc {
-		return v2api.RequireAuth(database, jwtSecret, v2api.RequireProductAccess(database, next))
+		return v2api.RequireAuth(database, tokenKeys, v2api.RequireProductAccess(database, next))
 	}
 	paidAIChat := func(next http.HandlerFunc) http.HandlerFunc {
-		return v2api.RequireAuth(database, jwtSecret, v2api.RequireAIChatAccess(database, next))
+		return v2api.RequireAuth(database, tokenKeys, v2api.RequireAIChatAccess(database, next))
 	}
 	onboardingOrPaid := func(next http.HandlerFunc) http.HandlerFunc {
-		return v2api.RequireAuth(database, jwtSecret, v2api.RequireOnboardingOrProductAccess(database, next))
+		return v2api.RequireAuth(database, tokenKeys, v2api.RequireOnboardingOrProductAccess(database, next))
 	}
 	onboardingOrPaidAI := func(next http.HandlerFunc) http.HandlerFunc {
-		return v2api.RequireAuth(database, jwtSecret, v2api.RequireOnboardingOrAIChatAccess(database, next))
+		return v2api.RequireAuth(database, tokenKeys, v2api.RequireOnboardingOrAIChatAccess(database, next))
 	}
 
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -204,7 +264,7 @@ func main() {
 	})
 
 	// Auth middleware
-	mw := auth.New(database, jwtSecret)
+	mw := auth.New(database, tokenKeys)
 	registerLimiter := security.NewLimiter(10, time.Minute)
 	loginLimiter := security.NewLimiter(30, time.Minute)
 	verifyEmailLimiter := security.NewLimiter(30, time.Minute)
@@ -212,25 +272,51 @@ func main() {
 	forgotPasswordLimiter := security.NewLimiter(5, time.Minute)
 	verifyResetCodeLimiter := security.NewLimiter(20, time.Minute)
 	resetPasswordLimiter := security.NewLimiter(10, time.Minute)
+	refreshLimiter := security.NewLimiter(60, time.Minute)
+	ssoLimiter := security.NewLimiter(30, time.Minute)
+	notMeLimiter := security.NewLimiter(10, time.Minute)
+	emailSignInStartLimiter := security.NewLimiter(5, time.Minute)
+	emailSignInLimiter := security.NewLimiter(20, time.Minute)
+	scimLimiter := security.NewLimiter(300, time.Minute)
 
 	// -----------------------
 	// AUTH (public)
 	// -----------------------
 	mux.Handle("/auth/register", registerLimiter.Wrap(auth.RegisterHandler(database, emailService)))
-	mux.Handle("/auth/login", loginLimiter.Wrap(auth.LoginHandler(database, jwtSecret, secureCookie, cfg.BrowserAuthOnly)))
-	mux.Handle("/auth/verify-email", verifyEmailLimiter.Wrap(auth.VerifyEmailHandler(database, jwtSecret, secureCookie, cfg.BrowserAuthOnly)))
+	mux.Handle("/auth/login", loginLimiter.Wrap(auth.LoginHandler(database, tokenKeys, secureCookie, cfg.BrowserAuthOnly, signInMonitor)))
+	mux.Handle("/auth/login/2fa", loginLimiter.Wrap(auth.TwoFactorLoginHandler(database, tokenKeys, secureCookie, cfg.BrowserAuthOnly, signInMonitor)))
+	mux.Handle("/auth/verify-email", verifyEmailLimiter.Wrap(auth.VerifyEmailHandler(database, tokenKeys, secureCookie, cfg.BrowserAuthOnly, signInMonitor)))
 	mux.Handle("/auth/resend-code", resendCodeLimiter.Wrap(auth.ResendCodeHandler(database, emailService)))
 	mux.Handle("/auth/forgot-password", forgotPasswordLimiter.Wrap(auth.ForgotPasswordHandler(database, emailService)))
 	mux.Handle("/auth/verify-reset-code", verifyResetCodeLimiter.Wrap(auth.VerifyResetCodeHandler(database)))
 	mux.Handle("/auth/reset-password", resetPasswordLimiter.Wrap(auth.ResetPasswordHandler(database)))
+	mux.Handle("/auth/refresh", refreshLimiter.Wrap(auth.RefreshHandler(database, tokenKeys, secureCookie, cfg.BrowserAuthOnly)))
+	mux.Handle("/auth/sso/start", ssoLimiter.Wrap(ssoService.StartHandler()))
+	mux.Handle("/auth/sso/callback", ssoLimiter.Wrap(ssoService.CallbackHandler()))
+	mux.Handle("/auth/sso/link", ssoLimiter.Wrap(ssoService.LinkHandler()))
+	mux.Handle("/auth/email-sign-in/start", emailSignInStartLimiter.Wrap(auth.EmailSignInStartHandler(database, emailService, cfg.FrontendBaseURL)))
+	mux.Handle("/auth/email-sign-in", emailSignInLimiter.Wrap(auth.EmailSignInHandler(database, tokenKeys, secureCookie, cfg.BrowserAuthOnly, signInMonitor)))
+	mux.Handle("/auth/not-me", notMeLimiter.Wrap(signInMonitor.RejectSignInHandler()))
 	mux.Handle("/auth/me", mw.Wrap(auth.MeHandler(database)))
 	mux.HandleFunc("/api/v2/privacy/legal-documents", privacyHandler.Documents)
 	mux.HandleFunc("/api/v2/invitations/preview", profileHandler.InvitationPreview)
-	mux.Handle("/api/v2/privacy/acceptances", v2api.RequireAuth(database, jwtSecret, privacyHandler.Acceptances))
-	mux.Handle("/api/v2/privacy/requests", v2api.RequireAuth(database, jwtSecret, privacyHandler.Requests))
-	mux.Handle("/api/v2/profile", v2api.RequireAuth(database, jwtSecret, profileHandler.Profile))
-	mux.Handle("/api/v2/profile/", v2api.RequireAuth(database, jwtSecret, profileHandler.Profile))
+	mux.Handle("/api/v2/privacy/acceptances", v2api.RequireAuth(database, tokenKeys, privacyHandler.Acceptances))
+	mux.Handle("/api/v2/privacy/requests", v2api.RequireAuth(database, tokenKeys, privacyHandler.Requests))
+	mux.Handle("/api/v2/profile", v2api.RequireAuth(database, tokenKeys, profileHandler.Profile))
+	mux.Handle("/api/v2/profile/", v2api.RequireAuth(database, tokenKeys, profileHandler.Profile))
+	mux.Handle("/scim/v2/", scimLimiter.Wrap(http.HandlerFunc(scimHandler.SCIM)))
 	mux.HandleFunc("/api/v2/admin/billing/invoices/confirm", billingAdminHandler.ConfirmInvoice)
+	mux.HandleFunc("/api/v2/admin/billing/refunds", billingAdminHandler.Refund)
+	mux.HandleFunc("/api/v2/admin/billing/statements", billingAdminHandler.ImportStatement)
+	mux.HandleFunc("/api/v2/admin/billing/statements/review", billingAdminHandler.StatementReview)
+	mux.HandleFunc("/api/v2/admin/billing/statements/resolve", billingAdminHandler.ResolveStatement)
+	mux.HandleFunc("/api/v2/admin/billing/plans", billingAdminHandler.Plans)
+	mux.HandleFunc("/api/v2/admin/billing/plans/prices", billingAdminHandler.PlanPrices)
+	mux.HandleFunc("/api/v2/admin/billing/promo-codes", billingAdminHandler.PromoCodes)
+	mux.HandleFunc("/api/v2/admin/billing/partners", billingAdminHandler.Partners)
+	mux.HandleFunc("/api/v2/admin/billing/partners/report", billingAdminHandler.PartnerReport)
+	mux.Handle("/api/v2/admin/auth/signing-keys", auth.SigningKeysAdminHandler(tokenKeys, cfg.AuthAdminKey))
+	mux.Handle("/.well-known/jwks.json", tokenKeys.JWKSHandler())
 
 	// -----------------------
 	// SUBSCRIPTIONS
@@ -239,21 +325,24 @@ func main() {
 	mux.Handle("/subscription/cancel", mw.Wrap(subscriptionHandler.Cancel))
 	if cfg.BillingPaymentsEnabled {
 		mux.Handle("/subscription/checkout-config", mw.Wrap(subscriptionHandler.CheckoutConfig))
-		mux.Handle("/payments/cloudpayments/check", subscriptionHandler.CloudPaymentsWebhook("check"))
-		mux.Handle("/payments/cloudpayments/pay", subscriptionHandler.CloudPaymentsWebhook("pay"))
-		mux.Handle("/payments/cloudpayments/fail", subscriptionHandler.CloudPaymentsWebhook("fail"))
-		mux.Handle("/payments/cloudpayments/recurrent", subscriptionHandler.CloudPaymentsWebhook("recurrent"))
-		mux.Handle("/payments/cloudpayments/cancel", subscriptionHandler.CloudPaymentsWebhook("cancel"))
+		mux.Handle("/payments/cloudpayments/check", subscriptionHandler.Webhook(cloudPayments))
+		mux.Handle("/payments/cloudpayments/pay", subscriptionHandler.Webhook(cloudPayments))
+		mux.Handle("/payments/cloudpayments/fail", subscriptionHandler.Webhook(cloudPayments))
+		mux.Handle("/payments/cloudpayments/recurrent", subscriptionHandler.Webhook(cloudPayments))
+		mux.Handle("/payments/cloudpayments/cancel", subscriptionHandler.Webhook(cloudPayments))
+		if yooKassa.Configured() {
+			mux.Handle("/payments/yookassa/notify", subscriptionHandler.Webhook(yooKassa))
+		}
 	}
 
 	// -----------------------
 	// V2 FOUNDATION
 	// -----------------------
-	mux.Handle("/api/v2/bootstrap", v2api.RequireAuth(database, jwtSecret, bootstrapHandler.Bootstrap))
-	mux.Handle("/api/v2/navigation", v2api.RequireAuth(database, jwtSecret, navigationHandler.Navigation))
-	mux.Handle("/api/v2/navigation/product-tour", v2api.RequireAuth(database, jwtSecret, navigationHandler.UpdateProductTour))
-	mux.Handle("/api/v2/navigation/feature-onboarding", v2api.RequireAuth(database, jwtSecret, navigationHandler.UpdateFeatureOnboarding))
-	mux.Handle("/api/v2/onboarding-summary", v2api.RequireAuth(database, jwtSecret, strategicMemoryHandler.OnboardingSummary))
+	mux.Handle("/api/v2/bootstrap", v2api.RequireAuth(database, tokenKeys, bootstrapHandler.Bootstrap))
+	mux.Handle("/api/v2/navigation", v2api.RequireAuth(database, tokenKeys, navigationHandler.Navigation))
+	mux.Handle("/api/v2/navigation/product-tour", v2api.RequireAuth(database, tokenKeys, navigationHandler.UpdateProductTour))
+	mux.Handle("/api/v2/navigation/feature-onboarding", v2api.RequireAuth(database, tokenKeys, navigationHandler.UpdateFeatureOnboarding))
+	mux.Handle("/api/v2/onboarding-summary", v2api.RequireAuth(database, tokenKeys, strategicMemoryHandler.OnboardingSummary))
 	mux.Handle("/api/v2/departments", paidProduct(departmentHandler.Departments))
 	mux.Handle("/api/v2/departments/", paidProduct(departmentHandler.Departments))
 	mux.Handle("/api/v2/workspace-documents", paidProduct(workspaceDocumentsHandler.Documents))
@@ -262,14 +351,18 @@ func main() {
 	mux.Handle("/api/v2/metrics/catalog", paidProduct(metricsHandler.Metrics))
 	mux.Handle("/api/v2/metrics/targets", paidProduct(metricsHandler.Metrics))
 	mux.Handle("/api/v2/metrics/targets/", paidProduct(metricsHandler.Metrics))
+	mux.Handle("/api/v2/okr/objectives", paidProduct(metricsHandler.OKR))
+	mux.Handle("/api/v2/okr/objectives/", paidProduct(metricsHandler.OKR))
+	mux.Handle("/api/v2/okr/key-results/", paidProduct(metricsHandler.OKR))
+	mux.Handle("/api/v2/okr/export", paidProduct(metricsHandler.OKR))
 	mux.Handle("/api/v2/audio/transcriptions", onboardingOrPaid(audioHandler.Transcriptions))
 	mux.Handle("/api/v2/ai-actions", paidProduct(aiActionsHandler.Actions))
 	mux.Handle("/api/v2/ai-actions/", paidProduct(aiActionsHandler.Actions))
-	mux.Handle("/api/v2/ai/prompts", v2api.RequireAuth(database, jwtSecret, aiPlatformHandler.Prompts))
-	mux.Handle("/api/v2/ai/prompts/", v2api.RequireAuth(database, jwtSecret, aiPlatformHandler.Prompts))
-	mux.Handle("/api/v2/ai/usage-policy", v2api.RequireAuth(database, jwtSecret, aiPlatformHandler.UsagePolicy))
-	mux.Handle("/api/v2/operations/overview", v2api.RequireAuth(database, jwtSecret, operationsHandler.Overview))
-	mux.Handle("/api/v2/operations/warnings", v2api.RequireAuth(database, jwtSecret, operationsHandler.Warnings))
+	mux.Handle("/api/v2/ai/prompts", v2api.RequireAuth(database, tokenKeys, aiPlatformHandler.Prompts))
+	mux.Handle("/api/v2/ai/prompts/", v2api.RequireAuth(database, tokenKeys, aiPlatformHandler.Prompts))
+	mux.Handle("/api/v2/ai/usage-policy", v2api.RequireAuth(database, tokenKeys, aiPlatformHandler.UsagePolicy))
+	mux.Handle("/api/v2/operations/overview", v2api.RequireAuth(database, tokenKeys, operationsHandler.Overview))
+	mux.Handle("/api/v2/operations/warnings", v2api.RequireAuth(database, tokenKeys, operationsHandler.Warnings))
 	mux.Handle("/api/v2/strategic-director/messages", onboardingOrPaidAI(strategicMemoryHandler.StrategicDirector))
 	mux.Handle("/api/v2/strategic-director/state", onboardingOrPaidAI(strategicMemoryHandler.StrategicDirector))
 	mux.Handle("/api/v2/strategic-director/confirm", onboardingOrPaidAI(strategicMemoryHandler.StrategicDirector))
@@ -290,6 +383,7 @@ func main() {
 	mux.Handle("/api/v2/strategy-research-requests", paidProduct(strategyHandler.ResearchRequests))
 	mux.Handle("/api/v2/strategy-research-requests/", paidProduct(strategyHandler.ResearchRequests))
 	mux.Handle("/api/v2/strategy-versions", paidProduct(strategyHandler.Versions))
+	mux.Handle("/api/v2/strategy-versions/", paidProduct(strategyHandler.Versions))
 	mux.Handle("/api/v2/strategy/artifacts/", paidProduct(strategyHandler.Artifacts))
 	mux.Handle("/api/v2/strategy/documents/", paidProduct(strategyHandler.SynthesisDocuments))
 	mux.Handle("/api/v2/strategy/", paidProduct(strategyHandler.Strategy))
@@ -299,6 +393,8 @@ func main() {
 	mux.Handle("/api/v2/strategy-facilitator/synthesis", paidAIChat(strategyHandler.Facilitator))
 	mux.Handle("/api/v2/strategy-facilitator/readiness", paidAIChat(strategyHandler.Facilitator))
 	mux.Handle("/api/v2/course/current", paidProduct(courseHandler.Current))
+	mux.Handle("/api/v2/course/portfolio", paidProduct(courseHandler.Portfolio))
+	mux.Handle("/api/v2/courses", paidProduct(courseHandler.Courses))
 	mux.Handle("/api/v2/course/", paidProduct(courseHandler.Course))
 	mux.Handle("/api/v2/tactics/current", paidProduct(tacticsHandler.Current))
 	mux.Handle("/api/v2/tactics-facilitator/state", paidAIChat(tacticsHandler.Facilitator))
@@ -0,0 +1,41 @@
+// Command payments-sandbox serves the CloudPayments and YooKassa sandbox for
+// local development. Run the API with CLOUDPAYMENTS_BASE_URL and
+// YOOKASSA_BASE_URL (…/v3) pointing here and the same credentials.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/subscriptions/sandbox"
+)
+
+func main() {
+	addr := env("SANDBOX_ADDR", ":8090")
+	publicURL := env("SANDBOX_PUBLIC_URL", "http://localhost"+addr)
+	if !strings.HasPrefix(addr, ":") {
+		publicURL = env("SANDBOX_PUBLIC_URL", "http://"+addr)
+	}
+	server := sandbox.New(sandbox.Config{
+		CallbackURL:         env("SANDBOX_CALLBACK_URL", "http://localhost:8080"),
+		PublicURL:           publicURL,
+		CloudPaymentsSecret: env("CLOUDPAYMENTS_API_SECRET", "sandbox-secret"),
+		YooKassaShopID:      env("YOOKASSA_SHOP_ID", "sandbox-shop"),
+		YooKassaSecretKey:   env("YOOKASSA_SECRET_KEY", "sandbox-secret"),
+	})
+	httpServer := &http.Server{Addr: addr, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("PAYMENTS SANDBOX RUNNING ON %s", addr)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func env(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
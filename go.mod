@@ -8,4 +8,4 @@ require (
 	github.com/rs/cors v1.11.1
 )
 
-require github.com/go-pdf/fpdf v0.9.0 // indirect
+require github.com/go-pdf/fpdf v0.9.0
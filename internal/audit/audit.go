@@ -0,0 +1,171 @@
+// Package audit keeps the security audit trail of account and workspace
+// administration events. Every workspace has its own hash chain, and events
+// without a workspace share one more chain: each entry stores the hash of
+// the one before it, so an edited or removed row breaks verification.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/legal"
+	"reup-goals-backend/internal/security"
+)
+
+const (
+	ActionLoginSucceeded             = "auth.login_succeeded"
+	ActionLoginFailed                = "auth.login_failed"
+	ActionAccountLocked              = "auth.account_locked"
+	ActionSignInRejected             = "auth.sign_in_rejected"
+	ActionSigningKeyRotated          = "auth.signing_key_rotated"
+	ActionPasswordChanged            = "auth.password_changed"
+	ActionPasswordReset              = "auth.password_reset"
+	ActionMemberInvited              = "workspace.member_invited"
+	ActionInvitationCancelled        = "workspace.invitation_cancelled"
+	ActionMemberRoleChanged          = "workspace.member_role_changed"
+	ActionMemberRemoved              = "workspace.member_removed"
+	ActionWorkspaceDeleted           = "workspace.deleted"
+	ActionBillingOrganizationUpdated = "billing.organization_updated"
+	ActionBillingPlanChanged         = "billing.plan_changed"
+	ActionBillingReferralAttributed  = "billing.referral_attributed"
+	ActionPromptActivated            = "ai.prompt_activated"
+	ActionPromptRolledBack           = "ai.prompt_rolled_back"
+	ActionStrategyActivated          = "strategy.activated"
+)
+
+// Event is what callers record. Before and After are short JSON-encodable
+// summaries of the target, not full copies of it.
+type Event struct {
+	WorkspaceID *int
+	ActorUserID *int
+	ActorEmail  string
+	Action      string
+	TargetType  string
+	TargetID    string
+	Before      any
+	After       any
+	RequestID   string
+	IPAddress   string
+}
+
+// WithRequest fills the request ID and client IP from r.
+func (e Event) WithRequest(r *http.Request) Event {
+	e.RequestID = legal.SanitizeRequestID(r.Header.Get("X-Request-ID"))
+	e.IPAddress = security.ClientIP(r)
+	return e
+}
+
+// Entry is a stored event. Before and After hold the summaries exactly as
+// they were hashed.
+type Entry struct {
+	ID          int64           `json:"id"`
+	WorkspaceID *int            `json:"workspace_id,omitempty"`
+	Sequence    int64           `json:"sequence"`
+	ActorUserID *int            `json:"actor_user_id,omitempty"`
+	ActorEmail  string          `json:"actor_email"`
+	Action      string          `json:"action"`
+	TargetType  string          `json:"target_type"`
+	TargetID    string          `json:"target_id"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	RequestID   string          `json:"request_id"`
+	IPAddress   string          `json:"ip_address"`
+	CreatedAt   time.Time       `json:"created_at"`
+	PrevHash    string          `json:"prev_hash"`
+	Hash        string          `json:"hash"`
+}
+
+// Verification is the result of walking a chain. The first entry still
+// stored anchors the chain, because retention removes the oldest ones.
+type Verification struct {
+	Valid          bool   `json:"valid"`
+	Checked        int    `json:"checked"`
+	FirstSequence  int64  `json:"first_sequence,omitempty"`
+	LastSequence   int64  `json:"last_sequence,omitempty"`
+	BrokenSequence *int64 `json:"broken_sequence,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// entryHash covers every stored field except the id and the hash itself.
+func entryHash(entry Entry) string {
+	payload, _ := json.Marshal([]any{
+		entry.PrevHash, entry.WorkspaceID, entry.Sequence, entry.ActorUserID, entry.ActorEmail,
+		entry.Action, entry.TargetType, entry.TargetID, string(entry.Before), string(entry.After),
+		entry.RequestID, entry.IPAddress, entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainVerifier checks entries one at a time in sequence order, so a long
+// chain can be verified while streaming rows.
+type chainVerifier struct {
+	result   Verification
+	previous *Entry
+}
+
+func (v *chainVerifier) add(entry Entry) bool {
+	reason := ""
+	switch {
+	case entryHash(entry) != entry.Hash:
+		reason = "hash_mismatch"
+	case v.previous != nil && entry.Sequence != v.previous.Sequence+1:
+		reason = "sequence_gap"
+	case v.previous != nil && entry.PrevHash != v.previous.Hash:
+		reason = "prev_hash_mismatch"
+	case v.previous == nil && entry.Sequence == 1 && entry.PrevHash != "":
+		reason = "prev_hash_mismatch"
+	}
+	if reason != "" {
+		sequence := entry.Sequence
+		v.result.BrokenSequence = &sequence
+		v.result.Reason = reason
+		return false
+	}
+	if v.previous == nil {
+		v.result.FirstSequence = entry.Sequence
+	}
+	v.result.Checked++
+	v.result.LastSequence = entry.Sequence
+	v.previous = &entry
+	return true
+}
+
+func (v *chainVerifier) finish() Verification {
+	v.result.Valid = v.result.BrokenSequence == nil
+	return v.result
+}
+
+// VerifyChain checks entries of one chain ordered by sequence.
+func VerifyChain(entries []Entry) Verification {
+	verifier := chainVerifier{}
+	for _, entry := range entries {
+		if !verifier.add(entry) {
+			break
+		}
+	}
+	return verifier.finish()
+}
+
+func encodeSummary(value any) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil || string(encoded) == "null" {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+func normalizeEvent(event Event) Event {
+	event.Action = strings.TrimSpace(event.Action)
+	event.ActorEmail = strings.ToLower(strings.TrimSpace(event.ActorEmail))
+	event.TargetType = strings.TrimSpace(event.TargetType)
+	event.TargetID = strings.TrimSpace(event.TargetID)
+	return event
+}
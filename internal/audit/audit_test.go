@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func buildChain(t *testing.T, count int) []Entry {
+	t.Helper()
+	workspaceID, actorID := 7, 3
+	created := time.Date(2026, 8, 23, 10, 0, 0, 123456000, time.UTC)
+	entries := []Entry{}
+	previous := ""
+	for index := 1; index <= count; index++ {
+		after, err := encodeSummary(map[string]any{"role": "admin", "index": index})
+		if err != nil {
+			t.Fatalf("encodeSummary: %v", err)
+		}
+		entry := Entry{
+			ID: int64(100 + index), WorkspaceID: &workspaceID, Sequence: int64(index), ActorUserID: &actorID,
+			ActorEmail: "owner@example.com", Action: ActionMemberRoleChanged, TargetType: "membership",
+			TargetID: "12", After: after, RequestID: "req-1", IPAddress: "203.0.113.9",
+			CreatedAt: created.Add(time.Duration(index) * time.Second), PrevHash: previous,
+		}
+		entry.Hash = entryHash(entry)
+		previous = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestVerifyChain(t *testing.T) {
+	entries := buildChain(t, 4)
+	if result := VerifyChain(entries); !result.Valid || result.Checked != 4 || result.FirstSequence != 1 || result.LastSequence != 4 {
+		t.Fatalf("intact chain = %+v", result)
+	}
+	// Retention removes the oldest entries; the first remaining one anchors.
+	if result := VerifyChain(entries[2:]); !result.Valid || result.FirstSequence != 3 {
+		t.Fatalf("trimmed chain = %+v", result)
+	}
+	if result := VerifyChain(nil); !result.Valid || result.Checked != 0 {
+		t.Fatalf("empty chain = %+v", result)
+	}
+
+	edited := append([]Entry{}, entries...)
+	edited[1].After = []byte(`{"index":2,"role":"member"}`)
+	if result := VerifyChain(edited); result.Valid || *result.BrokenSequence != 2 || result.Reason != "hash_mismatch" {
+		t.Fatalf("edited chain = %+v", result)
+	}
+
+	removed := append(append([]Entry{}, entries[:1]...), entries[2:]...)
+	if result := VerifyChain(removed); result.Valid || *result.BrokenSequence != 3 || result.Reason != "sequence_gap" {
+		t.Fatalf("chain with a removed entry = %+v", result)
+	}
+
+	// A rewritten tail with recomputed hashes still fails on the link.
+	rewritten := append([]Entry{}, entries...)
+	rewritten[2].ActorEmail = "someone@example.com"
+	rewritten[2].Hash = entryHash(rewritten[2])
+	if result := VerifyChain(rewritten); result.Valid || *result.BrokenSequence != 4 || result.Reason != "prev_hash_mismatch" {
+		t.Fatalf("rewritten chain = %+v", result)
+	}
+}
+
+func TestEntryHashIgnoresTimeZone(t *testing.T) {
+	entry := buildChain(t, 1)[0]
+	moscow := entry
+	moscow.CreatedAt = entry.CreatedAt.In(time.FixedZone("MSK", 3*60*60))
+	if entryHash(moscow) != entry.Hash {
+		t.Fatalf("hash depends on the time zone")
+	}
+}
+
+func TestEncodeSummary(t *testing.T) {
+	var missing *struct{ Name string }
+	for _, value := range []any{nil, missing} {
+		if encoded, err := encodeSummary(value); err != nil || encoded != nil {
+			t.Fatalf("encodeSummary(%v) = %s, %v", value, encoded, err)
+		}
+	}
+	encoded, err := encodeSummary(map[string]any{"b": 1, "a": "x"})
+	if err != nil || string(encoded) != `{"a":"x","b":1}` {
+		t.Fatalf("encodeSummary = %s, %v", encoded, err)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter(url.Values{
+		"action": {" auth.login_failed "}, "actor_user_id": {"5"}, "from": {"2026-08-01"},
+		"to": {"2026-08-31"}, "before_id": {"900"}, "limit": {"5000"},
+	})
+	if err != nil {
+		t.Fatalf("ParseFilter err = %v", err)
+	}
+	if filter.Action != ActionLoginFailed || *filter.ActorUserID != 5 || filter.BeforeID != 900 || filter.Limit != maxPageSize {
+		t.Fatalf("filter = %+v", filter)
+	}
+	if !filter.From.Equal(time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)) || !filter.To.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("range = %v - %v", filter.From, filter.To)
+	}
+	if filter, err := ParseFilter(url.Values{"to": {"2026-08-31T12:00:00Z"}}); err != nil || filter.To.Hour() != 12 || filter.Limit != defaultPageSize {
+		t.Fatalf("timestamp bound = %+v, %v", filter, err)
+	}
+	for _, query := range []url.Values{
+		{"actor_user_id": {"abc"}}, {"from": {"yesterday"}}, {"before_id": {"-1"}}, {"limit": {"0"}},
+	} {
+		if _, err := ParseFilter(query); !errors.Is(err, ErrInvalidFilter) {
+			t.Fatalf("ParseFilter(%v) err = %v", query, err)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	entries := buildChain(t, 2)
+	var output bytes.Buffer
+	if err := WriteCSV(&output, entries); err != nil {
+		t.Fatalf("WriteCSV err = %v", err)
+	}
+	rows, err := csv.NewReader(&output).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(rows) != 3 || len(rows[0]) != len(csvHeader) {
+		t.Fatalf("rows = %v", rows)
+	}
+	if rows[2][1] != "2" || rows[2][3] != "3" || rows[2][9] != `{"index":2,"role":"admin"}` ||
+		rows[2][12] != entries[0].Hash || rows[2][13] != entries[1].Hash {
+		t.Fatalf("second row = %v", rows[2])
+	}
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid_audit_filter")
+
+var csvHeader = []string{
+	"id", "sequence", "created_at", "actor_user_id", "actor_email", "action", "target_type", "target_id",
+	"before", "after", "request_id", "ip_address", "prev_hash", "hash",
+}
+
+// ParseFilter reads action, actor_user_id, from, to, before_id and limit
+// from a query string.
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{Action: strings.TrimSpace(query.Get("action"))}
+	if len(filter.Action) > 120 {
+		return Filter{}, ErrInvalidFilter
+	}
+	if raw := strings.TrimSpace(query.Get("actor_user_id")); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			return Filter{}, ErrInvalidFilter
+		}
+		filter.ActorUserID = &value
+	}
+	var err error
+	if filter.From, err = parseBound(query.Get("from"), false); err != nil {
+		return Filter{}, err
+	}
+	if filter.To, err = parseBound(query.Get("to"), true); err != nil {
+		return Filter{}, err
+	}
+	if raw := strings.TrimSpace(query.Get("before_id")); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value <= 0 {
+			return Filter{}, ErrInvalidFilter
+		}
+		filter.BeforeID = value
+	}
+	filter.Limit = defaultPageSize
+	if raw := strings.TrimSpace(query.Get("limit")); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			return Filter{}, ErrInvalidFilter
+		}
+		filter.Limit = min(value, maxPageSize)
+	}
+	return filter, nil
+}
+
+// parseBound parses a range bound; a bare date as the upper bound includes
+// that whole day.
+func parseBound(raw string, upper bool) (*time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if value, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &value, nil
+	}
+	value, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, ErrInvalidFilter
+	}
+	if upper {
+		value = value.AddDate(0, 0, 1)
+	}
+	return &value, nil
+}
+
+// WriteCSV writes one row per entry with the hashes, so an exported file can
+// be checked against the chain later.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writer.Write([]string{
+			strconv.FormatInt(entry.ID, 10), strconv.FormatInt(entry.Sequence, 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339Nano), formatOptionalID(entry.ActorUserID),
+			entry.ActorEmail, entry.Action, entry.TargetType, entry.TargetID,
+			string(entry.Before), string(entry.After), entry.RequestID, entry.IPAddress,
+			entry.PrevHash, entry.Hash,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatOptionalID(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	chainLockNamespace = 528105222
+
+	defaultPageSize = 100
+	maxPageSize     = 500
+	MaxExportRows   = 10000
+)
+
+var ErrInvalidEvent = errors.New("invalid_audit_event")
+
+type Store struct {
+	dbx *sql.DB
+}
+
+func NewStore(dbx *sql.DB) *Store {
+	return &Store{dbx: dbx}
+}
+
+// Filter narrows a workspace's events. BeforeID pages backwards from the
+// newest entry.
+type Filter struct {
+	Action      string
+	ActorUserID *int
+	From        *time.Time
+	To          *time.Time
+	BeforeID    int64
+	Limit       int
+}
+
+// Record appends the event in its own transaction.
+func (s *Store) Record(ctx context.Context, event Event) (Entry, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer tx.Rollback()
+	entry, err := RecordTx(ctx, tx, event)
+	if err != nil {
+		return Entry{}, err
+	}
+	return entry, tx.Commit()
+}
+
+// Log records the event and only logs a failure, for call sites where the
+// audited change has already been committed.
+func (s *Store) Log(ctx context.Context, event Event) {
+	if _, err := s.Record(ctx, event); err != nil {
+		log.Printf("[WARN] audit event %s not recorded: %v", event.Action, err)
+	}
+}
+
+// RecordTx appends the event inside tx, so it commits or rolls back together
+// with the change it describes. Appends to one chain are serialised by a
+// transaction-level advisory lock.
+func RecordTx(ctx context.Context, tx *sql.Tx, event Event) (Entry, error) {
+	event = normalizeEvent(event)
+	if event.Action == "" {
+		return Entry{}, ErrInvalidEvent
+	}
+	scope := 0
+	if event.WorkspaceID != nil {
+		scope = *event.WorkspaceID
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::int, $2::int)`, chainLockNamespace, scope); err != nil {
+		return Entry{}, err
+	}
+
+	// Timestamps never go backwards within a chain, so retention by age
+	// always removes a prefix of it.
+	entry := Entry{
+		WorkspaceID: event.WorkspaceID, ActorUserID: event.ActorUserID, ActorEmail: event.ActorEmail,
+		Action: event.Action, TargetType: event.TargetType, TargetID: event.TargetID,
+		RequestID: event.RequestID, IPAddress: event.IPAddress,
+		Sequence: 1, CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	var lastSequence int64
+	var lastHash string
+	var lastCreatedAt time.Time
+	err := tx.QueryRowContext(ctx, `
+		SELECT sequence, hash, created_at FROM audit_events
+		WHERE COALESCE(workspace_id, 0)=$1
+		ORDER BY sequence DESC
+		LIMIT 1
+	`, scope).Scan(&lastSequence, &lastHash, &lastCreatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, err
+	}
+	if err == nil {
+		entry.Sequence = lastSequence + 1
+		entry.PrevHash = lastHash
+		if lastCreatedAt.After(entry.CreatedAt) {
+			entry.CreatedAt = lastCreatedAt.UTC()
+		}
+	}
+	if entry.ActorEmail == "" && entry.ActorUserID != nil {
+		if err := tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id=$1`, *entry.ActorUserID).Scan(&entry.ActorEmail); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return Entry{}, err
+		}
+	}
+	if entry.Before, err = encodeSummary(event.Before); err != nil {
+		return Entry{}, err
+	}
+	if entry.After, err = encodeSummary(event.After); err != nil {
+		return Entry{}, err
+	}
+	entry.Hash = entryHash(entry)
+
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO audit_events (
+			workspace_id, sequence, actor_user_id, actor_email, action, target_type, target_id,
+			before_summary, after_summary, request_id, ip_address, created_at, prev_hash, hash
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+		RETURNING id
+	`, entry.WorkspaceID, entry.Sequence, entry.ActorUserID, entry.ActorEmail, entry.Action,
+		entry.TargetType, entry.TargetID, string(entry.Before), string(entry.After),
+		entry.RequestID, entry.IPAddress, entry.CreatedAt, entry.PrevHash, entry.Hash,
+	).Scan(&entry.ID); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// Events returns a workspace's events, newest first.
+func (s *Store) Events(ctx context.Context, workspaceID int, filter Filter) ([]Entry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultPageSize
+	}
+	if filter.Limit > MaxExportRows {
+		filter.Limit = MaxExportRows
+	}
+	var beforeID any
+	if filter.BeforeID > 0 {
+		beforeID = filter.BeforeID
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT `+entryColumns+`
+		FROM audit_events
+		WHERE workspace_id=$1
+			AND ($2='' OR action=$2)
+			AND ($3::integer IS NULL OR actor_user_id=$3)
+			AND ($4::timestamptz IS NULL OR created_at >= $4)
+			AND ($5::timestamptz IS NULL OR created_at < $5)
+			AND ($6::bigint IS NULL OR id < $6)
+		ORDER BY id DESC
+		LIMIT $7
+	`, workspaceID, filter.Action, filter.ActorUserID, filter.From, filter.To, beforeID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Entry{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, entry)
+	}
+	return items, rows.Err()
+}
+
+// Verify walks the workspace's whole chain.
+func (s *Store) Verify(ctx context.Context, workspaceID int) (Verification, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT `+entryColumns+`
+		FROM audit_events
+		WHERE workspace_id=$1
+		ORDER BY sequence
+	`, workspaceID)
+	if err != nil {
+		return Verification{}, err
+	}
+	defer rows.Close()
+	verifier := chainVerifier{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return Verification{}, err
+		}
+		if !verifier.add(entry) {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return Verification{}, err
+	}
+	return verifier.finish(), nil
+}
+
+const entryColumns = `id, workspace_id, sequence, actor_user_id, actor_email, action, target_type, target_id,
+			before_summary, after_summary, request_id, ip_address, created_at, prev_hash, hash`
+
+type rowScanner interface {
+	Scan(...any) error
+}
+
+func scanEntry(row rowScanner) (Entry, error) {
+	var entry Entry
+	var workspaceID, actorUserID sql.NullInt64
+	var before, after string
+	if err := row.Scan(
+		&entry.ID, &workspaceID, &entry.Sequence, &actorUserID, &entry.ActorEmail, &entry.Action,
+		&entry.TargetType, &entry.TargetID, &before, &after, &entry.RequestID, &entry.IPAddress,
+		&entry.CreatedAt, &entry.PrevHash, &entry.Hash,
+	); err != nil {
+		return Entry{}, err
+	}
+	if workspaceID.Valid {
+		value := int(workspaceID.Int64)
+		entry.WorkspaceID = &value
+	}
+	if actorUserID.Valid {
+		value := int(actorUserID.Int64)
+		entry.ActorUserID = &value
+	}
+	if before != "" {
+		entry.Before = []byte(before)
+	}
+	if after != "" {
+		entry.After = []byte(after)
+	}
+	entry.CreatedAt = entry.CreatedAt.UTC()
+	return entry, nil
+}
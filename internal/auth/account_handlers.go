@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
@@ -11,18 +12,28 @@ type AccountDataCleaner interface {
 	CleanupUserData(context.Context, int) error
 }
 
-func LogoutHandler(_ *sql.DB, secureCookie bool) http.HandlerFunc {
+// LogoutHandler signs out the current device only; other sessions stay
+// active until they are revoked from the profile.
+func LogoutHandler(dbx *sql.DB, secureCookie bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		_, ok := UserIDFromContext(r.Context())
+		uid, ok := UserIDFromContext(r.Context())
 		if !ok {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if sessionID, ok := SessionIDFromContext(r.Context()); ok {
+			err := revokeSession(r.Context(), dbx, uid, sessionID, sessionRevokedLogout)
+			if err != nil && !errors.Is(err, ErrSessionNotFound) {
+				http.Error(w, "logout_failed", http.StatusInternalServerError)
+				return
+			}
+		}
 		ClearSessionCookie(w, secureCookie)
+		clearRefreshCookie(w, secureCookie)
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
 			"ok": true,
@@ -41,6 +52,13 @@ func DeleteAccountHandler(dbx *sql.DB, cleaners ...AccountDataCleaner) http.Hand
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if err := RequireRecentAuthentication(r.Context(), dbx, uid); errors.Is(err, ErrReauthenticationRequired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, "reauthentication_check_failed", http.StatusInternalServerError)
+			return
+		}
 		for _, cleaner := range cleaners {
 			if cleaner == nil {
 				continue
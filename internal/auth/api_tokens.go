@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	APITokenPersonal  = "personal"
+	APITokenWorkspace = "workspace"
+
+	personalTokenPrefix  = "rgp_"
+	workspaceTokenPrefix = "rgw_"
+	maxAPITokensPerOwner = 50
+)
+
+// APIScopes lists every scope a token can carry. Each family maps to a group
+// of /api/v2 routes, except scim, which guards /scim/v2 provisioning and is
+// only granted to workspace tokens; write access implies read access.
+var APIScopes = []string{
+	"course:read", "course:write",
+	"departments:read", "departments:write",
+	"metrics:read", "metrics:write",
+	"scim:read", "scim:write",
+	"strategy:read", "strategy:write",
+	"tactics:read", "tactics:write",
+	"tasks:read", "tasks:write",
+}
+
+var (
+	ErrAPITokenInvalid      = errors.New("api_token_invalid")
+	ErrAPITokenNotFound     = errors.New("api_token_not_found")
+	ErrAPITokenScopeInvalid = errors.New("api_token_scope_invalid")
+	ErrAPITokenLimitReached = errors.New("api_token_limit_reached")
+)
+
+// APIToken is the stored metadata of a token; the secret itself is only
+// returned once, from CreateAPIToken.
+type APIToken struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	WorkspaceID *int       `json:"workspace_id,omitempty"`
+	CreatedBy   int        `json:"created_by"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type APITokenInput struct {
+	Name      string     `json:"name"`
+	Kind      string     `json:"kind"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APITokenPrincipal is who a valid token acts as.
+type APITokenPrincipal struct {
+	TokenID     int64
+	UserID      int
+	WorkspaceID *int
+	Scopes      []string
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix) || strings.HasPrefix(token, workspaceTokenPrefix)
+}
+
+// NormalizeAPIScopes validates, de-duplicates and sorts requested scopes.
+func NormalizeAPIScopes(scopes []string) ([]string, error) {
+	known := make(map[string]bool, len(APIScopes))
+	for _, scope := range APIScopes {
+		known[scope] = true
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !known[scope] {
+			return nil, ErrAPITokenScopeInvalid
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrAPITokenScopeInvalid
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// APIScopeAllows reports whether granted scopes cover the required one.
+func APIScopeAllows(granted []string, required string) bool {
+	family, access, ok := strings.Cut(required, ":")
+	if !ok {
+		return false
+	}
+	for _, scope := range granted {
+		if scope == required || (access == "read" && scope == family+":write") {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken issues a personal token for userID, or a workspace token for
+// workspaceID when input.Kind is workspace. The caller checks that the user
+// may manage the workspace.
+func CreateAPIToken(ctx context.Context, dbx *sql.DB, userID int, workspaceID int, input APITokenInput) (APIToken, string, error) {
+	scopes, err := NormalizeAPIScopes(input.Scopes)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	prefix := personalTokenPrefix
+	var tokenWorkspace any
+	if input.Kind != APITokenWorkspace {
+		for _, scope := range scopes {
+			if strings.HasPrefix(scope, "scim:") {
+				return APIToken{}, "", ErrAPITokenScopeInvalid
+			}
+		}
+	} else {
+		prefix = workspaceTokenPrefix
+		tokenWorkspace = workspaceID
+	}
+	var active int
+	if err := dbx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM api_tokens
+		WHERE revoked_at IS NULL AND (
+			(kind='personal' AND user_id=$1 AND $2::integer IS NULL)
+			OR (kind='workspace' AND workspace_id=$2::integer)
+		)
+	`, userID, tokenWorkspace).Scan(&active); err != nil {
+		return APIToken{}, "", err
+	}
+	if active >= maxAPITokensPerOwner {
+		return APIToken{}, "", ErrAPITokenLimitReached
+	}
+
+	random, err := randomToken()
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	secret := prefix + random
+	row := dbx.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (kind, user_id, workspace_id, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, kind, name, token_prefix, scopes, workspace_id, user_id, expires_at,
+			last_used_at, last_used_ip, created_at
+	`, input.Kind, userID, tokenWorkspace, input.Name, secret[:len(prefix)+6], hashSecretToken(secret),
+		pq.Array(scopes), input.ExpiresAt)
+	token, err := scanAPIToken(row)
+	if err != nil {
+		return APIToken{}, "", err
+	}
+	return token, secret, nil
+}
+
+// ListAPITokens returns the user's personal tokens and, for workspace
+// managers, the workspace's tokens.
+func ListAPITokens(ctx context.Context, dbx *sql.DB, userID int, workspaceID int, manageWorkspace bool) ([]APIToken, error) {
+	rows, err := dbx.QueryContext(ctx, `
+		SELECT id, kind, name, token_prefix, scopes, workspace_id, user_id, expires_at,
+			last_used_at, last_used_ip, created_at
+		FROM api_tokens
+		WHERE revoked_at IS NULL AND (
+			(kind='personal' AND user_id=$1)
+			OR (kind='workspace' AND workspace_id=$2 AND $3)
+		)
+		ORDER BY created_at DESC, id DESC
+	`, userID, workspaceID, manageWorkspace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func RevokeAPIToken(ctx context.Context, dbx *sql.DB, userID int, workspaceID int, manageWorkspace bool, tokenID int64) error {
+	result, err := dbx.ExecContext(ctx, `
+		UPDATE api_tokens
+		SET revoked_at=NOW()
+		WHERE id=$1 AND revoked_at IS NULL AND (
+			(kind='personal' AND user_id=$2)
+			OR (kind='workspace' AND workspace_id=$3 AND $4)
+		)
+	`, tokenID, userID, workspaceID, manageWorkspace)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIToken resolves a bearer API token and records its use at
+// most once a minute. Workspace tokens act as their creator in their own
+// workspace and stop working once the creator is no longer an active owner
+// or admin there, or the workspace is no longer active.
+func AuthenticateAPIToken(ctx context.Context, dbx *sql.DB, token string, ip string) (APITokenPrincipal, error) {
+	if dbx == nil || !IsAPIToken(token) {
+		return APITokenPrincipal{}, ErrAPITokenInvalid
+	}
+	var principal APITokenPrincipal
+	var workspaceID sql.NullInt64
+	err := dbx.QueryRowContext(ctx, `
+		WITH valid AS (
+			SELECT token.id, token.user_id, token.workspace_id, token.scopes, token.last_used_at
+			FROM api_tokens token
+			JOIN users ON users.id=token.user_id AND users.email_verified
+			WHERE token.token_hash=$1
+				AND token.revoked_at IS NULL
+				AND (token.expires_at IS NULL OR token.expires_at > NOW())
+				AND (
+					token.kind='personal'
+					OR EXISTS (
+						SELECT 1
+						FROM workspace_memberships membership
+						JOIN workspaces workspace ON workspace.id=membership.workspace_id
+						WHERE membership.workspace_id=token.workspace_id
+							AND membership.user_id=token.user_id
+							AND membership.status='active'
+							AND membership.role IN ('owner', 'admin')
+							AND workspace.status='active'
+					)
+				)
+		), touched AS (
+			UPDATE api_tokens
+			SET last_used_at=NOW(), last_used_ip=$2
+			FROM valid
+			WHERE api_tokens.id=valid.id
+				AND (valid.last_used_at IS NULL OR valid.last_used_at < NOW() - INTERVAL '1 minute')
+			RETURNING api_tokens.id
+		)
+		SELECT id, user_id, workspace_id, scopes FROM valid
+	`, hashSecretToken(token), ip).Scan(&principal.TokenID, &principal.UserID, &workspaceID, pq.Array(&principal.Scopes))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APITokenPrincipal{}, ErrAPITokenInvalid
+	}
+	if err != nil {
+		return APITokenPrincipal{}, err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		principal.WorkspaceID = &id
+	}
+	return principal, nil
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var token APIToken
+	var workspaceID sql.NullInt64
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&token.ID, &token.Kind, &token.Name, &token.Prefix, pq.Array(&token.Scopes), &workspaceID,
+		&token.CreatedBy, &expiresAt, &lastUsedAt, &token.LastUsedIP, &token.CreatedAt,
+	)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		token.WorkspaceID = &id
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, nil
+}
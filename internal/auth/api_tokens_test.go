@@ -0,0 +1,39 @@
+package auth
+
+import "testing"
+
+func TestNormalizeAPIScopes(t *testing.T) {
+	scopes, err := NormalizeAPIScopes([]string{" Tasks:Write", "metrics:read", "tasks:write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 2 || scopes[0] != "metrics:read" || scopes[1] != "tasks:write" {
+		t.Fatalf("unexpected scopes: %v", scopes)
+	}
+	if _, err := NormalizeAPIScopes([]string{"billing:write"}); err != ErrAPITokenScopeInvalid {
+		t.Fatalf("unknown scope must be rejected, got %v", err)
+	}
+	if _, err := NormalizeAPIScopes(nil); err != ErrAPITokenScopeInvalid {
+		t.Fatalf("empty scope list must be rejected, got %v", err)
+	}
+}
+
+func TestAPIScopeAllowsWriteToImplyRead(t *testing.T) {
+	granted := []string{"tasks:write", "metrics:read"}
+	cases := map[string]bool{
+		"tasks:read":    true,
+		"tasks:write":   true,
+		"metrics:read":  true,
+		"metrics:write": false,
+		"course:read":   false,
+		"tasks":         false,
+	}
+	for required, want := range cases {
+		if got := APIScopeAllows(granted, required); got != want {
+			t.Fatalf("APIScopeAllows(%q) = %v, want %v", required, got, want)
+		}
+	}
+	if !IsAPIToken("rgp_abc") || !IsAPIToken("rgw_abc") || IsAPIToken("eyJhbGciOi") {
+		t.Fatal("unexpected API token detection")
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"reup-goals-backend/internal/audit"
+)
+
+// recordAccountEvent appends a sign-in or credential event to the audit log.
+// Without an explicit workspace it goes to the chain of the user's current
+// workspace, so owners see their members' events; unknown users and users
+// without a workspace land in the shared chain.
+func recordAccountEvent(r *http.Request, dbx *sql.DB, userID int, email string, action string, details map[string]any) {
+	recordWorkspaceAccountEvent(r, dbx, nil, userID, email, action, details)
+}
+
+func recordWorkspaceAccountEvent(
+	r *http.Request,
+	dbx *sql.DB,
+	workspaceID *int,
+	userID int,
+	email string,
+	action string,
+	details map[string]any,
+) {
+	event := audit.Event{
+		WorkspaceID: workspaceID, ActorEmail: email, Action: action, TargetType: "user", After: details,
+	}
+	if userID > 0 {
+		event.ActorUserID = &userID
+		event.TargetID = strconv.Itoa(userID)
+		if workspaceID == nil {
+			var current int
+			if err := dbx.QueryRowContext(r.Context(), `
+				SELECT workspace_id FROM workspace_memberships
+				WHERE user_id=$1 AND status='active'
+				ORDER BY is_default DESC, created_at
+				LIMIT 1
+			`, userID).Scan(&current); err == nil {
+				event.WorkspaceID = &current
+			}
+		}
+	}
+	audit.NewStore(dbx).Log(r.Context(), event.WithRequest(r))
+}
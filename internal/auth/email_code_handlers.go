@@ -13,6 +13,8 @@ import (
 	"net/mail"
 	"strings"
 	"time"
+
+	"reup-goals-backend/internal/audit"
 )
 
 const (
@@ -36,7 +38,7 @@ var (
 	errInvalidResetToken = errors.New("invalid_reset_token")
 )
 
-func VerifyEmailHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAuthOnly bool) http.HandlerFunc {
+func VerifyEmailHandler(dbx *sql.DB, keys *KeySet, secureCookie bool, browserAuthOnly bool, monitor *SignInMonitor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
@@ -109,17 +111,19 @@ func VerifyEmailHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAu
 			writeAPIError(w, "user_not_found", http.StatusNotFound)
 			return
 		}
-		token, err := GenerateToken(secret, userID, authVersion)
+		session, err := issueSession(w, r, dbx, keys, userID, authVersion, secureCookie)
 		if err != nil {
 			writeAPIError(w, "token_generation_failed", http.StatusInternalServerError)
 			return
 		}
-		SetSessionCookie(w, token, secureCookie)
+		monitor.RecordSuccess(w, r, userID, "email_verification")
 		response := map[string]any{
 			"ok": true, "user_id": userID, "workspace_onboarding_mode": onboardingMode,
+			"session_id": session.SessionID,
 		}
 		if shouldExposeToken(r, browserAuthOnly) {
-			response["token"] = token
+			response["token"] = session.Token
+			response["refresh_token"] = session.RefreshToken
 		}
 		writeOK(w, response)
 	}
@@ -341,14 +345,22 @@ func ResetPasswordHandler(dbx *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		result, err := tx.ExecContext(r.Context(), `UPDATE users SET password=$1, auth_version=auth_version+1 WHERE lower(email)=lower($2)`, passwordHash, email)
+		var userID int
+		err = tx.QueryRowContext(r.Context(), `
+			UPDATE users SET password=$1, auth_version=auth_version+1 WHERE lower(email)=lower($2)
+			RETURNING id
+		`, passwordHash, email).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, errInvalidResetToken.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			writeAPIError(w, "db_update_failed", http.StatusInternalServerError)
 			return
 		}
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			writeAPIError(w, errInvalidResetToken.Error(), http.StatusBadRequest)
+		revoked, err := revokeUserSessions(r.Context(), tx, userID, sessionRevokedPasswordReset)
+		if err != nil {
+			writeAPIError(w, "db_update_failed", http.StatusInternalServerError)
 			return
 		}
 
@@ -366,25 +378,43 @@ func ResetPasswordHandler(dbx *sql.DB) http.HandlerFunc {
 			writeAPIError(w, "db_commit_failed", http.StatusInternalServerError)
 			return
 		}
+		forgetSessions(revoked...)
+		recordAccountEvent(r, dbx, userID, email, audit.ActionPasswordReset, map[string]any{"sessions_revoked": true})
 
 		writeOK(w, map[string]any{"ok": true})
 	}
 }
 
 func createAndSendCode(dbx *sql.DB, emailService *EmailService, email string, userID int, codeType string) error {
+	code, codeID, err := storeEmailCode(dbx, email, userID, codeType, "")
+	if err != nil {
+		return err
+	}
+
+	if codeType == codeTypeVerifyEmail {
+		err = emailService.SendVerificationCode(email, code)
+	} else {
+		err = emailService.SendPasswordResetCode(email, code)
+	}
+	return markCodeDelivered(dbx, codeID, err)
+}
+
+// storeEmailCode replaces any pending code of the same type with a fresh
+// one and returns it in clear text for the email.
+func storeEmailCode(dbx *sql.DB, email string, userID int, codeType string, linkTokenHash string) (string, int, error) {
 	code, err := generateCode()
 	if err != nil {
-		return errEmailSendFailed
+		return "", 0, errEmailSendFailed
 	}
 
 	codeHash, err := hashPassword(code)
 	if err != nil {
-		return errEmailSendFailed
+		return "", 0, errEmailSendFailed
 	}
 
 	tx, err := dbx.Begin()
 	if err != nil {
-		return err
+		return "", 0, err
 	}
 	defer tx.Rollback()
 
@@ -395,34 +425,33 @@ func createAndSendCode(dbx *sql.DB, emailService *EmailService, email string, us
 		email,
 		codeType,
 	); err != nil {
-		return err
+		return "", 0, err
 	}
 
 	var codeID int
 	if err := tx.QueryRow(
-		`INSERT INTO auth_email_codes (email, user_id, code_hash, code_type, expires_at, last_sent_at)
-		 VALUES ($1, $2, $3, $4, NOW() + $5::interval, NOW())
+		`INSERT INTO auth_email_codes (email, user_id, code_hash, code_type, expires_at, last_sent_at, link_token_hash)
+		 VALUES ($1, $2, $3, $4, NOW() + $5::interval, NOW(), $6)
 		 RETURNING id`,
 		email,
 		nullableUserID(userID),
 		codeHash,
 		codeType,
 		fmt.Sprintf("%d seconds", int(codeTTL.Seconds())),
+		sql.NullString{String: linkTokenHash, Valid: linkTokenHash != ""},
 	).Scan(&codeID); err != nil {
-		return err
+		return "", 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return err
+		return "", 0, err
 	}
+	return code, codeID, nil
+}
 
-	if codeType == codeTypeVerifyEmail {
-		err = emailService.SendVerificationCode(email, code)
-	} else {
-		err = emailService.SendPasswordResetCode(email, code)
-	}
-	if err != nil {
-		if errors.Is(err, errEmailListUnavailable) {
+func markCodeDelivered(dbx *sql.DB, codeID int, sendErr error) error {
+	if sendErr != nil {
+		if errors.Is(sendErr, errEmailListUnavailable) {
 			return errEmailListUnavailable
 		}
 		return errEmailSendFailed
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"net/url"
@@ -79,6 +80,17 @@ func (s *EmailService) SendPasswordResetCode(email, code string) error {
 	)
 }
 
+func (s *EmailService) SendSignInCode(email, code, link string) error {
+	return s.sendEmail(
+		email,
+		"Вход в REUP.goals",
+		fmt.Sprintf(
+			`<p>Ваш код для входа:</p><p style="font-size:24px;font-weight:700;">%s</p><p>Введите его на сайте REUP.goals или <a href="%s">войдите по ссылке</a>.</p><p>Код и ссылка действуют 15 минут и подходят для одного входа.</p><p>Если вы не пытались войти, просто проигнорируйте это письмо.</p>`,
+			code, html.EscapeString(link),
+		),
+	)
+}
+
 func (s *EmailService) SendServiceEmail(email, subject, body string) error {
 	return s.sendEmail(email, subject, body)
 }
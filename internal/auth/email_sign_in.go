@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/audit"
+)
+
+const (
+	codeTypeEmailSignIn = "email_sign_in"
+	emailSignInMethod   = "email_code"
+)
+
+var ErrEmailSignInDisabled = errors.New("email_sign_in_disabled")
+
+// EmailSignInAllowed reports whether the user may sign in with an emailed
+// code. Any active workspace of theirs can turn it off, and SSO enforcement
+// for their domain rules it out just like a password.
+func EmailSignInAllowed(ctx context.Context, dbx sqlQueryer, userID int, email string) (bool, error) {
+	var denied bool
+	if err := dbx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM workspace_memberships membership
+			JOIN workspaces workspace ON workspace.id=membership.workspace_id
+			WHERE membership.user_id=$1 AND membership.status='active'
+				AND workspace.status='active' AND NOT workspace.allow_email_sign_in
+		)
+	`, userID).Scan(&denied); err != nil || denied {
+		return false, err
+	}
+	ssoRequired, err := SSORequiredForPassword(ctx, dbx, userID, email)
+	return !ssoRequired, err
+}
+
+func SetWorkspaceEmailSignIn(ctx context.Context, dbx *sql.DB, workspaceID int, allowed bool) error {
+	_, err := dbx.ExecContext(ctx, `
+		UPDATE workspaces SET allow_email_sign_in=$2, updated_at=NOW() WHERE id=$1
+	`, workspaceID, allowed)
+	return err
+}
+
+// EmailSignInStartHandler emails a one-time code together with a link that
+// carries a separate single-use token. The answer is the same whether or not
+// the email may sign in this way, so it does not reveal accounts.
+func EmailSignInStartHandler(dbx *sql.DB, emailService *EmailService, frontendURL string) http.HandlerFunc {
+	frontendURL = strings.TrimRight(frontendURL, "/")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, "invalid_json", http.StatusBadRequest)
+			return
+		}
+		email, ok := normalizeAndValidateEmail(body.Email)
+		if !ok {
+			writeAPIError(w, errInvalidEmail.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var userID int
+		if err := dbx.QueryRowContext(r.Context(), `SELECT id FROM users WHERE lower(email)=lower($1)`, email).Scan(&userID); err != nil {
+			writeOK(w, neutralEmailSignInResponse())
+			return
+		}
+		if allowed, err := EmailSignInAllowed(r.Context(), dbx, userID, email); err != nil || !allowed {
+			writeOK(w, neutralEmailSignInResponse())
+			return
+		}
+		if err := enforceCooldown(dbx, email, codeTypeEmailSignIn); err != nil {
+			writeOK(w, neutralEmailSignInResponse())
+			return
+		}
+
+		linkToken, err := randomToken()
+		if err != nil {
+			writeOK(w, neutralEmailSignInResponse())
+			return
+		}
+		code, codeID, err := storeEmailCode(dbx, email, userID, codeTypeEmailSignIn, hashSecretToken(linkToken))
+		if err != nil {
+			writeOK(w, neutralEmailSignInResponse())
+			return
+		}
+		link := frontendURL + "/sign-in/email?token=" + url.QueryEscape(linkToken)
+		_ = markCodeDelivered(dbx, codeID, emailService.SendSignInCode(email, code, link))
+
+		writeOK(w, neutralEmailSignInResponse())
+	}
+}
+
+// EmailSignInHandler signs in with either the email and code or the token
+// from the link. Users with 2FA still get a second-factor challenge.
+func EmailSignInHandler(dbx *sql.DB, keys *KeySet, secureCookie bool, browserAuthOnly bool, monitor *SignInMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Email string `json:"email"`
+			Code  string `json:"code"`
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, "invalid_json", http.StatusBadRequest)
+			return
+		}
+
+		var tx *sql.Tx
+		var codeID, userID int
+		var err error
+		email := ""
+		if token := strings.TrimSpace(body.Token); token != "" {
+			tx, codeID, userID, email, err = beginVerifiedSignInLink(r.Context(), dbx, token)
+		} else {
+			var ok bool
+			email, ok = normalizeAndValidateEmail(body.Email)
+			if !ok {
+				writeAPIError(w, errInvalidEmail.Error(), http.StatusBadRequest)
+				return
+			}
+			retryAfter, checkErr := monitor.CheckLogin(r.Context(), email)
+			if checkErr != nil {
+				writeAPIError(w, "server_error", http.StatusInternalServerError)
+				return
+			}
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				writeAPIError(w, ErrLoginLocked.Error(), http.StatusTooManyRequests)
+				return
+			}
+			tx, codeID, userID, err = beginVerifiedCode(r.Context(), dbx, email, codeTypeEmailSignIn, body.Code)
+			if errors.Is(err, errInvalidCode) {
+				monitor.RecordFailure(r, email, 0)
+			}
+		}
+		if err != nil {
+			writeCodeError(w, err)
+			return
+		}
+		defer tx.Rollback()
+
+		// The workspace setting may have changed after the email was sent.
+		// A refused code is left unused so it still works once allowed.
+		allowed, err := EmailSignInAllowed(r.Context(), tx, userID, email)
+		if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			recordAccountEvent(r, dbx, userID, email, audit.ActionLoginFailed, map[string]any{
+				"method": emailSignInMethod, "reason": ErrEmailSignInDisabled.Error(),
+			})
+			writeAPIError(w, ErrEmailSignInDisabled.Error(), http.StatusForbidden)
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), `
+			UPDATE auth_email_codes SET used_at=NOW(), updated_at=NOW() WHERE id=$1
+		`, codeID); err != nil {
+			writeAPIError(w, "db_update_failed", http.StatusInternalServerError)
+			return
+		}
+		// Reading the email proves the address, as the verification code does.
+		var authVersion int
+		var onboardingMode string
+		if err := tx.QueryRowContext(r.Context(), `
+			UPDATE users SET email_verified=TRUE WHERE id=$1
+			RETURNING auth_version, workspace_onboarding_mode
+		`, userID).Scan(&authVersion, &onboardingMode); err != nil {
+			writeAPIError(w, errInvalidCode.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeAPIError(w, "db_commit_failed", http.StatusInternalServerError)
+			return
+		}
+
+		twoFactor, err := twoFactorEnabled(r.Context(), dbx, userID)
+		if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor {
+			challenge, err := createLoginChallenge(r.Context(), dbx, userID)
+			if err != nil {
+				writeAPIError(w, "server_error", http.StatusInternalServerError)
+				return
+			}
+			writeOK(w, map[string]any{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+				"expires_in":          int(loginChallengeTTL.Seconds()),
+			})
+			return
+		}
+
+		session, err := issueSession(w, r, dbx, keys, userID, authVersion, secureCookie)
+		if err != nil {
+			writeAPIError(w, "token_generation_failed", http.StatusInternalServerError)
+			return
+		}
+		risk := monitor.RecordSuccess(w, r, userID, emailSignInMethod)
+		recordAccountEvent(r, dbx, userID, email, audit.ActionLoginSucceeded, map[string]any{
+			"method": emailSignInMethod, "session_id": session.SessionID, "risk": risk,
+		})
+		response := map[string]any{
+			"user_id": userID, "workspace_onboarding_mode": onboardingMode, "session_id": session.SessionID,
+		}
+		if shouldExposeToken(r, browserAuthOnly) {
+			response["token"] = session.Token
+			response["refresh_token"] = session.RefreshToken
+		}
+		writeOK(w, response)
+	}
+}
+
+// beginVerifiedSignInLink locks the pending sign-in code behind a link token.
+// The token is long and random, so a miss is not counted as an attempt.
+func beginVerifiedSignInLink(ctx context.Context, dbx *sql.DB, token string) (*sql.Tx, int, int, string, error) {
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, 0, "", err
+	}
+	var codeID, userID int
+	var email string
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, email, expires_at
+		FROM auth_email_codes
+		WHERE link_token_hash=$1 AND code_type=$2 AND used_at IS NULL AND user_id IS NOT NULL
+		FOR UPDATE
+	`, hashSecretToken(token), codeTypeEmailSignIn).Scan(&codeID, &userID, &email, &expiresAt)
+	if err != nil {
+		_ = tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, 0, "", errInvalidCode
+		}
+		return nil, 0, 0, "", err
+	}
+	if time.Now().After(expiresAt) {
+		_ = tx.Rollback()
+		return nil, 0, 0, "", errCodeExpired
+	}
+	return tx, codeID, userID, email, nil
+}
+
+func neutralEmailSignInResponse() map[string]any {
+	return map[string]any{
+		"ok":      true,
+		"message": "Если вход по email доступен для этого аккаунта, мы отправили код и ссылку для входа",
+	}
+}
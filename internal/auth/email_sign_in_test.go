@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmailSignInHandlersRejectBadInput(t *testing.T) {
+	start := EmailSignInStartHandler(nil, nil, "https://reupgoals.pro/")
+	signIn := EmailSignInHandler(nil, nil, false, false, nil)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		body    string
+		status  int
+		error   string
+	}{
+		{"start needs POST", start, http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"start needs JSON", start, http.MethodPost, "{", http.StatusBadRequest, "invalid_json"},
+		{"start needs an email", start, http.MethodPost, `{"email":"not an email"}`, http.StatusBadRequest, "invalid_email"},
+		{"sign-in needs POST", signIn, http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{"sign-in without token needs an email", signIn, http.MethodPost, `{"code":"123456"}`, http.StatusBadRequest, "invalid_email"},
+		{"blank token falls back to the email", signIn, http.MethodPost, `{"token":"  ","email":"x"}`, http.StatusBadRequest, "invalid_email"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.handler(recorder, httptest.NewRequest(test.method, "/auth/email-sign-in", strings.NewReader(test.body)))
+			if recorder.Code != test.status || strings.TrimSpace(recorder.Body.String()) != test.error {
+				t.Fatalf("response = %d %q, want %d %q", recorder.Code, recorder.Body.String(), test.status, test.error)
+			}
+		})
+	}
+}
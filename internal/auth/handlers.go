@@ -7,9 +7,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"reup-goals-backend/internal/audit"
 	"reup-goals-backend/internal/legal"
 )
 
@@ -218,7 +220,7 @@ func cleanupFailedRegistration(dbx *sql.DB, userID int, subjectKey string) {
 	_ = tx.Commit()
 }
 
-func LoginHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAuthOnly bool) http.HandlerFunc {
+func LoginHandler(dbx *sql.DB, keys *KeySet, secureCookie bool, browserAuthOnly bool, monitor *SignInMonitor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method_not_allowed", http.StatusMethodNotAllowed)
@@ -249,18 +251,32 @@ func LoginHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAuthOnly
 			http.Error(w, "invalid login", http.StatusUnauthorized)
 			return
 		}
+		retryAfter, err := monitor.CheckLogin(r.Context(), email)
+		if err != nil {
+			http.Error(w, "login failed", http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, ErrLoginLocked.Error(), http.StatusTooManyRequests)
+			return
+		}
 
 		var id int
 		var storedPassword string
 		var authVersion int
 		var emailVerified bool
 		var onboardingMode string
-		err := dbx.QueryRow(`
+		err = dbx.QueryRow(`
 			SELECT id, password, auth_version, email_verified, workspace_onboarding_mode
 			FROM users WHERE email=$1
 		`, email).Scan(&id, &storedPassword, &authVersion, &emailVerified, &onboardingMode)
 
 		if err != nil || !passwordMatches(storedPassword, password) {
+			monitor.RecordFailure(r, email, id)
+			recordAccountEvent(r, dbx, id, email, audit.ActionLoginFailed, map[string]any{
+				"method": "password", "reason": "invalid_credentials",
+			})
 			http.Error(w, "invalid login", http.StatusUnauthorized)
 			return
 		}
@@ -268,6 +284,18 @@ func LoginHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAuthOnly
 			http.Error(w, "email_not_verified", http.StatusForbidden)
 			return
 		}
+		ssoRequired, err := SSORequiredForPassword(r.Context(), dbx, id, email)
+		if err != nil {
+			http.Error(w, "login failed", http.StatusInternalServerError)
+			return
+		}
+		if ssoRequired {
+			recordAccountEvent(r, dbx, id, email, audit.ActionLoginFailed, map[string]any{
+				"method": "password", "reason": ErrSSORequired.Error(),
+			})
+			http.Error(w, ErrSSORequired.Error(), http.StatusForbidden)
+			return
+		}
 
 		if passwordNeedsRehash(storedPassword) {
 			passwordHash, err := hashPassword(password)
@@ -286,17 +314,43 @@ func LoginHandler(dbx *sql.DB, secret []byte, secureCookie bool, browserAuthOnly
 			}
 		}
 
-		token, err := GenerateToken(secret, id, authVersion)
+		twoFactor, err := twoFactorEnabled(r.Context(), dbx, id)
+		if err != nil {
+			http.Error(w, "login failed", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor {
+			challenge, err := createLoginChallenge(r.Context(), dbx, id)
+			if err != nil {
+				http.Error(w, "login failed", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+				"expires_in":          int(loginChallengeTTL.Seconds()),
+			})
+			return
+		}
+
+		session, err := issueSession(w, r, dbx, keys, id, authVersion, secureCookie)
 		if err != nil {
 			http.Error(w, "token generation failed", http.StatusInternalServerError)
 			return
 		}
-		SetSessionCookie(w, token, secureCookie)
+		risk := monitor.RecordSuccess(w, r, id, "password")
+		recordAccountEvent(r, dbx, id, email, audit.ActionLoginSucceeded, map[string]any{
+			"method": "password", "session_id": session.SessionID, "risk": risk,
+		})
 
 		w.Header().Set("Content-Type", "application/json")
-		response := map[string]any{"user_id": id, "workspace_onboarding_mode": onboardingMode}
+		response := map[string]any{
+			"user_id": id, "workspace_onboarding_mode": onboardingMode, "session_id": session.SessionID,
+		}
 		if shouldExposeToken(r, browserAuthOnly) {
-			response["token"] = token
+			response["token"] = session.Token
+			response["refresh_token"] = session.RefreshToken
 		}
 		_ = json.NewEncoder(w).Encode(response)
 	}
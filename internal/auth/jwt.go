@@ -2,7 +2,6 @@ package auth
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -12,7 +11,7 @@ import (
 
 const (
 	SessionCookieName = "reupgoals_session"
-	sessionIssuer     = "reupgoals-api"
+	TokenIssuer       = "reupgoals-api"
 	sessionAudience   = "reupgoals-app"
 	tokenTTL          = 7 * 24 * time.Hour
 )
@@ -20,52 +19,50 @@ const (
 type SessionClaims struct {
 	UserID      int `json:"user_id"`
 	AuthVersion int `json:"auth_version"`
+	// SessionID is empty for tokens issued before server-side sessions.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(secret []byte, userID int, authVersion ...int) (string, error) {
+func GenerateToken(keys *KeySet, userID int, authVersion ...int) (string, error) {
 	version := 1
 	if len(authVersion) > 0 && authVersion[0] > 0 {
 		version = authVersion[0]
 	}
+	return GenerateSessionToken(keys, userID, version, "")
+}
+
+// GenerateSessionToken issues an access token bound to one server-side
+// session, so the session can be revoked without touching other devices.
+func GenerateSessionToken(keys *KeySet, userID int, authVersion int, sessionID string) (string, error) {
 	now := time.Now().UTC()
 	claims := SessionClaims{
-		UserID: userID, AuthVersion: version,
+		UserID: userID, AuthVersion: authVersion, SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer: sessionIssuer, Audience: jwt.ClaimStrings{sessionAudience},
+			Issuer: TokenIssuer, Audience: jwt.ClaimStrings{sessionAudience},
 			IssuedAt: jwt.NewNumericDate(now), NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
 			ExpiresAt: jwt.NewNumericDate(now.Add(tokenTTL)),
 		},
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString(secret)
+	return keys.Sign(claims)
 }
 
-func ParseToken(secret []byte, tokenString string) (int, error) {
-	claims, err := ParseTokenClaims(secret, tokenString)
+func ParseToken(keys *KeySet, tokenString string) (int, error) {
+	claims, err := ParseTokenClaims(keys, tokenString)
 	if err != nil {
 		return 0, err
 	}
 	return claims.UserID, nil
 }
 
-func ParseTokenClaims(secret []byte, tokenString string) (SessionClaims, error) {
-	if len(secret) == 0 {
-		return SessionClaims{}, errors.New("empty jwt secret")
+func ParseTokenClaims(keys *KeySet, tokenString string) (SessionClaims, error) {
+	if keys == nil {
+		return SessionClaims{}, errors.New("no signing keys")
 	}
 	claims := SessionClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
-		if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
-		}
-		return secret, nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(sessionIssuer), jwt.WithAudience(sessionAudience))
-	if err != nil {
+	if err := keys.Parse(tokenString, &claims, sessionAudience); err != nil {
 		return SessionClaims{}, err
 	}
-	if token == nil || !token.Valid {
-		return SessionClaims{}, errors.New("invalid token")
-	}
 	if claims.UserID <= 0 || claims.AuthVersion <= 0 {
 		return SessionClaims{}, errors.New("missing session claims")
 	}
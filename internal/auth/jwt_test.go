@@ -3,17 +3,16 @@ package auth
 import (
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 )
 
 func TestSessionTokenContainsSecurityClaims(t *testing.T) {
-	secret := []byte(strings.Repeat("s", 32))
-	token, err := GenerateToken(secret, 42, 7)
+	keys := testKeySet(t)
+	token, err := GenerateToken(keys, 42, 7)
 	if err != nil {
 		t.Fatalf("generate token: %v", err)
 	}
-	claims, err := ParseTokenClaims(secret, token)
+	claims, err := ParseTokenClaims(keys, token)
 	if err != nil {
 		t.Fatalf("parse token: %v", err)
 	}
@@ -22,6 +21,25 @@ func TestSessionTokenContainsSecurityClaims(t *testing.T) {
 	}
 }
 
+func TestSessionTokenCarriesSessionID(t *testing.T) {
+	keys := testKeySet(t)
+	token, err := GenerateSessionToken(keys, 42, 3, "device-1")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	claims, err := ParseTokenClaims(keys, token)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if claims.SessionID != "device-1" || claims.AuthVersion != 3 {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	legacy, _ := GenerateToken(keys, 42)
+	if claims, err := ParseTokenClaims(keys, legacy); err != nil || claims.SessionID != "" {
+		t.Fatalf("legacy token must stay valid without a session: %+v %v", claims, err)
+	}
+}
+
 func TestTokenFromRequestPrefersBearerAndSupportsCookie(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, "/", nil)
 	request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "cookie-token"})
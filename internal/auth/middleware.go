@@ -10,15 +10,18 @@ import (
 
 type ctxKey string
 
-const userIDKey ctxKey = "user_id"
+const (
+	userIDKey    ctxKey = "user_id"
+	sessionIDKey ctxKey = "session_id"
+)
 
 type Middleware struct {
-	dbx    *sql.DB
-	secret []byte
+	dbx  *sql.DB
+	keys *KeySet
 }
 
-func New(dbx *sql.DB, secret []byte) Middleware {
-	return Middleware{dbx: dbx, secret: secret}
+func New(dbx *sql.DB, keys *KeySet) Middleware {
+	return Middleware{dbx: dbx, keys: keys}
 }
 
 func (m Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
@@ -28,7 +31,7 @@ func (m Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "missing token", http.StatusUnauthorized)
 			return
 		}
-		claims, err := ParseTokenClaims(m.secret, tokenString)
+		claims, err := ParseTokenClaims(m.keys, tokenString)
 		if err != nil {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
@@ -37,8 +40,15 @@ func (m Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
+		if claims.SessionID != "" {
+			if active, err := SessionActive(r.Context(), m.dbx, claims.UserID, claims.SessionID); err != nil || !active {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
 
 		ctx := ContextWithUserID(r.Context(), claims.UserID)
+		ctx = ContextWithSessionID(ctx, claims.SessionID)
 
 		next(w, r.WithContext(ctx))
 	}
@@ -67,3 +77,17 @@ func UserIDFromContext(ctx context.Context) (int, bool) {
 	uid, ok := v.(int)
 	return uid, ok
 }
+
+// ContextWithSessionID stores the server-side session behind the request's
+// token. Legacy tokens without a session leave the context untouched.
+func ContextWithSessionID(ctx context.Context, sessionID string) context.Context {
+	if sessionID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey).(string)
+	return sessionID, ok && sessionID != ""
+}
@@ -0,0 +1,436 @@
+// Package oidc is a small OpenID Connect relying party: provider discovery,
+// the authorization code flow with PKCE and ID token validation against the
+// provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	discoveryTTL        = time.Hour
+	keySetTTL           = time.Hour
+	keySetRefetchPeriod = time.Minute
+	maxResponseBytes    = 1 << 20
+	clockLeeway         = time.Minute
+)
+
+var (
+	ErrIssuerInvalid  = errors.New("oidc_issuer_invalid")
+	ErrDiscovery      = errors.New("oidc_discovery_failed")
+	ErrCodeExchange   = errors.New("oidc_code_exchange_failed")
+	ErrIDTokenInvalid = errors.New("oidc_id_token_invalid")
+)
+
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Provider is the subset of the discovery document the login flow needs.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// AuthRequest describes one redirect to the provider's authorization endpoint.
+type AuthRequest struct {
+	ClientID      string
+	RedirectURI   string
+	State         string
+	Nonce         string
+	CodeChallenge string
+	LoginHint     string
+}
+
+// Credentials identify this application at the provider's token endpoint.
+type Credentials struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+}
+
+// Claims are the validated ID token claims the application uses.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Client caches discovery documents and key sets per issuer and is safe for
+// concurrent use.
+type Client struct {
+	http *http.Client
+	now  func() time.Time
+
+	mu        sync.Mutex
+	providers map[string]cachedProvider
+	keySets   map[string]cachedKeySet
+}
+
+type cachedProvider struct {
+	provider  Provider
+	fetchedAt time.Time
+}
+
+type cachedKeySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		http: httpClient, now: time.Now,
+		providers: map[string]cachedProvider{}, keySets: map[string]cachedKeySet{},
+	}
+}
+
+// NormalizeIssuer trims the issuer URL and requires https, except for
+// loopback hosts used by local identity providers.
+func NormalizeIssuer(issuer string) (string, error) {
+	issuer = strings.TrimRight(strings.TrimSpace(issuer), "/")
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", ErrIssuerInvalid
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if !isLoopback(parsed.Hostname()) {
+			return "", ErrIssuerInvalid
+		}
+	default:
+		return "", ErrIssuerInvalid
+	}
+	return issuer, nil
+}
+
+// Discover loads and caches the provider's discovery document. The document
+// must name the same issuer it was fetched for.
+func (c *Client) Discover(ctx context.Context, issuer string) (Provider, error) {
+	issuer, err := NormalizeIssuer(issuer)
+	if err != nil {
+		return Provider{}, err
+	}
+	c.mu.Lock()
+	cached, ok := c.providers[issuer]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetchedAt) < discoveryTTL {
+		return cached.provider, nil
+	}
+
+	var provider Provider
+	if err := c.getJSON(ctx, issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return Provider{}, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if strings.TrimRight(provider.Issuer, "/") != issuer {
+		return Provider{}, fmt.Errorf("%w: issuer mismatch", ErrDiscovery)
+	}
+	for _, endpoint := range []string{provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.JWKSURI} {
+		if _, err := NormalizeIssuer(endpoint); err != nil {
+			return Provider{}, fmt.Errorf("%w: invalid endpoint", ErrDiscovery)
+		}
+	}
+	c.mu.Lock()
+	c.providers[issuer] = cachedProvider{provider: provider, fetchedAt: c.now()}
+	c.mu.Unlock()
+	return provider, nil
+}
+
+// NewPKCE returns a code verifier and its S256 challenge.
+func NewPKCE() (string, string, error) {
+	verifier, err := RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, CodeChallenge(verifier), nil
+}
+
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns 32 random bytes, base64url encoded; used for state,
+// nonce and PKCE verifiers.
+func RandomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (p Provider) AuthCodeURL(request AuthRequest) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("scope", "openid email profile")
+	query.Set("client_id", request.ClientID)
+	query.Set("redirect_uri", request.RedirectURI)
+	query.Set("state", request.State)
+	query.Set("nonce", request.Nonce)
+	query.Set("code_challenge", request.CodeChallenge)
+	query.Set("code_challenge_method", "S256")
+	if request.LoginHint != "" {
+		query.Set("login_hint", request.LoginHint)
+	}
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (c *Client) Exchange(ctx context.Context, provider Provider, credentials Credentials, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", credentials.RedirectURI)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", credentials.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if credentials.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(credentials.ClientID), url.QueryEscape(credentials.ClientSecret))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCodeExchange, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: status %d", ErrCodeExchange, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("%w: status %d %s", ErrCodeExchange, resp.StatusCode, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   any    `json:"email_verified"`
+	Name            string `json:"name"`
+}
+
+// VerifyIDToken checks the signature against the provider's key set, the
+// issuer, audience, expiry and the nonce bound to this login attempt.
+func (c *Client) VerifyIDToken(ctx context.Context, provider Provider, clientID string, rawToken string, nonce string) (Claims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.signingKey(ctx, provider.JWKSURI, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(provider.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockLeeway),
+		jwt.WithTimeFunc(c.now),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrIDTokenInvalid, err)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrIDTokenInvalid)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != clientID {
+		return Claims{}, fmt.Errorf("%w: authorized party mismatch", ErrIDTokenInvalid)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrIDTokenInvalid)
+	}
+	return Claims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          strings.TrimSpace(claims.Name),
+	}, nil
+}
+
+// signingKey finds a key by id, refetching the key set when the id is unknown
+// so provider key rotation is picked up without a restart.
+func (c *Client) signingKey(ctx context.Context, jwksURI string, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	cached, ok := c.keySets[jwksURI]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetchedAt) < keySetTTL {
+		if key := pickKey(cached.keys, kid); key != nil {
+			return key, nil
+		}
+		if c.now().Sub(cached.fetchedAt) < keySetRefetchPeriod {
+			return nil, errors.New("unknown signing key")
+		}
+	}
+	keys, err := c.fetchKeySet(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.keySets[jwksURI] = cachedKeySet{keys: keys, fetchedAt: c.now()}
+	c.mu.Unlock()
+	if key := pickKey(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+func pickKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid != "" {
+		return keys[kid]
+	}
+	if len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (c *Client) fetchKeySet(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("empty key set")
+	}
+	return keys, nil
+}
+
+func parseJWK(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("rsa key too short")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+func (c *Client) getJSON(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(out)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,247 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal local OpenID provider: discovery, JWKS and a
+// token endpoint that hands out a pre-signed ID token for a known code.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	issued   map[string]string
+	verifier map[string]string
+	jwksHits int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	provider := &mockProvider{t: t, keys: map[string]*rsa.PrivateKey{}, issued: map[string]string{}, verifier: map[string]string{}}
+	provider.addKey("k1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"jwks_uri":               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		provider.jwksHits++
+		keys := []map[string]string{}
+		for kid, key := range provider.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if err := r.ParseForm(); err != nil || clientID != "app" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		code := r.PostForm.Get("code")
+		provider.mu.Lock()
+		idToken, ok := provider.issued[code]
+		challenge := provider.verifier[code]
+		delete(provider.issued, code)
+		provider.mu.Unlock()
+		if !ok || CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (p *mockProvider) addKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		p.t.Fatalf("generate key: %v", err)
+	}
+	p.mu.Lock()
+	p.keys[kid] = key
+	p.mu.Unlock()
+}
+
+func (p *mockProvider) sign(kid string, claims jwt.MapClaims) string {
+	p.t.Helper()
+	p.mu.Lock()
+	key := p.keys[kid]
+	p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		p.t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func (p *mockProvider) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": p.server.URL, "aud": "app", "sub": "user-1", "nonce": nonce,
+		"email": "Anna@Example.com", "email_verified": true, "name": "Анна",
+		"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	mock := newMockProvider(t)
+	client := NewClient(mock.server.Client())
+	ctx := context.Background()
+
+	provider, err := client.Discover(ctx, mock.server.URL+"/")
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(provider.AuthCodeURL(AuthRequest{
+		ClientID: "app", RedirectURI: "https://app.test/cb", State: "st", Nonce: "n1", CodeChallenge: challenge,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") != challenge || query.Get("nonce") != "n1" {
+		t.Fatalf("authorization url = %s", authURL)
+	}
+
+	mock.issued["code-1"] = mock.sign("k1", mock.claims("n1"))
+	mock.verifier["code-1"] = challenge
+	credentials := Credentials{ClientID: "app", ClientSecret: "s3cret", RedirectURI: "https://app.test/cb"}
+	if _, err := client.Exchange(ctx, provider, credentials, "code-1", "wrong-verifier"); !errors.Is(err, ErrCodeExchange) {
+		t.Fatalf("exchange with wrong verifier err = %v", err)
+	}
+	mock.issued["code-1"] = mock.sign("k1", mock.claims("n1"))
+	idToken, err := client.Exchange(ctx, provider, credentials, "code-1", verifier)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	claims, err := client.VerifyIDToken(ctx, provider, "app", idToken, "n1")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "anna@example.com" || !claims.EmailVerified || claims.Name != "Анна" {
+		t.Fatalf("claims = %+v", claims)
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	mock := newMockProvider(t)
+	client := NewClient(mock.server.Client())
+	ctx := context.Background()
+	provider, err := client.Discover(ctx, mock.server.URL)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	cases := map[string]func(jwt.MapClaims){
+		"wrong nonce":    func(c jwt.MapClaims) { c["nonce"] = "other" },
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.test" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-10 * time.Minute).Unix() },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+		"foreign azp":    func(c jwt.MapClaims) { c["aud"] = []string{"app", "other"}; c["azp"] = "other" },
+	}
+	for name, mutate := range cases {
+		claims := mock.claims("n1")
+		mutate(claims)
+		if _, err := client.VerifyIDToken(ctx, provider, "app", mock.sign("k1", claims), "n1"); !errors.Is(err, ErrIDTokenInvalid) {
+			t.Fatalf("%s: err = %v", name, err)
+		}
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodHS256, mock.claims("n1"))
+	forged, _ := unsigned.SignedString([]byte("guess"))
+	if _, err := client.VerifyIDToken(ctx, provider, "app", forged, "n1"); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("hmac token err = %v", err)
+	}
+	parts := strings.Split(mock.sign("k1", mock.claims("n1")), ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+	if _, err := client.VerifyIDToken(ctx, provider, "app", tampered, "n1"); !errors.Is(err, ErrIDTokenInvalid) {
+		t.Fatalf("tampered token err = %v", err)
+	}
+}
+
+func TestVerifyIDTokenPicksUpRotatedKeys(t *testing.T) {
+	mock := newMockProvider(t)
+	client := NewClient(mock.server.Client())
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	ctx := context.Background()
+	provider, err := client.Discover(ctx, mock.server.URL)
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if _, err := client.VerifyIDToken(ctx, provider, "app", mock.sign("k1", mock.claims("n")), "n"); err != nil {
+		t.Fatalf("verify k1: %v", err)
+	}
+
+	mock.addKey("k2")
+	rotated := mock.sign("k2", mock.claims("n"))
+	if _, err := client.VerifyIDToken(ctx, provider, "app", rotated, "n"); err == nil {
+		t.Fatalf("unknown key accepted before the refetch window")
+	}
+	now = now.Add(2 * keySetRefetchPeriod)
+	if _, err := client.VerifyIDToken(ctx, provider, "app", rotated, "n"); err != nil {
+		t.Fatalf("verify rotated key: %v", err)
+	}
+	if mock.jwksHits != 2 {
+		t.Fatalf("jwks fetched %d times", mock.jwksHits)
+	}
+}
+
+func TestNormalizeIssuer(t *testing.T) {
+	valid := map[string]string{
+		"https://id.example.com/":       "https://id.example.com",
+		" https://id.example.com/realm": "https://id.example.com/realm",
+		"http://localhost:8080":         "http://localhost:8080",
+		"http://127.0.0.1:9000/":        "http://127.0.0.1:9000",
+	}
+	for input, want := range valid {
+		got, err := NormalizeIssuer(input)
+		if err != nil || got != want {
+			t.Fatalf("NormalizeIssuer(%q) = %q, %v", input, got, err)
+		}
+	}
+	for _, input := range []string{"", "id.example.com", "http://id.example.com", "https://id.example.com?x=1", "ftp://id.example.com"} {
+		if _, err := NormalizeIssuer(input); !errors.Is(err, ErrIssuerInvalid) {
+			t.Fatalf("NormalizeIssuer(%q) err = %v", input, err)
+		}
+	}
+}
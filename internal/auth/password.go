@@ -35,8 +35,23 @@ func ChangePassword(ctx context.Context, dbx *sql.DB, userID int, currentPasswor
 	if err != nil {
 		return err
 	}
-	_, err = dbx.ExecContext(ctx, `UPDATE users SET password=$1, auth_version=auth_version+1 WHERE id=$2`, passwordHash, userID)
-	return err
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password=$1, auth_version=auth_version+1 WHERE id=$2`, passwordHash, userID); err != nil {
+		return err
+	}
+	revoked, err := revokeUserSessions(ctx, tx, userID, sessionRevokedPasswordChange)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	forgetSessions(revoked...)
+	return nil
 }
 
 func hashPassword(password string) (string, error) {
@@ -63,6 +78,17 @@ func hashPassword(password string) (string, error) {
 	), nil
 }
 
+// UnusablePasswordHash hashes a random secret that is never shown, for
+// accounts created by SSO or provisioning. They sign in through the provider
+// or set a password with the reset flow.
+func UnusablePasswordHash() (string, error) {
+	secret, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return hashPassword(secret)
+}
+
 func passwordNeedsRehash(stored string) bool {
 	if !isPasswordHash(stored) {
 		return true
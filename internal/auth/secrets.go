@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"reup-goals-backend/internal/encryption"
+)
+
+// legacySealedSecretPrefix marks a secret sealed with the key derived from
+// JWT_SECRET before secrets moved to the data encryption keyring. Such rows
+// are only read, and resealed at startup.
+const legacySealedSecretPrefix = "sealed:v1:"
+
+var ErrSecretKeyNotInstalled = errors.New("auth_secret_key_not_installed")
+
+var installedLegacySecretKey atomic.Pointer[[32]byte]
+
+// InstallLegacySecretKey derives the key that sealed secrets before they
+// moved to the data encryption keyring. It is only used to read and reseal
+// those rows; new secrets never depend on JWT_SECRET.
+func InstallLegacySecretKey(secret []byte) {
+	key := sha256.Sum256(append([]byte("reup-auth-secret:"), secret...))
+	installedLegacySecretKey.Store(&key)
+}
+
+// sealSecret encrypts a secret auth has to read back, such as a TOTP seed,
+// with the data encryption keyring; the purpose and row are bound to it.
+func sealSecret(purpose string, rowID int, plaintext string) (string, error) {
+	return encryption.SealSecret(purpose, rowID, plaintext)
+}
+
+// openSecret decrypts a sealed secret. A value from before sealing is
+// returned as is until SealStoredSecrets gets to it.
+func openSecret(purpose string, rowID int, stored string) (string, error) {
+	encoded, legacy := strings.CutPrefix(stored, legacySealedSecretPrefix)
+	if !legacy {
+		return encryption.OpenSecret(purpose, rowID, stored)
+	}
+	key := installedLegacySecretKey.Load()
+	if key == nil {
+		return "", ErrSecretKeyNotInstalled
+	}
+	plaintext, err := openWithKey(*key, []byte(legacySecretAAD(purpose, rowID)), encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func legacySecretAAD(purpose string, rowID int) string {
+	return purpose + ":" + strconv.Itoa(rowID)
+}
+
+// storedSecrets lists the columns holding sealed secrets, keyed by the row
+// id their sealing is bound to.
+var storedSecrets = []struct {
+	purpose string
+	table   string
+	idCol   string
+	column  string
+}{
+	{"totp", "auth_totp_factors", "user_id", "secret"},
+	{"sso_client_secret", "workspace_sso_connections", "workspace_id", "client_secret"},
+}
+
+// SealStoredSecrets seals the secrets stored before sealing, or sealed with
+// the legacy key or a previous master key, with the current master key. It
+// runs after the keyring is installed. A secret that no longer opens is
+// left for its owner to replace rather than blocking startup.
+func SealStoredSecrets(ctx context.Context, dbx *sql.DB) error {
+	for _, stored := range storedSecrets {
+		if err := sealStoredColumn(ctx, dbx, stored.purpose, stored.table, stored.idCol, stored.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sealStoredColumn(ctx context.Context, dbx *sql.DB, purpose, table, idCol, column string) error {
+	// #nosec G201 -- table and column names come from storedSecrets, never from input.
+	rows, err := dbx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[2]s, %[3]s FROM %[1]s WHERE %[3]s <> ''
+	`, table, idCol, column))
+	if err != nil {
+		return err
+	}
+	stale := map[int]string{}
+	for rows.Next() {
+		var id int
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return err
+		}
+		if !encryption.SecretIsCurrent(stored) {
+			stale[id] = stored
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, stored := range stale {
+		secret, err := openSecret(purpose, id, stored)
+		if err != nil {
+			log.Printf("[WARN] %s secret of %s %d cannot be opened: %v", purpose, idCol, id, err)
+			continue
+		}
+		sealed, err := sealSecret(purpose, id, secret)
+		if err != nil {
+			return err
+		}
+		// #nosec G201 -- table and column names come from storedSecrets, never from input.
+		if _, err := dbx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %[1]s SET %[3]s=$2 WHERE %[2]s=$1 AND %[3]s=$3
+		`, table, idCol, column), id, sealed, stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sealWithKey(key [32]byte, aad []byte, plaintext []byte) (string, error) {
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, nonce, plaintext, aad)
+	return base64.RawStdEncoding.EncodeToString(append(nonce, sealed...)), nil
+}
+
+func openWithKey(key [32]byte, aad []byte, encoded string) ([]byte, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return nil, errors.New("sealed secret is too short")
+	}
+	return gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], aad)
+}
+
+func newSecretCipher(key [32]byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
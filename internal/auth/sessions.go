@@ -0,0 +1,471 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"reup-goals-backend/internal/security"
+)
+
+const (
+	RefreshCookieName = "reupgoals_refresh"
+	refreshCookiePath = "/auth/refresh"
+	refreshTokenTTL   = 30 * 24 * time.Hour
+	deviceNameHeader  = "X-REUP-Device-Name"
+
+	sessionRevokedLogout         = "logout"
+	sessionRevokedByUser         = "revoked_by_user"
+	sessionRevokedPasswordReset  = "password_reset"
+	sessionRevokedPasswordChange = "password_change"
+	sessionRevokedRefreshReuse   = "refresh_token_reused"
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session_not_found")
+	errInvalidRefreshToken = errors.New("invalid_refresh_token")
+	errRefreshTokenReused  = errors.New("refresh_token_reused")
+)
+
+var installedSessionForgetter atomic.Pointer[func(sessionID string)]
+
+// OnSessionRevoked installs the callback told about every revoked session, so
+// caches of validated sessions drop them instead of waiting out their TTL.
+func OnSessionRevoked(forget func(sessionID string)) {
+	installedSessionForgetter.Store(&forget)
+}
+
+func forgetSessions(sessionIDs ...string) {
+	forget := installedSessionForgetter.Load()
+	if forget == nil {
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		(*forget)(sessionID)
+	}
+}
+
+// Session is one signed-in device as shown to its owner.
+type Session struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Current     bool      `json:"current"`
+}
+
+type issuedSession struct {
+	SessionID    string
+	Token        string
+	RefreshToken string
+}
+
+type sqlQueryer interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// issueSession records a new device session and sets both the access and the
+// refresh cookies. Login and email verification share it so every sign-in
+// shows up in the session list.
+func issueSession(w http.ResponseWriter, r *http.Request, dbx *sql.DB, keys *KeySet, userID int, authVersion int, secureCookie bool) (issuedSession, error) {
+	publicID, err := randomToken()
+	if err != nil {
+		return issuedSession{}, err
+	}
+	refreshToken, err := randomToken()
+	if err != nil {
+		return issuedSession{}, err
+	}
+	userAgent := truncateRunes(strings.TrimSpace(r.UserAgent()), 512)
+
+	tx, err := dbx.BeginTx(r.Context(), nil)
+	if err != nil {
+		return issuedSession{}, err
+	}
+	defer tx.Rollback()
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	var sessionID int64
+	if err := tx.QueryRowContext(r.Context(), `
+		INSERT INTO auth_sessions (public_id, user_id, device_label, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, publicID, userID, deviceLabel(r), security.ClientIP(r), userAgent, expiresAt).Scan(&sessionID); err != nil {
+		return issuedSession{}, err
+	}
+	if _, err := tx.ExecContext(r.Context(), `
+		INSERT INTO auth_refresh_tokens (session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, sessionID, hashSecretToken(refreshToken), expiresAt); err != nil {
+		return issuedSession{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return issuedSession{}, err
+	}
+
+	token, err := GenerateSessionToken(keys, userID, authVersion, publicID)
+	if err != nil {
+		return issuedSession{}, err
+	}
+	SetSessionCookie(w, token, secureCookie)
+	setRefreshCookie(w, refreshToken, secureCookie)
+	return issuedSession{SessionID: publicID, Token: token, RefreshToken: refreshToken}, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. Presenting an already rotated refresh token means it leaked,
+// so the whole session is revoked.
+func RefreshHandler(dbx *sql.DB, keys *KeySet, secureCookie bool, browserAuthOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeAPIError(w, "invalid_json", http.StatusBadRequest)
+				return
+			}
+		}
+		refreshToken := strings.TrimSpace(body.RefreshToken)
+		if refreshToken == "" {
+			if cookie, err := r.Cookie(RefreshCookieName); err == nil {
+				refreshToken = strings.TrimSpace(cookie.Value)
+			}
+		}
+		if refreshToken == "" {
+			writeAPIError(w, errInvalidRefreshToken.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		rotation, err := rotateRefreshToken(r.Context(), dbx, refreshToken)
+		if errors.Is(err, errRefreshTokenReused) || errors.Is(err, errInvalidRefreshToken) {
+			ClearSessionCookie(w, secureCookie)
+			clearRefreshCookie(w, secureCookie)
+			writeAPIError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "session_refresh_failed", http.StatusInternalServerError)
+			return
+		}
+		token, err := GenerateSessionToken(keys, rotation.userID, rotation.authVersion, rotation.sessionID)
+		if err != nil {
+			writeAPIError(w, "token_generation_failed", http.StatusInternalServerError)
+			return
+		}
+		SetSessionCookie(w, token, secureCookie)
+		setRefreshCookie(w, rotation.refreshToken, secureCookie)
+		response := map[string]any{"ok": true, "user_id": rotation.userID, "session_id": rotation.sessionID}
+		if shouldExposeToken(r, browserAuthOnly) {
+			response["token"] = token
+			response["refresh_token"] = rotation.refreshToken
+		}
+		writeOK(w, response)
+	}
+}
+
+type refreshRotation struct {
+	userID       int
+	authVersion  int
+	sessionID    string
+	refreshToken string
+}
+
+func rotateRefreshToken(ctx context.Context, dbx *sql.DB, refreshToken string) (refreshRotation, error) {
+	nextToken, err := randomToken()
+	if err != nil {
+		return refreshRotation{}, err
+	}
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return refreshRotation{}, err
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID int64
+	var used, revoked, emailVerified bool
+	var tokenExpiresAt, sessionExpiresAt time.Time
+	result := refreshRotation{}
+	err = tx.QueryRowContext(ctx, `
+		SELECT token.id, token.used_at IS NOT NULL, token.expires_at,
+			session.id, session.public_id, session.revoked_at IS NOT NULL, session.expires_at,
+			users.id, users.auth_version, users.email_verified
+		FROM auth_refresh_tokens token
+		JOIN auth_sessions session ON session.id=token.session_id
+		JOIN users ON users.id=session.user_id
+		WHERE token.token_hash=$1
+		FOR UPDATE OF token, session
+	`, hashSecretToken(refreshToken)).Scan(
+		&tokenID, &used, &tokenExpiresAt,
+		&sessionID, &result.sessionID, &revoked, &sessionExpiresAt,
+		&result.userID, &result.authVersion, &emailVerified,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return refreshRotation{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return refreshRotation{}, err
+	}
+	if used {
+		if !revoked {
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE auth_sessions SET revoked_at=NOW(), revoked_reason=$2 WHERE id=$1
+			`, sessionID, sessionRevokedRefreshReuse); err != nil {
+				return refreshRotation{}, err
+			}
+			if err := tx.Commit(); err != nil {
+				return refreshRotation{}, err
+			}
+			forgetSessions(result.sessionID)
+		}
+		return refreshRotation{}, errRefreshTokenReused
+	}
+	now := time.Now()
+	if revoked || !emailVerified || now.After(tokenExpiresAt) || now.After(sessionExpiresAt) {
+		return refreshRotation{}, errInvalidRefreshToken
+	}
+
+	expiresAt := now.Add(refreshTokenTTL)
+	if _, err := tx.ExecContext(ctx, `UPDATE auth_refresh_tokens SET used_at=NOW() WHERE id=$1`, tokenID); err != nil {
+		return refreshRotation{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO auth_refresh_tokens (session_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, sessionID, hashSecretToken(nextToken), expiresAt); err != nil {
+		return refreshRotation{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth_sessions SET last_seen_at=NOW(), expires_at=$2 WHERE id=$1
+	`, sessionID, expiresAt); err != nil {
+		return refreshRotation{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return refreshRotation{}, err
+	}
+	result.refreshToken = nextToken
+	return result, nil
+}
+
+// SessionActive reports whether a token's session is still usable and bumps
+// its last-seen time at most once a minute.
+func SessionActive(ctx context.Context, dbx *sql.DB, userID int, sessionID string) (bool, error) {
+	if dbx == nil || userID <= 0 || sessionID == "" {
+		return false, nil
+	}
+	var active bool
+	err := dbx.QueryRowContext(ctx, `
+		WITH active AS (
+			SELECT id, last_seen_at
+			FROM auth_sessions
+			WHERE public_id=$1 AND user_id=$2 AND revoked_at IS NULL AND expires_at > NOW()
+		), touched AS (
+			UPDATE auth_sessions
+			SET last_seen_at=NOW()
+			FROM active
+			WHERE auth_sessions.id=active.id AND active.last_seen_at < NOW() - INTERVAL '1 minute'
+			RETURNING auth_sessions.id
+		)
+		SELECT EXISTS(SELECT 1 FROM active)
+	`, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func ListSessions(ctx context.Context, dbx *sql.DB, userID int, currentSessionID string) ([]Session, error) {
+	rows, err := dbx.QueryContext(ctx, `
+		SELECT public_id, device_label, ip_address, user_agent, created_at, last_seen_at, expires_at
+		FROM auth_sessions
+		WHERE user_id=$1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.ID, &session.DeviceLabel, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func RevokeSession(ctx context.Context, dbx *sql.DB, userID int, sessionID string) error {
+	return revokeSession(ctx, dbx, userID, sessionID, sessionRevokedByUser)
+}
+
+// RevokeOtherSessions signs the user out everywhere except the current
+// session and returns how many sessions were revoked.
+func RevokeOtherSessions(ctx context.Context, dbx *sql.DB, userID int, currentSessionID string) (int64, error) {
+	rows, err := dbx.QueryContext(ctx, `
+		UPDATE auth_sessions
+		SET revoked_at=NOW(), revoked_reason=$3
+		WHERE user_id=$1 AND public_id<>$2 AND revoked_at IS NULL
+		RETURNING public_id
+	`, userID, currentSessionID, sessionRevokedByUser)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := scanSessionIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+	forgetSessions(revoked...)
+	return int64(len(revoked)), nil
+}
+
+func revokeSession(ctx context.Context, dbx *sql.DB, userID int, sessionID string, reason string) error {
+	result, err := dbx.ExecContext(ctx, `
+		UPDATE auth_sessions
+		SET revoked_at=NOW(), revoked_reason=$3
+		WHERE user_id=$1 AND public_id=$2 AND revoked_at IS NULL
+	`, userID, sessionID, reason)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	forgetSessions(sessionID)
+	return nil
+}
+
+// revokeUserSessions closes every session of a user. auth_version is bumped
+// alongside it, which already rejects old tokens; revoking keeps the session
+// list honest. It returns the revoked sessions for the caller to forget once
+// the transaction commits.
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userID int, reason string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE auth_sessions
+		SET revoked_at=NOW(), revoked_reason=$2
+		WHERE user_id=$1 AND revoked_at IS NULL
+		RETURNING public_id
+	`, userID, reason)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessionIDs(rows)
+}
+
+func scanSessionIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs, rows.Err()
+}
+
+func setRefreshCookie(w http.ResponseWriter, token string, secure bool) {
+	// #nosec G124 -- secure is always true in staging/production; false supports local HTTP development.
+	http.SetCookie(w, &http.Cookie{
+		Name: RefreshCookieName, Value: token, Path: refreshCookiePath, HttpOnly: true, Secure: secure,
+		SameSite: http.SameSiteStrictMode, MaxAge: int(refreshTokenTTL.Seconds()), Expires: time.Now().Add(refreshTokenTTL),
+	})
+}
+
+func clearRefreshCookie(w http.ResponseWriter, secure bool) {
+	// #nosec G124 -- deletion must use the same Secure attribute as the original cookie.
+	http.SetCookie(w, &http.Cookie{
+		Name: RefreshCookieName, Value: "", Path: refreshCookiePath, HttpOnly: true, Secure: secure,
+		SameSite: http.SameSiteStrictMode, MaxAge: -1, Expires: time.Unix(1, 0),
+	})
+}
+
+// hashSecretToken is how refresh tokens, API tokens and other bearer secrets
+// are stored: only their SHA-256 is kept.
+func hashSecretToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// deviceLabel prefers the name a native client sends and falls back to a
+// short browser/OS description of the user agent.
+func deviceLabel(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get(deviceNameHeader)); name != "" {
+		return truncateRunes(name, 120)
+	}
+	return describeUserAgent(r.UserAgent())
+}
+
+func describeUserAgent(userAgent string) string {
+	var client, system string
+	switch {
+	case strings.Contains(userAgent, "YaBrowser/"):
+		client = "Яндекс Браузер"
+	case strings.Contains(userAgent, "Edg/"):
+		client = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		client = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		client = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		client = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		client = "Safari"
+	case strings.Contains(userAgent, "okhttp/"), strings.Contains(userAgent, "CFNetwork/"),
+		strings.Contains(userAgent, "Dart/"):
+		client = "Приложение"
+	}
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		system = "iOS"
+	case strings.Contains(userAgent, "iPad"):
+		system = "iPadOS"
+	case strings.Contains(userAgent, "Android"):
+		system = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		system = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		system = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		system = "Linux"
+	}
+	switch {
+	case client != "" && system != "":
+		return client + " · " + system
+	case client != "":
+		return client
+	case system != "":
+		return system
+	default:
+		return "Неизвестное устройство"
+	}
+}
+
+func truncateRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
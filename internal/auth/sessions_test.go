@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36":         "Chrome · macOS",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0":     "Edge · Windows",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/604.1": "Safari · iOS",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) YaBrowser/24.4 Chrome/122.0 Mobile Safari/537.36":   "Яндекс Браузер · Android",
+		"okhttp/4.12.0": "Приложение",
+		"":              "Неизвестное устройство",
+	}
+	for userAgent, want := range tests {
+		if got := describeUserAgent(userAgent); got != want {
+			t.Fatalf("describeUserAgent(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestDeviceLabelPrefersNativeDeviceName(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	request.Header.Set("User-Agent", "okhttp/4.12.0")
+	request.Header.Set(deviceNameHeader, "  Pixel 8 Анны  ")
+	if got := deviceLabel(request); got != "Pixel 8 Анны" {
+		t.Fatalf("unexpected device label %q", got)
+	}
+}
+
+func TestRefreshCookieIsScopedToRefreshEndpoint(t *testing.T) {
+	response := httptest.NewRecorder()
+	setRefreshCookie(response, "refresh", true)
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != refreshCookiePath || !cookies[0].HttpOnly ||
+		!cookies[0].Secure || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected refresh cookie: %+v", cookies)
+	}
+	if hashSecretToken("refresh") == hashSecretToken("refresh2") || len(hashSecretToken("refresh")) != 64 {
+		t.Fatal("refresh token hash must be a stable sha256 hex digest")
+	}
+}
+
+func TestRevokedSessionsReachTheInstalledForgetter(t *testing.T) {
+	forgetSessions("before-install")
+
+	var forgotten []string
+	OnSessionRevoked(func(sessionID string) { forgotten = append(forgotten, sessionID) })
+	t.Cleanup(func() { installedSessionForgetter.Store(nil) })
+
+	forgetSessions("first", "second")
+	if len(forgotten) != 2 || forgotten[0] != "first" || forgotten[1] != "second" {
+		t.Fatalf("forgotten = %v", forgotten)
+	}
+}
@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"reup-goals-backend/internal/audit"
+	"reup-goals-backend/internal/config"
+	"reup-goals-backend/internal/security"
+)
+
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+
+	RiskNewDevice        = "new_device"
+	RiskNewNetwork       = "new_network"
+	RiskImpossibleTravel = "impossible_travel"
+
+	deviceCookieName = "reupgoals_device"
+	deviceCookiePath = "/auth"
+	deviceCookieTTL  = 400 * 24 * time.Hour
+
+	loginFailuresBeforeDelay   = 5
+	loginFailuresBeforeLockout = 10
+	loginFailureBaseDelay      = 5 * time.Second
+	loginLockoutDuration       = 15 * time.Minute
+	loginMaxLockoutDuration    = 24 * time.Hour
+	loginFailureWindow         = 24 * time.Hour
+
+	signInHistoryDepth     = 50
+	signInHistoryWindow    = 180 * 24 * time.Hour
+	impossibleTravelWindow = time.Hour
+	signInAlertTTL         = 7 * 24 * time.Hour
+
+	sessionRevokedSignInRejected = "sign_in_rejected"
+)
+
+var (
+	ErrLoginLocked         = errors.New("login_temporarily_locked")
+	errSignInAlertInvalid  = errors.New("sign_in_alert_invalid")
+	moscowTime             = time.FixedZone("МСК", 3*60*60)
+	signInRiskDescriptions = map[string]string{
+		RiskNewDevice:        "вход с нового устройства или браузера",
+		RiskNewNetwork:       "вход из новой сети",
+		RiskImpossibleTravel: "быстрая смена сети после предыдущего входа",
+	}
+)
+
+// RiskAssessment rates one sign-in against the user's recent history.
+type RiskAssessment struct {
+	Level   string   `json:"level"`
+	Reasons []string `json:"reasons"`
+}
+
+type signInRecord struct {
+	IPPrefix   string
+	DeviceHash string
+	CreatedAt  time.Time
+}
+
+// SignInMonitor throttles password guessing per account, rates every
+// successful sign-in and emails the user about risky ones. A nil monitor
+// does nothing, so handlers work without it.
+type SignInMonitor struct {
+	dbx          *sql.DB
+	email        *EmailService
+	frontendURL  string
+	secureCookie bool
+}
+
+func NewSignInMonitor(dbx *sql.DB, emailService *EmailService, secureCookie bool, cfg *config.Config) *SignInMonitor {
+	return &SignInMonitor{
+		dbx: dbx, email: emailService, secureCookie: secureCookie,
+		frontendURL: strings.TrimRight(cfg.FrontendBaseURL, "/"),
+	}
+}
+
+// loginBackoff is how long an account waits after its n-th consecutive
+// failure: nothing at first, then a doubling delay, then a lockout that
+// doubles every five further failures.
+func loginBackoff(failures int) time.Duration {
+	switch {
+	case failures < loginFailuresBeforeDelay:
+		return 0
+	case failures < loginFailuresBeforeLockout:
+		return loginFailureBaseDelay << (failures - loginFailuresBeforeDelay)
+	}
+	lockout := loginLockoutDuration << min((failures-loginFailuresBeforeLockout)/5, 7)
+	return min(lockout, loginMaxLockoutDuration)
+}
+
+// CheckLogin reports how long the email must wait before the next attempt.
+// Emails without an account are throttled the same way, so the answer does
+// not reveal whether one exists.
+func (m *SignInMonitor) CheckLogin(ctx context.Context, email string) (time.Duration, error) {
+	if m == nil {
+		return 0, nil
+	}
+	var blockedUntil time.Time
+	err := m.dbx.QueryRowContext(ctx, `
+		SELECT blocked_until FROM auth_login_failures
+		WHERE email=$1 AND blocked_until > NOW()
+	`, email).Scan(&blockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(blockedUntil), nil
+}
+
+// RecordFailure counts a failed attempt; failures older than a day are
+// forgotten. userID is 0 when the email has no account.
+func (m *SignInMonitor) RecordFailure(r *http.Request, email string, userID int) {
+	if m == nil || email == "" {
+		return
+	}
+	var failures int
+	if err := m.dbx.QueryRowContext(r.Context(), `
+		INSERT INTO auth_login_failures (email, failed_count, last_failed_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			failed_count=CASE
+				WHEN auth_login_failures.last_failed_at < $2 THEN 1
+				ELSE auth_login_failures.failed_count + 1
+			END,
+			last_failed_at=NOW()
+		RETURNING failed_count
+	`, email, time.Now().Add(-loginFailureWindow)).Scan(&failures); err != nil {
+		return
+	}
+	backoff := loginBackoff(failures)
+	if backoff <= 0 {
+		return
+	}
+	if _, err := m.dbx.ExecContext(r.Context(), `
+		UPDATE auth_login_failures SET blocked_until=$2 WHERE email=$1
+	`, email, time.Now().Add(backoff)); err != nil {
+		return
+	}
+	if failures == loginFailuresBeforeLockout {
+		recordAccountEvent(r, m.dbx, userID, email, audit.ActionAccountLocked, map[string]any{
+			"failed_attempts": failures, "locked_seconds": int(backoff.Seconds()),
+		})
+	}
+}
+
+// RecordUserFailure counts a failed second factor against the account.
+func (m *SignInMonitor) RecordUserFailure(r *http.Request, userID int) {
+	if m == nil {
+		return
+	}
+	var email string
+	if err := m.dbx.QueryRowContext(r.Context(), `SELECT email FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		return
+	}
+	m.RecordFailure(r, email, userID)
+}
+
+// RecordSuccess clears the failure counter, rates the sign-in, stores it in
+// the history and sends the "new sign-in" email when the risk is not low.
+// The first sign-in has nothing to compare with and is always low risk.
+func (m *SignInMonitor) RecordSuccess(w http.ResponseWriter, r *http.Request, userID int, method string) RiskAssessment {
+	if m == nil {
+		return RiskAssessment{Level: RiskLow, Reasons: []string{}}
+	}
+	ctx := r.Context()
+	var email string
+	if err := m.dbx.QueryRowContext(ctx, `SELECT email FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		return RiskAssessment{Level: RiskLow, Reasons: []string{}}
+	}
+	_, _ = m.dbx.ExecContext(ctx, `DELETE FROM auth_login_failures WHERE email=$1`, email)
+
+	deviceID := m.deviceID(w, r)
+	ip := security.ClientIP(r)
+	prefix := ipPrefix(ip)
+	history, err := m.history(ctx, userID)
+	if err != nil {
+		return RiskAssessment{Level: RiskLow, Reasons: []string{}}
+	}
+	assessment := assessSignInRisk(history, prefix, hashSecretToken(deviceID), time.Now())
+
+	var historyID int64
+	if err := m.dbx.QueryRowContext(ctx, `
+		INSERT INTO auth_sign_in_history (user_id, method, ip_address, ip_prefix, device_hash, risk_level, risk_reasons)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, userID, method, ip, prefix, hashSecretToken(deviceID), assessment.Level, pq.Array(assessment.Reasons)).Scan(&historyID); err != nil {
+		return assessment
+	}
+	if assessment.Level != RiskLow && m.email != nil {
+		m.sendAlert(ctx, r, userID, email, historyID, assessment)
+	}
+	return assessment
+}
+
+func (m *SignInMonitor) history(ctx context.Context, userID int) ([]signInRecord, error) {
+	rows, err := m.dbx.QueryContext(ctx, `
+		SELECT ip_prefix, device_hash, created_at FROM auth_sign_in_history
+		WHERE user_id=$1 AND created_at > $2
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, userID, time.Now().Add(-signInHistoryWindow), signInHistoryDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []signInRecord{}
+	for rows.Next() {
+		var item signInRecord
+		if err := rows.Scan(&item.IPPrefix, &item.DeviceHash, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// deviceID returns the browser's long-lived device cookie, setting a new one
+// on its first sign-in.
+func (m *SignInMonitor) deviceID(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(deviceCookieName); err == nil && len(cookie.Value) == 43 {
+		return cookie.Value
+	}
+	value, err := randomToken()
+	if err != nil {
+		return ""
+	}
+	// #nosec G124 -- secure is always true in staging/production; false supports local HTTP development.
+	http.SetCookie(w, &http.Cookie{
+		Name: deviceCookieName, Value: value, Path: deviceCookiePath, HttpOnly: true, Secure: m.secureCookie,
+		SameSite: http.SameSiteLaxMode, MaxAge: int(deviceCookieTTL.Seconds()), Expires: time.Now().Add(deviceCookieTTL),
+	})
+	return value
+}
+
+func (m *SignInMonitor) sendAlert(ctx context.Context, r *http.Request, userID int, email string, historyID int64, assessment RiskAssessment) {
+	token, err := randomToken()
+	if err != nil {
+		return
+	}
+	if _, err := m.dbx.ExecContext(ctx, `
+		INSERT INTO auth_sign_in_alerts (user_id, history_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, historyID, hashSecretToken(token), time.Now().Add(signInAlertTTL)); err != nil {
+		return
+	}
+	reasons := make([]string, 0, len(assessment.Reasons))
+	for _, reason := range assessment.Reasons {
+		reasons = append(reasons, "<li>"+html.EscapeString(signInRiskDescriptions[reason])+"</li>")
+	}
+	rejectURL := m.frontendURL + "/security/not-me?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf(
+		"<p>В ваш аккаунт REUP.goals выполнен вход.</p><ul><li>Время: %s</li><li>Устройство: %s</li><li>IP-адрес: %s</li></ul><p>Почему мы пишем:</p><ul>%s</ul><p>Если это были вы, ничего делать не нужно.</p><p><a href=\"%s\">Это был не я</a> — мы завершим все сеансы, после чего смените пароль.</p>",
+		time.Now().In(moscowTime).Format("02.01.2006 15:04 МСК"), html.EscapeString(deviceLabel(r)),
+		html.EscapeString(security.ClientIP(r)), strings.Join(reasons, ""), html.EscapeString(rejectURL),
+	)
+	go func() {
+		_ = m.email.SendServiceEmail(email, "Новый вход в аккаунт REUP.goals", body)
+	}()
+}
+
+// RejectSignInHandler serves the "this wasn't me" link from the new sign-in
+// email: it bumps auth_version and ends every session of the account.
+func (m *SignInMonitor) RejectSignInHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, "invalid_json", http.StatusBadRequest)
+			return
+		}
+		userID, err := m.rejectSignIn(r.Context(), strings.TrimSpace(body.Token))
+		if errors.Is(err, errSignInAlertInvalid) {
+			writeAPIError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		recordAccountEvent(r, m.dbx, userID, "", audit.ActionSignInRejected, map[string]any{"sessions_revoked": true})
+		writeOK(w, map[string]any{"ok": true, "password_reset_recommended": true})
+	}
+}
+
+func (m *SignInMonitor) rejectSignIn(ctx context.Context, token string) (int, error) {
+	if token == "" {
+		return 0, errSignInAlertInvalid
+	}
+	tx, err := m.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var userID int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE auth_sign_in_alerts SET used_at=NOW()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, hashSecretToken(token)).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errSignInAlertInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET auth_version=auth_version+1 WHERE id=$1`, userID); err != nil {
+		return 0, err
+	}
+	revoked, err := revokeUserSessions(ctx, tx, userID, sessionRevokedSignInRejected)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth_sign_in_alerts SET used_at=NOW() WHERE user_id=$1 AND used_at IS NULL
+	`, userID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	forgetSessions(revoked...)
+	return userID, nil
+}
+
+// assessSignInRisk compares a sign-in with the user's history, newest first.
+// Without geolocation, impossible travel means a new network shortly after a
+// sign-in from a different one.
+func assessSignInRisk(history []signInRecord, prefix string, deviceHash string, now time.Time) RiskAssessment {
+	result := RiskAssessment{Level: RiskLow, Reasons: []string{}}
+	if len(history) == 0 {
+		return result
+	}
+	knownDevice, knownNetwork := false, prefix == ""
+	for _, record := range history {
+		knownDevice = knownDevice || record.DeviceHash == deviceHash
+		knownNetwork = knownNetwork || record.IPPrefix == prefix
+	}
+	score := 0
+	if !knownDevice {
+		result.Reasons = append(result.Reasons, RiskNewDevice)
+		score++
+	}
+	if !knownNetwork {
+		result.Reasons = append(result.Reasons, RiskNewNetwork)
+		score++
+		latest := history[0]
+		if latest.IPPrefix != "" && now.Sub(latest.CreatedAt) < impossibleTravelWindow {
+			result.Reasons = append(result.Reasons, RiskImpossibleTravel)
+			score += 2
+		}
+	}
+	switch {
+	case score >= 2:
+		result.Level = RiskHigh
+	case score == 1:
+		result.Level = RiskMedium
+	}
+	return result
+}
+
+// ipPrefix is the network a sign-in came from: the /16 of an IPv4 address or
+// the /32 of an IPv6 one, roughly a provider's allocation.
+func ipPrefix(value string) string {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  0,
+		4:  0,
+		5:  5 * time.Second,
+		6:  10 * time.Second,
+		9:  80 * time.Second,
+		10: 15 * time.Minute,
+		14: 15 * time.Minute,
+		15: 30 * time.Minute,
+		30: 4 * time.Hour,
+		50: 24 * time.Hour,
+		99: 24 * time.Hour,
+	}
+	for failures, want := range cases {
+		if got := loginBackoff(failures); got != want {
+			t.Fatalf("loginBackoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestAssessSignInRisk(t *testing.T) {
+	now := time.Date(2026, 8, 24, 12, 0, 0, 0, time.UTC)
+	history := []signInRecord{
+		{IPPrefix: "203.0.0.0/16", DeviceHash: "laptop", CreatedAt: now.Add(-30 * time.Minute)},
+		{IPPrefix: "198.51.0.0/16", DeviceHash: "phone", CreatedAt: now.Add(-72 * time.Hour)},
+	}
+	cases := []struct {
+		name    string
+		history []signInRecord
+		prefix  string
+		device  string
+		level   string
+		reasons []string
+	}{
+		{"first sign-in", nil, "192.0.0.0/16", "new", RiskLow, []string{}},
+		{"known device and network", history, "198.51.0.0/16", "laptop", RiskLow, []string{}},
+		{"new device", history, "203.0.0.0/16", "tablet", RiskMedium, []string{RiskNewDevice}},
+		{"new network soon after", history, "192.0.0.0/16", "laptop", RiskHigh, []string{RiskNewNetwork, RiskImpossibleTravel}},
+		{"new device and network", history[1:], "192.0.0.0/16", "tablet", RiskHigh, []string{RiskNewDevice, RiskNewNetwork}},
+		{"new network later", history[1:], "192.0.0.0/16", "phone", RiskMedium, []string{RiskNewNetwork}},
+		{"unknown address", history, "", "laptop", RiskLow, []string{}},
+	}
+	for _, tc := range cases {
+		got := assessSignInRisk(tc.history, tc.prefix, tc.device, now)
+		if got.Level != tc.level || !slices.Equal(got.Reasons, tc.reasons) {
+			t.Fatalf("%s: assessSignInRisk = %+v, want %s %v", tc.name, got, tc.level, tc.reasons)
+		}
+	}
+}
+
+func TestIPPrefix(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.9":          "203.0.0.0/16",
+		" 203.0.200.1 ":        "203.0.0.0/16",
+		"2001:db8:abcd::1":     "2001:db8::/32",
+		"::ffff:198.51.100.20": "198.51.0.0/16",
+		"not-an-ip":            "",
+		"":                     "",
+	}
+	for value, want := range cases {
+		if got := ipPrefix(value); got != want {
+			t.Fatalf("ipPrefix(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestNilSignInMonitor(t *testing.T) {
+	var monitor *SignInMonitor
+	request := httptest.NewRequest("POST", "/auth/login", nil)
+	if wait, err := monitor.CheckLogin(request.Context(), "user@example.com"); wait != 0 || err != nil {
+		t.Fatalf("CheckLogin = %v, %v", wait, err)
+	}
+	monitor.RecordFailure(request, "user@example.com", 1)
+	if risk := monitor.RecordSuccess(httptest.NewRecorder(), request, 1, "password"); risk.Level != RiskLow {
+		t.Fatalf("RecordSuccess = %+v", risk)
+	}
+}
@@ -0,0 +1,483 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"reup-goals-backend/internal/audit"
+)
+
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmES256 = "ES256"
+
+	// A rotated key is published for a while before it signs anything, so
+	// every instance and every JWKS cache knows it by then.
+	keyActivationDelay = 10 * time.Minute
+	keyRefreshInterval = time.Minute
+	keyClockSkew       = time.Minute
+	jwksMaxAge         = 5 * time.Minute
+
+	signingKeysAdvisoryLock int64 = 528105237
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported_signing_algorithm")
+	errNoSigningKey         = errors.New("no signing key")
+	errUnknownSigningKey    = errors.New("unknown signing key")
+)
+
+// SigningKey is one asymmetric key of a KeySet. Keys loaded without their
+// private half, for example after JWT_SECRET changed, only verify.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	ActivatesAt time.Time
+	RetiresAt   *time.Time
+	ExpiresAt   *time.Time
+	private     crypto.Signer
+	public      crypto.PublicKey
+}
+
+// SigningKeyInfo is the public description of a key for the admin API.
+type SigningKeyInfo struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiresAt   *time.Time `json:"retires_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Signing     bool       `json:"signing"`
+}
+
+// JWK is the public key in the JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+func GenerateSigningKey(algorithm string, activatesAt time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID: keyID(publicDER), Algorithm: algorithm, ActivatesAt: activatesAt,
+		private: private, public: private.Public(),
+	}, nil
+}
+
+// keyID derives the kid from the public key, so it is stable and unique.
+func keyID(publicDER []byte) string {
+	sum := sha256.Sum256(publicDER)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmES256 {
+		return jwt.SigningMethodES256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+func (k *SigningKey) signsAt(now time.Time) bool {
+	return k.private != nil && !k.ActivatesAt.After(now) && (k.RetiresAt == nil || k.RetiresAt.After(now))
+}
+
+func (k *SigningKey) verifiesAt(now time.Time) bool {
+	return k.ExpiresAt == nil || k.ExpiresAt.After(now)
+}
+
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Algorithm: k.Algorithm, Use: "sig"}
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *ecdsa.PublicKey:
+		// The uncompressed point is 0x04 followed by X and Y.
+		if point, err := public.Bytes(); err == nil && len(point) == 65 {
+			jwk.KeyType, jwk.Curve = "EC", "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(point[1:33])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(point[33:])
+		}
+	}
+	return jwk
+}
+
+// KeySet signs tokens with the one key active right now and verifies them
+// with every key still inside its validation window. Rotating publishes the
+// next key first and keeps the previous one valid for the longest token
+// lifetime after it stops signing, so nobody is logged out.
+//
+// Session tokens from before key sets are HS256 without a kid; they keep
+// working with the legacy secret until that is turned off.
+type KeySet struct {
+	dbx           *sql.DB
+	encryptionKey [32]byte
+	legacySecret  []byte
+	algorithm     string
+
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+func NewKeySet(dbx *sql.DB, secret []byte, algorithm string, acceptLegacyHS256 bool) *KeySet {
+	keys := &KeySet{
+		dbx:           dbx,
+		encryptionKey: sha256.Sum256(append([]byte("reup-signing-key:"), secret...)),
+		algorithm:     algorithm,
+	}
+	if acceptLegacyHS256 {
+		keys.legacySecret = secret
+	}
+	if keys.algorithm == "" {
+		keys.algorithm = AlgorithmEdDSA
+	}
+	return keys
+}
+
+// NewStaticKeySet keeps the given keys in memory only. Tests and tools use
+// it; legacySecret may be nil.
+func NewStaticKeySet(legacySecret []byte, keys ...*SigningKey) *KeySet {
+	return &KeySet{legacySecret: legacySecret, keys: keys}
+}
+
+// Load reads the keys and creates the first one when nothing can sign yet.
+func (k *KeySet) Load(ctx context.Context) error {
+	if err := k.reload(ctx); err != nil {
+		return err
+	}
+	if k.signingKey(time.Now()) != nil {
+		return nil
+	}
+	_, err := k.rotate(ctx, k.algorithm, 0)
+	return err
+}
+
+// Start re-reads the keys periodically so rotations made by another
+// instance are picked up.
+func (k *KeySet) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(keyRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := k.reload(ctx); err != nil {
+					log.Printf("[WARN] signing keys refresh failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Rotate publishes a new key that starts signing after keyActivationDelay.
+func (k *KeySet) Rotate(ctx context.Context, algorithm string) (*SigningKey, error) {
+	if algorithm == "" {
+		algorithm = k.algorithm
+	}
+	return k.rotate(ctx, algorithm, keyActivationDelay)
+}
+
+func (k *KeySet) rotate(ctx context.Context, algorithm string, delay time.Duration) (*SigningKey, error) {
+	if k.dbx == nil {
+		return nil, errors.New("static key set cannot rotate")
+	}
+	now := time.Now().UTC()
+	key, err := GenerateSigningKey(algorithm, now.Add(delay))
+	if err != nil {
+		return nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := k.seal(key.ID, privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := k.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeysAdvisoryLock); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth_signing_keys SET retires_at=$1, expires_at=$2 WHERE retires_at IS NULL
+	`, key.ActivatesAt, key.ActivatesAt.Add(tokenTTL+keyClockSkew)); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth_signing_keys WHERE expires_at < $1`, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO auth_signing_keys (kid, algorithm, public_key, private_key_ciphertext, activates_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, key.ID, key.Algorithm, publicDER, sealed, key.ActivatesAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, k.reload(ctx)
+}
+
+func (k *KeySet) reload(ctx context.Context) error {
+	if k.dbx == nil {
+		return nil
+	}
+	rows, err := k.dbx.QueryContext(ctx, `
+		SELECT kid, algorithm, public_key, private_key_ciphertext, activates_at, retires_at, expires_at
+		FROM auth_signing_keys
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY activates_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	keys := []*SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		var publicDER []byte
+		var sealed string
+		var retiresAt, expiresAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Algorithm, &publicDER, &sealed, &key.ActivatesAt, &retiresAt, &expiresAt); err != nil {
+			return err
+		}
+		if retiresAt.Valid {
+			key.RetiresAt = &retiresAt.Time
+		}
+		if expiresAt.Valid {
+			key.ExpiresAt = &expiresAt.Time
+		}
+		if key.public, err = x509.ParsePKIXPublicKey(publicDER); err != nil {
+			log.Printf("[WARN] signing key %s has an unreadable public key: %v", key.ID, err)
+			continue
+		}
+		if privateDER, err := k.open(key.ID, sealed); err == nil {
+			if parsed, err := x509.ParsePKCS8PrivateKey(privateDER); err == nil {
+				key.private, _ = parsed.(crypto.Signer)
+			}
+		}
+		keys = append(keys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *KeySet) signingKey(now time.Time) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signingKeyLocked(now)
+}
+
+func (k *KeySet) signingKeyLocked(now time.Time) *SigningKey {
+	for index := len(k.keys) - 1; index >= 0; index-- {
+		if k.keys[index].signsAt(now) {
+			return k.keys[index]
+		}
+	}
+	return nil
+}
+
+func (k *KeySet) verificationKey(id string, now time.Time) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == id && key.verifiesAt(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+// Sign signs the claims with the current key and puts its kid in the header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := k.signingKey(time.Now())
+	if key == nil {
+		return "", errNoSigningKey
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token issued by this API for the given audience.
+func (k *KeySet) Parse(tokenString string, claims jwt.Claims, audience string) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		id, _ := t.Header["kid"].(string)
+		if id == "" {
+			if t.Method.Alg() == jwt.SigningMethodHS256.Alg() && audience == sessionAudience && len(k.legacySecret) > 0 {
+				return k.legacySecret, nil
+			}
+			return nil, errUnknownSigningKey
+		}
+		key := k.verificationKey(id, time.Now())
+		if key == nil {
+			return nil, errUnknownSigningKey
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Method.Alg())
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmES256, jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(TokenIssuer), jwt.WithAudience(audience))
+	if err != nil {
+		return err
+	}
+	if token == nil || !token.Valid {
+		return errors.New("invalid token")
+	}
+	return nil
+}
+
+// JWKS lists every public key that may still verify a token, including a
+// rotated key that does not sign yet.
+func (k *KeySet) JWKS() []JWK {
+	now := time.Now()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := []JWK{}
+	for _, key := range k.keys {
+		if key.verifiesAt(now) {
+			keys = append(keys, key.JWK())
+		}
+	}
+	return keys
+}
+
+func (k *KeySet) Keys() []SigningKeyInfo {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	current := k.signingKeyLocked(time.Now())
+	items := []SigningKeyInfo{}
+	for _, key := range k.keys {
+		items = append(items, SigningKeyInfo{
+			ID: key.ID, Algorithm: key.Algorithm, ActivatesAt: key.ActivatesAt,
+			RetiresAt: key.RetiresAt, ExpiresAt: key.ExpiresAt, Signing: key == current,
+		})
+	}
+	sort.SliceStable(items, func(left, right int) bool { return items[left].ActivatesAt.After(items[right].ActivatesAt) })
+	return items
+}
+
+func (k *KeySet) JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+		writeOK(w, map[string]any{"keys": k.JWKS()})
+	}
+}
+
+// seal encrypts a private key for storage; the kid is bound as additional
+// data so ciphertexts cannot be swapped between rows.
+func (k *KeySet) seal(id string, plaintext []byte) (string, error) {
+	return sealWithKey(k.encryptionKey, []byte(id), plaintext)
+}
+
+func (k *KeySet) open(id string, encoded string) ([]byte, error) {
+	return openWithKey(k.encryptionKey, []byte(id), encoded)
+}
+
+// SigningKeysAdminHandler lists the keys on GET and rotates on POST. It is
+// guarded by AUTH_ADMIN_KEY like the other operator endpoints.
+func SigningKeysAdminHandler(keys *KeySet, adminKey string) http.HandlerFunc {
+	adminKey = strings.TrimSpace(adminKey)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminKey == "" {
+			writeAPIError(w, "auth_admin_not_configured", http.StatusServiceUnavailable)
+			return
+		}
+		provided := strings.TrimSpace(r.Header.Get("X-Auth-Admin-Key"))
+		if len(provided) != len(adminKey) || subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+			writeAPIError(w, "auth_admin_required", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeOK(w, map[string]any{"keys": keys.Keys()})
+		case http.MethodPost:
+			var body struct {
+				Algorithm string `json:"algorithm"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&body); err != nil {
+					writeAPIError(w, "invalid_json", http.StatusBadRequest)
+					return
+				}
+			}
+			key, err := keys.Rotate(r.Context(), strings.TrimSpace(body.Algorithm))
+			if errors.Is(err, ErrUnsupportedAlgorithm) {
+				writeAPIError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			if err != nil {
+				writeAPIError(w, "signing_key_rotation_failed", http.StatusInternalServerError)
+				return
+			}
+			audit.NewStore(keys.dbx).Log(r.Context(), audit.Event{
+				ActorEmail: "auth-admin", Action: audit.ActionSigningKeyRotated, TargetType: "signing_key", TargetID: key.ID,
+				After: map[string]any{"algorithm": key.Algorithm, "activates_at": key.ActivatesAt},
+			}.WithRequest(r))
+			writeOK(w, map[string]any{"ok": true, "kid": key.ID, "activates_at": key.ActivatesAt, "keys": keys.Keys()})
+		default:
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testKeySet(t *testing.T) *KeySet {
+	t.Helper()
+	key, err := GenerateSigningKey(AlgorithmEdDSA, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	return NewStaticKeySet(nil, key)
+}
+
+func TestKeySetSignsWithKidAndVerifiesDuringRotation(t *testing.T) {
+	now := time.Now()
+	rotatedAt := now.Add(-time.Minute)
+	expiresAt := rotatedAt.Add(tokenTTL)
+	previous, err := GenerateSigningKey(AlgorithmEdDSA, now.Add(-48*time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	current, err := GenerateSigningKey(AlgorithmES256, rotatedAt)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	next, err := GenerateSigningKey(AlgorithmEdDSA, now.Add(keyActivationDelay))
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+
+	before := NewStaticKeySet(nil, previous)
+	oldToken, err := GenerateSessionToken(before, 42, 1, "device-1")
+	if err != nil {
+		t.Fatalf("sign with the previous key: %v", err)
+	}
+
+	previous.RetiresAt, previous.ExpiresAt = &rotatedAt, &expiresAt
+	keys := NewStaticKeySet(nil, previous, current, next)
+	token, err := GenerateSessionToken(keys, 42, 1, "device-2")
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &SessionClaims{})
+	if err != nil || parsed.Header["kid"] != current.ID || parsed.Method.Alg() != AlgorithmES256 {
+		t.Fatalf("token header = %v, %v; want the current key", parsed.Header, err)
+	}
+	for _, value := range []string{oldToken, token} {
+		if _, err := ParseTokenClaims(keys, value); err != nil {
+			t.Fatalf("token must verify during rotation: %v", err)
+		}
+	}
+
+	expired := now.Add(-time.Second)
+	previous.ExpiresAt = &expired
+	if _, err := ParseTokenClaims(keys, oldToken); err == nil {
+		t.Fatal("token of an expired key must be rejected")
+	}
+	if _, err := ParseTokenClaims(NewStaticKeySet(nil, next), token); err == nil {
+		t.Fatal("token of an unknown key must be rejected")
+	}
+}
+
+func TestKeySetLegacyHS256(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	claims := SessionClaims{
+		UserID: 42, AuthVersion: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: TokenIssuer, Audience: jwt.ClaimStrings{sessionAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("sign legacy token: %v", err)
+	}
+	key, _ := GenerateSigningKey(AlgorithmEdDSA, time.Now().Add(-time.Hour))
+	if _, err := ParseTokenClaims(NewStaticKeySet(secret, key), legacy); err != nil {
+		t.Fatalf("legacy token must verify while accepted: %v", err)
+	}
+	if _, err := ParseTokenClaims(NewStaticKeySet(nil, key), legacy); err == nil {
+		t.Fatal("legacy token must be rejected once turned off")
+	}
+	claims.Audience = jwt.ClaimStrings{"reupgoals-agent-runtime"}
+	other, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err := NewStaticKeySet(secret, key).Parse(other, &SessionClaims{}, "reupgoals-agent-runtime"); err == nil {
+		t.Fatal("the legacy secret only covers session tokens")
+	}
+}
+
+func TestKeySetWithoutSigningKey(t *testing.T) {
+	pending, _ := GenerateSigningKey(AlgorithmEdDSA, time.Now().Add(time.Hour))
+	if _, err := GenerateToken(NewStaticKeySet(nil, pending), 42); !errors.Is(err, errNoSigningKey) {
+		t.Fatalf("sign before activation err = %v", err)
+	}
+	if _, err := GenerateSigningKey("HS256", time.Now()); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("GenerateSigningKey(HS256) err = %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edKey, _ := GenerateSigningKey(AlgorithmEdDSA, time.Now())
+	ecKey, _ := GenerateSigningKey(AlgorithmES256, time.Now())
+	expired := time.Now().Add(-time.Second)
+	oldKey, _ := GenerateSigningKey(AlgorithmEdDSA, time.Now().Add(-tokenTTL))
+	oldKey.ExpiresAt = &expired
+
+	jwks := NewStaticKeySet(nil, oldKey, edKey, ecKey).JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS = %+v, want the two unexpired keys", jwks)
+	}
+	ed := jwks[0]
+	x, _ := base64.RawURLEncoding.DecodeString(ed.X)
+	if ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.KeyID != edKey.ID || !ed25519.PublicKey(x).Equal(edKey.public) {
+		t.Fatalf("Ed25519 JWK = %+v", ed)
+	}
+	ec := jwks[1]
+	y, _ := base64.RawURLEncoding.DecodeString(ec.Y)
+	point, _ := ecKey.public.(*ecdsa.PublicKey).Bytes()
+	if ec.KeyType != "EC" || ec.Curve != "P-256" || ec.Algorithm != AlgorithmES256 || string(y) != string(point[33:]) {
+		t.Fatalf("P-256 JWK = %+v", ec)
+	}
+}
+
+func TestKeySetSealIsBoundToKeyAndSecret(t *testing.T) {
+	keys := NewKeySet(nil, []byte(strings.Repeat("s", 32)), "", false)
+	sealed, err := keys.seal("kid-1", []byte("private key"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if plain, err := keys.open("kid-1", sealed); err != nil || string(plain) != "private key" {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+	if _, err := keys.open("kid-2", sealed); err == nil {
+		t.Fatal("ciphertext must not open under another kid")
+	}
+	if _, err := NewKeySet(nil, []byte(strings.Repeat("x", 32)), "", false).open("kid-1", sealed); err == nil {
+		t.Fatal("ciphertext must not open with another secret")
+	}
+}
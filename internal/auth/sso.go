@@ -0,0 +1,914 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/audit"
+	"reup-goals-backend/internal/auth/oidc"
+	"reup-goals-backend/internal/config"
+	"reup-goals-backend/internal/legal"
+	"reup-goals-backend/internal/v2/workspaces"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ssoStateTTL        = 10 * time.Minute
+	ssoStateCookieName = "reupgoals_sso_state"
+	ssoStateCookiePath = "/auth/sso"
+	ssoLinkTTL         = 15 * time.Minute
+	maxSSODomains      = 20
+
+	// A domain is verified by a TXT record on its _reup-verification
+	// subdomain carrying the workspace's token.
+	ssoVerificationRecordPrefix = "_reup-verification."
+	ssoVerificationValuePrefix  = "reup-domain-verification="
+)
+
+var (
+	ErrSSONotConfigured      = errors.New("sso_not_configured")
+	ErrSSOConfigInvalid      = errors.New("sso_config_invalid")
+	ErrSSODomainInvalid      = errors.New("sso_domain_invalid")
+	ErrSSODomainTaken        = errors.New("sso_domain_taken")
+	ErrSSODomainNotVerified  = errors.New("sso_domain_not_verified")
+	ErrSSOProviderFailed     = errors.New("sso_provider_unavailable")
+	ErrSSORequired           = errors.New("sso_required")
+	ErrSSOLinkInvalid        = errors.New("sso_link_invalid")
+	errSSOStateInvalid       = errors.New("sso_state_invalid")
+	errSSOEmailNotVerified   = errors.New("sso_email_not_verified")
+	errSSOEmailNotAllowed    = errors.New("sso_email_not_allowed")
+	errSSOLinkRequired       = errors.New("sso_link_required")
+	errSSOMemberLimitReached = errors.New("sso_member_limit_reached")
+)
+
+var ssoDomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// publicEmailDomains cannot be claimed by a workspace: anyone can register a
+// mailbox there, so the domain says nothing about who employs the user.
+var publicEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yandex.ru": true, "ya.ru": true, "yandex.com": true,
+	"mail.ru": true, "bk.ru": true, "inbox.ru": true, "list.ru": true, "rambler.ru": true,
+	"outlook.com": true, "hotmail.com": true, "live.com": true, "icloud.com": true, "me.com": true,
+	"yahoo.com": true, "proton.me": true, "protonmail.com": true,
+}
+
+// SSOConnection is a workspace's OpenID Connect provider. The client secret is
+// write-only and sealed at rest with the DATA_ENCRYPTION_KEY master key. Only
+// verified domains route sign-ins to the provider and are enforced.
+type SSOConnection struct {
+	WorkspaceID     int         `json:"workspace_id"`
+	Issuer          string      `json:"issuer"`
+	ClientID        string      `json:"client_id"`
+	HasClientSecret bool        `json:"has_client_secret"`
+	Domains         []string    `json:"domains"`
+	DomainStatus    []SSODomain `json:"domain_status"`
+	Enforced        bool        `json:"enforced"`
+	AutoJoin        bool        `json:"auto_join"`
+	RedirectURI     string      `json:"redirect_uri"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+
+	clientSecret string
+}
+
+// SSODomain is a domain the workspace claimed, with the TXT record that
+// proves it owns the domain.
+type SSODomain struct {
+	Domain      string     `json:"domain"`
+	Verified    bool       `json:"verified"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	RecordName  string     `json:"record_name"`
+	RecordValue string     `json:"record_value"`
+}
+
+// SSOConnectionInput replaces a workspace's connection. A nil ClientSecret
+// keeps the stored one.
+type SSOConnectionInput struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret *string  `json:"client_secret"`
+	Domains      []string `json:"domains"`
+	Enforced     bool     `json:"enforced"`
+	AutoJoin     bool     `json:"auto_join"`
+}
+
+// SSOService runs OpenID Connect sign-in for workspaces that configured a
+// provider. The provider is picked by the email domain the user types in.
+type SSOService struct {
+	dbx          *sql.DB
+	keys         *KeySet
+	secureCookie bool
+	client       *oidc.Client
+	redirectURL  string
+	frontendURL  string
+	monitor      *SignInMonitor
+	lookupTXT    func(ctx context.Context, name string) ([]string, error)
+}
+
+func NewSSOService(dbx *sql.DB, keys *KeySet, secureCookie bool, cfg *config.Config) *SSOService {
+	return &SSOService{
+		dbx: dbx, keys: keys, secureCookie: secureCookie, client: oidc.NewClient(nil),
+		redirectURL: cfg.OIDCRedirectURL, frontendURL: strings.TrimRight(cfg.FrontendBaseURL, "/"),
+		lookupTXT: net.DefaultResolver.LookupTXT,
+	}
+}
+
+// WithSignInMonitor rates SSO sign-ins like password ones.
+func (s *SSOService) WithSignInMonitor(monitor *SignInMonitor) *SSOService {
+	s.monitor = monitor
+	return s
+}
+
+// WithClient swaps the OpenID Connect client, e.g. for one with a custom
+// HTTP transport.
+func (s *SSOService) WithClient(client *oidc.Client) *SSOService {
+	s.client = client
+	return s
+}
+
+// WithTXTResolver swaps the DNS lookup used to verify domains.
+func (s *SSOService) WithTXTResolver(lookupTXT func(ctx context.Context, name string) ([]string, error)) *SSOService {
+	s.lookupTXT = lookupTXT
+	return s
+}
+
+// NormalizeSSODomains validates, de-duplicates and sorts email domains.
+func NormalizeSSODomains(domains []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+		if len(domain) > 253 || !ssoDomainPattern.MatchString(domain) || publicEmailDomains[domain] {
+			return nil, ErrSSODomainInvalid
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			result = append(result, domain)
+		}
+	}
+	if len(result) == 0 || len(result) > maxSSODomains {
+		return nil, ErrSSODomainInvalid
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (s *SSOService) Connection(ctx context.Context, workspaceID int) (SSOConnection, error) {
+	var connection SSOConnection
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT workspace_id, issuer, client_id, client_secret, enforced, auto_join, updated_at
+		FROM workspace_sso_connections
+		WHERE workspace_id=$1
+	`, workspaceID).Scan(
+		&connection.WorkspaceID, &connection.Issuer, &connection.ClientID, &connection.clientSecret,
+		&connection.Enforced, &connection.AutoJoin, &connection.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SSOConnection{}, ErrSSONotConfigured
+	}
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	if connection.clientSecret, err = openSecret("sso_client_secret", workspaceID, connection.clientSecret); err != nil {
+		// A secret sealed with a master key that is no longer configured is
+		// reported as missing, so an admin can enter it again instead of the
+		// settings page failing.
+		log.Printf("[WARN] sso client secret for workspace %d cannot be opened: %v", workspaceID, err)
+		connection.clientSecret = ""
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT domain, verification_token, verified_at
+		FROM workspace_sso_domains
+		WHERE workspace_id=$1
+		ORDER BY domain
+	`, workspaceID)
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	defer rows.Close()
+	connection.Domains = []string{}
+	connection.DomainStatus = []SSODomain{}
+	for rows.Next() {
+		var domain SSODomain
+		var token string
+		var verifiedAt sql.NullTime
+		if err := rows.Scan(&domain.Domain, &token, &verifiedAt); err != nil {
+			return SSOConnection{}, err
+		}
+		if verifiedAt.Valid {
+			domain.Verified = true
+			domain.VerifiedAt = &verifiedAt.Time
+		}
+		domain.RecordName = ssoVerificationRecordPrefix + domain.Domain
+		domain.RecordValue = ssoVerificationValuePrefix + token
+		connection.Domains = append(connection.Domains, domain.Domain)
+		connection.DomainStatus = append(connection.DomainStatus, domain)
+	}
+	connection.HasClientSecret = connection.clientSecret != ""
+	connection.RedirectURI = s.redirectURL
+	return connection, rows.Err()
+}
+
+// SaveConnection stores the workspace's provider after checking that its
+// discovery document resolves. New domains start unverified; any workspace
+// may claim one, but only the first to verify it with VerifyDomain gets it,
+// so claiming a domain cannot lock its owner out.
+func (s *SSOService) SaveConnection(ctx context.Context, workspaceID int, userID int, input SSOConnectionInput) (SSOConnection, error) {
+	issuer, err := oidc.NormalizeIssuer(input.Issuer)
+	if err != nil {
+		return SSOConnection{}, ErrSSOConfigInvalid
+	}
+	clientID := strings.TrimSpace(input.ClientID)
+	if clientID == "" || len(clientID) > 512 {
+		return SSOConnection{}, ErrSSOConfigInvalid
+	}
+	var clientSecret *string
+	if input.ClientSecret != nil {
+		value := strings.TrimSpace(*input.ClientSecret)
+		if len(value) > 1024 {
+			return SSOConnection{}, ErrSSOConfigInvalid
+		}
+		if value != "" {
+			if value, err = sealSecret("sso_client_secret", workspaceID, value); err != nil {
+				return SSOConnection{}, err
+			}
+		}
+		clientSecret = &value
+	}
+	domains, err := NormalizeSSODomains(input.Domains)
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	if _, err := s.client.Discover(ctx, issuer); err != nil {
+		return SSOConnection{}, ErrSSOProviderFailed
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO workspace_sso_connections
+			(workspace_id, issuer, client_id, client_secret, enforced, auto_join, updated_by)
+		VALUES ($1, $2, $3, COALESCE($4, ''), $5, $6, $7)
+		ON CONFLICT (workspace_id) DO UPDATE SET
+			issuer=EXCLUDED.issuer,
+			client_id=EXCLUDED.client_id,
+			client_secret=COALESCE($4, workspace_sso_connections.client_secret),
+			enforced=EXCLUDED.enforced,
+			auto_join=EXCLUDED.auto_join,
+			updated_by=EXCLUDED.updated_by,
+			updated_at=NOW()
+	`, workspaceID, issuer, clientID, clientSecret, input.Enforced, input.AutoJoin, userID); err != nil {
+		return SSOConnection{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM workspace_sso_domains WHERE workspace_id=$1 AND NOT (domain = ANY($2))
+	`, workspaceID, pq.Array(domains)); err != nil {
+		return SSOConnection{}, err
+	}
+	for _, domain := range domains {
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM workspace_sso_domains
+				WHERE domain=$1 AND workspace_id<>$2 AND verified_at IS NOT NULL
+			)
+		`, domain, workspaceID).Scan(&taken); err != nil {
+			return SSOConnection{}, err
+		}
+		if taken {
+			return SSOConnection{}, ErrSSODomainTaken
+		}
+		token, err := oidc.RandomString()
+		if err != nil {
+			return SSOConnection{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO workspace_sso_domains (domain, workspace_id, verification_token)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (workspace_id, domain) DO NOTHING
+		`, domain, workspaceID, token); err != nil {
+			return SSOConnection{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return SSOConnection{}, err
+	}
+	return s.Connection(ctx, workspaceID)
+}
+
+// VerifyDomain looks up the domain's TXT record and marks the domain
+// verified for the workspace when it carries the workspace's token.
+func (s *SSOService) VerifyDomain(ctx context.Context, workspaceID int, domain string) (SSOConnection, error) {
+	normalized, err := NormalizeSSODomains([]string{domain})
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	domain = normalized[0]
+	var token string
+	var verified bool
+	err = s.dbx.QueryRowContext(ctx, `
+		SELECT verification_token, verified_at IS NOT NULL
+		FROM workspace_sso_domains
+		WHERE workspace_id=$1 AND domain=$2
+	`, workspaceID, domain).Scan(&token, &verified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SSOConnection{}, ErrSSODomainInvalid
+	}
+	if err != nil {
+		return SSOConnection{}, err
+	}
+	if !verified {
+		records, err := s.lookupTXT(ctx, ssoVerificationRecordPrefix+domain)
+		if err != nil || !hasSSOVerificationRecord(records, token) {
+			return SSOConnection{}, ErrSSODomainNotVerified
+		}
+		if _, err := s.dbx.ExecContext(ctx, `
+			UPDATE workspace_sso_domains SET verified_at=NOW()
+			WHERE workspace_id=$1 AND domain=$2 AND verified_at IS NULL
+		`, workspaceID, domain); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return SSOConnection{}, ErrSSODomainTaken
+			}
+			return SSOConnection{}, err
+		}
+	}
+	return s.Connection(ctx, workspaceID)
+}
+
+func hasSSOVerificationRecord(records []string, token string) bool {
+	expected := ssoVerificationValuePrefix + token
+	for _, record := range records {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(record)), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SSOService) DeleteConnection(ctx context.Context, workspaceID int) error {
+	result, err := s.dbx.ExecContext(ctx, `DELETE FROM workspace_sso_connections WHERE workspace_id=$1`, workspaceID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSSONotConfigured
+	}
+	return nil
+}
+
+// SSORequiredForPassword reports whether a password sign-in must be refused
+// because a workspace the user belongs to enforces SSO for their email
+// domain. The workspace owner keeps password access so a broken provider
+// cannot lock everyone out.
+func SSORequiredForPassword(ctx context.Context, dbx sqlQueryer, userID int, email string) (bool, error) {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false, nil
+	}
+	var required bool
+	err := dbx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM workspace_sso_domains domain
+			JOIN workspace_sso_connections connection ON connection.workspace_id=domain.workspace_id
+			JOIN workspaces workspace ON workspace.id=connection.workspace_id
+			JOIN workspace_memberships membership
+				ON membership.workspace_id=workspace.id AND membership.user_id=$1 AND membership.status='active'
+			WHERE domain.domain=$2
+				AND domain.verified_at IS NOT NULL
+				AND connection.enforced
+				AND workspace.status='active'
+				AND workspace.owner_user_id<>$1
+		)
+	`, userID, domain).Scan(&required)
+	return required, err
+}
+
+// StartHandler begins a sign-in for the email's domain. GET redirects the
+// browser to the provider; POST returns the URL for clients that navigate
+// themselves.
+func (s *SSOService) StartHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rawEmail string
+		switch r.Method {
+		case http.MethodGet:
+			rawEmail = r.URL.Query().Get("email")
+		case http.MethodPost:
+			var body struct {
+				Email string `json:"email"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeAPIError(w, "invalid_json", http.StatusBadRequest)
+				return
+			}
+			rawEmail = body.Email
+		default:
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		email, ok := normalizeAndValidateEmail(rawEmail)
+		if !ok {
+			writeAPIError(w, "invalid_email", http.StatusBadRequest)
+			return
+		}
+		_, domain, _ := strings.Cut(email, "@")
+		var workspaceID int
+		err := s.dbx.QueryRowContext(r.Context(), `
+			SELECT domain.workspace_id
+			FROM workspace_sso_domains domain
+			JOIN workspaces workspace ON workspace.id=domain.workspace_id AND workspace.status='active'
+			WHERE domain.domain=$1 AND domain.verified_at IS NOT NULL
+		`, domain).Scan(&workspaceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, ErrSSONotConfigured.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		connection, err := s.Connection(r.Context(), workspaceID)
+		if err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		provider, err := s.client.Discover(r.Context(), connection.Issuer)
+		if err != nil {
+			writeAPIError(w, ErrSSOProviderFailed.Error(), http.StatusBadGateway)
+			return
+		}
+		state, err := oidc.RandomString()
+		if err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		nonce, err := oidc.RandomString()
+		if err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		verifier, challenge, err := oidc.NewPKCE()
+		if err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		if _, err := s.dbx.ExecContext(r.Context(), `
+			WITH expired AS (
+				DELETE FROM auth_sso_states WHERE expires_at < NOW() - INTERVAL '1 day'
+			)
+			INSERT INTO auth_sso_states (state_hash, workspace_id, nonce, code_verifier, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, hashSecretToken(state), workspaceID, nonce, verifier, time.Now().Add(ssoStateTTL)); err != nil {
+			writeAPIError(w, "sso_start_failed", http.StatusInternalServerError)
+			return
+		}
+		setSSOStateCookie(w, state, s.secureCookie)
+
+		authorizationURL := provider.AuthCodeURL(oidc.AuthRequest{
+			ClientID: connection.ClientID, RedirectURI: s.redirectURL, State: state, Nonce: nonce,
+			CodeChallenge: challenge, LoginHint: email,
+		})
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, authorizationURL, http.StatusFound)
+			return
+		}
+		writeOK(w, map[string]any{"authorization_url": authorizationURL})
+	}
+}
+
+// CallbackHandler finishes the sign-in the provider redirected back from and
+// sends the browser to the app with a fresh session, to the login page with
+// an sso_link token when an existing account has to confirm the link, or to
+// the login page with an sso_error code. Local TOTP is not asked for: the provider owns
+// the second factor for SSO users.
+func (s *SSOService) CallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		clearSSOStateCookie(w, s.secureCookie)
+		if query.Get("error") != "" {
+			s.redirectToLogin(w, r, "sso_denied")
+			return
+		}
+		state := query.Get("state")
+		cookie, err := r.Cookie(ssoStateCookieName)
+		if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			s.redirectToLogin(w, r, errSSOStateInvalid.Error())
+			return
+		}
+		var workspaceID int
+		var nonce, verifier string
+		err = s.dbx.QueryRowContext(r.Context(), `
+			UPDATE auth_sso_states
+			SET used_at=NOW()
+			WHERE state_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+			RETURNING workspace_id, nonce, code_verifier
+		`, hashSecretToken(state)).Scan(&workspaceID, &nonce, &verifier)
+		if err != nil {
+			s.redirectToLogin(w, r, errSSOStateInvalid.Error())
+			return
+		}
+		connection, err := s.Connection(r.Context(), workspaceID)
+		if err != nil {
+			s.redirectToLogin(w, r, ErrSSONotConfigured.Error())
+			return
+		}
+		provider, err := s.client.Discover(r.Context(), connection.Issuer)
+		if err != nil {
+			s.redirectToLogin(w, r, ErrSSOProviderFailed.Error())
+			return
+		}
+		idToken, err := s.client.Exchange(r.Context(), provider, oidc.Credentials{
+			ClientID: connection.ClientID, ClientSecret: connection.clientSecret, RedirectURI: s.redirectURL,
+		}, query.Get("code"), verifier)
+		if err != nil {
+			s.redirectToLogin(w, r, "sso_failed")
+			return
+		}
+		claims, err := s.client.VerifyIDToken(r.Context(), provider, connection.ClientID, idToken, nonce)
+		if err != nil {
+			s.redirectToLogin(w, r, "sso_failed")
+			return
+		}
+		userID, authVersion, linkToken, err := s.resolveUser(r.Context(), connection, claims)
+		if err == nil && linkToken != "" {
+			http.Redirect(w, r, s.frontendURL+"/login?sso_link="+url.QueryEscape(linkToken), http.StatusFound)
+			return
+		}
+		if err != nil {
+			code := "sso_failed"
+			for _, known := range []error{errSSOEmailNotVerified, errSSOEmailNotAllowed, ErrSSOLinkInvalid, errSSOMemberLimitReached} {
+				if errors.Is(err, known) {
+					code = known.Error()
+				}
+			}
+			recordWorkspaceAccountEvent(r, s.dbx, &workspaceID, 0, claims.Email, audit.ActionLoginFailed, map[string]any{
+				"method": "sso", "reason": code,
+			})
+			s.redirectToLogin(w, r, code)
+			return
+		}
+		session, err := issueSession(w, r, s.dbx, s.keys, userID, authVersion, s.secureCookie)
+		if err != nil {
+			s.redirectToLogin(w, r, "sso_failed")
+			return
+		}
+		risk := s.monitor.RecordSuccess(w, r, userID, "sso")
+		recordWorkspaceAccountEvent(r, s.dbx, &workspaceID, userID, "", audit.ActionLoginSucceeded, map[string]any{
+			"method": "sso", "session_id": session.SessionID, "risk": risk,
+		})
+		http.Redirect(w, r, s.frontendURL+"/", http.StatusFound)
+	}
+}
+
+// resolveUser maps the provider identity to a user: a known identity signs
+// in its user and an unknown email gets a new user just in time. An email
+// that already has an account is never signed in on the word of a provider
+// a workspace picked; a link request token is returned instead, which the
+// user confirms with the account's own credentials in LinkHandler.
+func (s *SSOService) resolveUser(ctx context.Context, connection SSOConnection, claims oidc.Claims) (int, int, string, error) {
+	if !claims.EmailVerified {
+		return 0, 0, "", errSSOEmailNotVerified
+	}
+	email, ok := normalizeAndValidateEmail(claims.Email)
+	if !ok || !connection.allowsEmail(email) {
+		return 0, 0, "", errSSOEmailNotAllowed
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer tx.Rollback()
+	var userID, authVersion int
+	err = tx.QueryRowContext(ctx, `
+		SELECT users.id, users.auth_version
+		FROM auth_identities identity
+		JOIN users ON users.id=identity.user_id
+		WHERE identity.issuer=$1 AND identity.subject=$2
+	`, claims.Issuer, claims.Subject).Scan(&userID, &authVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		userID, authVersion, err = createSSOUser(ctx, tx, email, claims)
+	}
+	if errors.Is(err, errSSOLinkRequired) {
+		token, err := createSSOLinkRequest(ctx, tx, connection.WorkspaceID, userID, email, claims)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		return 0, 0, token, tx.Commit()
+	}
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if err := attachSSOIdentity(ctx, tx, connection, userID, email, claims.Issuer, claims.Subject); err != nil {
+		return 0, 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, "", err
+	}
+	return userID, authVersion, "", nil
+}
+
+// createSSOUser creates the user for a new email. For an email that already
+// has an account it returns that account's id with errSSOLinkRequired.
+func createSSOUser(ctx context.Context, tx *sql.Tx, email string, claims oidc.Claims) (int, int, error) {
+	var userID, authVersion int
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, auth_version FROM users WHERE email=$1
+	`, email).Scan(&userID, &authVersion)
+	if err == nil {
+		return userID, authVersion, errSSOLinkRequired
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	passwordHash, err := UnusablePasswordHash()
+	if err != nil {
+		return 0, 0, err
+	}
+	subjectKey, err := legal.NewSubjectKey()
+	if err != nil {
+		return 0, 0, err
+	}
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (email, password, privacy_subject_id, name, email_verified, workspace_onboarding_mode)
+		VALUES ($1, $2, $3, $4, TRUE, $5)
+		RETURNING id, auth_version
+	`, email, passwordHash, subjectKey, truncateRunes(name, 200), workspaceOnboardingCreate).Scan(&userID, &authVersion)
+	return userID, authVersion, err
+}
+
+func createSSOLinkRequest(ctx context.Context, tx *sql.Tx, workspaceID int, userID int, email string, claims oidc.Claims) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		WITH expired AS (
+			DELETE FROM auth_sso_link_requests WHERE expires_at < NOW() - INTERVAL '1 day'
+		)
+		INSERT INTO auth_sso_link_requests (token_hash, user_id, workspace_id, issuer, subject, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, hashSecretToken(token), userID, workspaceID, claims.Issuer, claims.Subject, email, time.Now().Add(ssoLinkTTL))
+	return token, err
+}
+
+// attachSSOIdentity records the provider identity for the user and, when the
+// connection auto-joins, adds them to the workspace. An identity already
+// attached to someone else is refused.
+func attachSSOIdentity(ctx context.Context, tx *sql.Tx, connection SSOConnection, userID int, email, issuer, subject string) error {
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO auth_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO UPDATE SET email=EXCLUDED.email, last_login_at=NOW()
+		WHERE auth_identities.user_id=EXCLUDED.user_id
+	`, userID, issuer, subject, email)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSSOLinkInvalid
+	}
+	if connection.AutoJoin {
+		return joinSSOWorkspace(ctx, tx, connection.WorkspaceID, userID, email)
+	}
+	return nil
+}
+
+// LinkHandler completes a link request from the callback. The user proves
+// they own the existing account with its password, and its second factor
+// when enrolled, before the provider identity is attached and signs them
+// in. Accounts without a password cannot be linked this way.
+func (s *SSOService) LinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			LinkToken     string `json:"link_token"`
+			Password      string `json:"password"`
+			TwoFactorCode string `json:"two_factor_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, "invalid_json", http.StatusBadRequest)
+			return
+		}
+		password := normalizeSecret(body.Password)
+		if strings.TrimSpace(body.LinkToken) == "" {
+			writeAPIError(w, ErrSSOLinkInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		tokenHash := hashSecretToken(strings.TrimSpace(body.LinkToken))
+		var userID, workspaceID int
+		var issuer, subject, email string
+		err := s.dbx.QueryRowContext(r.Context(), `
+			SELECT user_id, workspace_id, issuer, subject, email
+			FROM auth_sso_link_requests
+			WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+		`, tokenHash).Scan(&userID, &workspaceID, &issuer, &subject, &email)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeAPIError(w, ErrSSOLinkInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+			return
+		}
+		retryAfter, err := s.monitor.CheckLogin(r.Context(), email)
+		if err != nil {
+			writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+			return
+		}
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeAPIError(w, ErrLoginLocked.Error(), http.StatusTooManyRequests)
+			return
+		}
+		var storedPassword string
+		var authVersion int
+		err = s.dbx.QueryRowContext(r.Context(), `
+			SELECT password, auth_version FROM users WHERE id=$1
+		`, userID).Scan(&storedPassword, &authVersion)
+		if err != nil || password == "" || len(password) > 1024 || !passwordMatches(storedPassword, password) {
+			s.monitor.RecordFailure(r, email, userID)
+			recordWorkspaceAccountEvent(r, s.dbx, &workspaceID, userID, email, audit.ActionLoginFailed, map[string]any{
+				"method": "sso", "reason": "sso_link_invalid_credentials",
+			})
+			writeAPIError(w, "invalid_credentials", http.StatusUnauthorized)
+			return
+		}
+		twoFactor, err := twoFactorEnabled(r.Context(), s.dbx, userID)
+		if err != nil {
+			writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+			return
+		}
+		if twoFactor {
+			if err := verifySecondFactor(r.Context(), s.dbx, userID, body.TwoFactorCode); errors.Is(err, ErrTwoFactorInvalidCode) {
+				s.monitor.RecordUserFailure(r, userID)
+				recordWorkspaceAccountEvent(r, s.dbx, &workspaceID, userID, email, audit.ActionLoginFailed, map[string]any{
+					"method": "sso", "reason": err.Error(),
+				})
+				writeAPIError(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+				return
+			}
+		}
+		connection, err := s.Connection(r.Context(), workspaceID)
+		if errors.Is(err, ErrSSONotConfigured) {
+			writeAPIError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+			return
+		}
+		if err := s.linkIdentity(r.Context(), connection, tokenHash, userID, email, issuer, subject); err != nil {
+			switch {
+			case errors.Is(err, ErrSSOLinkInvalid):
+				writeAPIError(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, errSSOMemberLimitReached):
+				writeAPIError(w, err.Error(), http.StatusConflict)
+			default:
+				writeAPIError(w, "sso_link_failed", http.StatusInternalServerError)
+			}
+			return
+		}
+		session, err := issueSession(w, r, s.dbx, s.keys, userID, authVersion, s.secureCookie)
+		if err != nil {
+			writeAPIError(w, "token_generation_failed", http.StatusInternalServerError)
+			return
+		}
+		risk := s.monitor.RecordSuccess(w, r, userID, "sso")
+		recordWorkspaceAccountEvent(r, s.dbx, &workspaceID, userID, "", audit.ActionLoginSucceeded, map[string]any{
+			"method": "sso", "identity_linked": true, "session_id": session.SessionID, "risk": risk,
+		})
+		writeOK(w, map[string]any{"user_id": userID, "session_id": session.SessionID})
+	}
+}
+
+func (s *SSOService) linkIdentity(ctx context.Context, connection SSOConnection, tokenHash string, userID int, email, issuer, subject string) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `
+		UPDATE auth_sso_link_requests SET used_at=NOW()
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW()
+	`, tokenHash)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSSOLinkInvalid
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET email_verified=TRUE WHERE id=$1`, userID); err != nil {
+		return err
+	}
+	if err := attachSSOIdentity(ctx, tx, connection, userID, email, issuer, subject); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// joinSSOWorkspace adds the user as a member and makes the workspace their
+// current one when a seat is free. Existing memberships, including removed
+// ones, are left alone so an admin's removal sticks.
+func joinSSOWorkspace(ctx context.Context, tx *sql.Tx, workspaceID int, userID int, email string) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM workspace_memberships WHERE workspace_id=$1 AND user_id=$2)
+	`, workspaceID, userID).Scan(&exists); err != nil || exists {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID); err != nil {
+		return err
+	}
+	if err := workspaces.CheckSeat(ctx, tx, workspaceID, email); errors.Is(err, workspaces.ErrMemberLimitReached) {
+		return errSSOMemberLimitReached
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workspace_memberships SET is_default=FALSE, updated_at=NOW()
+		WHERE user_id=$1 AND status='active'
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO workspace_memberships (workspace_id, user_id, role, status, is_default)
+		VALUES ($1, $2, 'member', 'active', TRUE)
+	`, workspaceID, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE users SET workspace_onboarding_mode='complete' WHERE id=$1
+	`, userID)
+	return err
+}
+
+func (connection SSOConnection) allowsEmail(email string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, allowed := range connection.DomainStatus {
+		if allowed.Verified && domain == allowed.Domain {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SSOService) redirectToLogin(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, s.frontendURL+"/login?sso_error="+url.QueryEscape(code), http.StatusFound)
+}
+
+func setSSOStateCookie(w http.ResponseWriter, state string, secure bool) {
+	// #nosec G124 -- secure is always true in staging/production; false supports local HTTP development.
+	http.SetCookie(w, &http.Cookie{
+		Name: ssoStateCookieName, Value: state, Path: ssoStateCookiePath, HttpOnly: true, Secure: secure,
+		SameSite: http.SameSiteLaxMode, MaxAge: int(ssoStateTTL.Seconds()),
+	})
+}
+
+func clearSSOStateCookie(w http.ResponseWriter, secure bool) {
+	// #nosec G124 -- deletion must use the same Secure attribute as the original cookie.
+	http.SetCookie(w, &http.Cookie{
+		Name: ssoStateCookieName, Value: "", Path: ssoStateCookiePath, HttpOnly: true, Secure: secure,
+		SameSite: http.SameSiteLaxMode, MaxAge: -1, Expires: time.Unix(1, 0),
+	})
+}
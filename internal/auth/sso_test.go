@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"reup-goals-backend/internal/encryption"
+)
+
+func TestNormalizeSSODomains(t *testing.T) {
+	domains, err := NormalizeSSODomains([]string{" Example.com ", "@corp.example.ru", "example.com"})
+	if err != nil {
+		t.Fatalf("NormalizeSSODomains err = %v", err)
+	}
+	if len(domains) != 2 || domains[0] != "corp.example.ru" || domains[1] != "example.com" {
+		t.Fatalf("domains = %v", domains)
+	}
+	for _, invalid := range [][]string{nil, {"localhost"}, {"-bad.com"}, {"exa mple.com"}, {"gmail.com"}, {"Yandex.ru"}} {
+		if _, err := NormalizeSSODomains(invalid); !errors.Is(err, ErrSSODomainInvalid) {
+			t.Fatalf("NormalizeSSODomains(%v) err = %v", invalid, err)
+		}
+	}
+}
+
+func TestSSOConnectionAllowsOnlyItsVerifiedDomains(t *testing.T) {
+	connection := SSOConnection{DomainStatus: []SSODomain{
+		{Domain: "example.com", Verified: true},
+		{Domain: "claimed.example", Verified: false},
+	}}
+	if !connection.allowsEmail("anna@example.com") {
+		t.Fatalf("own domain rejected")
+	}
+	for _, email := range []string{
+		"anna@sub.example.com", "anna@example.com.evil.test", "anna@other.com", "example.com", "anna@claimed.example",
+	} {
+		if connection.allowsEmail(email) {
+			t.Fatalf("%s allowed", email)
+		}
+	}
+}
+
+func TestSSOVerificationRecordMustCarryTheToken(t *testing.T) {
+	tests := []struct {
+		records []string
+		want    bool
+	}{
+		{[]string{"v=spf1 -all", " reup-domain-verification=token-1 "}, true},
+		{[]string{"reup-domain-verification=token-2"}, false},
+		{[]string{"token-1"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := hasSSOVerificationRecord(tt.records, "token-1"); got != tt.want {
+			t.Fatalf("hasSSOVerificationRecord(%v) = %v, want %v", tt.records, got, tt.want)
+		}
+	}
+}
+
+func TestSSOLinkHandlerRequiresALinkToken(t *testing.T) {
+	handler := (&SSOService{}).LinkHandler()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/auth/sso/link", strings.NewReader(`{"password":"secret"}`)))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), ErrSSOLinkInvalid.Error()) {
+		t.Fatalf("status = %d body = %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestSealedSSOClientSecretIsBoundToItsWorkspace(t *testing.T) {
+	encryption.Install(encryption.NewKeyring(nil, strings.Repeat("k", 32), nil))
+	defer encryption.Install(nil)
+	sealed, err := sealSecret("sso_client_secret", 3, "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := openSecret("sso_client_secret", 3, sealed); err != nil || secret != "client-secret" {
+		t.Fatalf("openSecret = %q, %v", secret, err)
+	}
+	if _, err := openSecret("sso_client_secret", 4, sealed); err == nil {
+		t.Fatal("a secret moved to another workspace must not open")
+	}
+	if _, err := openSecret("totp", 3, sealed); err == nil {
+		t.Fatal("a client secret must not open as a TOTP seed")
+	}
+}
+
+func TestLegacySSOClientSecretOpensAndResealsWithTheKeyring(t *testing.T) {
+	encryption.Install(encryption.NewKeyring(nil, strings.Repeat("k", 32), nil))
+	defer encryption.Install(nil)
+	InstallLegacySecretKey([]byte("old-jwt-secret"))
+	defer installedLegacySecretKey.Store(nil)
+	encoded, err := sealWithKey(*installedLegacySecretKey.Load(), []byte(legacySecretAAD("sso_client_secret", 3)), []byte("client-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := legacySealedSecretPrefix + encoded
+	if encryption.SecretIsCurrent(legacy) {
+		t.Fatal("a secret sealed with the JWT-derived key must be resealed")
+	}
+	secret, err := openSecret("sso_client_secret", 3, legacy)
+	if err != nil || secret != "client-secret" {
+		t.Fatalf("openSecret = %q, %v", secret, err)
+	}
+	resealed, err := sealSecret("sso_client_secret", 3, secret)
+	if err != nil || !encryption.SecretIsCurrent(resealed) {
+		t.Fatalf("sealSecret = %q, %v", resealed, err)
+	}
+	InstallLegacySecretKey([]byte("new-jwt-secret"))
+	if secret, err := openSecret("sso_client_secret", 3, resealed); err != nil || secret != "client-secret" {
+		t.Fatalf("rotating JWT_SECRET must not affect the client secret: %q, %v", secret, err)
+	}
+}
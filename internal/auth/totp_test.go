@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"reup-goals-backend/internal/encryption"
+)
+
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range tests {
+		if got := hotp(secret, totpStep(time.Unix(unix, 0))); got != want {
+			t.Fatalf("hotp at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestVerifyTOTPAllowsDriftAndRejectsReplay(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+	step, ok := verifyTOTP(secret, "081804", now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("expected current code to verify, got %d %v", step, ok)
+	}
+	if _, ok := verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Fatal("previous step must be accepted for clock drift")
+	}
+	if _, ok := verifyTOTP(secret, "081804", now.Add(3*totpPeriod*time.Second), 0); ok {
+		t.Fatal("stale code must be rejected")
+	}
+	if _, ok := verifyTOTP(secret, "081804", now, step); ok {
+		t.Fatal("a code must not be accepted twice")
+	}
+	if _, ok := verifyTOTP(secret, "12345", now, 0); ok {
+		t.Fatal("short code must be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(totpProvisioningURI("anna@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/REUP.goals:anna@example.com" {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "REUP.goals" || query.Get("digits") != "6" {
+		t.Fatalf("unexpected query: %s", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCodesNormalizeAndHashPerUser(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 14 || strings.Count(code, "-") != 2 {
+		t.Fatalf("unexpected recovery code format %q", code)
+	}
+	normalized := normalizeRecoveryCode(" " + strings.ToUpper(code) + " ")
+	if normalized != strings.ReplaceAll(code, "-", "") {
+		t.Fatalf("normalize(%q) = %q", code, normalized)
+	}
+	if normalizeRecoveryCode("123456") != "" || normalizeRecoveryCode("abcd-efgh-jk!m") != "" {
+		t.Fatal("totp codes and junk must not look like recovery codes")
+	}
+	if hashRecoveryCode(1, normalized) == hashRecoveryCode(2, normalized) {
+		t.Fatal("recovery code hashes must differ between users")
+	}
+}
+
+func TestSealedTOTPSecretIsBoundToItsUser(t *testing.T) {
+	encryption.Install(encryption.NewKeyring(nil, strings.Repeat("k", 32), nil))
+	defer encryption.Install(nil)
+	sealed, err := sealSecret("totp", 7, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.SecretIsCurrent(sealed) || strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("secret is not sealed: %q", sealed)
+	}
+	if secret, err := openSecret("totp", 7, sealed); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("openSecret = %q, %v", secret, err)
+	}
+	if _, err := openSecret("totp", 8, sealed); err == nil {
+		t.Fatal("a secret moved to another user must not open")
+	}
+	if secret, err := openSecret("totp", 7, "LEGACYSECRET"); err != nil || secret != "LEGACYSECRET" {
+		t.Fatalf("unsealed legacy secret = %q, %v", secret, err)
+	}
+}
+
+func TestTOTPSecretSurvivesJWTSecretRotation(t *testing.T) {
+	InstallLegacySecretKey([]byte(strings.Repeat("s", 32)))
+	legacy, err := sealWithKey(*installedLegacySecretKey.Load(), []byte(legacySecretAAD("totp", 7)), []byte("JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := openSecret("totp", 7, legacySealedSecretPrefix+legacy); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("legacy secret = %q, %v", secret, err)
+	}
+
+	encryption.Install(encryption.NewKeyring(nil, strings.Repeat("k", 32), nil))
+	defer encryption.Install(nil)
+	sealed, err := sealSecret("totp", 7, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InstallLegacySecretKey([]byte(strings.Repeat("r", 32)))
+	if secret, err := openSecret("totp", 7, sealed); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("secret after JWT_SECRET rotation = %q, %v", secret, err)
+	}
+}
+
+func TestVerifyTOTPRejectsAnEmptySecret(t *testing.T) {
+	now := time.Now()
+	if _, ok := verifyTOTP("", hotp(nil, totpStep(now)), now, 0); ok {
+		t.Fatal("an empty seed must not verify any code")
+	}
+}
@@ -0,0 +1,546 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/audit"
+)
+
+const (
+	recoveryCodeCount        = 10
+	loginChallengeTTL        = 5 * time.Minute
+	maxLoginChallengeTries   = 5
+	reauthenticationValidFor = 10 * time.Minute
+)
+
+var (
+	ErrTwoFactorInvalidCode         = errors.New("two_factor_invalid_code")
+	ErrTwoFactorNotEnrolled         = errors.New("two_factor_not_enrolled")
+	ErrTwoFactorAlreadyEnabled      = errors.New("two_factor_already_enabled")
+	ErrTwoFactorRequiredByWorkspace = errors.New("two_factor_required_by_workspace")
+	ErrReauthenticationRequired     = errors.New("reauthentication_required")
+	errLoginChallengeInvalid        = errors.New("login_challenge_invalid")
+)
+
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	PendingSetup           bool       `json:"pending_setup"`
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	RequiredByWorkspace    bool       `json:"required_by_workspace"`
+}
+
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+func TwoFactorStatusForUser(ctx context.Context, dbx *sql.DB, userID int) (TwoFactorStatus, error) {
+	var status TwoFactorStatus
+	var hasFactor bool
+	var confirmedAt sql.NullTime
+	err := dbx.QueryRowContext(ctx, `
+		SELECT factor.user_id IS NOT NULL, factor.confirmed_at,
+			(SELECT COUNT(*) FROM auth_recovery_codes WHERE user_id=$1 AND used_at IS NULL),
+			EXISTS (
+				SELECT 1
+				FROM workspace_memberships membership
+				JOIN workspaces workspace ON workspace.id=membership.workspace_id
+				WHERE membership.user_id=$1 AND membership.status='active'
+					AND workspace.status='active' AND workspace.require_two_factor
+			)
+		FROM (SELECT $1::integer AS user_id) requested
+		LEFT JOIN auth_totp_factors factor ON factor.user_id=requested.user_id
+	`, userID).Scan(&hasFactor, &confirmedAt, &status.RecoveryCodesRemaining, &status.RequiredByWorkspace)
+	if err != nil {
+		return TwoFactorStatus{}, err
+	}
+	status.Enabled = confirmedAt.Valid
+	status.PendingSetup = hasFactor && !confirmedAt.Valid
+	if confirmedAt.Valid {
+		status.ConfirmedAt = &confirmedAt.Time
+	}
+	return status, nil
+}
+
+// BeginTwoFactorSetup stores a fresh unconfirmed secret. It only takes effect
+// once ConfirmTwoFactor sees a valid code from the authenticator app.
+func BeginTwoFactorSetup(ctx context.Context, dbx *sql.DB, userID int) (TwoFactorSetup, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	var email string
+	if err := dbx.QueryRowContext(ctx, `SELECT email FROM users WHERE id=$1`, userID).Scan(&email); err != nil {
+		return TwoFactorSetup{}, err
+	}
+	sealed, err := sealSecret("totp", userID, secret)
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	result, err := dbx.ExecContext(ctx, `
+		INSERT INTO auth_totp_factors (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret=EXCLUDED.secret, last_used_step=0, updated_at=NOW()
+		WHERE auth_totp_factors.confirmed_at IS NULL
+	`, userID, sealed)
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return TwoFactorSetup{}, err
+	} else if affected == 0 {
+		return TwoFactorSetup{}, ErrTwoFactorAlreadyEnabled
+	}
+	return TwoFactorSetup{Secret: secret, ProvisioningURI: totpProvisioningURI(email, secret)}, nil
+}
+
+// ConfirmTwoFactor enables the pending factor and returns the recovery codes.
+// They are shown once; only their hashes are stored.
+func ConfirmTwoFactor(ctx context.Context, dbx *sql.DB, userID int, code string) ([]string, error) {
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var secret string
+	var confirmed bool
+	var lastUsedStep int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT secret, confirmed_at IS NOT NULL, last_used_step
+		FROM auth_totp_factors
+		WHERE user_id=$1
+		FOR UPDATE
+	`, userID).Scan(&secret, &confirmed, &lastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if secret, err = openSecret("totp", userID, secret); err != nil {
+		return nil, err
+	}
+	step, ok := verifyTOTP(secret, code, time.Now(), lastUsedStep)
+	if !ok {
+		return nil, ErrTwoFactorInvalidCode
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth_totp_factors
+		SET confirmed_at=NOW(), last_used_step=$2, updated_at=NOW()
+		WHERE user_id=$1
+	`, userID, step); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func RegenerateRecoveryCodes(ctx context.Context, dbx *sql.DB, userID int) ([]string, error) {
+	enabled, err := twoFactorEnabled(ctx, dbx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes the factor and its recovery codes unless a
+// workspace the user belongs to enforces 2FA.
+func DisableTwoFactor(ctx context.Context, dbx *sql.DB, userID int) error {
+	status, err := TwoFactorStatusForUser(ctx, dbx, userID)
+	if err != nil {
+		return err
+	}
+	if status.RequiredByWorkspace {
+		return ErrTwoFactorRequiredByWorkspace
+	}
+	tx, err := dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `DELETE FROM auth_totp_factors WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTwoFactorNotEnrolled
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reauthenticate confirms the password, and the second factor when enrolled,
+// for the current session so sensitive actions are allowed for a short while.
+func Reauthenticate(ctx context.Context, dbx *sql.DB, userID int, password string, code string) error {
+	sessionID, ok := SessionIDFromContext(ctx)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	var storedPassword string
+	if err := dbx.QueryRowContext(ctx, `SELECT password FROM users WHERE id=$1`, userID).Scan(&storedPassword); err != nil {
+		return err
+	}
+	if !passwordMatches(storedPassword, normalizeSecret(password)) {
+		return ErrCurrentPasswordInvalid
+	}
+	enabled, err := twoFactorEnabled(ctx, dbx, userID)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		if err := verifySecondFactor(ctx, dbx, userID, code); err != nil {
+			return err
+		}
+	}
+	result, err := dbx.ExecContext(ctx, `
+		UPDATE auth_sessions
+		SET authenticated_at=NOW(), last_seen_at=NOW()
+		WHERE public_id=$1 AND user_id=$2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RequireRecentAuthentication guards sensitive actions: the current session
+// must have signed in or re-authenticated within the last few minutes.
+// Tokens without a server-side session can never satisfy it.
+func RequireRecentAuthentication(ctx context.Context, dbx *sql.DB, userID int) error {
+	sessionID, ok := SessionIDFromContext(ctx)
+	if !ok || dbx == nil {
+		return ErrReauthenticationRequired
+	}
+	var recent bool
+	err := dbx.QueryRowContext(ctx, `
+		SELECT authenticated_at > NOW() - $3::interval
+		FROM auth_sessions
+		WHERE public_id=$1 AND user_id=$2 AND revoked_at IS NULL
+	`, sessionID, userID, fmt.Sprintf("%d seconds", int(reauthenticationValidFor.Seconds()))).Scan(&recent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReauthenticationRequired
+	}
+	if err != nil {
+		return err
+	}
+	if !recent {
+		return ErrReauthenticationRequired
+	}
+	return nil
+}
+
+// WorkspaceTwoFactorSatisfied reports whether the user may use a workspace:
+// either it does not enforce 2FA or the user has a confirmed factor.
+func WorkspaceTwoFactorSatisfied(ctx context.Context, dbx *sql.DB, workspaceID int, userID int) (bool, error) {
+	var satisfied bool
+	err := dbx.QueryRowContext(ctx, `
+		SELECT NOT require_two_factor OR EXISTS (
+			SELECT 1 FROM auth_totp_factors WHERE user_id=$2 AND confirmed_at IS NOT NULL
+		)
+		FROM workspaces
+		WHERE id=$1
+	`, workspaceID, userID).Scan(&satisfied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return satisfied, err
+}
+
+// SetWorkspaceTwoFactorRequirement toggles enforcement. The owner turning it
+// on must already be enrolled so they cannot lock themselves out.
+func SetWorkspaceTwoFactorRequirement(ctx context.Context, dbx *sql.DB, workspaceID int, userID int, required bool) error {
+	if required {
+		enabled, err := twoFactorEnabled(ctx, dbx, userID)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return ErrTwoFactorNotEnrolled
+		}
+	}
+	_, err := dbx.ExecContext(ctx, `
+		UPDATE workspaces SET require_two_factor=$2, updated_at=NOW() WHERE id=$1
+	`, workspaceID, required)
+	return err
+}
+
+// TwoFactorLoginHandler completes a login that LoginHandler paused for the
+// second factor. The session is only issued here.
+func TwoFactorLoginHandler(dbx *sql.DB, keys *KeySet, secureCookie bool, browserAuthOnly bool, monitor *SignInMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, "method_not_allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, "invalid_json", http.StatusBadRequest)
+			return
+		}
+		challengeID, userID, err := consumeLoginChallengeAttempt(r.Context(), dbx, body.ChallengeToken)
+		if errors.Is(err, errLoginChallengeInvalid) {
+			writeAPIError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		if err := verifySecondFactor(r.Context(), dbx, userID, body.Code); errors.Is(err, ErrTwoFactorInvalidCode) {
+			monitor.RecordUserFailure(r, userID)
+			recordAccountEvent(r, dbx, userID, "", audit.ActionLoginFailed, map[string]any{
+				"method": "two_factor", "reason": err.Error(),
+			})
+			writeAPIError(w, err.Error(), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		result, err := dbx.ExecContext(r.Context(), `
+			UPDATE auth_login_challenges SET used_at=NOW() WHERE id=$1 AND used_at IS NULL
+		`, challengeID)
+		if err != nil {
+			writeAPIError(w, "server_error", http.StatusInternalServerError)
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			writeAPIError(w, errLoginChallengeInvalid.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var authVersion int
+		var onboardingMode string
+		if err := dbx.QueryRowContext(r.Context(), `
+			SELECT auth_version, workspace_onboarding_mode FROM users WHERE id=$1
+		`, userID).Scan(&authVersion, &onboardingMode); err != nil {
+			writeAPIError(w, "user_not_found", http.StatusNotFound)
+			return
+		}
+		session, err := issueSession(w, r, dbx, keys, userID, authVersion, secureCookie)
+		if err != nil {
+			writeAPIError(w, "token_generation_failed", http.StatusInternalServerError)
+			return
+		}
+		risk := monitor.RecordSuccess(w, r, userID, "two_factor")
+		recordAccountEvent(r, dbx, userID, "", audit.ActionLoginSucceeded, map[string]any{
+			"method": "two_factor", "session_id": session.SessionID, "risk": risk,
+		})
+		response := map[string]any{
+			"user_id": userID, "workspace_onboarding_mode": onboardingMode, "session_id": session.SessionID,
+		}
+		if shouldExposeToken(r, browserAuthOnly) {
+			response["token"] = session.Token
+			response["refresh_token"] = session.RefreshToken
+		}
+		writeOK(w, response)
+	}
+}
+
+func createLoginChallenge(ctx context.Context, dbx *sql.DB, userID int) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = dbx.ExecContext(ctx, `
+		INSERT INTO auth_login_challenges (token_hash, user_id, expires_at)
+		VALUES ($1, $2, $3)
+	`, hashSecretToken(token), userID, time.Now().Add(loginChallengeTTL))
+	return token, err
+}
+
+// consumeLoginChallengeAttempt counts an attempt before the code is checked,
+// so a challenge allows a fixed number of guesses in total.
+func consumeLoginChallengeAttempt(ctx context.Context, dbx *sql.DB, token string) (int64, int, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return 0, 0, errLoginChallengeInvalid
+	}
+	var challengeID int64
+	var userID int
+	err := dbx.QueryRowContext(ctx, `
+		UPDATE auth_login_challenges
+		SET attempts=attempts+1
+		WHERE token_hash=$1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2
+		RETURNING id, user_id
+	`, hashSecretToken(token), maxLoginChallengeTries).Scan(&challengeID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, errLoginChallengeInvalid
+	}
+	return challengeID, userID, err
+}
+
+func twoFactorEnabled(ctx context.Context, dbx *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	err := dbx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM auth_totp_factors WHERE user_id=$1 AND confirmed_at IS NOT NULL)
+	`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// verifySecondFactor accepts a TOTP code or an unused recovery code. Recovery
+// codes are burned on use; TOTP steps are recorded to block replays.
+// Recovery codes keep working when the seed cannot be opened.
+func verifySecondFactor(ctx context.Context, dbx *sql.DB, userID int, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrTwoFactorInvalidCode
+	}
+	var secret string
+	var lastUsedStep int64
+	err := dbx.QueryRowContext(ctx, `
+		SELECT secret, last_used_step FROM auth_totp_factors WHERE user_id=$1 AND confirmed_at IS NOT NULL
+	`, userID).Scan(&secret, &lastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	// A seed that no longer opens must not lock the user out: recovery
+	// codes are hashes and are still checked below.
+	secret, err = openSecret("totp", userID, secret)
+	if err != nil {
+		log.Printf("[WARN] totp seed of user %d cannot be opened: %v", userID, err)
+		secret = ""
+	}
+	if step, ok := verifyTOTP(secret, code, time.Now(), lastUsedStep); ok {
+		result, err := dbx.ExecContext(ctx, `
+			UPDATE auth_totp_factors
+			SET last_used_step=$2, updated_at=NOW()
+			WHERE user_id=$1 AND last_used_step < $2
+		`, userID, step)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return ErrTwoFactorInvalidCode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrTwoFactorInvalidCode
+	}
+	result, err := dbx.ExecContext(ctx, `
+		UPDATE auth_recovery_codes
+		SET used_at=NOW()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL
+	`, userID, hashRecoveryCode(userID, normalized))
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrTwoFactorInvalidCode
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO auth_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+			ON CONFLICT (user_id, code_hash) DO NOTHING
+		`, userID, hashRecoveryCode(userID, normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCode returns a code such as "k7qm-2xpa-94tn" (about 60 bits).
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	for index, value := range raw {
+		if index > 0 && index%4 == 0 {
+			builder.WriteByte('-')
+		}
+		builder.WriteByte(recoveryCodeAlphabet[int(value)%len(recoveryCodeAlphabet)])
+	}
+	return builder.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	var builder strings.Builder
+	for _, char := range strings.ToLower(code) {
+		if strings.ContainsRune(recoveryCodeAlphabet, char) {
+			builder.WriteRune(char)
+		} else if char != '-' && char != ' ' {
+			return ""
+		}
+	}
+	if builder.Len() != 12 {
+		return ""
+	}
+	return builder.String()
+}
+
+func hashRecoveryCode(userID int, normalized string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, normalized)))
+	return hex.EncodeToString(hash[:])
+}
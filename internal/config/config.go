@@ -49,6 +49,14 @@ type Config struct {
 	AgentReleaseID                string
 
 	JWTSecret                     string
+	JWTSigningAlgorithm           string
+	JWTRejectLegacyHS256          bool
+	AuthAdminKey                  string
+	DataEncryptionKey             string
+	DataEncryptionPreviousKeys    []string
+	DataKeyVaultURL               string
+	DataKeyLifetime               time.Duration
+	DataRekeyInterval             time.Duration
 	CORSAllowedOrigins            []string
 	Environment                   string
 	HTTPPort                      int
@@ -70,6 +78,8 @@ type Config struct {
 	BackgroundJobRetention        time.Duration
 	LegalEvidenceRetention        time.Duration
 	PrivacyRequestRetention       time.Duration
+	AuditLogRetention             time.Duration
+	SignInHistoryRetention        time.Duration
 
 	UnisenderAPIKey           string
 	UnisenderBaseURL          string
@@ -87,11 +97,22 @@ type Config struct {
 	CloudPaymentsCurrency           string
 	CloudPaymentsTrialDays          int
 
+	PaymentProvider   string
+	YooKassaShopID    string
+	YooKassaSecretKey string
+	YooKassaBaseURL   string
+
 	TopPaymentsCheckoutURL    string
 	BillingPaymentsEnabled    bool
 	BillingEnforcementEnabled bool
 	BillingAdminKey           string
+	ClosingDocumentsInterval  time.Duration
+	DunningGraceDays          int
+	DunningRetryDays          []int
+	DunningEmailDays          []int
+	DunningInterval           time.Duration
 	FrontendBaseURL           string
+	OIDCRedirectURL           string
 	AppVersion                string
 	SupportEmail              string
 	DocumentationURL          string
@@ -140,6 +161,10 @@ func Load() *Config {
 	}
 
 	jwtSecret := strings.TrimSpace(os.Getenv("JWT_SECRET"))
+	jwtSigningAlgorithm := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALGORITHM"))
+	if jwtSigningAlgorithm == "" {
+		jwtSigningAlgorithm = "EdDSA"
+	}
 	environment := strings.ToLower(strings.TrimSpace(os.Getenv("APP_ENV")))
 	if environment == "" {
 		environment = "development"
@@ -183,10 +208,19 @@ func Load() *Config {
 	}
 
 	cloudPaymentsTrialDays := parseIntEnv("CLOUDPAYMENTS_TRIAL_DAYS", 0)
+
+	yooKassaBaseURL := strings.TrimSpace(os.Getenv("YOOKASSA_BASE_URL"))
+	if yooKassaBaseURL == "" {
+		yooKassaBaseURL = "https://api.yookassa.ru/v3"
+	}
 	frontendBaseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("FRONTEND_BASE_URL")), "/")
 	if frontendBaseURL == "" {
 		frontendBaseURL = "http://localhost:3000"
 	}
+	oidcRedirectURL := strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL"))
+	if oidcRedirectURL == "" {
+		oidcRedirectURL = frontendBaseURL + "/auth/sso/callback"
+	}
 	appVersion := strings.TrimSpace(os.Getenv("APP_VERSION"))
 	if appVersion == "" {
 		appVersion = "REUP.goals v2"
@@ -244,6 +278,14 @@ func Load() *Config {
 		AgentReleaseID:                strings.TrimSpace(os.Getenv("AGENT_RELEASE_ID")),
 
 		JWTSecret:                     jwtSecret,
+		JWTSigningAlgorithm:           jwtSigningAlgorithm,
+		JWTRejectLegacyHS256:          parseBoolEnv("JWT_REJECT_LEGACY_HS256"),
+		AuthAdminKey:                  strings.TrimSpace(os.Getenv("AUTH_ADMIN_KEY")),
+		DataEncryptionKey:             strings.TrimSpace(os.Getenv("DATA_ENCRYPTION_KEY")),
+		DataEncryptionPreviousKeys:    parseCSVEnv("DATA_ENCRYPTION_PREVIOUS_KEYS"),
+		DataKeyVaultURL:               strings.TrimSpace(os.Getenv("DATA_KEY_VAULT_URL")),
+		DataKeyLifetime:               daysEnv("DATA_KEY_ROTATION_DAYS", 90),
+		DataRekeyInterval:             parseDurationEnv("DATA_REKEY_INTERVAL", time.Hour),
 		CORSAllowedOrigins:            parseCSVEnv("CORS_ALLOWED_ORIGINS"),
 		Environment:                   environment,
 		HTTPPort:                      parseIntEnv("HTTP_PORT", 8080),
@@ -265,6 +307,8 @@ func Load() *Config {
 		BackgroundJobRetention:        daysEnv("BACKGROUND_JOB_RETENTION_DAYS", 30),
 		LegalEvidenceRetention:        daysEnv("LEGAL_EVIDENCE_RETENTION_DAYS", 1095),
 		PrivacyRequestRetention:       daysEnv("PRIVACY_REQUEST_RETENTION_DAYS", 1095),
+		AuditLogRetention:             daysEnv("AUDIT_LOG_RETENTION_DAYS", 1825),
+		SignInHistoryRetention:        daysEnv("SIGN_IN_HISTORY_RETENTION_DAYS", 180),
 
 		UnisenderAPIKey:           os.Getenv("UNISENDER_API_KEY"),
 		UnisenderBaseURL:          unisenderBaseURL,
@@ -282,11 +326,22 @@ func Load() *Config {
 		CloudPaymentsCurrency:           cloudPaymentsCurrency,
 		CloudPaymentsTrialDays:          cloudPaymentsTrialDays,
 
+		PaymentProvider:   strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER"))),
+		YooKassaShopID:    strings.TrimSpace(os.Getenv("YOOKASSA_SHOP_ID")),
+		YooKassaSecretKey: strings.TrimSpace(os.Getenv("YOOKASSA_SECRET_KEY")),
+		YooKassaBaseURL:   yooKassaBaseURL,
+
 		TopPaymentsCheckoutURL:    strings.TrimSpace(os.Getenv("TOPPAYMENTS_CHECKOUT_URL")),
 		BillingPaymentsEnabled:    parseBoolEnv("BILLING_PAYMENTS_ENABLED"),
 		BillingEnforcementEnabled: parseBoolEnv("BILLING_ENFORCEMENT_ENABLED"),
 		BillingAdminKey:           strings.TrimSpace(os.Getenv("BILLING_ADMIN_KEY")),
+		ClosingDocumentsInterval:  parseDurationEnv("CLOSING_DOCUMENTS_INTERVAL", time.Hour),
+		DunningGraceDays:          parseIntEnv("DUNNING_GRACE_DAYS", 14),
+		DunningRetryDays:          parseIntListEnv("DUNNING_RETRY_DAYS", []int{1, 3, 7}),
+		DunningEmailDays:          parseIntListEnv("DUNNING_EMAIL_DAYS", []int{0, 3, 7, 12}),
+		DunningInterval:           parseDurationEnv("DUNNING_INTERVAL", time.Hour),
 		FrontendBaseURL:           frontendBaseURL,
+		OIDCRedirectURL:           oidcRedirectURL,
 		AppVersion:                appVersion,
 		SupportEmail:              supportEmail,
 		DocumentationURL:          strings.TrimSpace(os.Getenv("DOCUMENTATION_URL")),
@@ -338,6 +393,25 @@ func parseIntEnv(key string, fallback int) int {
 	return parsed
 }
 
+// parseIntListEnv reads a comma-separated list of integers. An empty or
+// malformed value falls back as a whole, so a typo cannot silently drop
+// one step of a schedule.
+func parseIntListEnv(key string, fallback []int) []int {
+	parts := parseCSVEnv(key)
+	if len(parts) == 0 {
+		return fallback
+	}
+	result := make([]int, 0, len(parts))
+	for _, part := range parts {
+		parsed, err := strconv.Atoi(part)
+		if err != nil {
+			return fallback
+		}
+		result = append(result, parsed)
+	}
+	return result
+}
+
 func parseFloatEnv(key string, fallback float64) float64 {
 	value := os.Getenv(key)
 	if value == "" {
@@ -393,6 +467,18 @@ func (c *Config) Validate() error {
 	if len(c.JWTSecret) < 32 {
 		return fmt.Errorf("JWT_SECRET must contain at least 32 characters")
 	}
+	if c.JWTSigningAlgorithm != "" && c.JWTSigningAlgorithm != "EdDSA" && c.JWTSigningAlgorithm != "ES256" {
+		return fmt.Errorf("JWT_SIGNING_ALGORITHM must be EdDSA or ES256")
+	}
+	if c.AuthAdminKey != "" && len(c.AuthAdminKey) < 32 {
+		return fmt.Errorf("AUTH_ADMIN_KEY must contain at least 32 characters")
+	}
+	if c.DataEncryptionKey != "" && len(c.DataEncryptionKey) < 32 {
+		return fmt.Errorf("DATA_ENCRYPTION_KEY must contain at least 32 characters")
+	}
+	if c.DataEncryptionKey != "" && c.DataEncryptionKey == c.JWTSecret {
+		return fmt.Errorf("DATA_ENCRYPTION_KEY must differ from JWT_SECRET")
+	}
 	openAIBaseURL := strings.TrimSpace(c.OpenAIBaseURL)
 	if openAIBaseURL == "" {
 		openAIBaseURL = "https://api.openai.com/v1"
@@ -453,16 +539,30 @@ func (c *Config) Validate() error {
 		if (c.PrivacyMode == "gdpr" || c.PrivacyMode == "dual") && strings.TrimSpace(c.GDPRTransferMechanism) == "" {
 			return fmt.Errorf("GDPR_TRANSFER_MECHANISM is required for production external processing")
 		}
+		if c.DataEncryptionKey == "" {
+			return fmt.Errorf("DATA_ENCRYPTION_KEY is required in production")
+		}
+		if c.DataKeyVaultURL == "" {
+			return fmt.Errorf("DATA_KEY_VAULT_URL is required in production")
+		}
 	}
 	if (strings.TrimSpace(c.CloudPaymentsPublicID) == "") != (strings.TrimSpace(c.CloudPaymentsAPISecret) == "") {
 		return fmt.Errorf("CLOUDPAYMENTS_PUBLIC_ID and CLOUDPAYMENTS_API_SECRET must be configured together")
 	}
+	if (c.YooKassaShopID == "") != (c.YooKassaSecretKey == "") {
+		return fmt.Errorf("YOOKASSA_SHOP_ID and YOOKASSA_SECRET_KEY must be configured together")
+	}
+	switch c.PaymentProvider {
+	case "", "cloudpayments", "yookassa":
+	default:
+		return fmt.Errorf("PAYMENT_PROVIDER must be cloudpayments or yookassa")
+	}
 	if c.BillingAdminKey != "" && len(c.BillingAdminKey) < 32 {
 		return fmt.Errorf("BILLING_ADMIN_KEY must contain at least 32 characters")
 	}
 	if c.BillingPaymentsEnabled &&
 		strings.TrimSpace(c.TopPaymentsCheckoutURL) == "" &&
-		strings.TrimSpace(c.CloudPaymentsPublicID) == "" {
+		strings.TrimSpace(c.CloudPaymentsPublicID) == "" && c.YooKassaShopID == "" {
 		return fmt.Errorf("BILLING_PAYMENTS_ENABLED requires a configured payment provider")
 	}
 	if c.BillingEnforcementEnabled && !c.BillingPaymentsEnabled && strings.TrimSpace(c.BillingAdminKey) == "" {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -144,10 +145,15 @@ func TestValidateProductionPrivacyControls(t *testing.T) {
 		CORSAllowedOrigins: []string{"https://reupgoals.pro"}, PrivacyMode: "dual",
 		DataResidencyRegion: "ru-msk", PrivacyContactEmail: "privacy@example.com",
 		CrossBorderTransferRegistered: true, GDPRTransferMechanism: "scc",
+		DataEncryptionKey: strings.Repeat("d", 32), DataKeyVaultURL: "postgres://vault.example.com/keys",
 	}
 	if err := config.Validate(); err != nil {
 		t.Fatalf("expected valid dual-region config, got %v", err)
 	}
+	config.DataKeyVaultURL = ""
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "DATA_KEY_VAULT_URL") {
+		t.Fatalf("expected key vault error, got %v", err)
+	}
 }
 
 func TestLoadSecureCookieOverride(t *testing.T) {
@@ -192,3 +198,22 @@ func TestValidateAllowsManualBillingConfirmation(t *testing.T) {
 		t.Fatalf("expected manual billing activation path to be valid, got %v", err)
 	}
 }
+
+func TestParseIntListEnv(t *testing.T) {
+	fallback := []int{1, 3, 7}
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{value: "", want: fallback},
+		{value: "0, 2,5", want: []int{0, 2, 5}},
+		{value: "1,two,3", want: fallback},
+	}
+	for _, test := range tests {
+		t.Setenv("DUNNING_RETRY_DAYS", test.value)
+		got := parseIntListEnv("DUNNING_RETRY_DAYS", fallback)
+		if !slices.Equal(got, test.want) {
+			t.Fatalf("parseIntListEnv(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// currentKeyTTL bounds how long an instance keeps sealing with a data key
+	// after another instance rotated it.
+	currentKeyTTL     = time.Minute
+	maxCachedDataKeys = 4096
+)
+
+var (
+	ErrKeyringMissing = errors.New("data encryption key is not configured")
+	ErrKeyDestroyed   = errors.New("workspace data key was destroyed")
+	ErrUnknownMaster  = errors.New("data key is wrapped by an unknown master key")
+)
+
+type masterKey struct {
+	id  string
+	key [32]byte
+}
+
+type dataKeyRef struct {
+	workspaceID int
+	version     int
+}
+
+type currentKey struct {
+	version  int
+	loadedAt time.Time
+}
+
+// Keyring seals business content with a per-workspace data key. Data keys
+// are stored in workspace_data_keys wrapped by the workspace's key-encryption
+// key, which lives in the key vault wrapped by the master key from config. A
+// database dump alone does not reveal the content, and destroying the vault
+// row makes the workspace's content unreadable in the database and in every
+// backup of it.
+type Keyring struct {
+	dbx           *sql.DB
+	vault         *sql.DB
+	master        masterKey
+	masters       map[string]masterKey
+	mu            sync.Mutex
+	dataKeys      map[dataKeyRef][]byte
+	current       map[int]currentKey
+	workspaceKeys map[int][]byte
+}
+
+// NewKeyring uses masterSecret for new data keys. Previous secrets are only
+// used to unwrap keys until the rekeyer has rewrapped them.
+func NewKeyring(dbx *sql.DB, masterSecret string, previousSecrets []string) *Keyring {
+	master := deriveMasterKey(masterSecret)
+	masters := map[string]masterKey{master.id: master}
+	for _, secret := range previousSecrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			previous := deriveMasterKey(secret)
+			masters[previous.id] = previous
+		}
+	}
+	return &Keyring{
+		dbx: dbx, vault: dbx, master: master, masters: masters,
+		dataKeys: map[dataKeyRef][]byte{}, current: map[int]currentKey{},
+		workspaceKeys: map[int][]byte{},
+	}
+}
+
+// WithVault keeps key-encryption keys in a separate database that is left
+// out of the product backups. Without one they share the main database, and
+// its backups can open deleted content until they expire.
+func (k *Keyring) WithVault(vault *sql.DB) *Keyring {
+	if vault != nil {
+		k.vault = vault
+	}
+	return k
+}
+
+func deriveMasterKey(secret string) masterKey {
+	key := sha256.Sum256([]byte("reup-data-master:" + strings.TrimSpace(secret)))
+	id := sha256.Sum256(key[:])
+	return masterKey{id: hex.EncodeToString(id[:6]), key: key}
+}
+
+var installed atomic.Pointer[Keyring]
+
+// Install makes the keyring used by the package-level helpers. Stores are
+// built from a bare *sql.DB in many places, so the keyring is installed
+// once at startup instead of threaded through every constructor. Without
+// one, content is stored in plaintext as before.
+func Install(keyring *Keyring) {
+	installed.Store(keyring)
+}
+
+func Default() *Keyring {
+	return installed.Load()
+}
+
+func SealText(ctx context.Context, workspaceID int, plaintext string) (string, sql.NullInt64, error) {
+	return Default().SealText(ctx, workspaceID, plaintext)
+}
+
+func OpenText(ctx context.Context, workspaceID int, stored string, version sql.NullInt64) (string, error) {
+	return Default().OpenText(ctx, workspaceID, stored, version)
+}
+
+func SealBytes(ctx context.Context, workspaceID int, plaintext []byte) ([]byte, sql.NullInt64, error) {
+	return Default().SealBytes(ctx, workspaceID, plaintext)
+}
+
+func OpenBytes(ctx context.Context, workspaceID int, stored []byte, version sql.NullInt64) ([]byte, error) {
+	return Default().OpenBytes(ctx, workspaceID, stored, version)
+}
+
+// Forget drops the cached keys of a deleted workspace so nothing in memory
+// outlives the shredded rows.
+func Forget(workspaceID int) {
+	Default().Forget(workspaceID)
+}
+
+// DestroyWorkspace shreds a deleted workspace: it destroys its key-encryption
+// key, so its data keys, and with them its content, cannot be opened from
+// any copy of the database.
+func DestroyWorkspace(ctx context.Context, workspaceID int) error {
+	return Default().DestroyWorkspace(ctx, workspaceID)
+}
+
+// SealText returns the value for a sealed TEXT column together with the key
+// version for its content_key_version column.
+func (k *Keyring) SealText(ctx context.Context, workspaceID int, plaintext string) (string, sql.NullInt64, error) {
+	if k == nil {
+		return plaintext, sql.NullInt64{}, nil
+	}
+	sealed, version, err := k.SealBytes(ctx, workspaceID, []byte(plaintext))
+	if err != nil {
+		return "", sql.NullInt64{}, err
+	}
+	return base64.RawStdEncoding.EncodeToString(sealed), version, nil
+}
+
+// OpenText reads rows written before encryption as they are: a NULL key
+// version means plaintext.
+func (k *Keyring) OpenText(ctx context.Context, workspaceID int, stored string, version sql.NullInt64) (string, error) {
+	if !version.Valid {
+		return stored, nil
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(stored)
+	if err != nil {
+		return "", fmt.Errorf("decode sealed text: %w", err)
+	}
+	plaintext, err := k.OpenBytes(ctx, workspaceID, raw, version)
+	return string(plaintext), err
+}
+
+func (k *Keyring) SealBytes(ctx context.Context, workspaceID int, plaintext []byte) ([]byte, sql.NullInt64, error) {
+	if k == nil {
+		return plaintext, sql.NullInt64{}, nil
+	}
+	version, key, err := k.currentDataKey(ctx, workspaceID)
+	if err != nil {
+		return nil, sql.NullInt64{}, err
+	}
+	sealed, err := seal(key, plaintext, contentAAD(workspaceID))
+	if err != nil {
+		return nil, sql.NullInt64{}, err
+	}
+	return sealed, sql.NullInt64{Int64: int64(version), Valid: true}, nil
+}
+
+func (k *Keyring) OpenBytes(ctx context.Context, workspaceID int, stored []byte, version sql.NullInt64) ([]byte, error) {
+	if !version.Valid {
+		return stored, nil
+	}
+	if k == nil {
+		return nil, ErrKeyringMissing
+	}
+	key, err := k.dataKey(ctx, workspaceID, int(version.Int64))
+	if err != nil {
+		return nil, err
+	}
+	return open(key, stored, contentAAD(workspaceID))
+}
+
+func (k *Keyring) Forget(workspaceID int) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.current, workspaceID)
+	delete(k.workspaceKeys, workspaceID)
+	for ref := range k.dataKeys {
+		if ref.workspaceID == workspaceID {
+			delete(k.dataKeys, ref)
+		}
+	}
+}
+
+// RotateWorkspace retires the active data key and creates the next one. Old
+// content stays readable until the rekeyer has moved it to the new key.
+func (k *Keyring) RotateWorkspace(ctx context.Context, workspaceID int) error {
+	tx, err := k.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workspace_data_keys SET retired_at=NOW()
+		WHERE workspace_id=$1 AND retired_at IS NULL
+	`, workspaceID); err != nil {
+		return err
+	}
+	if err := k.insertDataKey(ctx, tx, workspaceID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	delete(k.current, workspaceID)
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *Keyring) currentDataKey(ctx context.Context, workspaceID int) (int, []byte, error) {
+	k.mu.Lock()
+	cached, ok := k.current[workspaceID]
+	key := k.dataKeys[dataKeyRef{workspaceID, cached.version}]
+	k.mu.Unlock()
+	if ok && key != nil && time.Since(cached.loadedAt) < currentKeyTTL {
+		return cached.version, key, nil
+	}
+
+	version, key, err := k.loadActiveKey(ctx, workspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The partial unique index lets only one instance create the first
+		// key; the loser reads the winner's.
+		if err := k.insertDataKey(ctx, k.dbx, workspaceID); err != nil {
+			return 0, nil, err
+		}
+		version, key, err = k.loadActiveKey(ctx, workspaceID)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	k.remember(workspaceID, version, key)
+	k.mu.Lock()
+	k.current[workspaceID] = currentKey{version: version, loadedAt: time.Now()}
+	k.mu.Unlock()
+	return version, key, nil
+}
+
+func (k *Keyring) loadActiveKey(ctx context.Context, workspaceID int) (int, []byte, error) {
+	var version int
+	var wrapped, masterID string
+	if err := k.dbx.QueryRowContext(ctx, `
+		SELECT version, wrapped_key, master_key_id
+		FROM workspace_data_keys
+		WHERE workspace_id=$1 AND retired_at IS NULL
+	`, workspaceID).Scan(&version, &wrapped, &masterID); err != nil {
+		return 0, nil, err
+	}
+	key, err := k.unwrapDataKey(ctx, workspaceID, version, wrapped, masterID)
+	return version, key, err
+}
+
+func (k *Keyring) dataKey(ctx context.Context, workspaceID int, version int) ([]byte, error) {
+	k.mu.Lock()
+	key := k.dataKeys[dataKeyRef{workspaceID, version}]
+	k.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	var wrapped, masterID string
+	err := k.dbx.QueryRowContext(ctx, `
+		SELECT wrapped_key, master_key_id
+		FROM workspace_data_keys
+		WHERE workspace_id=$1 AND version=$2
+	`, workspaceID, version).Scan(&wrapped, &masterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyDestroyed
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err = k.unwrapDataKey(ctx, workspaceID, version, wrapped, masterID)
+	if err != nil {
+		return nil, err
+	}
+	k.remember(workspaceID, version, key)
+	return key, nil
+}
+
+func (k *Keyring) remember(workspaceID int, version int, key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.dataKeys) >= maxCachedDataKeys {
+		k.dataKeys = map[dataKeyRef][]byte{}
+		k.current = map[int]currentKey{}
+		k.workspaceKeys = map[int][]byte{}
+	}
+	k.dataKeys[dataKeyRef{workspaceID, version}] = key
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (k *Keyring) insertDataKey(ctx context.Context, dbx querier, workspaceID int) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	var version int
+	if err := dbx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version), 0) + 1 FROM workspace_data_keys WHERE workspace_id=$1
+	`, workspaceID).Scan(&version); err != nil {
+		return err
+	}
+	wrapped, err := k.wrapDataKey(ctx, workspaceID, version, key)
+	if err != nil {
+		return err
+	}
+	_, err = dbx.ExecContext(ctx, `
+		INSERT INTO workspace_data_keys (workspace_id, version, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, workspaceID, version, wrapped, workspaceKeyWrap)
+	return err
+}
+
+func (k *Keyring) wrapDataKey(ctx context.Context, workspaceID int, version int, key []byte) (string, error) {
+	workspaceKey, err := k.workspaceKey(ctx, workspaceID, true)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(workspaceKey, key, keyAAD(workspaceID, version))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// unwrapDataKey also opens data keys wrapped by a master key directly, as
+// they were before key-encryption keys; the rekeyer moves them over.
+func (k *Keyring) unwrapDataKey(ctx context.Context, workspaceID int, version int, wrapped string, wrappedBy string) ([]byte, error) {
+	if wrappedBy != workspaceKeyWrap {
+		return k.unwrap(workspaceID, version, wrapped, wrappedBy)
+	}
+	workspaceKey, err := k.workspaceKey(ctx, workspaceID, false)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(workspaceKey, raw, keyAAD(workspaceID, version))
+}
+
+func (k *Keyring) wrap(workspaceID int, version int, key []byte) (string, error) {
+	sealed, err := seal(k.master.key[:], key, keyAAD(workspaceID, version))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) unwrap(workspaceID int, version int, wrapped string, masterID string) ([]byte, error) {
+	master, ok := k.masters[masterID]
+	if !ok {
+		return nil, ErrUnknownMaster
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(master.key[:], raw, keyAAD(workspaceID, version))
+}
+
+// The content AAD binds ciphertext to its workspace but not to a table, so
+// rows can be copied between sealed columns of the same workspace in SQL.
+func contentAAD(workspaceID int) []byte {
+	return []byte(fmt.Sprintf("workspace:%d", workspaceID))
+}
+
+func keyAAD(workspaceID int, version int) []byte {
+	return []byte(fmt.Sprintf("workspace:%d:data-key:%d", workspaceID, version))
+}
+
+func seal(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
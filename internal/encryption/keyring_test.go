@@ -0,0 +1,148 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestSealOpenBindsWorkspace(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	sealed, err := seal(key, []byte("выручка 12 млн"), contentAAD(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("выручка")) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+	opened, err := open(key, sealed, contentAAD(1))
+	if err != nil || string(opened) != "выручка 12 млн" {
+		t.Fatalf("open = %q, %v", opened, err)
+	}
+	if _, err := open(key, sealed, contentAAD(2)); err == nil {
+		t.Fatal("content sealed for one workspace opened for another")
+	}
+}
+
+func TestWrappedKeysSurviveMasterRotation(t *testing.T) {
+	dataKey := bytes.Repeat([]byte{3}, 32)
+	old := NewKeyring(nil, "old-master-secret-0123456789abcdef", nil)
+	wrapped, err := old.wrap(5, 2, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := NewKeyring(nil, "new-master-secret-0123456789abcdef", []string{"old-master-secret-0123456789abcdef"})
+	if rotated.master.id == old.master.id {
+		t.Fatal("different secrets produced the same master key id")
+	}
+	unwrapped, err := rotated.unwrap(5, 2, wrapped, old.master.id)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrap with previous master = %x, %v", unwrapped, err)
+	}
+	if _, err := rotated.unwrap(5, 3, wrapped, old.master.id); err == nil {
+		t.Fatal("wrapped key opened under another version")
+	}
+
+	dropped := NewKeyring(nil, "new-master-secret-0123456789abcdef", nil)
+	if _, err := dropped.unwrap(5, 2, wrapped, old.master.id); !errors.Is(err, ErrUnknownMaster) {
+		t.Fatalf("unwrap without previous master = %v", err)
+	}
+}
+
+func TestWithoutKeyringContentStaysPlaintext(t *testing.T) {
+	ctx := context.Background()
+	var keyring *Keyring
+	stored, version, err := keyring.SealText(ctx, 1, "план")
+	if err != nil || stored != "план" || version.Valid {
+		t.Fatalf("SealText = %q, %+v, %v", stored, version, err)
+	}
+	if text, err := keyring.OpenText(ctx, 1, "план", sql.NullInt64{}); err != nil || text != "план" {
+		t.Fatalf("OpenText = %q, %v", text, err)
+	}
+	sealed := sql.NullInt64{Int64: 1, Valid: true}
+	if _, err := keyring.OpenBytes(ctx, 1, []byte("x"), sealed); !errors.Is(err, ErrKeyringMissing) {
+		t.Fatalf("OpenBytes of sealed content = %v", err)
+	}
+	keyring.Forget(1)
+}
+
+func TestCachedKeysAreForgotten(t *testing.T) {
+	keyring := NewKeyring(nil, "master-secret-0123456789abcdef-xyz", nil)
+	keyring.remember(1, 1, []byte("one"))
+	keyring.remember(1, 2, []byte("two"))
+	keyring.remember(2, 1, []byte("other"))
+	keyring.Forget(1)
+	if len(keyring.dataKeys) != 1 || keyring.dataKeys[dataKeyRef{2, 1}] == nil {
+		t.Fatalf("cached keys after Forget = %v", keyring.dataKeys)
+	}
+}
+
+func TestSealedSecretsSurviveMasterRotation(t *testing.T) {
+	old := NewKeyring(nil, "old-master-secret-0123456789abcdef", nil)
+	sealed, err := old.SealSecret("totp", 7, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewKeyring(nil, "new-master-secret-0123456789abcdef", []string{"old-master-secret-0123456789abcdef"})
+	if rotated.SecretIsCurrent(sealed) {
+		t.Fatal("a secret under the previous master was reported current")
+	}
+	if secret, err := rotated.OpenSecret("totp", 7, sealed); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("open with previous master = %q, %v", secret, err)
+	}
+	if _, err := rotated.OpenSecret("totp", 8, sealed); err == nil {
+		t.Fatal("a secret moved to another row opened")
+	}
+	if secret, err := rotated.OpenSecret("totp", 7, "PLAINSECRET"); err != nil || secret != "PLAINSECRET" {
+		t.Fatalf("unsealed secret = %q, %v", secret, err)
+	}
+}
+
+func TestDataKeysAreWrappedByTheirWorkspaceKey(t *testing.T) {
+	ctx := context.Background()
+	dataKey := bytes.Repeat([]byte{3}, 32)
+	keyring := NewKeyring(nil, "master-secret-0123456789abcdef-xyz", nil)
+	keyring.workspaceKeys[5] = bytes.Repeat([]byte{9}, 32)
+	wrapped, err := keyring.wrapDataKey(ctx, 5, 2, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.unwrap(5, 2, wrapped, keyring.master.id); err == nil {
+		t.Fatal("a data key under a workspace key opened with the master key alone")
+	}
+	unwrapped, err := keyring.unwrapDataKey(ctx, 5, 2, wrapped, workspaceKeyWrap)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrapDataKey = %x, %v", unwrapped, err)
+	}
+
+	legacy, err := keyring.wrap(5, 1, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unwrapped, err := keyring.unwrapDataKey(ctx, 5, 1, legacy, keyring.master.id); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatalf("unwrapDataKey of a master-wrapped key = %x, %v", unwrapped, err)
+	}
+}
+
+func TestWorkspaceKeysSurviveMasterRotation(t *testing.T) {
+	workspaceKey := bytes.Repeat([]byte{4}, 32)
+	old := NewKeyring(nil, "old-master-secret-0123456789abcdef", nil)
+	wrapped, err := old.wrapWorkspaceKey(5, workspaceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewKeyring(nil, "new-master-secret-0123456789abcdef", []string{"old-master-secret-0123456789abcdef"})
+	unwrapped, err := rotated.unwrapWorkspaceKey(5, wrapped, old.master.id)
+	if err != nil || !bytes.Equal(unwrapped, workspaceKey) {
+		t.Fatalf("unwrap with previous master = %x, %v", unwrapped, err)
+	}
+	if _, err := rotated.unwrapWorkspaceKey(6, wrapped, old.master.id); err == nil {
+		t.Fatal("a workspace key opened for another workspace")
+	}
+	if _, err := old.unwrap(5, 0, wrapped, old.master.id); err == nil {
+		t.Fatal("a workspace key opened as a data key")
+	}
+}
@@ -0,0 +1,390 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	rekeyAdvisoryLock int64 = 528105238
+	// retiredKeyGrace outlasts currentKeyTTL so no instance is still sealing
+	// with a retired key when the rekeyer decides it is unused.
+	retiredKeyGrace = 10 * time.Minute
+)
+
+// sealedColumn is a column whose value is sealed with the workspace data key
+// and whose key version sits next to it in content_key_version.
+type sealedColumn struct {
+	table  string
+	column string
+	binary bool
+}
+
+var sealedColumns = []sealedColumn{
+	{table: "strategic_raw_sources", column: "content"},
+	{table: "strategic_document_chat_messages", column: "content"},
+	{table: "v2_strategy_chat_messages", column: "content"},
+	{table: "v2_tactics_chat_messages", column: "content"},
+	{table: "v2_task_brainstorm_messages", column: "content"},
+	{table: "v2_task_attachments", column: "content", binary: true},
+	{table: "strategic_claims", column: "claim_text"},
+	{table: "workspace_documents", column: "content"},
+	{table: "workspace_document_versions", column: "content"},
+}
+
+type RekeyPolicy struct {
+	Interval    time.Duration
+	KeyLifetime time.Duration
+	BatchSize   int
+}
+
+// Rekeyer keeps sealed content on current keys: it shreds deleted
+// workspaces, rotates data keys that reached their lifetime, rewraps keys
+// still under a previous master key, seals rows written before encryption or
+// under a retired key, and destroys retired keys once nothing refers to them.
+type Rekeyer struct {
+	keyring *Keyring
+	policy  RekeyPolicy
+}
+
+func NewRekeyer(keyring *Keyring, policy RekeyPolicy) *Rekeyer {
+	if policy.Interval <= 0 {
+		policy.Interval = time.Hour
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+	return &Rekeyer{keyring: keyring, policy: policy}
+}
+
+func (r *Rekeyer) Start(ctx context.Context) {
+	go func() {
+		r.run(ctx)
+		ticker := time.NewTicker(r.policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+}
+
+func (r *Rekeyer) run(ctx context.Context) {
+	conn, err := r.keyring.dbx.Conn(ctx)
+	if err != nil {
+		log.Printf("[WARN] data rekey connection failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, rekeyAdvisoryLock).Scan(&locked); err != nil || !locked {
+		return
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, rekeyAdvisoryLock)
+
+	steps := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"destroy deleted workspace keys", r.destroyDeletedWorkspaceKeys},
+		{"rotate data keys", r.rotateExpiredKeys},
+		{"rewrap workspace keys", r.rewrapWorkspaceKeys},
+		{"rewrap data keys", r.rewrapDataKeys},
+		{"reseal content", r.resealContent},
+		{"destroy retired keys", r.destroyRetiredKeys},
+	}
+	for _, step := range steps {
+		if err := step.run(ctx); err != nil {
+			log.Printf("[WARN] data rekey failed to %s: %v", step.name, err)
+			return
+		}
+	}
+}
+
+// destroyDeletedWorkspaceKeys shreds workspaces that were deleted without
+// DestroyWorkspace, such as with their owner's account, and shreds again the
+// ones a restored vault brought back.
+func (r *Rekeyer) destroyDeletedWorkspaceKeys(ctx context.Context) error {
+	k := r.keyring
+	workspaceIDs, err := queryInts(ctx, k.vault, `
+		SELECT workspace_id FROM workspace_key_encryption_keys ORDER BY workspace_id
+	`)
+	if err != nil || len(workspaceIDs) == 0 {
+		return err
+	}
+	deleted, err := queryInts(ctx, k.dbx, `
+		SELECT workspace_id FROM workspace_deletions WHERE workspace_id = ANY($1)
+	`, pq.Array(workspaceIDs))
+	if err != nil {
+		return err
+	}
+	for _, workspaceID := range deleted {
+		if err := k.DestroyWorkspace(ctx, workspaceID); err != nil {
+			return fmt.Errorf("workspace %d: %w", workspaceID, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rekeyer) rotateExpiredKeys(ctx context.Context) error {
+	if r.policy.KeyLifetime <= 0 {
+		return nil
+	}
+	workspaceIDs, err := queryInts(ctx, r.keyring.dbx, `
+		SELECT workspace_id FROM workspace_data_keys
+		WHERE retired_at IS NULL AND created_at < $1
+		ORDER BY workspace_id
+		LIMIT $2
+	`, time.Now().Add(-r.policy.KeyLifetime), r.policy.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, workspaceID := range workspaceIDs {
+		if err := r.keyring.RotateWorkspace(ctx, workspaceID); err != nil {
+			return fmt.Errorf("workspace %d: %w", workspaceID, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rekeyer) rewrapWorkspaceKeys(ctx context.Context) error {
+	k := r.keyring
+	rows, err := k.vault.QueryContext(ctx, `
+		SELECT workspace_id, wrapped_key, master_key_id
+		FROM workspace_key_encryption_keys
+		WHERE master_key_id<>$1
+		LIMIT $2
+	`, k.master.id, r.policy.BatchSize)
+	if err != nil {
+		return err
+	}
+	type wrappedKey struct {
+		workspaceID       int
+		wrapped, masterID string
+	}
+	var keys []wrappedKey
+	for rows.Next() {
+		var item wrappedKey
+		if err := rows.Scan(&item.workspaceID, &item.wrapped, &item.masterID); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, item := range keys {
+		key, err := k.unwrapWorkspaceKey(item.workspaceID, item.wrapped, item.masterID)
+		if err != nil {
+			log.Printf("[WARN] workspace key %d cannot be unwrapped: %v", item.workspaceID, err)
+			continue
+		}
+		wrapped, err := k.wrapWorkspaceKey(item.workspaceID, key)
+		if err != nil {
+			return err
+		}
+		if _, err := k.vault.ExecContext(ctx, `
+			UPDATE workspace_key_encryption_keys SET wrapped_key=$2, master_key_id=$3
+			WHERE workspace_id=$1 AND master_key_id=$4
+		`, item.workspaceID, wrapped, k.master.id, item.masterID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrapDataKeys moves data keys wrapped by a master key directly, from
+// before key-encryption keys, under their workspace's key.
+func (r *Rekeyer) rewrapDataKeys(ctx context.Context) error {
+	k := r.keyring
+	rows, err := k.dbx.QueryContext(ctx, `
+		SELECT workspace_id, version, wrapped_key, master_key_id
+		FROM workspace_data_keys
+		WHERE master_key_id<>$1
+		LIMIT $2
+	`, workspaceKeyWrap, r.policy.BatchSize)
+	if err != nil {
+		return err
+	}
+	type wrappedKey struct {
+		workspaceID, version int
+		wrapped, masterID    string
+	}
+	var keys []wrappedKey
+	for rows.Next() {
+		var item wrappedKey
+		if err := rows.Scan(&item.workspaceID, &item.version, &item.wrapped, &item.masterID); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, item := range keys {
+		key, err := k.unwrap(item.workspaceID, item.version, item.wrapped, item.masterID)
+		if err != nil {
+			log.Printf("[WARN] data key %d/%d cannot be unwrapped: %v", item.workspaceID, item.version, err)
+			continue
+		}
+		wrapped, err := k.wrapDataKey(ctx, item.workspaceID, item.version, key)
+		if err != nil {
+			return err
+		}
+		if _, err := k.dbx.ExecContext(ctx, `
+			UPDATE workspace_data_keys SET wrapped_key=$3, master_key_id=$4
+			WHERE workspace_id=$1 AND version=$2 AND master_key_id=$5
+		`, item.workspaceID, item.version, wrapped, workspaceKeyWrap, item.masterID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resealContent moves plaintext rows and rows under retired keys to the
+// current key. The update is conditional on the version it read, so a row
+// rewritten in the meantime is left alone.
+func (r *Rekeyer) resealContent(ctx context.Context) error {
+	for _, column := range sealedColumns {
+		if err := r.resealColumn(ctx, column); err != nil {
+			return fmt.Errorf("%s.%s: %w", column.table, column.column, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rekeyer) resealColumn(ctx context.Context, column sealedColumn) error {
+	var afterID int64
+	for {
+		lastID, count, err := r.resealBatch(ctx, column, afterID)
+		if err != nil || count < r.policy.BatchSize {
+			return err
+		}
+		afterID = lastID
+	}
+}
+
+// resealBatch walks by id so rows that cannot be opened do not hold back
+// the rest of the table.
+func (r *Rekeyer) resealBatch(ctx context.Context, column sealedColumn, afterID int64) (int64, int, error) {
+	k := r.keyring
+	rows, err := k.dbx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT item.id, item.workspace_id, item.%[2]s, item.content_key_version
+		FROM %[1]s item
+		WHERE item.id>$2 AND (item.content_key_version IS NULL
+			OR EXISTS (
+				SELECT 1 FROM workspace_data_keys data_key
+				WHERE data_key.workspace_id=item.workspace_id
+					AND data_key.version=item.content_key_version
+					AND data_key.retired_at IS NOT NULL
+			))
+		ORDER BY item.id
+		LIMIT $1
+	`, column.table, column.column), r.policy.BatchSize, afterID)
+	if err != nil {
+		return 0, 0, err
+	}
+	type sealedRow struct {
+		id          int64
+		workspaceID int
+		value       []byte
+		version     sql.NullInt64
+	}
+	var items []sealedRow
+	for rows.Next() {
+		var item sealedRow
+		if err := rows.Scan(&item.id, &item.workspaceID, &item.value, &item.version); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, item := range items {
+		var value any
+		var version sql.NullInt64
+		if column.binary {
+			plaintext, err := k.OpenBytes(ctx, item.workspaceID, item.value, item.version)
+			if err != nil {
+				log.Printf("[WARN] %s %d cannot be opened: %v", column.table, item.id, err)
+				continue
+			}
+			value, version, err = k.SealBytes(ctx, item.workspaceID, plaintext)
+			if err != nil {
+				return 0, 0, err
+			}
+		} else {
+			plaintext, err := k.OpenText(ctx, item.workspaceID, string(item.value), item.version)
+			if err != nil {
+				log.Printf("[WARN] %s %d cannot be opened: %v", column.table, item.id, err)
+				continue
+			}
+			value, version, err = k.SealText(ctx, item.workspaceID, plaintext)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		if _, err := k.dbx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %[1]s SET %[2]s=$2, content_key_version=$3
+			WHERE id=$1 AND content_key_version IS NOT DISTINCT FROM $4
+		`, column.table, column.column), item.id, value, version, item.version); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(items) == 0 {
+		return afterID, 0, nil
+	}
+	return items[len(items)-1].id, len(items), nil
+}
+
+// destroyRetiredKeys deletes retired keys no sealed row refers to. A row that
+// could not be opened keeps its key, so nothing readable is lost here.
+func (r *Rekeyer) destroyRetiredKeys(ctx context.Context) error {
+	query := `
+		DELETE FROM workspace_data_keys data_key
+		WHERE data_key.retired_at < $1`
+	for _, column := range sealedColumns {
+		query += fmt.Sprintf(`
+			AND NOT EXISTS (
+				SELECT 1 FROM %s item
+				WHERE item.workspace_id=data_key.workspace_id
+					AND item.content_key_version=data_key.version
+			)`, column.table)
+	}
+	_, err := r.keyring.dbx.ExecContext(ctx, query, time.Now().Add(-retiredKeyGrace))
+	return err
+}
+
+func queryInts(ctx context.Context, dbx *sql.DB, query string, args ...any) ([]int, error) {
+	rows, err := dbx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []int{}
+	for rows.Next() {
+		var value int
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
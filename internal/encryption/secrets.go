@@ -0,0 +1,77 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// secretPrefix marks a secret sealed with a master key; the id of that key
+// follows it, so secrets stay readable while their master is listed as a
+// previous key.
+const secretPrefix = "sealed:v2:"
+
+// SealSecret seals a credential the app has to read back and that belongs to
+// a user or a connection rather than to workspace content, such as a TOTP
+// seed or an SSO client secret. It is sealed with the master key itself, so
+// it does not depend on JWT_SECRET or on a workspace data key. The purpose
+// and row id are bound as additional data, so a sealed value cannot be moved
+// to another row. Without a keyring the secret is stored as it is.
+func SealSecret(purpose string, rowID int, plaintext string) (string, error) {
+	return Default().SealSecret(purpose, rowID, plaintext)
+}
+
+func OpenSecret(purpose string, rowID int, stored string) (string, error) {
+	return Default().OpenSecret(purpose, rowID, stored)
+}
+
+// SecretIsCurrent reports whether stored is sealed with the current master
+// key, so startup can reseal the rest.
+func SecretIsCurrent(stored string) bool {
+	return Default().SecretIsCurrent(stored)
+}
+
+func (k *Keyring) SealSecret(purpose string, rowID int, plaintext string) (string, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	sealed, err := seal(k.master.key[:], []byte(plaintext), secretAAD(purpose, rowID))
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + k.master.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenSecret returns a value without the sealed prefix as it is: it was
+// stored before sealing.
+func (k *Keyring) OpenSecret(purpose string, rowID int, stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, secretPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if k == nil {
+		return "", ErrKeyringMissing
+	}
+	masterID, encoded, _ := strings.Cut(rest, ":")
+	master, ok := k.masters[masterID]
+	if !ok {
+		return "", ErrUnknownMaster
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode sealed secret: %w", err)
+	}
+	plaintext, err := open(master.key[:], raw, secretAAD(purpose, rowID))
+	return string(plaintext), err
+}
+
+func (k *Keyring) SecretIsCurrent(stored string) bool {
+	if k == nil {
+		return true
+	}
+	return strings.HasPrefix(stored, secretPrefix+k.master.id+":")
+}
+
+func secretAAD(purpose string, rowID int) []byte {
+	return []byte(fmt.Sprintf("secret:%s:%d", purpose, rowID))
+}
@@ -0,0 +1,128 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// workspaceKeyWrap is stored as the master_key_id of data keys wrapped by
+// their workspace's key-encryption key rather than by a master key.
+const workspaceKeyWrap = "workspace"
+
+// EnsureVaultSchema creates the key-encryption key table in the vault. The
+// vault is its own database, so it is not covered by the main migrations.
+func EnsureVaultSchema(ctx context.Context, vault *sql.DB) error {
+	_, err := vault.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS workspace_key_encryption_keys (
+			workspace_id INTEGER PRIMARY KEY,
+			wrapped_key TEXT NOT NULL,
+			master_key_id TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_workspace_key_encryption_keys_master
+			ON workspace_key_encryption_keys (master_key_id);
+	`)
+	return err
+}
+
+func (k *Keyring) DestroyWorkspace(ctx context.Context, workspaceID int) error {
+	if k == nil {
+		return nil
+	}
+	k.Forget(workspaceID)
+	_, err := k.vault.ExecContext(ctx, `
+		DELETE FROM workspace_key_encryption_keys WHERE workspace_id=$1
+	`, workspaceID)
+	return err
+}
+
+// workspaceKey returns the workspace's key-encryption key. Only sealing may
+// create one: a workspace whose key is gone was shredded, and reading its
+// content reports ErrKeyDestroyed instead of minting a key that opens
+// nothing.
+func (k *Keyring) workspaceKey(ctx context.Context, workspaceID int, create bool) ([]byte, error) {
+	k.mu.Lock()
+	key := k.workspaceKeys[workspaceID]
+	k.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	key, err := k.loadWorkspaceKey(ctx, workspaceID)
+	if errors.Is(err, sql.ErrNoRows) && create {
+		if err := k.insertWorkspaceKey(ctx, workspaceID); err != nil {
+			return nil, err
+		}
+		key, err = k.loadWorkspaceKey(ctx, workspaceID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyDestroyed
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.workspaceKeys[workspaceID] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+func (k *Keyring) loadWorkspaceKey(ctx context.Context, workspaceID int) ([]byte, error) {
+	var wrapped, masterID string
+	if err := k.vault.QueryRowContext(ctx, `
+		SELECT wrapped_key, master_key_id
+		FROM workspace_key_encryption_keys
+		WHERE workspace_id=$1
+	`, workspaceID).Scan(&wrapped, &masterID); err != nil {
+		return nil, err
+	}
+	return k.unwrapWorkspaceKey(workspaceID, wrapped, masterID)
+}
+
+// insertWorkspaceKey leaves an existing key alone, so the loser of two
+// instances creating the same key reads the winner's.
+func (k *Keyring) insertWorkspaceKey(ctx context.Context, workspaceID int) error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	wrapped, err := k.wrapWorkspaceKey(workspaceID, key)
+	if err != nil {
+		return err
+	}
+	_, err = k.vault.ExecContext(ctx, `
+		INSERT INTO workspace_key_encryption_keys (workspace_id, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, workspaceID, wrapped, k.master.id)
+	return err
+}
+
+func (k *Keyring) wrapWorkspaceKey(workspaceID int, key []byte) (string, error) {
+	sealed, err := seal(k.master.key[:], key, workspaceKeyAAD(workspaceID))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) unwrapWorkspaceKey(workspaceID int, wrapped string, masterID string) ([]byte, error) {
+	master, ok := k.masters[masterID]
+	if !ok {
+		return nil, ErrUnknownMaster
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(master.key[:], raw, workspaceKeyAAD(workspaceID))
+}
+
+func workspaceKeyAAD(workspaceID int) []byte {
+	return []byte(fmt.Sprintf("workspace:%d:key-encryption-key", workspaceID))
+}
@@ -4103,6 +4103,946 @@ var migrations = []Migration{
 				WHERE request_id <> '';
 		`,
 	},
+	{
+		ID: "20260814_086_tactical_plan_rollbacks",
+		SQL: `
+			ALTER TABLE v2_tactical_plan_versions
+				ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'activation';
+			ALTER TABLE v2_tactical_plan_versions DROP CONSTRAINT IF EXISTS v2_tactical_plan_versions_kind_check;
+			ALTER TABLE v2_tactical_plan_versions ADD CONSTRAINT v2_tactical_plan_versions_kind_check
+				CHECK (kind IN ('activation', 'rollback'));
+
+			CREATE TABLE IF NOT EXISTS v2_tactical_plan_rollbacks (
+				id SERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				tactical_plan_id INTEGER NOT NULL REFERENCES v2_tactical_plans(id) ON DELETE CASCADE,
+				rollback_type TEXT NOT NULL CHECK (rollback_type IN ('revision', 'applied_changes')),
+				target_revision INTEGER NULL,
+				source_message_id INTEGER NULL REFERENCES v2_tactics_chat_messages(id) ON DELETE SET NULL,
+				base_revision INTEGER NOT NULL,
+				result_revision INTEGER NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				changes_json JSONB NOT NULL DEFAULT '[]'::jsonb,
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_v2_tactical_plan_rollbacks_plan
+				ON v2_tactical_plan_rollbacks (workspace_id, tactical_plan_id, created_at DESC, id DESC);
+
+			ALTER TABLE v2_tactics_applied_changes
+				ADD COLUMN IF NOT EXISTS before_json JSONB NULL,
+				ADD COLUMN IF NOT EXISTS reverted_at TIMESTAMPTZ NULL,
+				ADD COLUMN IF NOT EXISTS reverted_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS rollback_id INTEGER NULL REFERENCES v2_tactical_plan_rollbacks(id) ON DELETE SET NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_v2_tactics_applied_changes_message
+				ON v2_tactics_applied_changes (workspace_id, tactical_plan_id, source_message_id, id);
+		`,
+	},
+	{
+		ID: "20260815_087_course_review_cadence",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS v2_course_review_schedules (
+				id SERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				course_id INTEGER NOT NULL REFERENCES v2_courses(id) ON DELETE CASCADE,
+				cadence_days INTEGER NOT NULL DEFAULT 14,
+				include_final BOOLEAN NOT NULL DEFAULT TRUE,
+				enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				next_review_at DATE NULL,
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE(course_id),
+				CHECK (cadence_days BETWEEN 1 AND 180)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_v2_course_review_schedules_due
+				ON v2_course_review_schedules (next_review_at)
+				WHERE enabled;
+
+			CREATE TABLE IF NOT EXISTS v2_course_review_packs (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				course_id INTEGER NOT NULL REFERENCES v2_courses(id) ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				due_date DATE NOT NULL DEFAULT CURRENT_DATE,
+				status TEXT NOT NULL DEFAULT 'queued',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				not_before TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				pack_json JSONB NULL,
+				draft_json JSONB NULL,
+				model TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				review_id BIGINT NULL REFERENCES v2_course_reviews(id) ON DELETE SET NULL,
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				prepared_at TIMESTAMPTZ NULL,
+				confirmed_at TIMESTAMPTZ NULL,
+				UNIQUE(course_id, kind, due_date),
+				CHECK (kind IN ('periodic', 'final', 'manual')),
+				CHECK (status IN ('queued', 'preparing', 'ready', 'failed', 'confirmed'))
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_v2_course_review_packs_queue
+				ON v2_course_review_packs (status, not_before, id);
+			CREATE INDEX IF NOT EXISTS idx_v2_course_review_packs_course
+				ON v2_course_review_packs (workspace_id, course_id, created_at DESC, id DESC);
+		`,
+	},
+	{
+		ID: "20260816_088_concurrent_courses",
+		SQL: `
+			ALTER TABLE v2_courses
+				ADD COLUMN IF NOT EXISTS is_primary BOOLEAN NOT NULL DEFAULT TRUE;
+
+			ALTER TABLE v2_courses
+				ALTER COLUMN is_primary SET DEFAULT FALSE;
+
+			ALTER TABLE v2_courses
+				DROP CONSTRAINT IF EXISTS v2_courses_workspace_id_strategy_id_key;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_v2_courses_primary
+				ON v2_courses (workspace_id, strategy_id)
+				WHERE is_primary;
+
+			DROP INDEX IF EXISTS idx_v2_courses_one_active;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_v2_courses_one_active_primary
+				ON v2_courses (workspace_id)
+				WHERE is_primary AND status = 'active' AND archived_at IS NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_v2_tactical_workstreams_course
+				ON v2_tactical_workstreams (workspace_id, course_id)
+				WHERE course_id IS NOT NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_v2_tasks_course_status
+				ON v2_tasks (workspace_id, course_id, status);
+		`,
+	},
+	{
+		ID: "20260817_089_okr_objectives",
+		SQL: `
+			ALTER TABLE v2_metric_targets
+				DROP CONSTRAINT IF EXISTS v2_metric_targets_scope_type_check;
+
+			ALTER TABLE v2_metric_targets
+				ADD CONSTRAINT v2_metric_targets_scope_type_check
+				CHECK (scope_type IN ('workspace', 'strategy', 'workstream', 'project', 'department'));
+
+			CREATE TABLE IF NOT EXISTS v2_okr_objectives (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				level TEXT NOT NULL,
+				level_id INTEGER NULL,
+				parent_id BIGINT NULL REFERENCES v2_okr_objectives(id) ON DELETE SET NULL,
+				quarter TEXT NOT NULL,
+				title TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				owner_user_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				status TEXT NOT NULL DEFAULT 'active',
+				sort_order INTEGER NOT NULL DEFAULT 0,
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				archived_at TIMESTAMPTZ NULL,
+				CHECK (level IN ('workspace', 'department', 'workstream')),
+				CHECK ((level = 'workspace') = (level_id IS NULL)),
+				CHECK (quarter ~ '^[0-9]{4}-Q[1-4]$'),
+				CHECK (status IN ('draft', 'active', 'closed'))
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_v2_okr_objectives_quarter
+				ON v2_okr_objectives (workspace_id, quarter, level, level_id)
+				WHERE archived_at IS NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_v2_okr_objectives_parent
+				ON v2_okr_objectives (parent_id)
+				WHERE parent_id IS NOT NULL;
+
+			CREATE TABLE IF NOT EXISTS v2_okr_key_results (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				objective_id BIGINT NOT NULL REFERENCES v2_okr_objectives(id) ON DELETE CASCADE,
+				target_id BIGINT NOT NULL REFERENCES v2_metric_targets(id) ON DELETE CASCADE,
+				title TEXT NOT NULL DEFAULT '',
+				weight NUMERIC NOT NULL DEFAULT 1,
+				sort_order INTEGER NOT NULL DEFAULT 0,
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				archived_at TIMESTAMPTZ NULL,
+				CHECK (weight > 0 AND weight <= 100)
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_v2_okr_key_results_target
+				ON v2_okr_key_results (objective_id, target_id)
+				WHERE archived_at IS NULL;
+
+			CREATE TABLE IF NOT EXISTS v2_okr_checkins (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				key_result_id BIGINT NOT NULL REFERENCES v2_okr_key_results(id) ON DELETE CASCADE,
+				quarter TEXT NOT NULL,
+				observation_id BIGINT NULL REFERENCES v2_metric_observations(id) ON DELETE SET NULL,
+				value NUMERIC NULL,
+				score NUMERIC NULL,
+				confidence NUMERIC NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				created_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				CHECK (confidence >= 0 AND confidence <= 1),
+				CHECK (score IS NULL OR (score >= 0 AND score <= 1))
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_v2_okr_checkins_key_result
+				ON v2_okr_checkins (workspace_id, key_result_id, created_at DESC, id DESC);
+		`,
+	},
+	{
+		ID: "20260818_090_auth_sessions",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS auth_sessions (
+				id BIGSERIAL PRIMARY KEY,
+				public_id TEXT NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				device_label TEXT NOT NULL DEFAULT '',
+				ip_address TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMPTZ NOT NULL,
+				revoked_at TIMESTAMPTZ NULL,
+				revoked_reason TEXT NOT NULL DEFAULT ''
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_sessions_user
+				ON auth_sessions (user_id, last_seen_at DESC)
+				WHERE revoked_at IS NULL;
+
+			CREATE TABLE IF NOT EXISTS auth_refresh_tokens (
+				id BIGSERIAL PRIMARY KEY,
+				session_id BIGINT NOT NULL REFERENCES auth_sessions(id) ON DELETE CASCADE,
+				token_hash TEXT NOT NULL UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_refresh_tokens_session
+				ON auth_refresh_tokens (session_id, created_at DESC);
+		`,
+	},
+	{
+		ID: "20260819_091_two_factor",
+		SQL: `
+			ALTER TABLE workspaces
+				ADD COLUMN IF NOT EXISTS require_two_factor BOOLEAN NOT NULL DEFAULT FALSE;
+
+			ALTER TABLE auth_sessions
+				ADD COLUMN IF NOT EXISTS authenticated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+			CREATE TABLE IF NOT EXISTS auth_totp_factors (
+				user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				secret TEXT NOT NULL,
+				confirmed_at TIMESTAMPTZ NULL,
+				last_used_step BIGINT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS auth_recovery_codes (
+				id BIGSERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				code_hash TEXT NOT NULL,
+				used_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE (user_id, code_hash)
+			);
+
+			CREATE TABLE IF NOT EXISTS auth_login_challenges (
+				id BIGSERIAL PRIMARY KEY,
+				token_hash TEXT NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_login_challenges_user
+				ON auth_login_challenges (user_id, created_at DESC);
+		`,
+	},
+	{
+		ID: "20260820_092_api_tokens",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id BIGSERIAL PRIMARY KEY,
+				kind TEXT NOT NULL CHECK (kind IN ('personal', 'workspace')),
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				workspace_id INTEGER NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				token_prefix TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT[] NOT NULL DEFAULT '{}',
+				expires_at TIMESTAMPTZ NULL,
+				last_used_at TIMESTAMPTZ NULL,
+				last_used_ip TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				revoked_at TIMESTAMPTZ NULL,
+				CHECK ((kind = 'workspace') = (workspace_id IS NOT NULL))
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_api_tokens_user
+				ON api_tokens (user_id, created_at DESC)
+				WHERE revoked_at IS NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_api_tokens_workspace
+				ON api_tokens (workspace_id, created_at DESC)
+				WHERE revoked_at IS NULL AND workspace_id IS NOT NULL;
+		`,
+	},
+	{
+		ID: "20260821_093_oidc_sso",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS workspace_sso_connections (
+				workspace_id INTEGER PRIMARY KEY REFERENCES workspaces(id) ON DELETE CASCADE,
+				issuer TEXT NOT NULL,
+				client_id TEXT NOT NULL,
+				client_secret TEXT NOT NULL DEFAULT '',
+				enforced BOOLEAN NOT NULL DEFAULT FALSE,
+				auto_join BOOLEAN NOT NULL DEFAULT TRUE,
+				updated_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS workspace_sso_domains (
+				domain TEXT PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspace_sso_connections(workspace_id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_workspace_sso_domains_workspace
+				ON workspace_sso_domains (workspace_id);
+
+			CREATE TABLE IF NOT EXISTS auth_identities (
+				id BIGSERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				issuer TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				email TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE (issuer, subject)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_identities_user
+				ON auth_identities (user_id);
+
+			CREATE TABLE IF NOT EXISTS auth_sso_states (
+				state_hash TEXT PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				nonce TEXT NOT NULL,
+				code_verifier TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_sso_states_expires
+				ON auth_sso_states (expires_at);
+		`,
+	},
+	{
+		ID: "20260822_094_scim_provisioning",
+		SQL: `
+			ALTER TABLE workspace_memberships
+				ADD COLUMN IF NOT EXISTS scim_external_id TEXT NULL;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_memberships_scim_external_id
+				ON workspace_memberships (workspace_id, scim_external_id)
+				WHERE scim_external_id IS NOT NULL;
+
+			ALTER TABLE v2_departments
+				ADD COLUMN IF NOT EXISTS scim_external_id TEXT NULL;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_v2_departments_scim_external_id
+				ON v2_departments (workspace_id, scim_external_id)
+				WHERE scim_external_id IS NOT NULL AND archived_at IS NULL;
+		`,
+	},
+	{
+		ID: "20260823_095_audit_events",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS audit_events (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NULL,
+				sequence BIGINT NOT NULL,
+				actor_user_id INTEGER NULL,
+				actor_email TEXT NOT NULL DEFAULT '',
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL DEFAULT '',
+				target_id TEXT NOT NULL DEFAULT '',
+				before_summary TEXT NOT NULL DEFAULT '',
+				after_summary TEXT NOT NULL DEFAULT '',
+				request_id TEXT NOT NULL DEFAULT '',
+				ip_address TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				prev_hash TEXT NOT NULL DEFAULT '',
+				hash TEXT NOT NULL
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_chain
+				ON audit_events ((COALESCE(workspace_id, 0)), sequence);
+
+			CREATE INDEX IF NOT EXISTS idx_audit_events_workspace_created
+				ON audit_events (workspace_id, created_at DESC, id DESC);
+
+			CREATE INDEX IF NOT EXISTS idx_audit_events_created
+				ON audit_events (created_at);
+
+			CREATE OR REPLACE FUNCTION reup_audit_events_append_only()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS trg_audit_events_append_only ON audit_events;
+			CREATE TRIGGER trg_audit_events_append_only
+				BEFORE UPDATE ON audit_events
+				FOR EACH ROW EXECUTE FUNCTION reup_audit_events_append_only();
+		`,
+	},
+	{
+		ID: "20260824_096_sign_in_risk",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS auth_login_failures (
+				email TEXT PRIMARY KEY,
+				failed_count INTEGER NOT NULL DEFAULT 0,
+				last_failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				blocked_until TIMESTAMPTZ NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS auth_sign_in_history (
+				id BIGSERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				method TEXT NOT NULL,
+				ip_address TEXT NOT NULL DEFAULT '',
+				ip_prefix TEXT NOT NULL DEFAULT '',
+				device_hash TEXT NOT NULL,
+				risk_level TEXT NOT NULL,
+				risk_reasons TEXT[] NOT NULL DEFAULT '{}',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_sign_in_history_user
+				ON auth_sign_in_history (user_id, created_at DESC);
+
+			CREATE TABLE IF NOT EXISTS auth_sign_in_alerts (
+				id BIGSERIAL PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				history_id BIGINT NOT NULL REFERENCES auth_sign_in_history(id) ON DELETE CASCADE,
+				token_hash TEXT NOT NULL UNIQUE,
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		ID: "20260825_097_email_sign_in",
+		SQL: `
+			ALTER TABLE workspaces
+				ADD COLUMN IF NOT EXISTS allow_email_sign_in BOOLEAN NOT NULL DEFAULT TRUE;
+			ALTER TABLE auth_email_codes
+				ADD COLUMN IF NOT EXISTS link_token_hash TEXT NULL;
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_auth_email_codes_link_token
+				ON auth_email_codes (link_token_hash)
+				WHERE link_token_hash IS NOT NULL;
+		`,
+	},
+	{
+		ID: "20260826_098_auth_signing_keys",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS auth_signing_keys (
+				kid TEXT PRIMARY KEY,
+				algorithm TEXT NOT NULL CHECK (algorithm IN ('EdDSA', 'ES256')),
+				public_key BYTEA NOT NULL,
+				private_key_ciphertext TEXT NOT NULL,
+				activates_at TIMESTAMPTZ NOT NULL,
+				retires_at TIMESTAMPTZ NULL,
+				expires_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+		`,
+	},
+	{
+		ID: "20260827_099_workspace_data_keys",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS workspace_data_keys (
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				version INTEGER NOT NULL,
+				wrapped_key TEXT NOT NULL,
+				master_key_id TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				retired_at TIMESTAMPTZ NULL,
+				PRIMARY KEY (workspace_id, version)
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_data_keys_active
+				ON workspace_data_keys (workspace_id) WHERE retired_at IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_workspace_data_keys_master
+				ON workspace_data_keys (master_key_id);
+
+			ALTER TABLE strategic_raw_sources
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_strategic_raw_sources_key_version
+				ON strategic_raw_sources (workspace_id, content_key_version);
+
+			ALTER TABLE strategic_document_chat_messages
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_strategic_document_chat_messages_key_version
+				ON strategic_document_chat_messages (workspace_id, content_key_version);
+
+			ALTER TABLE v2_strategy_chat_messages
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_v2_strategy_chat_messages_key_version
+				ON v2_strategy_chat_messages (workspace_id, content_key_version);
+
+			ALTER TABLE v2_tactics_chat_messages
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_v2_tactics_chat_messages_key_version
+				ON v2_tactics_chat_messages (workspace_id, content_key_version);
+
+			ALTER TABLE v2_task_brainstorm_messages
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_v2_task_brainstorm_messages_key_version
+				ON v2_task_brainstorm_messages (workspace_id, content_key_version);
+
+			ALTER TABLE v2_task_attachments
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_v2_task_attachments_key_version
+				ON v2_task_attachments (workspace_id, content_key_version);
+		`,
+	},
+	{
+		ID: "20260828_100_billing_statement_imports",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS billing_statement_imports (
+				id BIGSERIAL PRIMARY KEY,
+				file_name TEXT NOT NULL DEFAULT '',
+				format TEXT NOT NULL CHECK (format IN ('1c','csv')),
+				imported_by TEXT NOT NULL DEFAULT '',
+				payments_count INTEGER NOT NULL DEFAULT 0,
+				confirmed_count INTEGER NOT NULL DEFAULT 0,
+				review_count INTEGER NOT NULL DEFAULT 0,
+				duplicate_count INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS billing_statement_payments (
+				id BIGSERIAL PRIMARY KEY,
+				import_id BIGINT NOT NULL REFERENCES billing_statement_imports(id) ON DELETE CASCADE,
+				fingerprint TEXT NOT NULL UNIQUE,
+				document_number TEXT NOT NULL DEFAULT '',
+				payment_date DATE NULL,
+				amount NUMERIC(12,2) NOT NULL,
+				payer_name TEXT NOT NULL DEFAULT '',
+				payer_inn TEXT NOT NULL DEFAULT '',
+				payer_account TEXT NOT NULL DEFAULT '',
+				purpose TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL CHECK (status IN ('confirmed','review','resolved','ignored')),
+				invoice_id BIGINT NULL REFERENCES workspace_billing_invoices(id) ON DELETE SET NULL,
+				suggested_invoice_id BIGINT NULL REFERENCES workspace_billing_invoices(id) ON DELETE SET NULL,
+				review_reason TEXT NOT NULL DEFAULT '',
+				resolved_by TEXT NOT NULL DEFAULT '',
+				resolved_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_billing_statement_payments_review
+				ON billing_statement_payments(created_at, id)
+				WHERE status='review';
+			CREATE INDEX IF NOT EXISTS idx_billing_statement_payments_import
+				ON billing_statement_payments(import_id);
+		`,
+	},
+	{
+		ID: "20260829_101_billing_closing_documents",
+		SQL: `
+			ALTER TABLE workspace_billing_payments
+				ADD COLUMN IF NOT EXISTS order_kind TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS plan_code TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS billing_period TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS period_start TIMESTAMPTZ NULL,
+				ADD COLUMN IF NOT EXISTS period_end TIMESTAMPTZ NULL;
+
+			UPDATE workspace_billing_payments payment SET
+				order_kind=invoice.order_kind,
+				plan_code=invoice.plan_code,
+				billing_period=invoice.billing_period,
+				period_start=CASE WHEN invoice.order_kind='subscription' THEN payment.paid_at END,
+				period_end=CASE WHEN invoice.order_kind='subscription' THEN payment.paid_at + CASE invoice.billing_period
+					WHEN 'quarterly' THEN INTERVAL '3 months'
+					WHEN 'annual' THEN INTERVAL '1 year'
+					ELSE INTERVAL '1 month'
+				END END
+			FROM workspace_billing_invoices invoice
+			WHERE invoice.id=payment.invoice_id AND payment.order_kind='';
+
+			ALTER TABLE workspace_billing_documents
+				ADD COLUMN IF NOT EXISTS payment_id BIGINT NULL REFERENCES workspace_billing_payments(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS number TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS recipient_email TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS email_attempts INTEGER NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS emailed_at TIMESTAMPTZ NULL;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_billing_documents_payment_act
+				ON workspace_billing_documents (payment_id)
+				WHERE kind='act';
+			CREATE INDEX IF NOT EXISTS idx_workspace_billing_documents_unsent
+				ON workspace_billing_documents (created_at)
+				WHERE kind='act' AND emailed_at IS NULL;
+
+			CREATE TABLE IF NOT EXISTS billing_document_sequences (
+				seller_inn TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				year INTEGER NOT NULL,
+				last_number INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (seller_inn, kind, year)
+			);
+		`,
+	},
+	{
+		ID: "20260830_102_billing_plan_changes",
+		SQL: `
+			ALTER TABLE workspace_billing_orders DROP CONSTRAINT IF EXISTS workspace_billing_orders_order_kind_check;
+			ALTER TABLE workspace_billing_orders ADD CONSTRAINT workspace_billing_orders_order_kind_check
+				CHECK (order_kind IN ('subscription', 'quota_reset', 'plan_change'));
+
+			ALTER TABLE subscriptions
+				ADD COLUMN IF NOT EXISTS scheduled_plan_code TEXT NULL REFERENCES billing_plans(code),
+				ADD COLUMN IF NOT EXISTS scheduled_amount NUMERIC(12,2) NULL,
+				ADD COLUMN IF NOT EXISTS scheduled_member_limit INTEGER NULL;
+
+			ALTER TABLE workspace_ai_quotas
+				ADD COLUMN IF NOT EXISTS base_limit_prorated_until TIMESTAMPTZ NULL;
+		`,
+	},
+	{
+		ID: "20260831_103_billing_plan_price_versions",
+		SQL: `
+			ALTER TABLE billing_plans
+				ADD COLUMN IF NOT EXISTS ai_chat_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+				ADD COLUMN IF NOT EXISTS per_seat_pricing BOOLEAN NOT NULL DEFAULT FALSE;
+
+			UPDATE billing_plans SET ai_chat_enabled=FALSE, per_seat_pricing=TRUE, updated_at=NOW()
+			WHERE code='start';
+
+			CREATE TABLE IF NOT EXISTS billing_plan_prices (
+				id BIGSERIAL PRIMARY KEY,
+				plan_code TEXT NOT NULL REFERENCES billing_plans(code),
+				monthly_amount NUMERIC(12,2) NOT NULL CHECK (monthly_amount > 0),
+				quarterly_amount NUMERIC(12,2) NOT NULL CHECK (quarterly_amount > 0),
+				annual_amount NUMERIC(12,2) NOT NULL CHECK (annual_amount > 0),
+				currency TEXT NOT NULL DEFAULT 'RUB',
+				effective_from TIMESTAMPTZ NOT NULL,
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				UNIQUE (plan_code, effective_from)
+			);
+
+			INSERT INTO billing_plan_prices (
+				plan_code, monthly_amount, quarterly_amount, annual_amount, currency, effective_from, created_by
+			)
+			SELECT plan.code, plan.monthly_amount, plan.quarterly_amount, plan.annual_amount,
+				plan.currency, plan.created_at, 'migration'
+			FROM billing_plans plan
+			WHERE NOT EXISTS (SELECT 1 FROM billing_plan_prices price WHERE price.plan_code=plan.code);
+
+			ALTER TABLE subscriptions
+				ADD COLUMN IF NOT EXISTS price_version_id BIGINT NULL REFERENCES billing_plan_prices(id),
+				ADD COLUMN IF NOT EXISTS pending_price_version_id BIGINT NULL REFERENCES billing_plan_prices(id),
+				ADD COLUMN IF NOT EXISTS scheduled_price_version_id BIGINT NULL REFERENCES billing_plan_prices(id);
+
+			ALTER TABLE workspace_billing_orders
+				ADD COLUMN IF NOT EXISTS price_version_id BIGINT NULL REFERENCES billing_plan_prices(id);
+
+			UPDATE subscriptions subscription SET price_version_id=price.id
+			FROM billing_plan_prices price
+			WHERE price.plan_code=subscription.plan_code AND subscription.price_version_id IS NULL;
+			UPDATE subscriptions subscription SET pending_price_version_id=price.id
+			FROM billing_plan_prices price
+			WHERE price.plan_code=subscription.pending_plan_code AND subscription.pending_price_version_id IS NULL;
+		`,
+	},
+	{
+		ID: "20260901_104_billing_promo_codes_partners",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS billing_partners (
+				id BIGSERIAL PRIMARY KEY,
+				name TEXT NOT NULL,
+				referral_code TEXT NOT NULL UNIQUE,
+				contact_email TEXT NOT NULL DEFAULT '',
+				active BOOLEAN NOT NULL DEFAULT TRUE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE TABLE IF NOT EXISTS billing_promo_codes (
+				id BIGSERIAL PRIMARY KEY,
+				code TEXT NOT NULL UNIQUE,
+				discount_kind TEXT NOT NULL CHECK (discount_kind IN ('percent', 'fixed')),
+				discount_value NUMERIC(12,2) NOT NULL CHECK (discount_value > 0),
+				currency TEXT NOT NULL DEFAULT 'RUB',
+				max_redemptions INTEGER NULL CHECK (max_redemptions IS NULL OR max_redemptions > 0),
+				redemptions INTEGER NOT NULL DEFAULT 0,
+				starts_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				expires_at TIMESTAMPTZ NULL,
+				plan_codes TEXT[] NOT NULL DEFAULT '{}',
+				billing_periods TEXT[] NOT NULL DEFAULT '{}',
+				first_payment_only BOOLEAN NOT NULL DEFAULT FALSE,
+				partner_id BIGINT NULL REFERENCES billing_partners(id) ON DELETE SET NULL,
+				active BOOLEAN NOT NULL DEFAULT TRUE,
+				created_by TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				CHECK (discount_kind <> 'percent' OR discount_value < 100),
+				CHECK (expires_at IS NULL OR expires_at > starts_at)
+			);
+
+			CREATE TABLE IF NOT EXISTS billing_promo_redemptions (
+				id BIGSERIAL PRIMARY KEY,
+				promo_code_id BIGINT NOT NULL REFERENCES billing_promo_codes(id) ON DELETE CASCADE,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				order_id BIGINT NOT NULL UNIQUE REFERENCES workspace_billing_orders(id) ON DELETE CASCADE,
+				discount_amount NUMERIC(12,2) NOT NULL,
+				redeemed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS idx_billing_promo_redemptions_workspace
+				ON billing_promo_redemptions(promo_code_id, workspace_id);
+
+			CREATE TABLE IF NOT EXISTS workspace_partner_attributions (
+				workspace_id INTEGER PRIMARY KEY REFERENCES workspaces(id) ON DELETE CASCADE,
+				partner_id BIGINT NOT NULL REFERENCES billing_partners(id) ON DELETE CASCADE,
+				source TEXT NOT NULL CHECK (source IN ('referral', 'promo_code')),
+				code TEXT NOT NULL,
+				attributed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS idx_workspace_partner_attributions_partner
+				ON workspace_partner_attributions(partner_id, attributed_at);
+
+			ALTER TABLE workspace_billing_orders
+				ADD COLUMN IF NOT EXISTS promo_code_id BIGINT NULL REFERENCES billing_promo_codes(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS discount_amount NUMERIC(12,2) NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		ID: "20260902_105_billing_dunning",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS billing_dunning_cases (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+				status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'recovered', 'expired')),
+				reason TEXT NOT NULL DEFAULT '',
+				amount NUMERIC(12,2) NOT NULL,
+				currency TEXT NOT NULL DEFAULT 'RUB',
+				started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				grace_until TIMESTAMPTZ NOT NULL,
+				retry_attempts INTEGER NOT NULL DEFAULT 0,
+				next_retry_at TIMESTAMPTZ NULL,
+				emails_sent INTEGER NOT NULL DEFAULT 0,
+				next_email_at TIMESTAMPTZ NULL,
+				resolved_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_billing_dunning_cases_open
+				ON billing_dunning_cases(workspace_id) WHERE status='open';
+			CREATE INDEX IF NOT EXISTS idx_billing_dunning_cases_workspace
+				ON billing_dunning_cases(workspace_id, started_at DESC);
+
+			CREATE TABLE IF NOT EXISTS billing_dunning_events (
+				id BIGSERIAL PRIMARY KEY,
+				case_id BIGINT NOT NULL REFERENCES billing_dunning_cases(id) ON DELETE CASCADE,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				detail TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+			CREATE INDEX IF NOT EXISTS idx_billing_dunning_events_case
+				ON billing_dunning_events(case_id, created_at);
+		`,
+	},
+	{
+		ID: "20260903_106_payment_providers",
+		SQL: `
+			ALTER TABLE payment_events
+				ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT 'cloudpayments';
+			CREATE INDEX IF NOT EXISTS idx_workspace_billing_orders_idempotency_key
+				ON workspace_billing_orders (idempotency_key);
+		`,
+	},
+	{
+		ID: "20260904_107_billing_refunds",
+		SQL: `
+			ALTER TABLE workspace_billing_payments
+				ADD COLUMN IF NOT EXISTS refunded_payment_id BIGINT NULL REFERENCES workspace_billing_payments(id) ON DELETE SET NULL,
+				ADD COLUMN IF NOT EXISTS refund_reason TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS refunded_by TEXT NOT NULL DEFAULT '';
+
+			CREATE INDEX IF NOT EXISTS idx_workspace_billing_payments_refunded
+				ON workspace_billing_payments (refunded_payment_id)
+				WHERE refunded_payment_id IS NOT NULL;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_billing_documents_payment_credit_note
+				ON workspace_billing_documents (payment_id)
+				WHERE kind='credit_note';
+		`,
+	},
+	{
+		ID: "20260905_108_ai_quota_allocations",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS workspace_ai_quota_allocations (
+				id BIGSERIAL PRIMARY KEY,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+				department_id INTEGER NULL REFERENCES v2_departments(id) ON DELETE CASCADE,
+				ai_module_group TEXT NOT NULL DEFAULT '',
+				limit_kind TEXT NOT NULL DEFAULT 'tokens',
+				limit_value INTEGER NOT NULL,
+				updated_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+				CHECK (limit_kind IN ('tokens', 'percent')),
+				CHECK (limit_value >= 0),
+				CHECK (limit_kind <> 'percent' OR limit_value <= 100),
+				CHECK (user_id IS NULL OR department_id IS NULL),
+				CHECK (user_id IS NOT NULL OR department_id IS NOT NULL OR ai_module_group <> '')
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_ai_quota_allocations_target
+				ON workspace_ai_quota_allocations (
+					workspace_id, COALESCE(user_id, 0), COALESCE(department_id, 0), ai_module_group
+				);
+
+			CREATE INDEX IF NOT EXISTS idx_workspace_ai_quota_events_user
+				ON workspace_ai_quota_events (workspace_id, user_id, created_at DESC)
+				WHERE event_type='ai_call';
+
+			CREATE INDEX IF NOT EXISTS idx_v2_ai_call_logs_workspace_user
+				ON v2_ai_call_logs (workspace_id, user_id, created_at DESC);
+		`,
+	},
+	{
+		ID: "20260906_109_sso_domain_verification",
+		SQL: `
+			ALTER TABLE workspace_sso_domains
+				ADD COLUMN IF NOT EXISTS verification_token TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ NULL;
+
+			UPDATE workspace_sso_domains
+			SET verification_token=md5(random()::text || clock_timestamp()::text || workspace_id::text || domain)
+			WHERE verification_token='';
+
+			ALTER TABLE workspace_sso_domains DROP CONSTRAINT IF EXISTS workspace_sso_domains_pkey;
+			ALTER TABLE workspace_sso_domains ADD PRIMARY KEY (workspace_id, domain);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_sso_domains_verified
+				ON workspace_sso_domains (domain)
+				WHERE verified_at IS NOT NULL;
+
+			CREATE TABLE IF NOT EXISTS auth_sso_link_requests (
+				token_hash TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+				issuer TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				email TEXT NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL,
+				used_at TIMESTAMPTZ NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_auth_sso_link_requests_user
+				ON auth_sso_link_requests (user_id);
+		`,
+	},
+	{
+		ID: "20260907_110_seal_claims_and_workspace_documents",
+		SQL: `
+			ALTER TABLE strategic_claims
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_strategic_claims_key_version
+				ON strategic_claims (workspace_id, content_key_version);
+
+			ALTER TABLE workspace_documents
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			ALTER TABLE workspace_documents
+				DROP CONSTRAINT IF EXISTS workspace_documents_content_check;
+			CREATE INDEX IF NOT EXISTS idx_workspace_documents_key_version
+				ON workspace_documents (workspace_id, content_key_version);
+
+			ALTER TABLE workspace_document_versions
+				ADD COLUMN IF NOT EXISTS content_key_version INTEGER NULL;
+			CREATE INDEX IF NOT EXISTS idx_workspace_document_versions_key_version
+				ON workspace_document_versions (workspace_id, content_key_version);
+		`,
+	},
+	{
+		ID: "20260908_111_reserve_promo_redemptions",
+		SQL: `
+			ALTER TABLE workspace_billing_orders
+				ADD COLUMN IF NOT EXISTS promo_released_at TIMESTAMPTZ NULL;
+			UPDATE workspace_billing_orders
+			SET promo_released_at=NOW()
+			WHERE promo_code_id IS NOT NULL AND status <> 'paid' AND promo_released_at IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_workspace_billing_orders_promo_reserved
+				ON workspace_billing_orders (created_at)
+				WHERE promo_code_id IS NOT NULL AND promo_released_at IS NULL;
+		`,
+	},
+	{
+		ID: "20260909_112_workspace_deletions",
+		SQL: `
+			CREATE TABLE IF NOT EXISTS workspace_deletions (
+				workspace_id INTEGER PRIMARY KEY,
+				deleted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			);
+
+			CREATE OR REPLACE FUNCTION reup_record_workspace_deletion()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				INSERT INTO workspace_deletions (workspace_id) VALUES (OLD.id)
+				ON CONFLICT DO NOTHING;
+				RETURN OLD;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS trg_record_workspace_deletion ON workspaces;
+			CREATE TRIGGER trg_record_workspace_deletion
+				AFTER DELETE ON workspaces
+				FOR EACH ROW EXECUTE FUNCTION reup_record_workspace_deletion();
+		`,
+	},
+	{
+		ID: "20260910_113_pending_refunds",
+		SQL: `
+			ALTER TABLE workspace_billing_payments
+				ADD COLUMN IF NOT EXISTS provider_request_key TEXT NOT NULL DEFAULT '';
+
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_billing_payments_pending_refund
+				ON workspace_billing_payments (refunded_payment_id)
+				WHERE status='refund_pending';
+		`,
+	},
 }
 
 func Run(dbx *sql.DB) error {
@@ -18,6 +18,8 @@ type RetentionPolicy struct {
 	BackgroundJobs  time.Duration
 	LegalEvidence   time.Duration
 	PrivacyRequests time.Duration
+	AuditEvents     time.Duration
+	SignInHistory   time.Duration
 }
 
 type RetentionRunner struct {
@@ -65,6 +67,9 @@ func (r *RetentionRunner) run(ctx context.Context) {
 		retention time.Duration
 	}{
 		{"auth codes", `DELETE FROM auth_email_codes WHERE created_at < $1 AND (used_at IS NOT NULL OR expires_at < NOW())`, r.policy.AuthCodes},
+		{"login failures", `DELETE FROM auth_login_failures WHERE last_failed_at < $1 AND (blocked_until IS NULL OR blocked_until < NOW())`, r.policy.AuthCodes},
+		{"sign-in alerts", `DELETE FROM auth_sign_in_alerts WHERE created_at < $1 AND (used_at IS NOT NULL OR expires_at < NOW())`, r.policy.AuthCodes},
+		{"sign-in history", `DELETE FROM auth_sign_in_history WHERE created_at < $1`, r.policy.SignInHistory},
 		{"HTTP request logs", `DELETE FROM v2_http_request_logs WHERE created_at < $1`, r.policy.HTTPRequestLogs},
 		{"product events", `DELETE FROM v2_product_events WHERE created_at < $1`, r.policy.ProductEvents},
 		{"AI call logs", `DELETE FROM v2_ai_call_logs WHERE created_at < $1`, r.policy.AICallLogs},
@@ -77,6 +82,13 @@ func (r *RetentionRunner) run(ctx context.Context) {
 					GROUP BY latest.subject_key, latest.document_type
 				)`, r.policy.LegalEvidence},
 		{"privacy requests", `DELETE FROM privacy_requests WHERE updated_at < $1 AND status IN ('completed', 'rejected', 'cancelled')`, r.policy.PrivacyRequests},
+		{"audit events", `
+			DELETE FROM audit_events event
+			WHERE event.created_at < $1
+				AND event.id NOT IN (
+					SELECT MAX(latest.id) FROM audit_events latest
+					GROUP BY COALESCE(latest.workspace_id, 0)
+				)`, r.policy.AuditEvents},
 	}
 	now := time.Now().UTC()
 	for _, statement := range statements {
@@ -47,7 +47,7 @@ func (l *Limiter) Wrap(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		if !l.allow(clientIP(r)) {
+		if !l.allow(ClientIP(r)) {
 			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("Retry-After", "60")
 			w.WriteHeader(http.StatusTooManyRequests)
@@ -154,7 +154,8 @@ func requestBodyLimit(path string) int64 {
 		"/api/v2/tactics-facilitator/files",
 		"/api/v2/tactics-advisor/files",
 		"/api/v2/tasks/files",
-		"/api/v2/tasks/completion-files":
+		"/api/v2/tasks/completion-files",
+		"/api/v2/admin/billing/statements":
 		return audioRequestLimit
 	default:
 		return defaultRequestLimit
@@ -182,7 +183,9 @@ func writePayloadTooLarge(w http.ResponseWriter) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": "payload_too_large"})
 }
 
-func clientIP(r *http.Request) string {
+// ClientIP returns the caller address, trusting proxy headers only from a
+// loopback peer.
+func ClientIP(r *http.Request) string {
 	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
 	if err != nil {
 		host = strings.TrimSpace(r.RemoteAddr)
@@ -66,11 +66,11 @@ func TestClientIPOnlyTrustsForwardedHeaderFromLoopback(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, "/", nil)
 	request.RemoteAddr = "198.51.100.7:1234"
 	request.Header.Set("X-Forwarded-For", "203.0.113.9")
-	if got := clientIP(request); got != "198.51.100.7" {
+	if got := ClientIP(request); got != "198.51.100.7" {
 		t.Fatalf("expected direct peer, got %s", got)
 	}
 	request.RemoteAddr = "127.0.0.1:1234"
-	if got := clientIP(request); got != "203.0.113.9" {
+	if got := ClientIP(request); got != "203.0.113.9" {
 		t.Fatalf("expected trusted forwarded address, got %s", got)
 	}
 }
@@ -108,6 +108,7 @@ func TestFileUploadRoutesUseTheirDocumentLimits(t *testing.T) {
 		{path: "/api/v2/tactics-advisor/files", size: 25 << 20, limit: audioRequestLimit},
 		{path: "/api/v2/tasks/files", size: 25 << 20, limit: audioRequestLimit},
 		{path: "/api/v2/tasks/completion-files", size: 25 << 20, limit: audioRequestLimit},
+		{path: "/api/v2/admin/billing/statements", size: 10 << 20, limit: audioRequestLimit},
 	}
 	for _, test := range tests {
 		t.Run(test.path, func(t *testing.T) {
@@ -2,6 +2,7 @@ package subscriptions
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -10,10 +11,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"reup-goals-backend/internal/config"
+	v2billing "reup-goals-backend/internal/v2/billing"
 )
 
 type CloudPaymentsClient struct {
@@ -44,6 +49,14 @@ func NewCloudPaymentsClient(cfg *config.Config) *CloudPaymentsClient {
 	}
 }
 
+func (c *CloudPaymentsClient) Name() string {
+	return v2billing.ProviderCloudPayments
+}
+
+func (c *CloudPaymentsClient) Configured() bool {
+	return c != nil && c.publicID != ""
+}
+
 func (c *CloudPaymentsClient) PublicID() string {
 	return c.publicID
 }
@@ -83,22 +96,180 @@ func (c *CloudPaymentsClient) VerifyWebhook(rawBody []byte, hmacHeader string) b
 	return hmac.Equal([]byte(expected), []byte(hmacHeader))
 }
 
-func (c *CloudPaymentsClient) CancelSubscription(subscriptionID string) error {
+// CheckoutSession returns the widget config: the first payment charges the
+// order amount and recurrent orders start a subscription at the renewal
+// amount from StartDate.
+func (c *CloudPaymentsClient) CheckoutSession(ctx context.Context, request CheckoutRequest) (CheckoutSession, error) {
+	if !c.Configured() {
+		return CheckoutSession{}, ErrProviderNotConfigured
+	}
+	config := map[string]any{
+		"public_id": c.publicID, "description": request.Description,
+		"first_payment_amount": request.Amount, "amount": request.RenewalAmount,
+		"currency": request.Currency, "account_id": accountIDForUser(request.UserID),
+		"email": request.Email, "trial_days": 0,
+		"start_date": request.StartDate.Format(time.RFC3339), "period_months": request.PeriodMonths,
+		"invoice_id": strconv.FormatInt(request.OrderID, 10), "recurrent": request.Recurrent,
+	}
+	for key, value := range request.Extra {
+		config[key] = value
+	}
+	return CheckoutSession{Provider: c.Name(), Mode: "widget", Config: config}, nil
+}
+
+// ParseWebhook checks the Content-HMAC signature of a form-encoded
+// notification. CloudPayments posts each notification kind to its own URL,
+// so the event type is the last path segment.
+func (c *CloudPaymentsClient) ParseWebhook(ctx context.Context, r *http.Request) (WebhookEvent, error) {
+	rawBody := readRawBody(r)
+	hmacHeader := r.Header.Get("Content-HMAC")
+	if hmacHeader == "" {
+		hmacHeader = r.Header.Get("X-Content-HMAC")
+	}
+	if !c.VerifyWebhook(rawBody, hmacHeader) {
+		return WebhookEvent{}, ErrWebhookRejected
+	}
+	form, err := url.ParseQuery(string(rawBody))
+	if err != nil {
+		return WebhookEvent{}, ErrWebhookRejected
+	}
+	eventType := path.Base(r.URL.Path)
+	switch eventType {
+	case EventCheck, EventPay, EventFail, EventRecurrent, EventCancel:
+	default:
+		return WebhookEvent{}, ErrWebhookRejected
+	}
+	orderID, _ := cloudPaymentsOrderID(form)
+	return WebhookEvent{
+		Type:          eventType,
+		OrderID:       orderID,
+		AccountID:     formValue(form, "AccountId"),
+		TransactionID: formValue(form, "TransactionId"),
+		// Ordered widget payments expose the recurrent subscription
+		// explicitly. `Id` belongs to other notifications and is only read
+		// by the legacy handling of payments without an order.
+		SubscriptionID: formValue(form, "SubscriptionId"),
+		Token:          formValue(form, "Token"),
+		Amount:         parseAmount(formValue(form, "Amount")),
+		Currency:       formValue(form, "Currency"),
+		Status:         formValue(form, "Status"),
+		Reason:         formValue(form, "Reason"),
+		Fields:         form,
+	}, nil
+}
+
+func (c *CloudPaymentsClient) AcknowledgeWebhook(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeWebhookCode(w, 13)
+		return
+	}
+	writeWebhookCode(w, 0)
+}
+
+func (c *CloudPaymentsClient) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	return c.call(ctx, "/subscriptions/cancel", map[string]any{"Id": subscriptionID}, "cloudpayments_cancel", nil)
+}
+
+// Refund returns a payment; CloudPayments refunds the whole remaining
+// amount when Amount is zero.
+func (c *CloudPaymentsClient) Refund(ctx context.Context, refund Refund) (string, error) {
+	transactionID, err := strconv.ParseInt(strings.TrimSpace(refund.TransactionID), 10, 64)
+	if err != nil || transactionID <= 0 {
+		return "", errors.New("cloudpayments_refund_transaction_invalid")
+	}
+	payload := map[string]any{"TransactionId": transactionID}
+	if refund.Amount > 0 {
+		payload["Amount"] = refund.Amount
+	}
+	var model struct {
+		TransactionID int64 `json:"TransactionId"`
+	}
+	// CloudPayments answers a repeated X-Request-ID with the first result,
+	// so a retried refund is not made twice.
+	if err := c.send(ctx, "/payments/refund", refund.IdempotenceKey, payload, "cloudpayments_refund", &model); err != nil {
+		return "", err
+	}
+	if model.TransactionID == 0 {
+		return "", errors.New("cloudpayments_refund_transaction_missing")
+	}
+	return strconv.FormatInt(model.TransactionID, 10), nil
+}
+
+// UpdateSubscription changes what a recurrent subscription charges from its
+// next payment on.
+func (c *CloudPaymentsClient) UpdateSubscription(subscriptionID string, amount float64, description string) error {
+	return c.post("/subscriptions/update", map[string]any{
+		"Id": subscriptionID, "Amount": amount, "Description": description,
+	}, "cloudpayments_update")
+}
+
+// RescheduleSubscription moves the next recurrent charge, e.g. after a
+// failed renewal was recovered by a token charge that paid a new period.
+func (c *CloudPaymentsClient) RescheduleSubscription(subscriptionID string, startDate time.Time) error {
+	return c.post("/subscriptions/update", map[string]any{
+		"Id": subscriptionID, "StartDate": startDate.UTC().Format(time.RFC3339),
+	}, "cloudpayments_update")
+}
+
+// ChargeRecurring charges a saved card token. The charge carries the
+// billing order as InvoiceId, so its pay notification confirms the same
+// order.
+func (c *CloudPaymentsClient) ChargeRecurring(ctx context.Context, charge v2billing.DunningCharge) (string, error) {
+	var model struct {
+		TransactionID     int64  `json:"TransactionId"`
+		Reason            string `json:"Reason"`
+		CardHolderMessage string `json:"CardHolderMessage"`
+	}
+	err := c.call(ctx, "/payments/tokens/charge", map[string]any{
+		"Amount":      charge.Amount,
+		"Currency":    charge.Currency,
+		"AccountId":   accountIDForUser(charge.UserID),
+		"Token":       charge.Token,
+		"InvoiceId":   strconv.FormatInt(charge.OrderID, 10),
+		"Description": charge.Description,
+		"Email":       charge.Email,
+	}, "cloudpayments_charge", &model)
+	if err != nil {
+		if model.Reason != "" {
+			return "", errors.New(model.Reason)
+		}
+		return "", err
+	}
+	if model.TransactionID == 0 {
+		return "", errors.New("cloudpayments_charge_transaction_missing")
+	}
+	return strconv.FormatInt(model.TransactionID, 10), nil
+}
+
+func (c *CloudPaymentsClient) post(path string, payload map[string]any, failure string) error {
+	return c.call(context.Background(), path, payload, failure, nil)
+}
+
+// call posts to the CloudPayments API. The response Model is decoded into
+// model when given, also for declined requests: it explains the decline.
+func (c *CloudPaymentsClient) call(ctx context.Context, path string, payload map[string]any, failure string, model any) error {
+	return c.send(ctx, path, "", payload, failure, model)
+}
+
+func (c *CloudPaymentsClient) send(ctx context.Context, path, requestID string, payload map[string]any, failure string, model any) error {
 	if c.publicID == "" || c.secret == "" {
 		return errors.New("cloudpayments_not_configured")
 	}
 
-	body, err := json.Marshal(map[string]string{"Id": subscriptionID})
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/subscriptions/cancel", bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 	req.SetBasicAuth(c.publicID, c.secret)
 	req.Header.Set("Content-Type", "application/json")
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -111,21 +282,27 @@ func (c *CloudPaymentsClient) CancelSubscription(subscriptionID string) error {
 		return err
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("cloudpayments_cancel_http_%d", resp.StatusCode)
+		return fmt.Errorf("%s_http_%d", failure, resp.StatusCode)
 	}
 
 	var parsed struct {
-		Success bool   `json:"Success"`
-		Message string `json:"Message"`
+		Success bool            `json:"Success"`
+		Message string          `json:"Message"`
+		Model   json.RawMessage `json:"Model"`
 	}
 	if err := json.Unmarshal(data, &parsed); err != nil {
 		return err
 	}
+	if model != nil && len(parsed.Model) > 0 && string(parsed.Model) != "null" {
+		if err := json.Unmarshal(parsed.Model, model); err != nil {
+			return err
+		}
+	}
 	if !parsed.Success {
 		if parsed.Message == "" {
-			parsed.Message = "cloudpayments_cancel_failed"
+			parsed.Message = failure + "_failed"
 		}
-		return errors.New(parsed.Message)
+		return declinedError(parsed.Message)
 	}
 
 	return nil
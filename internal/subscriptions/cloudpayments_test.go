@@ -1,10 +1,16 @@
 package subscriptions
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	v2billing "reup-goals-backend/internal/v2/billing"
 )
 
 func TestVerifyWebhookFailsClosedWithoutSecret(t *testing.T) {
@@ -42,3 +48,72 @@ func TestPaymentEventPayloadAllowlist(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateSubscriptionSendsAmount(t *testing.T) {
+	var path string
+	var payload map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if user, password, ok := r.BasicAuth(); !ok || user != "public" || password != "secret" {
+			t.Errorf("unexpected credentials %q:%q", user, password)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_, _ = w.Write([]byte(`{"Success":true}`))
+	}))
+	defer server.Close()
+	client := &CloudPaymentsClient{publicID: "public", secret: "secret", baseURL: server.URL, client: server.Client()}
+
+	if err := client.UpdateSubscription("sc_1", 3490, "REUP.goals · Founder"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/subscriptions/update" || payload["Id"] != "sc_1" || payload["Amount"] != 3490.0 {
+		t.Fatalf("request = %s %v", path, payload)
+	}
+}
+
+func TestUpdateSubscriptionReportsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Success":false}`))
+	}))
+	defer server.Close()
+	client := &CloudPaymentsClient{publicID: "public", secret: "secret", baseURL: server.URL, client: server.Client()}
+
+	if err := client.UpdateSubscription("sc_1", 3490, ""); err == nil || err.Error() != "cloudpayments_update_failed" {
+		t.Fatalf("UpdateSubscription error = %v", err)
+	}
+}
+
+func TestChargeRecurringReturnsTransaction(t *testing.T) {
+	var path string
+	var payload map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_, _ = w.Write([]byte(`{"Success":true,"Model":{"TransactionId":504}}`))
+	}))
+	defer server.Close()
+	client := &CloudPaymentsClient{publicID: "public", secret: "secret", baseURL: server.URL, client: server.Client()}
+
+	transactionID, err := client.ChargeRecurring(context.Background(), v2billing.DunningCharge{
+		OrderID: 42, UserID: 7, Token: "tk_1", Amount: 3490, Currency: "RUB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transactionID != "504" || path != "/payments/tokens/charge" ||
+		payload["InvoiceId"] != "42" || payload["AccountId"] != "reup_user_7" || payload["Token"] != "tk_1" {
+		t.Fatalf("ChargeRecurring = %q, request = %s %v", transactionID, path, payload)
+	}
+}
+
+func TestChargeRecurringReportsDecline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Success":false,"Message":null,"Model":{"TransactionId":505,"Reason":"InsufficientFunds"}}`))
+	}))
+	defer server.Close()
+	client := &CloudPaymentsClient{publicID: "public", secret: "secret", baseURL: server.URL, client: server.Client()}
+
+	if _, err := client.ChargeRecurring(context.Background(), v2billing.DunningCharge{OrderID: 42, UserID: 7}); err == nil || err.Error() != "InsufficientFunds" {
+		t.Fatalf("ChargeRecurring error = %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package subscriptions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/auth"
+	v2billing "reup-goals-backend/internal/v2/billing"
+)
+
+const (
+	dunningAdvisoryLock int64 = 528105240
+	dunningBatch              = 100
+)
+
+// DunningRunner works through open dunning cases: it charges the saved card
+// again on the retry schedule, reminds the owner with a link to update the
+// card and expires subscriptions whose grace period is over. Each pass also
+// releases the promo code reservations of orders that were never paid.
+type DunningRunner struct {
+	dbx       *sql.DB
+	billing   *v2billing.Service
+	providers Providers
+	email     *auth.EmailService
+	updateURL string
+	interval  time.Duration
+}
+
+func NewDunningRunner(dbx *sql.DB, billing *v2billing.Service, providers Providers, email *auth.EmailService, frontendBaseURL string, interval time.Duration) *DunningRunner {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &DunningRunner{
+		dbx: dbx, billing: billing, providers: providers, email: email, interval: interval,
+		updateURL: strings.TrimRight(frontendBaseURL, "/") + "/account?section=subscription&payment=update",
+	}
+}
+
+func (r *DunningRunner) Start(ctx context.Context) {
+	go func() {
+		r.run(ctx)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+}
+
+func (r *DunningRunner) run(ctx context.Context) {
+	conn, err := r.dbx.Conn(ctx)
+	if err != nil {
+		log.Printf("[WARN] dunning connection failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, dunningAdvisoryLock).Scan(&locked); err != nil || !locked {
+		return
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, dunningAdvisoryLock)
+
+	if _, err := r.billing.ReleasePromoReservations(ctx); err != nil {
+		log.Printf("[WARN] promo reservation release failed: %v", err)
+	}
+	cases, err := r.billing.DueDunningCases(ctx, dunningBatch)
+	if err != nil {
+		log.Printf("[WARN] dunning lookup failed: %v", err)
+		return
+	}
+	for _, item := range cases {
+		now := time.Now().UTC()
+		if !now.Before(item.GraceUntil) {
+			r.expire(ctx, item)
+			continue
+		}
+		if item.NextRetryAt != nil && !now.Before(*item.NextRetryAt) && r.retry(ctx, item) {
+			continue
+		}
+		if item.NextEmailAt != nil && !now.Before(*item.NextEmailAt) {
+			r.remind(ctx, item)
+		}
+	}
+}
+
+// retry charges the saved card and reports whether the case was recovered.
+func (r *DunningRunner) retry(ctx context.Context, item v2billing.DunningCase) bool {
+	charge, err := r.billing.PrepareDunningRetry(ctx, item.ID)
+	if err != nil {
+		if !errors.Is(err, v2billing.ErrDunningRetryUnavailable) {
+			log.Printf("[WARN] dunning retry for case %d failed: %v", item.ID, err)
+			return false
+		}
+		if err := r.billing.RecordDunningRetry(ctx, item.ID, 0, err); err != nil {
+			log.Printf("[WARN] dunning retry for case %d not recorded: %v", item.ID, err)
+		}
+		return false
+	}
+	provider, ok := r.providers[charge.Provider]
+	if !ok {
+		if err := r.billing.RecordDunningRetry(ctx, item.ID, charge.OrderID, ErrProviderNotConfigured); err != nil {
+			log.Printf("[WARN] dunning retry for case %d not recorded: %v", item.ID, err)
+		}
+		return false
+	}
+	transactionID, chargeErr := provider.ChargeRecurring(ctx, charge)
+	if chargeErr != nil {
+		// A pending charge keeps its order: the provider's webhook confirms
+		// it once the charge goes through.
+		orderID := charge.OrderID
+		if errors.Is(chargeErr, ErrChargePending) {
+			orderID = 0
+		}
+		if err := r.billing.RecordDunningRetry(ctx, item.ID, orderID, chargeErr); err != nil {
+			log.Printf("[WARN] dunning retry for case %d not recorded: %v", item.ID, err)
+		}
+		return false
+	}
+	// The pay notification for this charge confirms the same order, so
+	// whichever arrives second finds it paid and does nothing.
+	confirmation, err := r.billing.ConfirmCardPaymentOrder(
+		ctx, charge.Provider, charge.OrderID, charge.UserID, transactionID, charge.CloudSubscriptionID,
+		charge.Token, charge.Amount, charge.Currency,
+	)
+	if err != nil {
+		log.Printf("[WARN] dunning charge %s for order %d not confirmed: %v", transactionID, charge.OrderID, err)
+		return false
+	}
+	// The recurrent subscription would otherwise charge again on its old
+	// schedule; move it to the end of the period the retry paid for.
+	rescheduler, recurrent := provider.(subscriptionRescheduler)
+	if recurrent && charge.CloudSubscriptionID != "" && confirmation.PeriodEnd != nil {
+		if err := rescheduler.RescheduleSubscription(charge.CloudSubscriptionID, *confirmation.PeriodEnd); err != nil {
+			log.Printf("[WARN] %s subscription %s not rescheduled: %v", charge.Provider, charge.CloudSubscriptionID, err)
+		}
+	}
+	return true
+}
+
+// subscriptionRescheduler is a provider whose recurrent subscriptions
+// charge on their own schedule.
+type subscriptionRescheduler interface {
+	RescheduleSubscription(subscriptionID string, startDate time.Time) error
+}
+
+func (r *DunningRunner) remind(ctx context.Context, item v2billing.DunningCase) {
+	notice, err := r.billing.DunningNotice(ctx, item.ID)
+	if err != nil {
+		log.Printf("[WARN] dunning notice for case %d failed: %v", item.ID, err)
+		return
+	}
+	if err := r.billing.RecordDunningEmail(ctx, item.ID, r.send(notice)); err != nil {
+		log.Printf("[WARN] dunning email for case %d not recorded: %v", item.ID, err)
+	}
+}
+
+func (r *DunningRunner) expire(ctx context.Context, item v2billing.DunningCase) {
+	expired, err := r.billing.ExpireDunning(ctx, item.ID)
+	if err != nil {
+		log.Printf("[WARN] dunning case %d not expired: %v", item.ID, err)
+		return
+	}
+	if expired {
+		r.remind(ctx, item)
+	}
+}
+
+func (r *DunningRunner) send(notice v2billing.DunningNotice) error {
+	if r.email == nil {
+		return errors.New("email_not_configured")
+	}
+	if strings.TrimSpace(notice.Email) == "" {
+		return errors.New("owner_email_missing")
+	}
+	subject, body := dunningEmail(notice, r.updateURL)
+	return r.email.SendServiceEmail(notice.Email, subject, body)
+}
+
+func dunningEmail(notice v2billing.DunningNotice, updateURL string) (string, string) {
+	workspace := html.EscapeString(notice.WorkspaceName)
+	amount := fmt.Sprintf("%.2f %s", notice.Amount, html.EscapeString(notice.Currency))
+	link := fmt.Sprintf(`<p><a href="%s">Обновить способ оплаты</a></p>`, html.EscapeString(updateURL))
+	switch {
+	case notice.Status == v2billing.DunningExpired:
+		return "Подписка REUP.goals приостановлена", fmt.Sprintf(
+			"<p>Нам так и не удалось списать оплату подписки пространства «%s» (%s), и льготный период закончился.</p><p>Пространство перешло в режим чтения: данные можно просматривать и выгружать, а изменения и AI-функции вернутся после оплаты подписки.</p>%s",
+			workspace, amount, link,
+		)
+	case notice.Final:
+		return "Последнее напоминание об оплате REUP.goals", fmt.Sprintf(
+			"<p>Оплата подписки пространства «%s» (%s) всё ещё не прошла.</p><p>Если не обновить карту до %s, платные возможности будут отключены.</p>%s",
+			workspace, amount, notice.GraceUntil.Format("02.01.2006"), link,
+		)
+	default:
+		return "Не удалось списать оплату REUP.goals", fmt.Sprintf(
+			"<p>Не получилось списать оплату подписки пространства «%s» (%s).</p><p>Доступ сохранится до %s. Мы повторим списание автоматически, но лучше проверить карту или указать другую.</p>%s",
+			workspace, amount, notice.GraceUntil.Format("02.01.2006"), link,
+		)
+	}
+}
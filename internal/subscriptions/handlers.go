@@ -26,19 +26,27 @@ const (
 )
 
 type Handler struct {
-	dbx     *sql.DB
-	cp      *CloudPaymentsClient
-	billing *v2billing.Service
+	dbx       *sql.DB
+	cp        *CloudPaymentsClient
+	providers Providers
+	billing   *v2billing.Service
 }
 
 func NewHandler(dbx *sql.DB, cp *CloudPaymentsClient, billing ...*v2billing.Service) *Handler {
-	result := &Handler{dbx: dbx, cp: cp}
+	result := &Handler{dbx: dbx, cp: cp, providers: NewProviders(cp)}
 	if len(billing) > 0 {
 		result.billing = billing[0]
 	}
 	return result
 }
 
+// WithProviders sets the payment providers whose webhooks the handler
+// accepts; CloudPayments alone by default.
+func (h *Handler) WithProviders(providers Providers) *Handler {
+	h.providers = providers
+	return h
+}
+
 func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
 	uid, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
@@ -137,7 +145,7 @@ func (h *Handler) Cancel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if cpSubscriptionID.Valid && cpSubscriptionID.String != "" {
-		if err := h.cp.CancelSubscription(cpSubscriptionID.String); err != nil {
+		if err := h.cp.CancelSubscription(r.Context(), cpSubscriptionID.String); err != nil {
 			http.Error(w, "cloudpayments_cancel_failed", http.StatusBadGateway)
 			return
 		}
@@ -159,45 +167,36 @@ func (h *Handler) Cancel(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *Handler) CloudPaymentsWebhook(eventType string) http.HandlerFunc {
+// Webhook receives the notifications of a payment provider.
+func (h *Handler) Webhook(provider Provider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rawBody := readRawBody(r)
-		hmacHeader := r.Header.Get("Content-HMAC")
-		if hmacHeader == "" {
-			hmacHeader = r.Header.Get("X-Content-HMAC")
-		}
-		if !h.cp.VerifyWebhook(rawBody, hmacHeader) {
-			writeWebhookCode(w, 13)
-			return
-		}
-
-		form, err := url.ParseQuery(string(rawBody))
-		if err != nil {
-			writeWebhookCode(w, 13)
-			return
-		}
-
-		if eventType == "check" {
-			if !h.validateCheck(form) {
-				writeWebhookCode(w, 13)
-				return
-			}
-			writeWebhookCode(w, 0)
-			return
+		event, err := provider.ParseWebhook(r.Context(), r)
+		if err == nil {
+			err = h.handleEvent(r.Context(), provider, event)
 		}
+		provider.AcknowledgeWebhook(w, err)
+	}
+}
 
-		if err := h.applyWebhook(eventType, form); err != nil {
-			writeWebhookCode(w, 13)
-			return
+func (h *Handler) handleEvent(ctx context.Context, provider Provider, event WebhookEvent) error {
+	switch event.Type {
+	case "":
+		return nil
+	case EventCheck:
+		if !h.validateCheck(ctx, provider, event) {
+			return ErrWebhookRejected
 		}
-
-		writeWebhookCode(w, 0)
+		return nil
+	case EventRefund:
+		uid, _ := userIDFromAccountID(event.AccountID)
+		return h.storeEvent(provider.Name(), event, uid, 0, event.SubscriptionID)
+	default:
+		return h.applyWebhook(ctx, provider, event)
 	}
 }
 
-func (h *Handler) validateCheck(form url.Values) bool {
-	accountID := formValue(form, "AccountId")
-	uid, ok := userIDFromAccountID(accountID)
+func (h *Handler) validateCheck(ctx context.Context, provider Provider, event WebhookEvent) bool {
+	uid, ok := userIDFromAccountID(event.AccountID)
 	if !ok {
 		return false
 	}
@@ -209,13 +208,12 @@ func (h *Handler) validateCheck(form url.Values) bool {
 	if !exists {
 		return false
 	}
-	if orderID, ok := cloudPaymentsOrderID(form); ok {
+	if event.OrderID > 0 {
 		if h.billing == nil {
 			return false
 		}
-		valid, err := h.billing.ValidateCloudPaymentOrder(
-			context.Background(), orderID, uid,
-			parseAmount(formValue(form, "Amount")), formValue(form, "Currency"),
+		valid, err := h.billing.ValidateCardPaymentOrder(
+			ctx, provider.Name(), event.OrderID, uid, event.Amount, event.Currency,
 		)
 		return err == nil && valid
 	}
@@ -224,75 +222,91 @@ func (h *Handler) validateCheck(form url.Values) bool {
 	if err != nil {
 		return false
 	}
-	currency := formValue(form, "Currency")
-	if currency != "" && currency != selection.currency {
+	if event.Currency != "" && event.Currency != selection.currency {
 		return false
 	}
-	amount := parseAmount(formValue(form, "Amount"))
-	if amount != 0 && amount != selection.amount {
+	if event.Amount != 0 && event.Amount != selection.amount {
 		return false
 	}
 
 	return true
 }
 
-func (h *Handler) applyWebhook(eventType string, form url.Values) error {
-	accountID := formValue(form, "AccountId")
+func (h *Handler) applyWebhook(ctx context.Context, provider Provider, event WebhookEvent) error {
+	accountID := event.AccountID
 	uid, ok := userIDFromAccountID(accountID)
 	if !ok {
 		return errors.New("invalid_account_id")
 	}
-	if orderID, hasOrder := cloudPaymentsOrderID(form); hasOrder {
+	if event.OrderID > 0 {
 		if h.billing == nil {
 			return errors.New("billing_service_unavailable")
 		}
-		amount := parseAmount(formValue(form, "Amount"))
-		currency := formValue(form, "Currency")
-		transactionID := formValue(form, "TransactionId")
-		// Ordered widget payments expose the recurrent subscription explicitly.
-		// `Id` belongs to other CloudPayments notifications and must not be used
-		// when deciding which existing subscription to replace.
-		cpSubscriptionID := formValue(form, "SubscriptionId")
-		if eventType == "pay" {
-			confirmation, err := h.billing.ConfirmCloudPaymentOrder(
-				context.Background(), orderID, uid, transactionID, cpSubscriptionID,
-				formValue(form, "Token"), amount, currency,
+		if event.Type == EventPay {
+			confirmation, err := h.billing.ConfirmCardPaymentOrder(
+				ctx, provider.Name(), event.OrderID, uid, event.TransactionID, event.SubscriptionID,
+				event.Token, event.Amount, event.Currency,
 			)
 			if err != nil {
 				return err
 			}
 			if confirmation.SubscriptionIDToCancel != "" {
-				if err := h.cp.CancelSubscription(confirmation.SubscriptionIDToCancel); err != nil {
+				// Only CloudPayments keeps recurrent subscriptions, so the
+				// replaced one is always there.
+				cloudPayments, ok := h.providers[v2billing.ProviderCloudPayments]
+				if !ok {
+					return ErrProviderNotConfigured
+				}
+				if err := cloudPayments.CancelSubscription(ctx, confirmation.SubscriptionIDToCancel); err != nil {
 					return err
 				}
 				if err := h.billing.MarkCloudPaymentSubscriptionReplaced(
-					context.Background(), orderID, confirmation.SubscriptionIDToCancel,
+					ctx, event.OrderID, confirmation.SubscriptionIDToCancel,
 				); err != nil {
 					return err
 				}
 			}
 		}
-		return h.storeEvent(eventType, uid, 0, transactionID, cpSubscriptionID, accountID, amount, currency, form)
+		if event.SubscriptionID != "" && renewalFailed(event) {
+			var subscriptionID int
+			err := h.dbx.QueryRow(`
+				SELECT id FROM subscriptions WHERE cloudpayments_subscription_id=$1
+			`, event.SubscriptionID).Scan(&subscriptionID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if err == nil {
+				if err := h.billing.OpenDunning(ctx, subscriptionID, event.Reason); err != nil {
+					return err
+				}
+			}
+		}
+		return h.storeEvent(provider.Name(), event, uid, 0, event.SubscriptionID)
+	}
+	if provider.Name() != v2billing.ProviderCloudPayments {
+		return errors.New("payment_order_missing")
 	}
 
+	// Payments without a billing order come from the first CloudPayments
+	// widget, which charged the user's own subscription.
+	eventType := event.Type
+	form := event.Fields
 	selection, err := h.checkoutSelection(uid)
 	if err != nil {
 		return err
 	}
-	amount := parseAmount(formValue(form, "Amount"))
-	currency := formValue(form, "Currency")
+	amount, currency := event.Amount, event.Currency
 	if amount != 0 && amount != selection.amount {
 		return errors.New("payment_amount_mismatch")
 	}
 	if currency != "" && currency != selection.currency {
 		return errors.New("payment_currency_mismatch")
 	}
-	transactionID := formValue(form, "TransactionId")
 	cpSubscriptionID := firstNonEmpty(
 		formValue(form, "SubscriptionId"),
 		formValue(form, "Id"),
 	)
-	if eventType != "pay" && cpSubscriptionID != "" {
+	if eventType != EventPay && cpSubscriptionID != "" {
 		var activeSubscriptionID sql.NullString
 		queryErr := h.dbx.QueryRow(`
 			SELECT cloudpayments_subscription_id FROM subscriptions WHERE user_id=$1
@@ -301,12 +315,10 @@ func (h *Handler) applyWebhook(eventType string, form url.Values) error {
 			return queryErr
 		}
 		if activeSubscriptionID.Valid && activeSubscriptionID.String != "" && activeSubscriptionID.String != cpSubscriptionID {
-			return h.storeEvent(
-				eventType, uid, 0, transactionID, cpSubscriptionID, accountID, amount, currency, form,
-			)
+			return h.storeEvent(provider.Name(), event, uid, 0, cpSubscriptionID)
 		}
 	}
-	token := formValue(form, "Token")
+	token := event.Token
 	now := time.Now().UTC()
 	trialEndsAt := now.AddDate(0, 0, h.cp.TrialDays())
 	nextPaymentAt := parseCloudPaymentsTime(firstNonEmpty(
@@ -354,8 +366,19 @@ func (h *Handler) applyWebhook(eventType string, form url.Values) error {
 	if err != nil {
 		return err
 	}
+	if h.billing != nil {
+		switch {
+		case status == statusPastDue:
+			err = h.billing.OpenDunning(ctx, subscriptionID, event.Reason)
+		case eventType == EventPay:
+			err = h.billing.ResolveDunning(ctx, subscriptionID, "card_payment")
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-	return h.storeEvent(eventType, uid, subscriptionID, transactionID, cpSubscriptionID, accountID, amount, currency, form)
+	return h.storeEvent(provider.Name(), event, uid, subscriptionID, cpSubscriptionID)
 }
 
 type checkoutSelection struct {
@@ -574,9 +597,9 @@ func (h *Handler) statusForUser(uid int) (map[string]any, error) {
 	}, nil
 }
 
-func (h *Handler) storeEvent(eventType string, uid int, subscriptionID int, transactionID string, cpSubscriptionID string, accountID string, amount float64, currency string, form url.Values) error {
+func (h *Handler) storeEvent(provider string, event WebhookEvent, uid int, subscriptionID int, cpSubscriptionID string) error {
 	payload := make(map[string]any)
-	for key, values := range form {
+	for key, values := range event.Fields {
 		if !safePaymentEventField(key) {
 			continue
 		}
@@ -595,14 +618,28 @@ func (h *Handler) storeEvent(eventType string, uid int, subscriptionID int, tran
 	_, err = h.dbx.Exec(`
 		INSERT INTO payment_events (
 			event_type, user_id, subscription_id, cloudpayments_transaction_id,
-			cloudpayments_subscription_id, account_id, amount, currency, payload
+			cloudpayments_subscription_id, account_id, amount, currency, payload, provider
 		)
-		VALUES ($1,$2,NULLIF($3,0),nullif($4,''),nullif($5,''),nullif($6,''),$7,nullif($8,''),$9)
-	`, eventType, uid, subscriptionID, transactionID, cpSubscriptionID, accountID, amount, currency, string(rawPayload))
+		VALUES ($1,NULLIF($2,0),NULLIF($3,0),nullif($4,''),nullif($5,''),nullif($6,''),$7,nullif($8,''),$9,$10)
+	`, event.Type, uid, subscriptionID, event.TransactionID, cpSubscriptionID, event.AccountID,
+		event.Amount, event.Currency, string(rawPayload), provider)
 
 	return err
 }
 
+// renewalFailed reports whether a notification means a recurrent charge did
+// not go through.
+func renewalFailed(event WebhookEvent) bool {
+	switch event.Type {
+	case EventFail:
+		return true
+	case EventRecurrent:
+		return strings.EqualFold(event.Status, "PastDue")
+	default:
+		return false
+	}
+}
+
 func cloudPaymentsOrderID(form url.Values) (int64, bool) {
 	raw := strings.TrimSpace(formValue(form, "InvoiceId"))
 	if raw == "" {
@@ -25,3 +25,22 @@ func TestCloudPaymentsOrderID(t *testing.T) {
 		}
 	}
 }
+
+func TestRenewalFailed(t *testing.T) {
+	tests := []struct {
+		eventType string
+		status    string
+		want      bool
+	}{
+		{eventType: "fail", want: true},
+		{eventType: "recurrent", status: "PastDue", want: true},
+		{eventType: "recurrent", status: "Active"},
+		{eventType: "pay"},
+	}
+	for _, test := range tests {
+		got := renewalFailed(WebhookEvent{Type: test.eventType, Status: test.status})
+		if got != test.want {
+			t.Fatalf("renewalFailed(%q, %q) = %v, want %v", test.eventType, test.status, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package subscriptions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	v2billing "reup-goals-backend/internal/v2/billing"
+)
+
+const (
+	EventCheck     = "check"
+	EventPay       = "pay"
+	EventFail      = "fail"
+	EventRecurrent = "recurrent"
+	EventCancel    = "cancel"
+	EventRefund    = "refund"
+)
+
+var (
+	ErrProviderNotConfigured = errors.New("payment_provider_not_configured")
+	ErrWebhookRejected       = errors.New("payment_webhook_rejected")
+)
+
+// Provider is a card payment provider. Checkout creates what the frontend
+// needs to take the first payment of a billing order; the provider reports
+// the outcome through a webhook, which the handler applies to the order.
+type Provider interface {
+	Name() string
+	Configured() bool
+	CheckoutSession(ctx context.Context, request CheckoutRequest) (CheckoutSession, error)
+	// ParseWebhook verifies a notification and translates it into an event.
+	// An event with an empty Type is acknowledged and otherwise ignored.
+	ParseWebhook(ctx context.Context, r *http.Request) (WebhookEvent, error)
+	// AcknowledgeWebhook answers the notification the way the provider
+	// expects; a non-nil err asks it to deliver the notification again.
+	AcknowledgeWebhook(w http.ResponseWriter, err error)
+	// ChargeRecurring charges the saved card without the customer present
+	// and returns the transaction id.
+	ChargeRecurring(ctx context.Context, charge v2billing.DunningCharge) (string, error)
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+	Refund(ctx context.Context, refund Refund) (string, error)
+}
+
+// CheckoutRequest is the first payment of a billing order. Recurrent orders
+// save the card; RenewalAmount, StartDate and PeriodMonths describe the
+// renewals for providers that schedule them themselves.
+type CheckoutRequest struct {
+	OrderID       int64
+	UserID        int
+	Email         string
+	Description   string
+	Amount        float64
+	Currency      string
+	Recurrent     bool
+	RenewalAmount float64
+	StartDate     time.Time
+	PeriodMonths  int
+	ReturnURL     string
+	// IdempotenceKey makes a repeated checkout of the same attempt return
+	// the same payment; providers without idempotence keys ignore it.
+	IdempotenceKey string
+	// Extra is passed through to the frontend with the session config.
+	Extra map[string]any
+}
+
+// CheckoutSession is how the frontend takes the payment: a provider widget
+// opened with Config, or a redirect to CheckoutURL.
+type CheckoutSession struct {
+	Provider    string
+	Mode        string
+	Config      map[string]any
+	CheckoutURL string
+	PaymentID   string
+}
+
+// WebhookEvent is a provider notification in provider-neutral terms. Fields
+// keeps the raw values; only safePaymentEventField ones are stored.
+type WebhookEvent struct {
+	Type           string
+	OrderID        int64
+	AccountID      string
+	TransactionID  string
+	SubscriptionID string
+	Token          string
+	Amount         float64
+	Currency       string
+	Status         string
+	Reason         string
+	Fields         url.Values
+}
+
+// Refund returns a payment in full or in part.
+type Refund struct {
+	TransactionID  string
+	Amount         float64
+	Currency       string
+	Description    string
+	IdempotenceKey string
+}
+
+// Providers looks up the configured providers by name.
+type Providers map[string]Provider
+
+func NewProviders(providers ...Provider) Providers {
+	result := make(Providers, len(providers))
+	for _, provider := range providers {
+		if provider != nil && provider.Configured() {
+			result[provider.Name()] = provider
+		}
+	}
+	return result
+}
+
+// Default is the provider new checkouts go to: the preferred one when it
+// is configured, otherwise CloudPayments before YooKassa.
+func (p Providers) Default(preferred string) Provider {
+	for _, name := range []string{preferred, v2billing.ProviderCloudPayments, v2billing.ProviderYooKassa} {
+		if provider, ok := p[name]; ok {
+			return provider
+		}
+	}
+	return nil
+}
+
+// declinedError is a request the provider answered and refused, so nothing
+// was done on its side.
+type declinedError string
+
+func (e declinedError) Error() string {
+	return string(e)
+}
+
+// RefundPayment refunds a card payment through the provider that took it,
+// for the admin refunds of the billing service. A refused refund is
+// reported as billing.ErrRefundDeclined.
+func (p Providers) RefundPayment(ctx context.Context, provider, transactionID string, amount float64, currency, idempotenceKey string) (string, error) {
+	refunder, ok := p[provider]
+	if !ok {
+		return "", ErrProviderNotConfigured
+	}
+	refundID, err := refunder.Refund(ctx, Refund{
+		TransactionID: transactionID, Amount: amount, Currency: currency,
+		Description: "Возврат оплаты REUP.goals", IdempotenceKey: idempotenceKey,
+	})
+	var declined declinedError
+	if errors.As(err, &declined) {
+		return "", fmt.Errorf("%w: %s", v2billing.ErrRefundDeclined, declined)
+	}
+	return refundID, err
+}
+
+// CancelRecurring stops a provider's recurrent subscription.
+func (p Providers) CancelRecurring(ctx context.Context, provider, subscriptionID string) error {
+	canceller, ok := p[provider]
+	if !ok {
+		return ErrProviderNotConfigured
+	}
+	return canceller.CancelSubscription(ctx, subscriptionID)
+}
@@ -0,0 +1,266 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"reup-goals-backend/internal/subscriptions/sandbox"
+	v2billing "reup-goals-backend/internal/v2/billing"
+)
+
+// sandboxBackend receives the sandbox callbacks with the providers' own
+// webhook parsing, as the API does.
+type sandboxBackend struct {
+	mu     sync.Mutex
+	events []WebhookEvent
+}
+
+func (b *sandboxBackend) handler(providers ...Provider) http.Handler {
+	mux := http.NewServeMux()
+	for _, provider := range providers {
+		provider := provider
+		pattern := "/payments/cloudpayments/"
+		if provider.Name() == v2billing.ProviderYooKassa {
+			pattern = "/payments/yookassa/notify"
+		}
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			event, err := provider.ParseWebhook(r.Context(), r)
+			if err == nil && event.Type != "" {
+				b.mu.Lock()
+				b.events = append(b.events, event)
+				b.mu.Unlock()
+			}
+			provider.AcknowledgeWebhook(w, err)
+		})
+	}
+	return mux
+}
+
+func (b *sandboxBackend) received() []WebhookEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]WebhookEvent(nil), b.events...)
+}
+
+func newSandbox(t *testing.T, providers func(sandboxURL string) []Provider) (*sandboxBackend, *sandbox.Server, []Provider) {
+	t.Helper()
+	backend := &sandboxBackend{}
+	var handler http.Handler = http.NotFoundHandler()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+	server := sandbox.New(sandbox.Config{
+		CallbackURL: api.URL, CloudPaymentsSecret: "cp-secret",
+		YooKassaShopID: "shop", YooKassaSecretKey: "yk-secret",
+	})
+	emulator := httptest.NewServer(server)
+	t.Cleanup(emulator.Close)
+	server.SetPublicURL(emulator.URL)
+	configured := providers(emulator.URL)
+	handler = backend.handler(configured...)
+	return backend, server, configured
+}
+
+func TestCloudPaymentsSandboxCheckout(t *testing.T) {
+	backend, _, providers := newSandbox(t, func(sandboxURL string) []Provider {
+		return []Provider{&CloudPaymentsClient{
+			publicID: "public", secret: "cp-secret", baseURL: sandboxURL, client: http.DefaultClient,
+		}}
+	})
+	provider := providers[0]
+	session, err := provider.CheckoutSession(context.Background(), CheckoutRequest{
+		OrderID: 42, UserID: 7, Email: "owner@example.com", Description: "REUP.goals · Founder",
+		Amount: 2792, RenewalAmount: 3490, Currency: "RUB", Recurrent: true,
+		StartDate: time.Date(2026, 11, 19, 0, 0, 0, 0, time.UTC), PeriodMonths: 1,
+		Extra: map[string]any{"order_kind": v2billing.OrderSubscription},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Mode != "widget" || session.Config["invoice_id"] != "42" || session.Config["account_id"] != "reup_user_7" ||
+		session.Config["order_kind"] != v2billing.OrderSubscription {
+		t.Fatalf("CheckoutSession = %+v", session)
+	}
+
+	var result sandbox.WidgetResult
+	postJSON(t, sandboxURLOf(provider)+"/sandbox/cloudpayments/pay", session.Config, &result)
+	if !result.Success || result.Token == "" || result.SubscriptionID == "" {
+		t.Fatalf("widget payment = %+v", result)
+	}
+	events := backend.received()
+	if len(events) != 2 || events[0].Type != EventCheck || events[1].Type != EventPay {
+		t.Fatalf("events = %+v", events)
+	}
+	pay := events[1]
+	if pay.OrderID != 42 || pay.AccountID != "reup_user_7" || pay.Amount != 2792 || pay.Currency != "RUB" ||
+		pay.Token != result.Token || pay.SubscriptionID != result.SubscriptionID {
+		t.Fatalf("pay event = %+v", pay)
+	}
+
+	transactionID, err := provider.ChargeRecurring(context.Background(), v2billing.DunningCharge{
+		OrderID: 43, UserID: 7, Token: result.Token, Amount: 3490, Currency: "RUB",
+	})
+	if err != nil || transactionID == "" {
+		t.Fatalf("ChargeRecurring = %q, %v", transactionID, err)
+	}
+	if _, err := provider.ChargeRecurring(context.Background(), v2billing.DunningCharge{
+		OrderID: 44, UserID: 7, Token: "decline-card", Amount: 3490, Currency: "RUB",
+	}); err == nil || err.Error() != "InsufficientFunds" {
+		t.Fatalf("declined ChargeRecurring error = %v", err)
+	}
+	events = backend.received()
+	if len(events) != 4 || events[2].Type != EventPay || events[2].OrderID != 43 ||
+		events[3].Type != EventFail || events[3].Reason != "InsufficientFunds" {
+		t.Fatalf("charge events = %+v", events[2:])
+	}
+	refund := Refund{TransactionID: transactionID, Amount: 1000, IdempotenceKey: "refund-43-1"}
+	refundID, err := provider.Refund(context.Background(), refund)
+	if err != nil || refundID == "" {
+		t.Fatalf("Refund = %q, %v", refundID, err)
+	}
+	if repeated, err := provider.Refund(context.Background(), refund); err != nil || repeated != refundID {
+		t.Fatalf("repeated Refund = %q, %v, want %q", repeated, err, refundID)
+	}
+}
+
+func TestCloudPaymentsSandboxRejectsForgedCallback(t *testing.T) {
+	client := &CloudPaymentsClient{publicID: "public", secret: "cp-secret"}
+	request := httptest.NewRequest(http.MethodPost, "/payments/cloudpayments/pay", bytes.NewBufferString("InvoiceId=42"))
+	request.Header.Set("Content-HMAC", "forged")
+	if _, err := client.ParseWebhook(context.Background(), request); err != ErrWebhookRejected {
+		t.Fatalf("ParseWebhook error = %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	client.AcknowledgeWebhook(recorder, ErrWebhookRejected)
+	if recorder.Body.String() != "{\"code\":13}\n" {
+		t.Fatalf("acknowledgement = %q", recorder.Body.String())
+	}
+}
+
+func TestYooKassaSandboxCheckout(t *testing.T) {
+	backend, _, providers := newSandbox(t, func(sandboxURL string) []Provider {
+		return []Provider{&YooKassaClient{
+			shopID: "shop", secret: "yk-secret", baseURL: sandboxURL + "/v3", client: http.DefaultClient,
+		}}
+	})
+	provider := providers[0]
+	request := CheckoutRequest{
+		OrderID: 42, UserID: 7, Description: "REUP.goals · Founder", Amount: 3490, Currency: "RUB",
+		Recurrent: true, ReturnURL: "https://app.example.com/account", IdempotenceKey: "order-42-a",
+	}
+	session, err := provider.CheckoutSession(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Mode != "redirect" || session.CheckoutURL == "" || session.PaymentID == "" {
+		t.Fatalf("CheckoutSession = %+v", session)
+	}
+	again, err := provider.CheckoutSession(context.Background(), request)
+	if err != nil || again.PaymentID != session.PaymentID {
+		t.Fatalf("repeated CheckoutSession = %+v, %v; want payment %s", again, err, session.PaymentID)
+	}
+
+	postJSON(t, session.CheckoutURL, nil, nil)
+	events := backend.received()
+	if len(events) != 1 {
+		t.Fatalf("events = %+v", events)
+	}
+	pay := events[0]
+	if pay.Type != EventPay || pay.OrderID != 42 || pay.AccountID != "reup_user_7" || pay.TransactionID != session.PaymentID ||
+		pay.Amount != 3490 || pay.Currency != "RUB" || pay.Token == "" || pay.SubscriptionID != "" {
+		t.Fatalf("pay event = %+v", pay)
+	}
+
+	declined, err := provider.CheckoutSession(context.Background(), CheckoutRequest{
+		OrderID: 43, UserID: 7, Amount: 3490, Currency: "RUB", ReturnURL: "https://app.example.com/account",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postJSON(t, declined.CheckoutURL+"?result=decline", nil, nil)
+	if events = backend.received(); len(events) != 2 || events[1].Type != EventFail ||
+		events[1].OrderID != 43 || events[1].Reason != "insufficient_funds" {
+		t.Fatalf("decline events = %+v", events)
+	}
+
+	transactionID, err := provider.ChargeRecurring(context.Background(), v2billing.DunningCharge{
+		OrderID: 44, UserID: 7, Token: pay.Token, Amount: 3490, Currency: "RUB",
+	})
+	if err != nil || transactionID == "" {
+		t.Fatalf("ChargeRecurring = %q, %v", transactionID, err)
+	}
+	if _, err := provider.ChargeRecurring(context.Background(), v2billing.DunningCharge{
+		OrderID: 45, UserID: 7, Token: "decline-card", Amount: 3490, Currency: "RUB",
+	}); err == nil || err.Error() != "insufficient_funds" {
+		t.Fatalf("declined ChargeRecurring error = %v", err)
+	}
+	if _, err := NewProviders(provider).RefundPayment(
+		context.Background(), provider.Name(), transactionID, 5000, "RUB", "refund-45-1",
+	); !errors.Is(err, v2billing.ErrRefundDeclined) {
+		t.Fatalf("refund above the payment = %v, want a decline", err)
+	}
+	if refundID, err := provider.Refund(context.Background(), Refund{
+		TransactionID: transactionID, Amount: 1490, Currency: "RUB",
+	}); err != nil || refundID == "" {
+		t.Fatalf("Refund = %q, %v", refundID, err)
+	}
+	events = backend.received()
+	last := events[len(events)-1]
+	if last.Type != EventRefund || last.TransactionID != transactionID || last.Amount != 1490 {
+		t.Fatalf("refund event = %+v", last)
+	}
+}
+
+func TestProvidersDefault(t *testing.T) {
+	cloudPayments := &CloudPaymentsClient{publicID: "public"}
+	yooKassa := &YooKassaClient{shopID: "shop", secret: "secret"}
+	providers := NewProviders(cloudPayments, yooKassa, (*YooKassaClient)(nil))
+	if got := providers.Default(v2billing.ProviderYooKassa); got != yooKassa {
+		t.Fatalf("Default(yookassa) = %v", got)
+	}
+	if got := providers.Default(""); got != cloudPayments {
+		t.Fatalf("Default() = %v", got)
+	}
+	if got := NewProviders(&CloudPaymentsClient{}, yooKassa).Default(""); got != yooKassa {
+		t.Fatalf("Default() without CloudPayments = %v", got)
+	}
+	if got := NewProviders(&CloudPaymentsClient{}).Default(""); got != nil {
+		t.Fatalf("Default() without providers = %v", got)
+	}
+	if _, err := providers.RefundPayment(context.Background(), "manual", "1", 100, "RUB", "refund-1-1"); err != ErrProviderNotConfigured {
+		t.Fatalf("RefundPayment through an unknown provider = %v", err)
+	}
+}
+
+func sandboxURLOf(provider Provider) string {
+	return provider.(*CloudPaymentsClient).baseURL
+}
+
+func postJSON(t *testing.T, target string, payload, result any) {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s = %d", target, response.StatusCode)
+	}
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package subscriptions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	v2billing "reup-goals-backend/internal/v2/billing"
+)
+
+const (
+	renewalAdvisoryLock int64 = 528105241
+	renewalBatch              = 100
+)
+
+// RenewalRunner renews card subscriptions of a provider that has no
+// recurrent subscriptions of its own: when a paid period ends it charges the
+// saved card for the next one. A declined renewal opens dunning, which
+// retries from there.
+type RenewalRunner struct {
+	dbx      *sql.DB
+	billing  *v2billing.Service
+	provider Provider
+	interval time.Duration
+}
+
+func NewRenewalRunner(dbx *sql.DB, billing *v2billing.Service, provider Provider, interval time.Duration) *RenewalRunner {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &RenewalRunner{dbx: dbx, billing: billing, provider: provider, interval: interval}
+}
+
+func (r *RenewalRunner) Start(ctx context.Context) {
+	go func() {
+		r.run(ctx)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+}
+
+func (r *RenewalRunner) run(ctx context.Context) {
+	conn, err := r.dbx.Conn(ctx)
+	if err != nil {
+		log.Printf("[WARN] renewal connection failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, renewalAdvisoryLock).Scan(&locked); err != nil || !locked {
+		return
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, renewalAdvisoryLock)
+
+	due, err := r.billing.DueRenewals(ctx, r.provider.Name(), renewalBatch)
+	if err != nil {
+		log.Printf("[WARN] renewal lookup failed: %v", err)
+		return
+	}
+	for _, subscriptionID := range due {
+		r.renew(ctx, subscriptionID)
+	}
+}
+
+func (r *RenewalRunner) renew(ctx context.Context, subscriptionID int) {
+	charge, err := r.billing.PrepareRenewal(ctx, subscriptionID)
+	if errors.Is(err, v2billing.ErrRenewalNotDue) {
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, v2billing.ErrDunningRetryUnavailable) {
+			log.Printf("[WARN] renewal of subscription %d failed: %v", subscriptionID, err)
+			return
+		}
+		if err := r.billing.FailRenewal(ctx, subscriptionID, 0, err); err != nil {
+			log.Printf("[WARN] renewal failure of subscription %d not recorded: %v", subscriptionID, err)
+		}
+		return
+	}
+	transactionID, chargeErr := r.provider.ChargeRecurring(ctx, charge)
+	if errors.Is(chargeErr, ErrChargePending) {
+		// The provider's webhook confirms the order once it decides.
+		return
+	}
+	if chargeErr != nil {
+		if err := r.billing.FailRenewal(ctx, subscriptionID, charge.OrderID, chargeErr); err != nil {
+			log.Printf("[WARN] renewal failure of subscription %d not recorded: %v", subscriptionID, err)
+		}
+		return
+	}
+	if _, err := r.billing.ConfirmCardPaymentOrder(
+		ctx, charge.Provider, charge.OrderID, charge.UserID, transactionID, "",
+		charge.Token, charge.Amount, charge.Currency,
+	); err != nil {
+		log.Printf("[WARN] renewal charge %s for order %d not confirmed: %v", transactionID, charge.OrderID, err)
+	}
+}
@@ -0,0 +1,196 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// WidgetPayment is what the CloudPayments widget would be opened with: the
+// checkout config the backend returns, plus the card to pay with.
+type WidgetPayment struct {
+	PublicID           string  `json:"public_id"`
+	AccountID          string  `json:"account_id"`
+	Email              string  `json:"email"`
+	InvoiceID          string  `json:"invoice_id"`
+	FirstPaymentAmount float64 `json:"first_payment_amount"`
+	Amount             float64 `json:"amount"`
+	Currency           string  `json:"currency"`
+	Recurrent          bool    `json:"recurrent"`
+	Card               string  `json:"card"`
+}
+
+// WidgetResult is the outcome of a widget payment and the callbacks it
+// caused.
+type WidgetResult struct {
+	Success        bool       `json:"success"`
+	TransactionID  int64      `json:"transaction_id"`
+	SubscriptionID string     `json:"subscription_id,omitempty"`
+	Token          string     `json:"token,omitempty"`
+	Callbacks      []Callback `json:"callbacks"`
+}
+
+// cloudPaymentsWidget takes a payment the way the widget does: check,
+// then pay or fail, each posted to the backend with a Content-HMAC
+// signature.
+func (s *Server) cloudPaymentsWidget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method_not_allowed"})
+		return
+	}
+	var payment WidgetPayment
+	if err := decodeJSON(r, &payment); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	amount := payment.FirstPaymentAmount
+	if amount == 0 {
+		amount = payment.Amount
+	}
+	transactionID := s.nextNumber()
+	fields := map[string]string{
+		"TransactionId": strconv.FormatInt(transactionID, 10),
+		"Amount":        formatAmount(amount),
+		"Currency":      payment.Currency,
+		"InvoiceId":     payment.InvoiceID,
+		"AccountId":     payment.AccountID,
+		"Email":         payment.Email,
+		"TestMode":      "1",
+	}
+	result := WidgetResult{TransactionID: transactionID}
+	check, err := s.cloudPaymentsCallback("check", fields)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	result.Callbacks = append(result.Callbacks, check)
+	if !cloudPaymentsAccepted(check) {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	if declined(payment.Card) {
+		fields["Reason"], fields["ReasonCode"], fields["Status"] = "InsufficientFunds", "5051", "Declined"
+		fail, err := s.cloudPaymentsCallback("fail", fields)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		result.Callbacks = append(result.Callbacks, fail)
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	result.Token = s.nextID("tk_sandbox_")
+	fields["Token"], fields["Status"] = result.Token, "Completed"
+	if payment.Recurrent {
+		result.SubscriptionID = s.nextID("sc_sandbox_")
+		fields["SubscriptionId"] = result.SubscriptionID
+	}
+	pay, err := s.cloudPaymentsCallback("pay", fields)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	result.Callbacks = append(result.Callbacks, pay)
+	result.Success = cloudPaymentsAccepted(pay)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) cloudPaymentsCallback(kind string, fields map[string]string) (Callback, error) {
+	body := cloudPaymentsForm(fields)
+	return s.notify("cloudpayments", "/payments/cloudpayments/"+kind, "application/x-www-form-urlencoded", body, http.Header{
+		"Content-Hmac": {signCloudPayments(s.cfg.CloudPaymentsSecret, body)},
+	})
+}
+
+func cloudPaymentsAccepted(callback Callback) bool {
+	var answer struct {
+		Code int `json:"code"`
+	}
+	return callback.Status == http.StatusOK && json.Unmarshal([]byte(callback.Response), &answer) == nil && answer.Code == 0
+}
+
+type cloudPaymentsHandler func(payload map[string]any) (model any, success bool)
+
+// cloudPaymentsAPI answers in the CloudPayments envelope after checking the
+// API secret. A request repeating an X-Request-ID gets the first answer.
+func (s *Server) cloudPaymentsAPI(handler cloudPaymentsHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, secret, ok := r.BasicAuth(); !ok || secret != s.cfg.CloudPaymentsSecret || r.Method != http.MethodPost {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"Success": false, "Message": "Unauthorized"})
+			return
+		}
+		requestKey := ""
+		if requestID := r.Header.Get("X-Request-ID"); requestID != "" {
+			requestKey = "cloudpayments:" + r.URL.Path + ":" + requestID
+			s.mu.Lock()
+			answer, ok := s.idempotence[requestKey]
+			s.mu.Unlock()
+			if ok {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(answer))
+				return
+			}
+		}
+		var payload map[string]any
+		if err := decodeJSON(r, &payload); err != nil {
+			writeJSON(w, http.StatusOK, map[string]any{"Success": false, "Message": err.Error()})
+			return
+		}
+		model, success := handler(payload)
+		answer, _ := json.Marshal(map[string]any{"Success": success, "Message": nil, "Model": model})
+		if requestKey != "" {
+			s.mu.Lock()
+			s.idempotence[requestKey] = string(answer)
+			s.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(answer)
+	}
+}
+
+// cloudPaymentsCharge charges a saved token and sends the pay or fail
+// notification for the invoice before answering, as CloudPayments may.
+func (s *Server) cloudPaymentsCharge(payload map[string]any) (any, bool) {
+	transactionID := s.nextNumber()
+	token := fmt.Sprint(payload["Token"])
+	fields := map[string]string{
+		"TransactionId": strconv.FormatInt(transactionID, 10),
+		"Amount":        formatAmount(number(payload["Amount"])),
+		"Currency":      fmt.Sprint(payload["Currency"]),
+		"InvoiceId":     fmt.Sprint(payload["InvoiceId"]),
+		"AccountId":     fmt.Sprint(payload["AccountId"]),
+		"TestMode":      "1",
+	}
+	if declined(token) {
+		fields["Reason"], fields["ReasonCode"], fields["Status"] = "InsufficientFunds", "5051", "Declined"
+		_, _ = s.cloudPaymentsCallback("fail", fields)
+		return map[string]any{"TransactionId": transactionID, "Reason": "InsufficientFunds"}, false
+	}
+	fields["Token"], fields["Status"] = token, "Completed"
+	_, _ = s.cloudPaymentsCallback("pay", fields)
+	return map[string]any{"TransactionId": transactionID, "Status": "Completed"}, true
+}
+
+func (s *Server) cloudPaymentsRefund(payload map[string]any) (any, bool) {
+	if number(payload["TransactionId"]) <= 0 {
+		return nil, false
+	}
+	return map[string]any{"TransactionId": s.nextNumber()}, true
+}
+
+func (s *Server) cloudPaymentsAccept(payload map[string]any) (any, bool) {
+	return nil, true
+}
+
+func number(value any) float64 {
+	switch typed := value.(type) {
+	case float64:
+		return typed
+	case string:
+		parsed, _ := strconv.ParseFloat(typed, 64)
+		return parsed
+	default:
+		return 0
+	}
+}
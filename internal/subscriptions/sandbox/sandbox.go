@@ -0,0 +1,176 @@
+// Package sandbox emulates the CloudPayments and YooKassa APIs for local
+// development and tests. Point CLOUDPAYMENTS_BASE_URL and YOOKASSA_BASE_URL
+// at it; payments made through its checkout pages are reported to the
+// backend with the same callbacks the real providers send.
+//
+// Cards whose number or token starts with "decline" are declined.
+package sandbox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// CallbackURL is the backend the callbacks are posted to.
+	CallbackURL string
+	// PublicURL is where the sandbox itself is reachable, for the
+	// YooKassa confirmation pages.
+	PublicURL           string
+	CloudPaymentsSecret string
+	YooKassaShopID      string
+	YooKassaSecretKey   string
+}
+
+// Callback is a notification the sandbox delivered, with the backend's
+// answer.
+type Callback struct {
+	Provider string
+	Path     string
+	Body     string
+	Status   int
+	Response string
+}
+
+type Server struct {
+	cfg    Config
+	client *http.Client
+	mux    *http.ServeMux
+
+	mu          sync.Mutex
+	sequence    int64
+	payments    map[string]*yooKassaPayment
+	refunds     map[string]*yooKassaRefund
+	idempotence map[string]string
+	callbacks   []Callback
+}
+
+func New(cfg Config) *Server {
+	cfg.CallbackURL = strings.TrimRight(cfg.CallbackURL, "/")
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+	server := &Server{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: 15 * time.Second},
+		mux:         http.NewServeMux(),
+		payments:    make(map[string]*yooKassaPayment),
+		refunds:     make(map[string]*yooKassaRefund),
+		idempotence: make(map[string]string),
+	}
+	server.mux.HandleFunc("/sandbox/cloudpayments/pay", server.cloudPaymentsWidget)
+	server.mux.HandleFunc("/payments/tokens/charge", server.cloudPaymentsAPI(server.cloudPaymentsCharge))
+	server.mux.HandleFunc("/payments/refund", server.cloudPaymentsAPI(server.cloudPaymentsRefund))
+	server.mux.HandleFunc("/subscriptions/cancel", server.cloudPaymentsAPI(server.cloudPaymentsAccept))
+	server.mux.HandleFunc("/subscriptions/update", server.cloudPaymentsAPI(server.cloudPaymentsAccept))
+	server.mux.HandleFunc("/sandbox/yookassa/confirm/", server.yooKassaConfirm)
+	server.mux.HandleFunc("/v3/payments", server.yooKassaAPI(server.yooKassaCreatePayment))
+	server.mux.HandleFunc("/v3/payments/", server.yooKassaAPI(server.yooKassaGetPayment))
+	server.mux.HandleFunc("/v3/refunds", server.yooKassaAPI(server.yooKassaCreateRefund))
+	server.mux.HandleFunc("/v3/refunds/", server.yooKassaAPI(server.yooKassaGetRefund))
+	return server
+}
+
+// SetPublicURL tells the sandbox its own address once it is known, e.g.
+// after httptest.NewServer.
+func (s *Server) SetPublicURL(publicURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg.PublicURL = strings.TrimRight(publicURL, "/")
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Callbacks returns the notifications delivered so far.
+func (s *Server) Callbacks() []Callback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Callback(nil), s.callbacks...)
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sequence++
+	return prefix + strconv.FormatInt(s.sequence, 10)
+}
+
+func (s *Server) nextNumber() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sequence++
+	return 1000 + s.sequence
+}
+
+// notify posts a callback and records the backend's answer.
+func (s *Server) notify(provider, path, contentType string, body []byte, header http.Header) (Callback, error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.CallbackURL+path, bytes.NewReader(body))
+	if err != nil {
+		return Callback{}, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	callback := Callback{Provider: provider, Path: path, Body: string(body)}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return callback, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	callback.Status, callback.Response = resp.StatusCode, strings.TrimSpace(string(data))
+	s.mu.Lock()
+	s.callbacks = append(s.callbacks, callback)
+	s.mu.Unlock()
+	return callback, nil
+}
+
+func declined(value string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "decline")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func signCloudPayments(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func decodeJSON(r *http.Request, value any) error {
+	defer r.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(value); err != nil {
+		return fmt.Errorf("invalid_json: %w", err)
+	}
+	return nil
+}
+
+func cloudPaymentsForm(values map[string]string) []byte {
+	form := url.Values{}
+	for key, value := range values {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	return []byte(form.Encode())
+}
@@ -0,0 +1,269 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type yooKassaAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type yooKassaPayment struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Paid          bool              `json:"paid"`
+	Amount        yooKassaAmount    `json:"amount"`
+	Description   string            `json:"description,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	PaymentMethod struct {
+		Type  string `json:"type"`
+		ID    string `json:"id"`
+		Saved bool   `json:"saved"`
+	} `json:"payment_method"`
+	Confirmation *struct {
+		Type            string `json:"type"`
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation,omitempty"`
+	CancellationDetails *struct {
+		Party  string `json:"party"`
+		Reason string `json:"reason"`
+	} `json:"cancellation_details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	returnURL   string
+	savesMethod bool
+	refunded    float64
+}
+
+type yooKassaRefund struct {
+	ID        string         `json:"id"`
+	PaymentID string         `json:"payment_id"`
+	Status    string         `json:"status"`
+	Amount    yooKassaAmount `json:"amount"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type yooKassaHandler func(w http.ResponseWriter, r *http.Request)
+
+// yooKassaAPI checks the shop credentials, and the Idempotence-Key of
+// requests that create something.
+func (s *Server) yooKassaAPI(handler yooKassaHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		shopID, secret, ok := r.BasicAuth()
+		if !ok || shopID != s.cfg.YooKassaShopID || secret != s.cfg.YooKassaSecretKey {
+			yooKassaError(w, http.StatusUnauthorized, "invalid_credentials")
+			return
+		}
+		if r.Method == http.MethodPost && r.Header.Get("Idempotence-Key") == "" {
+			yooKassaError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func yooKassaError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"type": "error", "code": code})
+}
+
+// idempotent returns what an earlier request with the same key created.
+func (s *Server) idempotent(r *http.Request, kind string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.idempotence[kind+":"+r.Header.Get("Idempotence-Key")]
+	return id, ok
+}
+
+func (s *Server) remember(r *http.Request, kind, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idempotence[kind+":"+r.Header.Get("Idempotence-Key")] = id
+}
+
+// yooKassaCreatePayment starts a redirect checkout, or charges a saved
+// payment method at once.
+func (s *Server) yooKassaCreatePayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		yooKassaError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if id, ok := s.idempotent(r, "payment"); ok {
+		writeJSON(w, http.StatusOK, s.payment(id))
+		return
+	}
+	var request struct {
+		Amount       yooKassaAmount `json:"amount"`
+		Confirmation struct {
+			ReturnURL string `json:"return_url"`
+		} `json:"confirmation"`
+		SavePaymentMethod bool              `json:"save_payment_method"`
+		PaymentMethodID   string            `json:"payment_method_id"`
+		Description       string            `json:"description"`
+		Metadata          map[string]string `json:"metadata"`
+	}
+	if err := decodeJSON(r, &request); err != nil || request.Amount.Value == "" {
+		yooKassaError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	payment := &yooKassaPayment{
+		ID: s.nextID("pay_sandbox_"), Status: "pending", Amount: request.Amount,
+		Description: request.Description, Metadata: request.Metadata, CreatedAt: time.Now().UTC(),
+		returnURL: request.Confirmation.ReturnURL, savesMethod: request.SavePaymentMethod,
+	}
+	payment.PaymentMethod.Type = "bank_card"
+	if request.PaymentMethodID == "" {
+		payment.Confirmation = &struct {
+			Type            string `json:"type"`
+			ConfirmationURL string `json:"confirmation_url"`
+		}{Type: "redirect", ConfirmationURL: s.publicURL() + "/sandbox/yookassa/confirm/" + payment.ID}
+	} else {
+		payment.PaymentMethod.ID, payment.PaymentMethod.Saved = request.PaymentMethodID, true
+	}
+	s.mu.Lock()
+	s.payments[payment.ID] = payment
+	s.mu.Unlock()
+	s.remember(r, "payment", payment.ID)
+	if request.PaymentMethodID != "" {
+		s.settle(payment.ID, !declined(request.PaymentMethodID))
+	}
+	writeJSON(w, http.StatusOK, s.payment(payment.ID))
+}
+
+func (s *Server) yooKassaGetPayment(w http.ResponseWriter, r *http.Request) {
+	payment := s.payment(strings.TrimPrefix(r.URL.Path, "/v3/payments/"))
+	if payment == nil {
+		yooKassaError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	writeJSON(w, http.StatusOK, payment)
+}
+
+// yooKassaConfirm is the page the customer is redirected to. `result=decline`
+// declines the payment; anything else pays it. A GET returns to the shop's
+// return_url, a POST answers with the payment.
+func (s *Server) yooKassaConfirm(w http.ResponseWriter, r *http.Request) {
+	payment := s.payment(strings.TrimPrefix(r.URL.Path, "/sandbox/yookassa/confirm/"))
+	if payment == nil {
+		yooKassaError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	if payment.Status == "pending" {
+		s.settle(payment.ID, r.URL.Query().Get("result") != "decline")
+		payment = s.payment(payment.ID)
+	}
+	if r.Method == http.MethodGet && payment.returnURL != "" {
+		http.Redirect(w, r, payment.returnURL, http.StatusFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, payment)
+}
+
+// settle decides a pending payment and notifies the backend.
+func (s *Server) settle(id string, succeeded bool) {
+	s.mu.Lock()
+	payment := s.payments[id]
+	if succeeded {
+		payment.Status, payment.Paid = "succeeded", true
+		if payment.savesMethod && payment.PaymentMethod.ID == "" {
+			payment.PaymentMethod.ID, payment.PaymentMethod.Saved = "pm_"+payment.ID, true
+		}
+	} else {
+		payment.Status = "canceled"
+		payment.CancellationDetails = &struct {
+			Party  string `json:"party"`
+			Reason string `json:"reason"`
+		}{Party: "payment_network", Reason: "insufficient_funds"}
+	}
+	snapshot := *payment
+	s.mu.Unlock()
+	s.yooKassaNotify("payment."+snapshot.Status, snapshot)
+}
+
+func (s *Server) yooKassaCreateRefund(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		yooKassaError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if id, ok := s.idempotent(r, "refund"); ok {
+		writeJSON(w, http.StatusOK, s.refund(id))
+		return
+	}
+	var request struct {
+		PaymentID string         `json:"payment_id"`
+		Amount    yooKassaAmount `json:"amount"`
+	}
+	if err := decodeJSON(r, &request); err != nil {
+		yooKassaError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	amount := number(request.Amount.Value)
+	s.mu.Lock()
+	payment := s.payments[request.PaymentID]
+	if payment == nil || payment.Status != "succeeded" || amount <= 0 ||
+		payment.refunded+amount > number(payment.Amount.Value)+0.009 {
+		s.mu.Unlock()
+		yooKassaError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	payment.refunded += amount
+	refund := &yooKassaRefund{
+		PaymentID: payment.ID, Status: "succeeded", Amount: request.Amount, CreatedAt: time.Now().UTC(),
+	}
+	s.mu.Unlock()
+	refund.ID = s.nextID("refund_sandbox_")
+	s.mu.Lock()
+	s.refunds[refund.ID] = refund
+	s.mu.Unlock()
+	s.remember(r, "refund", refund.ID)
+	s.yooKassaNotify("refund.succeeded", refund)
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func (s *Server) yooKassaGetRefund(w http.ResponseWriter, r *http.Request) {
+	refund := s.refund(strings.TrimPrefix(r.URL.Path, "/v3/refunds/"))
+	if refund == nil {
+		yooKassaError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func (s *Server) yooKassaNotify(event string, object any) {
+	body, err := json.Marshal(map[string]any{"type": "notification", "event": event, "object": object})
+	if err != nil {
+		return
+	}
+	_, _ = s.notify("yookassa", "/payments/yookassa/notify", "application/json", body, nil)
+}
+
+func (s *Server) payment(id string) *yooKassaPayment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[id]
+	if !ok {
+		return nil
+	}
+	snapshot := *payment
+	return &snapshot
+}
+
+func (s *Server) refund(id string) *yooKassaRefund {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refund, ok := s.refunds[id]
+	if !ok {
+		return nil
+	}
+	snapshot := *refund
+	return &snapshot
+}
+
+func (s *Server) publicURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.PublicURL
+}
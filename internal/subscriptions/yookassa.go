@@ -0,0 +1,335 @@
+package subscriptions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/config"
+	v2billing "reup-goals-backend/internal/v2/billing"
+)
+
+// ErrChargePending means the provider accepted a charge but has not decided
+// on it yet; its webhook confirms the order later.
+var ErrChargePending = errors.New("payment_charge_pending")
+
+// YooKassaClient takes card payments through the YooKassa API. YooKassa has
+// no recurrent subscriptions: the first payment saves the card and the
+// renewal runner charges it when the period ends.
+type YooKassaClient struct {
+	shopID  string
+	secret  string
+	baseURL string
+	client  *http.Client
+}
+
+func NewYooKassaClient(cfg *config.Config) *YooKassaClient {
+	return &YooKassaClient{
+		shopID:  cfg.YooKassaShopID,
+		secret:  cfg.YooKassaSecretKey,
+		baseURL: strings.TrimRight(cfg.YooKassaBaseURL, "/"),
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+func (c *YooKassaClient) Name() string {
+	return v2billing.ProviderYooKassa
+}
+
+func (c *YooKassaClient) Configured() bool {
+	return c != nil && c.shopID != "" && c.secret != ""
+}
+
+type yooKassaAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type yooKassaPayment struct {
+	ID            string         `json:"id"`
+	Status        string         `json:"status"`
+	Amount        yooKassaAmount `json:"amount"`
+	PaymentMethod struct {
+		ID    string `json:"id"`
+		Saved bool   `json:"saved"`
+	} `json:"payment_method"`
+	Confirmation struct {
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation"`
+	CancellationDetails struct {
+		Party  string `json:"party"`
+		Reason string `json:"reason"`
+	} `json:"cancellation_details"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type yooKassaRefund struct {
+	ID        string         `json:"id"`
+	PaymentID string         `json:"payment_id"`
+	Status    string         `json:"status"`
+	Amount    yooKassaAmount `json:"amount"`
+}
+
+// CheckoutSession creates a payment and returns the page to redirect the
+// customer to. The billing order travels in the payment metadata.
+func (c *YooKassaClient) CheckoutSession(ctx context.Context, request CheckoutRequest) (CheckoutSession, error) {
+	if !c.Configured() {
+		return CheckoutSession{}, ErrProviderNotConfigured
+	}
+	key := request.IdempotenceKey
+	if key == "" {
+		key = "checkout-" + strconv.FormatInt(request.OrderID, 10) + "-" + randomKey()
+	}
+	var payment yooKassaPayment
+	err := c.call(ctx, http.MethodPost, "/payments", key, map[string]any{
+		"amount":  yooKassaMoney(request.Amount, request.Currency),
+		"capture": true,
+		"confirmation": map[string]any{
+			"type": "redirect", "return_url": request.ReturnURL,
+		},
+		"save_payment_method": request.Recurrent,
+		"description":         yooKassaDescription(request.Description),
+		"metadata":            yooKassaMetadata(request.OrderID, request.UserID),
+	}, "yookassa_checkout", &payment)
+	if err != nil {
+		return CheckoutSession{}, err
+	}
+	if payment.Confirmation.ConfirmationURL == "" {
+		return CheckoutSession{}, errors.New("yookassa_confirmation_missing")
+	}
+	config := map[string]any{
+		"payment_id": payment.ID, "confirmation_url": payment.Confirmation.ConfirmationURL,
+		"invoice_id": strconv.FormatInt(request.OrderID, 10), "recurrent": request.Recurrent,
+	}
+	for key, value := range request.Extra {
+		config[key] = value
+	}
+	return CheckoutSession{
+		Provider: c.Name(), Mode: "redirect", Config: config,
+		CheckoutURL: payment.Confirmation.ConfirmationURL, PaymentID: payment.ID,
+	}, nil
+}
+
+// ParseWebhook reads a JSON notification. Notifications are not signed, so
+// the object they name is fetched from the API and only its state is
+// trusted.
+func (c *YooKassaClient) ParseWebhook(ctx context.Context, r *http.Request) (WebhookEvent, error) {
+	var notification struct {
+		Event  string `json:"event"`
+		Object struct {
+			ID string `json:"id"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(readRawBody(r), &notification); err != nil || notification.Object.ID == "" {
+		return WebhookEvent{}, ErrWebhookRejected
+	}
+	id := url.PathEscape(notification.Object.ID)
+	switch notification.Event {
+	case "payment.succeeded", "payment.canceled":
+		var payment yooKassaPayment
+		if err := c.call(ctx, http.MethodGet, "/payments/"+id, "", nil, "yookassa_payment", &payment); err != nil {
+			return WebhookEvent{}, err
+		}
+		if "payment."+payment.Status != notification.Event {
+			return WebhookEvent{}, ErrWebhookRejected
+		}
+		return yooKassaPaymentEvent(payment), nil
+	case "refund.succeeded":
+		var refund yooKassaRefund
+		if err := c.call(ctx, http.MethodGet, "/refunds/"+id, "", nil, "yookassa_refund", &refund); err != nil {
+			return WebhookEvent{}, err
+		}
+		if refund.Status != "succeeded" {
+			return WebhookEvent{}, ErrWebhookRejected
+		}
+		return WebhookEvent{
+			Type: EventRefund, TransactionID: refund.PaymentID,
+			Amount: parseAmount(refund.Amount.Value), Currency: refund.Amount.Currency,
+			Status: refund.Status, Fields: url.Values{"Status": {refund.Status}},
+		}, nil
+	default:
+		// payment.waiting_for_capture does not happen with capture=true;
+		// nothing else changes what was paid.
+		return WebhookEvent{Fields: url.Values{}}, nil
+	}
+}
+
+func yooKassaPaymentEvent(payment yooKassaPayment) WebhookEvent {
+	event := WebhookEvent{
+		Type: EventPay, TransactionID: payment.ID,
+		AccountID: payment.Metadata["account_id"],
+		Amount:    parseAmount(payment.Amount.Value), Currency: payment.Amount.Currency,
+		Status: payment.Status, Fields: url.Values{"Status": {payment.Status}},
+	}
+	if orderID, err := strconv.ParseInt(payment.Metadata["order_id"], 10, 64); err == nil && orderID > 0 {
+		event.OrderID = orderID
+	}
+	if payment.PaymentMethod.Saved {
+		event.Token = payment.PaymentMethod.ID
+	}
+	if payment.Status == "canceled" {
+		event.Type = EventFail
+		event.Reason = payment.CancellationDetails.Reason
+		event.Fields.Set("Reason", event.Reason)
+	}
+	return event
+}
+
+func (c *YooKassaClient) AcknowledgeWebhook(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrWebhookRejected):
+		w.WriteHeader(http.StatusBadRequest)
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// ChargeRecurring charges the saved payment method. A charge YooKassa has
+// not decided on yet returns ErrChargePending.
+func (c *YooKassaClient) ChargeRecurring(ctx context.Context, charge v2billing.DunningCharge) (string, error) {
+	var payment yooKassaPayment
+	err := c.call(ctx, http.MethodPost, "/payments", "charge-"+strconv.FormatInt(charge.OrderID, 10), map[string]any{
+		"amount":            yooKassaMoney(charge.Amount, charge.Currency),
+		"capture":           true,
+		"payment_method_id": charge.Token,
+		"description":       yooKassaDescription(charge.Description),
+		"metadata":          yooKassaMetadata(charge.OrderID, charge.UserID),
+	}, "yookassa_charge", &payment)
+	if err != nil {
+		return "", err
+	}
+	switch payment.Status {
+	case "succeeded":
+		return payment.ID, nil
+	case "canceled":
+		if payment.CancellationDetails.Reason != "" {
+			return "", errors.New(payment.CancellationDetails.Reason)
+		}
+		return "", errors.New("yookassa_charge_canceled")
+	default:
+		return "", ErrChargePending
+	}
+}
+
+// CancelSubscription has nothing to cancel: renewals stop once the
+// subscription is no longer active.
+func (c *YooKassaClient) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	return nil
+}
+
+func (c *YooKassaClient) Refund(ctx context.Context, refund Refund) (string, error) {
+	key := refund.IdempotenceKey
+	if key == "" {
+		key = "refund-" + refund.TransactionID + "-" + randomKey()
+	}
+	payload := map[string]any{
+		"payment_id": refund.TransactionID,
+		"amount":     yooKassaMoney(refund.Amount, refund.Currency),
+	}
+	if refund.Description != "" {
+		payload["description"] = yooKassaDescription(refund.Description)
+	}
+	var result yooKassaRefund
+	if err := c.call(ctx, http.MethodPost, "/refunds", key, payload, "yookassa_refund", &result); err != nil {
+		return "", err
+	}
+	if result.Status == "canceled" {
+		return "", declinedError("yookassa_refund_canceled")
+	}
+	return result.ID, nil
+}
+
+// call sends an API request. POST requests carry an Idempotence-Key, so a
+// retried request returns the first result instead of charging again.
+func (c *YooKassaClient) call(ctx context.Context, method, path, idempotenceKey string, payload any, failure string, result any) error {
+	if !c.Configured() {
+		return ErrProviderNotConfigured
+	}
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.shopID, c.secret)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Code string `json:"code"`
+		}
+		message := fmt.Sprintf("%s_http_%d", failure, resp.StatusCode)
+		if json.Unmarshal(data, &apiError) == nil && apiError.Code != "" {
+			message = fmt.Sprintf("%s_%s", failure, apiError.Code)
+		}
+		if resp.StatusCode < 500 {
+			return declinedError(message)
+		}
+		return errors.New(message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+func yooKassaMoney(amount float64, currency string) yooKassaAmount {
+	return yooKassaAmount{Value: strconv.FormatFloat(amount, 'f', 2, 64), Currency: currency}
+}
+
+func yooKassaMetadata(orderID int64, userID int) map[string]string {
+	return map[string]string{
+		"order_id": strconv.FormatInt(orderID, 10), "account_id": accountIDForUser(userID),
+	}
+}
+
+// yooKassaDescription trims a description to the 128 characters the API
+// accepts.
+func yooKassaDescription(description string) string {
+	runes := []rune(description)
+	if len(runes) > 128 {
+		return string(runes[:128])
+	}
+	return description
+}
+
+func randomKey() string {
+	data := make([]byte, 12)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
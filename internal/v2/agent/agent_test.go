@@ -2,13 +2,18 @@ package agent
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/v2/tactics"
 )
 
@@ -79,11 +84,16 @@ func TestAgentRequestTextKeepsInterfaceCommandOutOfVisibleMessage(t *testing.T)
 
 func TestRunTokenRejectsTamperingAndExpiry(t *testing.T) {
 	run := Run{PublicID: "run_test", WorkspaceID: 12, UserID: 34, ExecutionGeneration: 7}
-	token, err := signRunToken(strings.Repeat("s", 40), run, time.Minute)
+	key, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA, time.Now().Add(-time.Minute))
 	if err != nil {
 		t.Fatal(err)
 	}
-	claims, err := verifyRunToken(strings.Repeat("s", 40), token, run.PublicID)
+	keys := auth.NewStaticKeySet(nil, key)
+	token, err := signRunToken(keys, run, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := verifyRunToken(keys, "", token, run.PublicID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,26 +101,52 @@ func TestRunTokenRejectsTamperingAndExpiry(t *testing.T) {
 		claims.ExecutionGeneration != run.ExecutionGeneration {
 		t.Fatalf("unexpected claims: %#v", claims)
 	}
-	if _, err := verifyRunToken(strings.Repeat("x", 40), token, run.PublicID); err == nil {
-		t.Fatal("token signed with another secret must be rejected")
+	other, _ := auth.GenerateSigningKey(auth.AlgorithmEdDSA, time.Now().Add(-time.Minute))
+	if _, err := verifyRunToken(auth.NewStaticKeySet(nil, other), "", token, run.PublicID); err == nil {
+		t.Fatal("token signed with another key must be rejected")
 	}
-	if _, err := verifyRunToken(strings.Repeat("s", 40), token, "run_other"); err == nil {
+	if _, err := verifyRunToken(keys, "", token, "run_other"); err == nil {
 		t.Fatal("token must be bound to the expected run")
 	}
-	expired, err := signRunToken(strings.Repeat("s", 40), run, -time.Second)
+	session, err := auth.GenerateToken(keys, run.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyRunToken(keys, "", session, run.PublicID); err == nil {
+		t.Fatal("a session token must not pass as a run token")
+	}
+	expired, err := signRunToken(keys, run, -time.Second)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, err := verifyRunToken(strings.Repeat("s", 40), expired, run.PublicID); err == nil {
-		t.Fatal("expired token must be rejected")
+	if _, err := verifyRunToken(keys, "", expired, run.PublicID); err == nil || err.Error() != "expired_agent_run_token" {
+		t.Fatalf("expired token err = %v", err)
 	}
-	if _, err := signRunToken(strings.Repeat("s", 40), Run{
+	if _, err := signRunToken(keys, Run{
 		PublicID: "run_missing_generation", WorkspaceID: 12, UserID: 34,
 	}, time.Minute); err == nil {
 		t.Fatal("token without an execution generation must be rejected")
 	}
 }
 
+func TestLegacyRunTokenStillVerifies(t *testing.T) {
+	secret := strings.Repeat("s", 40)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(
+		`{"run_id":"run_test","workspace_id":12,"user_id":34,"execution_generation":7,"expires_at":` +
+			strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10) + `}`,
+	))
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(payload))
+	token := payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	keys := auth.NewStaticKeySet(nil)
+	if claims, err := verifyRunToken(keys, secret, token, "run_test"); err != nil || claims.ExecutionGeneration != 7 {
+		t.Fatalf("legacy token = %#v, %v", claims, err)
+	}
+	if _, err := verifyRunToken(keys, strings.Repeat("x", 40), token, "run_test"); err == nil {
+		t.Fatal("legacy token signed with another secret must be rejected")
+	}
+}
+
 func TestAgentStateEncryptionIsBoundToRun(t *testing.T) {
 	secret := strings.Repeat("k", 40)
 	ciphertext, err := encryptState(secret, "run_one", `{"state":"pending"}`)
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"reup-goals-backend/internal/ai"
+	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/v2/billing"
 	"reup-goals-backend/internal/v2/contextindex"
 	"reup-goals-backend/internal/v2/departments"
@@ -24,6 +25,7 @@ type Service struct {
 	model        string
 	releaseID    string
 	secret       string
+	keys         *auth.KeySet
 	maxTurns     int
 	dbx          *sql.DB
 	store        *Store
@@ -58,6 +60,7 @@ type ServiceConfig struct {
 	Model     string
 	ReleaseID string
 	Secret    string
+	Keys      *auth.KeySet
 	MaxTurns  int
 	Timeout   time.Duration
 }
@@ -85,7 +88,7 @@ func NewService(
 ) *Service {
 	service := &Service{
 		enabled: cfg.Enabled, model: cfg.Model, releaseID: strings.TrimSpace(cfg.ReleaseID),
-		secret: cfg.Secret, maxTurns: cfg.MaxTurns,
+		secret: cfg.Secret, keys: cfg.Keys, maxTurns: cfg.MaxTurns,
 		dbx: dbx, store: NewStore(dbx), tactics: tactics.NewStore(dbx),
 		tacticsApply: tacticsHandler, workspaces: workspaces.NewStore(dbx),
 		strategy:     strategyBridge,
@@ -821,7 +824,7 @@ func (s *Service) handleExecuteJob(ctx context.Context, job jobs.Job) error {
 		previousResponseID = session.PreviousResponseID
 		conversationID = session.ConversationID
 	}
-	runToken, err := signRunToken(s.secret, run, 6*time.Hour)
+	runToken, err := signRunToken(s.keys, run, 6*time.Hour)
 	if err != nil {
 		return s.failAttempt(ctx, run, reservationID, job, err)
 	}
@@ -905,7 +908,7 @@ func (s *Service) handleResumeJob(ctx context.Context, job jobs.Job) error {
 	for _, item := range approvals {
 		decisions = append(decisions, Decision{CallID: item.CallID, Approved: item.Status == "applied"})
 	}
-	runToken, err := signRunToken(s.secret, run, 6*time.Hour)
+	runToken, err := signRunToken(s.keys, run, 6*time.Hour)
 	if err != nil {
 		return s.failAttempt(ctx, run, "", job, err)
 	}
@@ -933,7 +936,8 @@ func (s *Service) startBillableRun(ctx context.Context, run Run) (string, bool,
 	if err == nil {
 		return reservation.ID, false, nil
 	}
-	if errors.Is(err, billing.ErrQuotaExceeded) || errors.Is(err, billing.ErrPaymentRequired) {
+	if errors.Is(err, billing.ErrQuotaExceeded) || errors.Is(err, billing.ErrAllocationExceeded) ||
+		errors.Is(err, billing.ErrPaymentRequired) || errors.Is(err, billing.ErrAIReadOnly) {
 		_ = s.store.SetFailed(ctx, run.ID, err.Error(), true)
 		_ = s.store.InsertEvent(ctx, run.ID, RuntimeEvent{
 			Type: "run_failed", Stage: "quota", Title: "Лимит AI исчерпан", Detail: err.Error(),
@@ -1467,7 +1471,7 @@ func min(left int, right int) int {
 }
 
 func (s *Service) internalRun(ctx context.Context, publicID string, token string) (Run, error) {
-	claims, err := verifyRunToken(s.secret, token, publicID)
+	claims, err := verifyRunToken(s.keys, s.secret, token, publicID)
 	if err != nil {
 		return Run{}, err
 	}
@@ -13,64 +13,89 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"reup-goals-backend/internal/auth"
 )
 
+const runTokenAudience = "reupgoals-agent-runtime"
+
 type runTokenClaims struct {
 	RunID               string `json:"run_id"`
 	WorkspaceID         int    `json:"workspace_id"`
 	UserID              int    `json:"user_id"`
 	ExecutionGeneration int    `json:"execution_generation"`
-	ExpiresAt           int64  `json:"expires_at"`
+	jwt.RegisteredClaims
 }
 
-func signRunToken(secret string, run Run, ttl time.Duration) (string, error) {
+// signRunToken issues the token the runtime presents when it calls back. It
+// is signed by the API key set, so a service holding the JWKS can check it
+// without sharing a secret.
+func signRunToken(keys *auth.KeySet, run Run, ttl time.Duration) (string, error) {
 	if run.ExecutionGeneration <= 0 {
 		return "", errors.New("invalid_agent_execution_generation")
 	}
+	now := time.Now()
 	claims := runTokenClaims{
 		RunID: run.PublicID, WorkspaceID: run.WorkspaceID, UserID: run.UserID,
 		ExecutionGeneration: run.ExecutionGeneration,
-		ExpiresAt:           time.Now().Add(ttl).Unix(),
-	}
-	raw, err := json.Marshal(claims)
-	if err != nil {
-		return "", err
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: auth.TokenIssuer, Audience: jwt.ClaimStrings{runTokenAudience}, Subject: run.PublicID,
+			IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
-	payload := base64.RawURLEncoding.EncodeToString(raw)
-	mac := hmac.New(sha256.New, []byte(secret))
-	_, _ = mac.Write([]byte(payload))
-	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	return payload + "." + signature, nil
+	return keys.Sign(claims)
 }
 
-func verifyRunToken(secret string, token string, expectedRunID string) (runTokenClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 2 {
+// verifyRunToken also accepts the HMAC tokens issued before key sets; they
+// are told apart by having two parts instead of three.
+func verifyRunToken(keys *auth.KeySet, secret string, token string, expectedRunID string) (runTokenClaims, error) {
+	if strings.Count(token, ".") == 1 {
+		return verifyLegacyRunToken(secret, token, expectedRunID)
+	}
+	var claims runTokenClaims
+	if err := keys.Parse(token, &claims, runTokenAudience); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return runTokenClaims{}, errors.New("expired_agent_run_token")
+		}
 		return runTokenClaims{}, errors.New("invalid_agent_run_token")
 	}
+	if !validRunTokenClaims(claims, expectedRunID) {
+		return runTokenClaims{}, errors.New("invalid_agent_run_token")
+	}
+	return claims, nil
+}
+
+func verifyLegacyRunToken(secret string, token string, expectedRunID string) (runTokenClaims, error) {
+	parts := strings.Split(token, ".")
 	mac := hmac.New(sha256.New, []byte(secret))
 	_, _ = mac.Write([]byte(parts[0]))
 	expected := mac.Sum(nil)
 	actual, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil || !hmac.Equal(expected, actual) {
+	if secret == "" || err != nil || !hmac.Equal(expected, actual) {
 		return runTokenClaims{}, errors.New("invalid_agent_run_token")
 	}
 	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
 	if err != nil {
 		return runTokenClaims{}, errors.New("invalid_agent_run_token")
 	}
-	var claims runTokenClaims
-	if err := json.Unmarshal(raw, &claims); err != nil {
-		return runTokenClaims{}, errors.New("invalid_agent_run_token")
+	var legacy struct {
+		runTokenClaims
+		ExpiresAt int64 `json:"expires_at"`
 	}
-	if claims.RunID == "" || claims.RunID != expectedRunID || claims.WorkspaceID <= 0 ||
-		claims.UserID <= 0 || claims.ExecutionGeneration <= 0 {
+	if err := json.Unmarshal(raw, &legacy); err != nil || !validRunTokenClaims(legacy.runTokenClaims, expectedRunID) {
 		return runTokenClaims{}, errors.New("invalid_agent_run_token")
 	}
-	if time.Now().Unix() >= claims.ExpiresAt {
+	if time.Now().Unix() >= legacy.ExpiresAt {
 		return runTokenClaims{}, errors.New("expired_agent_run_token")
 	}
-	return claims, nil
+	return legacy.runTokenClaims, nil
+}
+
+func validRunTokenClaims(claims runTokenClaims, expectedRunID string) bool {
+	return claims.RunID != "" && claims.RunID == expectedRunID && claims.WorkspaceID > 0 &&
+		claims.UserID > 0 && claims.ExecutionGeneration > 0
 }
 
 func encryptState(secret string, runID string, plaintext string) (string, error) {
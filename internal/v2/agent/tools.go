@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/v2/metrics"
 	"reup-goals-backend/internal/v2/tactics"
 )
@@ -223,14 +224,9 @@ func (s *Service) getEntity(ctx context.Context, workspaceID int, input map[stri
 				status, version, generated_at
 			FROM strategic_documents WHERE workspace_id=$1 AND id=$2
 		) item`,
-		"workspace_document": `SELECT to_jsonb(item) FROM (
-			SELECT id, parent_id, title, LEFT(content, 20000) AS content,
-				(char_length(content) > 20000) AS content_truncated,
-				status, favorite, linked_department_ids AS linked_direction_ids,
-				version, created_by, updated_by, updated_at
-			FROM workspace_documents
-			WHERE workspace_id=$1 AND id=$2 AND archived_at IS NULL
-		) item`,
+	}
+	if entityType == "workspace_document" {
+		return s.workspaceDocumentEntity(ctx, workspaceID, entityID)
 	}
 	query, ok := queries[entityType]
 	if !ok {
@@ -247,6 +243,41 @@ func (s *Service) getEntity(ctx context.Context, workspaceID int, input map[stri
 	return result, nil
 }
 
+// workspaceDocumentEntity opens the sealed content in the app, so the
+// excerpt is cut here rather than with LEFT() in SQL.
+func (s *Service) workspaceDocumentEntity(ctx context.Context, workspaceID int, documentID int) (any, error) {
+	var raw json.RawMessage
+	var content string
+	var keyVersion sql.NullInt64
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT to_jsonb(item), item.content, item.content_key_version FROM (
+			SELECT id, parent_id, title, content, content_key_version,
+				status, favorite, linked_department_ids AS linked_direction_ids,
+				version, created_by, updated_by, updated_at
+			FROM workspace_documents
+			WHERE workspace_id=$1 AND id=$2 AND archived_at IS NULL
+		) item
+	`, workspaceID, documentID).Scan(&raw, &content, &keyVersion)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = encryption.OpenText(ctx, workspaceID, content, keyVersion); err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	delete(result, "content_key_version")
+	runes := []rune(content)
+	result["content_truncated"] = len(runes) > 20000
+	if len(runes) > 20000 {
+		content = string(runes[:20000])
+	}
+	result["content"] = content
+	return result, nil
+}
+
 func (s *Service) priorityView(ctx context.Context, workspaceID int, input map[string]any) (any, error) {
 	scopeType := stringValue(input, "scope_type")
 	scopeID := intValue(input, "scope_id")
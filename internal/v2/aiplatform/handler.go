@@ -4,10 +4,12 @@ import (
 	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"reup-goals-backend/internal/audit"
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/v2/api"
 )
@@ -234,7 +236,7 @@ func (h *Handler) activate(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, http.StatusNotFound, "not_found")
 		return
 	}
-	if err := h.setActive(r, name, version); err != nil {
+	if err := h.setActive(r, name, version, audit.ActionPromptActivated); err != nil {
 		api.WriteError(w, http.StatusNotFound, "prompt_version_not_found")
 		return
 	}
@@ -258,20 +260,27 @@ func (h *Handler) rollback(w http.ResponseWriter, r *http.Request) {
 		ORDER BY COALESCE(activated_at, created_at) DESC
 		LIMIT 1
 	`, name).Scan(&version)
-	if err != nil || h.setActive(r, name, version) != nil {
+	if err != nil || h.setActive(r, name, version, audit.ActionPromptRolledBack) != nil {
 		api.WriteError(w, http.StatusNotFound, "rollback_version_not_found")
 		return
 	}
 	api.WriteJSON(w, http.StatusOK, map[string]any{"active": true, "name": name, "version": version})
 }
 
-func (h *Handler) setActive(r *http.Request, name string, version string) error {
+// setActive makes version the only active one and records the switch in the
+// shared audit chain within the same transaction.
+func (h *Handler) setActive(r *http.Request, name string, version string, action string) error {
 	tx, err := h.dbx.BeginTx(r.Context(), nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.ExecContext(r.Context(), `UPDATE v2_ai_prompt_configs SET status='archived', updated_at=NOW() WHERE prompt_name=$1 AND status='active'`, name); err != nil {
+	var previous sql.NullString
+	if err := tx.QueryRowContext(r.Context(), `
+		UPDATE v2_ai_prompt_configs SET status='archived', updated_at=NOW()
+		WHERE prompt_name=$1 AND status='active'
+		RETURNING prompt_version
+	`, name).Scan(&previous); err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return err
 	}
 	userID, _ := auth.UserIDFromContext(r.Context())
@@ -287,6 +296,16 @@ func (h *Handler) setActive(r *http.Request, name string, version string) error
 	if count == 0 {
 		return sql.ErrNoRows
 	}
+	event := audit.Event{Action: action, TargetType: "ai_prompt", TargetID: name, After: map[string]any{"version": version}}
+	if previous.Valid {
+		event.Before = map[string]any{"version": previous.String}
+	}
+	if userID > 0 {
+		event.ActorUserID = &userID
+	}
+	if _, err := audit.RecordTx(r.Context(), tx, event.WithRequest(r)); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
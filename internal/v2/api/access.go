@@ -12,6 +12,19 @@ import (
 	"reup-goals-backend/internal/v2/workspaces"
 )
 
+const (
+	AccessTierFull     = "full"
+	AccessTierReadOnly = "read_only"
+	AccessTierNone     = "none"
+)
+
+// Error codes for requests the read-only tier does not cover. Both are
+// answered with 402: paying again lifts them.
+const (
+	ErrCodeSubscriptionReadOnly   = "subscription_read_only"
+	ErrCodeSubscriptionReadOnlyAI = "subscription_read_only_ai"
+)
+
 // RequireProductAccess protects the paid product surface. Authentication,
 // onboarding and billing endpoints intentionally stay outside this middleware
 // so a new workspace can finish its company-context interview and subscribe.
@@ -46,6 +59,17 @@ func requireWorkspaceAccess(dbx *sql.DB, allowPendingOnboarding, requireAIChat b
 			WriteError(w, http.StatusInternalServerError, "workspace_access_failed")
 			return
 		}
+		// Workspaces that enforce 2FA stay closed until the member enrols;
+		// the profile endpoints used for enrolment sit outside this check.
+		satisfied, err := auth.WorkspaceTwoFactorSatisfied(r.Context(), dbx, workspace.ID, userID)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "workspace_access_failed")
+			return
+		}
+		if !satisfied {
+			WriteError(w, http.StatusForbidden, "two_factor_enrollment_required")
+			return
+		}
 		access, err := WorkspaceSubscriptionAccess(r.Context(), dbx, workspace.ID, workspace.OwnerUserID, time.Now().UTC())
 		if err != nil {
 			WriteError(w, http.StatusInternalServerError, "subscription_lookup_failed")
@@ -62,22 +86,45 @@ func requireWorkspaceAccess(dbx *sql.DB, allowPendingOnboarding, requireAIChat b
 				return
 			}
 		}
-		if !access.Product {
-			WriteError(w, http.StatusPaymentRequired, "payment_required")
+		if status, code := accessDenial(access, r.Method, requireAIChat); code != "" {
+			WriteError(w, status, code)
 			return
 		}
+		next(w, r)
+	}
+}
+
+// accessDenial returns the error for a request the workspace's access does
+// not cover, or an empty code when the request may proceed. A lapsed
+// workspace keeps reading and exporting its data; anything that changes
+// it or calls AI waits for payment.
+func accessDenial(access SubscriptionAccess, method string, requireAIChat bool) (int, string) {
+	if access.Product {
 		if requireAIChat && !access.AIChat {
-			WriteError(w, http.StatusForbidden, "ai_chat_not_included")
-			return
+			return http.StatusForbidden, "ai_chat_not_included"
 		}
-		next(w, r)
+		return 0, ""
+	}
+	if !access.ReadOnly {
+		return http.StatusPaymentRequired, "payment_required"
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return 0, ""
 	}
+	if requireAIChat {
+		return http.StatusPaymentRequired, ErrCodeSubscriptionReadOnlyAI
+	}
+	return http.StatusPaymentRequired, ErrCodeSubscriptionReadOnly
 }
 
 type SubscriptionAccess struct {
 	Product  bool
 	PlanCode string
 	AIChat   bool
+	// ReadOnly is set once a subscription has lapsed: the workspace can
+	// still read and export its data but not change it or call AI.
+	ReadOnly bool
+	Tier     string
 }
 
 func WorkspaceSubscriptionAccess(ctx context.Context, dbx *sql.DB, workspaceID, ownerUserID int, now time.Time) (SubscriptionAccess, error) {
@@ -91,17 +138,34 @@ func WorkspaceSubscriptionAccess(ctx context.Context, dbx *sql.DB, workspaceID,
 		LIMIT 1
 	`, workspaceID, ownerUserID).Scan(&status, &planCode, &periodEnd, &graceUntil)
 	if errors.Is(err, sql.ErrNoRows) {
-		return SubscriptionAccess{}, nil
+		return SubscriptionAccess{Tier: AccessTierNone}, nil
 	}
 	if err != nil {
 		return SubscriptionAccess{}, err
 	}
-	product := subscriptionGrantsProductAccess(status, periodEnd, graceUntil, now)
+	tier := SubscriptionAccessTier(status, periodEnd, graceUntil, now)
+	product := tier == AccessTierFull
 	plan, err := billing.PlanByCode(planCode)
 	if err != nil {
 		return SubscriptionAccess{}, err
 	}
-	return SubscriptionAccess{Product: product, PlanCode: plan.Code, AIChat: product && plan.AIChatEnabled}, nil
+	return SubscriptionAccess{
+		Product: product, PlanCode: plan.Code, AIChat: product && plan.AIChatEnabled,
+		ReadOnly: tier == AccessTierReadOnly, Tier: tier,
+	}, nil
+}
+
+// SubscriptionAccessTier tells full access from a lapsed subscription,
+// which keeps read-only access, and a workspace that never subscribed.
+func SubscriptionAccessTier(status string, periodEnd, graceUntil sql.NullTime, now time.Time) string {
+	switch {
+	case subscriptionGrantsProductAccess(status, periodEnd, graceUntil, now):
+		return AccessTierFull
+	case status != "inactive" || periodEnd.Valid:
+		return AccessTierReadOnly
+	default:
+		return AccessTierNone
+	}
 }
 
 func WorkspaceHasProductAccess(ctx context.Context, dbx *sql.DB, workspaceID, ownerUserID int, now time.Time) (bool, error) {
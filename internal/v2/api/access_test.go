@@ -2,6 +2,7 @@ package api
 
 import (
 	"database/sql"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -36,3 +37,77 @@ func TestSubscriptionGrantsProductAccess(t *testing.T) {
 		})
 	}
 }
+
+func TestSubscriptionAccessTier(t *testing.T) {
+	now := time.Date(2026, time.August, 5, 12, 0, 0, 0, time.UTC)
+	future := sql.NullTime{Time: now.Add(time.Hour), Valid: true}
+	past := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+
+	cases := []struct {
+		name       string
+		status     string
+		periodEnd  sql.NullTime
+		graceUntil sql.NullTime
+		want       string
+	}{
+		{name: "active", status: "active", want: AccessTierFull},
+		{name: "past due within grace", status: "past_due", graceUntil: future, want: AccessTierFull},
+		{name: "past due after grace", status: "past_due", graceUntil: past, want: AccessTierReadOnly},
+		{name: "cancelled after period", status: "cancelled", periodEnd: past, want: AccessTierReadOnly},
+		{name: "expired", status: "expired", periodEnd: past, want: AccessTierReadOnly},
+		{name: "inactive after a paid period", status: "inactive", periodEnd: past, want: AccessTierReadOnly},
+		{name: "never subscribed", status: "inactive", want: AccessTierNone},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SubscriptionAccessTier(test.status, test.periodEnd, test.graceUntil, now); got != test.want {
+				t.Fatalf("tier = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAccessDenial(t *testing.T) {
+	full := SubscriptionAccess{Product: true, AIChat: true, Tier: AccessTierFull}
+	fullWithoutChat := SubscriptionAccess{Product: true, Tier: AccessTierFull}
+	readOnly := SubscriptionAccess{ReadOnly: true, Tier: AccessTierReadOnly}
+	none := SubscriptionAccess{Tier: AccessTierNone}
+
+	cases := []struct {
+		name          string
+		access        SubscriptionAccess
+		method        string
+		requireAIChat bool
+		wantStatus    int
+		wantCode      string
+	}{
+		{name: "product read", access: full, method: http.MethodGet},
+		{name: "product write", access: full, method: http.MethodPost},
+		{name: "ai chat write", access: full, method: http.MethodPost, requireAIChat: true},
+		{name: "ai chat not in plan", access: fullWithoutChat, method: http.MethodGet, requireAIChat: true,
+			wantStatus: http.StatusForbidden, wantCode: "ai_chat_not_included"},
+		{name: "read only product read", access: readOnly, method: http.MethodGet},
+		{name: "read only product head", access: readOnly, method: http.MethodHead},
+		{name: "read only product create", access: readOnly, method: http.MethodPost,
+			wantStatus: http.StatusPaymentRequired, wantCode: ErrCodeSubscriptionReadOnly},
+		{name: "read only product update", access: readOnly, method: http.MethodPatch,
+			wantStatus: http.StatusPaymentRequired, wantCode: ErrCodeSubscriptionReadOnly},
+		{name: "read only product delete", access: readOnly, method: http.MethodDelete,
+			wantStatus: http.StatusPaymentRequired, wantCode: ErrCodeSubscriptionReadOnly},
+		{name: "read only ai chat history", access: readOnly, method: http.MethodGet, requireAIChat: true},
+		{name: "read only ai chat message", access: readOnly, method: http.MethodPost, requireAIChat: true,
+			wantStatus: http.StatusPaymentRequired, wantCode: ErrCodeSubscriptionReadOnlyAI},
+		{name: "unpaid read", access: none, method: http.MethodGet,
+			wantStatus: http.StatusPaymentRequired, wantCode: "payment_required"},
+		{name: "unpaid ai chat", access: none, method: http.MethodGet, requireAIChat: true,
+			wantStatus: http.StatusPaymentRequired, wantCode: "payment_required"},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			status, code := accessDenial(test.access, test.method, test.requireAIChat)
+			if status != test.wantStatus || code != test.wantCode {
+				t.Fatalf("accessDenial = (%d, %q), want (%d, %q)", status, code, test.wantStatus, test.wantCode)
+			}
+		})
+	}
+}
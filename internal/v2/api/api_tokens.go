@@ -0,0 +1,72 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"reup-goals-backend/internal/auth"
+	"reup-goals-backend/internal/security"
+	"reup-goals-backend/internal/v2/workspaces"
+)
+
+// apiTokenScopeFamilies maps the first /api/v2 path segment to the scope
+// family that guards it. Routes missing here are closed to API tokens:
+// profile, billing, privacy and the AI facilitators stay browser-only.
+var apiTokenScopeFamilies = map[string]string{
+	"course":            "course",
+	"courses":           "course",
+	"departments":       "departments",
+	"responsibilities":  "departments",
+	"metrics":           "metrics",
+	"okr":               "metrics",
+	"strategy":          "strategy",
+	"strategy-versions": "strategy",
+	"tactics":           "tactics",
+	"tasks":             "tasks",
+}
+
+// RequiredAPIScope returns the scope an API token needs for a request;
+// safe methods need read access, everything else write access.
+func RequiredAPIScope(r *http.Request) (string, bool) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	if path == r.URL.Path {
+		return "", false
+	}
+	segment, _, _ := strings.Cut(path, "/")
+	family, ok := apiTokenScopeFamilies[segment]
+	if !ok {
+		return "", false
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return family + ":read", true
+	}
+	return family + ":write", true
+}
+
+// requireAPIToken authenticates a bearer API token in place of a session.
+// Tokens act as their owner but only on routes their scopes cover, and never
+// satisfy re-authentication because they carry no session. A workspace token
+// is pinned to its workspace rather than its owner's default one.
+func requireAPIToken(w http.ResponseWriter, r *http.Request, dbx *sql.DB, token string, next http.HandlerFunc) {
+	required, ok := RequiredAPIScope(r)
+	if !ok {
+		WriteError(w, http.StatusForbidden, "api_token_route_not_allowed")
+		return
+	}
+	principal, err := auth.AuthenticateAPIToken(r.Context(), dbx, token, security.ClientIP(r))
+	if err != nil {
+		WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !auth.APIScopeAllows(principal.Scopes, required) {
+		w.Header().Set("X-Required-Scope", required)
+		WriteError(w, http.StatusForbidden, "insufficient_scope")
+		return
+	}
+	ctx := auth.ContextWithUserID(r.Context(), principal.UserID)
+	if principal.WorkspaceID != nil {
+		ctx = workspaces.ContextWithWorkspace(ctx, *principal.WorkspaceID)
+	}
+	next(w, r.WithContext(ctx))
+}
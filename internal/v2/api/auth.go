@@ -31,14 +31,29 @@ type authValidationEntry struct {
 
 var authValidationEntries sync.Map
 
-func RequireAuth(dbx *sql.DB, secret []byte, next http.HandlerFunc) http.HandlerFunc {
+type sessionValidationEntry struct {
+	mu         sync.Mutex
+	userID     int
+	valid      bool
+	refreshing bool
+	expiresAt  time.Time
+	staleUntil time.Time
+}
+
+var sessionValidationEntries sync.Map
+
+func RequireAuth(dbx *sql.DB, keys *auth.KeySet, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString, ok := auth.TokenFromRequest(r)
 		if !ok {
 			WriteError(w, http.StatusUnauthorized, "unauthorized")
 			return
 		}
-		claims, err := auth.ParseTokenClaims(secret, tokenString)
+		if auth.IsAPIToken(tokenString) {
+			requireAPIToken(w, r, dbx, tokenString, next)
+			return
+		}
+		claims, err := auth.ParseTokenClaims(keys, tokenString)
 		if err != nil {
 			WriteError(w, http.StatusUnauthorized, "unauthorized")
 			return
@@ -47,8 +62,13 @@ func RequireAuth(dbx *sql.DB, secret []byte, next http.HandlerFunc) http.Handler
 			WriteError(w, http.StatusUnauthorized, "unauthorized")
 			return
 		}
+		if dbx != nil && claims.SessionID != "" && !validSession(r.Context(), dbx, claims.UserID, claims.SessionID) {
+			WriteError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
 
 		ctx := auth.ContextWithUserID(r.Context(), claims.UserID)
+		ctx = auth.ContextWithSessionID(ctx, claims.SessionID)
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -129,6 +149,84 @@ func refreshAuthenticatedUser(parent context.Context, dbx *sql.DB, userID int, a
 	entry.staleUntil = now.Add(authValidationStaleGrace)
 }
 
+// validSession mirrors validAuthenticatedUser for per-device sessions: a
+// revoked session stops working within the same TTL as a bumped auth_version.
+func validSession(ctx context.Context, dbx *sql.DB, userID int, sessionID string) bool {
+	value, _ := sessionValidationEntries.LoadOrStore(sessionID, &sessionValidationEntry{})
+	entry := value.(*sessionValidationEntry)
+	entry.mu.Lock()
+
+	now := time.Now()
+	if entry.valid && entry.userID == userID && now.Before(entry.expiresAt) {
+		entry.mu.Unlock()
+		return true
+	}
+	if entry.valid && entry.userID == userID && now.Before(entry.staleUntil) {
+		if !entry.refreshing {
+			entry.refreshing = true
+			go refreshSession(context.WithoutCancel(ctx), dbx, userID, sessionID, entry)
+		}
+		entry.mu.Unlock()
+		return true
+	}
+	entry.mu.Unlock()
+	if dbx == nil {
+		return false
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, authValidationTimeout)
+	defer cancel()
+	active, err := auth.SessionActive(queryCtx, dbx, userID, sessionID)
+	if err != nil || !active {
+		sessionValidationEntries.Delete(sessionID)
+		return false
+	}
+	entry.mu.Lock()
+	entry.userID = userID
+	entry.valid = true
+	entry.expiresAt = now.Add(authValidationTTL)
+	entry.staleUntil = now.Add(authValidationStaleGrace)
+	entry.mu.Unlock()
+	return true
+}
+
+func refreshSession(parent context.Context, dbx *sql.DB, userID int, sessionID string, entry *sessionValidationEntry) {
+	ctx, cancel := context.WithTimeout(parent, authValidationTimeout)
+	defer cancel()
+
+	active, err := auth.SessionActive(ctx, dbx, userID, sessionID)
+	now := time.Now()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.refreshing = false
+	if err != nil {
+		entry.expiresAt = now.Add(time.Second)
+		return
+	}
+	if !active {
+		entry.valid = false
+		entry.expiresAt = time.Time{}
+		entry.staleUntil = time.Time{}
+		sessionValidationEntries.Delete(sessionID)
+		return
+	}
+	entry.valid = true
+	entry.expiresAt = now.Add(authValidationTTL)
+	entry.staleUntil = now.Add(authValidationStaleGrace)
+}
+
+// ForgetSession drops the cached validation of a revoked session so this
+// instance rejects it immediately instead of after the cache TTL.
+func ForgetSession(sessionID string) {
+	if value, ok := sessionValidationEntries.LoadAndDelete(sessionID); ok {
+		entry := value.(*sessionValidationEntry)
+		entry.mu.Lock()
+		entry.valid = false
+		entry.mu.Unlock()
+	}
+}
+
 func WriteJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -144,10 +242,18 @@ func WriteAIError(w http.ResponseWriter, err error, fallbackStatus int, fallback
 		WriteError(w, http.StatusTooManyRequests, billing.ErrQuotaExceeded.Error())
 		return
 	}
+	if errors.Is(err, billing.ErrAllocationExceeded) {
+		WriteError(w, http.StatusTooManyRequests, billing.ErrAllocationExceeded.Error())
+		return
+	}
 	if errors.Is(err, billing.ErrPaymentRequired) {
 		WriteError(w, http.StatusPaymentRequired, billing.ErrPaymentRequired.Error())
 		return
 	}
+	if errors.Is(err, billing.ErrAIReadOnly) {
+		WriteError(w, http.StatusPaymentRequired, billing.ErrAIReadOnly.Error())
+		return
+	}
 	if errors.Is(err, ai.ErrRateLimitExceeded) {
 		WriteError(w, http.StatusTooManyRequests, ai.ErrRateLimitExceeded.Error())
 		return
@@ -0,0 +1,315 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	AllocationTokens  = "tokens"
+	AllocationPercent = "percent"
+
+	AIModuleAgentRuns      = "agent_runs"
+	AIModuleTaskEvaluation = "task_evaluation"
+	AIModuleStrategy       = "strategy_sessions"
+	AIModuleKnowledgeBase  = "knowledge_base"
+)
+
+// ErrAllocationExceeded means the member, their department or the AI module
+// used up its share of the weekly limit while the workspace still has some.
+var ErrAllocationExceeded = errors.New("ai_allocation_limit_reached")
+var ErrAllocationInvalid = errors.New("ai_allocation_invalid")
+var ErrAllocationNotFound = errors.New("ai_allocation_not_found")
+
+// aiModuleGroups lists the AI modules each cap-able module group covers.
+var aiModuleGroups = map[string][]string{
+	AIModuleAgentRuns:      {"executive_advisor"},
+	AIModuleTaskEvaluation: {"task_brainstorm", "task_completion_evaluator", "task_evaluator_v2"},
+	AIModuleStrategy:       {"strategy_facilitator_openai_native", "tactics_advisor_openai_native"},
+	AIModuleKnowledgeBase:  {"business_auditor_openai_native", "business_document_chat"},
+}
+
+// AIModuleGroup returns the module group an AI module is capped under, or
+// "" for modules no cap covers.
+func AIModuleGroup(module string) string {
+	module = strings.TrimSpace(module)
+	for group, modules := range aiModuleGroups {
+		for _, candidate := range modules {
+			if candidate == module {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+// AllocationInput caps the weekly AI usage of a member, a department or a
+// module group; a module group together with a member or a department caps
+// only that module for them. Value is in tokens, or in percent of the
+// weekly limit.
+type AllocationInput struct {
+	UserID       int    `json:"user_id"`
+	DepartmentID int    `json:"department_id"`
+	Module       string `json:"module"`
+	Kind         string `json:"kind"`
+	Value        int    `json:"value"`
+}
+
+// Allocation is a cap with what it allows and what was used against it in
+// the current weekly window.
+type Allocation struct {
+	ID              int64     `json:"id"`
+	UserID          *int      `json:"user_id,omitempty"`
+	UserName        string    `json:"user_name,omitempty"`
+	DepartmentID    *int      `json:"department_id,omitempty"`
+	DepartmentName  string    `json:"department_name,omitempty"`
+	Module          string    `json:"module,omitempty"`
+	Kind            string    `json:"kind"`
+	Value           int       `json:"value"`
+	LimitTokens     int       `json:"limit_tokens"`
+	UsedTokens      int       `json:"used_tokens"`
+	RemainingTokens int       `json:"remaining_tokens"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func normalizeAllocationInput(input AllocationInput) (AllocationInput, error) {
+	input.Module = strings.TrimSpace(input.Module)
+	input.Kind = strings.ToLower(strings.TrimSpace(input.Kind))
+	if input.Kind == "" {
+		input.Kind = AllocationTokens
+	}
+	switch {
+	case input.UserID < 0, input.DepartmentID < 0, input.UserID > 0 && input.DepartmentID > 0:
+		return AllocationInput{}, ErrAllocationInvalid
+	case input.Module != "" && aiModuleGroups[input.Module] == nil:
+		return AllocationInput{}, ErrAllocationInvalid
+	case input.UserID == 0 && input.DepartmentID == 0 && input.Module == "":
+		// A workspace-wide cap on everything is the weekly limit itself.
+		return AllocationInput{}, ErrAllocationInvalid
+	case input.Kind != AllocationTokens && input.Kind != AllocationPercent:
+		return AllocationInput{}, ErrAllocationInvalid
+	case input.Value < 0, input.Kind == AllocationPercent && input.Value > 100:
+		return AllocationInput{}, ErrAllocationInvalid
+	}
+	return input, nil
+}
+
+// allocationLimit converts a cap into tokens of the current weekly limit.
+func allocationLimit(kind string, value, weeklyLimit int) int {
+	if kind == AllocationPercent {
+		return int(math.Floor(float64(max(0, weeklyLimit)) * float64(value) / 100))
+	}
+	return max(0, value)
+}
+
+// allocationHeadroom is what the tightest of the caps still allows. It
+// reports false when no cap applies.
+func allocationHeadroom(caps []Allocation) (int, bool) {
+	if len(caps) == 0 {
+		return 0, false
+	}
+	headroom := math.MaxInt
+	for _, item := range caps {
+		headroom = min(headroom, max(0, item.LimitTokens-item.UsedTokens))
+	}
+	return headroom, true
+}
+
+const allocationSelect = `
+	SELECT allocation.id, allocation.user_id, COALESCE(users.name, ''),
+		allocation.department_id, COALESCE(department.name, ''), allocation.ai_module_group,
+		allocation.limit_kind, allocation.limit_value, allocation.updated_at
+	FROM workspace_ai_quota_allocations allocation
+	LEFT JOIN users ON users.id=allocation.user_id
+	LEFT JOIN v2_departments department ON department.id=allocation.department_id
+`
+
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Allocations lists the workspace's caps with their usage in the current
+// weekly window.
+func (s *Service) Allocations(ctx context.Context, workspaceID int) ([]Allocation, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	state, err := s.ensureQuota(ctx, tx, workspaceID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	result, err := loadAllocations(ctx, tx, state, allocationSelect+`
+		WHERE allocation.workspace_id=$1
+		ORDER BY allocation.user_id NULLS LAST, allocation.department_id NULLS LAST,
+			allocation.ai_module_group, allocation.id
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// SaveAllocation creates the cap for its member, department and module, or
+// replaces the one already set for them.
+func (s *Service) SaveAllocation(ctx context.Context, workspaceID, updatedBy int, input AllocationInput) (Allocation, error) {
+	input, err := normalizeAllocationInput(input)
+	if err != nil {
+		return Allocation{}, err
+	}
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return Allocation{}, err
+	}
+	defer tx.Rollback()
+	var valid bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT ($2=0 OR EXISTS (
+				SELECT 1 FROM workspace_memberships
+				WHERE workspace_id=$1 AND user_id=$2 AND status='active'
+			))
+			AND ($3=0 OR EXISTS (
+				SELECT 1 FROM v2_departments
+				WHERE workspace_id=$1 AND id=$3 AND archived_at IS NULL
+			))
+	`, workspaceID, input.UserID, input.DepartmentID).Scan(&valid)
+	if err != nil {
+		return Allocation{}, err
+	}
+	if !valid {
+		return Allocation{}, ErrAllocationInvalid
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO workspace_ai_quota_allocations (
+			workspace_id, user_id, department_id, ai_module_group, limit_kind, limit_value, updated_by
+		) VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, NULLIF($7, 0))
+		ON CONFLICT (workspace_id, COALESCE(user_id, 0), COALESCE(department_id, 0), ai_module_group)
+		DO UPDATE SET limit_kind=EXCLUDED.limit_kind, limit_value=EXCLUDED.limit_value,
+			updated_by=EXCLUDED.updated_by, updated_at=NOW()
+		RETURNING id
+	`, workspaceID, input.UserID, input.DepartmentID, input.Module, input.Kind, input.Value, updatedBy).Scan(&id)
+	if err != nil {
+		return Allocation{}, err
+	}
+	state, err := s.ensureQuota(ctx, tx, workspaceID, time.Now().UTC())
+	if err != nil {
+		return Allocation{}, err
+	}
+	items, err := loadAllocations(ctx, tx, state, allocationSelect+`
+		WHERE allocation.id=$1
+	`, id)
+	if err != nil {
+		return Allocation{}, err
+	}
+	if len(items) == 0 {
+		return Allocation{}, ErrAllocationNotFound
+	}
+	return items[0], tx.Commit()
+}
+
+func (s *Service) DeleteAllocation(ctx context.Context, workspaceID int, id int64) error {
+	result, err := s.dbx.ExecContext(ctx, `
+		DELETE FROM workspace_ai_quota_allocations WHERE workspace_id=$1 AND id=$2
+	`, workspaceID, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAllocationNotFound
+	}
+	return nil
+}
+
+// applicableAllocations loads the caps a reservation by userID for module
+// falls under: the member's own, their departments' and the module's.
+func applicableAllocations(ctx context.Context, tx *sql.Tx, state quotaState, workspaceID, userID int, module string) ([]Allocation, error) {
+	return loadAllocations(ctx, tx, state, allocationSelect+`
+		WHERE allocation.workspace_id=$1
+			AND (allocation.user_id IS NULL OR allocation.user_id=$2)
+			AND (allocation.department_id IS NULL OR allocation.department_id IN (
+				SELECT department_id FROM v2_department_members WHERE workspace_id=$1 AND user_id=$2
+			))
+			AND (allocation.ai_module_group='' OR allocation.ai_module_group=$3)
+	`, workspaceID, userID, AIModuleGroup(module))
+}
+
+func loadAllocations(ctx context.Context, q rowsQueryer, state quotaState, query string, args ...any) ([]Allocation, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Allocation, 0)
+	for rows.Next() {
+		var item Allocation
+		var userID, departmentID sql.NullInt64
+		if err := rows.Scan(
+			&item.ID, &userID, &item.UserName, &departmentID, &item.DepartmentName, &item.Module,
+			&item.Kind, &item.Value, &item.UpdatedAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			item.UserID = &id
+		}
+		if departmentID.Valid {
+			id := int(departmentID.Int64)
+			item.DepartmentID = &id
+		}
+		item.LimitTokens = allocationLimit(item.Kind, item.Value, state.baseLimit)
+		result = append(result, item)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for index := range result {
+		used, err := allocationUsage(ctx, q, state, result[index])
+		if err != nil {
+			return nil, err
+		}
+		result[index].UsedTokens = used
+		result[index].RemainingTokens = max(0, result[index].LimitTokens-used)
+	}
+	return result, nil
+}
+
+// allocationUsage sums the tokens reserved or charged in the current window
+// by the AI calls a cap covers.
+func allocationUsage(ctx context.Context, q rowsQueryer, state quotaState, item Allocation) (int, error) {
+	userID, departmentID := 0, 0
+	if item.UserID != nil {
+		userID = *item.UserID
+	}
+	if item.DepartmentID != nil {
+		departmentID = *item.DepartmentID
+	}
+	var used int
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(event.amount), 0)
+		FROM workspace_ai_quota_events event
+		JOIN workspace_ai_quota_allocations allocation ON allocation.id=$1
+		WHERE event.workspace_id=allocation.workspace_id
+			AND event.event_type='ai_call'
+			AND event.status IN ('reserved', 'consumed')
+			AND event.created_at >= $2
+			AND ($3=0 OR event.user_id=$3)
+			AND ($4=0 OR event.user_id IN (
+				SELECT user_id FROM v2_department_members WHERE department_id=$4
+			))
+			AND ($5='' OR event.ai_module=ANY($6))
+	`, item.ID, state.windowStartedAt, userID, departmentID, item.Module, pq.Array(aiModuleGroups[item.Module])).Scan(&used)
+	return used, err
+}
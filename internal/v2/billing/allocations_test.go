@@ -0,0 +1,96 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeAllocationInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input AllocationInput
+		want  AllocationInput
+		err   error
+	}{
+		{
+			name:  "member in tokens by default",
+			input: AllocationInput{UserID: 7, Value: 500_000},
+			want:  AllocationInput{UserID: 7, Kind: AllocationTokens, Value: 500_000},
+		},
+		{
+			name:  "department share of agent runs",
+			input: AllocationInput{DepartmentID: 3, Module: " agent_runs ", Kind: "Percent", Value: 40},
+			want:  AllocationInput{DepartmentID: 3, Module: AIModuleAgentRuns, Kind: AllocationPercent, Value: 40},
+		},
+		{
+			name:  "module for the whole workspace",
+			input: AllocationInput{Module: AIModuleTaskEvaluation, Kind: AllocationPercent, Value: 25},
+			want:  AllocationInput{Module: AIModuleTaskEvaluation, Kind: AllocationPercent, Value: 25},
+		},
+		{name: "nothing to cap", input: AllocationInput{Value: 10}, err: ErrAllocationInvalid},
+		{name: "member and department", input: AllocationInput{UserID: 7, DepartmentID: 3, Value: 10}, err: ErrAllocationInvalid},
+		{name: "unknown module", input: AllocationInput{Module: "executive_advisor", Value: 10}, err: ErrAllocationInvalid},
+		{name: "unknown kind", input: AllocationInput{UserID: 7, Kind: "messages", Value: 10}, err: ErrAllocationInvalid},
+		{name: "negative", input: AllocationInput{UserID: 7, Value: -1}, err: ErrAllocationInvalid},
+		{name: "above 100 percent", input: AllocationInput{UserID: 7, Kind: AllocationPercent, Value: 101}, err: ErrAllocationInvalid},
+	}
+	for _, test := range tests {
+		got, err := normalizeAllocationInput(test.input)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Fatalf("%s: normalizeAllocationInput = %+v, %v; want %+v, %v", test.name, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestAllocationLimit(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value int
+		want  int
+	}{
+		{AllocationTokens, 250_000, 250_000},
+		{AllocationPercent, 30, 900_000},
+		{AllocationPercent, 0, 0},
+		{AllocationPercent, 100, 3_000_000},
+	}
+	for _, test := range tests {
+		if got := allocationLimit(test.kind, test.value, 3_000_000); got != test.want {
+			t.Fatalf("allocationLimit(%s, %d) = %d, want %d", test.kind, test.value, got, test.want)
+		}
+	}
+	if got := allocationLimit(AllocationPercent, 33, 1_000); got != 330 {
+		t.Fatalf("percent of a small limit = %d", got)
+	}
+}
+
+func TestAllocationHeadroom(t *testing.T) {
+	if _, capped := allocationHeadroom(nil); capped {
+		t.Fatal("no caps must leave the reservation uncapped")
+	}
+	headroom, capped := allocationHeadroom([]Allocation{
+		{LimitTokens: 900_000, UsedTokens: 100_000},
+		{LimitTokens: 300_000, UsedTokens: 260_000},
+	})
+	if !capped || headroom != 40_000 {
+		t.Fatalf("tightest cap = %d, %v", headroom, capped)
+	}
+	if headroom, _ := allocationHeadroom([]Allocation{{LimitTokens: 100_000, UsedTokens: 130_000}}); headroom != 0 {
+		t.Fatalf("overdrawn cap = %d", headroom)
+	}
+}
+
+func TestAIModuleGroup(t *testing.T) {
+	tests := map[string]string{
+		"executive_advisor":                  AIModuleAgentRuns,
+		"task_brainstorm":                    AIModuleTaskEvaluation,
+		"task_completion_evaluator":          AIModuleTaskEvaluation,
+		"strategy_facilitator_openai_native": AIModuleStrategy,
+		"business_document_chat":             AIModuleKnowledgeBase,
+		"audio_transcription":                "",
+	}
+	for module, want := range tests {
+		if got := AIModuleGroup(module); got != want {
+			t.Fatalf("AIModuleGroup(%q) = %q, want %q", module, got, want)
+		}
+	}
+}
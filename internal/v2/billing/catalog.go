@@ -3,6 +3,8 @@ package billing
 import (
 	"errors"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,9 +19,14 @@ const (
 
 	OrderSubscription = "subscription"
 	OrderQuotaReset   = "quota_reset"
+	OrderPlanChange   = "plan_change"
+
+	ProviderCloudPayments = "cloudpayments"
+	ProviderYooKassa      = "yookassa"
 )
 
 var ErrPlanNotFound = errors.New("billing_plan_not_found")
+var ErrPlanUnavailable = errors.New("billing_plan_unavailable")
 
 type Plan struct {
 	Code              string  `json:"code"`
@@ -35,45 +42,160 @@ type Plan struct {
 	EquivalentTokens  int     `json:"equivalent_tokens_month"`
 	AIChatEnabled     bool    `json:"ai_chat_enabled"`
 	PerSeatPricing    bool    `json:"per_seat_pricing"`
+	Active            bool    `json:"active"`
+	// PriceVersion identifies the amounts above; zero for the built-in
+	// catalog used before the database one is loaded.
+	PriceVersion int64 `json:"price_version,omitempty"`
+}
+
+// PriceVersion is one price point of a plan. Versions are never edited:
+// subscriptions keep the version they bought until they change plan, and a
+// new price takes effect for everyone else from EffectiveFrom.
+type PriceVersion struct {
+	ID              int64     `json:"id"`
+	PlanCode        string    `json:"plan_code"`
+	MonthlyAmount   float64   `json:"monthly_amount"`
+	QuarterlyAmount float64   `json:"quarterly_amount"`
+	AnnualAmount    float64   `json:"annual_amount"`
+	Currency        string    `json:"currency"`
+	EffectiveFrom   time.Time `json:"effective_from"`
+	CreatedBy       string    `json:"created_by,omitempty"`
+}
+
+// Catalog is the set of plans with their price history. The plans below
+// are the built-in catalog; the one in billing_plans replaces it once
+// loaded, so PlanByCode keeps answering before the database is reachable.
+type Catalog struct {
+	plans  []Plan
+	prices map[string][]PriceVersion
+}
+
+// NewCatalog keeps plans in the given order. Prices must be sorted by
+// EffectiveFrom within each plan.
+func NewCatalog(plans []Plan, prices []PriceVersion) *Catalog {
+	catalog := &Catalog{plans: append([]Plan(nil), plans...), prices: map[string][]PriceVersion{}}
+	for _, price := range prices {
+		catalog.prices[price.PlanCode] = append(catalog.prices[price.PlanCode], price)
+	}
+	return catalog
+}
+
+var installedCatalog atomic.Pointer[Catalog]
+
+// InstallCatalog makes catalog the one behind the package-level lookups.
+func InstallCatalog(catalog *Catalog) {
+	installedCatalog.Store(catalog)
+}
+
+func currentCatalog() *Catalog {
+	if catalog := installedCatalog.Load(); catalog != nil {
+		return catalog
+	}
+	return builtinCatalog
+}
+
+func (c *Catalog) plan(code string) (Plan, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, item := range c.plans {
+		if item.Code == code {
+			return item, true
+		}
+	}
+	return Plan{}, false
+}
+
+// priced applies the latest price version in effect at now.
+func (c *Catalog) priced(plan Plan, now time.Time) Plan {
+	versions := c.prices[plan.Code]
+	for index := len(versions) - 1; index >= 0; index-- {
+		if !versions[index].EffectiveFrom.After(now) {
+			return withPrice(plan, versions[index])
+		}
+	}
+	return plan
+}
+
+func (c *Catalog) atVersion(plan Plan, versionID int64) (Plan, bool) {
+	for _, version := range c.prices[plan.Code] {
+		if version.ID == versionID {
+			return withPrice(plan, version), true
+		}
+	}
+	return Plan{}, false
+}
+
+func withPrice(plan Plan, version PriceVersion) Plan {
+	plan.MonthlyAmount = version.MonthlyAmount
+	plan.QuarterlyAmount = version.QuarterlyAmount
+	plan.AnnualAmount = version.AnnualAmount
+	plan.Currency = version.Currency
+	plan.PriceVersion = version.ID
+	return plan
 }
 
 var plans = []Plan{
 	{
 		Code: PlanStart, Name: "Start", MonthlyAmount: 290, QuarterlyAmount: 783, AnnualAmount: 2436,
 		Currency: "RUB", MemberLimit: 1, WeeklyTokenLimit: 0, ResetAmount: 0,
-		StandardResponses: 0, EquivalentTokens: 0, AIChatEnabled: false, PerSeatPricing: true,
+		StandardResponses: 0, EquivalentTokens: 0, AIChatEnabled: false, PerSeatPricing: true, Active: true,
 	},
 	{
 		Code: PlanFounder, Name: "Founder", MonthlyAmount: 3490, QuarterlyAmount: 9423, AnnualAmount: 29316,
 		Currency: "RUB", MemberLimit: 1, WeeklyTokenLimit: 1_250_000, ResetAmount: 890,
-		StandardResponses: 650, EquivalentTokens: 5_000_000, AIChatEnabled: true,
+		StandardResponses: 650, EquivalentTokens: 5_000_000, AIChatEnabled: true, Active: true,
 	},
 	{
 		Code: PlanTeam, Name: "Team", MonthlyAmount: 11990, QuarterlyAmount: 32373, AnnualAmount: 100716,
 		Currency: "RUB", MemberLimit: 5, WeeklyTokenLimit: 3_000_000, ResetAmount: 2990,
-		StandardResponses: 1730, EquivalentTokens: 12_000_000, AIChatEnabled: true,
+		StandardResponses: 1730, EquivalentTokens: 12_000_000, AIChatEnabled: true, Active: true,
 	},
 	{
 		Code: PlanCompany, Name: "Company", MonthlyAmount: 29990, QuarterlyAmount: 80973, AnnualAmount: 251916,
 		Currency: "RUB", MemberLimit: 0, WeeklyTokenLimit: 9_000_000, ResetAmount: 7490,
-		StandardResponses: 5200, EquivalentTokens: 36_000_000, AIChatEnabled: true,
+		StandardResponses: 5200, EquivalentTokens: 36_000_000, AIChatEnabled: true, Active: true,
 	},
 }
 
+var builtinCatalog = NewCatalog(plans, nil)
+
+// Plans lists the plans open for purchase at their current prices.
 func Plans() []Plan {
-	result := make([]Plan, len(plans))
-	copy(result, plans)
+	catalog, now := currentCatalog(), time.Now()
+	result := make([]Plan, 0, len(catalog.plans))
+	for _, item := range catalog.plans {
+		if item.Active {
+			result = append(result, catalog.priced(item, now))
+		}
+	}
 	return result
 }
 
+// PlanByCode returns a plan at its current price. Retired plans are still
+// found so existing subscribers keep their access and quota.
 func PlanByCode(code string) (Plan, error) {
-	code = strings.ToLower(strings.TrimSpace(code))
-	for _, item := range plans {
-		if item.Code == code {
-			return item, nil
+	catalog := currentCatalog()
+	plan, ok := catalog.plan(code)
+	if !ok {
+		return Plan{}, ErrPlanNotFound
+	}
+	return catalog.priced(plan, time.Now()), nil
+}
+
+// PlanAtVersion returns a plan at the price a subscriber bought it for,
+// falling back to the current price when the version is unknown or belongs
+// to another plan.
+func PlanAtVersion(code string, versionID int64) (Plan, error) {
+	catalog := currentCatalog()
+	plan, ok := catalog.plan(code)
+	if !ok {
+		return Plan{}, ErrPlanNotFound
+	}
+	if versionID > 0 {
+		if priced, ok := catalog.atVersion(plan, versionID); ok {
+			return priced, nil
 		}
 	}
-	return Plan{}, ErrPlanNotFound
+	return catalog.priced(plan, time.Now()), nil
 }
 
 func Price(plan Plan, period string) (float64, error) {
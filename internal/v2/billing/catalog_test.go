@@ -1,6 +1,7 @@
 package billing
 
 import (
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -185,3 +186,108 @@ func TestLegacyMessageQuotaPreservesUsageRatio(t *testing.T) {
 		t.Fatalf("unexpected migrated token usage: %d", got)
 	}
 }
+
+func TestCatalogPriceVersions(t *testing.T) {
+	defer InstallCatalog(nil)
+	now := time.Now().UTC()
+	founder, _ := PlanByCode(PlanFounder)
+	retired := founder
+	retired.Code, retired.Name, retired.Active = "legacy", "Legacy", false
+	InstallCatalog(NewCatalog([]Plan{founder, retired}, []PriceVersion{
+		{ID: 1, PlanCode: PlanFounder, MonthlyAmount: 2990, QuarterlyAmount: 8073, AnnualAmount: 25116, Currency: "RUB", EffectiveFrom: now.AddDate(-1, 0, 0)},
+		{ID: 2, PlanCode: PlanFounder, MonthlyAmount: 3490, QuarterlyAmount: 9423, AnnualAmount: 29316, Currency: "RUB", EffectiveFrom: now.AddDate(0, 0, -1)},
+		{ID: 3, PlanCode: PlanFounder, MonthlyAmount: 3990, QuarterlyAmount: 10773, AnnualAmount: 33516, Currency: "RUB", EffectiveFrom: now.AddDate(0, 1, 0)},
+	}))
+
+	current, err := PlanByCode(PlanFounder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.PriceVersion != 2 || current.MonthlyAmount != 3490 {
+		t.Fatalf("PlanByCode priced %d at %.2f, want version 2 at 3490", current.PriceVersion, current.MonthlyAmount)
+	}
+	tests := []struct {
+		name    string
+		version int64
+		want    int64
+		monthly float64
+	}{
+		{"grandfathered price", 1, 1, 2990},
+		{"future price once bought", 3, 3, 3990},
+		{"unknown version falls back to current", 99, 2, 3490},
+		{"no version is the current price", 0, 2, 3490},
+	}
+	for _, test := range tests {
+		plan, err := PlanAtVersion(PlanFounder, test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.PriceVersion != test.want || plan.MonthlyAmount != test.monthly {
+			t.Fatalf("%s: PlanAtVersion = version %d at %.2f, want %d at %.2f",
+				test.name, plan.PriceVersion, plan.MonthlyAmount, test.want, test.monthly)
+		}
+	}
+
+	if _, err := PlanByCode("legacy"); err != nil {
+		t.Fatalf("retired plan must stay resolvable for its subscribers: %v", err)
+	}
+	for _, plan := range Plans() {
+		if plan.Code == "legacy" {
+			t.Fatal("retired plan must not be offered for purchase")
+		}
+	}
+}
+
+func TestNormalizePlanInput(t *testing.T) {
+	valid := PlanInput{Code: " Pro_2027 ", Name: "Pro", MemberLimit: 3, WeeklyTokenLimit: 1000, AIChatEnabled: true}
+	got, err := normalizePlanInput(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "pro_2027" || got.Currency != "RUB" {
+		t.Fatalf("normalizePlanInput = %+v", got)
+	}
+	tests := []struct {
+		name  string
+		apply func(*PlanInput)
+	}{
+		{"code with spaces", func(input *PlanInput) { input.Code = "pro plan" }},
+		{"missing name", func(input *PlanInput) { input.Name = " " }},
+		{"bad currency", func(input *PlanInput) { input.Currency = "RUBLES" }},
+		{"negative member limit", func(input *PlanInput) { input.MemberLimit = -1 }},
+		{"ai chat without a limit", func(input *PlanInput) { input.WeeklyTokenLimit = 0 }},
+	}
+	for _, test := range tests {
+		input := valid
+		test.apply(&input)
+		if _, err := normalizePlanInput(input); !errors.Is(err, ErrPlanInvalid) {
+			t.Fatalf("%s: error = %v, want %v", test.name, err, ErrPlanInvalid)
+		}
+	}
+}
+
+func TestNormalizePriceInput(t *testing.T) {
+	now := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	future := now.AddDate(0, 1, 0)
+	past := now.AddDate(0, 0, -1)
+	tests := []struct {
+		name  string
+		input PriceInput
+		want  time.Time
+		err   error
+	}{
+		{"defaults to now", PriceInput{PlanCode: " Team ", MonthlyAmount: 1, QuarterlyAmount: 1, AnnualAmount: 1}, now, nil},
+		{"scheduled", PriceInput{PlanCode: "team", MonthlyAmount: 1, QuarterlyAmount: 1, AnnualAmount: 1, EffectiveFrom: &future}, future, nil},
+		{"backdated", PriceInput{PlanCode: "team", MonthlyAmount: 1, QuarterlyAmount: 1, AnnualAmount: 1, EffectiveFrom: &past}, time.Time{}, ErrPriceInvalid},
+		{"free", PriceInput{PlanCode: "team", QuarterlyAmount: 1, AnnualAmount: 1}, time.Time{}, ErrPriceInvalid},
+	}
+	for _, test := range tests {
+		input, effectiveFrom, err := normalizePriceInput(test.input, now)
+		if !errors.Is(err, test.err) || !effectiveFrom.Equal(test.want) {
+			t.Fatalf("%s: normalizePriceInput = %v, %v, want %v, %v", test.name, effectiveFrom, err, test.want, test.err)
+		}
+		if err == nil && input.PlanCode != "team" {
+			t.Fatalf("%s: plan code = %q", test.name, input.PlanCode)
+		}
+	}
+}
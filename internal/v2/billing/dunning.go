@@ -0,0 +1,594 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DunningOpen      = "open"
+	DunningRecovered = "recovered"
+	DunningExpired   = "expired"
+
+	defaultDunningGraceDays = 14
+)
+
+var ErrDunningRetryUnavailable = errors.New("dunning_retry_unavailable")
+
+// DunningPolicy is what happens after a card renewal fails. The workspace
+// keeps access for GraceDays; RetryDays and EmailDays are offsets from the
+// first failure on which the card is charged again and the owner is
+// reminded. Offsets outside the grace period are ignored.
+type DunningPolicy struct {
+	GraceDays int
+	RetryDays []int
+	EmailDays []int
+}
+
+func DefaultDunningPolicy() DunningPolicy {
+	return DunningPolicy{GraceDays: defaultDunningGraceDays, RetryDays: []int{1, 3, 7}, EmailDays: []int{0, 3, 7, 12}}
+}
+
+func (p DunningPolicy) normalized() DunningPolicy {
+	if p.GraceDays <= 0 {
+		p.GraceDays = defaultDunningGraceDays
+	}
+	offsets := func(days []int) []int {
+		result := []int{}
+		for _, day := range days {
+			if day >= 0 && day < p.GraceDays && !slices.Contains(result, day) {
+				result = append(result, day)
+			}
+		}
+		slices.Sort(result)
+		return result
+	}
+	p.RetryDays, p.EmailDays = offsets(p.RetryDays), offsets(p.EmailDays)
+	return p
+}
+
+// nextAt returns the first step of a schedule that falls after `after`, or
+// nil once the schedule is used up. Steps missed while the runner was down
+// collapse into one instead of firing back to back.
+func (p DunningPolicy) nextAt(days []int, startedAt, after time.Time) *time.Time {
+	for _, day := range days {
+		at := startedAt.AddDate(0, 0, day)
+		if at.After(after) {
+			return &at
+		}
+	}
+	return nil
+}
+
+// WithDunningPolicy replaces the default dunning schedule.
+func (s *Service) WithDunningPolicy(policy DunningPolicy) *Service {
+	s.dunning = policy.normalized()
+	return s
+}
+
+type DunningCase struct {
+	ID            int64          `json:"id"`
+	WorkspaceID   int            `json:"workspace_id"`
+	Status        string         `json:"status"`
+	Reason        string         `json:"reason,omitempty"`
+	Amount        float64        `json:"amount"`
+	Currency      string         `json:"currency"`
+	StartedAt     time.Time      `json:"started_at"`
+	GraceUntil    time.Time      `json:"grace_until"`
+	RetryAttempts int            `json:"retry_attempts"`
+	NextRetryAt   *time.Time     `json:"next_retry_at,omitempty"`
+	EmailsSent    int            `json:"emails_sent"`
+	NextEmailAt   *time.Time     `json:"next_email_at,omitempty"`
+	ResolvedAt    *time.Time     `json:"resolved_at,omitempty"`
+	Events        []DunningEvent `json:"events"`
+}
+
+type DunningEvent struct {
+	Kind      string    `json:"kind"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DunningCharge is a card subscription order paid with the saved token
+// without the customer present: a retry of a failed renewal, or a renewal
+// the backend charges itself for providers without recurrent subscriptions.
+type DunningCharge struct {
+	CaseID              int64
+	OrderID             int64
+	WorkspaceID         int
+	UserID              int
+	Email               string
+	Provider            string
+	Token               string
+	CloudSubscriptionID string
+	Amount              float64
+	Currency            string
+	Description         string
+}
+
+// DunningNotice is what the owner is told about an open or expired case.
+type DunningNotice struct {
+	CaseID        int64
+	Status        string
+	WorkspaceName string
+	Email         string
+	Amount        float64
+	Currency      string
+	GraceUntil    time.Time
+	Final         bool
+}
+
+// OpenDunning starts dunning for a subscription whose renewal failed, or
+// records another failure on the case that is already open. The grace
+// period counts from the first failure, so repeated provider notifications
+// do not extend access.
+func (s *Service) OpenDunning(ctx context.Context, subscriptionID int, reason string) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var workspaceID sql.NullInt64
+	var status, currency string
+	var amount float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(subscription.workspace_id, (
+				SELECT workspace.id FROM workspaces workspace
+				WHERE workspace.owner_user_id=subscription.user_id AND workspace.status='active'
+				ORDER BY workspace.created_at LIMIT 1
+			)), subscription.status, subscription.amount, subscription.currency
+		FROM subscriptions subscription
+		WHERE subscription.id=$1
+		FOR UPDATE
+	`, subscriptionID).Scan(&workspaceID, &status, &amount, &currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !workspaceID.Valid || (status != "active" && status != "trial_active" && status != "past_due") {
+		return nil
+	}
+	reason = strings.TrimSpace(reason)
+	now := time.Now().UTC()
+	policy := s.dunningPolicy()
+
+	var caseID int64
+	var graceUntil time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, grace_until FROM billing_dunning_cases
+		WHERE workspace_id=$1 AND status='open'
+		FOR UPDATE
+	`, workspaceID.Int64).Scan(&caseID, &graceUntil)
+	kind := "payment_failed"
+	if errors.Is(err, sql.ErrNoRows) {
+		kind = "opened"
+		graceUntil = now.AddDate(0, 0, policy.GraceDays)
+		before := now.Add(-time.Second)
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO billing_dunning_cases (
+				workspace_id, subscription_id, reason, amount, currency, started_at,
+				grace_until, next_retry_at, next_email_at
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+			RETURNING id
+		`, workspaceID.Int64, subscriptionID, reason, amount, currency, now, graceUntil,
+			policy.nextAt(policy.RetryDays, now, now), policy.nextAt(policy.EmailDays, now, before)).Scan(&caseID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := addDunningEvent(ctx, tx, caseID, int(workspaceID.Int64), kind, reason); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET status='past_due', grace_until=$2, last_failed_at=$3,
+			failed_attempts=GREATEST(failed_attempts, 1), updated_at=NOW()
+		WHERE id=$1
+	`, subscriptionID, graceUntil, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResolveDunning closes the open case of a subscription that was paid
+// outside of a billing order.
+func (s *Service) ResolveDunning(ctx context.Context, subscriptionID int, detail string) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var workspaceID int
+	err = tx.QueryRowContext(ctx, `
+		SELECT workspace_id FROM billing_dunning_cases
+		WHERE subscription_id=$1 AND status='open'
+	`, subscriptionID).Scan(&workspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := resolveDunning(ctx, tx, workspaceID, detail); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resolveDunning closes the workspace's open case after a payment that
+// renews its subscription.
+func resolveDunning(ctx context.Context, tx *sql.Tx, workspaceID int, detail string) error {
+	var caseID int64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE billing_dunning_cases
+		SET status='recovered', resolved_at=NOW(), next_retry_at=NULL, next_email_at=NULL, updated_at=NOW()
+		WHERE workspace_id=$1 AND status='open'
+		RETURNING id
+	`, workspaceID).Scan(&caseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return addDunningEvent(ctx, tx, caseID, workspaceID, "recovered", detail)
+}
+
+func addDunningEvent(ctx context.Context, tx *sql.Tx, caseID int64, workspaceID int, kind, detail string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO billing_dunning_events (case_id, workspace_id, kind, detail)
+		VALUES ($1,$2,$3,$4)
+	`, caseID, workspaceID, kind, detail)
+	return err
+}
+
+// DueDunningCases returns open cases with a retry or reminder due, or whose
+// grace period is over.
+func (s *Service) DueDunningCases(ctx context.Context, limit int) ([]DunningCase, error) {
+	rows, err := s.dbx.QueryContext(ctx, dunningCaseSelect+`
+		WHERE status='open' AND (
+			grace_until <= NOW() OR next_retry_at <= NOW() OR next_email_at <= NOW()
+		)
+		ORDER BY grace_until, id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDunningCases(rows)
+}
+
+// DunningHistory returns the workspace's dunning cases, newest first, with
+// their events.
+func (s *Service) DunningHistory(ctx context.Context, workspaceID, limit int) ([]DunningCase, error) {
+	rows, err := s.dbx.QueryContext(ctx, dunningCaseSelect+`
+		WHERE workspace_id=$1
+		ORDER BY started_at DESC, id DESC
+		LIMIT $2
+	`, workspaceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	cases, err := scanDunningCases(rows)
+	rows.Close()
+	if err != nil || len(cases) == 0 {
+		return cases, err
+	}
+	ids := make([]int64, 0, len(cases))
+	byID := map[int64]int{}
+	for index, item := range cases {
+		ids = append(ids, item.ID)
+		byID[item.ID] = index
+	}
+	events, err := s.dbx.QueryContext(ctx, `
+		SELECT case_id, kind, detail, created_at
+		FROM billing_dunning_events
+		WHERE case_id = ANY($1)
+		ORDER BY created_at, id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer events.Close()
+	for events.Next() {
+		var caseID int64
+		var event DunningEvent
+		if err := events.Scan(&caseID, &event.Kind, &event.Detail, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		index := byID[caseID]
+		cases[index].Events = append(cases[index].Events, event)
+	}
+	return cases, events.Err()
+}
+
+const dunningCaseSelect = `
+		SELECT id, workspace_id, status, reason, amount, currency, started_at, grace_until,
+			retry_attempts, next_retry_at, emails_sent, next_email_at, resolved_at
+		FROM billing_dunning_cases`
+
+func scanDunningCases(rows *sql.Rows) ([]DunningCase, error) {
+	result := []DunningCase{}
+	for rows.Next() {
+		var item DunningCase
+		var nextRetry, nextEmail, resolved sql.NullTime
+		if err := rows.Scan(
+			&item.ID, &item.WorkspaceID, &item.Status, &item.Reason, &item.Amount, &item.Currency,
+			&item.StartedAt, &item.GraceUntil, &item.RetryAttempts, &nextRetry, &item.EmailsSent,
+			&nextEmail, &resolved,
+		); err != nil {
+			return nil, err
+		}
+		item.NextRetryAt, item.NextEmailAt, item.ResolvedAt = nullTime(nextRetry), nullTime(nextEmail), nullTime(resolved)
+		item.Events = []DunningEvent{}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+// PrepareDunningRetry creates the order a retry charge pays. The retry
+// charges what the failed renewal would have: a downgrade scheduled for
+// that renewal takes effect with it.
+func (s *Service) PrepareDunningRetry(ctx context.Context, caseID int64) (DunningCharge, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return DunningCharge{}, err
+	}
+	defer tx.Rollback()
+
+	charge := DunningCharge{CaseID: caseID}
+	var planCode, period string
+	var priceVersion int64
+	var memberLimit int
+	err = tx.QueryRowContext(ctx, `
+		SELECT dunning.workspace_id, workspace.owner_user_id, owner.email,
+			COALESCE(subscription.scheduled_plan_code, subscription.plan_code),
+			COALESCE(subscription.scheduled_price_version_id, subscription.price_version_id, 0),
+			subscription.billing_period,
+			COALESCE(subscription.scheduled_amount, subscription.amount),
+			COALESCE(subscription.scheduled_member_limit, subscription.member_limit),
+			subscription.currency, subscription.payment_provider,
+			COALESCE(subscription.cloudpayments_token, ''),
+			COALESCE(subscription.cloudpayments_subscription_id, '')
+		FROM billing_dunning_cases dunning
+		JOIN subscriptions subscription ON subscription.id=dunning.subscription_id
+		JOIN workspaces workspace ON workspace.id=dunning.workspace_id
+		JOIN users owner ON owner.id=workspace.owner_user_id
+		WHERE dunning.id=$1 AND dunning.status='open'
+		FOR UPDATE OF dunning
+	`, caseID).Scan(
+		&charge.WorkspaceID, &charge.UserID, &charge.Email, &planCode, &priceVersion, &period,
+		&charge.Amount, &memberLimit, &charge.Currency, &charge.Provider, &charge.Token,
+		&charge.CloudSubscriptionID,
+	)
+	if err != nil {
+		return DunningCharge{}, err
+	}
+	if charge.Token == "" || charge.Amount <= 0 {
+		return DunningCharge{}, ErrDunningRetryUnavailable
+	}
+	if err := insertChargeOrder(
+		ctx, tx, &charge, planCode, priceVersion, period, memberLimit,
+		"dunning-"+strconv.FormatInt(caseID, 10)+"-"+randomID(), "dunning_case_id", caseID,
+	); err != nil {
+		return DunningCharge{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return DunningCharge{}, err
+	}
+	return charge, nil
+}
+
+// insertChargeOrder creates the waiting subscription order a token charge
+// pays and fills in its id and description. The order metadata links it to
+// what started the charge.
+func insertChargeOrder(
+	ctx context.Context,
+	tx *sql.Tx,
+	charge *DunningCharge,
+	planCode string,
+	priceVersion int64,
+	period string,
+	memberLimit int,
+	idempotencyKey, sourceKey string,
+	sourceID int64,
+) error {
+	plan, err := PlanAtVersion(planCode, priceVersion)
+	if err != nil {
+		return err
+	}
+	quantity := 1
+	if plan.PerSeatPricing {
+		quantity = max(1, memberLimit)
+	}
+	charge.Description = "REUP.goals · " + plan.Name
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO workspace_billing_orders (
+			workspace_id, created_by, order_kind, plan_code, billing_period,
+			quantity, amount, currency, status, provider, idempotency_key, metadata_json,
+			price_version_id
+		) VALUES (
+			$1,$2,$3,$4,$5,$6,$7,$8,'waiting',$9,$10,
+			jsonb_build_object($11::text, $12::bigint, 'cloudpayments_subscription_id', $13::text),
+			NULLIF($14, 0)
+		)
+		RETURNING id
+	`, charge.WorkspaceID, charge.UserID, OrderSubscription, plan.Code, period, quantity,
+		charge.Amount, charge.Currency, charge.Provider, idempotencyKey,
+		sourceKey, sourceID, charge.CloudSubscriptionID, plan.PriceVersion).Scan(&charge.OrderID)
+}
+
+// RecordDunningRetry notes a retry that did not go through and schedules
+// the next one. The retry's order, if any, is cancelled.
+func (s *Service) RecordDunningRetry(ctx context.Context, caseID, orderID int64, failure error) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var workspaceID int
+	var startedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT workspace_id, started_at FROM billing_dunning_cases
+		WHERE id=$1 AND status='open'
+		FOR UPDATE
+	`, caseID).Scan(&workspaceID, &startedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	policy := s.dunningPolicy()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE billing_dunning_cases
+		SET retry_attempts=retry_attempts + 1, next_retry_at=$2, updated_at=NOW()
+		WHERE id=$1
+	`, caseID, policy.nextAt(policy.RetryDays, startedAt, time.Now().UTC())); err != nil {
+		return err
+	}
+	detail := ""
+	if failure != nil {
+		detail = failure.Error()
+	}
+	if orderID > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE workspace_billing_orders
+			SET status='cancelled', metadata_json=metadata_json || jsonb_build_object('failure', $2::text),
+				updated_at=NOW()
+			WHERE id=$1 AND status='waiting'
+		`, orderID, detail); err != nil {
+			return err
+		}
+	}
+	kind := "retry_failed"
+	if errors.Is(failure, ErrDunningRetryUnavailable) {
+		kind = "retry_skipped"
+	}
+	if err := addDunningEvent(ctx, tx, caseID, workspaceID, kind, detail); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DunningNotice returns who to email about a case and what to tell them.
+func (s *Service) DunningNotice(ctx context.Context, caseID int64) (DunningNotice, error) {
+	notice := DunningNotice{CaseID: caseID}
+	var startedAt time.Time
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT dunning.status, workspace.name, owner.email, dunning.amount, dunning.currency,
+			dunning.grace_until, dunning.started_at
+		FROM billing_dunning_cases dunning
+		JOIN workspaces workspace ON workspace.id=dunning.workspace_id
+		JOIN users owner ON owner.id=workspace.owner_user_id
+		WHERE dunning.id=$1
+	`, caseID).Scan(
+		&notice.Status, &notice.WorkspaceName, &notice.Email, &notice.Amount, &notice.Currency,
+		&notice.GraceUntil, &startedAt,
+	)
+	if err != nil {
+		return DunningNotice{}, err
+	}
+	policy := s.dunningPolicy()
+	notice.Final = notice.Status != DunningOpen || policy.nextAt(policy.EmailDays, startedAt, time.Now().UTC()) == nil
+	return notice, nil
+}
+
+// RecordDunningEmail notes a reminder and schedules the next one. A failed
+// send is recorded too: the sequence moves on rather than retrying the
+// same reminder every run.
+func (s *Service) RecordDunningEmail(ctx context.Context, caseID int64, failure error) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var workspaceID int
+	var status string
+	var startedAt time.Time
+	if err := tx.QueryRowContext(ctx, `
+		SELECT workspace_id, status, started_at FROM billing_dunning_cases
+		WHERE id=$1
+		FOR UPDATE
+	`, caseID).Scan(&workspaceID, &status, &startedAt); err != nil {
+		return err
+	}
+	policy := s.dunningPolicy()
+	var next *time.Time
+	if status == DunningOpen {
+		next = policy.nextAt(policy.EmailDays, startedAt, time.Now().UTC())
+	}
+	sent := 1
+	kind, detail := "email_sent", ""
+	if failure != nil {
+		sent, kind, detail = 0, "email_failed", failure.Error()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE billing_dunning_cases
+		SET emails_sent=emails_sent + $2, next_email_at=$3, updated_at=NOW()
+		WHERE id=$1
+	`, caseID, sent, next); err != nil {
+		return err
+	}
+	if err := addDunningEvent(ctx, tx, caseID, workspaceID, kind, detail); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpireDunning ends a case whose grace period is over. The subscription
+// expires and the workspace loses paid access until it pays again.
+func (s *Service) ExpireDunning(ctx context.Context, caseID int64) (bool, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var workspaceID, subscriptionID int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE billing_dunning_cases
+		SET status='expired', resolved_at=NOW(), next_retry_at=NULL, next_email_at=NULL, updated_at=NOW()
+		WHERE id=$1 AND status='open' AND grace_until <= NOW()
+		RETURNING workspace_id, subscription_id
+	`, caseID).Scan(&workspaceID, &subscriptionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET status='expired', updated_at=NOW()
+		WHERE id=$1 AND status='past_due'
+	`, subscriptionID); err != nil {
+		return false, err
+	}
+	if err := addDunningEvent(ctx, tx, caseID, workspaceID, "expired", ""); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *Service) dunningPolicy() DunningPolicy {
+	if s.dunning.GraceDays <= 0 {
+		return DefaultDunningPolicy()
+	}
+	return s.dunning
+}
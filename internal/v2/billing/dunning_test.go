@@ -0,0 +1,43 @@
+package billing
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDunningPolicyNormalized(t *testing.T) {
+	policy := DunningPolicy{GraceDays: 10, RetryDays: []int{7, 1, 1, 12, -2}, EmailDays: []int{0, 10, 3}}.normalized()
+	if policy.GraceDays != 10 || !slices.Equal(policy.RetryDays, []int{1, 7}) || !slices.Equal(policy.EmailDays, []int{0, 3}) {
+		t.Fatalf("normalized = %+v", policy)
+	}
+	if fallback := (DunningPolicy{}).normalized(); fallback.GraceDays != defaultDunningGraceDays {
+		t.Fatalf("zero grace normalized to %d", fallback.GraceDays)
+	}
+}
+
+func TestDunningPolicyNextAt(t *testing.T) {
+	started := time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)
+	days := []int{0, 3, 7}
+	policy := DefaultDunningPolicy()
+	tests := []struct {
+		name  string
+		after time.Time
+		want  *time.Time
+	}{
+		{name: "first step when the case opens", after: started.Add(-time.Second), want: &started},
+		{name: "next step after the first", after: started, want: ptrTime(started.AddDate(0, 0, 3))},
+		{name: "missed steps collapse", after: started.AddDate(0, 0, 5), want: ptrTime(started.AddDate(0, 0, 7))},
+		{name: "schedule used up", after: started.AddDate(0, 0, 7)},
+	}
+	for _, test := range tests {
+		got := policy.nextAt(days, started, test.after)
+		if (got == nil) != (test.want == nil) || (got != nil && !got.Equal(*test.want)) {
+			t.Fatalf("%s: nextAt = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func ptrTime(value time.Time) *time.Time {
+	return &value
+}
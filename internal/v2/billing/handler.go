@@ -4,31 +4,41 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// statementBodyLimit stays under the limit the security middleware applies
+// to the statement upload route; a year of daily statements fits well within.
+const statementBodyLimit = 25 << 20
+
 type AdminHandler struct {
-	service *Service
-	key     string
+	service     *Service
+	key         string
+	refunder    PaymentRefunder
+	creditNotes CreditNoteIssuer
 }
 
 func NewAdminHandler(service *Service, key string) *AdminHandler {
 	return &AdminHandler{service: service, key: strings.TrimSpace(key)}
 }
 
+func (h *AdminHandler) WithRefunds(refunder PaymentRefunder, creditNotes CreditNoteIssuer) *AdminHandler {
+	h.refunder = refunder
+	h.creditNotes = creditNotes
+	return h
+}
+
 func (h *AdminHandler) ConfirmInvoice(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
 		return
 	}
-	if h.key == "" {
-		writeError(w, http.StatusServiceUnavailable, "manual_billing_confirmation_not_configured")
-		return
-	}
-	provided := strings.TrimSpace(r.Header.Get("X-Billing-Admin-Key"))
-	if subtle.ConstantTimeCompare([]byte(provided), []byte(h.key)) != 1 {
-		writeError(w, http.StatusForbidden, "billing_admin_required")
+	if !h.authorize(w, r) {
 		return
 	}
 	var body struct {
@@ -52,6 +62,365 @@ func (h *AdminHandler) ConfirmInvoice(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+// Refund refunds a payment in full or in part. Once the refund is recorded
+// it stops the provider's recurrent subscription if the refund ended the
+// period, and issues the credit note; a failure there is logged and left to
+// the closing documents runner rather than failing a refund already made.
+func (h *AdminHandler) Refund(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	var body RefundInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil || body.PaymentID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	result, err := h.service.RefundPayment(r.Context(), body, h.refunder)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrRefundPaymentNotFound):
+		writeError(w, http.StatusNotFound, ErrRefundPaymentNotFound.Error())
+		return
+	case errors.Is(err, ErrRefundNotAllowed), errors.Is(err, ErrRefundAmountInvalid),
+		errors.Is(err, ErrRefundMethodInvalid), errors.Is(err, ErrRefundProviderUnavailable):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case errors.Is(err, ErrRefundPending):
+		writeError(w, http.StatusConflict, ErrRefundPending.Error())
+		return
+	case errors.Is(err, ErrRefundProviderFailed):
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": ErrRefundProviderFailed.Error(), "detail": err.Error(),
+		})
+		return
+	default:
+		writeError(w, http.StatusInternalServerError, "billing_refund_failed")
+		return
+	}
+	if result.recurringID != "" && h.refunder != nil {
+		if err := h.refunder.CancelRecurring(r.Context(), result.recurringProvider, result.recurringID); err != nil {
+			log.Printf("[WARN] recurrent subscription %s cancel after refund %d failed: %v", result.recurringID, result.RefundPaymentID, err)
+		}
+	}
+	if h.creditNotes != nil {
+		documentID, err := h.creditNotes.IssueCreditNote(r.Context(), result.RefundPaymentID)
+		if err != nil {
+			log.Printf("[WARN] credit note for refund %d failed: %v", result.RefundPaymentID, err)
+		}
+		result.CreditNoteID = documentID
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// ImportStatement takes the statement file as the raw request body, in the
+// 1C ClientBankExchange format or CSV.
+func (h *AdminHandler) ImportStatement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, statementBodyLimit))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "bank_statement_too_large")
+		return
+	}
+	query := r.URL.Query()
+	result, err := h.service.ImportBankStatement(r.Context(), query.Get("file_name"), query.Get("imported_by"), data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrStatementEmpty):
+			writeError(w, http.StatusBadRequest, ErrStatementEmpty.Error())
+		case errors.Is(err, ErrStatementFormat):
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": ErrStatementFormat.Error(), "detail": err.Error(),
+			})
+		default:
+			writeError(w, http.StatusInternalServerError, "bank_statement_import_failed")
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *AdminHandler) StatementReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	items, err := h.service.StatementReviewQueue(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "bank_statement_review_failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"payments": items})
+}
+
+func (h *AdminHandler) ResolveStatement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	var body struct {
+		PaymentID  int64  `json:"payment_id"`
+		Action     string `json:"action"`
+		InvoiceID  int64  `json:"invoice_id"`
+		ResolvedBy string `json:"resolved_by"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil || body.PaymentID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	err := h.service.ResolveStatementPayment(r.Context(), body.PaymentID, body.Action, body.InvoiceID, body.ResolvedBy)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	case errors.Is(err, ErrStatementPaymentNotFound):
+		writeError(w, http.StatusNotFound, ErrStatementPaymentNotFound.Error())
+	case errors.Is(err, ErrStatementResolutionInvalid), err.Error() == "invoice_not_payable":
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "bank_statement_resolution_failed")
+	}
+}
+
+// Plans lists the catalog with its price history on GET and creates or
+// updates a plan on POST.
+func (h *AdminHandler) Plans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		items, err := h.service.AdminPlans(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "billing_plans_load_failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"plans": items})
+		return
+	}
+	var body PlanInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	plan, err := h.service.SavePlan(r.Context(), body)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// PlanPrices adds a price version on POST and withdraws a future one on
+// DELETE ?id=.
+func (h *AdminHandler) PlanPrices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_price_version_id")
+			return
+		}
+		if err := h.service.DeletePriceVersion(r.Context(), id); err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+		return
+	}
+	var body PriceInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	plan, err := h.service.AddPriceVersion(r.Context(), body)
+	if err != nil {
+		writeCatalogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, plan)
+}
+
+// PromoCodes lists promo codes on GET and creates or updates one on POST.
+func (h *AdminHandler) PromoCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		items, err := h.service.PromoCodes(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "promo_codes_load_failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"promo_codes": items})
+		return
+	}
+	var body PromoInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	item, err := h.service.SavePromoCode(r.Context(), body)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, item)
+	case errors.Is(err, ErrPromoCodeInvalid):
+		writeError(w, http.StatusUnprocessableEntity, ErrPromoCodeInvalid.Error())
+	case errors.Is(err, ErrPartnerNotFound):
+		writeError(w, http.StatusUnprocessableEntity, ErrPartnerNotFound.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "promo_code_save_failed")
+	}
+}
+
+// Partners lists partners on GET and creates or updates one on POST.
+func (h *AdminHandler) Partners(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		items, err := h.service.Partners(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "billing_partners_load_failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"partners": items})
+		return
+	}
+	var body PartnerInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	item, err := h.service.SavePartner(r.Context(), body)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, item)
+	case errors.Is(err, ErrPartnerInvalid):
+		writeError(w, http.StatusUnprocessableEntity, ErrPartnerInvalid.Error())
+	case errors.Is(err, ErrPartnerNotFound):
+		writeError(w, http.StatusNotFound, ErrPartnerNotFound.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "billing_partner_save_failed")
+	}
+}
+
+// PartnerReport sums attributed revenue between ?from= and ?to= (RFC 3339
+// or YYYY-MM-DD, to exclusive). It defaults to the current calendar month.
+func (h *AdminHandler) PartnerReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if !h.authorize(w, r) {
+		return
+	}
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	query := r.URL.Query()
+	for _, bound := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		value := strings.TrimSpace(query.Get(bound.name))
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			parsed, err = time.Parse(time.DateOnly, value)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_report_period")
+			return
+		}
+		*bound.target = parsed
+	}
+	if !to.After(from) {
+		writeError(w, http.StatusBadRequest, "invalid_report_period")
+		return
+	}
+	items, err := h.service.PartnerReport(r.Context(), from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "partner_report_failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"from": from, "to": to, "partners": items})
+}
+
+func writeCatalogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPlanInvalid), errors.Is(err, ErrPriceInvalid):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrPlanNotFound):
+		writeError(w, http.StatusNotFound, ErrPlanNotFound.Error())
+	case errors.Is(err, ErrPriceVersionNotFound):
+		writeError(w, http.StatusNotFound, ErrPriceVersionNotFound.Error())
+	case errors.Is(err, ErrPlanInUse):
+		writeError(w, http.StatusConflict, ErrPlanInUse.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "billing_catalog_update_failed")
+	}
+}
+
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.key == "" {
+		writeError(w, http.StatusServiceUnavailable, "manual_billing_confirmation_not_configured")
+		return false
+	}
+	provided := strings.TrimSpace(r.Header.Get("X-Billing-Admin-Key"))
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(h.key)) != 1 {
+		writeError(w, http.StatusForbidden, "billing_admin_required")
+		return false
+	}
+	return true
+}
+
 func writeError(w http.ResponseWriter, status int, code string) {
 	writeJSON(w, status, map[string]string{"error": code})
 }
@@ -12,29 +12,65 @@ import (
 
 type CloudPaymentConfirmation struct {
 	SubscriptionIDToCancel string
+	// PeriodEnd is set when the payment started a new subscription period.
+	PeriodEnd *time.Time
 }
 
+// recurringAmountSQL is what a card subscription created by an order charges
+// on renewal: a scheduled downgrade's price, the renewal price of a plan
+// change, or the order amount itself.
+const recurringAmountSQL = `COALESCE((
+			SELECT subscription.scheduled_amount FROM subscriptions subscription
+			WHERE subscription.cloudpayments_subscription_id=billing_order.metadata_json->>'cloudpayments_subscription_id'
+				AND subscription.scheduled_amount IS NOT NULL
+			LIMIT 1
+		), (billing_order.metadata_json->>'renewal_amount')::numeric, billing_order.amount)`
+
 func (s *Service) ValidateCloudPaymentOrder(ctx context.Context, orderID int64, userID int, amount float64, currency string) (bool, error) {
+	return s.ValidateCardPaymentOrder(ctx, ProviderCloudPayments, orderID, userID, amount, currency)
+}
+
+// ValidateCardPaymentOrder reports whether a card provider may take the
+// given amount for the order: the order must belong to that provider and
+// still be payable by the user. An order whose promo code reservation was
+// released has to be started again.
+func (s *Service) ValidateCardPaymentOrder(ctx context.Context, provider string, orderID int64, userID int, amount float64, currency string) (bool, error) {
 	var createdBy int
 	var kind, status, expectedCurrency string
-	var expectedAmount float64
+	var expectedAmount, recurringAmount float64
+	var orderOpen bool
 	err := s.dbx.QueryRowContext(ctx, `
 		SELECT COALESCE(billing_order.created_by, workspace.owner_user_id),
 			billing_order.order_kind, billing_order.status,
-			billing_order.amount, billing_order.currency
+			billing_order.amount, `+recurringAmountSQL+`, billing_order.currency,
+			(billing_order.order_kind <> 'plan_change'
+				OR COALESCE((billing_order.metadata_json->>'period_end')::timestamptz > NOW(), FALSE))
+				AND billing_order.promo_released_at IS NULL
 		FROM workspace_billing_orders billing_order
 		JOIN workspaces workspace ON workspace.id=billing_order.workspace_id
-		WHERE billing_order.id=$1 AND billing_order.provider='cloudpayments'
-	`, orderID).Scan(&createdBy, &kind, &status, &expectedAmount, &expectedCurrency)
+		WHERE billing_order.id=$1 AND billing_order.provider=$2
+	`, orderID, provider).Scan(&createdBy, &kind, &status, &expectedAmount, &recurringAmount, &expectedCurrency, &orderOpen)
 	if err != nil {
 		return false, err
 	}
-	payable := status == "waiting" || (status == "paid" && kind == OrderSubscription)
+	recurring := kind == OrderSubscription || kind == OrderPlanChange
+	payable := (status == "waiting" && orderOpen) || (status == "paid" && recurring)
+	if status == "paid" {
+		expectedAmount = recurringAmount
+	}
 	return createdBy == userID && payable &&
 		strings.EqualFold(expectedCurrency, currency) && math.Abs(expectedAmount-amount) <= 0.009, nil
 }
 
 func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, userID int, transactionID, cloudSubscriptionID, token string, amount float64, currency string) (CloudPaymentConfirmation, error) {
+	return s.ConfirmCardPaymentOrder(ctx, ProviderCloudPayments, orderID, userID, transactionID, cloudSubscriptionID, token, amount, currency)
+}
+
+// ConfirmCardPaymentOrder applies a successful card payment to its order.
+// The subscription columns named after CloudPayments keep the recurrent
+// subscription and saved card of whichever provider payment_provider names;
+// providers without their own recurrent subscriptions leave the former empty.
+func (s *Service) ConfirmCardPaymentOrder(ctx context.Context, provider string, orderID int64, userID int, transactionID, cloudSubscriptionID, token string, amount float64, currency string) (CloudPaymentConfirmation, error) {
 	if orderID <= 0 || userID <= 0 || strings.TrimSpace(transactionID) == "" {
 		return CloudPaymentConfirmation{}, errors.New("invalid_cloudpayments_order")
 	}
@@ -46,38 +82,46 @@ func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, u
 
 	var workspaceID, ownerUserID, createdBy, quantity int
 	var kind, planCode, period, status, expectedCurrency, externalID, replacedSubscriptionID, replacementStatus, storedSubscriptionID string
-	var expectedAmount float64
+	var expectedAmount, recurringAmount float64
+	var changePeriodEnd sql.NullTime
+	var priceVersion int64
 	err = tx.QueryRowContext(ctx, `
 		SELECT billing_order.workspace_id, workspace.owner_user_id,
 			COALESCE(billing_order.created_by, workspace.owner_user_id), billing_order.quantity,
 			billing_order.order_kind, billing_order.plan_code, billing_order.billing_period,
-			billing_order.status, billing_order.amount, billing_order.currency,
+			billing_order.status, billing_order.amount, `+recurringAmountSQL+`,
+			(billing_order.metadata_json->>'period_end')::timestamptz, billing_order.currency,
 			COALESCE(billing_order.external_id,''),
 			COALESCE(billing_order.metadata_json->>'replace_cloudpayments_subscription_id',''),
 			COALESCE(billing_order.metadata_json->>'replacement_status',''),
-			COALESCE(billing_order.metadata_json->>'cloudpayments_subscription_id','')
+			COALESCE(billing_order.metadata_json->>'cloudpayments_subscription_id',''),
+			COALESCE(billing_order.price_version_id, 0)
 		FROM workspace_billing_orders billing_order
 		JOIN workspaces workspace ON workspace.id=billing_order.workspace_id
-		WHERE billing_order.id=$1 AND billing_order.provider='cloudpayments'
+		WHERE billing_order.id=$1 AND billing_order.provider=$2
 		FOR UPDATE OF billing_order
-	`, orderID).Scan(
+	`, orderID, provider).Scan(
 		&workspaceID, &ownerUserID, &createdBy, &quantity, &kind, &planCode, &period,
-		&status, &expectedAmount, &expectedCurrency, &externalID, &replacedSubscriptionID, &replacementStatus,
-		&storedSubscriptionID,
+		&status, &expectedAmount, &recurringAmount, &changePeriodEnd, &expectedCurrency, &externalID,
+		&replacedSubscriptionID, &replacementStatus,
+		&storedSubscriptionID, &priceVersion,
 	)
 	if err != nil {
 		return CloudPaymentConfirmation{}, err
 	}
+	if status == "paid" {
+		expectedAmount = recurringAmount
+	}
 	if createdBy != userID || !strings.EqualFold(expectedCurrency, currency) || math.Abs(expectedAmount-amount) > 0.009 {
 		return CloudPaymentConfirmation{}, errors.New("cloudpayments_order_mismatch")
 	}
 	if status == "paid" {
 		if externalID != transactionID {
-			if kind != OrderSubscription || storedSubscriptionID == "" || cloudSubscriptionID == "" || storedSubscriptionID != cloudSubscriptionID {
+			if (kind != OrderSubscription && kind != OrderPlanChange) || storedSubscriptionID == "" || cloudSubscriptionID == "" || storedSubscriptionID != cloudSubscriptionID {
 				return CloudPaymentConfirmation{}, errors.New("cloudpayments_paid_order_mismatch")
 			}
 			if err := confirmRecurringCloudPayment(
-				ctx, tx, workspaceID, ownerUserID, planCode, period,
+				ctx, tx, workspaceID, ownerUserID, planCode, priceVersion, period,
 				transactionID, amount, expectedCurrency, cloudSubscriptionID, token, quantity,
 			); err != nil {
 				return CloudPaymentConfirmation{}, err
@@ -91,12 +135,13 @@ func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, u
 	if status != "waiting" {
 		return CloudPaymentConfirmation{}, errors.New("cloudpayments_order_not_payable")
 	}
-	plan, err := PlanByCode(planCode)
+	plan, err := PlanAtVersion(planCode, priceVersion)
 	if err != nil {
 		return CloudPaymentConfirmation{}, err
 	}
 	now := time.Now().UTC()
 	memberLimit := SubscriptionMemberLimit(plan, quantity)
+	var periodEnd *time.Time
 	if _, err := tx.ExecContext(ctx, `
 		UPDATE workspace_billing_orders
 		SET status='paid', external_id=$2, paid_at=$3,
@@ -108,10 +153,11 @@ func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, u
 	}
 	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO workspace_billing_payments (
-			workspace_id, provider, external_id, method, amount, currency, status, paid_at
-		) VALUES ($1,'cloudpayments',$2,'card',$3,$4,'paid',$5)
+			workspace_id, provider, external_id, method, amount, currency, status, paid_at,
+			order_kind, plan_code, billing_period
+		) VALUES ($1,$9,$2,'card',$3,$4,'paid',$5,$6,$7,$8)
 		ON CONFLICT (provider, external_id) WHERE external_id <> '' DO NOTHING
-	`, workspaceID, transactionID, amount, expectedCurrency, now); err != nil {
+	`, workspaceID, transactionID, amount, expectedCurrency, now, kind, planCode, period, provider); err != nil {
 		return CloudPaymentConfirmation{}, err
 	}
 
@@ -149,12 +195,20 @@ func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, u
 			_, err = tx.ExecContext(ctx, `
 				UPDATE subscriptions SET pending_plan_code=$2, pending_plan_name=$3,
 					pending_billing_period=$4, pending_amount=$5, pending_member_limit=$6,
-					pending_period_start=$7, pending_period_end=$8,
-					cloudpayments_subscription_id=COALESCE(NULLIF($9,''),cloudpayments_subscription_id),
-					cloudpayments_token=COALESCE(NULLIF($10,''),cloudpayments_token), updated_at=NOW()
+					pending_period_start=$7, pending_period_end=$8, pending_price_version_id=NULLIF($12, 0),
+					cloudpayments_subscription_id=CASE WHEN payment_provider=$13
+						THEN COALESCE(NULLIF($9,''),cloudpayments_subscription_id) ELSE NULLIF($9,'') END,
+					cloudpayments_token=CASE WHEN payment_provider=$13
+						THEN COALESCE(NULLIF($10,''),cloudpayments_token) ELSE NULLIF($10,'') END,
+					payment_method='card', payment_provider=$13,
+					scheduled_plan_code=NULL, scheduled_amount=NULL, scheduled_member_limit=NULL,
+					scheduled_price_version_id=NULL, updated_at=NOW()
 				WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$11)
-			`, workspaceID, plan.Code, plan.Name, period, amount, memberLimit,
-				currentEnd.Time, pendingEnd, cloudSubscriptionID, token, ownerUserID)
+			`, workspaceID, plan.Code, plan.Name, period, recurringAmount, memberLimit,
+				currentEnd.Time, pendingEnd, cloudSubscriptionID, token, ownerUserID, plan.PriceVersion, provider)
+			if err == nil {
+				err = setPaymentServicePeriod(ctx, tx, provider, transactionID, currentEnd.Time, pendingEnd)
+			}
 		} else {
 			start := now
 			end := start.AddDate(0, months, 0)
@@ -163,42 +217,98 @@ func (s *Service) ConfirmCloudPaymentOrder(ctx context.Context, orderID int64, u
 					user_id, workspace_id, status, plan_name, plan_code, billing_period,
 					amount, currency, member_limit, current_period_start, current_period_end,
 					next_payment_at, last_payment_at, quota_anchor_at, payment_method, payment_provider,
-					cloudpayments_subscription_id, cloudpayments_token
-				) VALUES ($1,$2,'active',$3,$4,$5,$6,$7,$8,$9,$10,$10,$11,$9,'card','cloudpayments',NULLIF($12,''),NULLIF($13,''))
+					cloudpayments_subscription_id, cloudpayments_token, price_version_id
+				) VALUES ($1,$2,'active',$3,$4,$5,$6,$7,$8,$9,$10,$10,$11,$9,'card',$15,NULLIF($12,''),NULLIF($13,''),NULLIF($14, 0))
 				ON CONFLICT (user_id) DO UPDATE SET workspace_id=EXCLUDED.workspace_id,
 					status='active', plan_name=EXCLUDED.plan_name, plan_code=EXCLUDED.plan_code,
+					price_version_id=EXCLUDED.price_version_id,
 					billing_period=EXCLUDED.billing_period, amount=EXCLUDED.amount, currency=EXCLUDED.currency,
 					member_limit=EXCLUDED.member_limit, current_period_start=EXCLUDED.current_period_start,
 					current_period_end=EXCLUDED.current_period_end, next_payment_at=EXCLUDED.next_payment_at,
 					last_payment_at=EXCLUDED.last_payment_at, quota_anchor_at=EXCLUDED.quota_anchor_at,
-					payment_method='card', payment_provider='cloudpayments',
-					cloudpayments_subscription_id=COALESCE(EXCLUDED.cloudpayments_subscription_id,subscriptions.cloudpayments_subscription_id),
-					cloudpayments_token=COALESCE(EXCLUDED.cloudpayments_token,subscriptions.cloudpayments_token), updated_at=NOW()
-			`, ownerUserID, workspaceID, plan.Name, plan.Code, period, amount, expectedCurrency,
-				memberLimit, start, end, now, cloudSubscriptionID, token)
+					payment_method='card', payment_provider=EXCLUDED.payment_provider,
+					cloudpayments_subscription_id=CASE WHEN subscriptions.payment_provider=EXCLUDED.payment_provider
+						THEN COALESCE(EXCLUDED.cloudpayments_subscription_id,subscriptions.cloudpayments_subscription_id)
+						ELSE EXCLUDED.cloudpayments_subscription_id END,
+					cloudpayments_token=CASE WHEN subscriptions.payment_provider=EXCLUDED.payment_provider
+						THEN COALESCE(EXCLUDED.cloudpayments_token,subscriptions.cloudpayments_token)
+						ELSE EXCLUDED.cloudpayments_token END,
+					grace_until=NULL, last_failed_at=NULL, failed_attempts=0,
+					scheduled_plan_code=NULL, scheduled_amount=NULL, scheduled_member_limit=NULL,
+					scheduled_price_version_id=NULL, updated_at=NOW()
+			`, ownerUserID, workspaceID, plan.Name, plan.Code, period, recurringAmount, expectedCurrency,
+				memberLimit, start, end, now, cloudSubscriptionID, token, plan.PriceVersion, provider)
+			if err == nil {
+				err = setPaymentServicePeriod(ctx, tx, provider, transactionID, start, end)
+			}
+			periodEnd = &end
 		}
 		if err != nil {
 			return CloudPaymentConfirmation{}, err
 		}
+	case OrderPlanChange:
+		// The first charge covers the rest of the current period; the card
+		// subscription it creates renews at the new price from the period end.
+		if !changePeriodEnd.Valid {
+			return CloudPaymentConfirmation{}, errors.New("plan_change_period_missing")
+		}
+		if err := applyPlanChange(
+			ctx, tx, workspaceID, ownerUserID, plan.Code, plan.PriceVersion, quantity, recurringAmount,
+			changePeriodEnd.Time,
+		); err != nil {
+			return CloudPaymentConfirmation{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE subscriptions SET payment_method='card', payment_provider=$6,
+				last_payment_at=$3,
+				cloudpayments_subscription_id=CASE WHEN payment_provider=$6
+					THEN COALESCE(NULLIF($4,''),cloudpayments_subscription_id) ELSE NULLIF($4,'') END,
+				cloudpayments_token=CASE WHEN payment_provider=$6
+					THEN COALESCE(NULLIF($5,''),cloudpayments_token) ELSE NULLIF($5,'') END,
+				updated_at=NOW()
+			WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$2)
+		`, workspaceID, ownerUserID, now, cloudSubscriptionID, token, provider); err != nil {
+			return CloudPaymentConfirmation{}, err
+		}
+		if err := setPaymentServicePeriod(ctx, tx, provider, transactionID, now, changePeriodEnd.Time); err != nil {
+			return CloudPaymentConfirmation{}, err
+		}
 	default:
 		return CloudPaymentConfirmation{}, fmt.Errorf("unsupported billing order kind %q", kind)
 	}
+	if kind != OrderQuotaReset {
+		if err := resolveDunning(ctx, tx, workspaceID, "card_payment"); err != nil {
+			return CloudPaymentConfirmation{}, err
+		}
+	}
+	if err := redeemPromoCode(ctx, tx, orderID); err != nil {
+		return CloudPaymentConfirmation{}, err
+	}
 	if err := tx.Commit(); err != nil {
 		return CloudPaymentConfirmation{}, err
 	}
-	return replacementConfirmation(replacedSubscriptionID, cloudSubscriptionID, replacementStatus), nil
+	confirmation := replacementConfirmation(replacedSubscriptionID, cloudSubscriptionID, replacementStatus)
+	if provider != ProviderCloudPayments && replacementStatus == "pending" && replacedSubscriptionID != "" {
+		// Paying through another provider leaves the CloudPayments
+		// subscription with nothing to renew.
+		confirmation.SubscriptionIDToCancel = replacedSubscriptionID
+	}
+	confirmation.PeriodEnd = periodEnd
+	return confirmation, nil
 }
 
 func confirmRecurringCloudPayment(
 	ctx context.Context,
 	tx *sql.Tx,
 	workspaceID, ownerUserID int,
-	planCode, period, transactionID string,
+	planCode string,
+	priceVersion int64,
+	period, transactionID string,
 	amount float64,
 	currency, cloudSubscriptionID, token string,
 	quantity int,
 ) error {
-	plan, err := PlanByCode(planCode)
+	plan, err := PlanAtVersion(planCode, priceVersion)
 	if err != nil {
 		return err
 	}
@@ -206,10 +316,11 @@ func confirmRecurringCloudPayment(
 	memberLimit := SubscriptionMemberLimit(plan, quantity)
 	result, err := tx.ExecContext(ctx, `
 		INSERT INTO workspace_billing_payments (
-			workspace_id, provider, external_id, method, amount, currency, status, paid_at
-		) VALUES ($1,'cloudpayments',$2,'card',$3,$4,'paid',$5)
+			workspace_id, provider, external_id, method, amount, currency, status, paid_at,
+			order_kind, plan_code, billing_period
+		) VALUES ($1,'cloudpayments',$2,'card',$3,$4,'paid',$5,$6,$7,$8)
 		ON CONFLICT (provider, external_id) WHERE external_id <> '' DO NOTHING
-	`, workspaceID, transactionID, amount, currency, now)
+	`, workspaceID, transactionID, amount, currency, now, OrderSubscription, planCode, period)
 	if err != nil {
 		return err
 	}
@@ -229,7 +340,8 @@ func confirmRecurringCloudPayment(
 			billing_period=pending_billing_period, amount=pending_amount,
 			member_limit=pending_member_limit, current_period_start=pending_period_start,
 			current_period_end=pending_period_end, next_payment_at=pending_period_end,
-			quota_anchor_at=pending_period_start, pending_plan_code=NULL,
+			quota_anchor_at=pending_period_start, price_version_id=pending_price_version_id,
+			pending_plan_code=NULL, pending_price_version_id=NULL,
 			pending_plan_name='', pending_billing_period='', pending_amount=NULL,
 			pending_member_limit=NULL, pending_period_start=NULL, pending_period_end=NULL,
 			updated_at=NOW()
@@ -241,15 +353,34 @@ func confirmRecurringCloudPayment(
 
 	var subscriptionID int
 	var currentEnd sql.NullTime
+	var scheduledPlan sql.NullString
+	var scheduledMemberLimit, scheduledPriceVersion sql.NullInt64
 	if err := tx.QueryRowContext(ctx, `
-		SELECT id, current_period_end
+		SELECT id, current_period_end, scheduled_plan_code, scheduled_member_limit,
+			scheduled_price_version_id
 		FROM subscriptions
 		WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$2)
 		ORDER BY CASE WHEN workspace_id=$1 THEN 0 ELSE 1 END, updated_at DESC
 		LIMIT 1 FOR UPDATE
-	`, workspaceID, ownerUserID).Scan(&subscriptionID, &currentEnd); err != nil {
+	`, workspaceID, ownerUserID).Scan(
+		&subscriptionID, &currentEnd, &scheduledPlan, &scheduledMemberLimit, &scheduledPriceVersion,
+	); err != nil {
 		return err
 	}
+	// A downgrade scheduled for the period end starts with the renewal that
+	// pays for it.
+	if scheduledPlan.Valid {
+		if plan, err = PlanAtVersion(scheduledPlan.String, scheduledPriceVersion.Int64); err != nil {
+			return err
+		}
+		memberLimit = int(scheduledMemberLimit.Int64)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE workspace_billing_payments SET plan_code=$2
+			WHERE provider='cloudpayments' AND external_id=$1
+		`, transactionID, plan.Code); err != nil {
+			return err
+		}
+	}
 	start := now
 	if currentEnd.Valid && currentEnd.Time.After(start) {
 		start = currentEnd.Time
@@ -261,13 +392,14 @@ func confirmRecurringCloudPayment(
 			current_period_start=$8, current_period_end=$9, next_payment_at=$9,
 			last_payment_at=$10, grace_until=NULL, cancelled_at=NULL,
 			last_failed_at=NULL, failed_attempts=0, payment_method='card',
-			payment_provider='cloudpayments',
-			cloudpayments_subscription_id=$11,
+			payment_provider='cloudpayments', price_version_id=COALESCE(NULLIF($13, 0), price_version_id),
+			scheduled_plan_code=NULL, scheduled_amount=NULL, scheduled_member_limit=NULL,
+			scheduled_price_version_id=NULL, cloudpayments_subscription_id=$11,
 			cloudpayments_token=COALESCE(NULLIF($12,''),cloudpayments_token),
 			updated_at=NOW()
 		WHERE id=$1
 	`, subscriptionID, plan.Code, plan.Name, period, amount, currency,
-		memberLimit, start, end, now, cloudSubscriptionID, token)
+		memberLimit, start, end, now, cloudSubscriptionID, token, plan.PriceVersion)
 	if err != nil {
 		return err
 	}
@@ -278,7 +410,20 @@ func confirmRecurringCloudPayment(
 	if updated == 0 {
 		return errors.New("cloudpayments_subscription_not_found")
 	}
-	return nil
+	if err := resolveDunning(ctx, tx, workspaceID, "recurring_payment"); err != nil {
+		return err
+	}
+	return setPaymentServicePeriod(ctx, tx, ProviderCloudPayments, transactionID, start, end)
+}
+
+// setPaymentServicePeriod records which subscription period a card payment
+// paid for; closing documents are issued once that period ends.
+func setPaymentServicePeriod(ctx context.Context, tx *sql.Tx, provider, transactionID string, start, end time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE workspace_billing_payments SET period_start=$3, period_end=$4, updated_at=NOW()
+		WHERE provider=$1 AND external_id=$2
+	`, provider, transactionID, start, end)
+	return err
 }
 
 func replacementConfirmation(previousID, currentID, status string) CloudPaymentConfirmation {
@@ -324,7 +469,8 @@ func (s *Service) ApplyPendingSubscription(ctx context.Context, workspaceID int)
 			billing_period=pending_billing_period, amount=pending_amount,
 			member_limit=pending_member_limit, current_period_start=pending_period_start,
 			current_period_end=pending_period_end, next_payment_at=pending_period_end,
-			quota_anchor_at=pending_period_start, pending_plan_code=NULL,
+			quota_anchor_at=pending_period_start, price_version_id=pending_price_version_id,
+			pending_plan_code=NULL, pending_price_version_id=NULL,
 			pending_plan_name='', pending_billing_period='', pending_amount=NULL,
 			pending_member_limit=NULL, pending_period_start=NULL, pending_period_end=NULL,
 			updated_at=NOW()
@@ -383,18 +529,21 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 	var workspaceID, ownerUserID int
 	var orderID sql.NullInt64
 	var orderKind, planCode, billingPeriod, status, currency string
-	var amount float64
+	var amount, discountAmount float64
+	var priceVersion int64
 	err = tx.QueryRowContext(ctx, `
 		SELECT invoice.workspace_id, workspace.owner_user_id, invoice.order_id,
 			invoice.order_kind, invoice.plan_code, invoice.billing_period,
-			invoice.status, invoice.amount, invoice.currency
+			invoice.status, invoice.amount, invoice.currency,
+			COALESCE(billing_order.price_version_id, 0), COALESCE(billing_order.discount_amount, 0)
 		FROM workspace_billing_invoices invoice
 		JOIN workspaces workspace ON workspace.id=invoice.workspace_id
+		LEFT JOIN workspace_billing_orders billing_order ON billing_order.id=invoice.order_id
 		WHERE invoice.id=$1
 		FOR UPDATE OF invoice
 	`, invoiceID).Scan(
 		&workspaceID, &ownerUserID, &orderID, &orderKind, &planCode,
-		&billingPeriod, &status, &amount, &currency,
+		&billingPeriod, &status, &amount, &currency, &priceVersion, &discountAmount,
 	)
 	if err != nil {
 		return err
@@ -405,7 +554,7 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 	if status != "waiting" {
 		return errors.New("invoice_not_payable")
 	}
-	plan, err := PlanByCode(planCode)
+	plan, err := PlanAtVersion(planCode, priceVersion)
 	if err != nil {
 		return err
 	}
@@ -425,24 +574,53 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 		`, orderID.Int64, now); err != nil {
 			return err
 		}
+		if err := redeemPromoCode(ctx, tx, orderID.Int64); err != nil {
+			return err
+		}
+	}
+	// A promo code discounts the period this invoice pays for; the
+	// subscription renews at the list price.
+	renewalAmount := roundMoney(amount + discountAmount)
+	periodEnd := now.AddDate(0, billingPeriodMonths(billingPeriod), 0)
+	var servicePeriodStart, servicePeriodEnd *time.Time
+	var changeQuantity int
+	var changeRenewalAmount float64
+	switch orderKind {
+	case OrderSubscription:
+		servicePeriodStart, servicePeriodEnd = &now, &periodEnd
+	case OrderPlanChange:
+		// A prorated invoice pays for the new plan until the current period ends.
+		if !orderID.Valid {
+			return errors.New("plan_change_order_missing")
+		}
+		if err := tx.QueryRowContext(ctx, `
+			SELECT quantity, (metadata_json->>'period_end')::timestamptz,
+				(metadata_json->>'renewal_amount')::numeric
+			FROM workspace_billing_orders WHERE id=$1
+		`, orderID.Int64).Scan(&changeQuantity, &periodEnd, &changeRenewalAmount); err != nil {
+			return err
+		}
+		// The invoice fell due at the period end. Money that arrives later
+		// is left to statement review and refunded from there rather than
+		// applied to a period it was not priced for.
+		if !periodEnd.After(now) {
+			return errors.New("invoice_not_payable")
+		}
+		servicePeriodStart, servicePeriodEnd = &now, &periodEnd
 	}
 	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO workspace_billing_payments (
 			workspace_id, invoice_id, provider, external_id, method,
-			amount, currency, status, paid_at
-		) VALUES ($1,$2,'manual',$3,'invoice',$4,$5,'paid',$6)
-	`, workspaceID, invoiceID, confirmedBy, amount, currency, now); err != nil {
+			amount, currency, status, paid_at, order_kind, plan_code,
+			billing_period, period_start, period_end
+		) VALUES ($1,$2,'manual',$3,'invoice',$4,$5,'paid',$6,$7,$8,$9,$10,$11)
+	`, workspaceID, invoiceID, confirmedBy, amount, currency, now, orderKind, planCode,
+		billingPeriod, servicePeriodStart, servicePeriodEnd); err != nil {
 		return err
 	}
 
 	switch orderKind {
 	case OrderSubscription:
-		periodEnd := now.AddDate(0, 1, 0)
-		if billingPeriod == PeriodQuarterly {
-			periodEnd = now.AddDate(0, 3, 0)
-		} else if billingPeriod == PeriodAnnual {
-			periodEnd = now.AddDate(1, 0, 0)
-		}
 		result, err := tx.ExecContext(ctx, `
 			UPDATE subscriptions SET
 				workspace_id=$1, status='active', plan_name=$2, plan_code=$3,
@@ -450,10 +628,12 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 				current_period_end=$8, next_payment_at=$8, grace_until=NULL,
 				cancelled_at=NULL, last_payment_at=$7, failed_attempts=0,
 				member_limit=$9, quota_anchor_at=$7, payment_method='invoice',
-				payment_provider='manual', updated_at=NOW()
+				payment_provider='manual', price_version_id=NULLIF($11, 0), scheduled_plan_code=NULL,
+				scheduled_amount=NULL, scheduled_member_limit=NULL, scheduled_price_version_id=NULL,
+				updated_at=NOW()
 				WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$10)
-		`, workspaceID, plan.Name, plan.Code, billingPeriod, amount, currency, now,
-			periodEnd, plan.MemberLimit, ownerUserID)
+		`, workspaceID, plan.Name, plan.Code, billingPeriod, renewalAmount, currency, now,
+			periodEnd, plan.MemberLimit, ownerUserID, plan.PriceVersion)
 		if err != nil {
 			return err
 		}
@@ -467,12 +647,12 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 					user_id, workspace_id, status, plan_name, plan_code, billing_period,
 					amount, currency, current_period_start, current_period_end,
 					next_payment_at, last_payment_at, member_limit, quota_anchor_at,
-					payment_method, payment_provider
+					payment_method, payment_provider, price_version_id
 				) VALUES (
-					$1,$2,'active',$3,$4,$5,$6,$7,$8,$9,$9,$8,$10,$8,'invoice','manual'
+					$1,$2,'active',$3,$4,$5,$6,$7,$8,$9,$9,$8,$10,$8,'invoice','manual',NULLIF($11, 0)
 				)
 			`, ownerUserID, workspaceID, plan.Name, plan.Code, billingPeriod,
-				amount, currency, now, periodEnd, plan.MemberLimit); err != nil {
+				renewalAmount, currency, now, periodEnd, plan.MemberLimit, plan.PriceVersion); err != nil {
 				return err
 			}
 		}
@@ -492,6 +672,19 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 		`, workspaceID, plan.Code, now, now.Add(7*24*time.Hour), plan.WeeklyTokenLimit); err != nil {
 			return err
 		}
+	case OrderPlanChange:
+		if err := applyPlanChange(
+			ctx, tx, workspaceID, ownerUserID, plan.Code, plan.PriceVersion, changeQuantity,
+			changeRenewalAmount, periodEnd,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE subscriptions SET last_payment_at=$3, updated_at=NOW()
+			WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$2)
+		`, workspaceID, ownerUserID, now); err != nil {
+			return err
+		}
 	case OrderQuotaReset:
 		if _, err := tx.ExecContext(ctx, `
 			INSERT INTO workspace_ai_quotas (
@@ -514,6 +707,11 @@ func (s *Service) ConfirmInvoicePayment(ctx context.Context, invoiceID int64, co
 	default:
 		return errors.New("billing_order_kind_invalid")
 	}
+	if orderKind != OrderQuotaReset {
+		if err := resolveDunning(ctx, tx, workspaceID, "invoice_payment"); err != nil {
+			return err
+		}
+	}
 
 	_, _ = tx.ExecContext(ctx, `
 		UPDATE v2_system_warnings
@@ -0,0 +1,402 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const catalogRefreshInterval = time.Minute
+
+var ErrPlanInvalid = errors.New("billing_plan_invalid")
+var ErrPriceInvalid = errors.New("billing_price_invalid")
+var ErrPlanInUse = errors.New("billing_plan_in_use")
+var ErrPriceVersionNotFound = errors.New("billing_price_version_not_found")
+
+var planCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+// PlanInput is what the admin API accepts for a plan. Price is required
+// when the plan is created and ignored afterwards: later prices are added
+// as versions.
+type PlanInput struct {
+	Code              string      `json:"code"`
+	Name              string      `json:"name"`
+	Currency          string      `json:"currency"`
+	MemberLimit       int         `json:"member_limit"`
+	WeeklyTokenLimit  int         `json:"weekly_token_limit"`
+	ResetAmount       float64     `json:"reset_amount"`
+	StandardResponses int         `json:"standard_responses_month"`
+	EquivalentTokens  int         `json:"equivalent_tokens_month"`
+	AIChatEnabled     bool        `json:"ai_chat_enabled"`
+	PerSeatPricing    bool        `json:"per_seat_pricing"`
+	Active            bool        `json:"active"`
+	SortOrder         int         `json:"sort_order"`
+	Price             *PriceInput `json:"price,omitempty"`
+}
+
+type PriceInput struct {
+	PlanCode        string     `json:"plan_code"`
+	MonthlyAmount   float64    `json:"monthly_amount"`
+	QuarterlyAmount float64    `json:"quarterly_amount"`
+	AnnualAmount    float64    `json:"annual_amount"`
+	EffectiveFrom   *time.Time `json:"effective_from,omitempty"`
+	CreatedBy       string     `json:"created_by"`
+}
+
+// AdminPlan is a plan with its whole price history.
+type AdminPlan struct {
+	Plan
+	SortOrder     int            `json:"sort_order"`
+	Subscriptions int            `json:"subscriptions"`
+	Prices        []PriceVersion `json:"prices"`
+}
+
+func normalizePlanInput(input PlanInput) (PlanInput, error) {
+	input.Code = strings.ToLower(strings.TrimSpace(input.Code))
+	input.Name = strings.TrimSpace(input.Name)
+	input.Currency = strings.ToUpper(strings.TrimSpace(input.Currency))
+	if input.Currency == "" {
+		input.Currency = "RUB"
+	}
+	switch {
+	case !planCodePattern.MatchString(input.Code), input.Name == "", len(input.Currency) != 3:
+		return input, ErrPlanInvalid
+	case input.MemberLimit < 0, input.WeeklyTokenLimit < 0, input.ResetAmount < 0,
+		input.StandardResponses < 0, input.EquivalentTokens < 0:
+		return input, ErrPlanInvalid
+	case input.AIChatEnabled && input.WeeklyTokenLimit == 0:
+		return input, ErrPlanInvalid
+	}
+	return input, nil
+}
+
+func normalizePriceInput(input PriceInput, now time.Time) (PriceInput, time.Time, error) {
+	input.PlanCode = strings.ToLower(strings.TrimSpace(input.PlanCode))
+	input.CreatedBy = strings.TrimSpace(input.CreatedBy)
+	if input.MonthlyAmount <= 0 || input.QuarterlyAmount <= 0 || input.AnnualAmount <= 0 {
+		return input, time.Time{}, ErrPriceInvalid
+	}
+	effectiveFrom := now
+	if input.EffectiveFrom != nil {
+		effectiveFrom = input.EffectiveFrom.UTC()
+	}
+	// Prices are not backdated: payments already taken were priced by the
+	// version in effect at the time.
+	if effectiveFrom.Before(now.Add(-time.Minute)) {
+		return input, time.Time{}, ErrPriceInvalid
+	}
+	return input, effectiveFrom, nil
+}
+
+// LoadCatalog reads billing_plans and their price versions and installs
+// them as the catalog behind PlanByCode.
+func (s *Service) LoadCatalog(ctx context.Context) error {
+	catalog, err := s.readCatalog(ctx)
+	if err != nil {
+		return err
+	}
+	InstallCatalog(catalog)
+	return nil
+}
+
+func (s *Service) readCatalog(ctx context.Context) (*Catalog, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT code, name, monthly_amount, quarterly_amount, annual_amount, currency,
+			member_limit, weekly_ai_limit, reset_amount, standard_responses_month,
+			equivalent_tokens_month, ai_chat_enabled, per_seat_pricing, active
+		FROM billing_plans
+		ORDER BY sort_order, code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var loaded []Plan
+	for rows.Next() {
+		var plan Plan
+		if err := rows.Scan(
+			&plan.Code, &plan.Name, &plan.MonthlyAmount, &plan.QuarterlyAmount, &plan.AnnualAmount,
+			&plan.Currency, &plan.MemberLimit, &plan.WeeklyTokenLimit, &plan.ResetAmount,
+			&plan.StandardResponses, &plan.EquivalentTokens, &plan.AIChatEnabled,
+			&plan.PerSeatPricing, &plan.Active,
+		); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(loaded) == 0 {
+		return nil, errors.New("billing_catalog_empty")
+	}
+	prices, err := s.priceVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewCatalog(loaded, prices), nil
+}
+
+func (s *Service) priceVersions(ctx context.Context) ([]PriceVersion, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, plan_code, monthly_amount, quarterly_amount, annual_amount, currency,
+			effective_from, created_by
+		FROM billing_plan_prices
+		ORDER BY plan_code, effective_from, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []PriceVersion
+	for rows.Next() {
+		var price PriceVersion
+		if err := rows.Scan(
+			&price.ID, &price.PlanCode, &price.MonthlyAmount, &price.QuarterlyAmount,
+			&price.AnnualAmount, &price.Currency, &price.EffectiveFrom, &price.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, price)
+	}
+	return result, rows.Err()
+}
+
+// StartCatalogRefresh re-reads the catalog periodically so changes made
+// through another instance, and prices reaching their effective date, are
+// picked up.
+func (s *Service) StartCatalogRefresh(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(catalogRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.LoadCatalog(ctx); err != nil {
+					log.Printf("[WARN] billing catalog refresh failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// WorkspacePlan prices planCode for a workspace: the plan it is subscribed
+// to keeps the price version it was bought at, any other plan costs what
+// the catalog asks today. Retired plans are only available to their
+// current subscribers.
+func WorkspacePlan(ctx context.Context, dbx *sql.DB, workspaceID int, planCode string) (Plan, error) {
+	var subscribedCode string
+	var versionID sql.NullInt64
+	err := dbx.QueryRowContext(ctx, `
+		SELECT subscription.plan_code, subscription.price_version_id
+		FROM subscriptions subscription
+		JOIN workspaces workspace ON workspace.id=$1
+		WHERE subscription.workspace_id=$1 OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id)
+		ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END, subscription.updated_at DESC
+		LIMIT 1
+	`, workspaceID).Scan(&subscribedCode, &versionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Plan{}, err
+	}
+	planCode = strings.ToLower(strings.TrimSpace(planCode))
+	if planCode == subscribedCode {
+		return PlanAtVersion(planCode, versionID.Int64)
+	}
+	plan, err := PlanByCode(planCode)
+	if err != nil {
+		return Plan{}, err
+	}
+	if !plan.Active {
+		return Plan{}, ErrPlanUnavailable
+	}
+	return plan, nil
+}
+
+func (s *Service) AdminPlans(ctx context.Context) ([]AdminPlan, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT plan.code, plan.sort_order,
+			(SELECT COUNT(*) FROM subscriptions subscription WHERE subscription.plan_code=plan.code)
+		FROM billing_plans plan
+		ORDER BY plan.sort_order, plan.code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []AdminPlan
+	for rows.Next() {
+		var item AdminPlan
+		if err := rows.Scan(&item.Code, &item.SortOrder, &item.Subscriptions); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	catalog, err := s.readCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for index := range result {
+		plan, ok := catalog.plan(result[index].Code)
+		if !ok {
+			return nil, ErrPlanNotFound
+		}
+		result[index].Plan = catalog.priced(plan, now)
+		result[index].Prices = append([]PriceVersion{}, catalog.prices[plan.Code]...)
+	}
+	return result, nil
+}
+
+// SavePlan creates a plan with its first price or updates the attributes of
+// an existing one. Plans are retired with Active=false rather than deleted:
+// subscriptions, orders and invoices keep referring to them. Switching
+// per-seat pricing is refused while anyone is subscribed, since it changes
+// what their quantity means.
+func (s *Service) SavePlan(ctx context.Context, input PlanInput) (AdminPlan, error) {
+	input, err := normalizePlanInput(input)
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	defer tx.Rollback()
+	var perSeat bool
+	var subscribed int
+	err = tx.QueryRowContext(ctx, `
+		SELECT plan.per_seat_pricing,
+			(SELECT COUNT(*) FROM subscriptions subscription WHERE subscription.plan_code=plan.code)
+		FROM billing_plans plan WHERE plan.code=$1
+		FOR UPDATE
+	`, input.Code).Scan(&perSeat, &subscribed)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return AdminPlan{}, err
+	}
+	if exists && perSeat != input.PerSeatPricing && subscribed > 0 {
+		return AdminPlan{}, ErrPlanInUse
+	}
+	if !exists && input.Price == nil {
+		return AdminPlan{}, ErrPriceInvalid
+	}
+	var price PriceInput
+	var effectiveFrom time.Time
+	if !exists {
+		price, effectiveFrom, err = normalizePriceInput(*input.Price, time.Now().UTC())
+		if err != nil {
+			return AdminPlan{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO billing_plans (
+			code, name, monthly_amount, quarterly_amount, annual_amount, currency,
+			member_limit, weekly_ai_limit, reset_amount, standard_responses_month,
+			equivalent_tokens_month, ai_chat_enabled, per_seat_pricing, active, sort_order
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
+		ON CONFLICT (code) DO UPDATE SET
+			name=EXCLUDED.name, currency=EXCLUDED.currency, member_limit=EXCLUDED.member_limit,
+			weekly_ai_limit=EXCLUDED.weekly_ai_limit, reset_amount=EXCLUDED.reset_amount,
+			standard_responses_month=EXCLUDED.standard_responses_month,
+			equivalent_tokens_month=EXCLUDED.equivalent_tokens_month,
+			ai_chat_enabled=EXCLUDED.ai_chat_enabled, per_seat_pricing=EXCLUDED.per_seat_pricing,
+			active=EXCLUDED.active, sort_order=EXCLUDED.sort_order, updated_at=NOW()
+	`, input.Code, input.Name, price.MonthlyAmount, price.QuarterlyAmount, price.AnnualAmount,
+		input.Currency, input.MemberLimit, input.WeeklyTokenLimit, input.ResetAmount,
+		input.StandardResponses, input.EquivalentTokens, input.AIChatEnabled, input.PerSeatPricing,
+		input.Active, input.SortOrder); err != nil {
+		return AdminPlan{}, err
+	}
+	if !exists {
+		if err := insertPriceVersion(ctx, tx, input.Code, input.Currency, price, effectiveFrom); err != nil {
+			return AdminPlan{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return AdminPlan{}, err
+	}
+	return s.reloadedPlan(ctx, input.Code)
+}
+
+// AddPriceVersion schedules a new price. It applies to new purchases and
+// plan changes from EffectiveFrom; current subscribers keep theirs.
+func (s *Service) AddPriceVersion(ctx context.Context, input PriceInput) (AdminPlan, error) {
+	input, effectiveFrom, err := normalizePriceInput(input, time.Now().UTC())
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	defer tx.Rollback()
+	var currency string
+	err = tx.QueryRowContext(ctx, `SELECT currency FROM billing_plans WHERE code=$1 FOR UPDATE`, input.PlanCode).Scan(&currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AdminPlan{}, ErrPlanNotFound
+	}
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	if err := insertPriceVersion(ctx, tx, input.PlanCode, currency, input, effectiveFrom); err != nil {
+		return AdminPlan{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AdminPlan{}, err
+	}
+	return s.reloadedPlan(ctx, input.PlanCode)
+}
+
+// DeletePriceVersion withdraws a price that has not taken effect yet.
+func (s *Service) DeletePriceVersion(ctx context.Context, versionID int64) error {
+	result, err := s.dbx.ExecContext(ctx, `
+		DELETE FROM billing_plan_prices price
+		WHERE price.id=$1 AND price.effective_from > NOW()
+			AND NOT EXISTS (SELECT 1 FROM workspace_billing_orders billing_order WHERE billing_order.price_version_id=price.id)
+			AND NOT EXISTS (
+				SELECT 1 FROM subscriptions subscription
+				WHERE price.id IN (subscription.price_version_id, subscription.pending_price_version_id, subscription.scheduled_price_version_id)
+			)
+	`, versionID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return errors.Join(ErrPriceVersionNotFound, err)
+	}
+	return s.LoadCatalog(ctx)
+}
+
+func insertPriceVersion(ctx context.Context, tx *sql.Tx, planCode, currency string, input PriceInput, effectiveFrom time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO billing_plan_prices (
+			plan_code, monthly_amount, quarterly_amount, annual_amount, currency, effective_from, created_by
+		) VALUES ($1,$2,$3,$4,$5,$6,$7)
+	`, planCode, input.MonthlyAmount, input.QuarterlyAmount, input.AnnualAmount, currency, effectiveFrom, input.CreatedBy)
+	return err
+}
+
+func (s *Service) reloadedPlan(ctx context.Context, code string) (AdminPlan, error) {
+	if err := s.LoadCatalog(ctx); err != nil {
+		return AdminPlan{}, err
+	}
+	items, err := s.AdminPlans(ctx)
+	if err != nil {
+		return AdminPlan{}, err
+	}
+	for _, item := range items {
+		if item.Code == code {
+			return item, nil
+		}
+	}
+	return AdminPlan{}, ErrPlanNotFound
+}
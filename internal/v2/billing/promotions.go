@@ -0,0 +1,594 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+
+	AttributionReferral  = "referral"
+	AttributionPromoCode = "promo_code"
+
+	// minimumChargeAmount keeps a discounted order payable: the card widget
+	// and bank transfers both need a positive amount.
+	minimumChargeAmount = 1
+
+	// promoReservationTTL is how long an unpaid card order holds its promo
+	// code redemption. An invoice holds it until the invoice falls due.
+	promoReservationTTL = 24 * time.Hour
+)
+
+var ErrPromoCodeNotFound = errors.New("promo_code_not_found")
+var ErrPromoCodeExhausted = errors.New("promo_code_exhausted")
+var ErrPromoCodeRedeemed = errors.New("promo_code_already_redeemed")
+var ErrPromoCodeNotApplicable = errors.New("promo_code_not_applicable")
+var ErrPromoCodeInvalid = errors.New("promo_code_invalid")
+var ErrPartnerInvalid = errors.New("billing_partner_invalid")
+var ErrPartnerNotFound = errors.New("billing_partner_not_found")
+var ErrWorkspaceAttributed = errors.New("workspace_already_attributed")
+
+var promotionCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{2,31}$`)
+
+// PromoCode is a discount on a subscription purchase. Empty PlanCodes or
+// BillingPeriods mean any. FirstPaymentOnly limits the code to workspaces
+// that have never paid for a subscription. A code tied to a partner also
+// attributes the workspace to that partner when it is redeemed.
+type PromoCode struct {
+	ID               int64      `json:"id"`
+	Code             string     `json:"code"`
+	DiscountKind     string     `json:"discount_kind"`
+	DiscountValue    float64    `json:"discount_value"`
+	Currency         string     `json:"currency"`
+	MaxRedemptions   *int       `json:"max_redemptions,omitempty"`
+	Redemptions      int        `json:"redemptions"`
+	StartsAt         time.Time  `json:"starts_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	PlanCodes        []string   `json:"plan_codes"`
+	BillingPeriods   []string   `json:"billing_periods"`
+	FirstPaymentOnly bool       `json:"first_payment_only"`
+	PartnerID        *int64     `json:"partner_id,omitempty"`
+	Active           bool       `json:"active"`
+	CreatedBy        string     `json:"created_by,omitempty"`
+}
+
+// Discount is a promo code applied to one order. It covers the period that
+// order pays for; card renewals charge RenewalAmount, the list price.
+type Discount struct {
+	PromoCodeID    int64   `json:"-"`
+	Code           string  `json:"code"`
+	ListAmount     float64 `json:"list_amount"`
+	DiscountAmount float64 `json:"discount_amount"`
+	Amount         float64 `json:"amount"`
+	RenewalAmount  float64 `json:"renewal_amount"`
+	Currency       string  `json:"currency"`
+}
+
+type Partner struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	ReferralCode string    `json:"referral_code"`
+	ContactEmail string    `json:"contact_email,omitempty"`
+	Active       bool      `json:"active"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PartnerRevenue is one partner's line in the referral report, per
+// currency. Revenue counts payments made by attributed workspaces after
+// they were attributed.
+type PartnerRevenue struct {
+	PartnerID            int64   `json:"partner_id"`
+	Name                 string  `json:"name"`
+	ReferralCode         string  `json:"referral_code"`
+	AttributedWorkspaces int     `json:"attributed_workspaces"`
+	PayingWorkspaces     int     `json:"paying_workspaces"`
+	Payments             int     `json:"payments"`
+	Revenue              float64 `json:"revenue"`
+	Currency             string  `json:"currency"`
+}
+
+func normalizePromotionCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// apply prices amount, the list price of planCode for period, with the
+// code. firstPayment tells whether the workspace has never paid for a
+// subscription.
+func (p PromoCode) apply(planCode, period, currency string, amount float64, firstPayment bool, now time.Time) (Discount, error) {
+	switch {
+	case !p.Active, now.Before(p.StartsAt), p.ExpiresAt != nil && !now.Before(*p.ExpiresAt):
+		return Discount{}, ErrPromoCodeNotFound
+	case p.MaxRedemptions != nil && p.Redemptions >= *p.MaxRedemptions:
+		return Discount{}, ErrPromoCodeExhausted
+	case len(p.PlanCodes) > 0 && !slices.Contains(p.PlanCodes, planCode),
+		len(p.BillingPeriods) > 0 && !slices.Contains(p.BillingPeriods, period),
+		p.FirstPaymentOnly && !firstPayment,
+		p.DiscountKind == DiscountFixed && !strings.EqualFold(p.Currency, currency):
+		return Discount{}, ErrPromoCodeNotApplicable
+	}
+	discount := p.DiscountValue
+	if p.DiscountKind == DiscountPercent {
+		discount = roundMoney(amount * p.DiscountValue / 100)
+	}
+	discount = math.Min(discount, roundMoney(amount-minimumChargeAmount))
+	if discount <= 0 {
+		return Discount{}, ErrPromoCodeNotApplicable
+	}
+	return Discount{
+		PromoCodeID: p.ID, Code: p.Code, ListAmount: amount, DiscountAmount: discount,
+		Amount: roundMoney(amount - discount), RenewalAmount: amount, Currency: currency,
+	}, nil
+}
+
+// QuotePromoCode applies code to a subscription order for a workspace. Only
+// subscription purchases take promo codes; resets and plan changes do not.
+// Each workspace redeems a code once. The redemption limit is checked here
+// for the preview; the order that uses the code reserves a redemption with
+// ReservePromoCode when it is created.
+func QuotePromoCode(ctx context.Context, dbx *sql.DB, workspaceID int, code, orderKind string, plan Plan, period string, amount float64) (Discount, error) {
+	if orderKind != OrderSubscription {
+		return Discount{}, ErrPromoCodeNotApplicable
+	}
+	promo, err := promoCodeByCode(ctx, dbx, code)
+	if err != nil {
+		return Discount{}, err
+	}
+	var redeemed, paid bool
+	if err := dbx.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM billing_promo_redemptions
+				WHERE promo_code_id=$1 AND workspace_id=$2
+			),
+			EXISTS (
+				SELECT 1 FROM workspace_billing_payments
+				WHERE workspace_id=$2 AND status='paid' AND order_kind IN ('subscription', 'plan_change')
+			)
+	`, promo.ID, workspaceID).Scan(&redeemed, &paid); err != nil {
+		return Discount{}, err
+	}
+	if redeemed {
+		return Discount{}, ErrPromoCodeRedeemed
+	}
+	return promo.apply(plan.Code, period, plan.Currency, amount, !paid, time.Now().UTC())
+}
+
+func promoCodeByCode(ctx context.Context, dbx *sql.DB, code string) (PromoCode, error) {
+	code = normalizePromotionCode(code)
+	if !promotionCodePattern.MatchString(code) {
+		return PromoCode{}, ErrPromoCodeNotFound
+	}
+	items, err := queryPromoCodes(ctx, dbx, `WHERE promo.code=$1`, code)
+	if err != nil {
+		return PromoCode{}, err
+	}
+	if len(items) == 0 {
+		return PromoCode{}, ErrPromoCodeNotFound
+	}
+	return items[0], nil
+}
+
+func queryPromoCodes(ctx context.Context, dbx *sql.DB, where string, args ...any) ([]PromoCode, error) {
+	rows, err := dbx.QueryContext(ctx, `
+		SELECT promo.id, promo.code, promo.discount_kind, promo.discount_value, promo.currency,
+			promo.max_redemptions, promo.redemptions, promo.starts_at, promo.expires_at,
+			promo.plan_codes, promo.billing_periods, promo.first_payment_only,
+			promo.partner_id, promo.active, promo.created_by
+		FROM billing_promo_codes promo
+		`+where+`
+		ORDER BY promo.created_at DESC, promo.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []PromoCode{}
+	for rows.Next() {
+		var item PromoCode
+		var maxRedemptions sql.NullInt64
+		var expiresAt sql.NullTime
+		var partnerID sql.NullInt64
+		if err := rows.Scan(
+			&item.ID, &item.Code, &item.DiscountKind, &item.DiscountValue, &item.Currency,
+			&maxRedemptions, &item.Redemptions, &item.StartsAt, &expiresAt,
+			pq.Array(&item.PlanCodes), pq.Array(&item.BillingPeriods), &item.FirstPaymentOnly,
+			&partnerID, &item.Active, &item.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		if maxRedemptions.Valid {
+			limit := int(maxRedemptions.Int64)
+			item.MaxRedemptions = &limit
+		}
+		if expiresAt.Valid {
+			item.ExpiresAt = &expiresAt.Time
+		}
+		if partnerID.Valid {
+			item.PartnerID = &partnerID.Int64
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// ReservePromoCode takes one redemption of the code for an order created in
+// tx, so the limit holds however many orders start at once. The reservation
+// is released by ReleasePromoReservations if the order is never paid.
+func ReservePromoCode(ctx context.Context, tx *sql.Tx, promoCodeID int64) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE billing_promo_codes SET redemptions=redemptions+1, updated_at=NOW()
+		WHERE id=$1 AND (max_redemptions IS NULL OR redemptions < max_redemptions)
+	`, promoCodeID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return errors.Join(ErrPromoCodeExhausted, err)
+	}
+	return nil
+}
+
+// ReleasePromoReservations gives back the redemptions held by orders that
+// were cancelled or expired, by card orders left unpaid for
+// promoReservationTTL and by invoices that fell due unpaid. It returns how
+// many reservations it released.
+func (s *Service) ReleasePromoReservations(ctx context.Context) (int, error) {
+	var released int
+	err := s.dbx.QueryRowContext(ctx, `
+		WITH released AS (
+			UPDATE workspace_billing_orders billing_order
+			SET promo_released_at=NOW(), updated_at=NOW()
+			WHERE billing_order.promo_code_id IS NOT NULL AND billing_order.promo_released_at IS NULL
+				AND (
+					billing_order.status IN ('cancelled', 'expired')
+					OR (billing_order.status='waiting' AND billing_order.provider<>'manual'
+						AND billing_order.created_at <= NOW() - ($1 * INTERVAL '1 second'))
+					OR (billing_order.status='waiting' AND EXISTS (
+						SELECT 1 FROM workspace_billing_invoices invoice
+						WHERE invoice.order_id=billing_order.id AND invoice.status='waiting'
+							AND invoice.due_at <= NOW()
+					))
+				)
+			RETURNING billing_order.promo_code_id
+		), counted AS (
+			SELECT promo_code_id, COUNT(*) AS orders FROM released GROUP BY promo_code_id
+		), updated AS (
+			UPDATE billing_promo_codes promo
+			SET redemptions=GREATEST(0, promo.redemptions - counted.orders), updated_at=NOW()
+			FROM counted
+			WHERE promo.id=counted.promo_code_id
+		)
+		SELECT COUNT(*) FROM released
+	`, int(promoReservationTTL.Seconds())).Scan(&released)
+	return released, err
+}
+
+// redeemPromoCode records the promo code of a paid order and attributes the
+// workspace to the code's partner unless it already has one. The redemption
+// was counted when the order reserved it; an order paid after its
+// reservation was released counts it again, since the discounted money is
+// already in. Confirming the same order twice redeems it once.
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, orderID int64) error {
+	var promoCodeID sql.NullInt64
+	var workspaceID int
+	var discountAmount float64
+	var released bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT promo_code_id, workspace_id, discount_amount, promo_released_at IS NOT NULL
+		FROM workspace_billing_orders WHERE id=$1
+	`, orderID).Scan(&promoCodeID, &workspaceID, &discountAmount, &released); err != nil {
+		return err
+	}
+	if !promoCodeID.Valid {
+		return nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO billing_promo_redemptions (promo_code_id, workspace_id, order_id, discount_amount)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (order_id) DO NOTHING
+	`, promoCodeID.Int64, workspaceID, orderID, discountAmount)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return err
+	}
+	if released {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE billing_promo_codes SET redemptions=redemptions+1, updated_at=NOW() WHERE id=$1
+		`, promoCodeID.Int64); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE workspace_billing_orders SET promo_released_at=NULL, updated_at=NOW() WHERE id=$1
+		`, orderID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_partner_attributions (workspace_id, partner_id, source, code)
+		SELECT $1, promo.partner_id, 'promo_code', promo.code
+		FROM billing_promo_codes promo
+		JOIN billing_partners partner ON partner.id=promo.partner_id
+		WHERE promo.id=$2
+		ON CONFLICT (workspace_id) DO NOTHING
+	`, workspaceID, promoCodeID.Int64)
+	return err
+}
+
+// AttributeReferral records that a partner brought the workspace. The first
+// partner wins; a later referral code for the same workspace is refused.
+func AttributeReferral(ctx context.Context, dbx *sql.DB, workspaceID int, code string) (Partner, error) {
+	code = normalizePromotionCode(code)
+	if !promotionCodePattern.MatchString(code) {
+		return Partner{}, ErrPartnerNotFound
+	}
+	var partner Partner
+	err := dbx.QueryRowContext(ctx, `
+		SELECT id, name, referral_code, active, created_at
+		FROM billing_partners WHERE referral_code=$1 AND active
+	`, code).Scan(&partner.ID, &partner.Name, &partner.ReferralCode, &partner.Active, &partner.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Partner{}, ErrPartnerNotFound
+	}
+	if err != nil {
+		return Partner{}, err
+	}
+	var attributedTo int64
+	err = dbx.QueryRowContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO workspace_partner_attributions (workspace_id, partner_id, source, code)
+			VALUES ($1,$2,'referral',$3)
+			ON CONFLICT (workspace_id) DO NOTHING
+			RETURNING partner_id
+		)
+		SELECT partner_id FROM inserted
+		UNION ALL
+		SELECT partner_id FROM workspace_partner_attributions WHERE workspace_id=$1
+		LIMIT 1
+	`, workspaceID, partner.ID, code).Scan(&attributedTo)
+	if err != nil {
+		return Partner{}, err
+	}
+	if attributedTo != partner.ID {
+		return Partner{}, ErrWorkspaceAttributed
+	}
+	return partner, nil
+}
+
+// PromoInput is what the admin API accepts for a promo code; saving an
+// existing code updates it in place.
+type PromoInput struct {
+	Code             string     `json:"code"`
+	DiscountKind     string     `json:"discount_kind"`
+	DiscountValue    float64    `json:"discount_value"`
+	Currency         string     `json:"currency"`
+	MaxRedemptions   *int       `json:"max_redemptions,omitempty"`
+	StartsAt         *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	PlanCodes        []string   `json:"plan_codes"`
+	BillingPeriods   []string   `json:"billing_periods"`
+	FirstPaymentOnly bool       `json:"first_payment_only"`
+	PartnerID        *int64     `json:"partner_id,omitempty"`
+	Active           bool       `json:"active"`
+	CreatedBy        string     `json:"created_by"`
+}
+
+func normalizePromoInput(input PromoInput, now time.Time) (PromoInput, error) {
+	input.Code = normalizePromotionCode(input.Code)
+	input.DiscountKind = strings.ToLower(strings.TrimSpace(input.DiscountKind))
+	input.Currency = strings.ToUpper(strings.TrimSpace(input.Currency))
+	input.CreatedBy = strings.TrimSpace(input.CreatedBy)
+	if input.Currency == "" {
+		input.Currency = "RUB"
+	}
+	if input.StartsAt == nil {
+		input.StartsAt = &now
+	}
+	planCodes := make([]string, 0, len(input.PlanCodes))
+	for _, code := range input.PlanCodes {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if _, err := PlanByCode(code); err != nil {
+			return input, ErrPromoCodeInvalid
+		}
+		if !slices.Contains(planCodes, code) {
+			planCodes = append(planCodes, code)
+		}
+	}
+	input.PlanCodes = planCodes
+	periods := make([]string, 0, len(input.BillingPeriods))
+	for _, period := range input.BillingPeriods {
+		period = strings.ToLower(strings.TrimSpace(period))
+		if period != PeriodMonthly && period != PeriodQuarterly && period != PeriodAnnual {
+			return input, ErrPromoCodeInvalid
+		}
+		if !slices.Contains(periods, period) {
+			periods = append(periods, period)
+		}
+	}
+	input.BillingPeriods = periods
+	switch {
+	case !promotionCodePattern.MatchString(input.Code), len(input.Currency) != 3:
+		return input, ErrPromoCodeInvalid
+	case input.DiscountKind != DiscountPercent && input.DiscountKind != DiscountFixed:
+		return input, ErrPromoCodeInvalid
+	case input.DiscountValue <= 0, input.DiscountKind == DiscountPercent && input.DiscountValue >= 100:
+		return input, ErrPromoCodeInvalid
+	case input.MaxRedemptions != nil && *input.MaxRedemptions <= 0:
+		return input, ErrPromoCodeInvalid
+	case input.ExpiresAt != nil && !input.ExpiresAt.After(*input.StartsAt):
+		return input, ErrPromoCodeInvalid
+	}
+	return input, nil
+}
+
+func (s *Service) PromoCodes(ctx context.Context) ([]PromoCode, error) {
+	return queryPromoCodes(ctx, s.dbx, "")
+}
+
+func (s *Service) SavePromoCode(ctx context.Context, input PromoInput) (PromoCode, error) {
+	input, err := normalizePromoInput(input, time.Now().UTC())
+	if err != nil {
+		return PromoCode{}, err
+	}
+	var id int64
+	err = s.dbx.QueryRowContext(ctx, `
+		INSERT INTO billing_promo_codes (
+			code, discount_kind, discount_value, currency, max_redemptions, starts_at, expires_at,
+			plan_codes, billing_periods, first_payment_only, partner_id, active, created_by
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		ON CONFLICT (code) DO UPDATE SET
+			discount_kind=EXCLUDED.discount_kind, discount_value=EXCLUDED.discount_value,
+			currency=EXCLUDED.currency, max_redemptions=EXCLUDED.max_redemptions,
+			starts_at=EXCLUDED.starts_at, expires_at=EXCLUDED.expires_at,
+			plan_codes=EXCLUDED.plan_codes, billing_periods=EXCLUDED.billing_periods,
+			first_payment_only=EXCLUDED.first_payment_only, partner_id=EXCLUDED.partner_id,
+			active=EXCLUDED.active, updated_at=NOW()
+		RETURNING id
+	`, input.Code, input.DiscountKind, input.DiscountValue, input.Currency, input.MaxRedemptions,
+		input.StartsAt, input.ExpiresAt, pq.Array(input.PlanCodes), pq.Array(input.BillingPeriods),
+		input.FirstPaymentOnly, input.PartnerID, input.Active, input.CreatedBy).Scan(&id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return PromoCode{}, ErrPartnerNotFound
+		}
+		return PromoCode{}, err
+	}
+	items, err := queryPromoCodes(ctx, s.dbx, `WHERE promo.id=$1`, id)
+	if err != nil {
+		return PromoCode{}, err
+	}
+	if len(items) == 0 {
+		return PromoCode{}, ErrPromoCodeNotFound
+	}
+	return items[0], nil
+}
+
+type PartnerInput struct {
+	ID           int64  `json:"id,omitempty"`
+	Name         string `json:"name"`
+	ReferralCode string `json:"referral_code"`
+	ContactEmail string `json:"contact_email"`
+	Active       bool   `json:"active"`
+}
+
+func normalizePartnerInput(input PartnerInput) (PartnerInput, error) {
+	input.Name = strings.TrimSpace(input.Name)
+	input.ReferralCode = normalizePromotionCode(input.ReferralCode)
+	input.ContactEmail = strings.ToLower(strings.TrimSpace(input.ContactEmail))
+	if input.Name == "" || !promotionCodePattern.MatchString(input.ReferralCode) || input.ID < 0 {
+		return input, ErrPartnerInvalid
+	}
+	if input.ContactEmail != "" && !strings.Contains(input.ContactEmail, "@") {
+		return input, ErrPartnerInvalid
+	}
+	return input, nil
+}
+
+func (s *Service) Partners(ctx context.Context) ([]Partner, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, name, referral_code, contact_email, active, created_at
+		FROM billing_partners ORDER BY name, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Partner{}
+	for rows.Next() {
+		var item Partner
+		if err := rows.Scan(&item.ID, &item.Name, &item.ReferralCode, &item.ContactEmail, &item.Active, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// SavePartner creates a partner, or updates the one with ID. Referral codes
+// are unique across partners.
+func (s *Service) SavePartner(ctx context.Context, input PartnerInput) (Partner, error) {
+	input, err := normalizePartnerInput(input)
+	if err != nil {
+		return Partner{}, err
+	}
+	var result Partner
+	if input.ID == 0 {
+		err = s.dbx.QueryRowContext(ctx, `
+			INSERT INTO billing_partners (name, referral_code, contact_email, active)
+			VALUES ($1,$2,$3,$4)
+			RETURNING id, name, referral_code, contact_email, active, created_at
+		`, input.Name, input.ReferralCode, input.ContactEmail, input.Active).Scan(
+			&result.ID, &result.Name, &result.ReferralCode, &result.ContactEmail, &result.Active, &result.CreatedAt,
+		)
+	} else {
+		err = s.dbx.QueryRowContext(ctx, `
+			UPDATE billing_partners SET name=$2, referral_code=$3, contact_email=$4, active=$5, updated_at=NOW()
+			WHERE id=$1
+			RETURNING id, name, referral_code, contact_email, active, created_at
+		`, input.ID, input.Name, input.ReferralCode, input.ContactEmail, input.Active).Scan(
+			&result.ID, &result.Name, &result.ReferralCode, &result.ContactEmail, &result.Active, &result.CreatedAt,
+		)
+	}
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Partner{}, ErrPartnerNotFound
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		return Partner{}, ErrPartnerInvalid
+	}
+	return result, err
+}
+
+// PartnerReport sums what workspaces attributed to each partner paid in
+// [from, to), less what was refunded in it. Partners without attributed
+// workspaces are listed with zeros.
+func (s *Service) PartnerReport(ctx context.Context, from, to time.Time) ([]PartnerRevenue, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		WITH revenue AS (
+			SELECT attribution.partner_id, payment.currency,
+				COUNT(DISTINCT payment.workspace_id) FILTER (WHERE payment.status='paid') AS paying_workspaces,
+				COUNT(*) FILTER (WHERE payment.status='paid') AS payments, SUM(payment.amount) AS amount
+			FROM workspace_partner_attributions attribution
+			JOIN workspace_billing_payments payment ON payment.workspace_id=attribution.workspace_id
+				AND payment.status IN ('paid', 'refunded') AND payment.paid_at >= attribution.attributed_at
+				AND payment.paid_at >= $1 AND payment.paid_at < $2
+			GROUP BY attribution.partner_id, payment.currency
+		)
+		SELECT partner.id, partner.name, partner.referral_code,
+			(SELECT COUNT(*) FROM workspace_partner_attributions attributed
+				WHERE attributed.partner_id=partner.id AND attributed.attributed_at < $2),
+			COALESCE(revenue.paying_workspaces, 0), COALESCE(revenue.payments, 0),
+			COALESCE(revenue.amount, 0), COALESCE(revenue.currency, 'RUB')
+		FROM billing_partners partner
+		LEFT JOIN revenue ON revenue.partner_id=partner.id
+		ORDER BY COALESCE(revenue.amount, 0) DESC, partner.name, partner.id
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []PartnerRevenue{}
+	for rows.Next() {
+		var item PartnerRevenue
+		if err := rows.Scan(
+			&item.PartnerID, &item.Name, &item.ReferralCode, &item.AttributedWorkspaces,
+			&item.PayingWorkspaces, &item.Payments, &item.Revenue, &item.Currency,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
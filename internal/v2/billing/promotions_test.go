@@ -0,0 +1,159 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromoCodeApply(t *testing.T) {
+	now := time.Date(2026, 9, 15, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	limit := 10
+	base := PromoCode{
+		ID: 7, Code: "PARTNER20", DiscountKind: DiscountPercent, DiscountValue: 20, Currency: "RUB",
+		StartsAt: now.AddDate(0, -1, 0), Active: true,
+	}
+	tests := []struct {
+		name         string
+		promo        func(PromoCode) PromoCode
+		plan, period string
+		amount       float64
+		firstPayment bool
+		want         Discount
+		err          error
+	}{
+		{
+			name: "percent", plan: PlanFounder, period: PeriodMonthly, amount: 3490,
+			want: Discount{ListAmount: 3490, DiscountAmount: 698, Amount: 2792, RenewalAmount: 3490},
+		},
+		{
+			name: "fixed",
+			promo: func(promo PromoCode) PromoCode {
+				promo.DiscountKind, promo.DiscountValue = DiscountFixed, 500
+				return promo
+			},
+			plan: PlanTeam, period: PeriodAnnual, amount: 100716,
+			want: Discount{ListAmount: 100716, DiscountAmount: 500, Amount: 100216, RenewalAmount: 100716},
+		},
+		{
+			name: "fixed above the price leaves the minimum charge",
+			promo: func(promo PromoCode) PromoCode {
+				promo.DiscountKind, promo.DiscountValue = DiscountFixed, 5000
+				return promo
+			},
+			plan: PlanStart, period: PeriodMonthly, amount: 290,
+			want: Discount{ListAmount: 290, DiscountAmount: 289, Amount: 1, RenewalAmount: 290},
+		},
+		{
+			name:  "expired",
+			promo: func(promo PromoCode) PromoCode { promo.ExpiresAt = &expired; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotFound,
+		},
+		{
+			name:  "not started",
+			promo: func(promo PromoCode) PromoCode { promo.StartsAt = now.Add(time.Hour); return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotFound,
+		},
+		{
+			name:  "inactive",
+			promo: func(promo PromoCode) PromoCode { promo.Active = false; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotFound,
+		},
+		{
+			name: "redemptions used up",
+			promo: func(promo PromoCode) PromoCode {
+				promo.MaxRedemptions, promo.Redemptions = &limit, 10
+				return promo
+			},
+			plan: PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeExhausted,
+		},
+		{
+			name:  "other plan",
+			promo: func(promo PromoCode) PromoCode { promo.PlanCodes = []string{PlanTeam}; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotApplicable,
+		},
+		{
+			name:  "other period",
+			promo: func(promo PromoCode) PromoCode { promo.BillingPeriods = []string{PeriodAnnual}; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotApplicable,
+		},
+		{
+			name:  "first payment only for a paying workspace",
+			promo: func(promo PromoCode) PromoCode { promo.FirstPaymentOnly = true; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotApplicable,
+		},
+		{
+			name:  "first payment only for a new workspace",
+			promo: func(promo PromoCode) PromoCode { promo.FirstPaymentOnly = true; return promo },
+			plan:  PlanFounder, period: PeriodMonthly, amount: 3490, firstPayment: true,
+			want: Discount{ListAmount: 3490, DiscountAmount: 698, Amount: 2792, RenewalAmount: 3490},
+		},
+		{
+			name: "fixed in another currency",
+			promo: func(promo PromoCode) PromoCode {
+				promo.DiscountKind, promo.DiscountValue, promo.Currency = DiscountFixed, 10, "USD"
+				return promo
+			},
+			plan: PlanFounder, period: PeriodMonthly, amount: 3490, err: ErrPromoCodeNotApplicable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			promo := base
+			if test.promo != nil {
+				promo = test.promo(promo)
+			}
+			got, err := promo.apply(test.plan, test.period, "RUB", test.amount, test.firstPayment, now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("apply error = %v, want %v", err, test.err)
+			}
+			if test.err != nil {
+				return
+			}
+			want := test.want
+			want.PromoCodeID, want.Code, want.Currency = promo.ID, promo.Code, "RUB"
+			if got != want {
+				t.Fatalf("apply = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizePromoInput(t *testing.T) {
+	now := time.Date(2026, 9, 15, 12, 0, 0, 0, time.UTC)
+	valid := PromoInput{
+		Code: " partner-20 ", DiscountKind: " Percent ", DiscountValue: 20,
+		PlanCodes: []string{"Team", "team"}, BillingPeriods: []string{"annual"},
+	}
+	got, err := normalizePromoInput(valid, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "PARTNER-20" || got.DiscountKind != DiscountPercent || got.Currency != "RUB" ||
+		len(got.PlanCodes) != 1 || got.PlanCodes[0] != PlanTeam || !got.StartsAt.Equal(now) {
+		t.Fatalf("normalizePromoInput = %+v", got)
+	}
+	zero := 0
+	past := now.Add(-time.Hour)
+	tests := []struct {
+		name  string
+		apply func(*PromoInput)
+	}{
+		{"short code", func(input *PromoInput) { input.Code = "AB" }},
+		{"unknown kind", func(input *PromoInput) { input.DiscountKind = "bogo" }},
+		{"full percent", func(input *PromoInput) { input.DiscountValue = 100 }},
+		{"no discount", func(input *PromoInput) { input.DiscountValue = 0 }},
+		{"unknown plan", func(input *PromoInput) { input.PlanCodes = []string{"enterprise"} }},
+		{"unknown period", func(input *PromoInput) { input.BillingPeriods = []string{"weekly"} }},
+		{"zero redemptions", func(input *PromoInput) { input.MaxRedemptions = &zero }},
+		{"expires before start", func(input *PromoInput) { input.ExpiresAt = &past }},
+	}
+	for _, test := range tests {
+		input := valid
+		test.apply(&input)
+		if _, err := normalizePromoInput(input, now); !errors.Is(err, ErrPromoCodeInvalid) {
+			t.Fatalf("%s: error = %v, want %v", test.name, err, ErrPromoCodeInvalid)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	PlanChangeUpgrade      = "upgrade"
+	PlanChangeDowngrade    = "downgrade"
+	PlanChangeSeatsAdded   = "seats_added"
+	PlanChangeSeatsRemoved = "seats_removed"
+)
+
+var ErrPlanChangeUnavailable = errors.New("plan_change_requires_active_subscription")
+var ErrPlanChangeNoop = errors.New("plan_change_noop")
+var ErrSeatsBelowMembers = errors.New("billing_quantity_below_reserved_seats")
+var ErrPendingPlanChange = errors.New("pending_subscription_change_exists")
+
+// SubscriptionTerms is what the workspace currently pays for.
+type SubscriptionTerms struct {
+	PlanCode      string
+	BillingPeriod string
+	MemberLimit   int
+	Amount        float64
+	PaymentMethod string
+	PeriodStart   time.Time
+	PeriodEnd     time.Time
+	Active        bool
+	// Pending is a plan already paid for from the next period on.
+	Pending      bool
+	PriceVersion int64
+}
+
+// PlanChange is a priced move to another plan or seat count. Changes that
+// raise the full-period price apply at once: the unused part of the current
+// period is credited against the new price for the same remaining time.
+// Cheaper changes wait for the period end, when nothing is left to credit.
+type PlanChange struct {
+	Kind              string    `json:"kind"`
+	PlanCode          string    `json:"plan_code"`
+	PlanName          string    `json:"plan_name"`
+	BillingPeriod     string    `json:"billing_period"`
+	Quantity          int       `json:"quantity"`
+	MemberLimit       int       `json:"member_limit"`
+	Immediate         bool      `json:"immediate"`
+	EffectiveAt       time.Time `json:"effective_at"`
+	PeriodEnd         time.Time `json:"period_end"`
+	RemainingFraction float64   `json:"remaining_fraction"`
+	Credit            float64   `json:"credit"`
+	Charge            float64   `json:"charge"`
+	AmountDue         float64   `json:"amount_due"`
+	RenewalAmount     float64   `json:"renewal_amount"`
+	Currency          string    `json:"currency"`
+	PriceVersion      int64     `json:"price_version,omitempty"`
+}
+
+func ProratePlanChange(current SubscriptionTerms, target Plan, quantity int, now time.Time) (PlanChange, error) {
+	if !current.Active || !current.PeriodEnd.After(now) || !current.PeriodEnd.After(current.PeriodStart) {
+		return PlanChange{}, ErrPlanChangeUnavailable
+	}
+	if current.Pending {
+		return PlanChange{}, ErrPendingPlanChange
+	}
+	currentPlan, err := PlanAtVersion(current.PlanCode, current.PriceVersion)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	currentQuantity := 1
+	if currentPlan.PerSeatPricing {
+		currentQuantity = max(1, current.MemberLimit)
+	}
+	if !target.PerSeatPricing {
+		quantity = 1
+	}
+	if target.Code == currentPlan.Code && quantity == currentQuantity {
+		return PlanChange{}, ErrPlanChangeNoop
+	}
+	currentPrice, err := SubscriptionPrice(currentPlan, current.BillingPeriod, currentQuantity)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	targetPrice, err := SubscriptionPrice(target, current.BillingPeriod, quantity)
+	if err != nil {
+		return PlanChange{}, err
+	}
+
+	change := PlanChange{
+		PlanCode: target.Code, PlanName: target.Name, BillingPeriod: current.BillingPeriod,
+		Quantity: quantity, MemberLimit: SubscriptionMemberLimit(target, quantity),
+		Immediate: targetPrice > currentPrice, PeriodEnd: current.PeriodEnd,
+		RenewalAmount: targetPrice, Currency: target.Currency, PriceVersion: target.PriceVersion,
+	}
+	switch {
+	case target.Code == currentPlan.Code && quantity > currentQuantity:
+		change.Kind = PlanChangeSeatsAdded
+	case target.Code == currentPlan.Code:
+		change.Kind = PlanChangeSeatsRemoved
+	case change.Immediate:
+		change.Kind = PlanChangeUpgrade
+	default:
+		change.Kind = PlanChangeDowngrade
+	}
+	if !change.Immediate {
+		change.EffectiveAt = current.PeriodEnd
+		return change, nil
+	}
+	change.EffectiveAt = now
+	change.RemainingFraction = remainingFraction(current.PeriodStart, current.PeriodEnd, now)
+	change.Charge = roundMoney(targetPrice * change.RemainingFraction)
+	change.Credit = roundMoney(current.Amount * change.RemainingFraction)
+	change.AmountDue = math.Max(0, roundMoney(change.Charge-change.Credit))
+	return change, nil
+}
+
+func remainingFraction(start, end, now time.Time) float64 {
+	total := end.Sub(start)
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(1, math.Max(0, float64(end.Sub(now))/float64(total)))
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// prorateWeeklyLimit blends the old and new weekly limits by how much of the
+// current quota window each plan covers, so an upgrade late in the week does
+// not hand out a whole new week of tokens and a downgrade does not take back
+// what was already granted.
+func prorateWeeklyLimit(oldLimit, newLimit int, windowStart, windowEnd, now time.Time) int {
+	remaining := remainingFraction(windowStart, windowEnd, now)
+	return int(math.Round(float64(oldLimit)*(1-remaining) + float64(newLimit)*remaining))
+}
+
+func (s *Service) SubscriptionTerms(ctx context.Context, workspaceID int) (SubscriptionTerms, error) {
+	var terms SubscriptionTerms
+	var status string
+	var memberLimit sql.NullInt64
+	var periodStart, periodEnd sql.NullTime
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT subscription.status, COALESCE(subscription.plan_code, ''),
+			COALESCE(NULLIF(subscription.billing_period, ''), 'monthly'), subscription.member_limit,
+			COALESCE(subscription.amount, 0), COALESCE(subscription.payment_method, ''),
+			subscription.current_period_start, subscription.current_period_end,
+			subscription.pending_plan_code IS NOT NULL, COALESCE(subscription.price_version_id, 0)
+		FROM subscriptions subscription
+		JOIN workspaces workspace ON workspace.id=$1
+		WHERE subscription.workspace_id=$1 OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id)
+		ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END, subscription.updated_at DESC
+		LIMIT 1
+	`, workspaceID).Scan(&status, &terms.PlanCode, &terms.BillingPeriod, &memberLimit, &terms.Amount,
+		&terms.PaymentMethod, &periodStart, &periodEnd, &terms.Pending, &terms.PriceVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SubscriptionTerms{}, ErrPlanChangeUnavailable
+	}
+	if err != nil {
+		return SubscriptionTerms{}, err
+	}
+	terms.MemberLimit = int(memberLimit.Int64)
+	terms.PeriodStart = periodStart.Time
+	terms.PeriodEnd = periodEnd.Time
+	terms.Active = (status == "active" || status == "cancelled") && periodStart.Valid && periodEnd.Valid
+	return terms, nil
+}
+
+// PreviewPlanChange prices a change without applying it. Moving to fewer
+// seats than members and pending invitations already hold is refused.
+func (s *Service) PreviewPlanChange(ctx context.Context, workspaceID int, planCode string, quantity int) (PlanChange, error) {
+	target, err := WorkspacePlan(ctx, s.dbx, workspaceID, planCode)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	if target.PerSeatPricing && quantity < 1 {
+		return PlanChange{}, errors.New("billing_quantity_invalid")
+	}
+	terms, err := s.SubscriptionTerms(ctx, workspaceID)
+	if err != nil {
+		return PlanChange{}, err
+	}
+	change, err := ProratePlanChange(terms, target, quantity, time.Now().UTC())
+	if err != nil {
+		return PlanChange{}, err
+	}
+	if change.MemberLimit > 0 {
+		var reserved int
+		if err := s.dbx.QueryRowContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM workspace_memberships WHERE workspace_id=$1 AND status='active')
+				+
+				(SELECT COUNT(*) FROM workspace_invitations
+				 WHERE workspace_id=$1 AND status='pending' AND expires_at>NOW())
+		`, workspaceID).Scan(&reserved); err != nil {
+			return PlanChange{}, err
+		}
+		if reserved > change.MemberLimit {
+			return PlanChange{}, ErrSeatsBelowMembers
+		}
+	}
+	return change, nil
+}
+
+// SchedulePlanChange queues a cheaper plan or fewer seats for the next
+// period. Unlike a pending plan, which is already paid for, a scheduled
+// change is only taken up by the renewal payment for that period.
+func (s *Service) SchedulePlanChange(ctx context.Context, workspaceID int, change PlanChange) error {
+	if change.Immediate {
+		return errors.New("plan_change_is_immediate")
+	}
+	result, err := s.dbx.ExecContext(ctx, `
+		UPDATE subscriptions subscription SET scheduled_plan_code=$2, scheduled_amount=$3,
+			scheduled_member_limit=$4, scheduled_price_version_id=NULLIF($6, 0), updated_at=NOW()
+		FROM workspaces workspace
+		WHERE workspace.id=$1
+			AND (subscription.workspace_id=$1 OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id))
+			AND subscription.current_period_end=$5 AND subscription.pending_plan_code IS NULL
+	`, workspaceID, change.PlanCode, change.RenewalAmount, change.MemberLimit, change.PeriodEnd, change.PriceVersion)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return errors.Join(ErrPlanChangeUnavailable, err)
+	}
+	return nil
+}
+
+// ApplyPlanChange switches the plan in place when nothing is due. The paid
+// period and the quota window stay as they are; ensureQuota prorates the
+// weekly limit for the rest of the window.
+func (s *Service) ApplyPlanChange(ctx context.Context, workspaceID int, change PlanChange) error {
+	if !change.Immediate || change.AmountDue > 0 {
+		return errors.New("plan_change_requires_payment")
+	}
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var ownerUserID int
+	if err := tx.QueryRowContext(ctx, `SELECT owner_user_id FROM workspaces WHERE id=$1`, workspaceID).Scan(&ownerUserID); err != nil {
+		return err
+	}
+	if err := applyPlanChange(
+		ctx, tx, workspaceID, ownerUserID, change.PlanCode, change.PriceVersion, change.Quantity,
+		change.RenewalAmount, change.PeriodEnd,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyPlanChange moves the subscription to the paid plan. It returns
+// ErrPlanChangeUnavailable if the period the change was priced for has
+// already ended, so a late payment cannot rewrite the next period; card
+// orders and invoices for a plan change stop being payable at that period's
+// end, before anything reaches this point.
+func applyPlanChange(ctx context.Context, tx *sql.Tx, workspaceID, ownerUserID int, planCode string, priceVersion int64, quantity int, renewalAmount float64, periodEnd time.Time) error {
+	plan, err := PlanAtVersion(planCode, priceVersion)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET plan_code=$2, plan_name=$3, amount=$4, member_limit=$5,
+			price_version_id=NULLIF($8, 0), scheduled_plan_code=NULL, scheduled_amount=NULL,
+			scheduled_member_limit=NULL, scheduled_price_version_id=NULL, updated_at=NOW()
+		WHERE (workspace_id=$1 OR (workspace_id IS NULL AND user_id=$6))
+			AND current_period_end=$7
+	`, workspaceID, plan.Code, plan.Name, renewalAmount, SubscriptionMemberLimit(plan, quantity), ownerUserID,
+		periodEnd, plan.PriceVersion)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return errors.Join(ErrPlanChangeUnavailable, err)
+	}
+	return nil
+}
+
+// CancelScheduledPlanChange keeps the current plan for the next period.
+func (s *Service) CancelScheduledPlanChange(ctx context.Context, workspaceID int) error {
+	_, err := s.dbx.ExecContext(ctx, `
+		UPDATE subscriptions subscription SET scheduled_plan_code=NULL, scheduled_amount=NULL,
+			scheduled_member_limit=NULL, scheduled_price_version_id=NULL, updated_at=NOW()
+		FROM workspaces workspace
+		WHERE workspace.id=$1
+			AND (subscription.workspace_id=$1 OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id))
+			AND subscription.scheduled_plan_code IS NOT NULL
+	`, workspaceID)
+	return err
+}
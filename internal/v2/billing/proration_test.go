@@ -0,0 +1,159 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProratePlanChange(t *testing.T) {
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	halfway := time.Date(2026, 9, 16, 0, 0, 0, 0, time.UTC)
+	founder := SubscriptionTerms{
+		PlanCode: PlanFounder, BillingPeriod: PeriodMonthly, MemberLimit: 1, Amount: 3490,
+		PeriodStart: start, PeriodEnd: end, Active: true,
+	}
+	team := SubscriptionTerms{
+		PlanCode: PlanTeam, BillingPeriod: PeriodMonthly, MemberLimit: 5, Amount: 11990,
+		PeriodStart: start, PeriodEnd: end, Active: true,
+	}
+	startSeats := SubscriptionTerms{
+		PlanCode: PlanStart, BillingPeriod: PeriodMonthly, MemberLimit: 3, Amount: 870,
+		PeriodStart: start, PeriodEnd: end, Active: true,
+	}
+	tests := []struct {
+		name      string
+		current   SubscriptionTerms
+		target    string
+		quantity  int
+		want      PlanChange
+		immediate bool
+	}{
+		{
+			name: "upgrade credits the unused half", current: founder, target: PlanTeam, quantity: 1,
+			immediate: true,
+			want: PlanChange{
+				Kind: PlanChangeUpgrade, MemberLimit: 5, RemainingFraction: 0.5,
+				Charge: 5995, Credit: 1745, AmountDue: 4250, RenewalAmount: 11990,
+			},
+		},
+		{
+			name: "downgrade waits for the period end", current: team, target: PlanFounder, quantity: 1,
+			want: PlanChange{Kind: PlanChangeDowngrade, MemberLimit: 1, RenewalAmount: 3490},
+		},
+		{
+			name: "added seats are charged for the rest of the period", current: startSeats, target: PlanStart,
+			quantity: 5, immediate: true,
+			want: PlanChange{
+				Kind: PlanChangeSeatsAdded, MemberLimit: 5, RemainingFraction: 0.5,
+				Charge: 725, Credit: 435, AmountDue: 290, RenewalAmount: 1450,
+			},
+		},
+		{
+			name: "removed seats apply from the next period", current: startSeats, target: PlanStart, quantity: 2,
+			want: PlanChange{Kind: PlanChangeSeatsRemoved, MemberLimit: 2, RenewalAmount: 580},
+		},
+		{
+			name: "credit above the charge leaves nothing due",
+			current: SubscriptionTerms{
+				PlanCode: PlanFounder, BillingPeriod: PeriodMonthly, MemberLimit: 1, Amount: 20000,
+				PeriodStart: start, PeriodEnd: end, Active: true,
+			},
+			target: PlanTeam, quantity: 1, immediate: true,
+			want: PlanChange{
+				Kind: PlanChangeUpgrade, MemberLimit: 5, RemainingFraction: 0.5,
+				Charge: 5995, Credit: 10000, AmountDue: 0, RenewalAmount: 11990,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := PlanByCode(test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ProratePlanChange(test.current, target, test.quantity, halfway)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.want
+			want.PlanCode, want.PlanName, want.Currency = target.Code, target.Name, target.Currency
+			want.BillingPeriod, want.PeriodEnd, want.Immediate = PeriodMonthly, end, test.immediate
+			want.Quantity = test.quantity
+			want.EffectiveAt = end
+			if test.immediate {
+				want.EffectiveAt = halfway
+			}
+			if got != want {
+				t.Fatalf("ProratePlanChange = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProratePlanChangeRejects(t *testing.T) {
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 9, 16, 0, 0, 0, 0, time.UTC)
+	active := SubscriptionTerms{
+		PlanCode: PlanFounder, BillingPeriod: PeriodMonthly, MemberLimit: 1, Amount: 3490,
+		PeriodStart: start, PeriodEnd: end, Active: true,
+	}
+	tests := []struct {
+		name    string
+		current func(SubscriptionTerms) SubscriptionTerms
+		target  string
+		want    error
+	}{
+		{
+			name:    "inactive",
+			current: func(terms SubscriptionTerms) SubscriptionTerms { terms.Active = false; return terms },
+			target:  PlanTeam, want: ErrPlanChangeUnavailable,
+		},
+		{
+			name:    "period over",
+			current: func(terms SubscriptionTerms) SubscriptionTerms { terms.PeriodEnd = now; return terms },
+			target:  PlanTeam, want: ErrPlanChangeUnavailable,
+		},
+		{
+			name:    "next plan already paid",
+			current: func(terms SubscriptionTerms) SubscriptionTerms { terms.Pending = true; return terms },
+			target:  PlanTeam, want: ErrPendingPlanChange,
+		},
+		{name: "same plan", target: PlanFounder, want: ErrPlanChangeNoop},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := active
+			if test.current != nil {
+				current = test.current(current)
+			}
+			target, _ := PlanByCode(test.target)
+			if _, err := ProratePlanChange(current, target, 1, now); !errors.Is(err, test.want) {
+				t.Fatalf("ProratePlanChange error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestProrateWeeklyLimit(t *testing.T) {
+	windowStart := time.Date(2026, 9, 14, 0, 0, 0, 0, time.UTC)
+	windowEnd := windowStart.Add(7 * 24 * time.Hour)
+	tests := []struct {
+		name     string
+		now      time.Time
+		old, new int
+		want     int
+	}{
+		{"start of window takes the new limit", windowStart, 1_250_000, 3_000_000, 3_000_000},
+		{"upgrade mid-week", windowStart.Add(84 * time.Hour), 1_250_000, 3_000_000, 2_125_000},
+		{"downgrade mid-week", windowStart.Add(84 * time.Hour), 3_000_000, 1_250_000, 2_125_000},
+		{"end of window keeps the old limit", windowEnd, 1_250_000, 3_000_000, 1_250_000},
+	}
+	for _, test := range tests {
+		if got := prorateWeeklyLimit(test.old, test.new, windowStart, windowEnd, test.now); got != test.want {
+			t.Fatalf("%s: prorateWeeklyLimit = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
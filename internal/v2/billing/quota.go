@@ -18,6 +18,10 @@ import (
 var ErrQuotaExceeded = errors.New("ai_weekly_limit_reached")
 var ErrPaymentRequired = errors.New("payment_required")
 
+// ErrAIReadOnly means the workspace's subscription lapsed: it keeps
+// read-only access to its data, AI calls wait for payment.
+var ErrAIReadOnly = errors.New("subscription_read_only_ai")
+
 const maxChatReservationTokens = 75_000
 
 const settleReservationSQL = `
@@ -64,6 +68,7 @@ type Reservation struct {
 type Service struct {
 	dbx     *sql.DB
 	enforce bool
+	dunning DunningPolicy
 }
 
 func NewService(dbx *sql.DB, enforcement ...bool) *Service {
@@ -104,7 +109,7 @@ func (s *Service) Reserve(ctx context.Context, workspaceID, userID int, module s
 		return Reservation{}, errors.New("subscription_state_unavailable")
 	}
 	if s.enforce {
-		allowed, err := s.hasAIEntitlement(ctx, workspaceID, time.Now().UTC())
+		allowed, lapsed, err := s.hasAIEntitlement(ctx, workspaceID, time.Now().UTC())
 		if err != nil {
 			return Reservation{}, err
 		}
@@ -114,6 +119,9 @@ func (s *Service) Reserve(ctx context.Context, workspaceID, userID int, module s
 				return Reservation{}, err
 			}
 		}
+		if !allowed && lapsed {
+			return Reservation{}, ErrAIReadOnly
+		}
 		if !allowed {
 			return Reservation{}, ErrPaymentRequired
 		}
@@ -127,8 +135,22 @@ func (s *Service) Reserve(ctx context.Context, workspaceID, userID int, module s
 	if err != nil {
 		return Reservation{}, errors.New("ai_quota_state_unavailable")
 	}
+	requested := maxChatReservationTokens
+	allocations, err := applicableAllocations(ctx, tx, state, workspaceID, userID, module)
+	if err != nil {
+		return Reservation{}, errors.New("ai_quota_allocation_unavailable")
+	}
+	if headroom, capped := allocationHeadroom(allocations); capped {
+		if headroom <= 0 && state.available() {
+			if err := tx.Commit(); err != nil {
+				return Reservation{}, err
+			}
+			return Reservation{}, ErrAllocationExceeded
+		}
+		requested = min(requested, headroom)
+	}
 
-	reservation := reserveQuotaTokens(&state, maxChatReservationTokens)
+	reservation := reserveQuotaTokens(&state, requested)
 	if reservation.total <= 0 {
 		if err := tx.Commit(); err != nil {
 			return Reservation{}, err
@@ -181,7 +203,9 @@ func (s *Service) onboardingPending(ctx context.Context, workspaceID int) (bool,
 	return workspaces.OnboardingPending(ctx, s.dbx, workspaceID)
 }
 
-func (s *Service) hasAIEntitlement(ctx context.Context, workspaceID int, now time.Time) (bool, error) {
+// hasAIEntitlement reports whether the subscription covers AI calls and, if
+// not, whether it is a lapsed one rather than none at all.
+func (s *Service) hasAIEntitlement(ctx context.Context, workspaceID int, now time.Time) (bool, bool, error) {
 	var status string
 	var periodEnd, graceUntil sql.NullTime
 	err := s.dbx.QueryRowContext(ctx, `
@@ -197,20 +221,23 @@ func (s *Service) hasAIEntitlement(ctx context.Context, workspaceID int, now tim
 		LIMIT 1
 	`, workspaceID).Scan(&status, &periodEnd, &graceUntil)
 	if errors.Is(err, sql.ErrNoRows) {
-		return false, nil
+		return false, false, nil
 	}
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
+	lapsed := status != "inactive" || periodEnd.Valid
 	switch status {
 	case "active", "trial_active":
-		return true, nil
+		return true, false, nil
 	case "cancelled":
-		return periodEnd.Valid && now.Before(periodEnd.Time), nil
+		allowed := periodEnd.Valid && now.Before(periodEnd.Time)
+		return allowed, !allowed, nil
 	case "past_due":
-		return graceUntil.Valid && now.Before(graceUntil.Time), nil
+		allowed := graceUntil.Valid && now.Before(graceUntil.Time)
+		return allowed, !allowed, nil
 	default:
-		return false, nil
+		return false, lapsed, nil
 	}
 }
 
@@ -356,15 +383,16 @@ func (s *Service) ensureQuota(ctx context.Context, tx *sql.Tx, workspaceID int,
 	}
 	var storedPlan string
 	var storedStart, storedEnd time.Time
+	var proratedUntil sql.NullTime
 	err = tx.QueryRowContext(ctx, `
 		SELECT plan_code, window_started_at, window_ends_at, base_limit, base_used, purchased_balance,
-			purchased_reset_balance
+			purchased_reset_balance, base_limit_prorated_until
 		FROM workspace_ai_quotas
 		WHERE workspace_id=$1
 		FOR UPDATE
 	`, workspaceID).Scan(
 		&storedPlan, &storedStart, &storedEnd, &state.baseLimit, &state.baseUsed, &state.purchasedBalance,
-		&state.purchasedResets,
+		&state.purchasedResets, &proratedUntil,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		_, err = tx.ExecContext(ctx, `
@@ -382,12 +410,22 @@ func (s *Service) ensureQuota(ctx context.Context, tx *sql.Tx, workspaceID int,
 
 	windowChanged := !storedStart.Equal(windowStart) || !storedEnd.Equal(windowEnd)
 	storedBaseLimit := state.baseLimit
-	planChanged := storedPlan != plan.Code || storedBaseLimit != plan.WeeklyTokenLimit
+	// A limit prorated after a mid-week plan change stays until the window ends.
+	proratedWindow := !windowChanged && proratedUntil.Valid && proratedUntil.Time.Equal(storedEnd)
+	planChanged := storedPlan != plan.Code || (storedBaseLimit != plan.WeeklyTokenLimit && !proratedWindow)
 	state.planCode = plan.Code
 	state.windowStartedAt = windowStart
 	state.windowEndsAt = windowEnd
 	state.baseLimit = plan.WeeklyTokenLimit
-	legacyUnits := storedPlan == plan.Code && isLegacyMessageLimit(storedPlan, storedBaseLimit)
+	legacyUnits := storedPlan == plan.Code && !proratedWindow && isLegacyMessageLimit(storedPlan, storedBaseLimit)
+	var limitProratedUntil sql.NullTime
+	if !windowChanged && storedPlan != plan.Code {
+		state.baseLimit = prorateWeeklyLimit(storedBaseLimit, plan.WeeklyTokenLimit, windowStart, windowEnd, now)
+		limitProratedUntil = sql.NullTime{Time: windowEnd, Valid: true}
+	} else if proratedWindow && storedPlan == plan.Code {
+		state.baseLimit = storedBaseLimit
+		limitProratedUntil = proratedUntil
+	}
 	if legacyUnits {
 		state.purchasedBalance = scaleQuotaUnits(state.purchasedBalance, storedBaseLimit, plan.WeeklyTokenLimit)
 	}
@@ -401,9 +439,10 @@ func (s *Service) ensureQuota(ctx context.Context, tx *sql.Tx, workspaceID int,
 			UPDATE workspace_ai_quotas
 			SET plan_code=$2, window_started_at=$3, window_ends_at=$4,
 				base_limit=$5, base_used=$6, purchased_balance=$7,
-				warning_level=0, updated_at=NOW()
+				base_limit_prorated_until=$8, warning_level=0, updated_at=NOW()
 			WHERE workspace_id=$1
-		`, workspaceID, plan.Code, windowStart, windowEnd, plan.WeeklyTokenLimit, state.baseUsed, state.purchasedBalance)
+		`, workspaceID, plan.Code, windowStart, windowEnd, state.baseLimit, state.baseUsed, state.purchasedBalance,
+			limitProratedUntil)
 		if err != nil {
 			return quotaState{}, err
 		}
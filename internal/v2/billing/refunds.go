@@ -0,0 +1,523 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	RefundViaProvider = "provider"
+	RefundViaBank     = "bank"
+)
+
+var ErrRefundPaymentNotFound = errors.New("billing_payment_not_found")
+var ErrRefundNotAllowed = errors.New("billing_payment_not_refundable")
+var ErrRefundAmountInvalid = errors.New("refund_amount_invalid")
+var ErrRefundMethodInvalid = errors.New("refund_method_invalid")
+var ErrRefundProviderUnavailable = errors.New("refund_provider_unavailable")
+var ErrRefundProviderFailed = errors.New("refund_provider_failed")
+var ErrRefundDeclined = errors.New("refund_declined")
+var ErrRefundPending = errors.New("refund_pending")
+
+// PaymentRefunder returns card money through the provider that took the
+// payment, and stops the provider's recurrent subscription when a refund
+// ends the paid period. RefundPayment wraps ErrRefundDeclined when the
+// provider refused the refund, so nothing was returned.
+type PaymentRefunder interface {
+	RefundPayment(ctx context.Context, provider, transactionID string, amount float64, currency, idempotenceKey string) (string, error)
+	CancelRecurring(ctx context.Context, provider, subscriptionID string) error
+}
+
+// CreditNoteIssuer renders the credit note of a refund payment. The closing
+// documents runner issues the ones a refund could not.
+type CreditNoteIssuer interface {
+	IssueCreditNote(ctx context.Context, refundPaymentID int64) (int64, error)
+}
+
+// RefundInput is an admin refund of a paid payment. A zero amount refunds
+// what is left of it. Method "provider" returns card money through the
+// payment provider, "bank" records a refund already sent by bank transfer.
+// EndSubscription ends the paid period at once whatever the amount.
+type RefundInput struct {
+	PaymentID       int64   `json:"payment_id"`
+	Amount          float64 `json:"amount"`
+	Method          string  `json:"method"`
+	Reason          string  `json:"reason"`
+	RefundedBy      string  `json:"refunded_by"`
+	EndSubscription bool    `json:"end_subscription"`
+}
+
+type RefundResult struct {
+	RefundPaymentID   int64      `json:"refund_payment_id"`
+	PaymentID         int64      `json:"payment_id"`
+	Amount            float64    `json:"amount"`
+	Currency          string     `json:"currency"`
+	Method            string     `json:"method"`
+	Provider          string     `json:"provider"`
+	ExternalID        string     `json:"external_id,omitempty"`
+	Remaining         float64    `json:"remaining"`
+	SubscriptionEnded bool       `json:"subscription_ended"`
+	PeriodEnd         *time.Time `json:"period_end,omitempty"`
+	ResetsClawedBack  int        `json:"resets_clawed_back"`
+	CreditNoteID      int64      `json:"credit_note_id,omitempty"`
+
+	recurringProvider string
+	recurringID       string
+}
+
+type refundedPayment struct {
+	WorkspaceID   int
+	OwnerUserID   int
+	InvoiceID     sql.NullInt64
+	Provider      string
+	ExternalID    string
+	Method        string
+	Amount        float64
+	Currency      string
+	Status        string
+	OrderKind     string
+	PlanCode      string
+	BillingPeriod string
+	PeriodStart   sql.NullTime
+	PeriodEnd     sql.NullTime
+	Refunded      float64
+	Resets        int
+}
+
+// refundRow is a negative payment: the refund of a paid payment, pending
+// while the provider is being asked for it.
+type refundRow struct {
+	id         int64
+	amount     float64
+	key        string
+	cutStart   *time.Time
+	cutEnd     *time.Time
+	reason     string
+	refundedBy string
+}
+
+// RefundPayment refunds a paid payment in full or in part. It writes a
+// negative payment, shortens or ends the subscription period the payment
+// bought, takes back purchased resets that were not used yet, and logs the
+// refund to the quota events. A card refund is recorded as pending before
+// the provider is called and finished after it answers, so a refund the
+// provider made is never lost to a rollback: retrying the payment's refund
+// resumes the pending one with the same idempotence key.
+func (s *Service) RefundPayment(ctx context.Context, input RefundInput, refunder PaymentRefunder) (RefundResult, error) {
+	input.Method = strings.TrimSpace(input.Method)
+	input.Reason = strings.TrimSpace(input.Reason)
+	input.RefundedBy = strings.TrimSpace(input.RefundedBy)
+	if input.RefundedBy == "" {
+		input.RefundedBy = "manual"
+	}
+	if input.PaymentID <= 0 || input.Amount < 0 || math.IsNaN(input.Amount) || math.IsInf(input.Amount, 0) {
+		return RefundResult{}, ErrRefundAmountInvalid
+	}
+	// A refund started with the provider is finished even if the admin
+	// request goes away meanwhile.
+	ctx = context.WithoutCancel(ctx)
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockRefundedPayment(ctx, tx, input.PaymentID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if payment.Status != "paid" || payment.Amount <= 0 {
+		return RefundResult{}, ErrRefundNotAllowed
+	}
+	var pending refundRow
+	var cutStart, cutEnd sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, -amount, provider_request_key, period_start, period_end, refund_reason, refunded_by
+		FROM workspace_billing_payments
+		WHERE refunded_payment_id=$1 AND status='refund_pending'
+	`, input.PaymentID).Scan(&pending.id, &pending.amount, &pending.key, &cutStart, &cutEnd, &pending.reason, &pending.refundedBy)
+	switch {
+	case err == nil:
+		if (input.Method != "" && input.Method != RefundViaProvider) ||
+			(input.Amount != 0 && roundMoney(input.Amount) != pending.amount) {
+			return RefundResult{}, ErrRefundPending
+		}
+		pending.cutStart, pending.cutEnd = nullTime(cutStart), nullTime(cutEnd)
+		if err := tx.Commit(); err != nil {
+			return RefundResult{}, err
+		}
+		return s.finishProviderRefund(ctx, input, pending, refunder)
+	case !errors.Is(err, sql.ErrNoRows):
+		return RefundResult{}, err
+	}
+
+	amount, err := refundAmount(input.Amount, payment.Amount, payment.Refunded)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if input.Method == "" {
+		input.Method = RefundViaBank
+		if payment.Method == "card" {
+			input.Method = RefundViaProvider
+		}
+	}
+	refund := refundRow{amount: amount, reason: input.Reason, refundedBy: input.RefundedBy}
+	// The negative payment covers the part of the period it gives back,
+	// taken from the end of what earlier refunds left.
+	if payment.PeriodStart.Valid && payment.PeriodEnd.Valid {
+		start, end := payment.PeriodStart.Time, payment.PeriodEnd.Time
+		left := end.Add(-refundPeriodCut(start, end, payment.Amount, 0, payment.Refunded))
+		given := left.Add(-refundPeriodCut(start, end, payment.Amount, payment.Refunded, amount))
+		refund.cutStart, refund.cutEnd = &given, &left
+	}
+
+	switch input.Method {
+	case RefundViaProvider:
+		if payment.Method != "card" || payment.ExternalID == "" || refunder == nil {
+			return RefundResult{}, ErrRefundProviderUnavailable
+		}
+		if err := insertRefund(ctx, tx, input.PaymentID, payment, refund, payment.Provider, "", "card", "refund_pending", nil).Scan(&refund.id); err != nil {
+			return RefundResult{}, err
+		}
+		// The key names the pending row, so a refund tried again after a
+		// decline is not answered with the provider's stored decline.
+		refund.key = fmt.Sprintf("refund-%d-%d", input.PaymentID, refund.id)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE workspace_billing_payments SET provider_request_key=$2 WHERE id=$1
+		`, refund.id, refund.key); err != nil {
+			return RefundResult{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefundResult{}, err
+		}
+		return s.finishProviderRefund(ctx, input, refund, refunder)
+	case RefundViaBank:
+	default:
+		return RefundResult{}, ErrRefundMethodInvalid
+	}
+
+	now := time.Now().UTC()
+	if err := insertRefund(ctx, tx, input.PaymentID, payment, refund, "manual", "", "bank_transfer", "refunded", &now).Scan(&refund.id); err != nil {
+		return RefundResult{}, err
+	}
+	result := RefundResult{
+		RefundPaymentID: refund.id, PaymentID: input.PaymentID, Amount: amount, Currency: payment.Currency,
+		Method: RefundViaBank, Provider: "manual", Remaining: roundMoney(payment.Amount - payment.Refunded - amount),
+	}
+	if err := applyRefund(ctx, tx, payment, refund, payment.Refunded, input, now, &result); err != nil {
+		return RefundResult{}, err
+	}
+	return result, tx.Commit()
+}
+
+// finishProviderRefund asks the provider for a pending card refund and
+// records it as made. A refund the provider refused is dropped; any other
+// failure leaves it pending for a retry, which sends the same idempotence
+// key, so the provider never refunds it twice.
+func (s *Service) finishProviderRefund(ctx context.Context, input RefundInput, refund refundRow, refunder PaymentRefunder) (RefundResult, error) {
+	if refunder == nil {
+		return RefundResult{}, ErrRefundProviderUnavailable
+	}
+	var provider, transactionID, currency string
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT provider, external_id, currency FROM workspace_billing_payments WHERE id=$1
+	`, input.PaymentID).Scan(&provider, &transactionID, &currency); err != nil {
+		return RefundResult{}, err
+	}
+	externalID, err := refunder.RefundPayment(ctx, provider, transactionID, refund.amount, currency, refund.key)
+	if err != nil {
+		if errors.Is(err, ErrRefundDeclined) {
+			if _, dropErr := s.dbx.ExecContext(ctx, `
+				DELETE FROM workspace_billing_payments WHERE id=$1 AND status='refund_pending'
+			`, refund.id); dropErr != nil {
+				return RefundResult{}, dropErr
+			}
+		}
+		return RefundResult{}, fmt.Errorf("%w: %v", ErrRefundProviderFailed, err)
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	defer tx.Rollback()
+	payment, err := lockRefundedPayment(ctx, tx, input.PaymentID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	now := time.Now().UTC()
+	finished, err := tx.ExecContext(ctx, `
+		UPDATE workspace_billing_payments SET status='refunded', external_id=$2, paid_at=$3, updated_at=NOW()
+		WHERE id=$1 AND status='refund_pending'
+	`, refund.id, externalID, now)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	count, err := finished.RowsAffected()
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if count == 0 {
+		// Another retry finished it first.
+		return RefundResult{}, ErrRefundPending
+	}
+	result := RefundResult{
+		RefundPaymentID: refund.id, PaymentID: input.PaymentID, Amount: refund.amount, Currency: payment.Currency,
+		Method: RefundViaProvider, Provider: payment.Provider, ExternalID: externalID,
+		Remaining: roundMoney(payment.Amount - payment.Refunded),
+	}
+	// The pending refund is the newest one of the payment: only one may be
+	// pending, and no other refund is made while it is.
+	if err := applyRefund(ctx, tx, payment, refund, roundMoney(payment.Refunded-refund.amount), input, now, &result); err != nil {
+		return RefundResult{}, err
+	}
+	return result, tx.Commit()
+}
+
+// lockRefundedPayment reads a payment with what its refunds took back so
+// far, and locks it, so two refunds of one payment cannot both pass the
+// balance check.
+func lockRefundedPayment(ctx context.Context, tx *sql.Tx, paymentID int64) (refundedPayment, error) {
+	var payment refundedPayment
+	err := tx.QueryRowContext(ctx, `
+		SELECT payment.workspace_id, workspace.owner_user_id, payment.invoice_id, payment.provider,
+			payment.external_id, payment.method, payment.amount, payment.currency, payment.status,
+			payment.order_kind, payment.plan_code, payment.billing_period,
+			payment.period_start, payment.period_end,
+			COALESCE((
+				SELECT -SUM(refund.amount) FROM workspace_billing_payments refund
+				WHERE refund.refunded_payment_id=payment.id
+			), 0),
+			COALESCE((
+				SELECT billing_order.quantity FROM workspace_billing_orders billing_order
+				LEFT JOIN workspace_billing_invoices invoice ON invoice.order_id=billing_order.id
+				WHERE invoice.id=payment.invoice_id OR (
+					payment.external_id <> '' AND billing_order.provider=payment.provider
+					AND billing_order.external_id=payment.external_id
+				)
+				LIMIT 1
+			), 1)
+		FROM workspace_billing_payments payment
+		JOIN workspaces workspace ON workspace.id=payment.workspace_id
+		WHERE payment.id=$1
+		FOR UPDATE OF payment
+	`, paymentID).Scan(
+		&payment.WorkspaceID, &payment.OwnerUserID, &payment.InvoiceID, &payment.Provider,
+		&payment.ExternalID, &payment.Method, &payment.Amount, &payment.Currency, &payment.Status,
+		&payment.OrderKind, &payment.PlanCode, &payment.BillingPeriod, &payment.PeriodStart,
+		&payment.PeriodEnd, &payment.Refunded, &payment.Resets,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return refundedPayment{}, ErrRefundPaymentNotFound
+	}
+	return payment, err
+}
+
+func insertRefund(
+	ctx context.Context, tx *sql.Tx, paymentID int64, payment refundedPayment, refund refundRow,
+	provider, externalID, method, status string, paidAt *time.Time,
+) *sql.Row {
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO workspace_billing_payments (
+			workspace_id, invoice_id, provider, external_id, method, amount, currency, status,
+			paid_at, order_kind, plan_code, billing_period, period_start, period_end,
+			refunded_payment_id, refund_reason, refunded_by, provider_request_key
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)
+		RETURNING id
+	`, payment.WorkspaceID, payment.InvoiceID, provider, externalID, method, -refund.amount,
+		payment.Currency, status, paidAt, payment.OrderKind, payment.PlanCode, payment.BillingPeriod,
+		refund.cutStart, refund.cutEnd, paymentID, refund.reason, refund.refundedBy, refund.key)
+}
+
+// applyRefund takes what a recorded refund gives back off the subscription
+// or the purchased resets and logs it to the quota events.
+func applyRefund(
+	ctx context.Context, tx *sql.Tx, payment refundedPayment, refund refundRow, refundedBefore float64,
+	input RefundInput, now time.Time, result *RefundResult,
+) error {
+	metadata := map[string]any{
+		"payment_id": input.PaymentID, "refund_payment_id": refund.id,
+		"amount": refund.amount, "currency": payment.Currency, "method": result.Method,
+		"order_kind": payment.OrderKind, "reason": refund.reason, "refunded_by": refund.refundedBy,
+	}
+	switch payment.OrderKind {
+	case OrderSubscription, OrderPlanChange:
+		if err := refundSubscriptionPeriod(ctx, tx, payment, refund.cutStart, refund.cutEnd, input.EndSubscription, now, result); err != nil {
+			return err
+		}
+		metadata["subscription_ended"] = result.SubscriptionEnded
+		if result.PeriodEnd != nil {
+			metadata["period_end"] = result.PeriodEnd
+		}
+	case OrderQuotaReset:
+		refunded := refundedResets(payment.Resets, payment.Amount, refundedBefore, refund.amount)
+		if err := tx.QueryRowContext(ctx, `
+			WITH previous AS (
+				SELECT purchased_reset_balance FROM workspace_ai_quotas WHERE workspace_id=$1 FOR UPDATE
+			)
+			UPDATE workspace_ai_quotas quota
+			SET purchased_reset_balance=quota.purchased_reset_balance - LEAST(quota.purchased_reset_balance, $2),
+				updated_at=NOW()
+			FROM previous
+			WHERE quota.workspace_id=$1
+			RETURNING LEAST(previous.purchased_reset_balance, $2)
+		`, payment.WorkspaceID, refunded).Scan(&result.ResetsClawedBack); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		metadata["resets_refunded"], metadata["resets_clawed_back"] = refunded, result.ResetsClawedBack
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_ai_quota_events (
+			workspace_id, reservation_key, event_type, source, amount, status, ai_module, metadata_json, settled_at
+		) VALUES ($1,$2,'payment_refunded','purchased',$3,'refunded','billing',$4::jsonb,NOW())
+	`, payment.WorkspaceID, fmt.Sprintf("refund-%d", refund.id), result.ResetsClawedBack, string(data))
+	return err
+}
+
+// refundSubscriptionPeriod takes the refunded share of a paid period off the
+// subscription. A period paid in advance shrinks, or is dropped once fully
+// refunded; the current period shrinks and ends when nothing of it is left.
+// A plan change payment only pays the difference for the rest of the
+// period, so its refunds change the period only when the admin ends it.
+func refundSubscriptionPeriod(
+	ctx context.Context, tx *sql.Tx, payment refundedPayment, cutStart, cutEnd *time.Time,
+	end bool, now time.Time, result *RefundResult,
+) error {
+	var subscriptionID int
+	var provider, recurringID string
+	var currentEnd, pendingStart, pendingEnd sql.NullTime
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, current_period_end, pending_period_start, pending_period_end,
+			COALESCE(payment_provider, ''), COALESCE(cloudpayments_subscription_id, '')
+		FROM subscriptions
+		WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$2)
+		ORDER BY CASE WHEN workspace_id=$1 THEN 0 ELSE 1 END, updated_at DESC LIMIT 1
+		FOR UPDATE
+	`, payment.WorkspaceID, payment.OwnerUserID).Scan(
+		&subscriptionID, &currentEnd, &pendingStart, &pendingEnd, &provider, &recurringID,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cut time.Duration
+	if payment.OrderKind == OrderSubscription && cutStart != nil && cutEnd.After(now) {
+		cut = cutEnd.Sub(*cutStart)
+	}
+	if !end && cut > 0 && pendingStart.Valid && pendingEnd.Valid && !payment.PeriodStart.Time.Before(pendingStart.Time) {
+		if remaining := pendingEnd.Time.Add(-cut); remaining.After(pendingStart.Time) {
+			result.PeriodEnd = &remaining
+			_, err = tx.ExecContext(ctx, `
+				UPDATE subscriptions SET pending_period_end=$2, updated_at=NOW() WHERE id=$1
+			`, subscriptionID, remaining)
+			return err
+		}
+		if currentEnd.Valid {
+			result.PeriodEnd = &currentEnd.Time
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions SET pending_plan_code=NULL, pending_plan_name='', pending_billing_period='',
+				pending_amount=NULL, pending_member_limit=NULL, pending_period_start=NULL,
+				pending_period_end=NULL, pending_price_version_id=NULL, updated_at=NOW()
+			WHERE id=$1
+		`, subscriptionID)
+		return err
+	}
+	if (!end && cut == 0) || !currentEnd.Valid || !currentEnd.Time.After(now) {
+		return nil
+	}
+	if remaining := currentEnd.Time.Add(-cut); !end && remaining.After(now) {
+		result.PeriodEnd = &remaining
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions SET current_period_end=$2, next_payment_at=$2, updated_at=NOW() WHERE id=$1
+		`, subscriptionID, remaining)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE subscriptions SET status='expired', current_period_end=$2, next_payment_at=NULL,
+			cancelled_at=COALESCE(cancelled_at, $2), cloudpayments_subscription_id=NULL,
+			pending_plan_code=NULL, pending_plan_name='', pending_billing_period='', pending_amount=NULL,
+			pending_member_limit=NULL, pending_period_start=NULL, pending_period_end=NULL,
+			pending_price_version_id=NULL, scheduled_plan_code=NULL, scheduled_amount=NULL,
+			scheduled_member_limit=NULL, scheduled_price_version_id=NULL, updated_at=NOW()
+		WHERE id=$1
+	`, subscriptionID, now); err != nil {
+		return err
+	}
+	// Nothing is left to collect for a refunded period.
+	var caseID int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE billing_dunning_cases
+		SET status='expired', resolved_at=NOW(), next_retry_at=NULL, next_email_at=NULL, updated_at=NOW()
+		WHERE workspace_id=$1 AND status='open'
+		RETURNING id
+	`, payment.WorkspaceID).Scan(&caseID)
+	switch {
+	case err == nil:
+		if err := addDunningEvent(ctx, tx, caseID, payment.WorkspaceID, "expired", "payment_refunded"); err != nil {
+			return err
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	result.SubscriptionEnded, result.PeriodEnd = true, &now
+	result.recurringProvider, result.recurringID = provider, recurringID
+	return nil
+}
+
+// refundAmount checks a requested refund against what is left of the
+// payment; zero asks for all of it.
+func refundAmount(requested, paid, refunded float64) (float64, error) {
+	left := roundMoney(paid - refunded)
+	if left <= 0 {
+		return 0, ErrRefundNotAllowed
+	}
+	if requested == 0 {
+		return left, nil
+	}
+	requested = roundMoney(requested)
+	if requested <= 0 || requested > left+0.009 {
+		return 0, ErrRefundAmountInvalid
+	}
+	return math.Min(requested, left), nil
+}
+
+// refundPeriodCut is the part of a paid period the refund gives back, in
+// proportion to the amount. Cuts of consecutive partial refunds add up to
+// the whole period once the payment is fully refunded.
+func refundPeriodCut(start, end time.Time, paid, refundedBefore, refund float64) time.Duration {
+	if paid <= 0 || !end.After(start) {
+		return 0
+	}
+	length := float64(end.Sub(start))
+	before := time.Duration(length * math.Min(1, refundedBefore/paid))
+	after := time.Duration(length * math.Min(1, (refundedBefore+refund)/paid))
+	return after - before
+}
+
+// refundedResets is how many of the resets a payment bought its refund
+// covers. Partial refunds round to whole resets, so the sum over all the
+// refunds of a payment is what it bought.
+func refundedResets(bought int, paid, refundedBefore, refund float64) int {
+	if bought <= 0 || paid <= 0 {
+		return 0
+	}
+	share := func(refunded float64) int {
+		return int(math.Round(float64(bought) * math.Min(1, refunded/paid)))
+	}
+	return share(refundedBefore+refund) - share(refundedBefore)
+}
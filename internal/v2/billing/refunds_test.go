@@ -0,0 +1,68 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefundAmount(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested float64
+		refunded  float64
+		want      float64
+		err       error
+	}{
+		{name: "zero refunds the rest", refunded: 1000, want: 2490},
+		{name: "partial", requested: 490.004, want: 490},
+		{name: "the whole rest", requested: 2490, refunded: 1000, want: 2490},
+		{name: "a kopeck above the rest", requested: 2490.01, refunded: 1000, err: ErrRefundAmountInvalid},
+		{name: "above the rest", requested: 2500, refunded: 1000, err: ErrRefundAmountInvalid},
+		{name: "fully refunded", refunded: 3490, err: ErrRefundNotAllowed},
+	}
+	for _, test := range tests {
+		got, err := refundAmount(test.requested, 3490, test.refunded)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Fatalf("%s: refundAmount = %v, %v; want %v, %v", test.name, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRefundPeriodCut(t *testing.T) {
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 30)
+	if got := refundPeriodCut(start, end, 3000, 0, 1000); got != 10*24*time.Hour {
+		t.Fatalf("first third = %v", got)
+	}
+	total := refundPeriodCut(start, end, 3000, 0, 1000) + refundPeriodCut(start, end, 3000, 1000, 1000) +
+		refundPeriodCut(start, end, 3000, 2000, 1000)
+	if total != end.Sub(start) {
+		t.Fatalf("partial cuts add up to %v, want %v", total, end.Sub(start))
+	}
+	if got := refundPeriodCut(start, start, 3000, 0, 3000); got != 0 {
+		t.Fatalf("empty period cut = %v", got)
+	}
+}
+
+func TestRefundedResets(t *testing.T) {
+	tests := []struct {
+		name           string
+		bought         int
+		refundedBefore float64
+		refund         float64
+		want           int
+	}{
+		{name: "full refund", bought: 3, refund: 3000, want: 3},
+		{name: "one of three", bought: 3, refund: 1000, want: 1},
+		{name: "rounds to whole resets", bought: 1, refund: 400, want: 0},
+		{name: "rest of a partial refund", bought: 1, refundedBefore: 400, refund: 2600, want: 1},
+		{name: "legacy payment without order", bought: 0, refund: 3000, want: 0},
+	}
+	for _, test := range tests {
+		paid := 3000.0
+		if got := refundedResets(test.bought, paid, test.refundedBefore, test.refund); got != test.want {
+			t.Fatalf("%s: refundedResets = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
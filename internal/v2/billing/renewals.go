@@ -0,0 +1,145 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrRenewalNotDue = errors.New("billing_renewal_not_due")
+
+// DueRenewals lists the active card subscriptions of a provider without
+// recurrent subscriptions of its own whose paid period is over. A
+// subscription in dunning or with a renewal order for the current period
+// is left out: the dunning retries or the order's payment take it from
+// there.
+func (s *Service) DueRenewals(ctx context.Context, provider string, limit int) ([]int, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT subscription.id
+		FROM subscriptions subscription
+		WHERE subscription.payment_provider=$1 AND subscription.payment_method='card'
+			AND subscription.status='active'
+			AND COALESCE(subscription.pending_period_end, subscription.current_period_end) <= NOW()
+			AND COALESCE(subscription.cloudpayments_token, '') <> ''
+			AND NOT EXISTS (
+				SELECT 1 FROM billing_dunning_cases dunning
+				WHERE dunning.subscription_id=subscription.id AND dunning.status='open'
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM workspace_billing_orders billing_order
+				WHERE billing_order.idempotency_key='renewal-' || subscription.id || '-' ||
+					FLOOR(EXTRACT(EPOCH FROM subscription.current_period_end))::bigint
+			)
+		ORDER BY subscription.current_period_end
+		LIMIT $2
+	`, provider, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// PrepareRenewal creates the order a renewal charge pays. A plan already
+// paid for the next period starts first, and a scheduled downgrade takes
+// effect with the renewal, as with provider-side recurrent subscriptions.
+func (s *Service) PrepareRenewal(ctx context.Context, subscriptionID int) (DunningCharge, error) {
+	var workspaceID sql.NullInt64
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT COALESCE(subscription.workspace_id, (
+			SELECT workspace.id FROM workspaces workspace
+			WHERE workspace.owner_user_id=subscription.user_id AND workspace.status='active'
+			ORDER BY workspace.created_at LIMIT 1
+		))
+		FROM subscriptions subscription WHERE subscription.id=$1
+	`, subscriptionID).Scan(&workspaceID); err != nil {
+		return DunningCharge{}, err
+	}
+	if !workspaceID.Valid {
+		return DunningCharge{}, ErrRenewalNotDue
+	}
+	if err := s.ApplyPendingSubscription(ctx, int(workspaceID.Int64)); err != nil {
+		return DunningCharge{}, err
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return DunningCharge{}, err
+	}
+	defer tx.Rollback()
+
+	charge := DunningCharge{WorkspaceID: int(workspaceID.Int64)}
+	var planCode, period, status string
+	var priceVersion int64
+	var memberLimit int
+	var periodEnd sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT workspace.owner_user_id, owner.email, subscription.status, subscription.current_period_end,
+			COALESCE(subscription.scheduled_plan_code, subscription.plan_code),
+			COALESCE(subscription.scheduled_price_version_id, subscription.price_version_id, 0),
+			subscription.billing_period,
+			COALESCE(subscription.scheduled_amount, subscription.amount),
+			COALESCE(subscription.scheduled_member_limit, subscription.member_limit),
+			subscription.currency, subscription.payment_provider,
+			COALESCE(subscription.cloudpayments_token, '')
+		FROM subscriptions subscription
+		JOIN workspaces workspace ON workspace.id=$2
+		JOIN users owner ON owner.id=workspace.owner_user_id
+		WHERE subscription.id=$1
+		FOR UPDATE OF subscription
+	`, subscriptionID, charge.WorkspaceID).Scan(
+		&charge.UserID, &charge.Email, &status, &periodEnd, &planCode, &priceVersion, &period,
+		&charge.Amount, &memberLimit, &charge.Currency, &charge.Provider, &charge.Token,
+	)
+	if err != nil {
+		return DunningCharge{}, err
+	}
+	if status != "active" || !periodEnd.Valid || periodEnd.Time.After(time.Now().UTC()) {
+		return DunningCharge{}, ErrRenewalNotDue
+	}
+	if strings.TrimSpace(charge.Token) == "" || charge.Amount <= 0 {
+		return DunningCharge{}, ErrDunningRetryUnavailable
+	}
+	if err := insertChargeOrder(
+		ctx, tx, &charge, planCode, priceVersion, period, memberLimit,
+		fmt.Sprintf("renewal-%d-%d", subscriptionID, periodEnd.Time.Unix()),
+		"renewal_subscription_id", int64(subscriptionID),
+	); err != nil {
+		return DunningCharge{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return DunningCharge{}, err
+	}
+	return charge, nil
+}
+
+// FailRenewal cancels the order of a declined renewal and starts dunning
+// for the subscription.
+func (s *Service) FailRenewal(ctx context.Context, subscriptionID int, orderID int64, failure error) error {
+	detail := ""
+	if failure != nil {
+		detail = failure.Error()
+	}
+	if orderID > 0 {
+		if _, err := s.dbx.ExecContext(ctx, `
+			UPDATE workspace_billing_orders
+			SET status='cancelled', metadata_json=metadata_json || jsonb_build_object('failure', $2::text),
+				updated_at=NOW()
+			WHERE id=$1 AND status='waiting'
+		`, orderID, detail); err != nil {
+			return err
+		}
+	}
+	return s.OpenDunning(ctx, subscriptionID, detail)
+}
@@ -0,0 +1,301 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrStatementPaymentNotFound = errors.New("statement_payment_not_found")
+var ErrStatementResolutionInvalid = errors.New("statement_resolution_invalid")
+
+type StatementImport struct {
+	ID         int64  `json:"id"`
+	Format     string `json:"format"`
+	Payments   int    `json:"payments"`
+	Confirmed  int    `json:"confirmed"`
+	Review     int    `json:"review"`
+	Duplicates int    `json:"duplicates"`
+	Outgoing   int    `json:"outgoing"`
+}
+
+type StatementReviewItem struct {
+	ID                     int64      `json:"id"`
+	ImportID               int64      `json:"import_id"`
+	DocumentNumber         string     `json:"document_number"`
+	PaymentDate            *time.Time `json:"payment_date,omitempty"`
+	Amount                 float64    `json:"amount"`
+	PayerName              string     `json:"payer_name"`
+	PayerINN               string     `json:"payer_inn"`
+	Purpose                string     `json:"purpose"`
+	Reason                 string     `json:"reason"`
+	SuggestedInvoiceID     *int64     `json:"suggested_invoice_id,omitempty"`
+	SuggestedInvoiceNumber string     `json:"suggested_invoice_number,omitempty"`
+	CreatedAt              time.Time  `json:"created_at"`
+}
+
+// ImportBankStatement records the incoming payments of a statement, confirms
+// invoices that match exactly and queues the rest for review. Payments seen
+// in an earlier import are counted as duplicates and left as they were.
+func (s *Service) ImportBankStatement(ctx context.Context, fileName, importedBy string, data []byte) (StatementImport, error) {
+	format, payments, err := ParseBankStatement(data)
+	if err != nil {
+		return StatementImport{}, err
+	}
+	var sellerINN, sellerAccount string
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT inn, settlement_account FROM billing_seller_profiles WHERE id=1
+	`).Scan(&sellerINN, &sellerAccount); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return StatementImport{}, err
+	}
+
+	result := StatementImport{Format: format}
+	if err := s.dbx.QueryRowContext(ctx, `
+		INSERT INTO billing_statement_imports (file_name, format, imported_by)
+		VALUES ($1,$2,$3)
+		RETURNING id
+	`, strings.TrimSpace(fileName), format, strings.TrimSpace(importedBy)).Scan(&result.ID); err != nil {
+		return StatementImport{}, err
+	}
+
+	for _, payment := range payments {
+		if !payment.incomingTo(sellerINN, sellerAccount) {
+			result.Outgoing++
+			continue
+		}
+		result.Payments++
+		match, err := s.matchStatementPayment(ctx, payment)
+		if err != nil {
+			return result, err
+		}
+		var paymentID int64
+		err = s.dbx.QueryRowContext(ctx, `
+			INSERT INTO billing_statement_payments (
+				import_id, fingerprint, document_number, payment_date, amount,
+				payer_name, payer_inn, payer_account, purpose, status,
+				suggested_invoice_id, review_reason
+			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,'review',$10,$11)
+			ON CONFLICT (fingerprint) DO NOTHING
+			RETURNING id
+		`, result.ID, payment.Fingerprint(), payment.DocumentNumber, payment.Date, payment.Amount,
+			payment.PayerName, payment.PayerINN, payment.PayerAccount, payment.Purpose,
+			nullableInvoiceID(match.Suggested), match.ReviewCause).Scan(&paymentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			result.Duplicates++
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if !match.Confirm {
+			result.Review++
+			continue
+		}
+		if err := s.ConfirmInvoicePayment(ctx, match.InvoiceID, statementConfirmation(paymentID)); err != nil {
+			if _, err := s.dbx.ExecContext(ctx, `
+				UPDATE billing_statement_payments
+				SET suggested_invoice_id=$2, review_reason=$3, updated_at=NOW()
+				WHERE id=$1
+			`, paymentID, match.InvoiceID, ReviewConfirmationFailed); err != nil {
+				return result, err
+			}
+			result.Review++
+			continue
+		}
+		if _, err := s.dbx.ExecContext(ctx, `
+			UPDATE billing_statement_payments
+			SET status='confirmed', invoice_id=$2, review_reason='', updated_at=NOW()
+			WHERE id=$1
+		`, paymentID, match.InvoiceID); err != nil {
+			return result, err
+		}
+		result.Confirmed++
+	}
+
+	_, err = s.dbx.ExecContext(ctx, `
+		UPDATE billing_statement_imports
+		SET payments_count=$2, confirmed_count=$3, review_count=$4, duplicate_count=$5
+		WHERE id=$1
+	`, result.ID, result.Payments, result.Confirmed, result.Review, result.Duplicates)
+	return result, err
+}
+
+func (s *Service) matchStatementPayment(ctx context.Context, payment StatementPayment) (statementMatch, error) {
+	numbers := payment.InvoiceNumbers()
+	named := []statementInvoice{}
+	if len(numbers) == 1 {
+		invoices, err := s.statementInvoices(ctx, `invoice.number=$1`, numbers[0])
+		if err != nil {
+			return statementMatch{}, err
+		}
+		named = invoices
+	}
+	suggestions := []statementInvoice{}
+	if len(numbers) == 0 && payment.PayerINN != "" {
+		invoices, err := s.statementInvoices(ctx, `
+			invoice.status='waiting' AND invoice.amount=$1
+			AND NOT (invoice.order_kind='plan_change' AND invoice.due_at <= NOW())
+			AND (invoice.organization_snapshot->>'inn'=$2 OR organization.inn=$2)
+		`, payment.Amount, payment.PayerINN)
+		if err != nil {
+			return statementMatch{}, err
+		}
+		suggestions = invoices
+	}
+	return matchStatementPayment(payment, named, suggestions), nil
+}
+
+func (s *Service) statementInvoices(ctx context.Context, condition string, args ...any) ([]statementInvoice, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT invoice.id, invoice.number, invoice.amount,
+			CASE WHEN invoice.status='waiting' AND invoice.order_kind='plan_change' AND invoice.due_at <= NOW()
+				THEN 'expired' ELSE invoice.status END,
+			COALESCE(invoice.organization_snapshot->>'inn', ''),
+			COALESCE(organization.inn, '')
+		FROM workspace_billing_invoices invoice
+		LEFT JOIN workspace_billing_organizations organization
+			ON organization.workspace_id=invoice.workspace_id
+		WHERE `+condition+`
+		ORDER BY invoice.id
+		LIMIT 2
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invoices := []statementInvoice{}
+	for rows.Next() {
+		var invoice statementInvoice
+		var snapshotINN, currentINN string
+		if err := rows.Scan(&invoice.ID, &invoice.Number, &invoice.Amount, &invoice.Status, &snapshotINN, &currentINN); err != nil {
+			return nil, err
+		}
+		invoice.PayerINN = []string{snapshotINN, currentINN}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+func (s *Service) StatementReviewQueue(ctx context.Context, limit int) ([]StatementReviewItem, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 200
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT payment.id, payment.import_id, payment.document_number, payment.payment_date,
+			payment.amount, payment.payer_name, payment.payer_inn, payment.purpose,
+			payment.review_reason, payment.suggested_invoice_id,
+			COALESCE(invoice.number, ''), payment.created_at
+		FROM billing_statement_payments payment
+		LEFT JOIN workspace_billing_invoices invoice ON invoice.id=payment.suggested_invoice_id
+		WHERE payment.status='review'
+		ORDER BY payment.created_at, payment.id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []StatementReviewItem{}
+	for rows.Next() {
+		var item StatementReviewItem
+		var paymentDate sql.NullTime
+		var suggested sql.NullInt64
+		if err := rows.Scan(
+			&item.ID, &item.ImportID, &item.DocumentNumber, &paymentDate,
+			&item.Amount, &item.PayerName, &item.PayerINN, &item.Purpose,
+			&item.Reason, &suggested, &item.SuggestedInvoiceNumber, &item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if paymentDate.Valid {
+			item.PaymentDate = &paymentDate.Time
+		}
+		if suggested.Valid {
+			item.SuggestedInvoiceID = &suggested.Int64
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ResolveStatementPayment settles a queued payment by hand: "confirm" pays
+// the given invoice, or the suggested one when none is given; "ignore"
+// drops the payment from the queue. The payment is claimed before the
+// invoice is confirmed so two admins cannot apply it twice.
+func (s *Service) ResolveStatementPayment(ctx context.Context, paymentID int64, action string, invoiceID int64, resolvedBy string) error {
+	resolvedBy = strings.TrimSpace(resolvedBy)
+	if resolvedBy == "" {
+		resolvedBy = "manual"
+	}
+	switch action {
+	case "ignore":
+		result, err := s.dbx.ExecContext(ctx, `
+			UPDATE billing_statement_payments
+			SET status='ignored', resolved_by=$2, resolved_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND status='review'
+		`, paymentID, resolvedBy)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return errors.Join(ErrStatementPaymentNotFound, err)
+		}
+		return nil
+	case "confirm":
+	default:
+		return ErrStatementResolutionInvalid
+	}
+
+	var suggested sql.NullInt64
+	err := s.dbx.QueryRowContext(ctx, `
+		UPDATE billing_statement_payments
+		SET status='resolved', resolved_by=$2, resolved_at=NOW(), updated_at=NOW()
+		WHERE id=$1 AND status='review'
+		RETURNING suggested_invoice_id
+	`, paymentID, resolvedBy).Scan(&suggested)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrStatementPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if invoiceID <= 0 {
+		invoiceID = suggested.Int64
+	}
+	var status string
+	err = s.dbx.QueryRowContext(ctx, `SELECT status FROM workspace_billing_invoices WHERE id=$1`, invoiceID).Scan(&status)
+	if err == nil && status != "waiting" {
+		err = errors.New("invoice_not_payable")
+	}
+	if err == nil {
+		err = s.ConfirmInvoicePayment(ctx, invoiceID, statementConfirmation(paymentID))
+	}
+	if err != nil {
+		if _, releaseErr := s.dbx.ExecContext(ctx, `
+			UPDATE billing_statement_payments
+			SET status='review', resolved_by='', resolved_at=NULL, updated_at=NOW()
+			WHERE id=$1
+		`, paymentID); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrStatementResolutionInvalid
+		}
+		return err
+	}
+	_, err = s.dbx.ExecContext(ctx, `
+		UPDATE billing_statement_payments SET invoice_id=$2, updated_at=NOW() WHERE id=$1
+	`, paymentID, invoiceID)
+	return err
+}
+
+func statementConfirmation(paymentID int64) string {
+	return fmt.Sprintf("bank_statement:%d", paymentID)
+}
+
+func nullableInvoiceID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
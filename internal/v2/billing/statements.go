@@ -0,0 +1,356 @@
+package billing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	StatementFormat1C  = "1c"
+	StatementFormatCSV = "csv"
+)
+
+const (
+	ReviewInvoiceNumberMissing = "invoice_number_missing"
+	ReviewInvoiceNotFound      = "invoice_not_found"
+	ReviewMultipleInvoices     = "multiple_invoices"
+	ReviewInvoiceAlreadyPaid   = "invoice_already_paid"
+	ReviewInvoiceNotPayable    = "invoice_not_payable"
+	ReviewAmountMismatch       = "amount_mismatch"
+	ReviewPayerINNMissing      = "payer_inn_missing"
+	ReviewPayerINNMismatch     = "payer_inn_mismatch"
+	ReviewConfirmationFailed   = "confirmation_failed"
+)
+
+var ErrStatementEmpty = errors.New("bank_statement_empty")
+var ErrStatementFormat = errors.New("bank_statement_format_invalid")
+
+var invoiceNumberPattern = regexp.MustCompile(`(?i)REUP[\s-]*(\d{4})[\s-]*(\d{6})`)
+
+// StatementPayment is one payment order from a bank statement.
+type StatementPayment struct {
+	DocumentNumber   string     `json:"document_number"`
+	Date             *time.Time `json:"date,omitempty"`
+	Amount           float64    `json:"amount"`
+	PayerName        string     `json:"payer_name"`
+	PayerINN         string     `json:"payer_inn"`
+	PayerAccount     string     `json:"payer_account"`
+	RecipientINN     string     `json:"recipient_inn"`
+	RecipientAccount string     `json:"recipient_account"`
+	Purpose          string     `json:"purpose"`
+}
+
+// Fingerprint identifies the payment across imports, so the same statement
+// or overlapping periods can be uploaded again without paying twice.
+func (p StatementPayment) Fingerprint() string {
+	date := ""
+	if p.Date != nil {
+		date = p.Date.Format("2006-01-02")
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		p.DocumentNumber, date, strconv.FormatFloat(p.Amount, 'f', 2, 64),
+		p.PayerINN, p.PayerAccount, p.Purpose,
+	}, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// incomingTo reports whether the payment credits the seller. A statement
+// lists outgoing orders too; CSV exports without recipient columns are
+// taken to hold credits only.
+func (p StatementPayment) incomingTo(sellerINN, sellerAccount string) bool {
+	if p.Amount <= 0 {
+		return false
+	}
+	if p.RecipientINN == "" && p.RecipientAccount == "" {
+		return true
+	}
+	return (sellerINN != "" && p.RecipientINN == sellerINN) ||
+		(sellerAccount != "" && p.RecipientAccount == sellerAccount)
+}
+
+// InvoiceNumbers returns the distinct invoice numbers named in the purpose,
+// normalised to the REUP-YYYY-NNNNNN form they are issued with.
+func (p StatementPayment) InvoiceNumbers() []string {
+	numbers := []string{}
+	seen := map[string]bool{}
+	for _, match := range invoiceNumberPattern.FindAllStringSubmatch(p.Purpose, -1) {
+		number := "REUP-" + match[1] + "-" + match[2]
+		if !seen[number] {
+			seen[number] = true
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+// ParseBankStatement reads a 1C ClientBankExchange file or a CSV export. Both
+// are accepted in UTF-8 or Windows-1251, which is what most banks send.
+func ParseBankStatement(data []byte) (string, []StatementPayment, error) {
+	text := decodeStatementText(data)
+	if strings.TrimSpace(text) == "" {
+		return "", nil, ErrStatementEmpty
+	}
+	if strings.HasPrefix(strings.TrimSpace(text), "1CClientBankExchange") {
+		payments, err := parseClientBankExchange(text)
+		return StatementFormat1C, payments, err
+	}
+	payments, err := parseStatementCSV(text)
+	return StatementFormatCSV, payments, err
+}
+
+func parseClientBankExchange(text string) ([]StatementPayment, error) {
+	payments := []StatementPayment{}
+	var fields map[string]string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, _ := strings.Cut(line, "=")
+		switch {
+		case key == "СекцияДокумент":
+			fields = map[string]string{}
+		case line == "КонецДокумента":
+			if fields == nil {
+				return nil, ErrStatementFormat
+			}
+			payment, err := clientBankPayment(fields)
+			if err != nil {
+				return nil, err
+			}
+			payments = append(payments, payment)
+			fields = nil
+		case fields != nil && key != "":
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if fields != nil {
+		return nil, ErrStatementFormat
+	}
+	return payments, nil
+}
+
+func clientBankPayment(fields map[string]string) (StatementPayment, error) {
+	amount, err := parseStatementAmount(fields["Сумма"])
+	if err != nil {
+		return StatementPayment{}, fmt.Errorf("%w: document %s amount", ErrStatementFormat, fields["Номер"])
+	}
+	payerName := fields["Плательщик1"]
+	if payerName == "" {
+		payerName = fields["Плательщик"]
+	}
+	date := parseStatementDate(fields["ДатаПоступило"])
+	if date == nil {
+		date = parseStatementDate(fields["Дата"])
+	}
+	return StatementPayment{
+		DocumentNumber: fields["Номер"], Date: date, Amount: amount,
+		PayerName: payerName, PayerINN: fields["ПлательщикИНН"], PayerAccount: fields["ПлательщикСчет"],
+		RecipientINN: fields["ПолучательИНН"], RecipientAccount: fields["ПолучательСчет"],
+		Purpose: fields["НазначениеПлатежа"],
+	}, nil
+}
+
+var statementCSVColumns = map[string][]string{
+	"number":            {"number", "document_number", "номер", "номер документа", "№ документа"},
+	"date":              {"date", "дата", "дата операции", "дата поступления"},
+	"amount":            {"amount", "credit", "сумма", "сумма прихода", "приход", "кредит"},
+	"payer_name":        {"payer", "payer_name", "плательщик", "наименование плательщика", "контрагент"},
+	"payer_inn":         {"payer_inn", "инн плательщика", "инн контрагента", "инн"},
+	"payer_account":     {"payer_account", "счет плательщика", "счёт плательщика", "счет контрагента", "счёт контрагента"},
+	"recipient_inn":     {"recipient_inn", "инн получателя"},
+	"recipient_account": {"recipient_account", "счет получателя", "счёт получателя"},
+	"purpose":           {"purpose", "назначение", "назначение платежа"},
+}
+
+func parseStatementCSV(text string) ([]StatementPayment, error) {
+	firstLine, _, _ := strings.Cut(text, "\n")
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = ','
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrStatementFormat
+	}
+	columns := map[string]int{}
+	for index, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, aliases := range statementCSVColumns {
+			for _, alias := range aliases {
+				if _, taken := columns[field]; !taken && name == alias {
+					columns[field] = index
+				}
+			}
+		}
+	}
+	if _, ok := columns["amount"]; !ok {
+		return nil, fmt.Errorf("%w: amount column is required", ErrStatementFormat)
+	}
+	if _, ok := columns["purpose"]; !ok {
+		return nil, fmt.Errorf("%w: purpose column is required", ErrStatementFormat)
+	}
+
+	payments := []StatementPayment{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d", ErrStatementFormat, line)
+		}
+		value := func(field string) string {
+			index, ok := columns[field]
+			if !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+		if value("amount") == "" {
+			continue
+		}
+		amount, err := parseStatementAmount(value("amount"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d amount", ErrStatementFormat, line)
+		}
+		payments = append(payments, StatementPayment{
+			DocumentNumber: value("number"), Date: parseStatementDate(value("date")), Amount: amount,
+			PayerName: value("payer_name"), PayerINN: value("payer_inn"), PayerAccount: value("payer_account"),
+			RecipientINN: value("recipient_inn"), RecipientAccount: value("recipient_account"),
+			Purpose: value("purpose"),
+		})
+	}
+	return payments, nil
+}
+
+func parseStatementAmount(value string) (float64, error) {
+	value = strings.NewReplacer(" ", "", " ", "", " ", "").Replace(strings.TrimSpace(value))
+	if strings.Contains(value, ",") && strings.Contains(value, ".") {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, ErrStatementFormat
+	}
+	return math.Round(amount*100) / 100, nil
+}
+
+func parseStatementDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"02.01.2006", "2006-01-02", "02.01.2006 15:04:05", "02.01.2006 15:04", "02/01/2006"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
+
+func decodeStatementText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return strings.ReplaceAll(string(data), "\r", "")
+	}
+	var builder strings.Builder
+	builder.Grow(len(data) * 2)
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			builder.WriteByte(b)
+		case b >= 0xC0:
+			builder.WriteRune(rune(0x0410 + int(b) - 0xC0))
+		default:
+			builder.WriteRune(windows1251High[b-0x80])
+		}
+	}
+	return strings.ReplaceAll(builder.String(), "\r", "")
+}
+
+// windows1251High maps 0x80-0xBF; the letters from 0xC0 follow the Unicode
+// Cyrillic block in order.
+var windows1251High = [64]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', '�', '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	' ', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '­', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+}
+
+type statementInvoice struct {
+	ID       int64
+	Number   string
+	Amount   float64
+	Status   string
+	PayerINN []string
+}
+
+type statementMatch struct {
+	InvoiceID   int64
+	Confirm     bool
+	Suggested   int64
+	ReviewCause string
+}
+
+// matchStatementPayment confirms only an exact match: a single invoice named
+// in the purpose, still waiting, for the same amount, paid from the INN of
+// the organization it was issued to. Anything else goes to review with the
+// first reason found. Suggestions are waiting invoices with the same amount
+// and payer INN, used when the purpose names no invoice.
+func matchStatementPayment(payment StatementPayment, named []statementInvoice, suggestions []statementInvoice) statementMatch {
+	numbers := payment.InvoiceNumbers()
+	if len(numbers) == 0 {
+		match := statementMatch{ReviewCause: ReviewInvoiceNumberMissing}
+		if len(suggestions) == 1 {
+			match.Suggested = suggestions[0].ID
+		}
+		return match
+	}
+	if len(numbers) > 1 {
+		return statementMatch{ReviewCause: ReviewMultipleInvoices}
+	}
+	var invoice *statementInvoice
+	for index := range named {
+		if named[index].Number == numbers[0] {
+			invoice = &named[index]
+		}
+	}
+	if invoice == nil {
+		return statementMatch{ReviewCause: ReviewInvoiceNotFound}
+	}
+	match := statementMatch{Suggested: invoice.ID}
+	switch {
+	case invoice.Status == "paid":
+		match.ReviewCause = ReviewInvoiceAlreadyPaid
+	case invoice.Status != "waiting":
+		match.ReviewCause = ReviewInvoiceNotPayable
+	case math.Abs(invoice.Amount-payment.Amount) > 0.009:
+		match.ReviewCause = ReviewAmountMismatch
+	case payment.PayerINN == "":
+		match.ReviewCause = ReviewPayerINNMissing
+	case !containsINN(invoice.PayerINN, payment.PayerINN):
+		match.ReviewCause = ReviewPayerINNMismatch
+	default:
+		return statementMatch{InvoiceID: invoice.ID, Confirm: true}
+	}
+	return match
+}
+
+func containsINN(values []string, inn string) bool {
+	for _, value := range values {
+		if value != "" && strings.TrimSpace(value) == inn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package billing
+
+import (
+	"strings"
+	"testing"
+)
+
+const clientBankStatement = `1CClientBankExchange
+ВерсияФормата=1.03
+Кодировка=Windows
+РасчСчет=40702810110001489655
+СекцияДокумент=Платежное поручение
+Номер=512
+Дата=14.08.2026
+Сумма=3 490,00
+ПлательщикСчет=40702810900000012345
+Плательщик1=ООО "Вектор"
+ПлательщикИНН=7701234567
+ПолучательСчет=40702810110001489655
+ПолучательИНН=5262392668
+НазначениеПлатежа=Оплата по счету REUP-2026-000042 от 10.08.2026. Без НДС
+ДатаПоступило=15.08.2026
+КонецДокумента
+СекцияДокумент=Платежное поручение
+Номер=77
+Дата=15.08.2026
+Сумма=1200.00
+ПлательщикСчет=40702810110001489655
+ПлательщикИНН=5262392668
+ПолучательСчет=40702810500000000001
+ПолучательИНН=7700000001
+НазначениеПлатежа=Аренда за август
+КонецДокумента
+КонецФайла
+`
+
+func TestParseClientBankExchange(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"utf-8":        []byte(clientBankStatement),
+		"windows-1251": encodeWindows1251(t, clientBankStatement),
+	} {
+		t.Run(name, func(t *testing.T) {
+			format, payments, err := ParseBankStatement(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != StatementFormat1C || len(payments) != 2 {
+				t.Fatalf("ParseBankStatement = %s, %d payments", format, len(payments))
+			}
+			payment := payments[0]
+			if payment.Amount != 3490 || payment.PayerINN != "7701234567" || payment.PayerName != `ООО "Вектор"` {
+				t.Fatalf("payment = %+v", payment)
+			}
+			if payment.Date == nil || payment.Date.Format("2006-01-02") != "2026-08-15" {
+				t.Fatalf("payment date = %v, want the date it was received", payment.Date)
+			}
+			if numbers := payment.InvoiceNumbers(); len(numbers) != 1 || numbers[0] != "REUP-2026-000042" {
+				t.Fatalf("InvoiceNumbers = %v", numbers)
+			}
+			if !payment.incomingTo("5262392668", "40702810110001489655") {
+				t.Fatal("payment to the seller is not incoming")
+			}
+			if payments[1].incomingTo("5262392668", "40702810110001489655") {
+				t.Fatal("payment from the seller is incoming")
+			}
+		})
+	}
+}
+
+func TestParseClientBankExchangeRejectsUnterminatedDocument(t *testing.T) {
+	data := "1CClientBankExchange\nСекцияДокумент=Платежное поручение\nСумма=10\n"
+	if _, _, err := ParseBankStatement([]byte(data)); err == nil {
+		t.Fatal("unterminated document was accepted")
+	}
+}
+
+func TestParseStatementCSV(t *testing.T) {
+	data := "\ufeffДата;Номер документа;Сумма прихода;Плательщик;ИНН плательщика;Назначение платежа\n" +
+		"15.08.2026;512;\"3 490,00\";ООО Вектор;7701234567;оплата сч. reup 2026 000042\n" +
+		"15.08.2026;513;;ООО Вектор;7701234567;списание\n"
+	format, payments, err := ParseBankStatement([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != StatementFormatCSV || len(payments) != 1 {
+		t.Fatalf("ParseBankStatement = %s, %+v", format, payments)
+	}
+	payment := payments[0]
+	if payment.Amount != 3490 || payment.PayerINN != "7701234567" || payment.DocumentNumber != "512" {
+		t.Fatalf("payment = %+v", payment)
+	}
+	if numbers := payment.InvoiceNumbers(); len(numbers) != 1 || numbers[0] != "REUP-2026-000042" {
+		t.Fatalf("InvoiceNumbers = %v", numbers)
+	}
+	if !payment.incomingTo("5262392668", "40702810110001489655") {
+		t.Fatal("CSV credit without recipient columns is not incoming")
+	}
+}
+
+func TestParseStatementCSVRequiresAmountAndPurpose(t *testing.T) {
+	if _, _, err := ParseBankStatement([]byte("date,amount\n2026-08-15,10\n")); err == nil {
+		t.Fatal("CSV without a purpose column was accepted")
+	}
+}
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := map[string]float64{
+		"3490":       3490,
+		"3 490,00":   3490,
+		"3490.5":     3490.5,
+		"1,234.56":   1234.56,
+		"12 345,678": 12345.68,
+	}
+	for value, want := range tests {
+		if got, err := parseStatementAmount(value); err != nil || got != want {
+			t.Fatalf("parseStatementAmount(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseStatementAmount("три тысячи"); err == nil {
+		t.Fatal("non-numeric amount was accepted")
+	}
+}
+
+func TestMatchStatementPayment(t *testing.T) {
+	payment := StatementPayment{Amount: 3490, PayerINN: "7701234567", Purpose: "Оплата по счету REUP-2026-000042"}
+	waiting := statementInvoice{ID: 42, Number: "REUP-2026-000042", Amount: 3490, Status: "waiting", PayerINN: []string{"7701234567", ""}}
+	tests := []struct {
+		name        string
+		payment     func(StatementPayment) StatementPayment
+		invoice     func(statementInvoice) statementInvoice
+		suggestions []statementInvoice
+		want        statementMatch
+	}{
+		{name: "exact", want: statementMatch{InvoiceID: 42, Confirm: true}},
+		{
+			name: "organization changed its requisites after invoicing",
+			invoice: func(invoice statementInvoice) statementInvoice {
+				invoice.PayerINN = []string{"7709999999", "7701234567"}
+				return invoice
+			},
+			want: statementMatch{InvoiceID: 42, Confirm: true},
+		},
+		{
+			name:    "amount differs",
+			payment: func(payment StatementPayment) StatementPayment { payment.Amount = 3000; return payment },
+			want:    statementMatch{Suggested: 42, ReviewCause: ReviewAmountMismatch},
+		},
+		{
+			name:    "another payer",
+			payment: func(payment StatementPayment) StatementPayment { payment.PayerINN = "7800000000"; return payment },
+			want:    statementMatch{Suggested: 42, ReviewCause: ReviewPayerINNMismatch},
+		},
+		{
+			name:    "payer without INN",
+			payment: func(payment StatementPayment) StatementPayment { payment.PayerINN = ""; return payment },
+			want:    statementMatch{Suggested: 42, ReviewCause: ReviewPayerINNMissing},
+		},
+		{
+			name:    "already paid",
+			invoice: func(invoice statementInvoice) statementInvoice { invoice.Status = "paid"; return invoice },
+			want:    statementMatch{Suggested: 42, ReviewCause: ReviewInvoiceAlreadyPaid},
+		},
+		{
+			name:    "cancelled",
+			invoice: func(invoice statementInvoice) statementInvoice { invoice.Status = "cancelled"; return invoice },
+			want:    statementMatch{Suggested: 42, ReviewCause: ReviewInvoiceNotPayable},
+		},
+		{
+			name:    "unknown number",
+			invoice: func(invoice statementInvoice) statementInvoice { invoice.Number = "REUP-2026-000043"; return invoice },
+			want:    statementMatch{ReviewCause: ReviewInvoiceNotFound},
+		},
+		{
+			name: "two invoices in one payment",
+			payment: func(payment StatementPayment) StatementPayment {
+				payment.Purpose = "Оплата счетов REUP-2026-000042, REUP-2026-000043"
+				return payment
+			},
+			want: statementMatch{ReviewCause: ReviewMultipleInvoices},
+		},
+		{
+			name: "no number with a single candidate",
+			payment: func(payment StatementPayment) StatementPayment {
+				payment.Purpose = "Оплата подписки"
+				return payment
+			},
+			suggestions: []statementInvoice{waiting},
+			want:        statementMatch{Suggested: 42, ReviewCause: ReviewInvoiceNumberMissing},
+		},
+		{
+			name: "no number with several candidates",
+			payment: func(payment StatementPayment) StatementPayment {
+				payment.Purpose = "Оплата подписки"
+				return payment
+			},
+			suggestions: []statementInvoice{waiting, {ID: 43}},
+			want:        statementMatch{ReviewCause: ReviewInvoiceNumberMissing},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current, invoice := payment, waiting
+			if test.payment != nil {
+				current = test.payment(current)
+			}
+			if test.invoice != nil {
+				invoice = test.invoice(invoice)
+			}
+			got := matchStatementPayment(current, []statementInvoice{invoice}, test.suggestions)
+			if got != test.want {
+				t.Fatalf("matchStatementPayment = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatementFingerprintIgnoresImport(t *testing.T) {
+	_, first, err := ParseBankStatement([]byte(clientBankStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := ParseBankStatement(encodeWindows1251(t, clientBankStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first[0].Fingerprint() != second[0].Fingerprint() || first[0].Fingerprint() == first[1].Fingerprint() {
+		t.Fatal("fingerprint does not identify the payment")
+	}
+}
+
+func encodeWindows1251(t *testing.T, text string) []byte {
+	t.Helper()
+	encoded := []byte{}
+	for _, r := range text {
+		switch {
+		case r < 0x80:
+			encoded = append(encoded, byte(r))
+		case r >= 'А' && r <= 'я':
+			encoded = append(encoded, byte(r-'А'+0xC0))
+		case r == 'Ё':
+			encoded = append(encoded, 0xA8)
+		case r == 'ё':
+			encoded = append(encoded, 0xB8)
+		default:
+			t.Fatalf("%q has no Windows-1251 encoding in this helper", r)
+		}
+	}
+	if strings.Contains(string(encoded), "Сумма") {
+		t.Fatal("encoded statement is still UTF-8")
+	}
+	return encoded
+}
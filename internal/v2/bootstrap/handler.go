@@ -48,11 +48,29 @@ type membershipResponse struct {
 	Status string `json:"status"`
 }
 
+// subscriptionResponse.AccessTier is "full", "read_only" once a
+// subscription lapsed (data can be read and exported, not changed) or
+// "none" before the first subscription.
 type subscriptionResponse struct {
-	Status       string     `json:"status"`
-	Access       bool       `json:"access"`
-	AccessReason string     `json:"access_reason"`
-	GraceUntil   *time.Time `json:"grace_until"`
+	Status       string         `json:"status"`
+	Access       bool           `json:"access"`
+	AccessTier   string         `json:"access_tier"`
+	AccessReason string         `json:"access_reason"`
+	GraceUntil   *time.Time     `json:"grace_until"`
+	Dunning      *dunningBanner `json:"dunning"`
+}
+
+// dunningBanner tells the app a renewal failed: while the case is open the
+// workspace is in its grace period, once it expired the workspace is
+// read-only until the subscription is paid again. Only the owner can fix the card.
+type dunningBanner struct {
+	Status           string     `json:"status"`
+	StartedAt        time.Time  `json:"started_at"`
+	GraceUntil       time.Time  `json:"grace_until"`
+	RetryAttempts    int        `json:"retry_attempts"`
+	NextRetryAt      *time.Time `json:"next_retry_at"`
+	UpdatePaymentURL string     `json:"update_payment_url"`
+	CanUpdatePayment bool       `json:"can_update_payment"`
 }
 
 type v2Response struct {
@@ -88,6 +106,9 @@ func (h *Handler) Bootstrap(w http.ResponseWriter, r *http.Request) {
 		api.WriteError(w, http.StatusInternalServerError, "subscription_lookup_failed")
 		return
 	}
+	if subscription.Dunning != nil {
+		subscription.Dunning.CanUpdatePayment = membership.Role == workspaces.MembershipRoleOwner
+	}
 
 	api.WriteJSON(w, http.StatusOK, response{
 		User: userResponse{
@@ -135,7 +156,7 @@ func (h *Handler) subscription(workspaceID int, ownerUserID int) (subscriptionRe
 	`, workspaceID, ownerUserID).Scan(&row.Status, &row.CurrentPeriodEnd, &row.GraceUntil)
 	if errors.Is(err, sql.ErrNoRows) {
 		return subscriptionResponse{
-			Status: "inactive", Access: false, AccessReason: "payment_required",
+			Status: "inactive", Access: false, AccessTier: api.AccessTierNone, AccessReason: "payment_required",
 		}, nil
 	}
 	if err != nil {
@@ -171,10 +192,43 @@ func (h *Handler) subscription(workspaceID int, ownerUserID int) (subscriptionRe
 		graceUntil = &row.GraceUntil.Time
 	}
 
+	dunning, err := h.dunning(workspaceID, status)
+	if err != nil {
+		return subscriptionResponse{}, err
+	}
+
 	return subscriptionResponse{
 		Status:       status,
 		Access:       access,
+		AccessTier:   api.SubscriptionAccessTier(status, row.CurrentPeriodEnd, row.GraceUntil, now),
 		AccessReason: accessReason,
 		GraceUntil:   graceUntil,
+		Dunning:      dunning,
 	}, nil
 }
+
+func (h *Handler) dunning(workspaceID int, subscriptionStatus string) (*dunningBanner, error) {
+	var banner dunningBanner
+	var nextRetryAt sql.NullTime
+	err := h.dbx.QueryRow(`
+		SELECT status, started_at, grace_until, retry_attempts, next_retry_at
+		FROM billing_dunning_cases
+		WHERE workspace_id=$1
+		ORDER BY started_at DESC, id DESC
+		LIMIT 1
+	`, workspaceID).Scan(&banner.Status, &banner.StartedAt, &banner.GraceUntil, &banner.RetryAttempts, &nextRetryAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if banner.Status != "open" && (banner.Status != "expired" || subscriptionStatus != "expired") {
+		return nil, nil
+	}
+	if nextRetryAt.Valid {
+		banner.NextRetryAt = &nextRetryAt.Time
+	}
+	banner.UpdatePaymentURL = "/account?section=subscription&payment=update"
+	return &banner, nil
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"reup-goals-backend/internal/ai"
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/v2/metrics"
 )
 
@@ -42,6 +43,10 @@ type contextFile struct {
 type snapshotSection struct {
 	Title string
 	Query string
+	// Sealed names the field that holds content sealed with the workspace
+	// data key. Such rows also select content_key_version so the content can
+	// be opened before it goes into the snapshot.
+	Sealed string
 }
 
 const workspaceContextLockNamespace int64 = 0x5245555000000000
@@ -64,13 +69,14 @@ var snapshotSections = []snapshotSection{
 		FROM (
 			SELECT id, claim_text, claim_type, topic_key, evidence_level, confidence,
 				importance, status, status_reason, superseded_by, source_ids_json,
-				created_at, updated_at
+				created_at, updated_at, content_key_version
 			FROM strategic_claims WHERE workspace_id=$1
-		) item`},
+		) item`, Sealed: "claim_text"},
 	{Title: "Raw business sources", Query: `
 		SELECT COALESCE(jsonb_agg(to_jsonb(item) ORDER BY item.id), '[]'::jsonb)::text
 		FROM (
-			SELECT id, user_id, source_type, entity_key, content, metadata_json, created_at
+			SELECT id, user_id, source_type, entity_key, content, content_key_version,
+				metadata_json, created_at
 			FROM strategic_raw_sources
 			WHERE workspace_id=$1
 				AND source_type <> 'assistant_message'
@@ -79,7 +85,7 @@ var snapshotSections = []snapshotSection{
 					FROM strategic_knowledge_pipeline_state
 					WHERE workspace_id=$1
 				), 0)
-		) item`},
+		) item`, Sealed: "content"},
 	{Title: "Latest knowledge snapshot", Query: `
 		SELECT COALESCE(jsonb_agg(to_jsonb(item) ORDER BY item.id), '[]'::jsonb)::text
 		FROM (
@@ -121,10 +127,10 @@ var snapshotSections = []snapshotSection{
 		FROM (
 			SELECT id, parent_id, title, content, status, favorite,
 				linked_department_ids, linked_workstream_ids, linked_project_ids,
-				version, created_by, updated_by, created_at, updated_at
+				version, created_by, updated_by, created_at, updated_at, content_key_version
 			FROM workspace_documents
 			WHERE workspace_id=$1 AND archived_at IS NULL
-		) item`},
+		) item`, Sealed: "content"},
 	{Title: "Departments", Query: `
 		SELECT COALESCE(jsonb_agg(to_jsonb(item) ORDER BY item.sort_order, item.id), '[]'::jsonb)::text
 		FROM (
@@ -476,6 +482,13 @@ func (s *Service) buildSnapshot(ctx context.Context, workspaceID int) ([]byte, s
 		if err := s.dbx.QueryRowContext(ctx, section.Query, workspaceID).Scan(&raw); err != nil {
 			return nil, "", fmt.Errorf("%s: %w", section.Title, err)
 		}
+		if section.Sealed != "" {
+			opened, err := openSealedRows(ctx, workspaceID, raw, section.Sealed)
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: %w", section.Title, err)
+			}
+			raw = opened
+		}
 		writeJSONSection(&builder, section.Title, raw)
 	}
 	catalog, err := standardMetricCatalogJSON()
@@ -488,6 +501,39 @@ func (s *Service) buildSnapshot(ctx context.Context, workspaceID int) ([]byte, s
 	return content, hex.EncodeToString(sum[:]), nil
 }
 
+func openSealedRows(ctx context.Context, workspaceID int, raw string, field string) (string, error) {
+	var rows []map[string]any
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		var keyVersion sql.NullInt64
+		if value, ok := row["content_key_version"].(json.Number); ok {
+			version, err := value.Int64()
+			if err != nil {
+				return "", err
+			}
+			keyVersion = sql.NullInt64{Int64: version, Valid: true}
+		}
+		delete(row, "content_key_version")
+		sealed, _ := row[field].(string)
+		content, err := encryption.OpenText(ctx, workspaceID, sealed, keyVersion)
+		if err != nil {
+			return "", err
+		}
+		row[field] = content
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(rows); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(encoded.String()), nil
+}
+
 func standardMetricCatalogJSON() (string, error) {
 	raw, err := json.MarshalIndent(metrics.Catalog("", ""), "", "  ")
 	if err != nil {
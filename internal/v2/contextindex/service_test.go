@@ -1,6 +1,7 @@
 package contextindex
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -24,3 +25,17 @@ func TestStandardMetricCatalogIsSearchableWorkspaceContext(t *testing.T) {
 		t.Fatal("retrieval instructions must require metric catalog search")
 	}
 }
+
+func TestOpenSealedRowsDropsKeyVersion(t *testing.T) {
+	raw := `[{"id": 1, "content": "Продажи <b>растут</b>", "content_key_version": null}]`
+	opened, err := openSealedRows(context.Background(), 1, raw, "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != `[{"content":"Продажи <b>растут</b>","id":1}]` {
+		t.Fatalf("openSealedRows = %s", opened)
+	}
+	if _, err := openSealedRows(context.Background(), 1, `[{"content": "x", "content_key_version": 1}]`, "content"); err == nil {
+		t.Fatal("sealed row opened without a keyring")
+	}
+}
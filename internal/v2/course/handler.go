@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"reup-goals-backend/internal/ai"
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/v2/api"
 	"reup-goals-backend/internal/v2/workspaces"
@@ -17,12 +18,16 @@ import (
 type Handler struct {
 	store      *Store
 	workspaces *workspaces.Store
+	reviews    *ReviewService
 }
 
-func NewHandler(dbx *sql.DB) *Handler {
+func NewHandler(dbx *sql.DB, aiClient ai.Provider) *Handler {
+	reviews := NewReviewService(dbx, aiClient)
+	reviews.StartWorker()
 	return &Handler{
 		store:      NewStore(dbx),
 		workspaces: workspaces.NewStore(dbx),
+		reviews:    reviews,
 	}
 }
 
@@ -52,6 +57,57 @@ func (h *Handler) Current(w http.ResponseWriter, r *http.Request) {
 	api.WriteJSON(w, http.StatusOK, response)
 }
 
+func (h *Handler) Courses(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v2/courses" {
+		api.WriteError(w, http.StatusNotFound, "not_found")
+		return
+	}
+
+	workspace, userID, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		courses, err := h.store.Courses(r.Context(), workspace.ID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "course_list_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"courses": courses})
+	case http.MethodPost:
+		h.createCourse(w, r, workspace.ID, userID)
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+func (h *Handler) Portfolio(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v2/course/portfolio" {
+		api.WriteError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+
+	workspace, userID, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	portfolio, err := h.store.Portfolio(r.Context(), workspace.ID)
+	if err != nil {
+		// #nosec G706 -- the error is quoted to prevent control-character injection.
+		log.Printf("[ERROR] course portfolio failed workspace_id=%d user_id=%d: %q", workspace.ID, userID, err.Error())
+		api.WriteError(w, http.StatusInternalServerError, "course_portfolio_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, portfolio)
+}
+
 func (h *Handler) Course(w http.ResponseWriter, r *http.Request) {
 	courseID, action, ok := coursePath(r.URL.Path)
 	if !ok {
@@ -69,10 +125,20 @@ func (h *Handler) Course(w http.ResponseWriter, r *http.Request) {
 		h.updateCourse(w, r, workspace.ID, courseID)
 	case action == "activate" && r.Method == http.MethodPost:
 		h.activateCourse(w, r, workspace.ID, courseID)
+	case action == "archive" && r.Method == http.MethodPost:
+		h.archiveCourse(w, r, workspace.ID, courseID)
 	case action == "refresh" && r.Method == http.MethodPost:
 		h.refreshCourse(w, r, workspace.ID, courseID)
 	case action == "review" && r.Method == http.MethodPost:
 		h.reviewCourse(w, r, workspace.ID, userID, courseID)
+	case action == "review-schedule" && r.Method == http.MethodGet:
+		h.reviewSchedule(w, r, workspace.ID, courseID)
+	case action == "review-schedule" && r.Method == http.MethodPut:
+		h.saveReviewSchedule(w, r, workspace.ID, userID, courseID)
+	case action == "review-packs" && r.Method == http.MethodGet:
+		h.reviewPacks(w, r, workspace.ID, courseID)
+	case action == "review-packs" && r.Method == http.MethodPost:
+		h.requestReviewPack(w, r, workspace.ID, userID, courseID)
 	default:
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
 	}
@@ -101,6 +167,10 @@ func (h *Handler) reviewCourse(w http.ResponseWriter, r *http.Request, workspace
 		api.WriteError(w, http.StatusConflict, "course_review_status_invalid")
 		return
 	}
+	if errors.Is(err, ErrReviewPackNotReady) {
+		api.WriteError(w, http.StatusConflict, "course_review_pack_not_ready")
+		return
+	}
 	if err != nil {
 		api.WriteError(w, http.StatusInternalServerError, "course_review_failed")
 		return
@@ -108,6 +178,63 @@ func (h *Handler) reviewCourse(w http.ResponseWriter, r *http.Request, workspace
 	api.WriteJSON(w, http.StatusOK, map[string]any{"review": review, "course": updated})
 }
 
+func (h *Handler) reviewSchedule(w http.ResponseWriter, r *http.Request, workspaceID int, courseID int) {
+	schedule, err := h.store.ReviewSchedule(r.Context(), workspaceID, courseID)
+	if errors.Is(err, sql.ErrNoRows) {
+		api.WriteError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "course_review_schedule_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"schedule": schedule})
+}
+
+func (h *Handler) saveReviewSchedule(w http.ResponseWriter, r *http.Request, workspaceID int, userID int, courseID int) {
+	var input CourseReviewScheduleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	schedule, err := h.store.SaveReviewSchedule(r.Context(), workspaceID, userID, courseID, input)
+	if errors.Is(err, sql.ErrNoRows) {
+		api.WriteError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if errors.Is(err, ErrReviewScheduleInvalid) {
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "course_review_schedule_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"schedule": schedule})
+}
+
+func (h *Handler) reviewPacks(w http.ResponseWriter, r *http.Request, workspaceID int, courseID int) {
+	packs, err := h.store.ReviewPacks(r.Context(), workspaceID, courseID, 20)
+	if errors.Is(err, sql.ErrNoRows) {
+		api.WriteError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "course_review_packs_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"review_packs": packs})
+}
+
+func (h *Handler) requestReviewPack(w http.ResponseWriter, r *http.Request, workspaceID int, userID int, courseID int) {
+	pack, err := h.store.RequestReviewPack(r.Context(), workspaceID, userID, courseID)
+	if h.writeCourseActionError(w, err) {
+		return
+	}
+	h.reviews.Wake()
+	api.WriteJSON(w, http.StatusAccepted, map[string]any{"review_pack": pack})
+}
+
 func (h *Handler) updateCourse(w http.ResponseWriter, r *http.Request, workspaceID int, courseID int) {
 	var input CourseInput
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -145,6 +272,31 @@ func (h *Handler) activateCourse(w http.ResponseWriter, r *http.Request, workspa
 	api.WriteJSON(w, http.StatusOK, map[string]any{"course": course})
 }
 
+func (h *Handler) createCourse(w http.ResponseWriter, r *http.Request, workspaceID int, userID int) {
+	var input CourseInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "invalid_json")
+		return
+	}
+	input.trim()
+	if input.Horizon != nil && *input.Horizon <= 0 {
+		api.WriteError(w, http.StatusUnprocessableEntity, "invalid_horizon")
+		return
+	}
+	course, err := h.store.CreateCourse(r.Context(), workspaceID, userID, input)
+	if h.writeCourseActionError(w, err) {
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, map[string]any{"course": course})
+}
+
+func (h *Handler) archiveCourse(w http.ResponseWriter, r *http.Request, workspaceID int, courseID int) {
+	if h.writeCourseActionError(w, h.store.ArchiveCourse(r.Context(), workspaceID, courseID)) {
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"archived": true})
+}
+
 func (h *Handler) refreshCourse(w http.ResponseWriter, r *http.Request, workspaceID int, courseID int) {
 	course, err := h.store.Refresh(r.Context(), workspaceID, courseID)
 	if h.writeCourseActionError(w, err) {
@@ -170,6 +322,12 @@ func (h *Handler) writeCourseActionError(w http.ResponseWriter, err error) bool
 		api.WriteError(w, http.StatusConflict, "course_strategy_artifacts_missing")
 	case errors.Is(err, ErrCourseReviewStatus):
 		api.WriteError(w, http.StatusConflict, "course_review_status_invalid")
+	case errors.Is(err, ErrCourseNotPrimary):
+		api.WriteError(w, http.StatusConflict, "course_not_primary")
+	case errors.Is(err, ErrCourseLimitReached):
+		api.WriteError(w, http.StatusConflict, "course_limit_reached")
+	case errors.Is(err, ErrCourseStrategyMissing):
+		api.WriteError(w, http.StatusConflict, "course_strategy_missing")
 	default:
 		log.Printf("[ERROR] course action failed: %v", err)
 		api.WriteError(w, http.StatusInternalServerError, "course_action_failed")
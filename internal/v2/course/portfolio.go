@@ -0,0 +1,603 @@
+package course
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"reup-goals-backend/internal/v2/metrics"
+)
+
+// portfolioLoad is one aggregated row of task or workstream allocation.
+// Workstream participation rows carry an empty Status and Tasks=0.
+type portfolioLoad struct {
+	CourseID int
+	UserID   int
+	Status   string
+	Tasks    int
+}
+
+// Courses lists the primary course and the additional courses of the active
+// strategy, primary first.
+func (s *Store) Courses(ctx context.Context, workspaceID int) ([]Course, error) {
+	strategy, err := s.activeStrategy(ctx, workspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return []Course{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.strategyCourses(ctx, workspaceID, strategy.ID)
+}
+
+// CreateCourse drafts an additional course next to the primary one. It keeps
+// its own horizon and key metric and is never regenerated from the strategy.
+func (s *Store) CreateCourse(ctx context.Context, workspaceID int, userID int, input CourseInput) (Course, error) {
+	input.trim()
+	if input.Title == "" {
+		return Course{}, ErrCourseIncomplete
+	}
+	horizon := 90
+	if input.Horizon != nil && *input.Horizon > 0 {
+		horizon = *input.Horizon
+	}
+	if input.HorizonUnit == "" {
+		input.HorizonUnit = "days"
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return Course{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID+6000000); err != nil {
+		return Course{}, err
+	}
+	strategy, err := activeStrategyTx(ctx, tx, workspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Course{}, ErrCourseStrategyMissing
+	}
+	if err != nil {
+		return Course{}, err
+	}
+	var open int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM v2_courses
+		WHERE workspace_id=$1 AND strategy_id=$2 AND archived_at IS NULL AND status<>$3
+	`, workspaceID, strategy.ID, StatusCompleted).Scan(&open); err != nil {
+		return Course{}, err
+	}
+	if open >= MaxOpenCoursesPerStrategy {
+		return Course{}, ErrCourseLimitReached
+	}
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO v2_courses (
+			workspace_id, strategy_id, title, direction, strategic_goal, meaning,
+			horizon, horizon_unit, start_date, end_date, key_metric, success_criterion,
+			status, source, created_by, is_primary
+		)
+		VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8,
+			COALESCE(NULLIF($9, '')::DATE, CURRENT_DATE),
+			COALESCE($10::DATE, COALESCE(NULLIF($9, '')::DATE, CURRENT_DATE) + ($7::INTEGER)),
+			$11, $12, $13, $14, $15, FALSE
+		)
+		RETURNING
+			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
+			title, direction, strategic_goal, meaning,
+			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
+	`, workspaceID, strategy.ID, input.Title, input.Direction, input.StrategicGoal, input.Meaning,
+		horizon, input.HorizonUnit, input.StartDate, nullableString(input.EndDate),
+		input.KeyMetric, input.SuccessCriterion, StatusDraft, SourceManual, userID)
+	course, err := scanCourse(row)
+	if err != nil {
+		return Course{}, fmt.Errorf("create course: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Course{}, err
+	}
+	return course, nil
+}
+
+// ArchiveCourse closes an additional course. Its workstreams fall back to the
+// plan's primary course and open tasks move with them.
+func (s *Store) ArchiveCourse(ctx context.Context, workspaceID int, courseID int) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := courseByIDTx(ctx, tx, workspaceID, courseID)
+	if err != nil {
+		return err
+	}
+	if current.IsPrimary {
+		return ErrCourseNotPrimary
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE v2_tasks task
+		SET course_id=plan.course_id, updated_at=NOW()
+		FROM v2_tactical_plans plan
+		WHERE plan.id=task.tactical_plan_id
+			AND task.workspace_id=$1
+			AND task.course_id=$2
+			AND task.status IN ('free', 'in_progress')
+	`, workspaceID, courseID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_workstreams
+		SET course_id=NULL, updated_at=NOW()
+		WHERE workspace_id=$1 AND course_id=$2
+	`, workspaceID, courseID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE v2_courses
+		SET status=$1, archived_at=NOW(), updated_at=NOW()
+		WHERE id=$2 AND workspace_id=$3 AND archived_at IS NULL
+	`, StatusArchived, courseID, workspaceID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Portfolio shows every open course of the active strategy side by side:
+// progress, how tasks and people are spread across them, and the conflicts
+// that arise when courses compete for the same metric or the same people.
+func (s *Store) Portfolio(ctx context.Context, workspaceID int) (CoursePortfolio, error) {
+	empty := CoursePortfolio{Courses: []PortfolioCourse{}, People: []PortfolioPerson{}, Conflicts: []PortfolioConflict{}}
+	strategy, err := s.activeStrategy(ctx, workspaceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		empty.Reason = "no_active_strategy"
+		return empty, nil
+	}
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load active strategy: %w", err)
+	}
+	empty.Strategy = &strategy
+	courses, err := s.strategyCourses(ctx, workspaceID, strategy.ID)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load courses: %w", err)
+	}
+	if len(courses) == 0 {
+		empty.Reason = "no_courses"
+		return empty, nil
+	}
+	courseIDs := make([]int, 0, len(courses))
+	for _, course := range courses {
+		courseIDs = append(courseIDs, course.ID)
+	}
+	targets, err := metrics.NewStore(s.dbx).Targets(ctx, workspaceID, metrics.ScopeStrategy, strategy.ID)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load strategy metrics: %w", err)
+	}
+	loads, err := s.portfolioTaskLoads(ctx, workspaceID, courseIDs)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load task allocation: %w", err)
+	}
+	workstreams, unlinked, err := s.portfolioWorkstreams(ctx, workspaceID, strategy.ID)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load workstream allocation: %w", err)
+	}
+	participants, err := s.portfolioParticipants(ctx, workspaceID, strategy.ID)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load workstream participants: %w", err)
+	}
+	loads = append(loads, participants...)
+	names, err := s.portfolioPeopleNames(ctx, workspaceID, loads)
+	if err != nil {
+		return CoursePortfolio{}, fmt.Errorf("load people: %w", err)
+	}
+
+	items, people := assemblePortfolio(courses, targets, loads, workstreams, names, time.Now())
+	return CoursePortfolio{
+		Strategy:            &strategy,
+		Courses:             items,
+		People:              people,
+		Conflicts:           detectPortfolioConflicts(items, people),
+		UnlinkedWorkstreams: unlinked,
+	}, nil
+}
+
+func (s *Store) strategyCourses(ctx context.Context, workspaceID int, strategyID int) ([]Course, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT
+			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
+			title, direction, strategic_goal, meaning,
+			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
+		FROM v2_courses
+		WHERE workspace_id=$1 AND strategy_id=$2 AND archived_at IS NULL
+		ORDER BY is_primary DESC, created_at ASC, id ASC
+	`, workspaceID, strategyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	courses := []Course{}
+	for rows.Next() {
+		course, err := scanCourse(rows)
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+func (s *Store) portfolioTaskLoads(ctx context.Context, workspaceID int, courseIDs []int) ([]portfolioLoad, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT course_id, COALESCE(owner_user_id, 0), status, COUNT(*)
+		FROM v2_tasks
+		WHERE workspace_id=$1 AND course_id = ANY($2) AND archived_at IS NULL AND status<>'archived'
+		GROUP BY course_id, COALESCE(owner_user_id, 0), status
+	`, workspaceID, pq.Array(courseIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loads := []portfolioLoad{}
+	for rows.Next() {
+		var load portfolioLoad
+		if err := rows.Scan(&load.CourseID, &load.UserID, &load.Status, &load.Tasks); err != nil {
+			return nil, err
+		}
+		loads = append(loads, load)
+	}
+	return loads, rows.Err()
+}
+
+// portfolioWorkstreams counts workstreams by the course they effectively
+// serve: their own link when it is still open, otherwise the plan's course.
+func (s *Store) portfolioWorkstreams(ctx context.Context, workspaceID int, strategyID int) (map[int]int, int, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT COALESCE(linked.id, plan.course_id, 0), COUNT(*)
+		FROM v2_tactical_workstreams workstream
+		JOIN v2_tactical_plans plan ON plan.id=workstream.tactical_plan_id AND plan.archived_at IS NULL
+		LEFT JOIN v2_courses linked ON linked.id=workstream.course_id AND linked.archived_at IS NULL
+		WHERE workstream.workspace_id=$1 AND workstream.strategy_id=$2 AND workstream.archived_at IS NULL
+		GROUP BY 1
+	`, workspaceID, strategyID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	counts := map[int]int{}
+	unlinked := 0
+	for rows.Next() {
+		var courseID, count int
+		if err := rows.Scan(&courseID, &count); err != nil {
+			return nil, 0, err
+		}
+		if courseID == 0 {
+			unlinked += count
+			continue
+		}
+		counts[courseID] += count
+	}
+	return counts, unlinked, rows.Err()
+}
+
+func (s *Store) portfolioParticipants(ctx context.Context, workspaceID int, strategyID int) ([]portfolioLoad, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT DISTINCT COALESCE(linked.id, plan.course_id), participant.user_id
+		FROM v2_workstream_participants participant
+		JOIN v2_tactical_workstreams workstream ON workstream.id=participant.workstream_id
+		JOIN v2_tactical_plans plan ON plan.id=workstream.tactical_plan_id AND plan.archived_at IS NULL
+		LEFT JOIN v2_courses linked ON linked.id=workstream.course_id AND linked.archived_at IS NULL
+		WHERE participant.workspace_id=$1
+			AND workstream.strategy_id=$2
+			AND workstream.archived_at IS NULL
+			AND COALESCE(linked.id, plan.course_id) IS NOT NULL
+	`, workspaceID, strategyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	loads := []portfolioLoad{}
+	for rows.Next() {
+		var load portfolioLoad
+		if err := rows.Scan(&load.CourseID, &load.UserID); err != nil {
+			return nil, err
+		}
+		loads = append(loads, load)
+	}
+	return loads, rows.Err()
+}
+
+func (s *Store) portfolioPeopleNames(ctx context.Context, workspaceID int, loads []portfolioLoad) (map[int]string, error) {
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, load := range loads {
+		if load.UserID > 0 && !seen[load.UserID] {
+			seen[load.UserID] = true
+			ids = append(ids, load.UserID)
+		}
+	}
+	names := map[int]string{}
+	if len(ids) == 0 {
+		return names, nil
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT users.id, COALESCE(NULLIF(users.name, ''), users.email)
+		FROM workspace_memberships membership
+		JOIN users ON users.id=membership.user_id
+		WHERE membership.workspace_id=$1 AND users.id = ANY($2)
+	`, workspaceID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = strings.TrimSpace(name)
+	}
+	return names, rows.Err()
+}
+
+func assemblePortfolio(
+	courses []Course,
+	targets []metrics.Target,
+	loads []portfolioLoad,
+	workstreams map[int]int,
+	names map[int]string,
+	now time.Time,
+) ([]PortfolioCourse, []PortfolioPerson) {
+	items := make([]PortfolioCourse, 0, len(courses))
+	index := make(map[int]int, len(courses))
+	for _, course := range courses {
+		index[course.ID] = len(items)
+		items = append(items, PortfolioCourse{
+			Course:      course,
+			Progress:    courseProgress(course, targets, now),
+			Workstreams: workstreams[course.ID],
+		})
+	}
+
+	people := map[int]*PortfolioPerson{}
+	personCourses := map[int]map[int]*PortfolioPersonLoad{}
+	courseUsers := map[int]map[int]bool{}
+	openTotal := 0
+	for _, load := range loads {
+		position, ok := index[load.CourseID]
+		if !ok {
+			continue
+		}
+		item := &items[position]
+		switch load.Status {
+		case "free":
+			item.Tasks.Free += load.Tasks
+		case "in_progress":
+			item.Tasks.InProgress += load.Tasks
+		case "done":
+			item.Tasks.Done += load.Tasks
+		}
+		item.Tasks.Total += load.Tasks
+		open := 0
+		if load.Status == "free" || load.Status == "in_progress" {
+			open = load.Tasks
+			openTotal += open
+		}
+		if load.UserID == 0 {
+			item.Tasks.Unassigned += open
+			continue
+		}
+		if courseUsers[load.CourseID] == nil {
+			courseUsers[load.CourseID] = map[int]bool{}
+		}
+		courseUsers[load.CourseID][load.UserID] = true
+		person := people[load.UserID]
+		if person == nil {
+			person = &PortfolioPerson{UserID: load.UserID, Name: names[load.UserID]}
+			people[load.UserID] = person
+			personCourses[load.UserID] = map[int]*PortfolioPersonLoad{}
+		}
+		person.OpenTasks += open
+		entry := personCourses[load.UserID][load.CourseID]
+		if entry == nil {
+			entry = &PortfolioPersonLoad{CourseID: load.CourseID}
+			personCourses[load.UserID][load.CourseID] = entry
+		}
+		entry.OpenTasks += open
+	}
+
+	for position := range items {
+		item := &items[position]
+		item.People = len(courseUsers[item.Course.ID])
+		if item.Tasks.Total > 0 {
+			item.Progress.TaskPercent = item.Tasks.Done * 100 / item.Tasks.Total
+		}
+		if openTotal > 0 {
+			item.TaskShare = (item.Tasks.Free + item.Tasks.InProgress) * 100 / openTotal
+		}
+	}
+
+	result := make([]PortfolioPerson, 0, len(people))
+	for userID, person := range people {
+		person.Courses = make([]PortfolioPersonLoad, 0, len(personCourses[userID]))
+		for _, entry := range personCourses[userID] {
+			person.Courses = append(person.Courses, *entry)
+		}
+		sort.Slice(person.Courses, func(i, j int) bool {
+			return index[person.Courses[i].CourseID] < index[person.Courses[j].CourseID]
+		})
+		result = append(result, *person)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OpenTasks != result[j].OpenTasks {
+			return result[i].OpenTasks > result[j].OpenTasks
+		}
+		return result[i].UserID < result[j].UserID
+	})
+	return items, result
+}
+
+func courseProgress(course Course, targets []metrics.Target, now time.Time) CourseProgress {
+	progress := CourseProgress{}
+	start, startErr := time.Parse("2006-01-02", course.StartDate)
+	if course.EndDate != nil && startErr == nil {
+		if end, err := time.Parse("2006-01-02", *course.EndDate); err == nil && end.After(start) {
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+			total := end.Sub(start).Hours() / 24
+			elapsed := today.Sub(start).Hours() / 24
+			progress.ElapsedPercent = clampPercent(int(math.Round(elapsed * 100 / total)))
+			daysLeft := int(math.Max(0, math.Round(end.Sub(today).Hours()/24)))
+			progress.DaysLeft = &daysLeft
+		}
+	}
+
+	target, ok := portfolioMetricTarget(course, targets)
+	if !ok {
+		return progress
+	}
+	trend := buildMetricTrend(target, course.StartDate)
+	progress.Metric = &trend
+	if target.BaselineValue != nil && target.TargetValue != nil && trend.Latest != nil &&
+		*target.TargetValue != *target.BaselineValue {
+		percent := (*trend.Latest - *target.BaselineValue) * 100 / (*target.TargetValue - *target.BaselineValue)
+		value := clampPercent(int(math.Round(percent)))
+		progress.MetricPercent = &value
+	}
+	return progress
+}
+
+// portfolioMetricTarget matches a course key metric to a strategy metric
+// target. Only the primary course falls back to the strategy's primary
+// metric; additional courses must name their own metric.
+func portfolioMetricTarget(course Course, targets []metrics.Target) (metrics.Target, bool) {
+	if course.IsPrimary {
+		return courseKeyMetricTarget(course.KeyMetric, targets)
+	}
+	name := normalizeKeyMetric(course.KeyMetric)
+	if name == "" {
+		return metrics.Target{}, false
+	}
+	for _, target := range targets {
+		metricName := normalizeKeyMetric(target.Metric.Name)
+		if metricName != "" && (metricName == name || strings.Contains(name, metricName)) {
+			return target, true
+		}
+	}
+	return metrics.Target{}, false
+}
+
+// detectPortfolioConflicts reports courses that pull on the same key metric
+// over overlapping horizons, people split across several running courses and
+// running courses that nobody is working on.
+func detectPortfolioConflicts(courses []PortfolioCourse, people []PortfolioPerson) []PortfolioConflict {
+	conflicts := []PortfolioConflict{}
+	running := map[int]bool{}
+	for _, item := range courses {
+		if item.Course.Status == StatusActive || item.Course.Status == StatusNeedsReview {
+			running[item.Course.ID] = true
+		}
+	}
+
+	for i := range courses {
+		left := courses[i].Course
+		if !running[left.ID] || normalizeKeyMetric(left.KeyMetric) == "" {
+			continue
+		}
+		for j := i + 1; j < len(courses); j++ {
+			right := courses[j].Course
+			if !running[right.ID] || normalizeKeyMetric(left.KeyMetric) != normalizeKeyMetric(right.KeyMetric) {
+				continue
+			}
+			if !horizonsOverlap(left, right) {
+				continue
+			}
+			conflicts = append(conflicts, PortfolioConflict{
+				Kind:      PortfolioConflictSharedKeyMetric,
+				Severity:  "medium",
+				CourseIDs: []int{left.ID, right.ID},
+				Message: fmt.Sprintf("Курсы «%s» и «%s» в одно время двигают метрику «%s»: вклад каждого будет не отделить.",
+					left.Title, right.Title, strings.TrimSpace(left.KeyMetric)),
+			})
+		}
+	}
+
+	for _, person := range people {
+		courseIDs := []int{}
+		for _, load := range person.Courses {
+			if running[load.CourseID] && load.OpenTasks > 0 {
+				courseIDs = append(courseIDs, load.CourseID)
+			}
+		}
+		if len(courseIDs) < 2 {
+			continue
+		}
+		severity := "low"
+		if len(courseIDs) > 2 || person.OpenTasks >= 10 {
+			severity = "high"
+		}
+		userID := person.UserID
+		name := person.Name
+		if name == "" {
+			name = fmt.Sprintf("Участник #%d", person.UserID)
+		}
+		conflicts = append(conflicts, PortfolioConflict{
+			Kind:      PortfolioConflictSharedPeople,
+			Severity:  severity,
+			CourseIDs: courseIDs,
+			UserID:    &userID,
+			Message: fmt.Sprintf("%s ведёт открытые задачи в %d курсах одновременно (%d задач).",
+				name, len(courseIDs), person.OpenTasks),
+		})
+	}
+
+	for _, item := range courses {
+		if item.Course.Status != StatusActive || item.People > 0 || item.Tasks.Free+item.Tasks.InProgress > 0 {
+			continue
+		}
+		conflicts = append(conflicts, PortfolioConflict{
+			Kind:      PortfolioConflictUnstaffed,
+			Severity:  "medium",
+			CourseIDs: []int{item.Course.ID},
+			Message:   fmt.Sprintf("У активного курса «%s» нет открытых задач и участников.", item.Course.Title),
+		})
+	}
+	return conflicts
+}
+
+func horizonsOverlap(left Course, right Course) bool {
+	leftEnd := "9999-12-31"
+	if left.EndDate != nil {
+		leftEnd = *left.EndDate
+	}
+	rightEnd := "9999-12-31"
+	if right.EndDate != nil {
+		rightEnd = *right.EndDate
+	}
+	return left.StartDate <= rightEnd && right.StartDate <= leftEnd
+}
+
+func normalizeKeyMetric(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func clampPercent(value int) int {
+	if value < 0 {
+		return 0
+	}
+	if value > 100 {
+		return 100
+	}
+	return value
+}
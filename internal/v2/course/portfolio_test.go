@@ -0,0 +1,100 @@
+package course
+
+import (
+	"testing"
+	"time"
+
+	"reup-goals-backend/internal/v2/metrics"
+)
+
+func TestAssemblePortfolio(t *testing.T) {
+	end := "2026-12-31"
+	courses := []Course{
+		{ID: 1, Title: "Рост выручки", KeyMetric: "Выручка", Status: StatusActive, StartDate: "2026-10-01", EndDate: &end, IsPrimary: true},
+		{ID: 2, Title: "Удержание", KeyMetric: "Отток", Status: StatusActive, StartDate: "2026-10-01", EndDate: &end},
+	}
+	baseline, target := 100.0, 200.0
+	targets := []metrics.Target{{
+		Metric:        metrics.Definition{Name: "Выручка"},
+		Role:          metrics.RolePrimary,
+		BaselineValue: &baseline,
+		TargetValue:   &target,
+		Observations:  []metrics.Observation{{MeasuredAt: "2026-10-10", Value: 150}},
+	}}
+	loads := []portfolioLoad{
+		{CourseID: 1, UserID: 7, Status: "in_progress", Tasks: 3},
+		{CourseID: 1, UserID: 0, Status: "free", Tasks: 1},
+		{CourseID: 1, UserID: 7, Status: "done", Tasks: 4},
+		{CourseID: 2, UserID: 7, Status: "free", Tasks: 2},
+		{CourseID: 2, UserID: 8, Status: "free", Tasks: 2},
+		{CourseID: 9, UserID: 8, Status: "free", Tasks: 5},
+	}
+	now := time.Date(2026, 11, 15, 12, 0, 0, 0, time.UTC)
+
+	items, people := assemblePortfolio(courses, targets, loads, map[int]int{1: 2, 2: 1}, map[int]string{7: "Анна"}, now)
+	if len(items) != 2 {
+		t.Fatalf("items = %+v", items)
+	}
+	primary := items[0]
+	if primary.Tasks != (PortfolioTaskStats{Total: 8, Free: 1, InProgress: 3, Done: 4, Unassigned: 1}) {
+		t.Fatalf("primary tasks = %+v", primary.Tasks)
+	}
+	if primary.People != 1 || primary.Workstreams != 2 || primary.Progress.TaskPercent != 50 || primary.TaskShare != 50 {
+		t.Fatalf("primary = %+v", primary)
+	}
+	if primary.Progress.MetricPercent == nil || *primary.Progress.MetricPercent != 50 {
+		t.Fatalf("metric percent = %v", primary.Progress.MetricPercent)
+	}
+	if primary.Progress.ElapsedPercent != 49 || primary.Progress.DaysLeft == nil || *primary.Progress.DaysLeft != 46 {
+		t.Fatalf("elapsed = %d days left = %v", primary.Progress.ElapsedPercent, primary.Progress.DaysLeft)
+	}
+	if items[1].Progress.Metric != nil {
+		t.Fatalf("additional course borrowed the primary metric: %+v", items[1].Progress.Metric)
+	}
+	if len(people) != 2 || people[0].UserID != 7 || people[0].OpenTasks != 5 || len(people[0].Courses) != 2 {
+		t.Fatalf("people = %+v", people)
+	}
+	if people[0].Courses[0].CourseID != 1 || people[0].Name != "Анна" {
+		t.Fatalf("person loads = %+v", people[0])
+	}
+}
+
+func TestDetectPortfolioConflicts(t *testing.T) {
+	end := "2026-12-31"
+	laterStart := "2027-01-10"
+	courses := []PortfolioCourse{
+		{Course: Course{ID: 1, Title: "A", KeyMetric: "Выручка", Status: StatusActive, StartDate: "2026-10-01", EndDate: &end}, People: 1},
+		{Course: Course{ID: 2, Title: "B", KeyMetric: " выручка ", Status: StatusActive, StartDate: "2026-11-01", EndDate: &end}, People: 1},
+		{Course: Course{ID: 3, Title: "C", KeyMetric: "Выручка", Status: StatusActive, StartDate: laterStart}},
+		{Course: Course{ID: 4, Title: "D", KeyMetric: "Выручка", Status: StatusDraft, StartDate: "2026-10-01"}},
+	}
+	people := []PortfolioPerson{
+		{UserID: 7, OpenTasks: 4, Courses: []PortfolioPersonLoad{{CourseID: 1, OpenTasks: 2}, {CourseID: 2, OpenTasks: 2}}},
+		{UserID: 8, OpenTasks: 3, Courses: []PortfolioPersonLoad{{CourseID: 1, OpenTasks: 3}, {CourseID: 4, OpenTasks: 0}}},
+	}
+
+	conflicts := detectPortfolioConflicts(courses, people)
+	kinds := map[string]int{}
+	for _, conflict := range conflicts {
+		kinds[conflict.Kind]++
+	}
+	if kinds[PortfolioConflictSharedKeyMetric] != 1 || kinds[PortfolioConflictSharedPeople] != 1 || kinds[PortfolioConflictUnstaffed] != 1 {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	for _, conflict := range conflicts {
+		switch conflict.Kind {
+		case PortfolioConflictSharedKeyMetric:
+			if len(conflict.CourseIDs) != 2 || conflict.CourseIDs[0] != 1 || conflict.CourseIDs[1] != 2 {
+				t.Fatalf("metric conflict = %+v", conflict)
+			}
+		case PortfolioConflictSharedPeople:
+			if conflict.UserID == nil || *conflict.UserID != 7 || conflict.Severity != "low" {
+				t.Fatalf("people conflict = %+v", conflict)
+			}
+		case PortfolioConflictUnstaffed:
+			if conflict.CourseIDs[0] != 3 {
+				t.Fatalf("unstaffed conflict = %+v", conflict)
+			}
+		}
+	}
+}
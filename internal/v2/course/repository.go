@@ -21,6 +21,9 @@ var (
 	ErrCourseStrategyMismatch = errors.New("course_strategy_mismatch")
 	ErrCourseArtifactsMissing = errors.New("course_strategy_artifacts_missing")
 	ErrCourseReviewStatus     = errors.New("course_review_status_invalid")
+	ErrCourseNotPrimary       = errors.New("course_not_primary")
+	ErrCourseLimitReached     = errors.New("course_limit_reached")
+	ErrCourseStrategyMissing  = errors.New("course_strategy_missing")
 )
 
 type strategySnapshot struct {
@@ -103,11 +106,20 @@ func (s *Store) Current(ctx context.Context, workspaceID int, userID int) (Curre
 	if reviewErr == nil {
 		review = &latestReview
 	}
+	openPack, packErr := s.openReviewPack(ctx, workspaceID, course.ID)
+	if packErr != nil && !errors.Is(packErr, sql.ErrNoRows) {
+		return CurrentResponse{}, fmt.Errorf("load course review pack: %w", packErr)
+	}
+	var reviewPack *CourseReviewPack
+	if packErr == nil {
+		reviewPack = &openPack
+	}
 	return CurrentResponse{
 		Course:        &course,
 		Strategy:      &strategy,
 		Sync:          &syncState,
 		LatestReview:  review,
+		ReviewPack:    reviewPack,
 		Sources:       buildCourseSources(snapshot.Artifacts),
 		KnowledgeBase: knowledgeBase,
 	}, nil
@@ -168,7 +180,7 @@ func (s *Store) Update(ctx context.Context, workspaceID int, courseID int, input
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, input.Title, input.Direction, input.StrategicGoal, input.Meaning, horizon, input.HorizonUnit,
 		input.StartDate, nullableString(input.EndDate), input.KeyMetric, input.SuccessCriterion,
 		SourceManual, courseID, workspaceID)
@@ -247,12 +259,12 @@ func (s *Store) createDraft(
 			activated_at=NULL,
 			archived_at=NULL,
 			updated_at=NOW()
-		WHERE workspace_id=$1 AND strategy_id=$2
+		WHERE workspace_id=$1 AND strategy_id=$2 AND is_primary
 		RETURNING
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, workspaceID, strategy.ID, snapshot.RunID, snapshot.SessionRevision,
 		draft.Title, draft.Direction, draft.StrategicGoal, draft.Meaning,
 		draft.Horizon, draft.HorizonUnit, draft.KeyMetric, draft.SuccessCriterion,
@@ -265,14 +277,14 @@ func (s *Store) createDraft(
 				workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 				title, direction, strategic_goal, meaning,
 				horizon, horizon_unit, start_date, end_date, key_metric, success_criterion,
-				status, source, created_by
+				status, source, created_by, is_primary
 			)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_DATE, CURRENT_DATE + ($9::INTEGER), $11, $12, $13, $14, $15)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CURRENT_DATE, CURRENT_DATE + ($9::INTEGER), $11, $12, $13, $14, $15, TRUE)
 			RETURNING
 				id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 				title, direction, strategic_goal, meaning,
 				horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-				success_criterion, status, source, created_by, created_at, updated_at, activated_at
+				success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 		`, workspaceID, strategy.ID, snapshot.RunID, snapshot.SessionRevision,
 			draft.Title, draft.Direction, draft.StrategicGoal, draft.Meaning,
 			draft.Horizon, draft.HorizonUnit, draft.KeyMetric, draft.SuccessCriterion,
@@ -301,6 +313,9 @@ func (s *Store) Refresh(ctx context.Context, workspaceID int, courseID int) (Cou
 	if err != nil {
 		return Course{}, err
 	}
+	if !current.IsPrimary {
+		return Course{}, ErrCourseNotPrimary
+	}
 	strategy, err := activeStrategyTx(ctx, tx, workspaceID)
 	if err != nil {
 		return Course{}, err
@@ -332,7 +347,7 @@ func (s *Store) Refresh(ctx context.Context, workspaceID int, courseID int) (Cou
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, snapshot.RunID, snapshot.SessionRevision, draft.Title, draft.Direction,
 		draft.StrategicGoal, draft.Meaning, *draft.Horizon, draft.HorizonUnit,
 		draft.KeyMetric, draft.SuccessCriterion, StatusDraft, SourceFromStrategy,
@@ -371,25 +386,29 @@ func (s *Store) Activate(ctx context.Context, workspaceID int, courseID int) (Co
 	if current.StrategyID != strategy.ID {
 		return Course{}, ErrCourseStrategyMismatch
 	}
-	snapshot, err := strategySnapshotTx(ctx, tx, workspaceID, strategy.ID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return Course{}, ErrCourseArtifactsMissing
+	// Additional courses are authored by hand and do not track the strategy
+	// snapshot, so only the primary course replaces its predecessor.
+	if current.IsPrimary {
+		snapshot, err := strategySnapshotTx(ctx, tx, workspaceID, strategy.ID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return Course{}, ErrCourseArtifactsMissing
+			}
+			return Course{}, err
+		}
+		if !snapshot.isCurrent() || current.SourceSynthesisRunID == nil ||
+			*current.SourceSynthesisRunID != snapshot.RunID ||
+			current.SourceSessionRevision != snapshot.SessionRevision {
+			return Course{}, ErrCourseStrategyStale
 		}
-		return Course{}, err
-	}
-	if !snapshot.isCurrent() || current.SourceSynthesisRunID == nil ||
-		*current.SourceSynthesisRunID != snapshot.RunID ||
-		current.SourceSessionRevision != snapshot.SessionRevision {
-		return Course{}, ErrCourseStrategyStale
-	}
 
-	if _, err := tx.ExecContext(ctx, `
-		UPDATE v2_courses
-		SET status=$1, archived_at=NOW(), updated_at=NOW()
-		WHERE workspace_id=$2 AND id<>$3 AND status=$4 AND archived_at IS NULL
-	`, StatusArchived, workspaceID, courseID, StatusActive); err != nil {
-		return Course{}, err
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE v2_courses
+			SET status=$1, archived_at=NOW(), updated_at=NOW()
+			WHERE workspace_id=$2 AND id<>$3 AND status=$4 AND is_primary AND archived_at IS NULL
+		`, StatusArchived, workspaceID, courseID, StatusActive); err != nil {
+			return Course{}, err
+		}
 	}
 
 	row := tx.QueryRowContext(ctx, `
@@ -400,7 +419,7 @@ func (s *Store) Activate(ctx context.Context, workspaceID int, courseID int) (Co
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, StatusActive, courseID, workspaceID)
 	activated, err := scanCourse(row)
 	if err != nil {
@@ -446,6 +465,11 @@ func (s *Store) Review(
 	if err != nil {
 		return CourseReview{}, Course{}, err
 	}
+	if input.ReviewPackID > 0 {
+		if err := confirmReviewPackTx(ctx, tx, workspaceID, courseID, input.ReviewPackID, review.ID); err != nil {
+			return CourseReview{}, Course{}, err
+		}
+	}
 
 	nextStatus := StatusActive
 	if input.Decision == "revise" {
@@ -461,7 +485,7 @@ func (s *Store) Review(
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, nextStatus, courseID, workspaceID)
 	updated, err := scanCourse(courseRow)
 	if err != nil {
@@ -479,9 +503,9 @@ func (s *Store) courseByStrategy(ctx context.Context, workspaceID int, strategyI
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 		FROM v2_courses
-	WHERE workspace_id=$1 AND strategy_id=$2 AND archived_at IS NULL AND status IN ($3, $4, $5, $6)
+	WHERE workspace_id=$1 AND strategy_id=$2 AND is_primary AND archived_at IS NULL AND status IN ($3, $4, $5, $6)
 	ORDER BY CASE status WHEN $4 THEN 1 WHEN $5 THEN 2 WHEN $6 THEN 3 ELSE 4 END, created_at DESC
 		LIMIT 1
 	`, workspaceID, strategyID, StatusDraft, StatusActive, StatusNeedsReview, StatusCompleted)
@@ -509,7 +533,7 @@ func (s *Store) markNeedsReview(ctx context.Context, workspaceID int, courseID i
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 	`, StatusNeedsReview, courseID, workspaceID, StatusArchived)
 	return scanCourse(row)
 }
@@ -520,7 +544,7 @@ func (s *Store) courseByID(ctx context.Context, workspaceID int, courseID int) (
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 		FROM v2_courses
 		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
 	`, courseID, workspaceID)
@@ -533,9 +557,9 @@ func courseByStrategyTx(ctx context.Context, tx *sql.Tx, workspaceID int, strate
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 		FROM v2_courses
-		WHERE workspace_id=$1 AND strategy_id=$2 AND archived_at IS NULL AND status IN ($3, $4)
+		WHERE workspace_id=$1 AND strategy_id=$2 AND is_primary AND archived_at IS NULL AND status IN ($3, $4)
 		ORDER BY CASE status WHEN $4 THEN 1 ELSE 2 END, created_at DESC
 		LIMIT 1
 	`, workspaceID, strategyID, StatusDraft, StatusActive)
@@ -548,7 +572,7 @@ func courseByIDTx(ctx context.Context, tx *sql.Tx, workspaceID int, courseID int
 			id, workspace_id, strategy_id, source_synthesis_run_id, source_session_revision,
 			title, direction, strategic_goal, meaning,
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
-			success_criterion, status, source, created_by, created_at, updated_at, activated_at
+			success_criterion, status, source, created_by, created_at, updated_at, activated_at, is_primary
 		FROM v2_courses
 		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
 		FOR UPDATE
@@ -920,6 +944,7 @@ func scanCourse(scanner scanner) (Course, error) {
 		&course.CreatedAt,
 		&course.UpdatedAt,
 		&activatedAt,
+		&course.IsPrimary,
 	)
 	if err != nil {
 		return Course{}, err
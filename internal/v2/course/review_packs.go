@@ -0,0 +1,546 @@
+package course
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/v2/metrics"
+)
+
+var (
+	ErrReviewScheduleInvalid = errors.New("course_review_schedule_invalid")
+	ErrReviewPackNotReady    = errors.New("course_review_pack_not_ready")
+)
+
+const reviewPackMaxAttempts = 3
+
+type reviewPackJob struct {
+	ID          int64
+	WorkspaceID int
+	CourseID    int
+	Kind        string
+	DueDate     string
+	Attempts    int
+}
+
+func (s *Store) ReviewSchedule(ctx context.Context, workspaceID int, courseID int) (CourseReviewSchedule, error) {
+	if _, err := s.courseByID(ctx, workspaceID, courseID); err != nil {
+		return CourseReviewSchedule{}, err
+	}
+	schedule, err := scanReviewSchedule(s.dbx.QueryRowContext(ctx, `
+		SELECT course_id, cadence_days, include_final, enabled, next_review_at::TEXT, updated_at
+		FROM v2_course_review_schedules
+		WHERE workspace_id=$1 AND course_id=$2
+	`, workspaceID, courseID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CourseReviewSchedule{
+			CourseID: courseID, CadenceDays: DefaultReviewCadenceDays, IncludeFinal: true,
+		}, nil
+	}
+	return schedule, err
+}
+
+// SaveReviewSchedule stores the cadence. When no explicit date is given the
+// first review lands one cadence after today.
+func (s *Store) SaveReviewSchedule(ctx context.Context, workspaceID int, userID int, courseID int, input CourseReviewScheduleInput) (CourseReviewSchedule, error) {
+	if input.CadenceDays == 0 {
+		input.CadenceDays = DefaultReviewCadenceDays
+	}
+	if input.CadenceDays < 1 || input.CadenceDays > MaxReviewCadenceDays {
+		return CourseReviewSchedule{}, ErrReviewScheduleInvalid
+	}
+	var nextReviewAt any
+	if input.NextReviewAt != nil && strings.TrimSpace(*input.NextReviewAt) != "" {
+		value := strings.TrimSpace(*input.NextReviewAt)
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return CourseReviewSchedule{}, ErrReviewScheduleInvalid
+		}
+		nextReviewAt = value
+	}
+	includeFinal := input.IncludeFinal == nil || *input.IncludeFinal
+	enabled := input.Enabled == nil || *input.Enabled
+	if _, err := s.courseByID(ctx, workspaceID, courseID); err != nil {
+		return CourseReviewSchedule{}, err
+	}
+	return scanReviewSchedule(s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_course_review_schedules (
+			workspace_id, course_id, cadence_days, include_final, enabled, next_review_at, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6::DATE, CURRENT_DATE + $3::INTEGER), $7)
+		ON CONFLICT (course_id) DO UPDATE SET
+			cadence_days=EXCLUDED.cadence_days,
+			include_final=EXCLUDED.include_final,
+			enabled=EXCLUDED.enabled,
+			next_review_at=CASE
+				WHEN $6::DATE IS NOT NULL THEN $6::DATE
+				WHEN v2_course_review_schedules.next_review_at IS NULL THEN EXCLUDED.next_review_at
+				ELSE v2_course_review_schedules.next_review_at
+			END,
+			updated_at=NOW()
+		RETURNING course_id, cadence_days, include_final, enabled, next_review_at::TEXT, updated_at
+	`, workspaceID, courseID, input.CadenceDays, includeFinal, enabled, nextReviewAt, userID))
+}
+
+func (s *Store) ReviewPacks(ctx context.Context, workspaceID int, courseID int, limit int) ([]CourseReviewPack, error) {
+	if _, err := s.courseByID(ctx, workspaceID, courseID); err != nil {
+		return nil, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, course_id, kind, due_date::TEXT, status, pack_json, draft_json, error,
+			review_id, created_at, prepared_at, confirmed_at
+		FROM v2_course_review_packs
+		WHERE workspace_id=$1 AND course_id=$2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, workspaceID, courseID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []CourseReviewPack{}
+	for rows.Next() {
+		item, err := scanReviewPack(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// openReviewPack returns the newest pack that still waits for the owner.
+func (s *Store) openReviewPack(ctx context.Context, workspaceID int, courseID int) (CourseReviewPack, error) {
+	return scanReviewPack(s.dbx.QueryRowContext(ctx, `
+		SELECT id, course_id, kind, due_date::TEXT, status, pack_json, draft_json, error,
+			review_id, created_at, prepared_at, confirmed_at
+		FROM v2_course_review_packs
+		WHERE workspace_id=$1 AND course_id=$2 AND status IN ($3, $4, $5)
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, workspaceID, courseID, ReviewPackQueued, ReviewPackPreparing, ReviewPackReady))
+}
+
+func (s *Store) RequestReviewPack(ctx context.Context, workspaceID int, userID int, courseID int) (CourseReviewPack, error) {
+	current, err := s.courseByID(ctx, workspaceID, courseID)
+	if err != nil {
+		return CourseReviewPack{}, err
+	}
+	if current.Status != StatusActive && current.Status != StatusNeedsReview {
+		return CourseReviewPack{}, ErrCourseReviewStatus
+	}
+	return scanReviewPack(s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_course_review_packs (workspace_id, course_id, kind, due_date, status, created_by)
+		VALUES ($1, $2, $3, CURRENT_DATE, $4, $5)
+		ON CONFLICT (course_id, kind, due_date) DO UPDATE SET
+			status=CASE
+				WHEN v2_course_review_packs.status IN ($6, $7) THEN EXCLUDED.status
+				ELSE v2_course_review_packs.status
+			END,
+			attempts=CASE
+				WHEN v2_course_review_packs.status IN ($6, $7) THEN 0
+				ELSE v2_course_review_packs.attempts
+			END,
+			not_before=NOW(),
+			updated_at=NOW()
+		RETURNING id, course_id, kind, due_date::TEXT, status, pack_json, draft_json, error,
+			review_id, created_at, prepared_at, confirmed_at
+	`, workspaceID, courseID, ReviewPackKindManual, ReviewPackQueued, userID, ReviewPackReady, ReviewPackFailed))
+}
+
+// queueDueReviewPacks turns due schedules and reached course end dates into
+// queued packs. The unique (course, kind, due date) key keeps it idempotent.
+func (s *Store) queueDueReviewPacks(ctx context.Context) (int64, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	periodic, err := tx.ExecContext(ctx, `
+		INSERT INTO v2_course_review_packs (workspace_id, course_id, kind, due_date, status)
+		SELECT schedule.workspace_id, schedule.course_id, $1, schedule.next_review_at, $2
+		FROM v2_course_review_schedules schedule
+		JOIN v2_courses course ON course.id=schedule.course_id
+		WHERE schedule.enabled
+			AND schedule.next_review_at <= CURRENT_DATE
+			AND course.archived_at IS NULL
+			AND course.status IN ($3, $4)
+		ON CONFLICT (course_id, kind, due_date) DO NOTHING
+	`, ReviewPackKindPeriodic, ReviewPackQueued, StatusActive, StatusNeedsReview)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE v2_course_review_schedules
+		SET next_review_at=next_review_at + cadence_days * ((CURRENT_DATE - next_review_at) / cadence_days + 1),
+			updated_at=NOW()
+		WHERE enabled AND next_review_at <= CURRENT_DATE
+	`); err != nil {
+		return 0, err
+	}
+	final, err := tx.ExecContext(ctx, `
+		INSERT INTO v2_course_review_packs (workspace_id, course_id, kind, due_date, status)
+		SELECT schedule.workspace_id, schedule.course_id, $1, course.end_date, $2
+		FROM v2_course_review_schedules schedule
+		JOIN v2_courses course ON course.id=schedule.course_id
+		WHERE schedule.enabled
+			AND schedule.include_final
+			AND course.end_date IS NOT NULL
+			AND course.end_date <= CURRENT_DATE
+			AND course.archived_at IS NULL
+			AND course.status IN ($3, $4)
+		ON CONFLICT (course_id, kind, due_date) DO NOTHING
+	`, ReviewPackKindFinal, ReviewPackQueued, StatusActive, StatusNeedsReview)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	periodicCount, _ := periodic.RowsAffected()
+	finalCount, _ := final.RowsAffected()
+	return periodicCount + finalCount, nil
+}
+
+func (s *Store) recoverStaleReviewPacks(ctx context.Context) error {
+	_, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_course_review_packs
+		SET status=$1, updated_at=NOW()
+		WHERE status=$2 AND updated_at < NOW() - INTERVAL '10 minutes'
+	`, ReviewPackQueued, ReviewPackPreparing)
+	return err
+}
+
+func (s *Store) claimReviewPack(ctx context.Context) (reviewPackJob, error) {
+	var job reviewPackJob
+	err := s.dbx.QueryRowContext(ctx, `
+		UPDATE v2_course_review_packs
+		SET status=$1, attempts=attempts + 1, updated_at=NOW()
+		WHERE id=(
+			SELECT id
+			FROM v2_course_review_packs
+			WHERE status=$2 AND not_before <= NOW()
+			ORDER BY not_before ASC, id ASC
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, workspace_id, course_id, kind, due_date::TEXT, attempts
+	`, ReviewPackPreparing, ReviewPackQueued).Scan(
+		&job.ID, &job.WorkspaceID, &job.CourseID, &job.Kind, &job.DueDate, &job.Attempts,
+	)
+	return job, err
+}
+
+func (s *Store) completeReviewPack(ctx context.Context, job reviewPackJob, pack ReviewPackContent, draft *CourseReviewDraft, model string, draftErr string) error {
+	var draftJSON any
+	if draft != nil {
+		draftJSON = mustReviewJSON(draft)
+	}
+	_, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_course_review_packs
+		SET status=$1, pack_json=$2, draft_json=$3, model=$4, error=$5,
+			prepared_at=NOW(), updated_at=NOW()
+		WHERE id=$6 AND status=$7
+	`, ReviewPackReady, mustReviewJSON(pack), draftJSON, model, draftErr, job.ID, ReviewPackPreparing)
+	return err
+}
+
+func (s *Store) failReviewPack(ctx context.Context, job reviewPackJob, message string) error {
+	status := ReviewPackQueued
+	if job.Attempts >= reviewPackMaxAttempts {
+		status = ReviewPackFailed
+	}
+	_, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_course_review_packs
+		SET status=$1, error=$2, not_before=NOW() + ($3::INTEGER * INTERVAL '1 minute'), updated_at=NOW()
+		WHERE id=$4 AND status=$5
+	`, status, truncateReviewText(message, 1000), job.Attempts*5, job.ID, ReviewPackPreparing)
+	return err
+}
+
+// confirmReviewPackTx links a submitted review to the pack it was drafted from.
+func confirmReviewPackTx(ctx context.Context, tx *sql.Tx, workspaceID int, courseID int, packID int64, reviewID int64) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_course_review_packs
+		SET status=$1, review_id=$2, confirmed_at=NOW(), updated_at=NOW()
+		WHERE id=$3 AND workspace_id=$4 AND course_id=$5 AND status=$6
+	`, ReviewPackConfirmed, reviewID, packID, workspaceID, courseID, ReviewPackReady)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrReviewPackNotReady
+	}
+	return nil
+}
+
+// BuildReviewPack collects the evidence for a review of the period since the
+// previous review, or since the course started when there is none.
+func (s *Store) BuildReviewPack(ctx context.Context, workspaceID int, courseID int) (Course, ReviewPackContent, error) {
+	current, err := s.courseByID(ctx, workspaceID, courseID)
+	if err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	pack := ReviewPackContent{
+		PeriodStart: current.StartDate,
+		PeriodEnd:   TodayString(),
+		Learnings:   []ReviewTaskLearning{},
+		Hypotheses:  []ReviewHypothesis{},
+		OpenRisks:   []ReviewRisk{},
+	}
+	if current.ActivatedAt != nil {
+		pack.PeriodStart = current.ActivatedAt.Format("2006-01-02")
+	}
+	lastReview, err := s.latestReview(ctx, workspaceID, courseID)
+	if err == nil {
+		pack.LastReview = &lastReview
+		pack.PeriodStart = lastReview.CreatedAt.Format("2006-01-02")
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Course{}, ReviewPackContent{}, err
+	}
+
+	targets, err := metrics.NewStore(s.dbx).Targets(ctx, workspaceID, metrics.ScopeStrategy, current.StrategyID)
+	if err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	if target, ok := courseKeyMetricTarget(current.KeyMetric, targets); ok {
+		trend := buildMetricTrend(target, pack.PeriodStart)
+		pack.KeyMetric = &trend
+	}
+
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status='done'),
+			COUNT(*) FILTER (WHERE status='in_progress'),
+			COUNT(*) FILTER (WHERE status='free'),
+			COUNT(*) FILTER (WHERE status='done' AND completed_at >= $3::DATE),
+			COUNT(*) FILTER (WHERE status<>'done' AND due_date < CURRENT_DATE)
+		FROM v2_tasks
+		WHERE workspace_id=$1 AND course_id=$2 AND archived_at IS NULL
+	`, workspaceID, courseID, pack.PeriodStart).Scan(
+		&pack.Tasks.Total, &pack.Tasks.Done, &pack.Tasks.InProgress, &pack.Tasks.Free,
+		&pack.Tasks.DoneInPeriod, &pack.Tasks.Overdue,
+	); err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	if pack.Tasks.Total > 0 {
+		pack.Tasks.CompletionRate = pack.Tasks.Done * 100 / pack.Tasks.Total
+	}
+
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, title, completion_learning, hypothesis_outcome, completed_at::DATE::TEXT
+		FROM v2_tasks
+		WHERE workspace_id=$1 AND course_id=$2 AND archived_at IS NULL AND status='done'
+			AND completed_at >= $3::DATE AND BTRIM(completion_learning) <> ''
+		ORDER BY completed_at DESC, id DESC
+		LIMIT 20
+	`, workspaceID, courseID, pack.PeriodStart)
+	if err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	for rows.Next() {
+		var item ReviewTaskLearning
+		if err := rows.Scan(&item.TaskID, &item.Title, &item.Learning, &item.HypothesisOutcome, &item.CompletedAt); err != nil {
+			rows.Close()
+			return Course{}, ReviewPackContent{}, err
+		}
+		pack.Learnings = append(pack.Learnings, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+
+	rows, err = s.dbx.QueryContext(ctx, `
+		SELECT hypothesis.id, hypothesis.title, hypothesis.status, hypothesis.evidence
+		FROM v2_tactical_hypotheses hypothesis
+		JOIN v2_tactical_plans plan ON plan.id=hypothesis.tactical_plan_id
+		WHERE hypothesis.workspace_id=$1 AND plan.course_id=$2
+			AND plan.archived_at IS NULL AND hypothesis.archived_at IS NULL
+		ORDER BY CASE hypothesis.status
+			WHEN 'confirmed' THEN 0 WHEN 'disproved' THEN 1 WHEN 'inconclusive' THEN 2
+			WHEN 'testing' THEN 3 ELSE 4 END, hypothesis.updated_at DESC
+		LIMIT 30
+	`, workspaceID, courseID)
+	if err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	for rows.Next() {
+		var item ReviewHypothesis
+		if err := rows.Scan(&item.ID, &item.Title, &item.Status, &item.Evidence); err != nil {
+			rows.Close()
+			return Course{}, ReviewPackContent{}, err
+		}
+		item.Evidence = truncateReviewText(item.Evidence, 600)
+		pack.Hypotheses = append(pack.Hypotheses, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+
+	rows, err = s.dbx.QueryContext(ctx, `
+		SELECT risk.id, risk.title, risk.severity, risk.status, risk.mitigation_plan
+		FROM v2_tactical_risks risk
+		JOIN v2_tactical_plans plan ON plan.id=risk.tactical_plan_id
+		WHERE risk.workspace_id=$1 AND plan.course_id=$2
+			AND plan.archived_at IS NULL AND risk.archived_at IS NULL
+			AND risk.status NOT IN ('closed', 'resolved', 'mitigated', 'archived')
+		ORDER BY CASE risk.severity WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'medium' THEN 2 ELSE 3 END,
+			risk.id ASC
+		LIMIT 20
+	`, workspaceID, courseID)
+	if err != nil {
+		return Course{}, ReviewPackContent{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ReviewRisk
+		if err := rows.Scan(&item.ID, &item.Title, &item.Severity, &item.Status, &item.MitigationPlan); err != nil {
+			return Course{}, ReviewPackContent{}, err
+		}
+		item.MitigationPlan = truncateReviewText(item.MitigationPlan, 600)
+		pack.OpenRisks = append(pack.OpenRisks, item)
+	}
+	return current, pack, rows.Err()
+}
+
+// courseKeyMetricTarget prefers the strategy-level target whose metric is named
+// like the course key metric, then the primary strategy metric.
+func courseKeyMetricTarget(keyMetric string, targets []metrics.Target) (metrics.Target, bool) {
+	name := strings.ToLower(strings.TrimSpace(keyMetric))
+	if name != "" {
+		for _, target := range targets {
+			metricName := strings.ToLower(strings.TrimSpace(target.Metric.Name))
+			if metricName != "" && (metricName == name || strings.Contains(name, metricName)) {
+				return target, true
+			}
+		}
+	}
+	for _, target := range targets {
+		if target.Role == metrics.RolePrimary {
+			return target, true
+		}
+	}
+	return metrics.Target{}, false
+}
+
+func buildMetricTrend(target metrics.Target, periodStart string) ReviewMetricTrend {
+	trend := ReviewMetricTrend{
+		Name: target.Metric.Name, Unit: firstNonEmpty(target.DisplayUnit, target.Metric.Unit),
+		BetterDirection: target.Metric.BetterDirection, Baseline: target.BaselineValue,
+		Target: target.TargetValue, TargetDate: target.TargetDate, Latest: target.LatestValue,
+		Trend: "no_data", Points: []ReviewMetricPoint{},
+	}
+	// Observations arrive newest first; the pack shows them chronologically.
+	for index := len(target.Observations) - 1; index >= 0; index-- {
+		observation := target.Observations[index]
+		trend.Points = append(trend.Points, ReviewMetricPoint{MeasuredAt: observation.MeasuredAt, Value: observation.Value})
+	}
+	if len(trend.Points) == 0 {
+		return trend
+	}
+	last := trend.Points[len(trend.Points)-1]
+	trend.Latest = &last.Value
+	start := trend.Points[0]
+	for _, point := range trend.Points {
+		if point.MeasuredAt <= periodStart {
+			start = point
+		}
+	}
+	change := last.Value - start.Value
+	trend.PeriodChange = &change
+	switch {
+	case len(trend.Points) == 1 || change == 0:
+		trend.Trend = "flat"
+	case (change > 0) == (target.Metric.BetterDirection != "decrease"):
+		trend.Trend = "improving"
+	default:
+		trend.Trend = "worsening"
+	}
+	return trend
+}
+
+func scanReviewSchedule(scanner scanner) (CourseReviewSchedule, error) {
+	var schedule CourseReviewSchedule
+	var nextReviewAt sql.NullString
+	var updatedAt time.Time
+	if err := scanner.Scan(
+		&schedule.CourseID, &schedule.CadenceDays, &schedule.IncludeFinal, &schedule.Enabled,
+		&nextReviewAt, &updatedAt,
+	); err != nil {
+		return CourseReviewSchedule{}, err
+	}
+	schedule.NextReviewAt = nullableStringPointer(nextReviewAt)
+	schedule.UpdatedAt = &updatedAt
+	return schedule, nil
+}
+
+func scanReviewPack(scanner scanner) (CourseReviewPack, error) {
+	var pack CourseReviewPack
+	var packJSON []byte
+	var draftJSON []byte
+	var reviewID sql.NullInt64
+	var preparedAt sql.NullTime
+	var confirmedAt sql.NullTime
+	if err := scanner.Scan(
+		&pack.ID, &pack.CourseID, &pack.Kind, &pack.DueDate, &pack.Status, &packJSON, &draftJSON,
+		&pack.Error, &reviewID, &pack.CreatedAt, &preparedAt, &confirmedAt,
+	); err != nil {
+		return CourseReviewPack{}, err
+	}
+	if len(packJSON) > 0 {
+		var content ReviewPackContent
+		if err := json.Unmarshal(packJSON, &content); err == nil {
+			pack.Pack = &content
+		}
+	}
+	if len(draftJSON) > 0 {
+		var draft CourseReviewDraft
+		if err := json.Unmarshal(draftJSON, &draft); err == nil {
+			pack.Draft = &draft
+		}
+	}
+	if reviewID.Valid {
+		pack.ReviewID = &reviewID.Int64
+	}
+	if preparedAt.Valid {
+		pack.PreparedAt = &preparedAt.Time
+	}
+	if confirmedAt.Valid {
+		pack.ConfirmedAt = &confirmedAt.Time
+	}
+	return pack, nil
+}
+
+func nullableStringPointer(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func mustReviewJSON(value any) []byte {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return []byte("{}")
+	}
+	return raw
+}
+
+func truncateReviewText(value string, limit int) string {
+	runes := []rune(strings.TrimSpace(value))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+	return string(runes[:limit])
+}
@@ -0,0 +1,70 @@
+package course
+
+import (
+	"testing"
+
+	"reup-goals-backend/internal/v2/metrics"
+)
+
+func TestCourseKeyMetricTargetPrefersNamedMetric(t *testing.T) {
+	targets := []metrics.Target{
+		{ID: 1, Role: metrics.RolePrimary, Metric: metrics.Definition{Name: "Выручка"}},
+		{ID: 2, Role: metrics.RoleSupporting, Metric: metrics.Definition{Name: "NPS"}},
+	}
+	target, ok := courseKeyMetricTarget("NPS клиентов", targets)
+	if !ok || target.ID != 2 {
+		t.Fatalf("expected named metric, got %+v (ok=%v)", target, ok)
+	}
+	target, ok = courseKeyMetricTarget("Доля рынка", targets)
+	if !ok || target.ID != 1 {
+		t.Fatalf("expected primary fallback, got %+v (ok=%v)", target, ok)
+	}
+	if _, ok := courseKeyMetricTarget("", nil); ok {
+		t.Fatal("expected no target without strategy metrics")
+	}
+}
+
+func TestBuildMetricTrendUsesPeriodStartAndDirection(t *testing.T) {
+	churn, ok := metrics.TemplateByKey("customer_churn")
+	if !ok {
+		t.Fatal("customer_churn missing from the metric catalog")
+	}
+	target := metrics.Target{
+		Metric: metrics.Definition{Name: churn.Name, BetterDirection: churn.BetterDirection},
+		Observations: []metrics.Observation{
+			{Value: 4, MeasuredAt: "2026-03-01"},
+			{Value: 6, MeasuredAt: "2026-02-01"},
+			{Value: 9, MeasuredAt: "2026-01-01"},
+		},
+	}
+	trend := buildMetricTrend(target, "2026-02-10")
+	if len(trend.Points) != 3 || trend.Points[0].MeasuredAt != "2026-01-01" {
+		t.Fatalf("points must be chronological: %+v", trend.Points)
+	}
+	if trend.PeriodChange == nil || *trend.PeriodChange != -2 {
+		t.Fatalf("expected change from the last point before the period, got %v", trend.PeriodChange)
+	}
+	if trend.Trend != "improving" || trend.Latest == nil || *trend.Latest != 4 {
+		t.Fatalf("unexpected trend: %+v", trend)
+	}
+	target.Observations[0].Value = 11
+	if worse := buildMetricTrend(target, "2026-02-10"); worse.Trend != "worsening" {
+		t.Fatalf("rising churn must be worsening, got %q", worse.Trend)
+	}
+	if empty := buildMetricTrend(metrics.Target{}, "2026-01-01"); empty.Trend != "no_data" {
+		t.Fatalf("expected no_data, got %q", empty.Trend)
+	}
+}
+
+func TestParseCourseReviewDraftDropsInvalidEnums(t *testing.T) {
+	draft, err := parseCourseReviewDraft(`{"result":"Сделали пилот","metric_result":"+5%","outcome":"great","decision":"continue"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if draft.Outcome != "" || draft.Decision != "continue" || draft.Result != "Сделали пилот" {
+		t.Fatalf("unexpected draft: %+v", draft)
+	}
+	if _, err := parseCourseReviewDraft(`{"result":" "}`); err == nil {
+		t.Fatal("empty draft must be rejected")
+	}
+}
@@ -0,0 +1,24 @@
+package course
+
+const courseReviewDrafterPromptVersion = "course_review_drafter_v1_0_0"
+
+const courseReviewDrafterPrompt = `You prepare a draft of a periodic course review inside REUP.goals.
+
+The input contains the company course (direction, strategic goal, key metric, success criterion, horizon) and a review pack for the period: key metric trend, task completion statistics, learnings recorded on completed tasks, hypothesis outcomes, open risks, and the previous review if any.
+
+Write the draft the course owner will edit and confirm. Rely only on the supplied evidence; when evidence is missing, say so briefly instead of guessing. Keep every field short, concrete, and in the language of the course texts.
+
+- result: what actually happened during the period, 2-5 sentences;
+- metric_result: the key metric movement against the baseline and target, or an explicit note that there is no data;
+- outcome: one of achieved, partially_achieved, not_achieved, changed;
+- decision: one of continue, revise, complete. Propose complete only when the success criterion is clearly met or the course has ended. Propose revise when the evidence shows the direction no longer works;
+- rationale: one or two sentences explaining the proposed outcome and decision.
+
+Return valid JSON only:
+{
+  "result": "",
+  "metric_result": "",
+  "outcome": "partially_achieved",
+  "decision": "continue",
+  "rationale": ""
+}`
@@ -0,0 +1,168 @@
+package course
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/ai"
+	"reup-goals-backend/internal/v2/strategicmemory"
+)
+
+const (
+	reviewPackPollInterval  = time.Minute
+	reviewPackDraftTimeout  = 90 * time.Second
+	reviewPackScheduleEvery = 15 * time.Minute
+)
+
+// ReviewService prepares review packs for courses whose review cadence is due
+// and asks the model for a draft the owner edits before submitting a review.
+type ReviewService struct {
+	store  *Store
+	memory *strategicmemory.Store
+	ai     ai.Provider
+	wake   chan struct{}
+}
+
+func NewReviewService(dbx *sql.DB, aiClient ai.Provider) *ReviewService {
+	return &ReviewService{
+		store: NewStore(dbx), memory: strategicmemory.NewStore(dbx), ai: aiClient,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func (s *ReviewService) StartWorker() {
+	recoveryCtx, recoveryCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_ = s.store.recoverStaleReviewPacks(recoveryCtx)
+	recoveryCancel()
+	go func() {
+		ticker := time.NewTicker(reviewPackPollInterval)
+		defer ticker.Stop()
+		var lastScheduled time.Time
+		for {
+			if time.Since(lastScheduled) >= reviewPackScheduleEvery {
+				lastScheduled = time.Now()
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				if _, err := s.store.queueDueReviewPacks(ctx); err != nil {
+					log.Printf("[WARN] queue course review packs: %v", err)
+				}
+				cancel()
+			}
+			s.processDue()
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+func (s *ReviewService) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ReviewService) processDue() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		job, err := s.store.claimReviewPack(ctx)
+		cancel()
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Printf("[WARN] claim course review pack: %v", err)
+			return
+		}
+		s.execute(job)
+	}
+}
+
+func (s *ReviewService) execute(job reviewPackJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), reviewPackDraftTimeout+30*time.Second)
+	defer cancel()
+	current, pack, err := s.store.BuildReviewPack(ctx, job.WorkspaceID, job.CourseID)
+	if err != nil {
+		if failErr := s.store.failReviewPack(ctx, job, err.Error()); failErr != nil {
+			log.Printf("[WARN] fail course review pack id=%d: %v", job.ID, failErr)
+		}
+		return
+	}
+	// A missing draft must not hide the evidence, so the pack is stored either way.
+	draft, draftErr := s.draft(ctx, job, current, pack)
+	errorText := ""
+	if draftErr != nil {
+		errorText = draftErr.Error()
+	}
+	if err := s.store.completeReviewPack(ctx, job, pack, draft, s.ai.ModelName(), errorText); err != nil {
+		log.Printf("[WARN] complete course review pack id=%d: %v", job.ID, err)
+	}
+}
+
+func (s *ReviewService) draft(ctx context.Context, job reviewPackJob, current Course, pack ReviewPackContent) (*CourseReviewDraft, error) {
+	rawInput, err := json.Marshal(map[string]any{
+		"course": map[string]any{
+			"title": current.Title, "direction": current.Direction, "strategic_goal": current.StrategicGoal,
+			"key_metric": current.KeyMetric, "success_criterion": current.SuccessCriterion,
+			"start_date": current.StartDate, "end_date": current.EndDate,
+			"horizon": current.Horizon, "horizon_unit": current.HorizonUnit,
+		},
+		"review_kind": job.Kind,
+		"due_date":    job.DueDate,
+		"pack":        pack,
+	})
+	if err != nil {
+		return nil, err
+	}
+	const scenario = "course_review_drafter"
+	aiCtx := ai.WithScenario(ctx, job.WorkspaceID, 0, scenario, courseReviewDrafterPromptVersion)
+	started := time.Now()
+	result, err := s.ai.GenerateJSONNative(aiCtx, courseReviewDrafterPrompt, string(rawInput), ai.ResponseContextOptions{
+		PromptCacheKey:  "reupgoals-course-review-drafter-v1",
+		MaxOutputTokens: 900,
+		RequestTimeout:  reviewPackDraftTimeout,
+	})
+	duration := time.Since(started).Milliseconds()
+	if err != nil {
+		s.memory.LogAIRunWithUsage(ctx, job.WorkspaceID, scenario, s.ai.ModelName(), courseReviewDrafterPromptVersion, duration, 0, 0, "failed", err.Error())
+		return nil, err
+	}
+	draft, err := parseCourseReviewDraft(result.Text)
+	if err != nil {
+		s.memory.LogAIRunWithUsage(ctx, job.WorkspaceID, scenario, s.ai.ModelName(), courseReviewDrafterPromptVersion, duration, result.Usage.InputTokens, result.Usage.OutputTokens, "failed", err.Error())
+		return nil, err
+	}
+	s.memory.LogAIRunWithUsage(ctx, job.WorkspaceID, scenario, s.ai.ModelName(), courseReviewDrafterPromptVersion, duration, result.Usage.InputTokens, result.Usage.OutputTokens, "success", "")
+	return &draft, nil
+}
+
+// parseCourseReviewDraft keeps the text fields and drops enum values the
+// review endpoint would reject, so the owner picks them instead.
+func parseCourseReviewDraft(raw string) (CourseReviewDraft, error) {
+	var draft CourseReviewDraft
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &draft); err != nil {
+		return CourseReviewDraft{}, fmt.Errorf("invalid course review draft: %w", err)
+	}
+	draft.Result = truncateReviewText(draft.Result, 4000)
+	draft.MetricResult = truncateReviewText(draft.MetricResult, 2000)
+	draft.Rationale = truncateReviewText(draft.Rationale, 1000)
+	draft.Outcome = strings.TrimSpace(draft.Outcome)
+	draft.Decision = strings.TrimSpace(draft.Decision)
+	if !ValidReviewOutcome(draft.Outcome) {
+		draft.Outcome = ""
+	}
+	if !ValidReviewDecision(draft.Decision) {
+		draft.Decision = ""
+	}
+	if draft.Result == "" {
+		return CourseReviewDraft{}, errors.New("course review draft is empty")
+	}
+	return draft, nil
+}
@@ -35,6 +35,9 @@ type Course struct {
 	CreatedAt             time.Time  `json:"created_at"`
 	UpdatedAt             time.Time  `json:"updated_at"`
 	ActivatedAt           *time.Time `json:"activated_at"`
+	// IsPrimary marks the course generated from the strategy; additional
+	// courses run alongside it with their own horizon and key metric.
+	IsPrimary bool `json:"is_primary"`
 }
 
 type StrategySummary struct {
@@ -84,6 +87,7 @@ type CurrentResponse struct {
 	Strategy      *StrategySummary     `json:"strategy,omitempty"`
 	Sync          *CourseSync          `json:"sync,omitempty"`
 	LatestReview  *CourseReview        `json:"latest_review,omitempty"`
+	ReviewPack    *CourseReviewPack    `json:"review_pack,omitempty"`
 	Sources       []CourseSource       `json:"sources"`
 	KnowledgeBase KnowledgeBaseSummary `json:"knowledge_base"`
 	Reason        string               `json:"reason,omitempty"`
@@ -107,6 +111,7 @@ type CourseReviewInput struct {
 	MetricResult string `json:"metric_result"`
 	Outcome      string `json:"outcome"`
 	Decision     string `json:"decision"`
+	ReviewPackID int64  `json:"review_pack_id"`
 }
 
 type CourseInput struct {
@@ -157,3 +162,184 @@ var requiredCourseFields = []string{
 	"key_metric",
 	"success_criterion",
 }
+
+const (
+	ReviewPackKindPeriodic = "periodic"
+	ReviewPackKindFinal    = "final"
+	ReviewPackKindManual   = "manual"
+
+	ReviewPackQueued    = "queued"
+	ReviewPackPreparing = "preparing"
+	ReviewPackReady     = "ready"
+	ReviewPackFailed    = "failed"
+	ReviewPackConfirmed = "confirmed"
+
+	DefaultReviewCadenceDays = 14
+	MaxReviewCadenceDays     = 180
+)
+
+type CourseReviewSchedule struct {
+	CourseID     int        `json:"course_id"`
+	CadenceDays  int        `json:"cadence_days"`
+	IncludeFinal bool       `json:"include_final"`
+	Enabled      bool       `json:"enabled"`
+	NextReviewAt *string    `json:"next_review_at"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+}
+
+type CourseReviewScheduleInput struct {
+	CadenceDays  int     `json:"cadence_days"`
+	IncludeFinal *bool   `json:"include_final"`
+	Enabled      *bool   `json:"enabled"`
+	NextReviewAt *string `json:"next_review_at"`
+}
+
+type CourseReviewPack struct {
+	ID          int64              `json:"id"`
+	CourseID    int                `json:"course_id"`
+	Kind        string             `json:"kind"`
+	DueDate     string             `json:"due_date"`
+	Status      string             `json:"status"`
+	Pack        *ReviewPackContent `json:"pack,omitempty"`
+	Draft       *CourseReviewDraft `json:"draft,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	ReviewID    *int64             `json:"review_id,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	PreparedAt  *time.Time         `json:"prepared_at,omitempty"`
+	ConfirmedAt *time.Time         `json:"confirmed_at,omitempty"`
+}
+
+type ReviewPackContent struct {
+	PeriodStart string               `json:"period_start"`
+	PeriodEnd   string               `json:"period_end"`
+	KeyMetric   *ReviewMetricTrend   `json:"key_metric,omitempty"`
+	Tasks       ReviewTaskStats      `json:"tasks"`
+	Learnings   []ReviewTaskLearning `json:"learnings"`
+	Hypotheses  []ReviewHypothesis   `json:"hypotheses"`
+	OpenRisks   []ReviewRisk         `json:"open_risks"`
+	LastReview  *CourseReview        `json:"last_review,omitempty"`
+}
+
+type ReviewMetricTrend struct {
+	Name            string              `json:"name"`
+	Unit            string              `json:"unit"`
+	BetterDirection string              `json:"better_direction"`
+	Baseline        *float64            `json:"baseline,omitempty"`
+	Target          *float64            `json:"target,omitempty"`
+	TargetDate      string              `json:"target_date,omitempty"`
+	Latest          *float64            `json:"latest,omitempty"`
+	PeriodChange    *float64            `json:"period_change,omitempty"`
+	Trend           string              `json:"trend"`
+	Points          []ReviewMetricPoint `json:"points"`
+}
+
+type ReviewMetricPoint struct {
+	MeasuredAt string  `json:"measured_at"`
+	Value      float64 `json:"value"`
+}
+
+type ReviewTaskStats struct {
+	Total          int `json:"total"`
+	Done           int `json:"done"`
+	InProgress     int `json:"in_progress"`
+	Free           int `json:"free"`
+	DoneInPeriod   int `json:"done_in_period"`
+	Overdue        int `json:"overdue"`
+	CompletionRate int `json:"completion_rate"`
+}
+
+type ReviewTaskLearning struct {
+	TaskID            int    `json:"task_id"`
+	Title             string `json:"title"`
+	Learning          string `json:"learning"`
+	HypothesisOutcome string `json:"hypothesis_outcome,omitempty"`
+	CompletedAt       string `json:"completed_at"`
+}
+
+type ReviewHypothesis struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status"`
+	Evidence string `json:"evidence"`
+}
+
+type ReviewRisk struct {
+	ID             int    `json:"id"`
+	Title          string `json:"title"`
+	Severity       string `json:"severity"`
+	Status         string `json:"status"`
+	MitigationPlan string `json:"mitigation_plan"`
+}
+
+type CourseReviewDraft struct {
+	Result       string `json:"result"`
+	MetricResult string `json:"metric_result"`
+	Outcome      string `json:"outcome"`
+	Decision     string `json:"decision"`
+	Rationale    string `json:"rationale,omitempty"`
+}
+
+const (
+	// MaxOpenCoursesPerStrategy caps the primary course plus the additional
+	// courses that may be drafted or running for one strategy at a time.
+	MaxOpenCoursesPerStrategy = 5
+
+	PortfolioConflictSharedKeyMetric = "shared_key_metric"
+	PortfolioConflictSharedPeople    = "shared_people"
+	PortfolioConflictUnstaffed       = "unstaffed_course"
+)
+
+type CoursePortfolio struct {
+	Strategy            *StrategySummary    `json:"strategy"`
+	Courses             []PortfolioCourse   `json:"courses"`
+	People              []PortfolioPerson   `json:"people"`
+	Conflicts           []PortfolioConflict `json:"conflicts"`
+	UnlinkedWorkstreams int                 `json:"unlinked_workstreams"`
+	Reason              string              `json:"reason,omitempty"`
+}
+
+type PortfolioCourse struct {
+	Course      Course             `json:"course"`
+	Progress    CourseProgress     `json:"progress"`
+	Tasks       PortfolioTaskStats `json:"tasks"`
+	Workstreams int                `json:"workstreams"`
+	People      int                `json:"people"`
+	// TaskShare is the course's percentage of all open portfolio tasks.
+	TaskShare int `json:"task_share"`
+}
+
+type CourseProgress struct {
+	ElapsedPercent int                `json:"elapsed_percent"`
+	DaysLeft       *int               `json:"days_left"`
+	Metric         *ReviewMetricTrend `json:"metric,omitempty"`
+	MetricPercent  *int               `json:"metric_percent"`
+	TaskPercent    int                `json:"task_percent"`
+}
+
+type PortfolioTaskStats struct {
+	Total      int `json:"total"`
+	Free       int `json:"free"`
+	InProgress int `json:"in_progress"`
+	Done       int `json:"done"`
+	Unassigned int `json:"unassigned"`
+}
+
+type PortfolioPerson struct {
+	UserID    int                   `json:"user_id"`
+	Name      string                `json:"name"`
+	OpenTasks int                   `json:"open_tasks"`
+	Courses   []PortfolioPersonLoad `json:"courses"`
+}
+
+type PortfolioPersonLoad struct {
+	CourseID  int `json:"course_id"`
+	OpenTasks int `json:"open_tasks"`
+}
+
+type PortfolioConflict struct {
+	Kind      string `json:"kind"`
+	Severity  string `json:"severity"`
+	CourseIDs []int  `json:"course_ids"`
+	UserID    *int   `json:"user_id,omitempty"`
+	Message   string `json:"message"`
+}
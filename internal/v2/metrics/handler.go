@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/v2/api"
@@ -183,7 +184,8 @@ func validTargetInput(input TargetInput) bool {
 }
 
 func validScope(value string) bool {
-	return value == ScopeWorkspace || value == ScopeStrategy || value == ScopeWorkstream || value == ScopeProject
+	return value == ScopeWorkspace || value == ScopeStrategy || value == ScopeWorkstream ||
+		value == ScopeProject || value == ScopeDepartment
 }
 
 func validRole(value string) bool {
@@ -220,3 +222,279 @@ func writeMetricEntity(w http.ResponseWriter, err error, status int, key string,
 	}
 	api.WriteJSON(w, status, map[string]any{key: value})
 }
+
+func (h *Handler) OKR(w http.ResponseWriter, r *http.Request) {
+	workspace, userID, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/api/v2/okr/objectives":
+		h.objectives(w, r, workspace.ID, userID)
+	case strings.HasPrefix(r.URL.Path, "/api/v2/okr/objectives/"):
+		h.objective(w, r, workspace.ID, userID)
+	case strings.HasPrefix(r.URL.Path, "/api/v2/okr/key-results/"):
+		h.keyResult(w, r, workspace.ID, userID)
+	case r.URL.Path == "/api/v2/okr/export":
+		h.okrExport(w, r, workspace.ID)
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+func (h *Handler) objectives(w http.ResponseWriter, r *http.Request, workspaceID int, userID int) {
+	switch r.Method {
+	case http.MethodGet:
+		quarter, ok := requestQuarter(w, r)
+		if !ok {
+			return
+		}
+		level := strings.TrimSpace(r.URL.Query().Get("level"))
+		levelID, _ := strconv.Atoi(r.URL.Query().Get("level_id"))
+		if level != "" && !validOKRLevel(level) {
+			api.WriteError(w, http.StatusBadRequest, "invalid_okr_level")
+			return
+		}
+		items, err := h.store.Objectives(r.Context(), workspaceID, quarter, level, levelID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "okr_objectives_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"quarter": quarter, "objectives": items})
+	case http.MethodPost:
+		var input ObjectiveInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+		normalizeObjectiveInput(&input)
+		if input.Level == "" {
+			input.Level = OKRLevelWorkspace
+		}
+		if input.Quarter == "" {
+			input.Quarter = QuarterOf(time.Now())
+		}
+		if input.Status == "" {
+			input.Status = OKRStatusActive
+		}
+		if !validObjectiveInput(input) {
+			api.WriteError(w, http.StatusBadRequest, "invalid_okr_objective")
+			return
+		}
+		item, err := h.store.CreateObjective(r.Context(), workspaceID, userID, input)
+		writeOKREntity(w, err, http.StatusCreated, "objective", item, "okr_objective_create_failed")
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+func (h *Handler) objective(w http.ResponseWriter, r *http.Request, workspaceID int, userID int) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2/okr/objectives/"), "/")
+	if strings.HasSuffix(path, "/key-results") {
+		objectiveID, err := strconv.ParseInt(strings.TrimSuffix(path, "/key-results"), 10, 64)
+		if err != nil || objectiveID <= 0 {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		if r.Method != http.MethodPost {
+			api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		var input KeyResultInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+		if input.Target != nil {
+			normalizeTargetInput(input.Target)
+			applyTargetDefaults(input.Target)
+			scoped := *input.Target
+			if scoped.ScopeType == "" {
+				// The store scopes the new target to the objective level.
+				scoped.ScopeType = ScopeWorkspace
+			}
+			if !validTargetInput(scoped) {
+				api.WriteError(w, http.StatusBadRequest, "invalid_metric_target")
+				return
+			}
+		}
+		if !validKeyResultWeight(input.Weight) {
+			api.WriteError(w, http.StatusBadRequest, "invalid_okr_key_result")
+			return
+		}
+		item, err := h.store.AddKeyResult(r.Context(), workspaceID, userID, objectiveID, input)
+		writeOKREntity(w, err, http.StatusCreated, "key_result", item, "okr_key_result_create_failed")
+		return
+	}
+
+	objectiveID, err := strconv.ParseInt(path, 10, 64)
+	if err != nil || objectiveID <= 0 {
+		api.WriteError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		item, err := h.store.Objective(r.Context(), workspaceID, objectiveID)
+		writeOKREntity(w, err, http.StatusOK, "objective", item, "okr_objective_failed")
+	case http.MethodPatch:
+		var input ObjectiveInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+		normalizeObjectiveInput(&input)
+		if (input.Level != "" && !validOKRLevel(input.Level)) ||
+			(input.Quarter != "" && !validQuarter(input.Quarter)) ||
+			(input.Status != "" && !validOKRStatus(input.Status)) {
+			api.WriteError(w, http.StatusBadRequest, "invalid_okr_objective")
+			return
+		}
+		item, err := h.store.UpdateObjective(r.Context(), workspaceID, objectiveID, input)
+		writeOKREntity(w, err, http.StatusOK, "objective", item, "okr_objective_update_failed")
+	case http.MethodDelete:
+		err := h.store.ArchiveObjective(r.Context(), workspaceID, objectiveID)
+		writeOKREntity(w, err, http.StatusOK, "ok", true, "okr_objective_archive_failed")
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+func (h *Handler) keyResult(w http.ResponseWriter, r *http.Request, workspaceID int, userID int) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2/okr/key-results/"), "/")
+	if strings.HasSuffix(path, "/check-ins") {
+		keyResultID, err := strconv.ParseInt(strings.TrimSuffix(path, "/check-ins"), 10, 64)
+		if err != nil || keyResultID <= 0 {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if _, err := h.store.KeyResult(r.Context(), workspaceID, keyResultID); err != nil {
+				writeOKREntity(w, err, http.StatusOK, "check_ins", nil, "okr_check_ins_failed")
+				return
+			}
+			items, err := h.store.CheckIns(r.Context(), workspaceID, keyResultID, 200)
+			writeOKREntity(w, err, http.StatusOK, "check_ins", items, "okr_check_ins_failed")
+		case http.MethodPost:
+			var input CheckInInput
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				api.WriteError(w, http.StatusBadRequest, "invalid_json")
+				return
+			}
+			if input.Confidence != nil && (*input.Confidence < 0 || *input.Confidence > 1) {
+				api.WriteError(w, http.StatusBadRequest, "invalid_okr_check_in")
+				return
+			}
+			item, err := h.store.CheckIn(r.Context(), workspaceID, userID, keyResultID, input)
+			writeOKREntity(w, err, http.StatusCreated, "check_in", item, "okr_check_in_failed")
+		default:
+			api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		}
+		return
+	}
+
+	keyResultID, err := strconv.ParseInt(path, 10, 64)
+	if err != nil || keyResultID <= 0 {
+		api.WriteError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		item, err := h.store.KeyResult(r.Context(), workspaceID, keyResultID)
+		writeOKREntity(w, err, http.StatusOK, "key_result", item, "okr_key_result_failed")
+	case http.MethodPatch:
+		var input KeyResultInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+		if !validKeyResultWeight(input.Weight) {
+			api.WriteError(w, http.StatusBadRequest, "invalid_okr_key_result")
+			return
+		}
+		item, err := h.store.UpdateKeyResult(r.Context(), workspaceID, keyResultID, input)
+		writeOKREntity(w, err, http.StatusOK, "key_result", item, "okr_key_result_update_failed")
+	case http.MethodDelete:
+		err := h.store.ArchiveKeyResult(r.Context(), workspaceID, keyResultID)
+		writeOKREntity(w, err, http.StatusOK, "ok", true, "okr_key_result_archive_failed")
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+func (h *Handler) okrExport(w http.ResponseWriter, r *http.Request, workspaceID int) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	quarter, ok := requestQuarter(w, r)
+	if !ok {
+		return
+	}
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	if format != "" && format != "json" && format != "csv" {
+		api.WriteError(w, http.StatusBadRequest, "invalid_export_format")
+		return
+	}
+	objectives, err := h.store.Export(r.Context(), workspaceID, quarter)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "okr_export_failed")
+		return
+	}
+	export := BuildOKRExport(quarter, objectives, time.Now())
+	if format != "csv" {
+		api.WriteJSON(w, http.StatusOK, export)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="okr-`+quarter+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+	_ = WriteOKRCSV(w, export)
+}
+
+func requestQuarter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	quarter := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("quarter")))
+	if quarter == "" {
+		return QuarterOf(time.Now()), true
+	}
+	if !validQuarter(quarter) {
+		api.WriteError(w, http.StatusBadRequest, "invalid_okr_quarter")
+		return "", false
+	}
+	return quarter, true
+}
+
+func validObjectiveInput(input ObjectiveInput) bool {
+	return input.Title != "" && validOKRLevel(input.Level) &&
+		(input.Level == OKRLevelWorkspace || (input.LevelID != nil && *input.LevelID > 0)) &&
+		validQuarter(input.Quarter) && validOKRStatus(input.Status)
+}
+
+func validOKRLevel(value string) bool {
+	return value == OKRLevelWorkspace || value == OKRLevelDepartment || value == OKRLevelWorkstream
+}
+
+func validOKRStatus(value string) bool {
+	return value == OKRStatusDraft || value == OKRStatusActive || value == OKRStatusClosed
+}
+
+func validQuarter(value string) bool {
+	_, _, ok := ParseQuarter(value)
+	return ok
+}
+
+func validKeyResultWeight(value *float64) bool {
+	return value == nil || (*value > 0 && *value <= 100)
+}
+
+func writeOKREntity(w http.ResponseWriter, err error, status int, key string, value any, internalCode string) {
+	switch {
+	case errors.Is(err, ErrObjectiveParentInvalid), errors.Is(err, ErrKeyResultTargetRequired):
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrKeyResultDuplicate):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		writeMetricEntity(w, err, status, key, value, internalCode)
+	}
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ScoreProgress grades the latest value on the 0–1.0 OKR scale. Increase and
+// decrease metrics score the share of the baseline→target distance covered;
+// range metrics treat baseline and target as the bounds of the healthy band.
+// A missing baseline counts from zero. Nil means there is nothing to score.
+func ScoreProgress(direction string, baseline *float64, target *float64, latest *float64) *float64 {
+	if target == nil || latest == nil {
+		return nil
+	}
+	start := 0.0
+	if baseline != nil {
+		start = *baseline
+	}
+	var score float64
+	switch {
+	case direction == "range":
+		low, high := math.Min(start, *target), math.Max(start, *target)
+		switch {
+		case *latest >= low && *latest <= high:
+			score = 1
+		case high == low:
+			score = 0
+		case *latest < low:
+			score = 1 - (low-*latest)/(high-low)
+		default:
+			score = 1 - (*latest-high)/(high-low)
+		}
+	case *target == start:
+		if *latest == *target || Improving(direction, *latest-*target) {
+			score = 1
+		}
+	default:
+		score = (*latest - start) / (*target - start)
+	}
+	score = math.Round(math.Max(0, math.Min(1, score))*100) / 100
+	return &score
+}
+
+// ScoreTarget scores a metric target from its latest observation.
+func ScoreTarget(target Target) *float64 {
+	return ScoreProgress(target.Metric.BetterDirection, target.BaselineValue, target.TargetValue, target.LatestValue)
+}
+
+// Improving reports whether a change moves the metric in its better
+// direction.
+func Improving(direction string, change float64) bool {
+	if direction == "decrease" {
+		return change < 0
+	}
+	return change > 0
+}
+
+func OKRGrade(score *float64) string {
+	switch {
+	case score == nil:
+		return OKRGradeNoData
+	case *score >= 0.7:
+		return OKRGradeOnTrack
+	case *score >= 0.4:
+		return OKRGradeAtRisk
+	default:
+		return OKRGradeOffTrack
+	}
+}
+
+// ObjectiveScore is the weighted mean of the scored key results; key results
+// without data are left out rather than counted as zero.
+func ObjectiveScore(keyResults []KeyResult) *float64 {
+	total, weights := 0.0, 0.0
+	for _, keyResult := range keyResults {
+		if keyResult.Score == nil || keyResult.Weight <= 0 {
+			continue
+		}
+		total += *keyResult.Score * keyResult.Weight
+		weights += keyResult.Weight
+	}
+	if weights == 0 {
+		return nil
+	}
+	score := math.Round(total/weights*100) / 100
+	return &score
+}
+
+// scoreObjective fills key result and objective scores in place.
+func scoreObjective(objective *Objective) {
+	for index := range objective.KeyResults {
+		keyResult := &objective.KeyResults[index]
+		keyResult.Score = ScoreTarget(keyResult.Target)
+		keyResult.Grade = OKRGrade(keyResult.Score)
+	}
+	objective.Score = ObjectiveScore(objective.KeyResults)
+	objective.Grade = OKRGrade(objective.Score)
+}
+
+func QuarterOf(value time.Time) string {
+	return fmt.Sprintf("%d-Q%d", value.Year(), (int(value.Month())-1)/3+1)
+}
+
+// ParseQuarter returns the first and last day of a "2026-Q3" quarter.
+func ParseQuarter(value string) (time.Time, time.Time, bool) {
+	if len(value) != 7 || value[4:6] != "-Q" {
+		return time.Time{}, time.Time{}, false
+	}
+	year, err := strconv.Atoi(value[:4])
+	if err != nil || year < 2000 || year > 2100 {
+		return time.Time{}, time.Time{}, false
+	}
+	quarter := int(value[6] - '0')
+	if quarter < 1 || quarter > 4 {
+		return time.Time{}, time.Time{}, false
+	}
+	start := time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 3, -1), true
+}
+
+func BuildOKRExport(quarter string, objectives []Objective, now time.Time) OKRExport {
+	export := OKRExport{
+		Format:      OKRExportFormat,
+		Quarter:     quarter,
+		GeneratedAt: now.UTC(),
+		Objectives:  make([]OKRExportObjective, 0, len(objectives)),
+	}
+	total, count := 0.0, 0
+	for _, objective := range objectives {
+		item := OKRExportObjective{
+			ID: objective.ID, ParentID: objective.ParentID, Level: objective.Level, LevelName: objective.LevelName,
+			Title: objective.Title, Description: objective.Description, OwnerUserID: objective.OwnerUserID,
+			Status: objective.Status, Score: objective.Score, Grade: objective.Grade,
+			KeyResults: make([]OKRExportKeyResult, 0, len(objective.KeyResults)),
+		}
+		for _, keyResult := range objective.KeyResults {
+			row := OKRExportKeyResult{
+				ID: keyResult.ID, Title: keyResultTitle(keyResult), Metric: keyResult.Target.Metric.Name,
+				Unit:       firstNonEmpty(keyResult.Target.DisplayUnit, keyResult.Target.Metric.Unit),
+				Direction:  keyResult.Target.Metric.BetterDirection,
+				StartValue: keyResult.Target.BaselineValue, TargetValue: keyResult.Target.TargetValue,
+				CurrentValue: keyResult.Target.LatestValue, TargetDate: keyResult.Target.TargetDate,
+				Weight: keyResult.Weight, Score: keyResult.Score, Grade: keyResult.Grade,
+			}
+			if keyResult.LastCheckIn != nil {
+				confidence := keyResult.LastCheckIn.Confidence
+				row.Confidence = &confidence
+				row.LastCheckInAt = keyResult.LastCheckIn.CreatedAt.UTC().Format(time.RFC3339)
+			}
+			item.KeyResults = append(item.KeyResults, row)
+		}
+		if objective.Score != nil {
+			total += *objective.Score
+			count++
+		}
+		export.Objectives = append(export.Objectives, item)
+	}
+	if count > 0 {
+		score := math.Round(total/float64(count)*100) / 100
+		export.Score = &score
+	}
+	return export
+}
+
+var okrCSVHeader = []string{
+	"quarter", "objective_id", "parent_objective_id", "level", "level_name", "objective", "objective_status",
+	"objective_score", "objective_grade", "key_result_id", "key_result", "metric", "unit", "direction",
+	"start_value", "target_value", "current_value", "target_date", "weight", "score", "grade",
+	"confidence", "last_check_in_at",
+}
+
+// WriteOKRCSV writes one row per key result, repeating objective columns;
+// objectives without key results still get a row so none go missing.
+func WriteOKRCSV(w io.Writer, export OKRExport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(okrCSVHeader); err != nil {
+		return err
+	}
+	for _, objective := range export.Objectives {
+		prefix := []string{
+			export.Quarter, strconv.FormatInt(objective.ID, 10), formatOptionalID(objective.ParentID),
+			objective.Level, objective.LevelName, objective.Title, objective.Status,
+			formatOptionalFloat(objective.Score), objective.Grade,
+		}
+		if len(objective.KeyResults) == 0 {
+			if err := writer.Write(append(prefix, make([]string, len(okrCSVHeader)-len(prefix))...)); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, keyResult := range objective.KeyResults {
+			row := append(append([]string{}, prefix...),
+				strconv.FormatInt(keyResult.ID, 10), keyResult.Title, keyResult.Metric, keyResult.Unit,
+				keyResult.Direction, formatOptionalFloat(keyResult.StartValue), formatOptionalFloat(keyResult.TargetValue),
+				formatOptionalFloat(keyResult.CurrentValue), keyResult.TargetDate,
+				strconv.FormatFloat(keyResult.Weight, 'f', -1, 64), formatOptionalFloat(keyResult.Score),
+				keyResult.Grade, formatOptionalFloat(keyResult.Confidence), keyResult.LastCheckInAt,
+			)
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func keyResultTitle(keyResult KeyResult) string {
+	return firstNonEmpty(keyResult.Title, keyResult.Target.Metric.Name)
+}
+
+func formatOptionalFloat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+func formatOptionalID(value *int64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatInt(*value, 10)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,638 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrObjectiveParentInvalid  = errors.New("okr_objective_parent_invalid")
+	ErrKeyResultTargetRequired = errors.New("okr_key_result_target_required")
+	ErrKeyResultDuplicate      = errors.New("okr_key_result_duplicate")
+)
+
+const objectiveSelect = `
+	SELECT objective.id, objective.workspace_id, objective.level, objective.level_id,
+		COALESCE(department.name, workstream.title, ''), objective.parent_id, objective.quarter,
+		objective.title, objective.description, objective.owner_user_id, objective.status,
+		objective.created_at, objective.updated_at
+	FROM v2_okr_objectives objective
+	LEFT JOIN v2_departments department
+		ON objective.level='department' AND department.id=objective.level_id
+	LEFT JOIN v2_tactical_workstreams workstream
+		ON objective.level='workstream' AND workstream.id=objective.level_id
+`
+
+// Objectives lists a quarter's objectives with scored key results. Level and
+// levelID narrow the list to one workspace, department or workstream.
+func (s *Store) Objectives(ctx context.Context, workspaceID int, quarter string, level string, levelID int) ([]Objective, error) {
+	query := objectiveSelect + `
+		WHERE objective.workspace_id=$1 AND objective.quarter=$2 AND objective.archived_at IS NULL
+	`
+	args := []any{workspaceID, quarter}
+	if level != "" {
+		query += ` AND objective.level=$3`
+		args = append(args, level)
+		if level != OKRLevelWorkspace && levelID > 0 {
+			query += ` AND objective.level_id=$4`
+			args = append(args, levelID)
+		}
+	}
+	query += `
+		ORDER BY CASE objective.level WHEN 'workspace' THEN 0 WHEN 'department' THEN 1 ELSE 2 END,
+			objective.level_id NULLS FIRST, objective.sort_order, objective.id
+	`
+	rows, err := s.dbx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	objectives := []Objective{}
+	for rows.Next() {
+		objective, err := scanObjective(rows)
+		if err != nil {
+			return nil, err
+		}
+		objectives = append(objectives, objective)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.hydrateKeyResults(ctx, workspaceID, objectives, false); err != nil {
+		return nil, err
+	}
+	return objectives, nil
+}
+
+// Objective returns one objective with the full check-in history of each key
+// result.
+func (s *Store) Objective(ctx context.Context, workspaceID int, objectiveID int64) (Objective, error) {
+	objective, err := s.objectiveByID(ctx, workspaceID, objectiveID)
+	if err != nil {
+		return Objective{}, err
+	}
+	objectives := []Objective{objective}
+	if err := s.hydrateKeyResults(ctx, workspaceID, objectives, true); err != nil {
+		return Objective{}, err
+	}
+	return objectives[0], nil
+}
+
+func (s *Store) CreateObjective(ctx context.Context, workspaceID int, userID int, input ObjectiveInput) (Objective, error) {
+	normalizeObjectiveInput(&input)
+	if err := s.validateObjective(ctx, workspaceID, 0, input); err != nil {
+		return Objective{}, err
+	}
+	var objectiveID int64
+	err := s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_okr_objectives (
+			workspace_id, level, level_id, parent_id, quarter, title, description,
+			owner_user_id, status, sort_order, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, (
+			SELECT COALESCE(MAX(sort_order), 0) + 1
+			FROM v2_okr_objectives
+			WHERE workspace_id=$1 AND quarter=$5
+		), $10)
+		RETURNING id
+	`, workspaceID, input.Level, objectiveLevelID(input), nullableObjectiveID(input.ParentID), input.Quarter,
+		input.Title, input.Description, input.OwnerUserID, input.Status, userID).Scan(&objectiveID)
+	if err != nil {
+		return Objective{}, err
+	}
+	return s.Objective(ctx, workspaceID, objectiveID)
+}
+
+// UpdateObjective merges input over the stored objective. A parent_id of 0
+// detaches the objective from its parent.
+func (s *Store) UpdateObjective(ctx context.Context, workspaceID int, objectiveID int64, input ObjectiveInput) (Objective, error) {
+	current, err := s.objectiveByID(ctx, workspaceID, objectiveID)
+	if err != nil {
+		return Objective{}, err
+	}
+	normalizeObjectiveInput(&input)
+	if input.Level == "" {
+		input.Level = current.Level
+		input.LevelID = current.LevelID
+	}
+	if input.ParentID == nil {
+		input.ParentID = current.ParentID
+	}
+	if input.Quarter == "" {
+		input.Quarter = current.Quarter
+	}
+	if input.Title == "" {
+		input.Title = current.Title
+	}
+	if input.Description == "" {
+		input.Description = current.Description
+	}
+	if input.OwnerUserID == nil {
+		input.OwnerUserID = current.OwnerUserID
+	}
+	if input.Status == "" {
+		input.Status = current.Status
+	}
+	if err := s.validateObjective(ctx, workspaceID, objectiveID, input); err != nil {
+		return Objective{}, err
+	}
+	result, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_okr_objectives
+		SET level=$1, level_id=$2, parent_id=$3, quarter=$4, title=$5, description=$6,
+			owner_user_id=$7, status=$8, updated_at=NOW()
+		WHERE id=$9 AND workspace_id=$10 AND archived_at IS NULL
+	`, input.Level, objectiveLevelID(input), nullableObjectiveID(input.ParentID), input.Quarter, input.Title,
+		input.Description, input.OwnerUserID, input.Status, objectiveID, workspaceID)
+	if err := requireAffected(result, err); err != nil {
+		return Objective{}, err
+	}
+	return s.Objective(ctx, workspaceID, objectiveID)
+}
+
+// ArchiveObjective hides an objective; its children move up to the top of the
+// hierarchy instead of pointing at an archived parent.
+func (s *Store) ArchiveObjective(ctx context.Context, workspaceID int, objectiveID int64) error {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_okr_objectives
+		SET archived_at=NOW(), updated_at=NOW()
+		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+	`, objectiveID, workspaceID)
+	if err := requireAffected(result, err); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE v2_okr_objectives
+		SET parent_id=NULL, updated_at=NOW()
+		WHERE workspace_id=$1 AND parent_id=$2
+	`, workspaceID, objectiveID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddKeyResult attaches a metric target to an objective. Without a target_id
+// the target is created in the objective's own scope.
+func (s *Store) AddKeyResult(ctx context.Context, workspaceID int, userID int, objectiveID int64, input KeyResultInput) (KeyResult, error) {
+	objective, err := s.objectiveByID(ctx, workspaceID, objectiveID)
+	if err != nil {
+		return KeyResult{}, err
+	}
+	targetID := input.TargetID
+	switch {
+	case targetID > 0:
+		if _, err := s.targetByID(ctx, workspaceID, targetID); err != nil {
+			return KeyResult{}, err
+		}
+	case input.Target != nil:
+		targetInput := *input.Target
+		normalizeTargetInput(&targetInput)
+		if targetInput.ScopeType == "" {
+			targetInput.ScopeType = objective.Level
+			if objective.LevelID != nil {
+				targetInput.ScopeID = *objective.LevelID
+			}
+		}
+		target, err := s.CreateTarget(ctx, workspaceID, userID, targetInput)
+		if err != nil {
+			return KeyResult{}, err
+		}
+		targetID = target.ID
+	default:
+		return KeyResult{}, ErrKeyResultTargetRequired
+	}
+
+	var exists bool
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM v2_okr_key_results
+			WHERE objective_id=$1 AND target_id=$2 AND archived_at IS NULL
+		)
+	`, objectiveID, targetID).Scan(&exists); err != nil {
+		return KeyResult{}, err
+	}
+	if exists {
+		return KeyResult{}, ErrKeyResultDuplicate
+	}
+	weight := 1.0
+	if input.Weight != nil {
+		weight = *input.Weight
+	}
+	var keyResultID int64
+	err = s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_okr_key_results (workspace_id, objective_id, target_id, title, weight, sort_order, created_by)
+		VALUES ($1, $2, $3, $4, $5, (
+			SELECT COALESCE(MAX(sort_order), 0) + 1 FROM v2_okr_key_results WHERE objective_id=$2
+		), $6)
+		RETURNING id
+	`, workspaceID, objectiveID, targetID, strings.TrimSpace(input.Title), weight, userID).Scan(&keyResultID)
+	if err != nil {
+		return KeyResult{}, err
+	}
+	return s.KeyResult(ctx, workspaceID, keyResultID)
+}
+
+func (s *Store) KeyResult(ctx context.Context, workspaceID int, keyResultID int64) (KeyResult, error) {
+	keyResult, _, err := s.keyResultByID(ctx, workspaceID, keyResultID)
+	if err != nil {
+		return KeyResult{}, err
+	}
+	if err := s.hydrateKeyResult(ctx, workspaceID, &keyResult); err != nil {
+		return KeyResult{}, err
+	}
+	keyResult.CheckIns, err = s.CheckIns(ctx, workspaceID, keyResultID, 50)
+	if err != nil {
+		return KeyResult{}, err
+	}
+	if len(keyResult.CheckIns) > 0 {
+		keyResult.LastCheckIn = &keyResult.CheckIns[0]
+	}
+	return keyResult, nil
+}
+
+func (s *Store) UpdateKeyResult(ctx context.Context, workspaceID int, keyResultID int64, input KeyResultInput) (KeyResult, error) {
+	current, _, err := s.keyResultByID(ctx, workspaceID, keyResultID)
+	if err != nil {
+		return KeyResult{}, err
+	}
+	title := strings.TrimSpace(input.Title)
+	if title == "" {
+		title = current.Title
+	}
+	weight := current.Weight
+	if input.Weight != nil {
+		weight = *input.Weight
+	}
+	result, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_okr_key_results
+		SET title=$1, weight=$2, updated_at=NOW()
+		WHERE id=$3 AND workspace_id=$4 AND archived_at IS NULL
+	`, title, weight, keyResultID, workspaceID)
+	if err := requireAffected(result, err); err != nil {
+		return KeyResult{}, err
+	}
+	return s.KeyResult(ctx, workspaceID, keyResultID)
+}
+
+func (s *Store) ArchiveKeyResult(ctx context.Context, workspaceID int, keyResultID int64) error {
+	result, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_okr_key_results
+		SET archived_at=NOW(), updated_at=NOW()
+		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+	`, keyResultID, workspaceID)
+	return requireAffected(result, err)
+}
+
+// CheckIn records a quarterly check-in. A reported value is stored as a
+// regular metric observation first, so the key result score and every other
+// view of the metric agree.
+func (s *Store) CheckIn(ctx context.Context, workspaceID int, userID int, keyResultID int64, input CheckInInput) (OKRCheckIn, error) {
+	keyResult, quarter, err := s.keyResultByID(ctx, workspaceID, keyResultID)
+	if err != nil {
+		return OKRCheckIn{}, err
+	}
+	input.Note = strings.TrimSpace(input.Note)
+	confidence := 0.5
+	if input.Confidence != nil {
+		confidence = *input.Confidence
+	} else if previous, err := s.CheckIns(ctx, workspaceID, keyResultID, 1); err != nil {
+		return OKRCheckIn{}, err
+	} else if len(previous) > 0 {
+		confidence = previous[0].Confidence
+	}
+
+	var observationID *int64
+	if input.Value != nil {
+		observation, err := s.AddObservation(ctx, workspaceID, userID, keyResult.TargetID, ObservationInput{
+			Value:      *input.Value,
+			MeasuredAt: input.MeasuredAt,
+			SourceType: "manual",
+			SourceNote: input.Note,
+			Confidence: int(math.Round(confidence * 1000)),
+		})
+		if err != nil {
+			return OKRCheckIn{}, err
+		}
+		observationID = &observation.ID
+	}
+	target, err := s.targetByID(ctx, workspaceID, keyResult.TargetID)
+	if err != nil {
+		return OKRCheckIn{}, err
+	}
+
+	row := s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_okr_checkins (
+			workspace_id, key_result_id, quarter, observation_id, value, score, confidence, note, created_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, key_result_id, quarter, observation_id, value, score, confidence, note, created_by, created_at
+	`, workspaceID, keyResultID, quarter, observationID, target.LatestValue, ScoreTarget(target),
+		confidence, input.Note, userID)
+	return scanCheckIn(row)
+}
+
+func (s *Store) CheckIns(ctx context.Context, workspaceID int, keyResultID int64, limit int) ([]OKRCheckIn, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, key_result_id, quarter, observation_id, value, score, confidence, note, created_by, created_at
+		FROM v2_okr_checkins
+		WHERE workspace_id=$1 AND key_result_id=$2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, workspaceID, keyResultID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []OKRCheckIn{}
+	for rows.Next() {
+		item, err := scanCheckIn(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) Export(ctx context.Context, workspaceID int, quarter string) ([]Objective, error) {
+	return s.Objectives(ctx, workspaceID, quarter, "", 0)
+}
+
+func (s *Store) objectiveByID(ctx context.Context, workspaceID int, objectiveID int64) (Objective, error) {
+	return scanObjective(s.dbx.QueryRowContext(ctx, objectiveSelect+`
+		WHERE objective.id=$1 AND objective.workspace_id=$2 AND objective.archived_at IS NULL
+	`, objectiveID, workspaceID))
+}
+
+func (s *Store) keyResultByID(ctx context.Context, workspaceID int, keyResultID int64) (KeyResult, string, error) {
+	var keyResult KeyResult
+	var quarter string
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT keyresult.id, keyresult.objective_id, keyresult.target_id, keyresult.title,
+			keyresult.weight, keyresult.created_at, keyresult.updated_at, objective.quarter
+		FROM v2_okr_key_results keyresult
+		JOIN v2_okr_objectives objective ON objective.id=keyresult.objective_id AND objective.archived_at IS NULL
+		WHERE keyresult.id=$1 AND keyresult.workspace_id=$2 AND keyresult.archived_at IS NULL
+	`, keyResultID, workspaceID).Scan(
+		&keyResult.ID, &keyResult.ObjectiveID, &keyResult.TargetID, &keyResult.Title,
+		&keyResult.Weight, &keyResult.CreatedAt, &keyResult.UpdatedAt, &quarter,
+	)
+	return keyResult, quarter, err
+}
+
+func (s *Store) hydrateKeyResults(ctx context.Context, workspaceID int, objectives []Objective, withHistory bool) error {
+	if len(objectives) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Objective, len(objectives))
+	ids := make([]int64, 0, len(objectives))
+	for index := range objectives {
+		objectives[index].KeyResults = []KeyResult{}
+		byID[objectives[index].ID] = &objectives[index]
+		ids = append(ids, objectives[index].ID)
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, objective_id, target_id, title, weight, created_at, updated_at
+		FROM v2_okr_key_results
+		WHERE workspace_id=$1 AND objective_id = ANY($2) AND archived_at IS NULL
+		ORDER BY objective_id, sort_order, id
+	`, workspaceID, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	keyResults := []KeyResult{}
+	for rows.Next() {
+		var keyResult KeyResult
+		if err := rows.Scan(
+			&keyResult.ID, &keyResult.ObjectiveID, &keyResult.TargetID, &keyResult.Title,
+			&keyResult.Weight, &keyResult.CreatedAt, &keyResult.UpdatedAt,
+		); err != nil {
+			rows.Close()
+			return err
+		}
+		keyResults = append(keyResults, keyResult)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	lastCheckIns, err := s.lastCheckIns(ctx, workspaceID, keyResults)
+	if err != nil {
+		return err
+	}
+	for _, keyResult := range keyResults {
+		if err := s.hydrateKeyResult(ctx, workspaceID, &keyResult); err != nil {
+			return err
+		}
+		if withHistory {
+			keyResult.CheckIns, err = s.CheckIns(ctx, workspaceID, keyResult.ID, 50)
+			if err != nil {
+				return err
+			}
+		}
+		if checkIn, ok := lastCheckIns[keyResult.ID]; ok {
+			keyResult.LastCheckIn = &checkIn
+		}
+		objective := byID[keyResult.ObjectiveID]
+		objective.KeyResults = append(objective.KeyResults, keyResult)
+	}
+	for index := range objectives {
+		scoreObjective(&objectives[index])
+	}
+	return nil
+}
+
+// hydrateKeyResult loads the backing target and scores it. A key result whose
+// target was archived keeps its row but has nothing to score.
+func (s *Store) hydrateKeyResult(ctx context.Context, workspaceID int, keyResult *KeyResult) error {
+	target, err := s.targetByID(ctx, workspaceID, keyResult.TargetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		keyResult.Target = Target{ID: keyResult.TargetID, Observations: []Observation{}}
+		keyResult.Grade = OKRGrade(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyResult.Target = target
+	keyResult.Score = ScoreTarget(target)
+	keyResult.Grade = OKRGrade(keyResult.Score)
+	return nil
+}
+
+func (s *Store) lastCheckIns(ctx context.Context, workspaceID int, keyResults []KeyResult) (map[int64]OKRCheckIn, error) {
+	result := map[int64]OKRCheckIn{}
+	if len(keyResults) == 0 {
+		return result, nil
+	}
+	ids := make([]int64, 0, len(keyResults))
+	for _, keyResult := range keyResults {
+		ids = append(ids, keyResult.ID)
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT DISTINCT ON (key_result_id)
+			id, key_result_id, quarter, observation_id, value, score, confidence, note, created_by, created_at
+		FROM v2_okr_checkins
+		WHERE workspace_id=$1 AND key_result_id = ANY($2)
+		ORDER BY key_result_id, created_at DESC, id DESC
+	`, workspaceID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanCheckIn(rows)
+		if err != nil {
+			return nil, err
+		}
+		result[item.KeyResultID] = item
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) validateObjective(ctx context.Context, workspaceID int, objectiveID int64, input ObjectiveInput) error {
+	if input.Level != OKRLevelWorkspace {
+		if input.LevelID == nil || *input.LevelID <= 0 {
+			return sql.ErrNoRows
+		}
+		if err := s.validateScope(ctx, workspaceID, input.Level, *input.LevelID); err != nil {
+			return err
+		}
+	}
+	if input.ParentID == nil || *input.ParentID <= 0 {
+		return nil
+	}
+	if *input.ParentID == objectiveID {
+		return ErrObjectiveParentInvalid
+	}
+	// The parent must exist, and for an existing objective it must not sit
+	// below the objective itself.
+	var parentExists, cycle bool
+	err := s.dbx.QueryRowContext(ctx, `
+		WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT id, parent_id
+			FROM v2_okr_objectives
+			WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+			UNION
+			SELECT parent.id, parent.parent_id
+			FROM v2_okr_objectives parent
+			JOIN ancestors ON parent.id=ancestors.parent_id
+		)
+		SELECT EXISTS(SELECT 1 FROM ancestors WHERE id=$1), EXISTS(SELECT 1 FROM ancestors WHERE id=$3)
+	`, *input.ParentID, workspaceID, objectiveID).Scan(&parentExists, &cycle)
+	if err != nil {
+		return err
+	}
+	if !parentExists || cycle {
+		return ErrObjectiveParentInvalid
+	}
+	return nil
+}
+
+func normalizeObjectiveInput(input *ObjectiveInput) {
+	input.Level = strings.TrimSpace(input.Level)
+	input.Quarter = strings.ToUpper(strings.TrimSpace(input.Quarter))
+	input.Title = strings.TrimSpace(input.Title)
+	input.Description = strings.TrimSpace(input.Description)
+	input.Status = strings.TrimSpace(input.Status)
+}
+
+func objectiveLevelID(input ObjectiveInput) any {
+	if input.Level == OKRLevelWorkspace || input.LevelID == nil {
+		return nil
+	}
+	return *input.LevelID
+}
+
+func nullableObjectiveID(value *int64) any {
+	if value == nil || *value <= 0 {
+		return nil
+	}
+	return *value
+}
+
+func requireAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanObjective(row scanner) (Objective, error) {
+	var item Objective
+	var levelID sql.NullInt64
+	var parentID sql.NullInt64
+	var ownerID sql.NullInt64
+	err := row.Scan(
+		&item.ID, &item.WorkspaceID, &item.Level, &levelID, &item.LevelName, &parentID, &item.Quarter,
+		&item.Title, &item.Description, &ownerID, &item.Status, &item.CreatedAt, &item.UpdatedAt,
+	)
+	if err != nil {
+		return Objective{}, err
+	}
+	if levelID.Valid {
+		value := int(levelID.Int64)
+		item.LevelID = &value
+	}
+	if parentID.Valid {
+		value := parentID.Int64
+		item.ParentID = &value
+	}
+	if ownerID.Valid {
+		value := int(ownerID.Int64)
+		item.OwnerUserID = &value
+	}
+	item.KeyResults = []KeyResult{}
+	item.Grade = OKRGradeNoData
+	return item, nil
+}
+
+func scanCheckIn(row scanner) (OKRCheckIn, error) {
+	var item OKRCheckIn
+	var observationID sql.NullInt64
+	var value sql.NullFloat64
+	var score sql.NullFloat64
+	var createdBy sql.NullInt64
+	err := row.Scan(
+		&item.ID, &item.KeyResultID, &item.Quarter, &observationID, &value, &score,
+		&item.Confidence, &item.Note, &createdBy, &item.CreatedAt,
+	)
+	if err != nil {
+		return OKRCheckIn{}, err
+	}
+	if observationID.Valid {
+		id := observationID.Int64
+		item.ObservationID = &id
+	}
+	if value.Valid {
+		number := value.Float64
+		item.Value = &number
+	}
+	if score.Valid {
+		number := score.Float64
+		item.Score = &number
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		item.CreatedBy = &id
+	}
+	return item, nil
+}
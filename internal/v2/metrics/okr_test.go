@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestScoreProgressFollowsDirection(t *testing.T) {
+	number := func(value float64) *float64 { return &value }
+	cases := []struct {
+		name      string
+		direction string
+		baseline  *float64
+		target    *float64
+		latest    *float64
+		want      *float64
+	}{
+		{"increase halfway", "increase", number(100), number(200), number(150), number(0.5)},
+		{"increase overshoot", "increase", number(100), number(200), number(260), number(1)},
+		{"increase regressed", "increase", number(100), number(200), number(80), number(0)},
+		{"decrease", "decrease", number(10), number(4), number(7), number(0.5)},
+		{"no baseline counts from zero", "increase", nil, number(40), number(30), number(0.75)},
+		{"range inside band", "range", number(40), number(60), number(45), number(1)},
+		{"range below band", "range", number(40), number(60), number(30), number(0.5)},
+		{"no observation", "increase", number(1), number(2), nil, nil},
+		{"no target", "increase", number(1), nil, number(2), nil},
+	}
+	for _, tc := range cases {
+		got := ScoreProgress(tc.direction, tc.baseline, tc.target, tc.latest)
+		if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+			t.Fatalf("%s: got %v want %v", tc.name, formatOptionalFloat(got), formatOptionalFloat(tc.want))
+		}
+	}
+}
+
+func TestObjectiveScoreWeightsAndSkipsMissingData(t *testing.T) {
+	high, low := 0.9, 0.3
+	score := ObjectiveScore([]KeyResult{
+		{Weight: 3, Score: &high},
+		{Weight: 1, Score: &low},
+		{Weight: 5},
+	})
+	if score == nil || *score != 0.75 {
+		t.Fatalf("score = %v", formatOptionalFloat(score))
+	}
+	if OKRGrade(score) != OKRGradeOnTrack || OKRGrade(&low) != OKRGradeOffTrack || OKRGrade(nil) != OKRGradeNoData {
+		t.Fatalf("unexpected grades")
+	}
+	if ObjectiveScore([]KeyResult{{Weight: 1}}) != nil {
+		t.Fatal("objective without data should not be scored")
+	}
+}
+
+func TestQuarters(t *testing.T) {
+	if got := QuarterOf(time.Date(2026, 11, 3, 0, 0, 0, 0, time.UTC)); got != "2026-Q4" {
+		t.Fatalf("quarter = %q", got)
+	}
+	start, end, ok := ParseQuarter("2026-Q1")
+	if !ok || start.Format("2006-01-02") != "2026-01-01" || end.Format("2006-01-02") != "2026-03-31" {
+		t.Fatalf("parsed %v %v %v", start, end, ok)
+	}
+	for _, value := range []string{"2026-Q5", "2026Q1", "26-Q1", "2026-q1"} {
+		if _, _, ok := ParseQuarter(value); ok {
+			t.Fatalf("accepted %q", value)
+		}
+	}
+}
+
+func TestOKRExportCSV(t *testing.T) {
+	baseline, target, latest := 10.0, 20.0, 18.0
+	objectives := []Objective{
+		{
+			ID: 1, Level: OKRLevelWorkspace, Title: "Выйти на новый рынок", Status: OKRStatusActive,
+			KeyResults: []KeyResult{{
+				ID: 11, Weight: 1,
+				Target: Target{
+					Metric:        Definition{Name: "Клиенты", BetterDirection: "increase"},
+					BaselineValue: &baseline, TargetValue: &target, LatestValue: &latest,
+				},
+			}},
+		},
+		{ID: 2, Level: OKRLevelWorkspace, Title: "Без ключевых результатов", Status: OKRStatusDraft},
+	}
+	for index := range objectives {
+		scoreObjective(&objectives[index])
+	}
+	export := BuildOKRExport("2026-Q4", objectives, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC))
+	if export.Format != OKRExportFormat || export.Score == nil || *export.Score != 0.8 {
+		t.Fatalf("export = %+v", export)
+	}
+	if export.Objectives[0].KeyResults[0].Title != "Клиенты" || export.Objectives[0].KeyResults[0].Grade != OKRGradeOnTrack {
+		t.Fatalf("key result = %+v", export.Objectives[0].KeyResults[0])
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteOKRCSV(&buffer, export); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || len(rows[1]) != len(okrCSVHeader) || len(rows[2]) != len(okrCSVHeader) {
+		t.Fatalf("rows = %v", rows)
+	}
+	if rows[1][10] != "Клиенты" || rows[1][19] != "0.8" || rows[2][9] != "" {
+		t.Fatalf("rows = %v", rows)
+	}
+}
@@ -388,6 +388,9 @@ func (s *Store) validateScope(ctx context.Context, workspaceID int, scopeType st
 	case ScopeProject:
 		query = `SELECT EXISTS(SELECT 1 FROM v2_tactical_projects WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL)`
 		args = []any{scopeID, workspaceID}
+	case ScopeDepartment:
+		query = `SELECT EXISTS(SELECT 1 FROM v2_departments WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL)`
+		args = []any{scopeID, workspaceID}
 	default:
 		return sql.ErrNoRows
 	}
@@ -7,6 +7,7 @@ const (
 	ScopeStrategy   = "strategy"
 	ScopeWorkstream = "workstream"
 	ScopeProject    = "project"
+	ScopeDepartment = "department"
 
 	RolePrimary    = "primary"
 	RoleGuardrail  = "guardrail"
@@ -114,3 +115,136 @@ type ObservationInput struct {
 	EvidenceURL string  `json:"evidence_url"`
 	Confidence  int     `json:"confidence"`
 }
+
+const (
+	OKRLevelWorkspace  = "workspace"
+	OKRLevelDepartment = "department"
+	OKRLevelWorkstream = "workstream"
+
+	OKRStatusDraft  = "draft"
+	OKRStatusActive = "active"
+	OKRStatusClosed = "closed"
+
+	// OKR grades follow the usual 0.7 "stretch met" convention.
+	OKRGradeOnTrack  = "on_track"
+	OKRGradeAtRisk   = "at_risk"
+	OKRGradeOffTrack = "off_track"
+	OKRGradeNoData   = "no_data"
+)
+
+type Objective struct {
+	ID          int64       `json:"id"`
+	WorkspaceID int         `json:"workspace_id"`
+	Level       string      `json:"level"`
+	LevelID     *int        `json:"level_id,omitempty"`
+	LevelName   string      `json:"level_name"`
+	ParentID    *int64      `json:"parent_id,omitempty"`
+	Quarter     string      `json:"quarter"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	OwnerUserID *int        `json:"owner_user_id,omitempty"`
+	Status      string      `json:"status"`
+	Score       *float64    `json:"score"`
+	Grade       string      `json:"grade"`
+	KeyResults  []KeyResult `json:"key_results"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+type KeyResult struct {
+	ID          int64        `json:"id"`
+	ObjectiveID int64        `json:"objective_id"`
+	TargetID    int64        `json:"target_id"`
+	Title       string       `json:"title"`
+	Weight      float64      `json:"weight"`
+	Target      Target       `json:"target"`
+	Score       *float64     `json:"score"`
+	Grade       string       `json:"grade"`
+	LastCheckIn *OKRCheckIn  `json:"last_check_in,omitempty"`
+	CheckIns    []OKRCheckIn `json:"check_ins,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+type OKRCheckIn struct {
+	ID            int64     `json:"id"`
+	KeyResultID   int64     `json:"key_result_id"`
+	Quarter       string    `json:"quarter"`
+	ObservationID *int64    `json:"observation_id,omitempty"`
+	Value         *float64  `json:"value"`
+	Score         *float64  `json:"score"`
+	Confidence    float64   `json:"confidence"`
+	Note          string    `json:"note"`
+	CreatedBy     *int      `json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ObjectiveInput struct {
+	Level       string `json:"level"`
+	LevelID     *int   `json:"level_id"`
+	ParentID    *int64 `json:"parent_id"`
+	Quarter     string `json:"quarter"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	OwnerUserID *int   `json:"owner_user_id"`
+	Status      string `json:"status"`
+}
+
+// KeyResultInput either references an existing metric target or carries a
+// TargetInput so the target is created alongside the key result.
+type KeyResultInput struct {
+	TargetID int64        `json:"target_id"`
+	Target   *TargetInput `json:"target"`
+	Title    string       `json:"title"`
+	Weight   *float64     `json:"weight"`
+}
+
+type CheckInInput struct {
+	Value      *float64 `json:"value"`
+	MeasuredAt string   `json:"measured_at"`
+	Confidence *float64 `json:"confidence"`
+	Note       string   `json:"note"`
+}
+
+// OKRExportFormat identifies the flat objective/key-result layout shared by
+// the JSON and CSV exports so investor tooling can import either one.
+const OKRExportFormat = "okr-export/v1"
+
+type OKRExport struct {
+	Format      string               `json:"format"`
+	Quarter     string               `json:"quarter"`
+	GeneratedAt time.Time            `json:"generated_at"`
+	Score       *float64             `json:"score"`
+	Objectives  []OKRExportObjective `json:"objectives"`
+}
+
+type OKRExportObjective struct {
+	ID          int64                `json:"id"`
+	ParentID    *int64               `json:"parent_id,omitempty"`
+	Level       string               `json:"level"`
+	LevelName   string               `json:"level_name"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	OwnerUserID *int                 `json:"owner_user_id,omitempty"`
+	Status      string               `json:"status"`
+	Score       *float64             `json:"score"`
+	Grade       string               `json:"grade"`
+	KeyResults  []OKRExportKeyResult `json:"key_results"`
+}
+
+type OKRExportKeyResult struct {
+	ID            int64    `json:"id"`
+	Title         string   `json:"title"`
+	Metric        string   `json:"metric"`
+	Unit          string   `json:"unit"`
+	Direction     string   `json:"direction"`
+	StartValue    *float64 `json:"start_value"`
+	TargetValue   *float64 `json:"target_value"`
+	CurrentValue  *float64 `json:"current_value"`
+	TargetDate    string   `json:"target_date"`
+	Weight        float64  `json:"weight"`
+	Score         *float64 `json:"score"`
+	Grade         string   `json:"grade"`
+	Confidence    *float64 `json:"confidence"`
+	LastCheckInAt string   `json:"last_check_in_at"`
+}
@@ -29,6 +29,7 @@ type response struct {
 	Workspace              workspace           `json:"workspace"`
 	ContextReady           bool                `json:"context_ready"`
 	SubscriptionAccess     bool                `json:"subscription_access"`
+	SubscriptionAccessTier string              `json:"subscription_access_tier"`
 	AIChatAccess           bool                `json:"ai_chat_access"`
 	OnboardingProgress     onboardingProgress  `json:"onboarding_progress"`
 	Strategy               *strategy           `json:"strategy,omitempty"`
@@ -163,6 +164,7 @@ func (h *Handler) Navigation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	result.SubscriptionAccess = subscriptionAccess.Product
+	result.SubscriptionAccessTier = subscriptionAccess.Tier
 	result.AIChatAccess = subscriptionAccess.AIChat
 	if currentWorkspace.DisplayName != nil && strings.TrimSpace(*currentWorkspace.DisplayName) != "" {
 		result.Workspace.DisplayName = strings.TrimSpace(*currentWorkspace.DisplayName)
@@ -244,7 +246,7 @@ func (h *Handler) Navigation(w http.ResponseWriter, r *http.Request) {
 	result.OnboardingProgress = deriveOnboardingProgress(
 		result.ContextReady, result.Strategy, result.Departments, directedTaskExists,
 	)
-	if result.ContextReady && !result.SubscriptionAccess {
+	if result.ContextReady && !result.SubscriptionAccess && !subscriptionAccess.ReadOnly {
 		result.Strategy = nil
 		result.MainTask = nil
 		result.Workstreams = []workstream{}
@@ -60,14 +60,14 @@ func (w *responseRecorder) Flush() {
 
 type Collector struct {
 	dbx    *sql.DB
-	secret []byte
+	keys   *auth.KeySet
 	queue  chan requestRecord
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
-func NewCollector(dbx *sql.DB, secret []byte) *Collector {
-	return &Collector{dbx: dbx, secret: secret, queue: make(chan requestRecord, 1024)}
+func NewCollector(dbx *sql.DB, keys *auth.KeySet) *Collector {
+	return &Collector{dbx: dbx, keys: keys, queue: make(chan requestRecord, 1024)}
 }
 
 func (c *Collector) Start(parent context.Context) {
@@ -94,6 +94,7 @@ func (c *Collector) Middleware(next http.Handler) http.Handler {
 		requestID := strings.TrimSpace(r.Header.Get("X-Request-ID"))
 		if requestID == "" {
 			requestID = newRequestID()
+			r.Header.Set("X-Request-ID", requestID)
 		}
 		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
 		recorder := &responseRecorder{ResponseWriter: w}
@@ -111,7 +112,7 @@ func (c *Collector) Middleware(next http.Handler) http.Handler {
 			RequestID: requestID, Method: r.Method, Path: normalizedPath(r.URL.Path),
 			StatusCode: recorder.status, LatencyMS: latency, ResponseBytes: recorder.bytes,
 		}
-		if userID, ok := requestUserID(c.secret, r); ok && recorder.status != http.StatusUnauthorized {
+		if userID, ok := requestUserID(c.keys, r); ok && recorder.status != http.StatusUnauthorized {
 			record.UserID = &userID
 		}
 		select {
@@ -186,12 +187,12 @@ func newRequestID() string {
 	return hex.EncodeToString(raw)
 }
 
-func requestUserID(secret []byte, r *http.Request) (int, bool) {
+func requestUserID(keys *auth.KeySet, r *http.Request) (int, bool) {
 	token, ok := auth.TokenFromRequest(r)
 	if !ok {
 		return 0, false
 	}
-	userID, err := auth.ParseToken(secret, token)
+	userID, err := auth.ParseToken(keys, token)
 	return userID, err == nil
 }
 
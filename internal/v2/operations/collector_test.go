@@ -3,8 +3,8 @@ package operations
 import (
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
+	"time"
 
 	"reup-goals-backend/internal/auth"
 )
@@ -24,8 +24,12 @@ func TestEventName(t *testing.T) {
 }
 
 func TestRequestUserIDSupportsCookieAndBearerSessions(t *testing.T) {
-	secret := []byte(strings.Repeat("x", 32))
-	token, err := auth.GenerateToken(secret, 42)
+	key, err := auth.GenerateSigningKey(auth.AlgorithmEdDSA, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewStaticKeySet(nil, key)
+	token, err := auth.GenerateToken(keys, 42)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,7 +46,7 @@ func TestRequestUserIDSupportsCookieAndBearerSessions(t *testing.T) {
 		request := httptest.NewRequest("GET", "/api/v2/tasks", nil)
 		recorder := httptest.NewRecorder()
 		setup(recorder, request)
-		if userID, ok := requestUserID(secret, request); !ok || userID != 42 {
+		if userID, ok := requestUserID(keys, request); !ok || userID != 42 {
 			t.Fatalf("expected user 42, got %d ok=%v", userID, ok)
 		}
 	}
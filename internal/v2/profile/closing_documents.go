@@ -0,0 +1,577 @@
+package profile
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"reup-goals-backend/internal/auth"
+	"reup-goals-backend/internal/v2/billing"
+)
+
+const (
+	closingDocumentsAdvisoryLock int64 = 528105239
+	closingDocumentsBatch              = 100
+	maxActEmailAttempts                = 5
+)
+
+// ActsDue returns subscription and plan change payments whose paid period
+// has ended and that have no act yet. Only organizations get acts: card
+// payments from a workspace without billing details have nobody to issue
+// the act to.
+func (s *Store) ActsDue(ctx context.Context, limit int) ([]int64, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT payment.id
+		FROM workspace_billing_payments payment
+		WHERE payment.status='paid' AND payment.order_kind IN ($1,$2)
+			AND payment.period_end IS NOT NULL AND payment.period_end <= NOW()
+			AND (payment.invoice_id IS NOT NULL OR EXISTS (
+				SELECT 1 FROM workspace_billing_organizations organization
+				WHERE organization.workspace_id=payment.workspace_id
+			))
+			AND NOT EXISTS (
+				SELECT 1 FROM workspace_billing_documents document
+				WHERE document.payment_id=payment.id AND document.kind='act'
+			)
+		ORDER BY payment.period_end, payment.id
+		LIMIT $3
+	`, billing.OrderSubscription, billing.OrderPlanChange, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// CreateAct issues the act for a paid subscription period. Parties come from
+// the invoice snapshots when the payment was made by invoice, so the act
+// names the same organizations as the invoice it closes.
+func (s *Store) CreateAct(ctx context.Context, paymentID int64) (BillingDocument, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+	defer tx.Rollback()
+
+	var workspaceID int
+	var invoiceID sql.NullInt64
+	var act Act
+	var planCode, timezone, recipientEmail string
+	var periodStart, periodEnd time.Time
+	var buyerSnapshot, sellerSnapshot []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT payment.workspace_id, payment.invoice_id, payment.amount, payment.currency,
+			payment.plan_code, payment.period_start, payment.period_end,
+			COALESCE(invoice.number, ''), COALESCE(invoice.recipient_email, ''),
+			invoice.organization_snapshot, invoice.seller_snapshot,
+			COALESCE(NULLIF(workspace.timezone, ''), 'Europe/Moscow')
+		FROM workspace_billing_payments payment
+		JOIN workspaces workspace ON workspace.id=payment.workspace_id
+		LEFT JOIN workspace_billing_invoices invoice ON invoice.id=payment.invoice_id
+		WHERE payment.id=$1 AND payment.period_start IS NOT NULL AND payment.period_end IS NOT NULL
+		FOR UPDATE OF payment
+	`, paymentID).Scan(
+		&workspaceID, &invoiceID, &act.Amount, &act.Currency, &planCode, &periodStart, &periodEnd,
+		&act.InvoiceNumber, &recipientEmail, &buyerSnapshot, &sellerSnapshot, &timezone,
+	)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+
+	var buyer BillingOrganization
+	if len(buyerSnapshot) > 0 {
+		if err := json.Unmarshal(buyerSnapshot, &buyer); err != nil {
+			return BillingDocument{}, err
+		}
+	} else {
+		organization, err := s.BillingOrganization(ctx, workspaceID)
+		if err != nil {
+			return BillingDocument{}, err
+		}
+		if organization == nil {
+			return BillingDocument{}, errors.New("billing_organization_required")
+		}
+		buyer = *organization
+	}
+	if strings.TrimSpace(recipientEmail) == "" {
+		recipientEmail = buyer.AccountingEmail
+	}
+	var seller SellerProfile
+	if len(sellerSnapshot) > 0 {
+		if err := json.Unmarshal(sellerSnapshot, &seller); err != nil {
+			return BillingDocument{}, err
+		}
+	} else if seller, err = s.SellerProfile(ctx); err != nil {
+		return BillingDocument{}, err
+	}
+	act.TaxLabel = seller.TaxLabel
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	act.PeriodStart, act.PeriodEnd = actPeriod(periodStart, periodEnd, location)
+	act.Date = act.PeriodEnd
+	act.Description = "Предоставление доступа к сервису REUP.goals"
+	if plan, err := billing.PlanByCode(planCode); err == nil {
+		act.Description += ", тариф " + plan.Name
+	}
+
+	var sequence int
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO billing_document_sequences (seller_inn, kind, year, last_number)
+		VALUES ($1,'act',$2,1)
+		ON CONFLICT (seller_inn, kind, year) DO UPDATE
+			SET last_number=billing_document_sequences.last_number + 1
+		RETURNING last_number
+	`, seller.INN, act.Date.Year()).Scan(&sequence); err != nil {
+		return BillingDocument{}, err
+	}
+	act.Number = actNumber(act.Date.Year(), sequence)
+
+	pdf, err := BuildActPDF(act, seller, buyer)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+	document := BillingDocument{
+		Kind: "act", Number: act.Number, Title: "Акт " + act.Number,
+		FileName: "act-" + act.Number + ".pdf", MimeType: "application/pdf",
+		PeriodStart: &act.PeriodStart, PeriodEnd: &act.PeriodEnd,
+	}
+	if invoiceID.Valid {
+		value := invoiceID.Int64
+		document.InvoiceID = &value
+	}
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO workspace_billing_documents (
+			workspace_id, invoice_id, payment_id, kind, number, title, file_name,
+			mime_type, content, period_start, period_end, recipient_email
+		) VALUES ($1,$2,$3,'act',$4,$5,$6,'application/pdf',$7,$8,$9,$10)
+		RETURNING id, created_at
+	`, workspaceID, invoiceID, paymentID, act.Number, document.Title, document.FileName, pdf,
+		act.PeriodStart, act.PeriodEnd, strings.TrimSpace(recipientEmail)).Scan(&document.ID, &document.CreatedAt); err != nil {
+		return BillingDocument{}, err
+	}
+	return document, tx.Commit()
+}
+
+// actPeriod turns the paid interval into the calendar dates printed on the
+// act: the end is exclusive, so the last day of service is the day before.
+func actPeriod(start, end time.Time, location *time.Location) (time.Time, time.Time) {
+	start, end = start.In(location), end.In(location)
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	last := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	if last.Before(first) {
+		last = first
+	}
+	return first, last
+}
+
+func actNumber(year, sequence int) string {
+	return fmt.Sprintf("REUP-A-%d-%06d", year, sequence)
+}
+
+// CreditNotesDue returns refund payments that have no credit note yet:
+// the refund could not issue it, or the seller profile was missing then.
+func (s *Store) CreditNotesDue(ctx context.Context, limit int) ([]int64, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT payment.id
+		FROM workspace_billing_payments payment
+		WHERE payment.refunded_payment_id IS NOT NULL AND payment.status='refunded'
+			AND NOT EXISTS (
+				SELECT 1 FROM workspace_billing_documents document
+				WHERE document.payment_id=payment.id AND document.kind='credit_note'
+			)
+		ORDER BY payment.created_at, payment.id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+// CreateCreditNote issues the credit note for a refund payment, naming the
+// parties of the payment it refunds. A payer without billing details is
+// named as an individual. A refund that already has its credit note gets
+// that one back.
+func (s *Store) CreateCreditNote(ctx context.Context, refundPaymentID int64) (BillingDocument, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+	defer tx.Rollback()
+
+	var workspaceID int
+	var invoiceID sql.NullInt64
+	var note CreditNote
+	var orderKind, planCode, timezone, recipientEmail, ownerEmail string
+	var periodStart, periodEnd sql.NullTime
+	var buyerSnapshot, sellerSnapshot []byte
+	err = tx.QueryRowContext(ctx, `
+		SELECT refund.workspace_id, refund.invoice_id, -refund.amount, refund.currency,
+			refund.order_kind, refund.plan_code, refund.period_start, refund.period_end,
+			refund.refund_reason, COALESCE(original.paid_at, original.created_at),
+			COALESCE(invoice.number, ''), COALESCE(invoice.recipient_email, ''),
+			invoice.organization_snapshot, invoice.seller_snapshot,
+			COALESCE(NULLIF(workspace.timezone, ''), 'Europe/Moscow'), COALESCE(owner.email, '')
+		FROM workspace_billing_payments refund
+		JOIN workspace_billing_payments original ON original.id=refund.refunded_payment_id
+		JOIN workspaces workspace ON workspace.id=refund.workspace_id
+		LEFT JOIN users owner ON owner.id=workspace.owner_user_id
+		LEFT JOIN workspace_billing_invoices invoice ON invoice.id=refund.invoice_id
+		WHERE refund.id=$1 AND refund.status='refunded'
+		FOR UPDATE OF refund
+	`, refundPaymentID).Scan(
+		&workspaceID, &invoiceID, &note.Amount, &note.Currency, &orderKind, &planCode,
+		&periodStart, &periodEnd, &note.Reason, &note.PaidAt, &note.InvoiceNumber,
+		&recipientEmail, &buyerSnapshot, &sellerSnapshot, &timezone, &ownerEmail,
+	)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+	document := BillingDocument{Kind: "credit_note", MimeType: "application/pdf"}
+	var documentStart, documentEnd sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, number, title, file_name, period_start, period_end, created_at
+		FROM workspace_billing_documents
+		WHERE payment_id=$1 AND kind='credit_note'
+	`, refundPaymentID).Scan(
+		&document.ID, &document.Number, &document.Title, &document.FileName,
+		&documentStart, &documentEnd, &document.CreatedAt,
+	)
+	if err == nil {
+		document.PeriodStart, document.PeriodEnd = nullableTime(documentStart), nullableTime(documentEnd)
+		return document, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return BillingDocument{}, err
+	}
+
+	var buyer BillingOrganization
+	if len(buyerSnapshot) > 0 {
+		if err := json.Unmarshal(buyerSnapshot, &buyer); err != nil {
+			return BillingDocument{}, err
+		}
+	} else {
+		organization, err := s.BillingOrganization(ctx, workspaceID)
+		if err != nil {
+			return BillingDocument{}, err
+		}
+		if organization != nil {
+			buyer = *organization
+		} else {
+			buyer = BillingOrganization{FullName: "Физическое лицо", AccountingEmail: ownerEmail}
+		}
+	}
+	if strings.TrimSpace(recipientEmail) == "" {
+		recipientEmail = buyer.AccountingEmail
+	}
+	var seller SellerProfile
+	if len(sellerSnapshot) > 0 {
+		if err := json.Unmarshal(sellerSnapshot, &seller); err != nil {
+			return BillingDocument{}, err
+		}
+	} else if seller, err = s.SellerProfile(ctx); err != nil {
+		return BillingDocument{}, err
+	}
+	note.TaxLabel = seller.TaxLabel
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	now := time.Now().In(location)
+	note.Date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	note.PaidAt = note.PaidAt.In(location)
+	if periodStart.Valid && periodEnd.Valid && periodEnd.Time.After(periodStart.Time) {
+		first, last := actPeriod(periodStart.Time, periodEnd.Time, location)
+		note.PeriodStart, note.PeriodEnd = &first, &last
+	}
+	note.Description = "Предоставление доступа к сервису REUP.goals"
+	if orderKind == billing.OrderQuotaReset {
+		note.Description = "Сброс недельного AI-лимита REUP.goals"
+	}
+	if plan, err := billing.PlanByCode(planCode); err == nil {
+		note.Description += ", тариф " + plan.Name
+	}
+
+	var sequence int
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO billing_document_sequences (seller_inn, kind, year, last_number)
+		VALUES ($1,'credit_note',$2,1)
+		ON CONFLICT (seller_inn, kind, year) DO UPDATE
+			SET last_number=billing_document_sequences.last_number + 1
+		RETURNING last_number
+	`, seller.INN, note.Date.Year()).Scan(&sequence); err != nil {
+		return BillingDocument{}, err
+	}
+	note.Number = creditNoteNumber(note.Date.Year(), sequence)
+
+	pdf, err := BuildCreditNotePDF(note, seller, buyer)
+	if err != nil {
+		return BillingDocument{}, err
+	}
+	document.Number, document.Title = note.Number, "Кредит-нота "+note.Number
+	document.FileName = "credit-note-" + note.Number + ".pdf"
+	document.PeriodStart, document.PeriodEnd = note.PeriodStart, note.PeriodEnd
+	if invoiceID.Valid {
+		value := invoiceID.Int64
+		document.InvoiceID = &value
+	}
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO workspace_billing_documents (
+			workspace_id, invoice_id, payment_id, kind, number, title, file_name,
+			mime_type, content, period_start, period_end, recipient_email
+		) VALUES ($1,$2,$3,'credit_note',$4,$5,$6,'application/pdf',$7,$8,$9,$10)
+		RETURNING id, created_at
+	`, workspaceID, invoiceID, refundPaymentID, note.Number, document.Title, document.FileName, pdf,
+		note.PeriodStart, note.PeriodEnd, strings.TrimSpace(recipientEmail)).Scan(&document.ID, &document.CreatedAt); err != nil {
+		return BillingDocument{}, err
+	}
+	return document, tx.Commit()
+}
+
+// IssueCreditNote lets the billing admin refund issue the credit note as
+// soon as the refund is recorded.
+func (s *Store) IssueCreditNote(ctx context.Context, refundPaymentID int64) (int64, error) {
+	document, err := s.CreateCreditNote(ctx, refundPaymentID)
+	return document.ID, err
+}
+
+func creditNoteNumber(year, sequence int) string {
+	return fmt.Sprintf("REUP-K-%d-%06d", year, sequence)
+}
+
+type unsentAct struct {
+	ID          int64
+	Number      string
+	Recipient   string
+	FileName    string
+	Content     []byte
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+func (s *Store) UnsentActs(ctx context.Context, limit int) ([]unsentAct, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, number, recipient_email, file_name, content, period_start, period_end
+		FROM workspace_billing_documents
+		WHERE kind='act' AND emailed_at IS NULL AND recipient_email<>'' AND email_attempts<$1
+		ORDER BY created_at, id
+		LIMIT $2
+	`, maxActEmailAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []unsentAct{}
+	for rows.Next() {
+		var item unsentAct
+		if err := rows.Scan(&item.ID, &item.Number, &item.Recipient, &item.FileName, &item.Content,
+			&item.PeriodStart, &item.PeriodEnd); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) MarkActEmailed(ctx context.Context, documentID int64, sent bool) error {
+	query := `UPDATE workspace_billing_documents SET email_attempts=email_attempts + 1 WHERE id=$1`
+	if sent {
+		query = `UPDATE workspace_billing_documents SET emailed_at=NOW(), email_attempts=email_attempts + 1 WHERE id=$1`
+	}
+	_, err := s.dbx.ExecContext(ctx, query, documentID)
+	return err
+}
+
+// QuarterDocuments returns the invoices and acts dated within a calendar
+// quarter. Acts are dated by the last day of service, invoices by the day
+// they were issued in the workspace time zone.
+func (s *Store) QuarterDocuments(ctx context.Context, workspaceID, year, quarter int) ([]archivedDocument, error) {
+	from := time.Date(year, time.Month(quarter*3-2), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 3, 0)
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT document.kind, document.file_name, document.content
+		FROM workspace_billing_documents document
+		JOIN workspaces workspace ON workspace.id=document.workspace_id
+		WHERE document.workspace_id=$1
+			AND COALESCE(
+				CASE WHEN document.kind='act' THEN document.period_end END,
+				(document.created_at AT TIME ZONE COALESCE(NULLIF(workspace.timezone, ''), 'Europe/Moscow'))::date
+			) >= $2::date
+			AND COALESCE(
+				CASE WHEN document.kind='act' THEN document.period_end END,
+				(document.created_at AT TIME ZONE COALESCE(NULLIF(workspace.timezone, ''), 'Europe/Moscow'))::date
+			) < $3::date
+		ORDER BY document.created_at, document.id
+	`, workspaceID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []archivedDocument{}
+	for rows.Next() {
+		var item archivedDocument
+		if err := rows.Scan(&item.Kind, &item.FileName, &item.Content); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+type archivedDocument struct {
+	Kind     string
+	FileName string
+	Content  []byte
+}
+
+// buildDocumentsArchive packs documents into folders by kind, the way
+// accountants file them. Repeated file names get a numeric suffix.
+func buildDocumentsArchive(documents []archivedDocument) ([]byte, error) {
+	folders := map[string]string{"invoice": "Счета", "act": "Акты", "credit_note": "Кредит-ноты"}
+	var output bytes.Buffer
+	archive := zip.NewWriter(&output)
+	used := map[string]int{}
+	for _, document := range documents {
+		folder := folders[document.Kind]
+		if folder == "" {
+			folder = "Прочее"
+		}
+		name := path.Join(folder, path.Base(strings.ReplaceAll(document.FileName, "\\", "/")))
+		if count := used[name]; count > 0 {
+			extension := path.Ext(name)
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), count+1, extension)
+		}
+		used[name]++
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(document.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// ClosingDocumentRunner issues acts for paid periods that have ended and
+// emails them to the organization's accounting address. It also issues the
+// credit notes refunds left behind.
+type ClosingDocumentRunner struct {
+	store    *Store
+	email    *auth.EmailService
+	interval time.Duration
+}
+
+func NewClosingDocumentRunner(dbx *sql.DB, email *auth.EmailService, interval time.Duration) *ClosingDocumentRunner {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return &ClosingDocumentRunner{store: NewStore(dbx), email: email, interval: interval}
+}
+
+func (r *ClosingDocumentRunner) Start(ctx context.Context) {
+	go func() {
+		r.run(ctx)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.run(ctx)
+			}
+		}
+	}()
+}
+
+func (r *ClosingDocumentRunner) run(ctx context.Context) {
+	conn, err := r.store.dbx.Conn(ctx)
+	if err != nil {
+		log.Printf("[WARN] closing documents connection failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, closingDocumentsAdvisoryLock).Scan(&locked); err != nil || !locked {
+		return
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, closingDocumentsAdvisoryLock)
+
+	paymentIDs, err := r.store.ActsDue(ctx, closingDocumentsBatch)
+	if err != nil {
+		log.Printf("[WARN] closing documents lookup failed: %v", err)
+		return
+	}
+	for _, paymentID := range paymentIDs {
+		if _, err := r.store.CreateAct(ctx, paymentID); err != nil {
+			log.Printf("[WARN] act for payment %d failed: %v", paymentID, err)
+		}
+	}
+	refundIDs, err := r.store.CreditNotesDue(ctx, closingDocumentsBatch)
+	if err != nil {
+		log.Printf("[WARN] credit notes lookup failed: %v", err)
+		return
+	}
+	for _, refundID := range refundIDs {
+		if _, err := r.store.CreateCreditNote(ctx, refundID); err != nil {
+			log.Printf("[WARN] credit note for refund %d failed: %v", refundID, err)
+		}
+	}
+	if r.email == nil {
+		return
+	}
+	acts, err := r.store.UnsentActs(ctx, closingDocumentsBatch)
+	if err != nil {
+		log.Printf("[WARN] unsent acts lookup failed: %v", err)
+		return
+	}
+	for _, act := range acts {
+		body := fmt.Sprintf(
+			"<p>Акт оказанных услуг <strong>%s</strong> за период с %s по %s сформирован в REUP.goals.</p><p>PDF-файл приложен к письму и остаётся доступен в разделе «Подписка и биллинг».</p>",
+			html.EscapeString(act.Number), act.PeriodStart.Format("02.01.2006"), act.PeriodEnd.Format("02.01.2006"),
+		)
+		sendErr := r.email.SendServiceEmailAttachment(
+			act.Recipient, "Акт "+act.Number+" от REUP.goals", body, act.FileName, act.Content,
+		)
+		if sendErr != nil {
+			log.Printf("[WARN] act %s email failed: %v", act.Number, sendErr)
+		}
+		if err := r.store.MarkActEmailed(ctx, act.ID, sendErr == nil); err != nil {
+			log.Printf("[WARN] act %s email status update failed: %v", act.Number, err)
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"reup-goals-backend/internal/audit"
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/config"
 	"reup-goals-backend/internal/subscriptions"
@@ -28,8 +29,11 @@ type Handler struct {
 	cfg          *config.Config
 	emailService *auth.EmailService
 	payments     *subscriptions.CloudPaymentsClient
+	cardProvider subscriptions.Provider
 	quotaService *billing.Service
 	dataCleaner  WorkspaceDataCleaner
+	sso          *auth.SSOService
+	audit        *audit.Store
 }
 
 type WorkspaceDataCleaner interface {
@@ -45,6 +49,10 @@ func NewHandler(
 ) *Handler {
 	result := &Handler{
 		store: NewStore(dbx), dbx: dbx, cfg: cfg, emailService: emailService, payments: payments,
+		audit: audit.NewStore(dbx),
+	}
+	if payments != nil {
+		result.cardProvider = payments
 	}
 	if len(billingServices) > 0 {
 		result.quotaService = billingServices[0]
@@ -57,6 +65,20 @@ func (h *Handler) WithWorkspaceDataCleaner(cleaner WorkspaceDataCleaner) *Handle
 	return h
 }
 
+func (h *Handler) WithSSO(sso *auth.SSOService) *Handler {
+	h.sso = sso
+	return h
+}
+
+// WithCardProvider sets the provider card checkouts go to; CloudPayments
+// by default.
+func (h *Handler) WithCardProvider(provider subscriptions.Provider) *Handler {
+	if provider != nil {
+		h.cardProvider = provider
+	}
+	return h
+}
+
 func (h *Handler) InvitationPreview(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -99,6 +121,18 @@ func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 		h.aiUsage(w, r, userID)
 		return
 	}
+	if segments[0] == "sessions" {
+		h.sessions(w, r, userID, segments[1:])
+		return
+	}
+	if segments[0] == "two-factor" {
+		h.twoFactor(w, r, userID, segments[1:])
+		return
+	}
+	if len(segments) == 1 && segments[0] == "reauthenticate" {
+		h.reauthenticate(w, r, userID)
+		return
+	}
 
 	overview, err := h.loadOverview(r, userID)
 	if err != nil {
@@ -110,8 +144,24 @@ func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 	case "account":
 		h.account(w, r, userID)
 	case "password":
-		h.password(w, r, userID)
+		h.password(w, r, userID, overview.Workspace.ID)
 	case "workspace":
+		if len(segments) >= 2 && segments[1] == "audit" {
+			h.workspaceAudit(w, r, overview, segments[2:])
+			return
+		}
+		if len(segments) == 2 && segments[1] == "security" {
+			h.workspaceSecurity(w, r, userID, overview)
+			return
+		}
+		if len(segments) == 2 && segments[1] == "sso" {
+			h.workspaceSSO(w, r, userID, overview)
+			return
+		}
+		if len(segments) == 3 && segments[1] == "sso" && segments[2] == "verify" {
+			h.workspaceSSOVerify(w, r, overview)
+			return
+		}
 		h.workspace(w, r, userID, overview)
 	case "members":
 		h.members(w, r, userID, overview, segments)
@@ -119,6 +169,8 @@ func (h *Handler) Profile(w http.ResponseWriter, r *http.Request) {
 		h.invitations(w, r, userID, overview)
 	case "settings":
 		h.settings(w, r, userID)
+	case "api-tokens":
+		h.apiTokens(w, r, userID, overview, segments[1:])
 	case "billing":
 		h.billing(w, r, userID, overview, segments[1:])
 	case "about":
@@ -218,12 +270,37 @@ func (h *Handler) aiUsage(w http.ResponseWriter, r *http.Request, userID int) {
 			return
 		}
 	}
+	// Owners see every member's usage and the caps; a member sees their own.
+	owner := membership.Role == workspaces.MembershipRoleOwner
+	since := usage.WindowStartedAt
+	if since.IsZero() {
+		since = time.Now().UTC().Add(-7 * 24 * time.Hour)
+	}
+	breakdownUserID := userID
+	if owner {
+		breakdownUserID = 0
+	}
+	breakdown, err := h.store.AIUsageBreakdown(r.Context(), workspace.ID, breakdownUserID, since)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "ai_usage_load_failed")
+		return
+	}
+	var allocations []billing.Allocation
+	if owner && h.quotaService != nil {
+		allocations, err = h.quotaService.Allocations(r.Context(), workspace.ID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "ai_usage_load_failed")
+			return
+		}
+	}
 	api.WriteJSON(w, http.StatusOK, AIUsageResponse{
 		PlanCode: plan.Code, PlanName: plan.Name, ResetAmount: plan.ResetAmount,
 		Currency:              plan.Currency,
-		CanManageSubscription: membership.Role == workspaces.MembershipRoleOwner,
+		CanManageSubscription: owner,
 		AIChatEnabled:         plan.AIChatEnabled && subscription.Access,
 		AIUsage:               usage,
+		Breakdown:             breakdown,
+		Allocations:           allocations,
 	})
 }
 
@@ -257,7 +334,7 @@ func (h *Handler) account(w http.ResponseWriter, r *http.Request, userID int) {
 	api.WriteJSON(w, http.StatusOK, result)
 }
 
-func (h *Handler) password(w http.ResponseWriter, r *http.Request, userID int) {
+func (h *Handler) password(w http.ResponseWriter, r *http.Request, userID, workspaceID int) {
 	if r.Method != http.MethodPost {
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
 		return
@@ -278,10 +355,415 @@ func (h *Handler) password(w http.ResponseWriter, r *http.Request, userID int) {
 	case err != nil:
 		api.WriteError(w, http.StatusInternalServerError, "password_update_failed")
 	default:
+		h.recordAudit(r, workspaceID, userID, audit.ActionPasswordChanged, "user", strconv.Itoa(userID), nil, map[string]any{
+			"sessions_revoked": true,
+		})
 		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true, "reauthentication_required": true})
 	}
 }
 
+func (h *Handler) sessions(w http.ResponseWriter, r *http.Request, userID int, segments []string) {
+	currentSessionID, _ := auth.SessionIDFromContext(r.Context())
+	switch {
+	case len(segments) == 0:
+		if r.Method != http.MethodGet {
+			api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		items, err := auth.ListSessions(r.Context(), h.dbx, userID, currentSessionID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "sessions_load_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"sessions": items, "current_session_id": currentSessionID})
+	case len(segments) == 1 && segments[0] == "revoke-others":
+		if r.Method != http.MethodPost {
+			api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		if currentSessionID == "" {
+			api.WriteError(w, http.StatusConflict, "session_reauthentication_required")
+			return
+		}
+		revoked, err := auth.RevokeOtherSessions(r.Context(), h.dbx, userID, currentSessionID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "session_revoke_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true, "revoked": revoked})
+	case len(segments) == 1:
+		if r.Method != http.MethodDelete {
+			api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+		err := auth.RevokeSession(r.Context(), h.dbx, userID, segments[0])
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "session_revoke_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true, "current": segments[0] == currentSessionID})
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+func (h *Handler) twoFactor(w http.ResponseWriter, r *http.Request, userID int, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		status, err := auth.TwoFactorStatusForUser(r.Context(), h.dbx, userID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "two_factor_load_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, status)
+	case len(segments) == 0 && r.Method == http.MethodDelete:
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
+		writeTwoFactorResult(w, auth.DisableTwoFactor(r.Context(), h.dbx, userID), map[string]any{"ok": true})
+	case len(segments) == 1 && segments[0] == "setup" && r.Method == http.MethodPost:
+		setup, err := auth.BeginTwoFactorSetup(r.Context(), h.dbx, userID)
+		writeTwoFactorResult(w, err, setup)
+	case len(segments) == 1 && segments[0] == "confirm" && r.Method == http.MethodPost:
+		var body struct {
+			Code string `json:"code"`
+		}
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		codes, err := auth.ConfirmTwoFactor(r.Context(), h.dbx, userID, body.Code)
+		writeTwoFactorResult(w, err, map[string]any{"ok": true, "recovery_codes": codes})
+	case len(segments) == 1 && segments[0] == "recovery-codes" && r.Method == http.MethodPost:
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
+		codes, err := auth.RegenerateRecoveryCodes(r.Context(), h.dbx, userID)
+		writeTwoFactorResult(w, err, map[string]any{"recovery_codes": codes})
+	case len(segments) <= 1:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+func (h *Handler) reauthenticate(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != http.MethodPost {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	err := auth.Reauthenticate(r.Context(), h.dbx, userID, body.Password, body.Code)
+	switch {
+	case errors.Is(err, auth.ErrCurrentPasswordInvalid):
+		api.WriteError(w, http.StatusUnprocessableEntity, "current_password_invalid")
+	case errors.Is(err, auth.ErrSessionNotFound):
+		api.WriteError(w, http.StatusConflict, "session_reauthentication_required")
+	default:
+		writeTwoFactorResult(w, err, map[string]any{"ok": true})
+	}
+}
+
+func (h *Handler) workspaceSecurity(w http.ResponseWriter, r *http.Request, userID int, overview Overview) {
+	if !overview.Capabilities.ManageWorkspace {
+		api.WriteError(w, http.StatusForbidden, "owner_required")
+		return
+	}
+	if r.Method != http.MethodPatch {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	var body struct {
+		RequireTwoFactor *bool `json:"require_two_factor"`
+		AllowEmailSignIn *bool `json:"allow_email_sign_in"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if body.RequireTwoFactor == nil && body.AllowEmailSignIn == nil {
+		api.WriteError(w, http.StatusUnprocessableEntity, "invalid_workspace_security")
+		return
+	}
+	if !h.recentlyAuthenticated(w, r, userID) {
+		return
+	}
+	result := map[string]any{}
+	if body.RequireTwoFactor != nil {
+		if err := auth.SetWorkspaceTwoFactorRequirement(r.Context(), h.dbx, overview.Workspace.ID, userID, *body.RequireTwoFactor); err != nil {
+			writeTwoFactorResult(w, err, nil)
+			return
+		}
+		result["require_two_factor"] = *body.RequireTwoFactor
+	}
+	if body.AllowEmailSignIn != nil {
+		if err := auth.SetWorkspaceEmailSignIn(r.Context(), h.dbx, overview.Workspace.ID, *body.AllowEmailSignIn); err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "workspace_security_update_failed")
+			return
+		}
+		result["allow_email_sign_in"] = *body.AllowEmailSignIn
+	}
+	api.WriteJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) workspaceSSO(w http.ResponseWriter, r *http.Request, userID int, overview Overview) {
+	if !overview.Capabilities.ManageWorkspace {
+		api.WriteError(w, http.StatusForbidden, "owner_required")
+		return
+	}
+	if h.sso == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, "sso_unavailable")
+		return
+	}
+	workspaceID := overview.Workspace.ID
+	switch r.Method {
+	case http.MethodGet:
+		connection, err := h.sso.Connection(r.Context(), workspaceID)
+		writeSSOResult(w, err, connection)
+	case http.MethodPut:
+		var input auth.SSOConnectionInput
+		if !decodeJSON(w, r, &input) {
+			return
+		}
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
+		connection, err := h.sso.SaveConnection(r.Context(), workspaceID, userID, input)
+		writeSSOResult(w, err, connection)
+	case http.MethodDelete:
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
+		err := h.sso.DeleteConnection(r.Context(), workspaceID)
+		writeSSOResult(w, err, map[string]bool{"ok": true})
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// workspaceSSOVerify checks the DNS TXT record of a claimed SSO domain.
+func (h *Handler) workspaceSSOVerify(w http.ResponseWriter, r *http.Request, overview Overview) {
+	if !overview.Capabilities.ManageWorkspace {
+		api.WriteError(w, http.StatusForbidden, "owner_required")
+		return
+	}
+	if h.sso == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, "sso_unavailable")
+		return
+	}
+	if r.Method != http.MethodPost {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	var body struct {
+		Domain string `json:"domain"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	connection, err := h.sso.VerifyDomain(r.Context(), overview.Workspace.ID, body.Domain)
+	writeSSOResult(w, err, connection)
+}
+
+// workspaceAudit lists, exports and verifies the workspace's audit log for
+// owners and admins.
+func (h *Handler) workspaceAudit(w http.ResponseWriter, r *http.Request, overview Overview, segments []string) {
+	if !overview.Capabilities.ManageMembers {
+		api.WriteError(w, http.StatusForbidden, "member_management_required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	workspaceID := overview.Workspace.ID
+	if len(segments) == 1 && segments[0] == "verify" {
+		result, err := h.audit.Verify(r.Context(), workspaceID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "audit_verify_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, result)
+		return
+	}
+	if len(segments) != 0 {
+		api.WriteError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	filter, err := audit.ParseFilter(r.URL.Query())
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	if format != "" && format != "json" && format != "csv" {
+		api.WriteError(w, http.StatusBadRequest, "invalid_export_format")
+		return
+	}
+	if format != "" {
+		filter.Limit = audit.MaxExportRows
+	}
+	entries, err := h.audit.Events(r.Context(), workspaceID, filter)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "audit_load_failed")
+		return
+	}
+	filename := "audit-" + strconv.Itoa(workspaceID) + "-" + time.Now().UTC().Format("20060102")
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		w.WriteHeader(http.StatusOK)
+		_ = audit.WriteCSV(w, entries)
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		api.WriteJSON(w, http.StatusOK, map[string]any{
+			"workspace_id": workspaceID, "exported_at": time.Now().UTC(), "events": entries,
+		})
+	default:
+		response := map[string]any{"events": entries}
+		if len(entries) == filter.Limit {
+			response["next_before_id"] = entries[len(entries)-1].ID
+		}
+		api.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// recordAudit appends a workspace administration event after the change it
+// describes has been committed.
+func (h *Handler) recordAudit(
+	r *http.Request,
+	workspaceID, userID int,
+	action, targetType, targetID string,
+	before, after any,
+) {
+	h.audit.Log(r.Context(), audit.Event{
+		WorkspaceID: &workspaceID, ActorUserID: &userID, Action: action,
+		TargetType: targetType, TargetID: targetID, Before: before, After: after,
+	}.WithRequest(r))
+}
+
+func writeSSOResult(w http.ResponseWriter, err error, value any) {
+	switch {
+	case errors.Is(err, auth.ErrSSONotConfigured):
+		api.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrSSOConfigInvalid), errors.Is(err, auth.ErrSSODomainInvalid),
+		errors.Is(err, auth.ErrSSODomainNotVerified):
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, auth.ErrSSODomainTaken):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrSSOProviderFailed):
+		api.WriteError(w, http.StatusBadGateway, err.Error())
+	case err != nil:
+		api.WriteError(w, http.StatusInternalServerError, "sso_update_failed")
+	default:
+		api.WriteJSON(w, http.StatusOK, value)
+	}
+}
+
+// recentlyAuthenticated guards sensitive actions and writes the 403 that tells
+// the client to call /api/v2/profile/reauthenticate first.
+func (h *Handler) recentlyAuthenticated(w http.ResponseWriter, r *http.Request, userID int) bool {
+	err := auth.RequireRecentAuthentication(r.Context(), h.dbx, userID)
+	if errors.Is(err, auth.ErrReauthenticationRequired) {
+		api.WriteError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "reauthentication_check_failed")
+		return false
+	}
+	return true
+}
+
+func writeTwoFactorResult(w http.ResponseWriter, err error, value any) {
+	switch {
+	case errors.Is(err, auth.ErrTwoFactorInvalidCode):
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, auth.ErrTwoFactorNotEnrolled), errors.Is(err, auth.ErrTwoFactorAlreadyEnabled),
+		errors.Is(err, auth.ErrTwoFactorRequiredByWorkspace):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		api.WriteError(w, http.StatusInternalServerError, "two_factor_update_failed")
+	default:
+		api.WriteJSON(w, http.StatusOK, value)
+	}
+}
+
+func (h *Handler) apiTokens(w http.ResponseWriter, r *http.Request, userID int, overview Overview, segments []string) {
+	manageWorkspace := overview.Capabilities.ManageWorkspace
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		items, err := auth.ListAPITokens(r.Context(), h.dbx, userID, overview.Workspace.ID, manageWorkspace)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "api_tokens_load_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"tokens": items, "scopes": auth.APIScopes})
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		var body auth.APITokenInput
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if body.Kind == "" {
+			body.Kind = auth.APITokenPersonal
+		}
+		if len([]rune(body.Name)) < 2 || len([]rune(body.Name)) > 120 ||
+			!oneOf(body.Kind, auth.APITokenPersonal, auth.APITokenWorkspace) ||
+			(body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now())) {
+			api.WriteError(w, http.StatusUnprocessableEntity, "invalid_api_token")
+			return
+		}
+		if body.Kind == auth.APITokenWorkspace && !manageWorkspace {
+			api.WriteError(w, http.StatusForbidden, "owner_required")
+			return
+		}
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
+		item, secret, err := auth.CreateAPIToken(r.Context(), h.dbx, userID, overview.Workspace.ID, body)
+		switch {
+		case errors.Is(err, auth.ErrAPITokenScopeInvalid):
+			api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, auth.ErrAPITokenLimitReached):
+			api.WriteError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			api.WriteError(w, http.StatusInternalServerError, "api_token_create_failed")
+		default:
+			api.WriteJSON(w, http.StatusCreated, map[string]any{"token": item, "secret": secret})
+		}
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		tokenID, err := strconv.ParseInt(segments[0], 10, 64)
+		if err != nil || tokenID <= 0 {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		err = auth.RevokeAPIToken(r.Context(), h.dbx, userID, overview.Workspace.ID, manageWorkspace, tokenID)
+		if errors.Is(err, auth.ErrAPITokenNotFound) {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "api_token_revoke_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true})
+	case len(segments) <= 1:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
 func (h *Handler) workspace(w http.ResponseWriter, r *http.Request, userID int, overview Overview) {
 	if !overview.Capabilities.ManageWorkspace {
 		api.WriteError(w, http.StatusForbidden, "owner_required")
@@ -317,6 +799,9 @@ func (h *Handler) workspace(w http.ResponseWriter, r *http.Request, userID int,
 			api.WriteError(w, http.StatusUnprocessableEntity, "workspace_confirmation_mismatch")
 			return
 		}
+		if !h.recentlyAuthenticated(w, r, userID) {
+			return
+		}
 		if h.dataCleaner != nil {
 			if err := h.dataCleaner.CleanupWorkspaceData(r.Context(), overview.Workspace.ID); err != nil {
 				api.WriteError(w, http.StatusBadGateway, "workspace_external_cleanup_failed")
@@ -327,6 +812,8 @@ func (h *Handler) workspace(w http.ResponseWriter, r *http.Request, userID int,
 			api.WriteError(w, http.StatusInternalServerError, "workspace_delete_failed")
 			return
 		}
+		h.recordAudit(r, overview.Workspace.ID, userID, audit.ActionWorkspaceDeleted, "workspace",
+			strconv.Itoa(overview.Workspace.ID), map[string]any{"name": overview.Workspace.DisplayName}, nil)
 		secureCookies := h.cfg != nil && h.cfg.SecureCookies
 		auth.ClearSessionCookie(w, secureCookies)
 		w.Header().Set("Cache-Control", "no-store")
@@ -375,13 +862,21 @@ func (h *Handler) members(w http.ResponseWriter, r *http.Request, userID int, ov
 	}
 	switch r.Method {
 	case http.MethodDelete:
-		if err := h.store.RemoveMember(r.Context(), overview.Workspace.ID, userID, segments[1], id); errors.Is(err, sql.ErrNoRows) {
+		removed, err := h.store.RemoveMember(r.Context(), overview.Workspace.ID, userID, segments[1], id)
+		if errors.Is(err, sql.ErrNoRows) {
 			api.WriteError(w, http.StatusNotFound, "member_not_found")
 			return
-		} else if err != nil {
+		}
+		if err != nil {
 			api.WriteError(w, http.StatusInternalServerError, "member_remove_failed")
 			return
 		}
+		action := audit.ActionMemberRemoved
+		if removed.Kind == "invitation" {
+			action = audit.ActionInvitationCancelled
+		}
+		h.recordAudit(r, overview.Workspace.ID, userID, action, removed.Kind, strconv.FormatInt(removed.ID, 10),
+			map[string]any{"email": removed.Email, "role": removed.Role}, nil)
 		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true})
 	case http.MethodPatch:
 		if segments[1] != "membership" {
@@ -394,7 +889,7 @@ func (h *Handler) members(w http.ResponseWriter, r *http.Request, userID int, ov
 		if !decodeJSON(w, r, &body) {
 			return
 		}
-		item, err := h.store.UpdateMemberRole(
+		item, previousRole, err := h.store.UpdateMemberRole(
 			r.Context(), overview.Workspace.ID, userID, id, strings.ToLower(strings.TrimSpace(body.Role)),
 		)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -409,6 +904,10 @@ func (h *Handler) members(w http.ResponseWriter, r *http.Request, userID int, ov
 			api.WriteError(w, http.StatusInternalServerError, "member_role_update_failed")
 			return
 		}
+		h.recordAudit(r, overview.Workspace.ID, userID, audit.ActionMemberRoleChanged, "membership",
+			strconv.FormatInt(item.ID, 10),
+			map[string]any{"email": item.Email, "role": previousRole},
+			map[string]any{"email": item.Email, "role": item.Role})
 		api.WriteJSON(w, http.StatusOK, map[string]any{"member": item})
 	default:
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -484,6 +983,9 @@ func (h *Handler) invitations(w http.ResponseWriter, r *http.Request, userID int
 		api.WriteError(w, http.StatusInternalServerError, "invitation_create_failed")
 		return
 	}
+	h.recordAudit(r, overview.Workspace.ID, userID, audit.ActionMemberInvited, "invitation",
+		strconv.FormatInt(item.ID, 10), nil,
+		map[string]any{"email": email, "role": body.Role, "department_ids": item.DepartmentIDs})
 	emailDelivered := true
 	if token != "" {
 		inviteURL := strings.TrimRight(h.cfg.FrontendBaseURL, "/") + "/invite?token=" +
@@ -578,11 +1080,16 @@ func (h *Handler) billing(w http.ResponseWriter, r *http.Request, userID int, ov
 		api.WriteJSON(w, http.StatusOK, overview.Subscription)
 		return
 	}
+	if r.Method != http.MethodGet && !h.recentlyAuthenticated(w, r, userID) {
+		return
+	}
 	switch segments[0] {
 	case "checkout":
 		h.checkout(w, r, overview)
 	case "quota-resets":
 		h.quotaResets(w, r, userID, overview, segments[1:])
+	case "ai-allocations":
+		h.aiAllocations(w, r, userID, overview, segments[1:])
 	case "organization":
 		h.billingOrganization(w, r, userID, overview.Workspace.ID)
 	case "invoices":
@@ -591,6 +1098,14 @@ func (h *Handler) billing(w http.ResponseWriter, r *http.Request, userID int, ov
 		h.documents(w, r, overview.Workspace.ID, segments[1:])
 	case "payments":
 		h.paymentsHistory(w, r, overview.Workspace.ID)
+	case "dunning":
+		h.dunningHistory(w, r, overview.Workspace.ID)
+	case "plan-change":
+		h.planChange(w, r, userID, overview)
+	case "promo-code":
+		h.promoCode(w, r, overview)
+	case "referral":
+		h.referral(w, r, userID, overview)
 	default:
 		api.WriteError(w, http.StatusNotFound, "not_found")
 	}
@@ -625,6 +1140,59 @@ func (h *Handler) quotaResets(w http.ResponseWriter, r *http.Request, userID int
 	api.WriteJSON(w, http.StatusOK, map[string]any{"ai_usage": usage})
 }
 
+// aiAllocations manages the caps that split the weekly AI limit between
+// members, departments and modules.
+func (h *Handler) aiAllocations(w http.ResponseWriter, r *http.Request, userID int, overview Overview, segments []string) {
+	if h.quotaService == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, "billing_unavailable")
+		return
+	}
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		items, err := h.quotaService.Allocations(r.Context(), overview.Workspace.ID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "ai_allocations_load_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"allocations": items})
+	case len(segments) == 0 && r.Method == http.MethodPut:
+		var body billing.AllocationInput
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		item, err := h.quotaService.SaveAllocation(r.Context(), overview.Workspace.ID, userID, body)
+		if errors.Is(err, billing.ErrAllocationInvalid) {
+			api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "ai_allocation_save_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"allocation": item})
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		allocationID, err := strconv.ParseInt(segments[0], 10, 64)
+		if err != nil || allocationID <= 0 {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		err = h.quotaService.DeleteAllocation(r.Context(), overview.Workspace.ID, allocationID)
+		if errors.Is(err, billing.ErrAllocationNotFound) {
+			api.WriteError(w, http.StatusNotFound, "not_found")
+			return
+		}
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "ai_allocation_delete_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"ok": true})
+	case len(segments) <= 1:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
 func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Overview) {
 	if r.Method != http.MethodPost {
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -671,9 +1239,9 @@ func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Over
 		api.WriteError(w, http.StatusConflict, "pending_subscription_change_exists")
 		return
 	}
-	plan, err := billing.PlanByCode(request.PlanCode)
+	plan, err := billing.WorkspacePlan(r.Context(), h.store.dbx, overview.Workspace.ID, request.PlanCode)
 	if err != nil {
-		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		writeWorkspacePlanError(w, err)
 		return
 	}
 	if request.OrderKind == billing.OrderQuotaReset && (!plan.AIChatEnabled || plan.ResetAmount <= 0) {
@@ -702,7 +1270,26 @@ func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Over
 	} else if !plan.PerSeatPricing {
 		request.Quantity = 1
 	}
-	order, err := h.store.CreateCheckoutOrder(r.Context(), overview.Workspace.ID, overview.Account.ID, request, plan, amount)
+	renewalAmount := amount
+	var discount billing.Discount
+	if strings.TrimSpace(request.PromoCode) != "" {
+		discount, err = billing.QuotePromoCode(
+			r.Context(), h.store.dbx, overview.Workspace.ID, request.PromoCode, request.OrderKind,
+			plan, request.BillingPeriod, amount,
+		)
+		if err != nil {
+			writePromoCodeError(w, err)
+			return
+		}
+		amount = discount.Amount
+	}
+	order, err := h.store.CreateCheckoutOrder(
+		r.Context(), overview.Workspace.ID, overview.Account.ID, request, plan, amount, discount, h.orderProvider(),
+	)
+	if errors.Is(err, billing.ErrPromoCodeExhausted) {
+		writePromoCodeError(w, err)
+		return
+	}
 	if err != nil {
 		api.WriteError(w, http.StatusInternalServerError, "checkout_prepare_failed")
 		return
@@ -713,7 +1300,7 @@ func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Over
 	} else if request.BillingPeriod == billing.PeriodAnnual {
 		periodMonths = 12
 	}
-	if h.checkoutProvider() == "cloudpayments" {
+	if provider := h.checkoutProvider(); provider != "" && provider != "toppayments" {
 		now := time.Now().UTC()
 		startDate := now.AddDate(0, periodMonths, 0)
 		replacingActivePerSeatPlan := request.OrderKind == billing.OrderSubscription &&
@@ -721,27 +1308,25 @@ func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Over
 		if overview.Subscription.Access && overview.Subscription.PeriodEnd != nil && overview.Subscription.PeriodEnd.After(now) && !replacingActivePerSeatPlan {
 			startDate = overview.Subscription.PeriodEnd.AddDate(0, periodMonths, 0)
 		}
-		description := "REUP.goals · " + plan.Name
-		if plan.PerSeatPricing {
-			description = fmt.Sprintf("REUP.goals · %s · %d мест(а)", plan.Name, request.Quantity)
-		}
+		description := subscriptionDescription(plan, request.Quantity)
 		if request.OrderKind == billing.OrderQuotaReset {
 			description = fmt.Sprintf("REUP.goals · %d сброс(а) AI-лимита", request.Quantity)
 		}
-		api.WriteJSON(w, http.StatusOK, map[string]any{
-			"provider": "cloudpayments",
-			"mode":     "widget",
-			"config": map[string]any{
-				"public_id": h.payments.PublicID(), "description": description,
-				"first_payment_amount": amount, "amount": amount,
-				"currency": plan.Currency, "account_id": "reup_user_" + strconv.Itoa(overview.Account.ID),
-				"email": overview.Account.Email, "trial_days": 0,
-				"start_date": startDate.Format(time.RFC3339), "period_months": periodMonths,
-				"invoice_id": strconv.FormatInt(order.ID, 10),
-				"recurrent":  request.OrderKind == billing.OrderSubscription,
-				"order_kind": request.OrderKind, "quantity": request.Quantity,
-			},
+		session, err := h.cardProvider.CheckoutSession(r.Context(), subscriptions.CheckoutRequest{
+			OrderID: order.ID, UserID: overview.Account.ID, Email: overview.Account.Email,
+			Description: description, Amount: amount, RenewalAmount: renewalAmount, Currency: plan.Currency,
+			Recurrent: request.OrderKind == billing.OrderSubscription, StartDate: startDate,
+			PeriodMonths: periodMonths, ReturnURL: h.checkoutReturnURL(),
+			IdempotenceKey: checkoutIdempotenceKey(order.ID, request.IdempotencyKey),
+			Extra:          map[string]any{"order_kind": request.OrderKind, "quantity": request.Quantity},
 		})
+		if err != nil {
+			api.WriteError(w, http.StatusBadGateway, "checkout_prepare_failed")
+			return
+		}
+		response := checkoutSessionResponse(session)
+		response["discount"] = discountResponse(discount)
+		api.WriteJSON(w, http.StatusOK, response)
 		return
 	}
 	if h.checkoutProvider() == "toppayments" {
@@ -767,6 +1352,274 @@ func (h *Handler) checkout(w http.ResponseWriter, r *http.Request, overview Over
 	api.WriteError(w, http.StatusServiceUnavailable, "checkout_not_configured")
 }
 
+// planChange previews (GET), applies or schedules (POST) and cancels a
+// scheduled (DELETE) move to another plan or seat count. Upgrades with an
+// amount due are paid by a prorated invoice or a card order and applied on
+// confirmation.
+func (h *Handler) planChange(w http.ResponseWriter, r *http.Request, userID int, overview Overview) {
+	if h.quotaService == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, "billing_unavailable")
+		return
+	}
+	workspaceID := overview.Workspace.ID
+	switch r.Method {
+	case http.MethodGet:
+		quantity, _ := strconv.Atoi(r.URL.Query().Get("quantity"))
+		change, err := h.quotaService.PreviewPlanChange(r.Context(), workspaceID, r.URL.Query().Get("plan_code"), max(1, quantity))
+		if err != nil {
+			writePlanChangeError(w, err)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, change)
+	case http.MethodPost:
+		if !h.cfg.BillingPaymentsEnabled {
+			api.WriteError(w, http.StatusServiceUnavailable, "checkout_not_configured")
+			return
+		}
+		var request PlanChangeRequest
+		if !decodeJSON(w, r, &request) {
+			return
+		}
+		change, err := h.quotaService.PreviewPlanChange(r.Context(), workspaceID, request.PlanCode, max(1, request.Quantity))
+		if err != nil {
+			writePlanChangeError(w, err)
+			return
+		}
+		plan, _ := billing.PlanByCode(change.PlanCode)
+		switch {
+		case !change.Immediate:
+			if err := h.quotaService.SchedulePlanChange(r.Context(), workspaceID, change); err != nil {
+				writePlanChangeError(w, err)
+				return
+			}
+			if err := h.syncCardSubscriptionAmount(r, workspaceID, change.RenewalAmount, subscriptionDescription(plan, change.Quantity)); err != nil {
+				_ = h.quotaService.CancelScheduledPlanChange(r.Context(), workspaceID)
+				api.WriteError(w, http.StatusBadGateway, "cloudpayments_update_failed")
+				return
+			}
+			h.recordAudit(r, workspaceID, userID, audit.ActionBillingPlanChanged, "subscription",
+				strconv.Itoa(workspaceID), overview.Subscription.PlanCode, change)
+			api.WriteJSON(w, http.StatusOK, map[string]any{"status": "scheduled", "change": change})
+		case change.AmountDue <= 0:
+			if err := h.syncCardSubscriptionAmount(r, workspaceID, change.RenewalAmount, subscriptionDescription(plan, change.Quantity)); err != nil {
+				api.WriteError(w, http.StatusBadGateway, "cloudpayments_update_failed")
+				return
+			}
+			if err := h.quotaService.ApplyPlanChange(r.Context(), workspaceID, change); err != nil {
+				current, _ := billing.PlanByCode(overview.Subscription.PlanCode)
+				_ = h.syncCardSubscriptionAmount(r, workspaceID, overview.Subscription.Amount,
+					subscriptionDescription(current, overview.Subscription.MemberLimit))
+				writePlanChangeError(w, err)
+				return
+			}
+			h.recordAudit(r, workspaceID, userID, audit.ActionBillingPlanChanged, "subscription",
+				strconv.Itoa(workspaceID), overview.Subscription.PlanCode, change)
+			api.WriteJSON(w, http.StatusOK, map[string]any{"status": "applied", "change": change})
+		case overview.Subscription.PaymentMethod == "invoice":
+			if h.cfg.Environment == "production" {
+				api.WriteError(w, http.StatusForbidden, "invoice_payments_disabled")
+				return
+			}
+			invoice, err := h.store.CreatePlanChangeInvoice(r.Context(), workspaceID, userID, change, request.IdempotencyKey)
+			if err != nil {
+				if strings.Contains(err.Error(), "billing_organization_required") {
+					api.WriteError(w, http.StatusUnprocessableEntity, "billing_organization_required")
+					return
+				}
+				api.WriteError(w, http.StatusInternalServerError, "invoice_create_failed")
+				return
+			}
+			api.WriteJSON(w, http.StatusCreated, map[string]any{"status": "invoiced", "change": change, "invoice": invoice})
+		default:
+			if provider := h.checkoutProvider(); provider == "" || provider == "toppayments" {
+				api.WriteError(w, http.StatusServiceUnavailable, "checkout_not_configured")
+				return
+			}
+			order, err := h.store.CreatePlanChangeOrder(
+				r.Context(), workspaceID, userID, change, request.IdempotencyKey, h.orderProvider(),
+			)
+			if err != nil {
+				api.WriteError(w, http.StatusInternalServerError, "checkout_prepare_failed")
+				return
+			}
+			periodMonths := 1
+			if change.BillingPeriod == billing.PeriodQuarterly {
+				periodMonths = 3
+			} else if change.BillingPeriod == billing.PeriodAnnual {
+				periodMonths = 12
+			}
+			session, err := h.cardProvider.CheckoutSession(r.Context(), subscriptions.CheckoutRequest{
+				OrderID: order.ID, UserID: overview.Account.ID, Email: overview.Account.Email,
+				Description: subscriptionDescription(plan, change.Quantity), Amount: order.Amount,
+				RenewalAmount: change.RenewalAmount, Currency: order.Currency, Recurrent: true,
+				StartDate: change.PeriodEnd, PeriodMonths: periodMonths, ReturnURL: h.checkoutReturnURL(),
+				IdempotenceKey: checkoutIdempotenceKey(order.ID, request.IdempotencyKey),
+				Extra:          map[string]any{"order_kind": order.OrderKind, "quantity": order.Quantity},
+			})
+			if err != nil {
+				api.WriteError(w, http.StatusBadGateway, "checkout_prepare_failed")
+				return
+			}
+			response := checkoutSessionResponse(session)
+			response["status"], response["change"] = "payment_required", change
+			api.WriteJSON(w, http.StatusOK, response)
+		}
+	case http.MethodDelete:
+		if overview.Subscription.ScheduledPlanCode == "" {
+			api.WriteError(w, http.StatusNotFound, "scheduled_plan_change_not_found")
+			return
+		}
+		current, _ := billing.PlanByCode(overview.Subscription.PlanCode)
+		if err := h.syncCardSubscriptionAmount(r, workspaceID, overview.Subscription.Amount,
+			subscriptionDescription(current, overview.Subscription.MemberLimit)); err != nil {
+			api.WriteError(w, http.StatusBadGateway, "cloudpayments_update_failed")
+			return
+		}
+		if err := h.quotaService.CancelScheduledPlanChange(r.Context(), workspaceID); err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "plan_change_cancel_failed")
+			return
+		}
+		h.recordAudit(r, workspaceID, userID, audit.ActionBillingPlanChanged, "subscription",
+			strconv.Itoa(workspaceID), overview.Subscription.ScheduledPlanCode, overview.Subscription.PlanCode)
+		api.WriteJSON(w, http.StatusOK, map[string]any{"status": "cancelled"})
+	default:
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+	}
+}
+
+// syncCardSubscriptionAmount points the recurrent card charge at a new
+// price. Workspaces paying by invoice have nothing to update.
+func (h *Handler) syncCardSubscriptionAmount(r *http.Request, workspaceID int, amount float64, description string) error {
+	subscriptionID, err := h.store.CloudPaymentsSubscriptionID(r.Context(), workspaceID)
+	if err != nil || subscriptionID == "" {
+		return err
+	}
+	if h.payments == nil {
+		return errors.New("cloudpayments_not_configured")
+	}
+	return h.payments.UpdateSubscription(subscriptionID, amount, description)
+}
+
+func writePlanChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, billing.ErrPlanChangeUnavailable), errors.Is(err, billing.ErrPendingPlanChange):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, billing.ErrPlanChangeNoop), errors.Is(err, billing.ErrSeatsBelowMembers),
+		errors.Is(err, billing.ErrPlanNotFound), errors.Is(err, billing.ErrPlanUnavailable),
+		err.Error() == "billing_quantity_invalid":
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		api.WriteError(w, http.StatusInternalServerError, "plan_change_failed")
+	}
+}
+
+// promoCode previews a promo code for the plan, period and seat count the
+// checkout form has selected.
+func (h *Handler) promoCode(w http.ResponseWriter, r *http.Request, overview Overview) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	query := r.URL.Query()
+	period := strings.ToLower(strings.TrimSpace(query.Get("billing_period")))
+	if period == "" {
+		period = billing.PeriodMonthly
+	}
+	quantity, _ := strconv.Atoi(query.Get("quantity"))
+	plan, err := billing.WorkspacePlan(r.Context(), h.store.dbx, overview.Workspace.ID, query.Get("plan_code"))
+	if err != nil {
+		writeWorkspacePlanError(w, err)
+		return
+	}
+	if !plan.PerSeatPricing || quantity < 1 {
+		quantity = 1
+	}
+	amount, err := billing.SubscriptionPrice(plan, period, quantity)
+	if err != nil {
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	discount, err := billing.QuotePromoCode(
+		r.Context(), h.store.dbx, overview.Workspace.ID, query.Get("code"), billing.OrderSubscription,
+		plan, period, amount,
+	)
+	if err != nil {
+		writePromoCodeError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, discount)
+}
+
+// referral attributes the workspace to the partner whose referral link the
+// owner signed up through. The frontend keeps the code from the link until
+// the workspace exists.
+func (h *Handler) referral(w http.ResponseWriter, r *http.Request, userID int, overview Overview) {
+	if r.Method != http.MethodPost {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	var body struct {
+		ReferralCode string `json:"referral_code"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	partner, err := billing.AttributeReferral(r.Context(), h.store.dbx, overview.Workspace.ID, body.ReferralCode)
+	switch {
+	case errors.Is(err, billing.ErrPartnerNotFound):
+		api.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, billing.ErrWorkspaceAttributed):
+		api.WriteError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		api.WriteError(w, http.StatusInternalServerError, "referral_attribution_failed")
+		return
+	}
+	h.recordAudit(r, overview.Workspace.ID, userID, audit.ActionBillingReferralAttributed, "workspace",
+		strconv.Itoa(overview.Workspace.ID), nil, map[string]any{"partner_id": partner.ID, "code": partner.ReferralCode})
+	api.WriteJSON(w, http.StatusOK, map[string]any{"partner": partner.Name, "referral_code": partner.ReferralCode})
+}
+
+// writePromoCodeError reports why a promo code does not apply; the checkout
+// form shows it next to the code field.
+func writePromoCodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, billing.ErrPromoCodeNotFound):
+		api.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, billing.ErrPromoCodeExhausted), errors.Is(err, billing.ErrPromoCodeRedeemed):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, billing.ErrPromoCodeNotApplicable):
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		api.WriteError(w, http.StatusInternalServerError, "promo_code_check_failed")
+	}
+}
+
+func discountResponse(discount billing.Discount) any {
+	if discount.PromoCodeID == 0 {
+		return nil
+	}
+	return discount
+}
+
+// writeWorkspacePlanError answers a checkout for a plan that is unknown or no
+// longer sold with 422; anything else is a failed catalog lookup.
+func writeWorkspacePlanError(w http.ResponseWriter, err error) {
+	if errors.Is(err, billing.ErrPlanNotFound) || errors.Is(err, billing.ErrPlanUnavailable) {
+		api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	api.WriteError(w, http.StatusInternalServerError, "billing_plan_load_failed")
+}
+
+func subscriptionDescription(plan billing.Plan, quantity int) string {
+	if plan.PerSeatPricing {
+		return fmt.Sprintf("REUP.goals · %s · %d мест(а)", plan.Name, quantity)
+	}
+	return "REUP.goals · " + plan.Name
+}
+
 func (h *Handler) billingOrganization(w http.ResponseWriter, r *http.Request, userID, workspaceID int) {
 	switch r.Method {
 	case http.MethodGet:
@@ -786,11 +1639,18 @@ func (h *Handler) billingOrganization(w http.ResponseWriter, r *http.Request, us
 			api.WriteError(w, http.StatusUnprocessableEntity, "invalid_billing_organization")
 			return
 		}
+		previous, err := h.store.BillingOrganization(r.Context(), workspaceID)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, "billing_organization_update_failed")
+			return
+		}
 		result, err := h.store.SaveBillingOrganization(r.Context(), workspaceID, userID, body)
 		if err != nil {
 			api.WriteError(w, http.StatusInternalServerError, "billing_organization_update_failed")
 			return
 		}
+		h.recordAudit(r, workspaceID, userID, audit.ActionBillingOrganizationUpdated, "billing_organization",
+			strconv.Itoa(workspaceID), previous, result)
 		api.WriteJSON(w, http.StatusOK, result)
 	default:
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -840,9 +1700,9 @@ func (h *Handler) invoices(w http.ResponseWriter, r *http.Request, userID int, o
 				api.WriteError(w, http.StatusUnprocessableEntity, "billing_reset_plan_mismatch")
 				return
 			}
-			plan, err := billing.PlanByCode(request.PlanCode)
+			plan, err := billing.WorkspacePlan(r.Context(), h.store.dbx, overview.Workspace.ID, request.PlanCode)
 			if err != nil {
-				api.WriteError(w, http.StatusUnprocessableEntity, err.Error())
+				writeWorkspacePlanError(w, err)
 				return
 			}
 			if _, err := billing.Price(plan, request.BillingPeriod); err != nil {
@@ -855,6 +1715,11 @@ func (h *Handler) invoices(w http.ResponseWriter, r *http.Request, userID int, o
 					api.WriteError(w, http.StatusUnprocessableEntity, "billing_organization_required")
 					return
 				}
+				if errors.Is(err, billing.ErrPromoCodeNotFound) || errors.Is(err, billing.ErrPromoCodeExhausted) ||
+					errors.Is(err, billing.ErrPromoCodeRedeemed) || errors.Is(err, billing.ErrPromoCodeNotApplicable) {
+					writePromoCodeError(w, err)
+					return
+				}
 				api.WriteError(w, http.StatusInternalServerError, "invoice_create_failed")
 				return
 			}
@@ -930,6 +1795,10 @@ func (h *Handler) documents(w http.ResponseWriter, r *http.Request, workspaceID
 		api.WriteJSON(w, http.StatusOK, map[string]any{"documents": items})
 		return
 	}
+	if len(segments) == 1 && segments[0] == "archive" {
+		h.documentsArchive(w, r, workspaceID)
+		return
+	}
 	if len(segments) != 2 || segments[1] != "download" || r.Method != http.MethodGet {
 		api.WriteError(w, http.StatusNotFound, "not_found")
 		return
@@ -955,6 +1824,40 @@ func (h *Handler) documents(w http.ResponseWriter, r *http.Request, workspaceID
 	_, _ = w.Write(content)
 }
 
+// documentsArchive returns the invoices and acts of one quarter as a ZIP
+// for the customer's accountant.
+func (h *Handler) documentsArchive(w http.ResponseWriter, r *http.Request, workspaceID int) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	year, yearErr := strconv.Atoi(r.URL.Query().Get("year"))
+	quarter, quarterErr := strconv.Atoi(r.URL.Query().Get("quarter"))
+	if yearErr != nil || quarterErr != nil || year < 2000 || year > 2100 || quarter < 1 || quarter > 4 {
+		api.WriteError(w, http.StatusBadRequest, "invalid_quarter")
+		return
+	}
+	documents, err := h.store.QuarterDocuments(r.Context(), workspaceID, year, quarter)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "billing_documents_load_failed")
+		return
+	}
+	if len(documents) == 0 {
+		api.WriteError(w, http.StatusNotFound, "billing_documents_not_found")
+		return
+	}
+	content, err := buildDocumentsArchive(documents)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "billing_documents_archive_failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("reup-documents-%d-Q%d.zip", year, quarter)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
+
 func (h *Handler) paymentsHistory(w http.ResponseWriter, r *http.Request, workspaceID int) {
 	if r.Method != http.MethodGet {
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -968,6 +1871,23 @@ func (h *Handler) paymentsHistory(w http.ResponseWriter, r *http.Request, worksp
 	api.WriteJSON(w, http.StatusOK, map[string]any{"payments": items})
 }
 
+func (h *Handler) dunningHistory(w http.ResponseWriter, r *http.Request, workspaceID int) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	if h.quotaService == nil {
+		api.WriteError(w, http.StatusServiceUnavailable, "billing_unavailable")
+		return
+	}
+	cases, err := h.quotaService.DunningHistory(r.Context(), workspaceID, 20)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "dunning_load_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"cases": cases})
+}
+
 func (h *Handler) about(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
@@ -986,12 +1906,43 @@ func (h *Handler) checkoutAvailable() bool {
 	return h.checkoutProvider() != ""
 }
 
+// orderProvider is the provider recorded on new card orders. TopPayments
+// redirects keep the CloudPayments default they always had.
+func (h *Handler) orderProvider() string {
+	if h.cardProvider != nil && h.cardProvider.Configured() {
+		return h.cardProvider.Name()
+	}
+	return billing.ProviderCloudPayments
+}
+
+func (h *Handler) checkoutReturnURL() string {
+	return h.cfg.FrontendBaseURL + "/account?section=subscription&payment=return"
+}
+
+// checkoutIdempotenceKey scopes the client's key to the order, so retrying
+// one checkout returns the same provider payment.
+func checkoutIdempotenceKey(orderID int64, clientKey string) string {
+	clientKey = strings.TrimSpace(clientKey)
+	if clientKey == "" {
+		return ""
+	}
+	return "order-" + strconv.FormatInt(orderID, 10) + "-" + clientKey
+}
+
+func checkoutSessionResponse(session subscriptions.CheckoutSession) map[string]any {
+	response := map[string]any{"provider": session.Provider, "mode": session.Mode, "config": session.Config}
+	if session.CheckoutURL != "" {
+		response["checkout_url"] = session.CheckoutURL
+	}
+	return response
+}
+
 func (h *Handler) checkoutProvider() string {
 	if !h.cfg.BillingPaymentsEnabled {
 		return ""
 	}
-	if h.payments != nil && h.payments.PublicID() != "" {
-		return "cloudpayments"
+	if h.cardProvider != nil && h.cardProvider.Configured() {
+		return h.cardProvider.Name()
 	}
 	if h.cfg.TopPaymentsCheckoutURL != "" {
 		return "toppayments"
@@ -1022,7 +1973,8 @@ func (h *Handler) loadOverview(r *http.Request, userID int) (Overview, error) {
 		result.Subscription.Currency = h.payments.Currency()
 		result.Subscription.Plan = h.payments.PlanName()
 	}
-	if plan, planErr := billing.PlanByCode(result.Subscription.PlanCode); planErr == nil {
+	plan, planErr := billing.PlanAtVersion(result.Subscription.PlanCode, result.Subscription.PriceVersion)
+	if planErr == nil {
 		result.Subscription.Plan = plan.Name
 		if !plan.PerSeatPricing || !result.Subscription.Access {
 			if amount, amountErr := billing.Price(plan, result.Subscription.BillingPeriod); amountErr == nil {
@@ -1036,6 +1988,15 @@ func (h *Handler) loadOverview(r *http.Request, userID int) (Overview, error) {
 		result.Subscription.AIChatEnabled = result.Subscription.Access && plan.AIChatEnabled
 	}
 	result.Subscription.AvailablePlans = billing.Plans()
+	if planErr == nil && result.Subscription.Access {
+		// A grandfathered subscriber renews at the price they bought, so the
+		// plan list quotes that price for their own plan.
+		for index := range result.Subscription.AvailablePlans {
+			if result.Subscription.AvailablePlans[index].Code == plan.Code {
+				result.Subscription.AvailablePlans[index] = plan
+			}
+		}
+	}
 	if h.quotaService != nil {
 		usage, usageErr := h.quotaService.Summary(r.Context(), result.Workspace.ID)
 		if usageErr != nil {
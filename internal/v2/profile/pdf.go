@@ -161,6 +161,241 @@ func BuildInvoicePDF(invoice Invoice, seller SellerProfile, buyer BillingOrganiz
 	return output.Bytes(), nil
 }
 
+// BuildActPDF renders the act of services that closes a paid period. It
+// shares the invoice layout so both documents read as one set.
+func BuildActPDF(act Act, seller SellerProfile, buyer BillingOrganization) ([]byte, error) {
+	document, err := newClosingDocumentPDF("act")
+	if err != nil {
+		return nil, err
+	}
+
+	document.SetTitle("Акт "+act.Number, true)
+	document.SetAuthor("REUP.goals", true)
+	document.AddPage()
+	writeInvoiceHeader(document, seller)
+
+	document.SetXY(invoicePageLeft, 44)
+	document.SetFont("invoice", "B", 15)
+	document.SetTextColor(13, 27, 43)
+	document.MultiCell(
+		invoicePageWidth,
+		7,
+		fmt.Sprintf("Акт оказанных услуг № %s от %s", act.Number, russianLongDate(act.Date)),
+		"",
+		"C",
+		false,
+	)
+	document.SetDrawColor(33, 126, 236)
+	document.SetLineWidth(0.8)
+	document.Line(invoicePageLeft, document.GetY()+1.5, invoicePageRight, document.GetY()+1.5)
+	document.Ln(6)
+
+	writeInvoiceParty(document, "Исполнитель:", legalPartyDetails(
+		seller.FullName,
+		seller.INN,
+		seller.KPP,
+		seller.RegistrationNumber,
+		seller.LegalAddress,
+	))
+	writeInvoiceParty(document, "Заказчик:", legalPartyDetails(
+		buyer.FullName,
+		buyer.INN,
+		buyer.KPP,
+		buyer.RegistrationNumber,
+		buyer.LegalAddress,
+	))
+	if number := strings.TrimSpace(act.InvoiceNumber); number != "" {
+		writeInvoiceParty(document, "Основание:", "Счёт на оплату № "+number)
+	}
+	document.Ln(5)
+
+	item := Invoice{
+		Description: fmt.Sprintf(
+			"%s за период с %s по %s",
+			valueOrDash(act.Description),
+			act.PeriodStart.Format("02.01.2006"),
+			act.PeriodEnd.Format("02.01.2006"),
+		),
+		Amount:   act.Amount,
+		Currency: act.Currency,
+		TaxLabel: act.TaxLabel,
+	}
+	itemBottom := writeInvoiceItemTable(document, item)
+	document.SetY(itemBottom + 5)
+	writeInvoiceTotals(document, item)
+
+	document.Ln(3)
+	document.SetFont("invoice", "", 9.5)
+	document.SetTextColor(13, 27, 43)
+	document.MultiCell(
+		invoicePageWidth,
+		5,
+		fmt.Sprintf(
+			"Всего оказано услуг 1, на сумму %s %s\n%s",
+			formatMoney(act.Amount),
+			invoiceCurrencyLabel(act.Currency),
+			russianMoneyWords(act.Amount, act.Currency),
+		),
+		"",
+		"L",
+		false,
+	)
+	document.Ln(3)
+	document.SetFont("invoice", "", 8.5)
+	document.MultiCell(
+		invoicePageWidth,
+		4.5,
+		"Вышеперечисленные услуги оказаны полностью и в срок. Заказчик претензий по объёму, качеству и срокам оказания услуг не имеет.",
+		"",
+		"L",
+		false,
+	)
+
+	writeActSignatureArea(document, seller, buyer)
+
+	var output bytes.Buffer
+	if err := document.Output(&output); err != nil {
+		return nil, fmt.Errorf("render act PDF: %w", err)
+	}
+	return output.Bytes(), nil
+}
+
+// BuildCreditNotePDF renders the credit note of a refund in the layout of
+// the act it corrects.
+func BuildCreditNotePDF(note CreditNote, seller SellerProfile, buyer BillingOrganization) ([]byte, error) {
+	document, err := newClosingDocumentPDF("credit note")
+	if err != nil {
+		return nil, err
+	}
+
+	document.SetTitle("Кредит-нота "+note.Number, true)
+	document.SetAuthor("REUP.goals", true)
+	document.AddPage()
+	writeInvoiceHeader(document, seller)
+
+	document.SetXY(invoicePageLeft, 44)
+	document.SetFont("invoice", "B", 15)
+	document.SetTextColor(13, 27, 43)
+	document.MultiCell(
+		invoicePageWidth,
+		7,
+		fmt.Sprintf("Кредит-нота № %s от %s", note.Number, russianLongDate(note.Date)),
+		"",
+		"C",
+		false,
+	)
+	document.SetDrawColor(33, 126, 236)
+	document.SetLineWidth(0.8)
+	document.Line(invoicePageLeft, document.GetY()+1.5, invoicePageRight, document.GetY()+1.5)
+	document.Ln(6)
+
+	writeInvoiceParty(document, "Исполнитель:", legalPartyDetails(
+		seller.FullName,
+		seller.INN,
+		seller.KPP,
+		seller.RegistrationNumber,
+		seller.LegalAddress,
+	))
+	writeInvoiceParty(document, "Заказчик:", legalPartyDetails(
+		buyer.FullName,
+		buyer.INN,
+		buyer.KPP,
+		buyer.RegistrationNumber,
+		buyer.LegalAddress,
+	))
+	basis := "Оплата от " + note.PaidAt.Format("02.01.2006")
+	if number := strings.TrimSpace(note.InvoiceNumber); number != "" {
+		basis = "Счёт на оплату № " + number + ", оплата от " + note.PaidAt.Format("02.01.2006")
+	}
+	writeInvoiceParty(document, "Основание:", basis)
+	if reason := strings.TrimSpace(note.Reason); reason != "" {
+		writeInvoiceParty(document, "Причина:", reason)
+	}
+	document.Ln(5)
+
+	description := "Возврат оплаты: " + valueOrDash(note.Description)
+	if note.PeriodStart != nil && note.PeriodEnd != nil {
+		description += fmt.Sprintf(
+			" за период с %s по %s",
+			note.PeriodStart.Format("02.01.2006"),
+			note.PeriodEnd.Format("02.01.2006"),
+		)
+	}
+	item := Invoice{Description: description, Amount: note.Amount, Currency: note.Currency, TaxLabel: note.TaxLabel}
+	itemBottom := writeInvoiceItemTable(document, item)
+	document.SetY(itemBottom + 5)
+
+	document.SetFont("invoice", "B", 9.5)
+	document.SetTextColor(13, 27, 43)
+	document.MultiCell(
+		invoicePageWidth,
+		5,
+		fmt.Sprintf(
+			"Сумма возврата: %s %s (%s)\n%s",
+			formatMoney(note.Amount),
+			invoiceCurrencyLabel(note.Currency),
+			valueOrDash(note.TaxLabel),
+			russianMoneyWords(note.Amount, note.Currency),
+		),
+		"",
+		"L",
+		false,
+	)
+	document.Ln(3)
+	document.SetFont("invoice", "", 8.5)
+	document.MultiCell(
+		invoicePageWidth,
+		4.5,
+		"Стоимость оказанных услуг уменьшена на сумму возврата. Денежные средства возвращены Заказчику тем же способом, которым была произведена оплата, если стороны не договорились об ином.",
+		"",
+		"L",
+		false,
+	)
+
+	writeActSignatureArea(document, seller, buyer)
+
+	var output bytes.Buffer
+	if err := document.Output(&output); err != nil {
+		return nil, fmt.Errorf("render credit note PDF: %w", err)
+	}
+	return output.Bytes(), nil
+}
+
+// newClosingDocumentPDF prepares an A4 page with the invoice fonts and
+// logo for acts and credit notes.
+func newClosingDocumentPDF(kind string) (*fpdf.Fpdf, error) {
+	fontPath, err := invoiceFontPath()
+	if err != nil {
+		return nil, err
+	}
+	fontData, err := readInvoiceFont(fontPath)
+	if err != nil {
+		return nil, err
+	}
+	boldFontData := fontData
+	if boldPath, boldErr := invoiceFontPathFromCandidates(invoiceBoldFontCandidates); boldErr == nil {
+		if data, readErr := readInvoiceFont(boldPath); readErr == nil {
+			boldFontData = data
+		}
+	}
+
+	document := fpdf.New("P", "mm", "A4", "")
+	document.SetCompression(true)
+	document.SetMargins(invoicePageLeft, 12, 12)
+	document.SetAutoPageBreak(true, 16)
+	document.AddUTF8FontFromBytes("invoice", "", fontData)
+	document.AddUTF8FontFromBytes("invoice", "B", boldFontData)
+	document.RegisterImageOptionsReader(
+		"reup-wordmark",
+		fpdf.ImageOptions{ImageType: "PNG", ReadDpi: false},
+		bytes.NewReader(invoiceLogo),
+	)
+	if document.Error() != nil {
+		return nil, fmt.Errorf("load %s assets: %w", kind, document.Error())
+	}
+	return document, nil
+}
+
 func writeInvoiceHeader(document *fpdf.Fpdf, seller SellerProfile) {
 	document.ImageOptions(
 		"reup-wordmark",
@@ -477,6 +712,53 @@ func writeInvoiceSignatureArea(document *fpdf.Fpdf, seller SellerProfile) {
 	document.CellFormat(12, 5, "М.П.", "", 0, "L", false, 0, "")
 }
 
+func writeActSignatureArea(document *fpdf.Fpdf, seller SellerProfile, buyer BillingOrganization) {
+	y := math.Max(document.GetY()+14, 200)
+	if y > 250 {
+		document.AddPage()
+		y = 28
+	}
+	const columnWidth = (invoicePageWidth - 10) / 2
+	columns := []struct {
+		x     float64
+		title string
+		party string
+		name  string
+	}{
+		{invoicePageLeft, "Исполнитель", seller.FullName, seller.DirectorName},
+		{invoicePageLeft + columnWidth + 10, "Заказчик", buyer.FullName, buyer.ContactPerson},
+	}
+	for _, column := range columns {
+		document.SetFont("invoice", "B", 8.5)
+		document.SetTextColor(13, 27, 43)
+		document.SetXY(column.x, y)
+		document.CellFormat(columnWidth, 5, column.title, "", 0, "L", false, 0, "")
+		document.SetFont("invoice", "", 8)
+		document.SetXY(column.x, y+5)
+		document.MultiCell(columnWidth, 4.2, valueOrDash(column.party), "", "L", false)
+
+		lineY := y + 24
+		document.SetDrawColor(90, 99, 109)
+		document.SetLineWidth(0.25)
+		document.Line(column.x, lineY, column.x+columnWidth*0.45, lineY)
+		document.Line(column.x+columnWidth*0.5, lineY, column.x+columnWidth, lineY)
+		document.SetXY(column.x+columnWidth*0.5, lineY-4.7)
+		document.CellFormat(columnWidth*0.5, 4.5, strings.TrimSpace(column.name), "", 0, "C", false, 0, "")
+
+		document.SetFont("invoice", "", 6.5)
+		document.SetTextColor(98, 108, 119)
+		document.SetXY(column.x, lineY+0.3)
+		document.CellFormat(columnWidth*0.45, 4, "подпись", "", 0, "C", false, 0, "")
+		document.SetXY(column.x+columnWidth*0.5, lineY+0.3)
+		document.CellFormat(columnWidth*0.5, 4, "расшифровка подписи", "", 0, "C", false, 0, "")
+
+		document.SetFont("invoice", "", 8)
+		document.SetTextColor(13, 27, 43)
+		document.SetXY(column.x, lineY+8)
+		document.CellFormat(12, 5, "М.П.", "", 0, "L", false, 0, "")
+	}
+}
+
 func readInvoiceFont(path string) ([]byte, error) {
 	// #nosec G304 -- invoiceFontPath restricts paths to trusted system font directories.
 	data, err := os.ReadFile(path)
@@ -539,6 +821,10 @@ func invoiceLongDate(invoice Invoice) string {
 	if date.IsZero() {
 		return valueOrDash(invoice.IssuedDate)
 	}
+	return russianLongDate(date)
+}
+
+func russianLongDate(date time.Time) string {
 	months := [...]string{
 		"",
 		"января",
@@ -1,6 +1,7 @@
 package profile
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"net/http"
@@ -51,6 +52,92 @@ func TestBuildInvoicePDF(t *testing.T) {
 	}
 }
 
+func TestBuildActPDF(t *testing.T) {
+	document, err := BuildActPDF(Act{
+		Number: "REUP-A-2026-000001", Date: time.Date(2026, time.August, 19, 0, 0, 0, 0, time.UTC),
+		PeriodStart: time.Date(2026, time.July, 20, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2026, time.August, 19, 0, 0, 0, 0, time.UTC),
+		Description: "Предоставление доступа к сервису REUP.goals, тариф Founder", InvoiceNumber: "REUP-2026-000001",
+		Amount: 2990, Currency: "RUB", TaxLabel: "Без НДС",
+	}, SellerProfile{
+		FullName: "ООО РЕАП", INN: "5262392668", KPP: "526201001", RegistrationNumber: "1235200026995",
+		LegalAddress: "603000, Нижегородская область, город Нижний Новгород", DirectorName: "Михасов Никита Игоревич",
+		TaxLabel: "Без НДС",
+	}, BillingOrganization{
+		FullName: "ООО Покупатель", INN: "7701234567", LegalAddress: "125009, город Москва", ContactPerson: "Иван",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(document, []byte("%PDF-")) || !bytes.HasSuffix(document, []byte("%%EOF\n")) {
+		t.Fatal("expected a complete PDF file")
+	}
+}
+
+func TestBuildCreditNotePDF(t *testing.T) {
+	periodStart := time.Date(2026, time.August, 5, 0, 0, 0, 0, time.UTC)
+	periodEnd := time.Date(2026, time.August, 19, 0, 0, 0, 0, time.UTC)
+	document, err := BuildCreditNotePDF(CreditNote{
+		Number: "REUP-K-2026-000001", Date: time.Date(2026, time.August, 5, 0, 0, 0, 0, time.UTC),
+		PaidAt: time.Date(2026, time.July, 20, 0, 0, 0, 0, time.UTC), PeriodStart: &periodStart, PeriodEnd: &periodEnd,
+		Description: "Предоставление доступа к сервису REUP.goals, тариф Founder", InvoiceNumber: "REUP-2026-000001",
+		Reason: "Двойная оплата", Amount: 1495, Currency: "RUB", TaxLabel: "Без НДС",
+	}, SellerProfile{
+		FullName: "ООО РЕАП", INN: "5262392668", KPP: "526201001", RegistrationNumber: "1235200026995",
+		LegalAddress: "603000, Нижегородская область, город Нижний Новгород", DirectorName: "Михасов Никита Игоревич",
+		TaxLabel: "Без НДС",
+	}, BillingOrganization{FullName: "Физическое лицо", AccountingEmail: "owner@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(document, []byte("%PDF-")) || !bytes.HasSuffix(document, []byte("%%EOF\n")) {
+		t.Fatal("expected a complete PDF file")
+	}
+	if got := creditNoteNumber(2026, 7); got != "REUP-K-2026-000007" {
+		t.Fatalf("creditNoteNumber = %q", got)
+	}
+}
+
+func TestActPeriodEndsOnLastDayOfService(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip("time zone data is not available")
+	}
+	start := time.Date(2026, time.July, 19, 22, 30, 0, 0, time.UTC)
+	first, last := actPeriod(start, start.AddDate(0, 1, 0), moscow)
+	if first.Format("02.01.2006") != "20.07.2026" || last.Format("02.01.2006") != "19.08.2026" {
+		t.Fatalf("actPeriod = %s - %s", first.Format("02.01.2006"), last.Format("02.01.2006"))
+	}
+	if got := actNumber(2026, 42); got != "REUP-A-2026-000042" {
+		t.Fatalf("actNumber = %q", got)
+	}
+}
+
+func TestBuildDocumentsArchiveFilesByKind(t *testing.T) {
+	content, err := buildDocumentsArchive([]archivedDocument{
+		{Kind: "invoice", FileName: "invoice-REUP-2026-000001.pdf", Content: []byte("invoice")},
+		{Kind: "act", FileName: "act-REUP-A-2026-000001.pdf", Content: []byte("act")},
+		{Kind: "act", FileName: "../act-REUP-A-2026-000001.pdf", Content: []byte("again")},
+		{Kind: "credit_note", FileName: "credit-note-REUP-K-2026-000001.pdf", Content: []byte("refund")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	want := "Счета/invoice-REUP-2026-000001.pdf,Акты/act-REUP-A-2026-000001.pdf,Акты/act-REUP-A-2026-000001-2.pdf," +
+		"Кредит-ноты/credit-note-REUP-K-2026-000001.pdf"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("archive files = %v", names)
+	}
+}
+
 func TestInvoicePresentationHelpers(t *testing.T) {
 	invoice := Invoice{
 		IssuedAt:   time.Date(2026, time.July, 28, 12, 0, 0, 0, time.UTC),
@@ -70,6 +157,24 @@ func TestInvoicePresentationHelpers(t *testing.T) {
 	}
 }
 
+func TestInvoiceDueAtStopsAtPlanChangePeriodEnd(t *testing.T) {
+	issuedAt := time.Date(2026, time.July, 28, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		dueBy time.Time
+		want  time.Time
+	}{
+		{name: "no limit", want: issuedAt.AddDate(0, 0, 5)},
+		{name: "period ends first", dueBy: issuedAt.AddDate(0, 0, 2), want: issuedAt.AddDate(0, 0, 2)},
+		{name: "period ends later", dueBy: issuedAt.AddDate(0, 0, 20), want: issuedAt.AddDate(0, 0, 5)},
+	}
+	for _, tc := range cases {
+		if got := invoiceDueAt(issuedAt, tc.dueBy); !got.Equal(tc.want) {
+			t.Fatalf("%s: due at %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
 func TestInvoiceFontPathRejectsPathsOutsideSystemFontDirectories(t *testing.T) {
 	t.Setenv("INVOICE_FONT_PATH", "/etc/passwd")
 	path, err := invoiceFontPath()
@@ -167,6 +272,7 @@ func TestInvoiceCreationIsDisabledInProduction(t *testing.T) {
 }
 
 func TestAIUsageResponseJSONContract(t *testing.T) {
+	memberID := 7
 	payload, err := json.Marshal(AIUsageResponse{
 		PlanCode: "team", PlanName: "Team", ResetAmount: 2990, Currency: "RUB",
 		CanManageSubscription: true,
@@ -174,6 +280,10 @@ func TestAIUsageResponseJSONContract(t *testing.T) {
 			WeeklyTokenLimit: 3_000_000, WeeklyTokensUsed: 1_260_000,
 			PurchasedTokenBalance: 25_000, RemainingTokens: 1_765_000,
 		},
+		Breakdown: []AIUsageBreakdown{{
+			UserID: &memberID, UserName: "Анна", Module: "executive_advisor",
+			ModuleGroup: billing.AIModuleAgentRuns, Calls: 4, Tokens: 180_000,
+		}},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -188,6 +298,7 @@ func TestAIUsageResponseJSONContract(t *testing.T) {
 		`"weekly_tokens_used":1260000`,
 		`"purchased_token_balance":25000`,
 		`"remaining_tokens":1765000`,
+		`"breakdown":[{"user_id":7,"user_name":"Анна","module":"executive_advisor","module_group":"agent_runs","calls":4,"tokens":180000}]`,
 	} {
 		if !strings.Contains(body, field) {
 			t.Fatalf("expected %s in %s", field, body)
@@ -9,18 +9,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 	_ "time/tzdata"
 
 	"github.com/lib/pq"
 
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/v2/billing"
 	"reup-goals-backend/internal/v2/workspaces"
 )
 
 var (
-	ErrMemberLimitReached = errors.New("workspace_member_limit_reached")
+	ErrMemberLimitReached = workspaces.ErrMemberLimitReached
 	ErrAlreadyMember      = errors.New("workspace_member_already_exists")
 	ErrInvalidMemberRole  = errors.New("invalid_member_role")
 	ErrInvalidDepartments = errors.New("invalid_invitation_departments")
@@ -132,15 +134,54 @@ func (s *Store) Overview(ctx context.Context, userID int, checkoutAvailable bool
 	}, nil
 }
 
+// AIUsageBreakdown sums the workspace's AI calls since the start of the
+// weekly window per member and module. A userID limits it to that member.
+func (s *Store) AIUsageBreakdown(ctx context.Context, workspaceID, userID int, since time.Time) ([]AIUsageBreakdown, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT entry.user_id, COALESCE(MAX(users.name), ''), entry.ai_module, COUNT(*),
+			COALESCE(SUM(COALESCE(
+				entry.token_usage_total,
+				COALESCE(entry.token_usage_input, 0) + COALESCE(entry.token_usage_output, 0)
+			)), 0)
+		FROM v2_ai_call_logs entry
+		LEFT JOIN users ON users.id=entry.user_id
+		WHERE entry.workspace_id=$1 AND entry.created_at >= $2 AND entry.status <> 'rejected'
+			AND ($3=0 OR entry.user_id=$3)
+		GROUP BY entry.user_id, entry.ai_module
+		ORDER BY entry.user_id NULLS LAST, 5 DESC, entry.ai_module
+	`, workspaceID, since, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]AIUsageBreakdown, 0)
+	for rows.Next() {
+		var item AIUsageBreakdown
+		var memberID sql.NullInt64
+		if err := rows.Scan(&memberID, &item.UserName, &item.Module, &item.Calls, &item.Tokens); err != nil {
+			return nil, err
+		}
+		if memberID.Valid {
+			id := int(memberID.Int64)
+			item.UserID = &id
+		}
+		item.ModuleGroup = billing.AIModuleGroup(item.Module)
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
 func (s *Store) Subscription(ctx context.Context, workspaceID, ownerUserID int, checkoutAvailable bool) (SubscriptionSummary, error) {
 	var result SubscriptionSummary
 	var periodEnd, nextRenewal, graceUntil, pendingStartsAt sql.NullTime
-	var pendingPlanCode, pendingPlanName, pendingPeriod sql.NullString
+	var pendingPlanCode, pendingPlanName, pendingPeriod, scheduledPlanCode sql.NullString
+	var scheduledMemberLimit sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
 		SELECT plan_name, plan_code, billing_period, status, amount, currency,
 			payment_method, payment_provider, current_period_end, next_payment_at,
 			grace_until, member_limit, pending_plan_code, pending_plan_name,
-			pending_billing_period, pending_period_start
+			pending_billing_period, pending_period_start, scheduled_plan_code,
+			scheduled_member_limit, COALESCE(price_version_id, 0)
 		FROM subscriptions
 		WHERE workspace_id=$1 OR (workspace_id IS NULL AND user_id=$2)
 		ORDER BY CASE WHEN workspace_id=$1 THEN 0 ELSE 1 END, updated_at DESC
@@ -150,6 +191,7 @@ func (s *Store) Subscription(ctx context.Context, workspaceID, ownerUserID int,
 		&result.Amount, &result.Currency, &result.PaymentMethod, &result.PaymentProvider,
 		&periodEnd, &nextRenewal, &graceUntil, &result.MemberLimit,
 		&pendingPlanCode, &pendingPlanName, &pendingPeriod, &pendingStartsAt,
+		&scheduledPlanCode, &scheduledMemberLimit, &result.PriceVersion,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		plan, _ := billing.PlanByCode(billing.PlanFounder)
@@ -178,6 +220,8 @@ func (s *Store) Subscription(ctx context.Context, workspaceID, ownerUserID int,
 	result.PendingPlanName = pendingPlanName.String
 	result.PendingPeriod = pendingPeriod.String
 	result.PendingStartsAt = nullableTime(pendingStartsAt)
+	result.ScheduledPlanCode = scheduledPlanCode.String
+	result.ScheduledMemberLimit = int(scheduledMemberLimit.Int64)
 	result.CheckoutAvailable = checkoutAvailable
 	result.DisplayStatus = subscriptionDisplayStatus(result.Status, result.PeriodEnd, result.GraceUntil)
 	now := time.Now().UTC()
@@ -187,7 +231,12 @@ func (s *Store) Subscription(ctx context.Context, workspaceID, ownerUserID int,
 	return result, nil
 }
 
-func (s *Store) CreateCheckoutOrder(ctx context.Context, workspaceID, userID int, request CheckoutRequest, plan billing.Plan, amount float64) (CheckoutOrder, error) {
+// CreateCheckoutOrder records a card order for amount. A discounted order
+// keeps the list price as its renewal amount, which the card subscription
+// created by the payment charges from the next period, and reserves a
+// redemption of its promo code; repeating the request reuses the order and
+// its reservation.
+func (s *Store) CreateCheckoutOrder(ctx context.Context, workspaceID, userID int, request CheckoutRequest, plan billing.Plan, amount float64, discount billing.Discount, provider string) (CheckoutOrder, error) {
 	if strings.TrimSpace(request.IdempotencyKey) == "" {
 		randomBytes := make([]byte, 16)
 		if _, err := rand.Read(randomBytes); err != nil {
@@ -203,14 +252,23 @@ func (s *Store) CreateCheckoutOrder(ctx context.Context, workspaceID, userID int
 			request.IdempotencyKey, workspaceID, userID, plan.Code, request.BillingPeriod, request.OrderKind,
 		)
 	}
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return CheckoutOrder{}, err
+	}
+	defer tx.Rollback()
 	var result CheckoutOrder
-	err := s.dbx.QueryRowContext(ctx, `
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO workspace_billing_orders (
 			workspace_id, created_by, order_kind, plan_code, billing_period,
-			quantity, amount, currency, status, provider, idempotency_key, metadata_json
+			quantity, amount, currency, status, provider, idempotency_key, metadata_json,
+			price_version_id, promo_code_id, discount_amount
 		) VALUES (
-			$1,$2,$3,$4,$5,$6,$7,$8,'waiting','cloudpayments',$9,
-			jsonb_build_object(
+			$1,$2,$3,$4,$5,$6,$7,$8,'waiting',$15,$9,
+			CASE WHEN $11::bigint > 0 THEN jsonb_build_object(
+				'promo_code', $13::text, 'renewal_amount', $14::numeric
+			) ELSE '{}'::jsonb END || jsonb_build_object(
 				'replace_cloudpayments_subscription_id', CASE WHEN $3='subscription' THEN COALESCE((
 					SELECT subscription.cloudpayments_subscription_id
 					FROM subscriptions subscription
@@ -221,20 +279,119 @@ func (s *Store) CreateCheckoutOrder(ctx context.Context, workspaceID, userID int
 						subscription.updated_at DESC LIMIT 1
 				), '') ELSE '' END,
 				'replacement_status', 'pending'
-			)
+			),
+			NULLIF($10, 0), NULLIF($11::bigint, 0), $12
 		)
 		ON CONFLICT (workspace_id, idempotency_key) WHERE idempotency_key <> ''
 		DO UPDATE SET updated_at=NOW()
 		WHERE workspace_billing_orders.status='waiting'
-		RETURNING id, order_kind, plan_code, billing_period, quantity, amount, currency
+		RETURNING id, order_kind, plan_code, billing_period, quantity, amount, currency, xmax=0
 	`, workspaceID, userID, request.OrderKind, plan.Code, request.BillingPeriod,
-		request.Quantity, amount, plan.Currency, request.IdempotencyKey).Scan(
+		request.Quantity, amount, plan.Currency, request.IdempotencyKey, plan.PriceVersion,
+		discount.PromoCodeID, discount.DiscountAmount, discount.Code, discount.RenewalAmount, provider).Scan(
+		&result.ID, &result.OrderKind, &result.PlanCode, &result.BillingPeriod,
+		&result.Quantity, &result.Amount, &result.Currency, &inserted,
+	)
+	if err != nil {
+		return CheckoutOrder{}, err
+	}
+	if inserted && discount.PromoCodeID > 0 {
+		if err := billing.ReservePromoCode(ctx, tx, discount.PromoCodeID); err != nil {
+			return CheckoutOrder{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return CheckoutOrder{}, err
+	}
+	return result, nil
+}
+
+// CreatePlanChangeOrder prepares the card payment for an immediate upgrade.
+// The widget charges the prorated amount now and starts a new recurrent
+// subscription at the renewal price from the period end; the old one is
+// cancelled once the payment is confirmed.
+func (s *Store) CreatePlanChangeOrder(ctx context.Context, workspaceID, userID int, change billing.PlanChange, idempotencyKey, provider string) (CheckoutOrder, error) {
+	if strings.TrimSpace(idempotencyKey) == "" {
+		randomBytes := make([]byte, 16)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return CheckoutOrder{}, err
+		}
+		idempotencyKey = fmt.Sprintf(
+			"plan-change:%d:%d:%s:%d:%s",
+			workspaceID, userID, change.PlanCode, change.Quantity, hex.EncodeToString(randomBytes),
+		)
+	} else {
+		idempotencyKey = normalizeInvoiceIdempotencyKey(
+			idempotencyKey, workspaceID, userID, change.PlanCode, change.BillingPeriod, billing.OrderPlanChange,
+		)
+	}
+	metadata, err := json.Marshal(planChangeMetadata(change))
+	if err != nil {
+		return CheckoutOrder{}, err
+	}
+	var result CheckoutOrder
+	err = s.dbx.QueryRowContext(ctx, `
+		INSERT INTO workspace_billing_orders (
+			workspace_id, created_by, order_kind, plan_code, billing_period,
+			quantity, amount, currency, status, provider, idempotency_key, metadata_json,
+			price_version_id
+		) VALUES (
+			$1,$2,$3,$4,$5,$6,$7,$8,'waiting',$12,$9,
+			$10::jsonb || jsonb_build_object(
+				'replace_cloudpayments_subscription_id', COALESCE((
+					SELECT subscription.cloudpayments_subscription_id
+					FROM subscriptions subscription
+					WHERE subscription.workspace_id=$1 OR (
+						subscription.workspace_id IS NULL AND subscription.user_id=$2
+					)
+					ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END,
+						subscription.updated_at DESC LIMIT 1
+				), ''),
+				'replacement_status', 'pending'
+			),
+			NULLIF($11, 0)
+		)
+		ON CONFLICT (workspace_id, idempotency_key) WHERE idempotency_key <> ''
+		DO UPDATE SET updated_at=NOW()
+		WHERE workspace_billing_orders.status='waiting'
+		RETURNING id, order_kind, plan_code, billing_period, quantity, amount, currency
+	`, workspaceID, userID, billing.OrderPlanChange, change.PlanCode, change.BillingPeriod,
+		change.Quantity, change.AmountDue, change.Currency, idempotencyKey, metadata, change.PriceVersion, provider).Scan(
 		&result.ID, &result.OrderKind, &result.PlanCode, &result.BillingPeriod,
 		&result.Quantity, &result.Amount, &result.Currency,
 	)
 	return result, err
 }
 
+// planChangeMetadata is what confirming a plan change order needs to apply
+// it: the period it was priced for and the price from the next period on.
+func planChangeMetadata(change billing.PlanChange) map[string]any {
+	return map[string]any{
+		"period_end":     change.PeriodEnd.UTC().Format(time.RFC3339Nano),
+		"renewal_amount": change.RenewalAmount,
+		"credit":         change.Credit,
+	}
+}
+
+// CloudPaymentsSubscriptionID returns the recurrent card subscription of the
+// workspace, if it pays by card.
+func (s *Store) CloudPaymentsSubscriptionID(ctx context.Context, workspaceID int) (string, error) {
+	var id string
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT COALESCE(subscription.cloudpayments_subscription_id, '')
+		FROM subscriptions subscription
+		JOIN workspaces workspace ON workspace.id=$1
+		WHERE (subscription.workspace_id=$1 OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id))
+			AND subscription.payment_method='card'
+		ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END, subscription.updated_at DESC
+		LIMIT 1
+	`, workspaceID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
 func (s *Store) UpdateAccount(ctx context.Context, userID int, name, avatarURL, companyRole string) (Account, error) {
 	var account Account
 	err := s.dbx.QueryRowContext(ctx, `
@@ -262,16 +419,7 @@ func (s *Store) UpdateWorkspace(ctx context.Context, workspaceID int, displayNam
 }
 
 func (s *Store) ReservedSeatCount(ctx context.Context, workspaceID int) (int, error) {
-	var result int
-	err := s.dbx.QueryRowContext(ctx, `
-		SELECT
-			(SELECT COUNT(*) FROM workspace_memberships
-			 WHERE workspace_id=$1 AND status='active')
-			+
-			(SELECT COUNT(*) FROM workspace_invitations
-			 WHERE workspace_id=$1 AND status='pending' AND expires_at>NOW())
-	`, workspaceID).Scan(&result)
-	return result, err
+	return workspaces.ReservedSeats(ctx, s.dbx, workspaceID, "")
 }
 
 func (s *Store) Settings(ctx context.Context, userID int) (Settings, error) {
@@ -452,23 +600,10 @@ func (s *Store) Invite(
 			return Member{}, "", &InvitationResendTooSoonError{RetryAfter: retryAfter}
 		}
 	}
-	if !pendingExists && memberLimit > 0 {
-		var seatsUsed int
-		if err := tx.QueryRowContext(ctx, `
-				SELECT
-					(SELECT COUNT(*) FROM workspace_memberships
-					 WHERE workspace_id=$1 AND status='active')
-					+
-					(SELECT COUNT(*) FROM workspace_invitations
-					 WHERE workspace_id=$1
-					   AND status='pending'
-					   AND expires_at>NOW())
-			`, workspaceID).Scan(&seatsUsed); err != nil {
+	if !pendingExists {
+		if err := workspaces.CheckSeatWithin(ctx, tx, workspaceID, email, memberLimit); err != nil {
 			return Member{}, "", err
 		}
-		if seatsUsed >= memberLimit {
-			return Member{}, "", ErrMemberLimitReached
-		}
 	}
 
 	var invitationID int64
@@ -522,10 +657,10 @@ func (s *Store) AcceptInvitation(ctx context.Context, userID int, token string)
 	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID); err != nil {
 		return err
 	}
-	var role string
+	var role, email string
 	var departmentIDs []int
 	err = tx.QueryRowContext(ctx, `
-		SELECT invitation.role, invitation.department_ids
+		SELECT invitation.role, invitation.department_ids, invitation.email
 		FROM workspace_invitations invitation
 		JOIN users ON users.id=$1
 		WHERE invitation.token_hash=$2
@@ -534,7 +669,7 @@ func (s *Store) AcceptInvitation(ctx context.Context, userID int, token string)
 			AND invitation.status='pending'
 			AND invitation.expires_at > NOW()
 		FOR UPDATE
-	`, userID, hash, workspaceID).Scan(&role, pq.Array(&departmentIDs))
+	`, userID, hash, workspaceID).Scan(&role, pq.Array(&departmentIDs), &email)
 	if err != nil {
 		return err
 	}
@@ -550,31 +685,10 @@ func (s *Store) AcceptInvitation(ctx context.Context, userID int, token string)
 	`, workspaceID, userID).Scan(&alreadyMember); err != nil {
 		return err
 	}
-	var memberLimit int
-	if err := tx.QueryRowContext(ctx, `
-		SELECT COALESCE((
-			SELECT subscription.member_limit
-			FROM subscriptions subscription
-			JOIN workspaces workspace ON workspace.id=$1
-			WHERE subscription.workspace_id=$1
-				OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id)
-			ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END, subscription.updated_at DESC
-			LIMIT 1
-		), 5)
-	`, workspaceID).Scan(&memberLimit); err != nil {
-		return err
-	}
-	if !alreadyMember && memberLimit > 0 {
-		var activeMembers int
-		if err := tx.QueryRowContext(ctx, `
-			SELECT COUNT(*) FROM workspace_memberships
-			WHERE workspace_id=$1 AND status='active'
-		`, workspaceID).Scan(&activeMembers); err != nil {
+	if !alreadyMember {
+		if err := workspaces.CheckSeat(ctx, tx, workspaceID, email); err != nil {
 			return err
 		}
-		if activeMembers >= memberLimit {
-			return ErrMemberLimitReached
-		}
 	}
 	if _, err := tx.ExecContext(ctx, `
 		UPDATE workspace_memberships SET is_default=FALSE, updated_at=NOW()
@@ -706,99 +820,110 @@ func dedupePositiveIDs(values []int) []int {
 	return result
 }
 
-func (s *Store) RemoveMember(ctx context.Context, workspaceID, actorUserID int, kind string, id int64) error {
+// RemoveMember removes a membership or cancels a pending invitation and
+// returns what was removed.
+func (s *Store) RemoveMember(ctx context.Context, workspaceID, actorUserID int, kind string, id int64) (Member, error) {
 	tx, err := s.dbx.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return Member{}, err
 	}
 	defer tx.Rollback()
-	var result sql.Result
+	removed := Member{ID: id, Kind: kind}
 	switch kind {
 	case "membership":
 		var userID int
 		if err := tx.QueryRowContext(ctx, `
-			SELECT user_id FROM workspace_memberships
-			WHERE id=$1 AND workspace_id=$2 AND role <> 'owner' AND user_id <> $3
-			FOR UPDATE
-		`, id, workspaceID, actorUserID).Scan(&userID); err != nil {
-			return err
+			SELECT membership.user_id, users.email, membership.role
+			FROM workspace_memberships membership
+			JOIN users ON users.id=membership.user_id
+			WHERE membership.id=$1 AND membership.workspace_id=$2
+				AND membership.role <> 'owner' AND membership.user_id <> $3
+			FOR UPDATE OF membership
+		`, id, workspaceID, actorUserID).Scan(&userID, &removed.Email, &removed.Role); err != nil {
+			return Member{}, err
 		}
+		removed.UserID = &userID
 		if _, err := tx.ExecContext(ctx, `
 			DELETE FROM v2_department_members WHERE workspace_id=$1 AND user_id=$2
 		`, workspaceID, userID); err != nil {
-			return err
+			return Member{}, err
 		}
 		if _, err := tx.ExecContext(ctx, `
 			UPDATE v2_departments SET manager_user_id=NULL, updated_at=NOW()
 			WHERE workspace_id=$1 AND manager_user_id=$2
 		`, workspaceID, userID); err != nil {
-			return err
+			return Member{}, err
 		}
 		if _, err := tx.ExecContext(ctx, `
 			UPDATE v2_tasks SET owner_user_id=NULL, updated_at=NOW()
 			WHERE workspace_id=$1 AND owner_user_id=$2
 		`, workspaceID, userID); err != nil {
-			return err
+			return Member{}, err
 		}
-		result, err = tx.ExecContext(ctx, `
+		result, err := tx.ExecContext(ctx, `
 			DELETE FROM workspace_memberships
 			WHERE id=$1 AND workspace_id=$2 AND role <> 'owner'
 		`, id, workspaceID)
+		if err != nil {
+			return Member{}, err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return Member{}, err
+		} else if count == 0 {
+			return Member{}, sql.ErrNoRows
+		}
 	case "invitation":
-		result, err = tx.ExecContext(ctx, `
+		if err := tx.QueryRowContext(ctx, `
 			UPDATE workspace_invitations
 			SET status='cancelled', cancelled_at=NOW(), updated_at=NOW()
 			WHERE id=$1 AND workspace_id=$2 AND status='pending'
-		`, id, workspaceID)
+			RETURNING email, role
+		`, id, workspaceID).Scan(&removed.Email, &removed.Role); err != nil {
+			return Member{}, err
+		}
 	default:
-		return sql.ErrNoRows
-	}
-	if err != nil {
-		return err
-	}
-	count, err := result.RowsAffected()
-	if err != nil {
-		return err
+		return Member{}, sql.ErrNoRows
 	}
-	if count == 0 {
-		return sql.ErrNoRows
-	}
-	return tx.Commit()
+	return removed, tx.Commit()
 }
 
+// UpdateMemberRole changes a member's role and also returns the role they
+// had before.
 func (s *Store) UpdateMemberRole(
 	ctx context.Context,
 	workspaceID, actorUserID int,
 	membershipID int64,
 	role string,
-) (Member, error) {
+) (Member, string, error) {
 	if role != roleAdmin && role != roleMember {
-		return Member{}, ErrInvalidMemberRole
+		return Member{}, "", ErrInvalidMemberRole
 	}
 	var item Member
 	var userID int
+	var previousRole string
 	err := s.dbx.QueryRowContext(ctx, `
 		UPDATE workspace_memberships membership
 		SET role=$1, updated_at=NOW()
-		FROM users
+		FROM users, workspace_memberships previous
 		WHERE membership.id=$2 AND membership.workspace_id=$3
 			AND membership.role <> 'owner' AND membership.user_id <> $4
 			AND users.id=membership.user_id
+			AND previous.id=membership.id
 			RETURNING membership.id, users.id, users.name, users.email, users.avatar_url,
-				users.company_role, membership.role, membership.created_at
+				users.company_role, membership.role, membership.created_at, previous.role
 	`, role, membershipID, workspaceID, actorUserID).Scan(
 		&item.ID, &userID, &item.Name, &item.Email, &item.AvatarURL,
-		&item.CompanyRole, &item.Role, &item.CreatedAt,
+		&item.CompanyRole, &item.Role, &item.CreatedAt, &previousRole,
 	)
 	if err != nil {
-		return Member{}, err
+		return Member{}, "", err
 	}
 	item.Kind = "membership"
 	item.UserID = &userID
 	item.Status = invitationAccepted
 	item.CanBeRemoved = true
 	item.CanChangeRole = true
-	return item, nil
+	return item, previousRole, nil
 }
 
 func (s *Store) BillingOrganization(ctx context.Context, workspaceID int) (*BillingOrganization, error) {
@@ -859,26 +984,7 @@ func (s *Store) CreateInvoice(
 	workspaceID, userID int,
 	request InvoiceRequest,
 ) (Invoice, error) {
-	organization, err := s.BillingOrganization(ctx, workspaceID)
-	if err != nil {
-		return Invoice{}, err
-	}
-	if organization == nil {
-		return Invoice{}, errors.New("billing_organization_required")
-	}
-	seller, err := s.SellerProfile(ctx)
-	if err != nil {
-		return Invoice{}, err
-	}
-	buyerSnapshot, err := json.Marshal(organization)
-	if err != nil {
-		return Invoice{}, err
-	}
-	sellerSnapshot, err := json.Marshal(seller)
-	if err != nil {
-		return Invoice{}, err
-	}
-	plan, err := billing.PlanByCode(request.PlanCode)
+	plan, err := billing.WorkspacePlan(ctx, s.dbx, workspaceID, request.PlanCode)
 	if err != nil {
 		return Invoice{}, err
 	}
@@ -902,6 +1008,17 @@ func (s *Store) CreateInvoice(
 	} else if request.OrderKind != billing.OrderSubscription {
 		return Invoice{}, errors.New("billing_order_kind_invalid")
 	}
+	var discount billing.Discount
+	if strings.TrimSpace(request.PromoCode) != "" {
+		discount, err = billing.QuotePromoCode(
+			ctx, s.dbx, workspaceID, request.PromoCode, request.OrderKind, plan, request.BillingPeriod, amount,
+		)
+		if err != nil {
+			return Invoice{}, err
+		}
+		amount = discount.Amount
+		description += fmt.Sprintf(", с учётом скидки по промокоду %s", discount.Code)
+	}
 	request.IdempotencyKey = normalizeInvoiceIdempotencyKey(
 		request.IdempotencyKey,
 		workspaceID,
@@ -910,6 +1027,94 @@ func (s *Store) CreateInvoice(
 		request.BillingPeriod,
 		request.OrderKind,
 	)
+	return s.issueInvoice(ctx, workspaceID, userID, invoiceDraft{
+		OrderKind: request.OrderKind, Plan: plan, BillingPeriod: request.BillingPeriod,
+		Quantity: 1, Amount: amount, Description: description, IdempotencyKey: request.IdempotencyKey,
+		Discount: discount,
+	})
+}
+
+// CreatePlanChangeInvoice bills the prorated difference of an immediate
+// upgrade. The change is applied once the invoice is paid, as long as the
+// period it was priced for has not ended.
+func (s *Store) CreatePlanChangeInvoice(
+	ctx context.Context,
+	workspaceID, userID int,
+	change billing.PlanChange,
+	idempotencyKey string,
+) (Invoice, error) {
+	plan, err := billing.PlanAtVersion(change.PlanCode, change.PriceVersion)
+	if err != nil {
+		return Invoice{}, err
+	}
+	_, location, err := s.workspaceBillingLocation(ctx, workspaceID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	description := fmt.Sprintf(
+		"Подписка REUP.goals, переход на тариф %s до %s",
+		plan.Name, change.PeriodEnd.In(location).Format("02.01.2006"),
+	)
+	if plan.PerSeatPricing {
+		description = fmt.Sprintf(
+			"Подписка REUP.goals, тариф %s на %d мест(а) до %s",
+			plan.Name, change.Quantity, change.PeriodEnd.In(location).Format("02.01.2006"),
+		)
+	}
+	return s.issueInvoice(ctx, workspaceID, userID, invoiceDraft{
+		OrderKind: billing.OrderPlanChange, Plan: plan, BillingPeriod: change.BillingPeriod,
+		Quantity: change.Quantity, Amount: change.AmountDue, Description: description,
+		IdempotencyKey: normalizeInvoiceIdempotencyKey(
+			idempotencyKey, workspaceID, userID, plan.Code, change.BillingPeriod, billing.OrderPlanChange,
+		),
+		Metadata: planChangeMetadata(change),
+		DueBy:    change.PeriodEnd,
+	})
+}
+
+// invoiceDraft is an invoice about to be issued. DueBy, when set, brings
+// the due date forward from the usual five days: a plan change invoice
+// cannot be paid once the period it was priced for has ended.
+type invoiceDraft struct {
+	OrderKind      string
+	Plan           billing.Plan
+	BillingPeriod  string
+	Quantity       int
+	Amount         float64
+	Description    string
+	IdempotencyKey string
+	Metadata       map[string]any
+	Discount       billing.Discount
+	DueBy          time.Time
+}
+
+func (s *Store) issueInvoice(ctx context.Context, workspaceID, userID int, draft invoiceDraft) (Invoice, error) {
+	organization, err := s.BillingOrganization(ctx, workspaceID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if organization == nil {
+		return Invoice{}, errors.New("billing_organization_required")
+	}
+	seller, err := s.SellerProfile(ctx)
+	if err != nil {
+		return Invoice{}, err
+	}
+	buyerSnapshot, err := json.Marshal(organization)
+	if err != nil {
+		return Invoice{}, err
+	}
+	sellerSnapshot, err := json.Marshal(seller)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if draft.Metadata == nil {
+		draft.Metadata = map[string]any{}
+	}
+	metadata, err := json.Marshal(draft.Metadata)
+	if err != nil {
+		return Invoice{}, err
+	}
 	timezone, location, err := s.workspaceBillingLocation(ctx, workspaceID)
 	if err != nil {
 		return Invoice{}, err
@@ -923,7 +1128,7 @@ func (s *Store) CreateInvoice(
 	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID); err != nil {
 		return Invoice{}, err
 	}
-	existing, err := invoiceByIdempotencyKey(ctx, tx, workspaceID, request.IdempotencyKey)
+	existing, err := invoiceByIdempotencyKey(ctx, tx, workspaceID, draft.IdempotencyKey)
 	if err == nil {
 		if err := tx.Commit(); err != nil {
 			return Invoice{}, err
@@ -937,23 +1142,31 @@ func (s *Store) CreateInvoice(
 	if err := tx.QueryRowContext(ctx, `
 		INSERT INTO workspace_billing_orders (
 			workspace_id, created_by, order_kind, plan_code, billing_period,
-			amount, currency, status, provider, idempotency_key
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,'waiting','manual',$8)
+			quantity, amount, currency, status, provider, idempotency_key, metadata_json,
+			price_version_id, promo_code_id, discount_amount
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,'waiting','manual',$9,$10,NULLIF($11, 0),NULLIF($12::bigint, 0),$13)
 		RETURNING id
-	`, workspaceID, userID, request.OrderKind, plan.Code, request.BillingPeriod, amount, plan.Currency, request.IdempotencyKey).Scan(&orderID); err != nil {
+	`, workspaceID, userID, draft.OrderKind, draft.Plan.Code, draft.BillingPeriod, max(1, draft.Quantity),
+		draft.Amount, draft.Plan.Currency, draft.IdempotencyKey, metadata, draft.Plan.PriceVersion,
+		draft.Discount.PromoCodeID, draft.Discount.DiscountAmount).Scan(&orderID); err != nil {
 		return Invoice{}, err
 	}
+	if draft.Discount.PromoCodeID > 0 {
+		if err := billing.ReservePromoCode(ctx, tx, draft.Discount.PromoCodeID); err != nil {
+			return Invoice{}, err
+		}
+	}
 	var id int64
 	if err := tx.QueryRowContext(ctx, `SELECT nextval('workspace_billing_invoices_id_seq')`).Scan(&id); err != nil {
 		return Invoice{}, err
 	}
 	now := time.Now().In(location)
-	dueAt := now.AddDate(0, 0, 5)
+	dueAt := invoiceDueAt(now, draft.DueBy)
 	number := fmt.Sprintf("REUP-%d-%06d", now.Year(), id)
 	invoice := Invoice{
-		ID: id, OrderID: &orderID, Number: number, OrderKind: request.OrderKind,
-		PlanCode: plan.Code, BillingPeriod: request.BillingPeriod, Description: description,
-		TaxLabel: seller.TaxLabel, Amount: amount, Currency: plan.Currency, Status: "waiting",
+		ID: id, OrderID: &orderID, Number: number, OrderKind: draft.OrderKind,
+		PlanCode: draft.Plan.Code, BillingPeriod: draft.BillingPeriod, Description: draft.Description,
+		TaxLabel: seller.TaxLabel, Amount: draft.Amount, Currency: draft.Plan.Currency, Status: "waiting",
 		RecipientEmail: organization.AccountingEmail, IssuedAt: now, DueAt: dueAt,
 		IssuedDate: now.Format("02.01.2006"), DueDate: dueAt.Format("02.01.2006"),
 		Timezone: timezone,
@@ -966,9 +1179,9 @@ func (s *Store) CreateInvoice(
 		) VALUES (
 			$1,$2,$3,$4,$5,'waiting',$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17
 		)
-	`, id, workspaceID, number, amount, plan.Currency, buyerSnapshot, organization.AccountingEmail,
-		userID, now, dueAt, orderID, request.OrderKind, plan.Code, request.BillingPeriod,
-		description, seller.TaxLabel, sellerSnapshot); err != nil {
+	`, id, workspaceID, number, draft.Amount, draft.Plan.Currency, buyerSnapshot, organization.AccountingEmail,
+		userID, now, dueAt, orderID, draft.OrderKind, draft.Plan.Code, draft.BillingPeriod,
+		draft.Description, seller.TaxLabel, sellerSnapshot); err != nil {
 		return Invoice{}, err
 	}
 	pdf, err := BuildInvoicePDF(invoice, seller, *organization)
@@ -991,6 +1204,14 @@ func (s *Store) CreateInvoice(
 	return invoice, nil
 }
 
+func invoiceDueAt(issuedAt, dueBy time.Time) time.Time {
+	dueAt := issuedAt.AddDate(0, 0, 5)
+	if !dueBy.IsZero() && dueBy.Before(dueAt) {
+		return dueBy.In(issuedAt.Location())
+	}
+	return dueAt
+}
+
 func (s *Store) Invoices(ctx context.Context, workspaceID int) ([]Invoice, error) {
 	rows, err := s.dbx.QueryContext(ctx, `
 		SELECT invoice.id, invoice.order_id, invoice.number, invoice.order_kind,
@@ -1044,7 +1265,7 @@ func (s *Store) Invoices(ctx context.Context, workspaceID int) ([]Invoice, error
 
 func (s *Store) Documents(ctx context.Context, workspaceID int) ([]BillingDocument, error) {
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, invoice_id, kind, title, file_name, mime_type, period_start, period_end, created_at
+		SELECT id, invoice_id, kind, number, title, file_name, mime_type, period_start, period_end, created_at
 		FROM workspace_billing_documents
 		WHERE workspace_id=$1
 		ORDER BY created_at DESC, id DESC
@@ -1058,7 +1279,7 @@ func (s *Store) Documents(ctx context.Context, workspaceID int) ([]BillingDocume
 		var item BillingDocument
 		var invoiceID sql.NullInt64
 		var periodStart, periodEnd sql.NullTime
-		if err := rows.Scan(&item.ID, &invoiceID, &item.Kind, &item.Title, &item.FileName,
+		if err := rows.Scan(&item.ID, &invoiceID, &item.Kind, &item.Number, &item.Title, &item.FileName,
 			&item.MimeType, &periodStart, &periodEnd, &item.CreatedAt); err != nil {
 			return nil, err
 		}
@@ -1233,7 +1454,7 @@ func (s *Store) Payments(ctx context.Context, workspaceID int) ([]Payment, error
 
 			UNION ALL
 
-			SELECT -(event.id::BIGINT), NULL::BIGINT, 'cloudpayments', 'card',
+			SELECT -(event.id::BIGINT), NULL::BIGINT, event.provider, 'card',
 				COALESCE(event.amount, 0), COALESCE(NULLIF(event.currency, ''), 'RUB'),
 				CASE event.event_type
 					WHEN 'pay' THEN 'paid'
@@ -1332,6 +1553,12 @@ func (s *Store) DeleteWorkspace(ctx context.Context, workspaceID, userID int) er
 	if deletedCount == 0 || resetUserCount == 0 {
 		return sql.ErrNoRows
 	}
+	// Destroying its key-encryption key shreds the content in backups too.
+	// The workspace is already gone, so a failure is left to the rekeyer,
+	// which destroys the keys of every workspace in workspace_deletions.
+	if err := encryption.DestroyWorkspace(ctx, workspaceID); err != nil {
+		log.Printf("[WARN] workspace %d key destruction failed: %v", workspaceID, err)
+	}
 	return nil
 }
 
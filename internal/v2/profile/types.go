@@ -54,31 +54,36 @@ type Capabilities struct {
 }
 
 type SubscriptionSummary struct {
-	Plan              string               `json:"plan"`
-	PlanCode          string               `json:"plan_code"`
-	BillingPeriod     string               `json:"billing_period"`
-	Status            string               `json:"status"`
-	Amount            float64              `json:"amount"`
-	AnnualAmount      float64              `json:"annual_amount"`
-	ResetAmount       float64              `json:"reset_amount"`
-	Currency          string               `json:"currency"`
-	PaymentMethod     string               `json:"payment_method"`
-	PaymentProvider   string               `json:"payment_provider"`
-	PeriodEnd         *time.Time           `json:"period_end"`
-	NextRenewal       *time.Time           `json:"next_renewal"`
-	GraceUntil        *time.Time           `json:"grace_until"`
-	PendingPlanCode   string               `json:"pending_plan_code,omitempty"`
-	PendingPlanName   string               `json:"pending_plan_name,omitempty"`
-	PendingPeriod     string               `json:"pending_billing_period,omitempty"`
-	PendingStartsAt   *time.Time           `json:"pending_starts_at,omitempty"`
-	Access            bool                 `json:"access"`
-	AIChatEnabled     bool                 `json:"ai_chat_enabled"`
-	DisplayStatus     string               `json:"display_status"`
-	CheckoutAvailable bool                 `json:"checkout_available"`
-	MemberLimit       int                  `json:"member_limit"`
-	SeatsUsed         int                  `json:"seats_used"`
-	AIUsage           billing.QuotaSummary `json:"ai_usage"`
-	AvailablePlans    []billing.Plan       `json:"available_plans"`
+	Plan            string     `json:"plan"`
+	PlanCode        string     `json:"plan_code"`
+	BillingPeriod   string     `json:"billing_period"`
+	Status          string     `json:"status"`
+	Amount          float64    `json:"amount"`
+	AnnualAmount    float64    `json:"annual_amount"`
+	ResetAmount     float64    `json:"reset_amount"`
+	Currency        string     `json:"currency"`
+	PriceVersion    int64      `json:"price_version,omitempty"`
+	PaymentMethod   string     `json:"payment_method"`
+	PaymentProvider string     `json:"payment_provider"`
+	PeriodEnd       *time.Time `json:"period_end"`
+	NextRenewal     *time.Time `json:"next_renewal"`
+	GraceUntil      *time.Time `json:"grace_until"`
+	PendingPlanCode string     `json:"pending_plan_code,omitempty"`
+	PendingPlanName string     `json:"pending_plan_name,omitempty"`
+	PendingPeriod   string     `json:"pending_billing_period,omitempty"`
+	PendingStartsAt *time.Time `json:"pending_starts_at,omitempty"`
+	// ScheduledPlanCode is a cheaper plan or seat count taken up by the next
+	// renewal; unlike a pending plan it is not paid for yet.
+	ScheduledPlanCode    string               `json:"scheduled_plan_code,omitempty"`
+	ScheduledMemberLimit int                  `json:"scheduled_member_limit,omitempty"`
+	Access               bool                 `json:"access"`
+	AIChatEnabled        bool                 `json:"ai_chat_enabled"`
+	DisplayStatus        string               `json:"display_status"`
+	CheckoutAvailable    bool                 `json:"checkout_available"`
+	MemberLimit          int                  `json:"member_limit"`
+	SeatsUsed            int                  `json:"seats_used"`
+	AIUsage              billing.QuotaSummary `json:"ai_usage"`
+	AvailablePlans       []billing.Plan       `json:"available_plans"`
 }
 
 type AIUsageResponse struct {
@@ -89,6 +94,19 @@ type AIUsageResponse struct {
 	CanManageSubscription bool                 `json:"can_manage_subscription"`
 	AIChatEnabled         bool                 `json:"ai_chat_enabled"`
 	AIUsage               billing.QuotaSummary `json:"ai_usage"`
+	Breakdown             []AIUsageBreakdown   `json:"breakdown"`
+	Allocations           []billing.Allocation `json:"allocations,omitempty"`
+}
+
+// AIUsageBreakdown is one member's AI calls of one module in the current
+// weekly window. Background calls made on nobody's behalf have no UserID.
+type AIUsageBreakdown struct {
+	UserID      *int   `json:"user_id,omitempty"`
+	UserName    string `json:"user_name,omitempty"`
+	Module      string `json:"module"`
+	ModuleGroup string `json:"module_group,omitempty"`
+	Calls       int    `json:"calls"`
+	Tokens      int    `json:"tokens"`
 }
 
 type Member struct {
@@ -161,6 +179,34 @@ type Invoice struct {
 	DocumentID     *int64     `json:"document_id,omitempty"`
 }
 
+// Act is an act of services rendered for one paid subscription period.
+type Act struct {
+	Number        string    `json:"number"`
+	Date          time.Time `json:"date"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	Description   string    `json:"description"`
+	InvoiceNumber string    `json:"invoice_number,omitempty"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	TaxLabel      string    `json:"tax_label"`
+}
+
+// CreditNote corrects the amount paid for a service by a refund.
+type CreditNote struct {
+	Number        string     `json:"number"`
+	Date          time.Time  `json:"date"`
+	PaidAt        time.Time  `json:"paid_at"`
+	PeriodStart   *time.Time `json:"period_start,omitempty"`
+	PeriodEnd     *time.Time `json:"period_end,omitempty"`
+	Description   string     `json:"description"`
+	InvoiceNumber string     `json:"invoice_number,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	TaxLabel      string     `json:"tax_label"`
+}
+
 type SellerProfile struct {
 	FullName             string `json:"full_name"`
 	INN                  string `json:"inn"`
@@ -180,6 +226,13 @@ type InvoiceRequest struct {
 	PlanCode       string `json:"plan_code"`
 	BillingPeriod  string `json:"billing_period"`
 	OrderKind      string `json:"order_kind"`
+	PromoCode      string `json:"promo_code,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type PlanChangeRequest struct {
+	PlanCode       string `json:"plan_code"`
+	Quantity       int    `json:"quantity"`
 	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
@@ -188,6 +241,7 @@ type CheckoutRequest struct {
 	BillingPeriod  string `json:"billing_period"`
 	OrderKind      string `json:"order_kind"`
 	Quantity       int    `json:"quantity"`
+	PromoCode      string `json:"promo_code,omitempty"`
 	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
@@ -205,6 +259,7 @@ type BillingDocument struct {
 	ID          int64      `json:"id"`
 	InvoiceID   *int64     `json:"invoice_id,omitempty"`
 	Kind        string     `json:"kind"`
+	Number      string     `json:"number,omitempty"`
 	Title       string     `json:"title"`
 	FileName    string     `json:"file_name"`
 	MimeType    string     `json:"mime_type"`
@@ -0,0 +1,352 @@
+package scim
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"reup-goals-backend/internal/auth"
+	"reup-goals-backend/internal/security"
+)
+
+const maxBodyBytes = 1 << 20
+
+// Handler serves SCIM 2.0 under /scim/v2 for the workspace a bearer
+// workspace API token belongs to. Reads need scim:read, writes scim:write.
+type Handler struct {
+	dbx   *sql.DB
+	store *Store
+}
+
+func NewHandler(dbx *sql.DB) *Handler {
+	return &Handler{dbx: dbx, store: NewStore(dbx)}
+}
+
+type principal struct {
+	userID      int
+	workspaceID int
+}
+
+func (h *Handler) SCIM(w http.ResponseWriter, r *http.Request) {
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/scim/v2"), "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "ServiceProviderConfig":
+		h.serviceProviderConfig(w, r)
+	case len(segments) == 1 && segments[0] == "ResourceTypes":
+		h.resourceTypes(w, r)
+	case segments[0] == "Users" && len(segments) <= 2:
+		h.users(w, r, caller, segments[1:])
+	case segments[0] == "Groups" && len(segments) <= 2:
+		h.groups(w, r, caller, segments[1:])
+	default:
+		writeError(w, ErrNotFound)
+	}
+}
+
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || !auth.IsAPIToken(token) {
+		writeError(w, &Error{Status: http.StatusUnauthorized, Detail: "unauthorized"})
+		return principal{}, false
+	}
+	result, err := auth.AuthenticateAPIToken(r.Context(), h.dbx, token, security.ClientIP(r))
+	if err != nil {
+		writeError(w, &Error{Status: http.StatusUnauthorized, Detail: "unauthorized"})
+		return principal{}, false
+	}
+	if result.WorkspaceID == nil {
+		writeError(w, &Error{Status: http.StatusForbidden, Detail: "workspace_token_required"})
+		return principal{}, false
+	}
+	required := "scim:write"
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		required = "scim:read"
+	}
+	if !auth.APIScopeAllows(result.Scopes, required) {
+		w.Header().Set("X-Required-Scope", required)
+		writeError(w, &Error{Status: http.StatusForbidden, Detail: "insufficient_scope"})
+		return principal{}, false
+	}
+	return principal{userID: result.UserID, workspaceID: *result.WorkspaceID}, true
+}
+
+func (h *Handler) users(w http.ResponseWriter, r *http.Request, caller principal, rest []string) {
+	ctx := r.Context()
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			filter, err := ParseFilter(r.URL.Query().Get("filter"), "username", "externalid", "emails.value")
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			startIndex, count := pageParams(r)
+			users, total, err := h.store.Users(ctx, caller.workspaceID, filter, startIndex, count)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			resources := make([]any, 0, len(users))
+			for _, user := range users {
+				resources = append(resources, user)
+			}
+			writeList(w, resources, total, startIndex)
+		case http.MethodPost:
+			var input UserInput
+			if !decodeBody(w, r, &input) {
+				return
+			}
+			user, err := h.store.CreateUser(ctx, caller.workspaceID, input)
+			writeResource(w, err, http.StatusCreated, user, user.Meta.Location)
+		default:
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+		}
+		return
+	}
+
+	userID, err := strconv.Atoi(rest[0])
+	if err != nil || userID <= 0 {
+		writeError(w, ErrNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		user, err := h.store.User(ctx, caller.workspaceID, userID)
+		writeResource(w, err, http.StatusOK, user, "")
+	case http.MethodPut:
+		var input UserInput
+		if !decodeBody(w, r, &input) {
+			return
+		}
+		user, err := h.store.ReplaceUser(ctx, caller.workspaceID, caller.userID, userID, input)
+		writeResource(w, err, http.StatusOK, user, "")
+	case http.MethodPatch:
+		var patch PatchRequest
+		if !decodeBody(w, r, &patch) {
+			return
+		}
+		current, err := h.store.User(ctx, caller.workspaceID, userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		input, err := ApplyUserPatch(current.CurrentUserInput(), patch.Operations)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		user, err := h.store.ReplaceUser(ctx, caller.workspaceID, caller.userID, userID, input)
+		writeResource(w, err, http.StatusOK, user, "")
+	case http.MethodDelete:
+		current, err := h.store.User(ctx, caller.workspaceID, userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		input := current.CurrentUserInput()
+		inactive := false
+		input.Active = &inactive
+		if _, err := h.store.ReplaceUser(ctx, caller.workspaceID, caller.userID, userID, input); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+	}
+}
+
+func (h *Handler) groups(w http.ResponseWriter, r *http.Request, caller principal, rest []string) {
+	ctx := r.Context()
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			filter, err := ParseFilter(r.URL.Query().Get("filter"), "displayname", "externalid")
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			startIndex, count := pageParams(r)
+			groups, total, err := h.store.Groups(ctx, caller.workspaceID, filter, startIndex, count)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			resources := make([]any, 0, len(groups))
+			for _, group := range groups {
+				resources = append(resources, group)
+			}
+			writeList(w, resources, total, startIndex)
+		case http.MethodPost:
+			var input GroupInput
+			if !decodeBody(w, r, &input) {
+				return
+			}
+			group, err := h.store.CreateGroup(ctx, caller.workspaceID, caller.userID, input)
+			writeResource(w, err, http.StatusCreated, group, group.Meta.Location)
+		default:
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+		}
+		return
+	}
+
+	groupID, err := strconv.Atoi(rest[0])
+	if err != nil || groupID <= 0 {
+		writeError(w, ErrNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		group, err := h.store.Group(ctx, caller.workspaceID, groupID)
+		writeResource(w, err, http.StatusOK, group, "")
+	case http.MethodPut:
+		var input GroupInput
+		if !decodeBody(w, r, &input) {
+			return
+		}
+		group, err := h.store.ReplaceGroup(ctx, caller.workspaceID, groupID, input)
+		writeResource(w, err, http.StatusOK, group, "")
+	case http.MethodPatch:
+		var patch PatchRequest
+		if !decodeBody(w, r, &patch) {
+			return
+		}
+		current, err := h.store.Group(ctx, caller.workspaceID, groupID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		input, err := ApplyGroupPatch(current.CurrentGroupInput(), patch.Operations)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		group, err := h.store.ReplaceGroup(ctx, caller.workspaceID, groupID, input)
+		writeResource(w, err, http.StatusOK, group, "")
+	case http.MethodDelete:
+		if err := h.store.DeleteGroup(ctx, caller.workspaceID, groupID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+	}
+}
+
+func (h *Handler) serviceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+		return
+	}
+	supported := func(value bool) map[string]bool { return map[string]bool{"supported": value} }
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{SchemaServiceCfg},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": maxPageSize},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type": "oauthbearertoken", "name": "Workspace API token", "primary": true,
+			"description": "Bearer workspace token with the scim:read or scim:write scope",
+		}},
+	})
+}
+
+func (h *Handler) resourceTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Detail: "method_not_allowed"})
+		return
+	}
+	resources := []any{
+		map[string]any{
+			"schemas": []string{SchemaResourceType}, "id": "User", "name": "User",
+			"endpoint": "/Users", "schema": SchemaUser,
+		},
+		map[string]any{
+			"schemas": []string{SchemaResourceType}, "id": "Group", "name": "Group",
+			"endpoint": "/Groups", "schema": SchemaGroup,
+		},
+	}
+	writeList(w, resources, len(resources), 1)
+}
+
+// pageParams reads the 1-based startIndex and count, clamped to sane values.
+func pageParams(r *http.Request) (int, int) {
+	query := r.URL.Query()
+	startIndex, err := strconv.Atoi(query.Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil {
+		count = defaultPageSize
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxPageSize {
+		count = maxPageSize
+	}
+	return startIndex, count
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, target any) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil || len(body) > maxBodyBytes || json.Unmarshal(body, target) != nil {
+		writeError(w, ErrInvalidSyntax)
+		return false
+	}
+	return true
+}
+
+func writeList(w http.ResponseWriter, resources []any, total int, startIndex int) {
+	writeSCIM(w, http.StatusOK, ListResponse{
+		Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: startIndex,
+		ItemsPerPage: len(resources), Resources: resources,
+	})
+}
+
+func writeResource(w http.ResponseWriter, err error, status int, value any, location string) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	writeSCIM(w, status, value)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var scimError *Error
+	if !errors.As(err, &scimError) {
+		scimError = &Error{Status: http.StatusInternalServerError, Detail: "scim_request_failed"}
+	}
+	body := map[string]any{
+		"schemas": []string{SchemaError},
+		"status":  strconv.Itoa(scimError.Status),
+		"detail":  scimError.Detail,
+	}
+	if scimError.ScimType != "" {
+		body["scimType"] = scimError.ScimType
+	}
+	writeSCIM(w, scimError.Status, body)
+}
+
+func writeSCIM(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
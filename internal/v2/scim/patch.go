@@ -0,0 +1,256 @@
+package scim
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	filterPattern       = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9.]*)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+	memberFilterPattern = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+)
+
+// ParseFilter accepts an empty filter or one equality comparison; attribute
+// names are case-insensitive per RFC 7644 and returned lowercased.
+func ParseFilter(raw string, allowed ...string) (*Filter, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	match := filterPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return nil, ErrInvalidFilter
+	}
+	attribute := strings.ToLower(match[1])
+	for _, name := range allowed {
+		if attribute == name {
+			value := strings.ReplaceAll(strings.ReplaceAll(match[2], `\"`, `"`), `\\`, `\`)
+			return &Filter{Attribute: attribute, Value: value}, nil
+		}
+	}
+	return nil, ErrInvalidFilter
+}
+
+// ApplyUserPatch applies PATCH operations to the user's current writable
+// state. Email edits are accepted and ignored because userName, the email,
+// cannot change; unknown extension attributes are ignored too.
+func ApplyUserPatch(current UserInput, operations []PatchOperation) (UserInput, error) {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return UserInput{}, ErrInvalidSyntax
+		}
+		if operation.Path == "" {
+			values, ok := operation.Value.(map[string]any)
+			if !ok || op == "remove" {
+				return UserInput{}, ErrInvalidSyntax
+			}
+			for path, value := range values {
+				if err := applyUserAttribute(&current, op, path, value); err != nil {
+					return UserInput{}, err
+				}
+			}
+			continue
+		}
+		if err := applyUserAttribute(&current, op, operation.Path, operation.Value); err != nil {
+			return UserInput{}, err
+		}
+	}
+	return current, nil
+}
+
+func applyUserAttribute(user *UserInput, op string, path string, value any) error {
+	remove := op == "remove"
+	lower := strings.ToLower(path)
+	switch {
+	case lower == "active":
+		active, ok := boolValue(value)
+		if remove || !ok {
+			return ErrInvalidValue
+		}
+		user.Active = &active
+	case lower == "externalid":
+		return setString(&user.ExternalID, value, remove)
+	case lower == "username":
+		if remove {
+			return ErrInvalidValue
+		}
+		return setString(&user.UserName, value, false)
+	case lower == "displayname":
+		return setString(&user.DisplayName, value, remove)
+	case lower == "name.givenname":
+		return setString(&user.Name.GivenName, value, remove)
+	case lower == "name.familyname":
+		return setString(&user.Name.FamilyName, value, remove)
+	case lower == "name.formatted":
+		return setString(&user.Name.Formatted, value, remove)
+	case lower == "name":
+		if remove {
+			user.Name = Name{}
+			return nil
+		}
+		values, ok := value.(map[string]any)
+		if !ok {
+			return ErrInvalidValue
+		}
+		for key, item := range values {
+			if err := applyUserAttribute(user, op, "name."+key, item); err != nil {
+				return err
+			}
+		}
+	case lower == "roles":
+		if remove {
+			user.Roles = nil
+			return nil
+		}
+		roles, ok := multiValues(value)
+		if !ok {
+			return ErrInvalidValue
+		}
+		user.Roles = roles
+	case lower == "emails" || strings.HasPrefix(lower, "emails["):
+	case strings.HasPrefix(lower, "urn:"):
+	default:
+		return ErrInvalidPath
+	}
+	return nil
+}
+
+// ApplyGroupPatch applies PATCH operations to a group's name, external id and
+// member list.
+func ApplyGroupPatch(current GroupInput, operations []PatchOperation) (GroupInput, error) {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		lower := strings.ToLower(operation.Path)
+		switch {
+		case op != "add" && op != "replace" && op != "remove":
+			return GroupInput{}, ErrInvalidSyntax
+		case lower == "":
+			values, ok := operation.Value.(map[string]any)
+			if !ok || op == "remove" {
+				return GroupInput{}, ErrInvalidSyntax
+			}
+			for path, value := range values {
+				next, err := ApplyGroupPatch(current, []PatchOperation{{Op: op, Path: path, Value: value}})
+				if err != nil {
+					return GroupInput{}, err
+				}
+				current = next
+			}
+		case lower == "displayname":
+			if op == "remove" {
+				return GroupInput{}, ErrInvalidValue
+			}
+			if err := setString(&current.DisplayName, operation.Value, false); err != nil {
+				return GroupInput{}, err
+			}
+		case lower == "externalid":
+			if err := setString(&current.ExternalID, operation.Value, op == "remove"); err != nil {
+				return GroupInput{}, err
+			}
+		case lower == "members":
+			members, ok := multiValues(operation.Value)
+			switch {
+			case op == "remove" && operation.Value == nil:
+				current.Members = nil
+			case !ok:
+				return GroupInput{}, ErrInvalidValue
+			case op == "add":
+				current.Members = unionMembers(current.Members, members)
+			case op == "replace":
+				current.Members = unionMembers(nil, members)
+			default:
+				current.Members = withoutMembers(current.Members, members)
+			}
+		case memberFilterPattern.MatchString(operation.Path):
+			if op != "remove" {
+				return GroupInput{}, ErrInvalidPath
+			}
+			id := memberFilterPattern.FindStringSubmatch(operation.Path)[1]
+			current.Members = withoutMembers(current.Members, []MultiValue{{Value: id}})
+		default:
+			return GroupInput{}, ErrInvalidPath
+		}
+	}
+	return current, nil
+}
+
+func unionMembers(current []MultiValue, added []MultiValue) []MultiValue {
+	seen := map[string]bool{}
+	result := []MultiValue{}
+	for _, member := range append(append([]MultiValue{}, current...), added...) {
+		if member.Value == "" || seen[member.Value] {
+			continue
+		}
+		seen[member.Value] = true
+		result = append(result, MultiValue{Value: member.Value})
+	}
+	return result
+}
+
+func withoutMembers(current []MultiValue, removed []MultiValue) []MultiValue {
+	drop := map[string]bool{}
+	for _, member := range removed {
+		drop[member.Value] = true
+	}
+	result := []MultiValue{}
+	for _, member := range current {
+		if !drop[member.Value] {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func setString(target *string, value any, remove bool) error {
+	if remove {
+		*target = ""
+		return nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return ErrInvalidValue
+	}
+	*target = strings.TrimSpace(text)
+	return nil
+}
+
+// boolValue also accepts "True"/"False" strings, which some providers send.
+func boolValue(value any) (bool, bool) {
+	switch typed := value.(type) {
+	case bool:
+		return typed, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(typed)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func multiValues(value any) ([]MultiValue, bool) {
+	items, ok := value.([]any)
+	if !ok {
+		if single, isMap := value.(map[string]any); isMap {
+			items = []any{single}
+		} else {
+			return nil, false
+		}
+	}
+	result := []MultiValue{}
+	for _, item := range items {
+		entry, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		text, ok := entry["value"].(string)
+		if !ok {
+			return nil, false
+		}
+		primary, _ := boolValue(entry["primary"])
+		result = append(result, MultiValue{Value: strings.TrimSpace(text), Primary: primary})
+	}
+	return result, true
+}
@@ -0,0 +1,137 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter(`userName Eq "Anna@Example.com"`, "username", "externalid")
+	if err != nil || filter == nil || filter.Attribute != "username" || filter.Value != "Anna@Example.com" {
+		t.Fatalf("filter = %+v, %v", filter, err)
+	}
+	filter, err = ParseFilter(`externalId eq "a\"b"`, "username", "externalid")
+	if err != nil || filter.Value != `a"b` {
+		t.Fatalf("escaped filter = %+v, %v", filter, err)
+	}
+	if filter, err := ParseFilter("  ", "username"); filter != nil || err != nil {
+		t.Fatalf("empty filter = %+v, %v", filter, err)
+	}
+	for _, raw := range []string{`name.givenName eq "Anna"`, `userName co "anna"`, `userName eq "a" and active eq true`} {
+		if _, err := ParseFilter(raw, "username"); !errors.Is(err, ErrInvalidFilter) {
+			t.Fatalf("ParseFilter(%q) err = %v", raw, err)
+		}
+	}
+}
+
+func decodeOperations(t *testing.T, raw string) []PatchOperation {
+	t.Helper()
+	var request PatchRequest
+	if err := json.Unmarshal([]byte(raw), &request); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return request.Operations
+}
+
+func TestApplyUserPatch(t *testing.T) {
+	active := true
+	current := UserInput{UserName: "anna@example.com", DisplayName: "Анна", Active: &active, Roles: []MultiValue{{Value: "admin"}}}
+
+	// Azure AD style: capitalised ops, string booleans, filtered email paths.
+	patched, err := ApplyUserPatch(current, decodeOperations(t, `{"Operations":[
+		{"op":"Replace","path":"active","value":"False"},
+		{"op":"Replace","path":"emails[type eq \"work\"].value","value":"anna@example.com"},
+		{"op":"Add","path":"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department","value":"Sales"},
+		{"op":"replace","path":"name.familyName","value":"Петрова"}
+	]}`))
+	if err != nil {
+		t.Fatalf("ApplyUserPatch err = %v", err)
+	}
+	if patched.Active == nil || *patched.Active || patched.Name.FamilyName != "Петрова" || patched.Roles[0].Value != "admin" {
+		t.Fatalf("patched = %+v", patched)
+	}
+	if *current.Active != true {
+		t.Fatalf("current state mutated")
+	}
+
+	// Okta style: no path, a value object.
+	patched, err = ApplyUserPatch(current, decodeOperations(t, `{"Operations":[
+		{"op":"replace","value":{"active":false,"externalId":"00u1","name":{"givenName":"Аня"}}}
+	]}`))
+	if err != nil || *patched.Active || patched.ExternalID != "00u1" || patched.Name.GivenName != "Аня" {
+		t.Fatalf("value-object patch = %+v, %v", patched, err)
+	}
+
+	patched, err = ApplyUserPatch(current, decodeOperations(t, `{"Operations":[{"op":"remove","path":"roles"}]}`))
+	if err != nil || patched.Roles != nil {
+		t.Fatalf("remove roles = %+v, %v", patched, err)
+	}
+
+	cases := map[string]*Error{
+		`{"Operations":[{"op":"move","path":"active","value":true}]}`:     ErrInvalidSyntax,
+		`{"Operations":[{"op":"replace","path":"active","value":"yes"}]}`: ErrInvalidValue,
+		`{"Operations":[{"op":"replace","path":"nickName","value":"a"}]}`: ErrInvalidPath,
+		`{"Operations":[{"op":"remove","path":"userName"}]}`:              ErrInvalidValue,
+	}
+	for raw, want := range cases {
+		if _, err := ApplyUserPatch(current, decodeOperations(t, raw)); !errors.Is(err, want) {
+			t.Fatalf("%s: err = %v, want %v", raw, err, want)
+		}
+	}
+}
+
+func TestApplyGroupPatch(t *testing.T) {
+	current := GroupInput{DisplayName: "Продажи", Members: []MultiValue{{Value: "1"}, {Value: "2"}}}
+	patched, err := ApplyGroupPatch(current, decodeOperations(t, `{"Operations":[
+		{"op":"add","path":"members","value":[{"value":"3"},{"value":"2"}]},
+		{"op":"remove","path":"members[value eq \"1\"]"},
+		{"op":"replace","path":"displayName","value":" Продажи B2B "}
+	]}`))
+	if err != nil {
+		t.Fatalf("ApplyGroupPatch err = %v", err)
+	}
+	if patched.DisplayName != "Продажи B2B" || len(patched.Members) != 2 || patched.Members[0].Value != "2" || patched.Members[1].Value != "3" {
+		t.Fatalf("patched = %+v", patched)
+	}
+
+	patched, err = ApplyGroupPatch(current, decodeOperations(t, `{"Operations":[
+		{"op":"remove","path":"members","value":[{"value":"2"}]},
+		{"op":"replace","value":{"externalId":"grp-1"}}
+	]}`))
+	if err != nil || len(patched.Members) != 1 || patched.Members[0].Value != "1" || patched.ExternalID != "grp-1" {
+		t.Fatalf("remove with value = %+v, %v", patched, err)
+	}
+
+	patched, err = ApplyGroupPatch(current, decodeOperations(t, `{"Operations":[{"op":"replace","path":"members","value":[{"value":"5"}]}]}`))
+	if err != nil || len(patched.Members) != 1 || patched.Members[0].Value != "5" {
+		t.Fatalf("replace members = %+v, %v", patched, err)
+	}
+	if _, err := ApplyGroupPatch(current, decodeOperations(t, `{"Operations":[{"op":"add","path":"owners","value":[]}]}`)); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("unknown path err = %v", err)
+	}
+}
+
+func TestRoleFromInput(t *testing.T) {
+	if role, err := roleFromInput(nil); err != nil || role != RoleMember {
+		t.Fatalf("no roles = %q, %v", role, err)
+	}
+	if role, err := roleFromInput([]MultiValue{{Value: "member"}, {Value: "Admin"}}); err != nil || role != RoleAdmin {
+		t.Fatalf("admin role = %q, %v", role, err)
+	}
+	if _, err := roleFromInput([]MultiValue{{Value: "owner"}}); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("owner role err = %v", err)
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	request := httptest.NewRequest("GET", "/scim/v2/Users?startIndex=0&count=9000", nil)
+	if start, count := pageParams(request); start != 1 || count != maxPageSize {
+		t.Fatalf("page = %d, %d", start, count)
+	}
+	request = httptest.NewRequest("GET", "/scim/v2/Users?startIndex=11&count=10", nil)
+	if start, count := pageParams(request); start != 11 || count != 10 {
+		t.Fatalf("page = %d, %d", start, count)
+	}
+}
@@ -0,0 +1,691 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"reup-goals-backend/internal/auth"
+	"reup-goals-backend/internal/legal"
+	"reup-goals-backend/internal/v2/departments"
+	"reup-goals-backend/internal/v2/workspaces"
+)
+
+type Store struct {
+	dbx         *sql.DB
+	departments *departments.Store
+}
+
+func NewStore(dbx *sql.DB) *Store {
+	return &Store{dbx: dbx, departments: departments.NewStore(dbx)}
+}
+
+type userRow struct {
+	UserID     int
+	Email      string
+	Name       string
+	Role       string
+	Status     string
+	ExternalID string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+const userColumns = `
+	users.id, users.email, COALESCE(users.name, ''), membership.role, membership.status,
+	COALESCE(membership.scim_external_id, ''), membership.created_at, membership.updated_at`
+
+// Users lists active and deactivated members. Removed members and pending
+// invitations are not SCIM users.
+func (s *Store) Users(ctx context.Context, workspaceID int, filter *Filter, startIndex, count int) ([]User, int, error) {
+	attribute, value := "", ""
+	if filter != nil {
+		attribute, value = filter.Attribute, filter.Value
+	}
+	where := `
+		FROM workspace_memberships membership
+		JOIN users ON users.id=membership.user_id
+		WHERE membership.workspace_id=$1
+			AND membership.status IN ('active', 'deactivated')
+			AND (
+				$2=''
+				OR ($2 IN ('username', 'emails.value') AND lower(users.email)=lower($3))
+				OR ($2='externalid' AND membership.scim_external_id=$3)
+			)`
+	var total int
+	if err := s.dbx.QueryRowContext(ctx, `SELECT COUNT(*) `+where, workspaceID, attribute, value).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `SELECT `+userColumns+where+`
+		ORDER BY membership.id
+		OFFSET $4 LIMIT $5
+	`, workspaceID, attribute, value, startIndex-1, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	items := []userRow{}
+	for rows.Next() {
+		item, err := scanUserRow(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	users, err := s.withGroups(ctx, workspaceID, items)
+	return users, total, err
+}
+
+func (s *Store) User(ctx context.Context, workspaceID int, userID int) (User, error) {
+	row, err := s.userRow(ctx, s.dbx, workspaceID, userID, false)
+	if err != nil {
+		return User{}, err
+	}
+	users, err := s.withGroups(ctx, workspaceID, []userRow{row})
+	if err != nil {
+		return User{}, err
+	}
+	return users[0], nil
+}
+
+// CreateUser provisions a member. An unknown email gets a new account that
+// signs in through the workspace's SSO or a password reset; a known one is
+// added to the workspace without becoming their current workspace unless
+// it is their only one.
+func (s *Store) CreateUser(ctx context.Context, workspaceID int, input UserInput) (User, error) {
+	email, ok := userEmail(input)
+	if !ok {
+		return User{}, ErrInvalidValue
+	}
+	role, err := roleFromInput(input.Roles)
+	if err != nil {
+		return User{}, err
+	}
+	active := input.Active == nil || *input.Active
+	status := membershipDeactivated
+	if active {
+		status = membershipActive
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID); err != nil {
+		return User{}, err
+	}
+	var userID int
+	var member bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT users.id, EXISTS (
+			SELECT 1 FROM workspace_memberships membership
+			WHERE membership.workspace_id=$2 AND membership.user_id=users.id
+		)
+		FROM users
+		WHERE users.email=$1
+	`, email, workspaceID).Scan(&userID, &member)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		userID, err = createUser(ctx, tx, email, displayName(input, email))
+		if err != nil {
+			return User{}, err
+		}
+	case err != nil:
+		return User{}, err
+	case member:
+		return User{}, ErrUniqueness
+	}
+	if active {
+		if err := checkSeat(ctx, tx, workspaceID, email); err != nil {
+			return User{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO workspace_memberships (workspace_id, user_id, role, status, is_default, scim_external_id)
+		VALUES ($1, $2, $3, $4,
+			$4='active' AND NOT EXISTS (
+				SELECT 1 FROM workspace_memberships WHERE user_id=$2 AND status='active'
+			),
+			NULLIF($5, ''))
+	`, workspaceID, userID, role, status, input.ExternalID); err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrUniqueness
+		}
+		return User{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workspace_invitations
+		SET status='cancelled', cancelled_at=NOW(), updated_at=NOW()
+		WHERE workspace_id=$1 AND lower(email)=$2 AND status='pending'
+	`, workspaceID, email); err != nil {
+		return User{}, err
+	}
+	if active {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET workspace_onboarding_mode='complete' WHERE id=$1
+		`, userID); err != nil {
+			return User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.User(ctx, workspaceID, userID)
+}
+
+// ReplaceUser sets role, external id, name and the active flag. Turning a
+// member inactive deactivates the membership: the seat is released and
+// department membership dropped, while tasks and history stay in place for
+// a later reactivation.
+func (s *Store) ReplaceUser(ctx context.Context, workspaceID int, actorUserID int, userID int, input UserInput) (User, error) {
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, workspaceID); err != nil {
+		return User{}, err
+	}
+	current, err := s.userRow(ctx, tx, workspaceID, userID, true)
+	if err != nil {
+		return User{}, err
+	}
+	if input.UserName != "" && !strings.EqualFold(strings.TrimSpace(input.UserName), current.Email) {
+		return User{}, ErrUserNameImmutable
+	}
+	active := input.Active == nil || *input.Active
+	role := current.Role
+	if current.Role == "owner" {
+		if !active {
+			return User{}, ErrOwnerImmutable
+		}
+	} else if role, err = roleFromInput(input.Roles); err != nil {
+		return User{}, err
+	}
+	if !active && userID == actorUserID {
+		return User{}, ErrTokenOwner
+	}
+
+	status := current.Status
+	switch {
+	case active && current.Status != membershipActive:
+		if err := checkSeat(ctx, tx, workspaceID, current.Email); err != nil {
+			return User{}, err
+		}
+		status = membershipActive
+	case !active && current.Status == membershipActive:
+		if err := releaseMembership(ctx, tx, workspaceID, userID); err != nil {
+			return User{}, err
+		}
+		status = membershipDeactivated
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workspace_memberships
+		SET role=$3, status=$4, is_default=is_default AND $4='active',
+			scim_external_id=NULLIF($5, ''), updated_at=NOW()
+		WHERE workspace_id=$1 AND user_id=$2
+	`, workspaceID, userID, role, status, input.ExternalID); err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrUniqueness
+		}
+		return User{}, err
+	}
+	// The account may also belong to other workspaces; only rename it when
+	// this workspace is the only place it is used.
+	if name := displayName(input, ""); name != "" && name != current.Name {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET name=$3
+			WHERE id=$2 AND NOT EXISTS (
+				SELECT 1 FROM workspace_memberships
+				WHERE user_id=$2 AND workspace_id<>$1 AND status='active'
+			)
+		`, workspaceID, userID, name); err != nil {
+			return User{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return s.User(ctx, workspaceID, userID)
+}
+
+// CurrentUserInput is the writable state PATCH operations start from.
+func (user User) CurrentUserInput() UserInput {
+	active := user.Active
+	return UserInput{
+		ExternalID: user.ExternalID, UserName: user.UserName, Name: user.Name,
+		DisplayName: user.DisplayName, Active: &active, Roles: user.Roles,
+	}
+}
+
+func (s *Store) Groups(ctx context.Context, workspaceID int, filter *Filter, startIndex, count int) ([]Group, int, error) {
+	attribute, value := "", ""
+	if filter != nil {
+		attribute, value = filter.Attribute, filter.Value
+	}
+	where := `
+		FROM v2_departments department
+		WHERE department.workspace_id=$1
+			AND department.archived_at IS NULL
+			AND (
+				$2=''
+				OR ($2='displayname' AND lower(department.name)=lower($3))
+				OR ($2='externalid' AND department.scim_external_id=$3)
+			)`
+	var total int
+	if err := s.dbx.QueryRowContext(ctx, `SELECT COUNT(*) `+where, workspaceID, attribute, value).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT department.id, department.name, COALESCE(department.scim_external_id, ''),
+			department.created_at, department.updated_at
+	`+where+`
+		ORDER BY department.sort_order, department.id
+		OFFSET $4 LIMIT $5
+	`, workspaceID, attribute, value, startIndex-1, count)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	groups := []Group{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	for index := range groups {
+		groupID, _ := strconv.Atoi(groups[index].ID)
+		if groups[index].Members, err = s.groupMembers(ctx, workspaceID, groupID); err != nil {
+			return nil, 0, err
+		}
+	}
+	return groups, total, nil
+}
+
+func (s *Store) Group(ctx context.Context, workspaceID int, groupID int) (Group, error) {
+	group, err := scanGroup(s.dbx.QueryRowContext(ctx, `
+		SELECT id, name, COALESCE(scim_external_id, ''), created_at, updated_at
+		FROM v2_departments
+		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+	`, groupID, workspaceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Group{}, ErrNotFound
+	}
+	if err != nil {
+		return Group{}, err
+	}
+	group.Members, err = s.groupMembers(ctx, workspaceID, groupID)
+	return group, err
+}
+
+// CreateGroup creates a department. Members that are not active members of
+// the workspace are skipped rather than failing the whole push.
+func (s *Store) CreateGroup(ctx context.Context, workspaceID int, actorUserID int, input GroupInput) (Group, error) {
+	memberIDs, err := s.activeMemberIDs(ctx, workspaceID, input.Members)
+	if err != nil {
+		return Group{}, err
+	}
+	name := strings.TrimSpace(input.DisplayName)
+	detail, err := s.departments.Create(ctx, workspaceID, actorUserID, departments.Input{Name: &name, MemberUserIDs: memberIDs})
+	if err != nil {
+		return Group{}, departmentError(err)
+	}
+	return s.setGroupExternalID(ctx, workspaceID, detail.Department.ID, input.ExternalID)
+}
+
+// ReplaceGroup renames the department and makes its member list match the
+// provider's. A manager the provider no longer lists stops being manager.
+func (s *Store) ReplaceGroup(ctx context.Context, workspaceID int, groupID int, input GroupInput) (Group, error) {
+	if _, err := s.Group(ctx, workspaceID, groupID); err != nil {
+		return Group{}, err
+	}
+	memberIDs, err := s.activeMemberIDs(ctx, workspaceID, input.Members)
+	if err != nil {
+		return Group{}, err
+	}
+	name := strings.TrimSpace(input.DisplayName)
+	update := departments.Input{Name: &name, MemberUserIDs: memberIDs}
+	var managerID sql.NullInt64
+	if err := s.dbx.QueryRowContext(ctx, `
+		SELECT manager_user_id FROM v2_departments WHERE id=$1 AND workspace_id=$2
+	`, groupID, workspaceID).Scan(&managerID); err != nil {
+		return Group{}, err
+	}
+	if managerID.Valid && !containsID(memberIDs, int(managerID.Int64)) {
+		update.ClearManager = true
+	}
+	if _, err := s.departments.Update(ctx, workspaceID, groupID, update); err != nil {
+		return Group{}, departmentError(err)
+	}
+	return s.setGroupExternalID(ctx, workspaceID, groupID, input.ExternalID)
+}
+
+func (s *Store) DeleteGroup(ctx context.Context, workspaceID int, groupID int) error {
+	return departmentError(s.departments.Archive(ctx, workspaceID, groupID))
+}
+
+func (group Group) CurrentGroupInput() GroupInput {
+	members := make([]MultiValue, 0, len(group.Members))
+	for _, member := range group.Members {
+		members = append(members, MultiValue{Value: member.Value})
+	}
+	return GroupInput{ExternalID: group.ExternalID, DisplayName: group.DisplayName, Members: members}
+}
+
+func (s *Store) setGroupExternalID(ctx context.Context, workspaceID int, groupID int, externalID string) (Group, error) {
+	if _, err := s.dbx.ExecContext(ctx, `
+		UPDATE v2_departments SET scim_external_id=NULLIF($3, ''), updated_at=NOW()
+		WHERE id=$1 AND workspace_id=$2
+	`, groupID, workspaceID, strings.TrimSpace(externalID)); err != nil {
+		if isUniqueViolation(err) {
+			return Group{}, ErrUniqueness
+		}
+		return Group{}, err
+	}
+	return s.Group(ctx, workspaceID, groupID)
+}
+
+func (s *Store) groupMembers(ctx context.Context, workspaceID int, groupID int) ([]MultiValue, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT users.id, COALESCE(NULLIF(users.name, ''), users.email)
+		FROM v2_department_members member
+		JOIN users ON users.id=member.user_id
+		WHERE member.workspace_id=$1 AND member.department_id=$2
+		ORDER BY users.id
+	`, workspaceID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []MultiValue{}
+	for rows.Next() {
+		var id int
+		var display string
+		if err := rows.Scan(&id, &display); err != nil {
+			return nil, err
+		}
+		members = append(members, MultiValue{Value: strconv.Itoa(id), Display: display})
+	}
+	return members, rows.Err()
+}
+
+func (s *Store) activeMemberIDs(ctx context.Context, workspaceID int, members []MultiValue) ([]int, error) {
+	ids := []int{}
+	for _, member := range members {
+		id, err := strconv.Atoi(strings.TrimSpace(member.Value))
+		if err != nil || id <= 0 {
+			return nil, ErrInvalidValue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT user_id FROM workspace_memberships
+		WHERE workspace_id=$1 AND status='active' AND user_id=ANY($2)
+		ORDER BY user_id
+	`, workspaceID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	active := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		active = append(active, id)
+	}
+	return active, rows.Err()
+}
+
+type queryer interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+func (s *Store) userRow(ctx context.Context, q queryer, workspaceID int, userID int, forUpdate bool) (userRow, error) {
+	lock := ""
+	if forUpdate {
+		lock = " FOR UPDATE OF membership"
+	}
+	row, err := scanUserRow(q.QueryRowContext(ctx, `
+		SELECT `+userColumns+`
+		FROM workspace_memberships membership
+		JOIN users ON users.id=membership.user_id
+		WHERE membership.workspace_id=$1 AND membership.user_id=$2
+			AND membership.status IN ('active', 'deactivated')
+	`+lock, workspaceID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return userRow{}, ErrNotFound
+	}
+	return row, err
+}
+
+func (s *Store) withGroups(ctx context.Context, workspaceID int, rows []userRow) ([]User, error) {
+	ids := make([]int, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.UserID)
+	}
+	groups := map[int][]MultiValue{}
+	if len(ids) > 0 {
+		result, err := s.dbx.QueryContext(ctx, `
+			SELECT member.user_id, department.id, department.name
+			FROM v2_department_members member
+			JOIN v2_departments department ON department.id=member.department_id
+			WHERE member.workspace_id=$1 AND member.user_id=ANY($2) AND department.archived_at IS NULL
+			ORDER BY department.sort_order, department.id
+		`, workspaceID, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		defer result.Close()
+		for result.Next() {
+			var userID, departmentID int
+			var name string
+			if err := result.Scan(&userID, &departmentID, &name); err != nil {
+				return nil, err
+			}
+			groups[userID] = append(groups[userID], MultiValue{Value: strconv.Itoa(departmentID), Display: name})
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+	}
+	users := make([]User, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, row.toUser(groups[row.UserID]))
+	}
+	return users, nil
+}
+
+func (row userRow) toUser(groups []MultiValue) User {
+	given, family, _ := strings.Cut(strings.TrimSpace(row.Name), " ")
+	id := strconv.Itoa(row.UserID)
+	role := RoleMember
+	if row.Role == RoleAdmin || row.Role == "owner" {
+		role = RoleAdmin
+	}
+	if groups == nil {
+		groups = []MultiValue{}
+	}
+	return User{
+		Schemas:     []string{SchemaUser},
+		ID:          id,
+		ExternalID:  row.ExternalID,
+		UserName:    row.Email,
+		Name:        Name{Formatted: row.Name, GivenName: given, FamilyName: strings.TrimSpace(family)},
+		DisplayName: row.Name,
+		Emails:      []MultiValue{{Value: row.Email, Type: "work", Primary: true}},
+		Active:      row.Status == membershipActive,
+		Roles:       []MultiValue{{Value: role, Primary: true}},
+		Groups:      groups,
+		Meta: Meta{
+			ResourceType: "User", Created: row.CreatedAt, LastModified: row.UpdatedAt,
+			Location: "/scim/v2/Users/" + id,
+		},
+	}
+}
+
+func scanUserRow(row interface{ Scan(...any) error }) (userRow, error) {
+	var item userRow
+	err := row.Scan(&item.UserID, &item.Email, &item.Name, &item.Role, &item.Status,
+		&item.ExternalID, &item.CreatedAt, &item.UpdatedAt)
+	return item, err
+}
+
+func scanGroup(row interface{ Scan(...any) error }) (Group, error) {
+	var group Group
+	var id int
+	if err := row.Scan(&id, &group.DisplayName, &group.ExternalID, &group.Meta.Created, &group.Meta.LastModified); err != nil {
+		return Group{}, err
+	}
+	group.Schemas = []string{SchemaGroup}
+	group.ID = strconv.Itoa(id)
+	group.Meta.ResourceType = "Group"
+	group.Meta.Location = "/scim/v2/Groups/" + group.ID
+	group.Members = []MultiValue{}
+	return group, nil
+}
+
+// checkSeat applies the same limit as invitations and SSO sign-ups. An
+// invitation for this very email does not count, since provisioning
+// supersedes it.
+func checkSeat(ctx context.Context, tx *sql.Tx, workspaceID int, email string) error {
+	err := workspaces.CheckSeat(ctx, tx, workspaceID, email)
+	if errors.Is(err, workspaces.ErrMemberLimitReached) {
+		return ErrMemberLimit
+	}
+	return err
+}
+
+// releaseMembership mirrors what removing a member does to departments, so
+// a deactivated person no longer shows up as anyone's manager or teammate.
+func releaseMembership(ctx context.Context, tx *sql.Tx, workspaceID int, userID int) error {
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM v2_department_members WHERE workspace_id=$1 AND user_id=$2
+	`, workspaceID, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE v2_departments SET manager_user_id=NULL, updated_at=NOW()
+		WHERE workspace_id=$1 AND manager_user_id=$2
+	`, workspaceID, userID)
+	return err
+}
+
+func createUser(ctx context.Context, tx *sql.Tx, email string, name string) (int, error) {
+	passwordHash, err := auth.UnusablePasswordHash()
+	if err != nil {
+		return 0, err
+	}
+	subjectKey, err := legal.NewSubjectKey()
+	if err != nil {
+		return 0, err
+	}
+	var userID int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (email, password, privacy_subject_id, name, workspace_onboarding_mode)
+		VALUES ($1, $2, $3, $4, 'complete')
+		RETURNING id
+	`, email, passwordHash, subjectKey, name).Scan(&userID)
+	return userID, err
+}
+
+func userEmail(input UserInput) (string, bool) {
+	candidate := input.UserName
+	if candidate == "" {
+		for _, email := range input.Emails {
+			if email.Primary || candidate == "" {
+				candidate = email.Value
+			}
+		}
+	}
+	candidate = strings.ToLower(strings.TrimSpace(candidate))
+	address, err := mail.ParseAddress(candidate)
+	if err != nil || address.Address != candidate {
+		return "", false
+	}
+	return candidate, true
+}
+
+func displayName(input UserInput, email string) string {
+	name := strings.TrimSpace(input.DisplayName)
+	if name == "" {
+		name = strings.TrimSpace(input.Name.Formatted)
+	}
+	if name == "" {
+		name = strings.TrimSpace(input.Name.GivenName + " " + input.Name.FamilyName)
+	}
+	if name == "" && email != "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if runes := []rune(name); len(runes) > 200 {
+		name = string(runes[:200])
+	}
+	return name
+}
+
+// roleFromInput maps SCIM roles to a workspace role; no roles means member.
+func roleFromInput(roles []MultiValue) (string, error) {
+	role := RoleMember
+	for _, item := range roles {
+		value := strings.ToLower(strings.TrimSpace(item.Value))
+		if value != RoleAdmin && value != RoleMember {
+			return "", ErrInvalidValue
+		}
+		if value == RoleAdmin {
+			role = RoleAdmin
+		}
+	}
+	return role, nil
+}
+
+func departmentError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, departments.ErrInvalidDepartment), errors.Is(err, departments.ErrInvalidMember):
+		return ErrInvalidValue
+	case errors.Is(err, departments.ErrDuplicateDepartment):
+		return ErrUniqueness
+	case errors.Is(err, departments.ErrDepartmentInUse):
+		return ErrGroupInUse
+	case errors.Is(err, departments.ErrLastDepartment):
+		return ErrLastGroup
+	default:
+		return err
+	}
+}
+
+func containsID(ids []int, id int) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	var pqError *pq.Error
+	return errors.As(err, &pqError) && pqError.Code == "23505"
+}
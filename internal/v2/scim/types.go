@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceCfg   = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+
+	membershipActive      = "active"
+	membershipDeactivated = "deactivated"
+
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// Error is a SCIM error response (RFC 7644 section 3.12). ScimType is set
+// for the 400 and 409 cases the RFC names.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+var (
+	ErrNotFound          = &Error{Status: http.StatusNotFound, Detail: "resource not found"}
+	ErrInvalidFilter     = &Error{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: "unsupported filter"}
+	ErrInvalidPath       = &Error{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: "unsupported attribute path"}
+	ErrInvalidValue      = &Error{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "invalid attribute value"}
+	ErrInvalidSyntax     = &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "malformed request body"}
+	ErrUserNameImmutable = &Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "userName cannot be changed"}
+	ErrOwnerImmutable    = &Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "the workspace owner cannot be changed via SCIM"}
+	ErrTokenOwner        = &Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "the token owner cannot be deactivated via SCIM"}
+	ErrUniqueness        = &Error{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "resource already exists"}
+	ErrMemberLimit       = &Error{Status: http.StatusForbidden, Detail: "workspace_member_limit_reached"}
+	ErrGroupInUse        = &Error{Status: http.StatusConflict, Detail: "department_in_use"}
+	ErrLastGroup         = &Error{Status: http.StatusConflict, Detail: "last_department_required"}
+)
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// User is a workspace membership seen as a SCIM user. The id is the user's
+// id; userName is their email.
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        Name         `json:"name"`
+	DisplayName string       `json:"displayName"`
+	Emails      []MultiValue `json:"emails"`
+	Active      bool         `json:"active"`
+	Roles       []MultiValue `json:"roles"`
+	Groups      []MultiValue `json:"groups"`
+	Meta        Meta         `json:"meta"`
+}
+
+// Group is a department seen as a SCIM group.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members"`
+	Meta        Meta         `json:"meta"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// UserInput is the writable part of a user, from POST or PUT or built up by
+// applying PATCH operations to the current state.
+type UserInput struct {
+	ExternalID  string       `json:"externalId"`
+	UserName    string       `json:"userName"`
+	Name        Name         `json:"name"`
+	DisplayName string       `json:"displayName"`
+	Emails      []MultiValue `json:"emails"`
+	Active      *bool        `json:"active"`
+	Roles       []MultiValue `json:"roles"`
+}
+
+type GroupInput struct {
+	ExternalID  string       `json:"externalId"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Filter is the single "attribute eq value" comparison identity providers
+// use to look up existing resources before creating them.
+type Filter struct {
+	Attribute string
+	Value     string
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 func (s *Store) DocumentByType(ctx context.Context, workspaceID int, documentType string) (StrategicDocument, error) {
@@ -50,15 +52,19 @@ func (s *Store) CreateDocumentChatMessage(
 	if role != "assistant" && role != "user" {
 		role = "user"
 	}
-	var item DocumentChatMessage
-	err := s.dbx.QueryRowContext(ctx, `
+	item := DocumentChatMessage{Content: strings.TrimSpace(content)}
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, item.Content)
+	if err != nil {
+		return DocumentChatMessage{}, err
+	}
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO strategic_document_chat_messages (
-			workspace_id, document_type, user_id, role, content, metadata_json
+			workspace_id, document_type, user_id, role, content, content_key_version, metadata_json
 		)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, role, content, created_at
-	`, workspaceID, strings.TrimSpace(documentType), userID, role, strings.TrimSpace(content), mustJSON(metadata)).Scan(
-		&item.ID, &item.Role, &item.Content, &item.CreatedAt,
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, role, created_at
+	`, workspaceID, strings.TrimSpace(documentType), userID, role, sealed, keyVersion, mustJSON(metadata)).Scan(
+		&item.ID, &item.Role, &item.CreatedAt,
 	)
 	return item, err
 }
@@ -73,7 +79,7 @@ func (s *Store) DocumentChatMessages(
 		limit = 300
 	}
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, role, content, created_at
+		SELECT id, role, content, content_key_version, created_at
 		FROM strategic_document_chat_messages
 		WHERE workspace_id=$1 AND document_type=$2
 		ORDER BY created_at DESC, id DESC
@@ -87,7 +93,11 @@ func (s *Store) DocumentChatMessages(
 	items := []DocumentChatMessage{}
 	for rows.Next() {
 		var item DocumentChatMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		items = append(items, item)
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 func (s *Store) TryStartKnowledgeCandidate(
@@ -295,7 +297,7 @@ func (s *Store) MarkKnowledgeFeedbackDelivered(ctx context.Context, workspaceID
 
 func (s *Store) KnowledgeSourcesRange(ctx context.Context, workspaceID int, afterID int, throughID int) ([]RawSource, error) {
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, workspace_id, user_id, source_type, content, metadata_json, created_at
+		SELECT id, workspace_id, user_id, source_type, content, content_key_version, metadata_json, created_at
 		FROM strategic_raw_sources
 		WHERE workspace_id=$1 AND id>$2 AND id<=$3
 			AND source_type IN ($4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
@@ -315,8 +317,11 @@ func (s *Store) KnowledgeSourcesRange(ctx context.Context, workspaceID int, afte
 	result := []RawSource{}
 	for rows.Next() {
 		var item RawSource
-		var userID sql.NullInt64
-		if err := rows.Scan(&item.ID, &item.WorkspaceID, &userID, &item.SourceType, &item.Content, &item.Metadata, &item.CreatedAt); err != nil {
+		var userID, keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.WorkspaceID, &userID, &item.SourceType, &item.Content, &keyVersion, &item.Metadata, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		if userID.Valid {
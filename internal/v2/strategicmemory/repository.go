@@ -12,6 +12,7 @@ import (
 	"unicode"
 
 	"reup-goals-backend/internal/ai"
+	"reup-goals-backend/internal/encryption"
 )
 
 type Store struct {
@@ -24,12 +25,16 @@ func NewStore(dbx *sql.DB) *Store {
 
 func (s *Store) CreateRawSource(ctx context.Context, workspaceID int, userID *int, sourceType string, content string, metadata any) (int, error) {
 	meta := mustJSON(metadata)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, content)
+	if err != nil {
+		return 0, err
+	}
 	var id int
-	err := s.dbx.QueryRowContext(ctx, `
-		INSERT INTO strategic_raw_sources (workspace_id, user_id, source_type, content, metadata_json)
-		VALUES ($1, $2, $3, $4, $5)
+	err = s.dbx.QueryRowContext(ctx, `
+		INSERT INTO strategic_raw_sources (workspace_id, user_id, source_type, content, content_key_version, metadata_json)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
-	`, workspaceID, userID, sourceType, content, meta).Scan(&id)
+	`, workspaceID, userID, sourceType, sealed, keyVersion, meta).Scan(&id)
 	return id, err
 }
 
@@ -44,17 +49,21 @@ func (s *Store) CreateRawSourceOnce(
 	metadata any,
 ) (int, bool, error) {
 	meta := mustJSON(metadata)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, content)
+	if err != nil {
+		return 0, false, err
+	}
 	var id int
-	err := s.dbx.QueryRowContext(ctx, `
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO strategic_raw_sources (
-			workspace_id, user_id, source_type, entity_key, content_hash, content, metadata_json
+			workspace_id, user_id, source_type, entity_key, content_hash, content, content_key_version, metadata_json
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (workspace_id, source_type, entity_key, content_hash)
 			WHERE entity_key <> '' AND content_hash <> ''
 		DO NOTHING
 		RETURNING id
-	`, workspaceID, userID, sourceType, entityKey, contentHash, content, meta).Scan(&id)
+	`, workspaceID, userID, sourceType, entityKey, contentHash, sealed, keyVersion, meta).Scan(&id)
 	if err == nil {
 		return id, true, nil
 	}
@@ -309,12 +318,37 @@ func upsertCompanyOverviewDocument(ctx context.Context, tx *sql.Tx, workspaceID
 	if strings.TrimSpace(companyOverview) == "" {
 		return sql.ErrNoRows
 	}
+	// The stored content is sealed, so whether the overview changed is
+	// decided here rather than by comparing ciphertext in SQL.
+	changed := true
+	var current string
+	var currentKeyVersion sql.NullInt64
+	err := tx.QueryRowContext(ctx, `
+		SELECT content, content_key_version
+		FROM workspace_documents
+		WHERE workspace_id=$1 AND system_key='company_overview' AND archived_at IS NULL
+		FOR UPDATE
+	`, workspaceID).Scan(&current, &currentKeyVersion)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		opened, err := encryption.OpenText(ctx, workspaceID, current, currentKeyVersion)
+		if err != nil {
+			return err
+		}
+		changed = opened != companyOverview
+	}
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, companyOverview)
+	if err != nil {
+		return err
+	}
 	var documentID int64
 	if err := tx.QueryRowContext(ctx, `
 		INSERT INTO workspace_documents (
-			workspace_id, title, content, status, favorite, created_by, updated_by, system_key
+			workspace_id, title, content, content_key_version, status, favorite, created_by, updated_by, system_key
 		)
-		SELECT $1, 'О компании', $2, 'published', TRUE,
+		SELECT $1, 'О компании', $2, $3, 'published', TRUE,
 			COALESCE(pipeline.onboarding_confirmed_by, workspace.owner_user_id),
 			COALESCE(pipeline.onboarding_confirmed_by, workspace.owner_user_id),
 			'company_overview'
@@ -323,20 +357,21 @@ func upsertCompanyOverviewDocument(ctx context.Context, tx *sql.Tx, workspaceID
 		WHERE workspace.id=$1
 		ON CONFLICT (workspace_id, system_key) WHERE system_key <> '' AND archived_at IS NULL
 		DO UPDATE SET
-			title='О компании', content=EXCLUDED.content, status='published', favorite=TRUE,
+			title='О компании', content=EXCLUDED.content, content_key_version=EXCLUDED.content_key_version,
+			status='published', favorite=TRUE,
 			updated_by=EXCLUDED.updated_by,
-			version=workspace_documents.version + CASE WHEN workspace_documents.content IS DISTINCT FROM EXCLUDED.content THEN 1 ELSE 0 END,
+			version=workspace_documents.version + CASE WHEN $4 THEN 1 ELSE 0 END,
 			updated_at=NOW()
 		RETURNING id
-	`, workspaceID, companyOverview).Scan(&documentID); err != nil {
+	`, workspaceID, sealed, keyVersion, changed).Scan(&documentID); err != nil {
 		return err
 	}
-	_, err := tx.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO workspace_document_versions (
-			document_id, workspace_id, version, title, content, status, favorite,
+			document_id, workspace_id, version, title, content, content_key_version, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids, saved_by
 		)
-		SELECT id, workspace_id, version, title, content, status, favorite,
+		SELECT id, workspace_id, version, title, content, content_key_version, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids, updated_by
 		FROM workspace_documents
 		WHERE id=$1
@@ -411,63 +446,59 @@ func (s *Store) UpdateStrategicFileStatus(ctx context.Context, workspaceID int,
 }
 
 func (s *Store) RecentMessages(ctx context.Context, workspaceID int, limit int) ([]ConversationMessage, error) {
-	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id,
-			CASE WHEN source_type=$2 THEN 'assistant' ELSE 'user' END AS role,
-			content,
-			created_at
-		FROM strategic_raw_sources
-		WHERE workspace_id=$1
-			AND source_type IN ($2, $3)
-		ORDER BY created_at DESC, id DESC
-		LIMIT $4
-	`, workspaceID, SourceTypeAssistantMessage, SourceTypeUserMessage, limit)
+	messages, err := s.conversationMessages(ctx, workspaceID, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	messages := []ConversationMessage{}
-	for rows.Next() {
-		var item ConversationMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &item.CreatedAt); err != nil {
-			return nil, err
-		}
-		if item.Role == "assistant" {
-			item.Content = cleanAssistantMessage(item.Content)
-		}
-		messages = append(messages, item)
-	}
 	reverseMessages(messages)
-	return messages, rows.Err()
+	return messages, nil
 }
 
+// relevantMessageScanLimit bounds the history RelevantMessages searches. The
+// content is sealed, so matching happens here rather than in SQL.
+const relevantMessageScanLimit = 1000
+
 func (s *Store) RelevantMessages(ctx context.Context, workspaceID int, query string, limit int) ([]ConversationMessage, error) {
 	terms := searchTerms(query, 8)
 	if len(terms) == 0 {
 		return nil, nil
 	}
+	candidates, err := s.conversationMessages(ctx, workspaceID, relevantMessageScanLimit)
+	if err != nil {
+		return nil, err
+	}
 
-	args := []any{workspaceID, SourceTypeAssistantMessage, SourceTypeUserMessage}
-	clauses := make([]string, 0, len(terms))
-	for _, term := range terms {
-		args = append(args, "%"+strings.ToLower(term)+"%")
-		clauses = append(clauses, fmt.Sprintf("LOWER(content) LIKE $%d", len(args)))
+	messages := []ConversationMessage{}
+	for _, item := range candidates {
+		if len(messages) >= limit {
+			break
+		}
+		content := strings.ToLower(item.Content)
+		for _, term := range terms {
+			if strings.Contains(content, strings.ToLower(term)) {
+				messages = append(messages, item)
+				break
+			}
+		}
 	}
-	args = append(args, limit)
+	reverseMessages(messages)
+	return messages, nil
+}
 
-	rows, err := s.dbx.QueryContext(ctx, fmt.Sprintf(`
+// conversationMessages returns the newest auditor messages first.
+func (s *Store) conversationMessages(ctx context.Context, workspaceID int, limit int) ([]ConversationMessage, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
 		SELECT id,
 			CASE WHEN source_type=$2 THEN 'assistant' ELSE 'user' END AS role,
 			content,
+			content_key_version,
 			created_at
 		FROM strategic_raw_sources
 		WHERE workspace_id=$1
 			AND source_type IN ($2, $3)
-			AND (%s)
 		ORDER BY created_at DESC, id DESC
-		LIMIT $%d
-	`, strings.Join(clauses, " OR "), len(args)), args...)
+		LIMIT $4
+	`, workspaceID, SourceTypeAssistantMessage, SourceTypeUserMessage, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -476,7 +507,11 @@ func (s *Store) RelevantMessages(ctx context.Context, workspaceID int, query str
 	messages := []ConversationMessage{}
 	for rows.Next() {
 		var item ConversationMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		if item.Role == "assistant" {
@@ -484,7 +519,6 @@ func (s *Store) RelevantMessages(ctx context.Context, workspaceID int, query str
 		}
 		messages = append(messages, item)
 	}
-	reverseMessages(messages)
 	return messages, rows.Err()
 }
 
@@ -492,7 +526,7 @@ func (s *Store) ListClaims(ctx context.Context, workspaceID int, limit int) ([]C
 	rows, err := s.dbx.QueryContext(ctx, `
 		SELECT id, workspace_id, claim_text, claim_type, topic_key, evidence_level,
 			confidence, importance, source_ids_json, status, status_reason, superseded_by,
-			reviewed_by, reviewed_at, created_at, updated_at
+			reviewed_by, reviewed_at, created_at, updated_at, content_key_version
 		FROM strategic_claims
 		WHERE workspace_id=$1 AND status IN ($2, $3, $4)
 		ORDER BY updated_at DESC, id DESC
@@ -502,14 +536,14 @@ func (s *Store) ListClaims(ctx context.Context, workspaceID int, limit int) ([]C
 		return nil, err
 	}
 	defer rows.Close()
-	return scanClaims(rows)
+	return scanClaims(ctx, rows)
 }
 
 func (s *Store) ListAllClaims(ctx context.Context, workspaceID int, limit int) ([]Claim, error) {
 	rows, err := s.dbx.QueryContext(ctx, `
 		SELECT id, workspace_id, claim_text, claim_type, topic_key, evidence_level,
 			confidence, importance, source_ids_json, status, status_reason, superseded_by,
-			reviewed_by, reviewed_at, created_at, updated_at
+			reviewed_by, reviewed_at, created_at, updated_at, content_key_version
 		FROM strategic_claims
 		WHERE workspace_id=$1
 		ORDER BY updated_at DESC, id DESC
@@ -519,13 +553,14 @@ func (s *Store) ListAllClaims(ctx context.Context, workspaceID int, limit int) (
 		return nil, err
 	}
 	defer rows.Close()
-	return scanClaims(rows)
+	return scanClaims(ctx, rows)
 }
 
-func scanClaims(rows *sql.Rows) ([]Claim, error) {
+func scanClaims(ctx context.Context, rows *sql.Rows) ([]Claim, error) {
 	claims := []Claim{}
 	for rows.Next() {
 		var item Claim
+		var keyVersion sql.NullInt64
 		if err := rows.Scan(
 			&item.ID,
 			&item.WorkspaceID,
@@ -543,9 +578,15 @@ func scanClaims(rows *sql.Rows) ([]Claim, error) {
 			&item.ReviewedAt,
 			&item.CreatedAt,
 			&item.UpdatedAt,
+			&keyVersion,
 		); err != nil {
 			return nil, err
 		}
+		text, err := encryption.OpenText(ctx, item.WorkspaceID, item.ClaimText, keyVersion)
+		if err != nil {
+			return nil, err
+		}
+		item.ClaimText = text
 		claims = append(claims, item)
 	}
 	return claims, rows.Err()
@@ -594,17 +635,21 @@ func (s *Store) InsertClaims(ctx context.Context, workspaceID int, sourceID int,
 		}
 		status := claimStatusForMaterializedClaim(claim)
 		sourceIDs := mustJSON(validClaimSourceIDs(claim.SourceIDs, sourceID, validSourceIDs))
+		sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, text)
+		if err != nil {
+			return added, skipped, err
+		}
 		var claimID int
-		err := tx.QueryRowContext(ctx, `
+		err = tx.QueryRowContext(ctx, `
 			INSERT INTO strategic_claims (
 				workspace_id, claim_text, claim_type, topic_key, evidence_level,
-				confidence, importance, source_ids_json, status, status_reason
+				confidence, importance, source_ids_json, status, status_reason, content_key_version
 			)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 			RETURNING id
 		`,
 			workspaceID,
-			text,
+			sealed,
 			normalizeClaimType(claim.ClaimType),
 			normalizeTopicKey(claim.TopicKey),
 			normalizeEvidenceLevel(claim.EvidenceLevel),
@@ -613,6 +658,7 @@ func (s *Store) InsertClaims(ctx context.Context, workspaceID int, sourceID int,
 			sourceIDs,
 			status,
 			claimLifecycleReason(claim),
+			keyVersion,
 		).Scan(&claimID)
 		if err != nil {
 			return added, skipped, err
@@ -692,7 +738,7 @@ type existingClaimIndex struct {
 
 func claimKeys(ctx context.Context, dbx claimQueryer, workspaceID int) (map[string]existingClaimIndex, error) {
 	rows, err := dbx.QueryContext(ctx, `
-		SELECT id, claim_text, importance FROM strategic_claims
+		SELECT id, claim_text, content_key_version, importance FROM strategic_claims
 		WHERE workspace_id=$1 AND status IN ($2, $3, $4)
 	`, workspaceID, ClaimStatusConfirmed, ClaimStatusSuggested, ClaimStatusConflicted)
 	if err != nil {
@@ -704,8 +750,12 @@ func claimKeys(ctx context.Context, dbx claimQueryer, workspaceID int) (map[stri
 	for rows.Next() {
 		var id int
 		var text string
+		var keyVersion sql.NullInt64
 		var importance string
-		if err := rows.Scan(&id, &text, &importance); err != nil {
+		if err := rows.Scan(&id, &text, &keyVersion, &importance); err != nil {
+			return nil, err
+		}
+		if text, err = encryption.OpenText(ctx, workspaceID, text, keyVersion); err != nil {
 			return nil, err
 		}
 		result[claimKey(text)] = existingClaimIndex{ID: id, Importance: importance}
@@ -826,6 +876,7 @@ func (s *Store) UpdateClaimLifecycle(
 	}
 
 	var item Claim
+	var keyVersion sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
 		UPDATE strategic_claims
 		SET status=$1, status_reason=$2, superseded_by=$3, reviewed_by=$4,
@@ -833,7 +884,7 @@ func (s *Store) UpdateClaimLifecycle(
 		WHERE id=$5 AND workspace_id=$6
 		RETURNING id, workspace_id, claim_text, claim_type, topic_key, evidence_level,
 			confidence, importance, source_ids_json, status, status_reason, superseded_by,
-			reviewed_by, reviewed_at, created_at, updated_at
+			reviewed_by, reviewed_at, created_at, updated_at, content_key_version
 	`, status, cleanText(reason), supersededBy, userID, claimID, workspaceID).Scan(
 		&item.ID,
 		&item.WorkspaceID,
@@ -851,6 +902,7 @@ func (s *Store) UpdateClaimLifecycle(
 		&item.ReviewedAt,
 		&item.CreatedAt,
 		&item.UpdatedAt,
+		&keyVersion,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("claim_not_found")
@@ -858,6 +910,9 @@ func (s *Store) UpdateClaimLifecycle(
 	if err != nil {
 		return nil, err
 	}
+	if item.ClaimText, err = encryption.OpenText(ctx, workspaceID, item.ClaimText, keyVersion); err != nil {
+		return nil, err
+	}
 	return &item, nil
 }
 
@@ -1161,17 +1216,22 @@ func (s *Store) ListDocuments(ctx context.Context, workspaceID int) ([]Strategic
 
 func (s *Store) CompanyOverviewDocument(ctx context.Context, workspaceID int) (StrategicDocument, error) {
 	var item StrategicDocument
+	var keyVersion sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
-		SELECT id, workspace_id, 'company_overview', title, content, '[]'::jsonb,
+		SELECT id, workspace_id, 'company_overview', title, content, content_key_version, '[]'::jsonb,
 			'strong', version, updated_at
 		FROM workspace_documents
 		WHERE workspace_id=$1 AND system_key='company_overview' AND archived_at IS NULL
 		ORDER BY id ASC
 		LIMIT 1
 	`, workspaceID).Scan(
-		&item.ID, &item.WorkspaceID, &item.DocumentType, &item.Title, &item.Markdown,
+		&item.ID, &item.WorkspaceID, &item.DocumentType, &item.Title, &item.Markdown, &keyVersion,
 		&item.SourceClaimIDs, &item.Status, &item.Version, &item.GeneratedAt,
 	)
+	if err != nil {
+		return item, err
+	}
+	item.Markdown, err = encryption.OpenText(ctx, workspaceID, item.Markdown, keyVersion)
 	return item, err
 }
 
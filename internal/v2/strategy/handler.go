@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"reup-goals-backend/internal/ai"
+	"reup-goals-backend/internal/audit"
 	"reup-goals-backend/internal/auth"
 	"reup-goals-backend/internal/security"
 	"reup-goals-backend/internal/v2/api"
@@ -30,6 +31,7 @@ type Handler struct {
 	facilitator *FacilitatorService
 	synthesis   *SynthesisService
 	readiness   *ReadinessService
+	audit       *audit.Store
 }
 
 func (h *Handler) WithContextIndex(index *contextindex.Service) *Handler {
@@ -52,6 +54,7 @@ func NewHandler(dbx *sql.DB, aiClient ai.Provider, compactThreshold int, manager
 		facilitator: facilitator,
 		synthesis:   synthesis,
 		readiness:   readiness,
+		audit:       audit.NewStore(dbx),
 	}
 }
 
@@ -306,7 +309,15 @@ func (h *Handler) Strategy(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) Versions(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/api/v2/strategy-versions" {
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/api/v2/strategy-versions":
+	case "/api/v2/strategy-versions/compare":
+		h.compareVersions(w, r)
+		return
+	case "/api/v2/strategy-versions/lineage":
+		h.versionLineage(w, r)
+		return
+	default:
 		api.WriteError(w, http.StatusNotFound, "not_found")
 		return
 	}
@@ -339,6 +350,70 @@ func (h *Handler) Versions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) compareVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	workspace, _, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "invalid_to_version")
+		return
+	}
+	from := 0
+	if value := strings.TrimSpace(r.URL.Query().Get("from")); value != "" {
+		from, err = strconv.Atoi(value)
+		if err != nil || from <= 0 || from == to {
+			api.WriteError(w, http.StatusBadRequest, "invalid_from_version")
+			return
+		}
+	}
+	comparison, err := h.store.CompareVersions(r.Context(), workspace.ID, from, to)
+	if errors.Is(err, sql.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "strategy_version_not_found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "strategy_compare_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"comparison": comparison})
+}
+
+func (h *Handler) versionLineage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+	workspace, _, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	strategyID := 0
+	if value := strings.TrimSpace(r.URL.Query().Get("strategy_id")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			api.WriteError(w, http.StatusBadRequest, "invalid_strategy_id")
+			return
+		}
+		strategyID = parsed
+	}
+	lineage, err := h.store.Lineage(r.Context(), workspace.ID, strategyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		api.WriteError(w, http.StatusNotFound, "strategy_version_not_found")
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, "strategy_lineage_failed")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]any{"lineage": lineage})
+}
+
 func (h *Handler) ResearchRequests(w http.ResponseWriter, r *http.Request) {
 	workspace, userID, ok := h.currentWorkspace(w, r)
 	if !ok {
@@ -564,6 +639,11 @@ func (h *Handler) activateStrategy(w http.ResponseWriter, r *http.Request, works
 		api.WriteError(w, http.StatusInternalServerError, "strategy_activate_failed")
 		return
 	}
+	h.audit.Log(r.Context(), audit.Event{
+		WorkspaceID: &workspaceID, ActorUserID: &userID, Action: audit.ActionStrategyActivated,
+		TargetType: "strategy", TargetID: strconv.Itoa(strategy.ID),
+		After: map[string]any{"version": strategy.Version, "title": strategy.Title},
+	}.WithRequest(r))
 
 	api.WriteJSON(w, http.StatusOK, map[string]any{"strategy": strategy})
 }
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 type Store struct {
@@ -418,12 +420,16 @@ func (s *Store) CreateChatMessage(ctx context.Context, workspaceID int, userID *
 		role = "user"
 	}
 
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, strings.TrimSpace(content))
+	if err != nil {
+		return 0, err
+	}
 	var id int
-	err := s.dbx.QueryRowContext(ctx, `
-		INSERT INTO v2_strategy_chat_messages (workspace_id, user_id, role, content, metadata_json)
-		VALUES ($1, $2, $3, $4, $5)
+	err = s.dbx.QueryRowContext(ctx, `
+		INSERT INTO v2_strategy_chat_messages (workspace_id, user_id, role, content, content_key_version, metadata_json)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
-	`, workspaceID, userID, role, strings.TrimSpace(content), mustJSON(metadata)).Scan(&id)
+	`, workspaceID, userID, role, sealed, keyVersion, mustJSON(metadata)).Scan(&id)
 	return id, err
 }
 
@@ -433,7 +439,7 @@ func (s *Store) RecentChatMessages(ctx context.Context, workspaceID int, limit i
 	}
 
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, role, content, created_at
+		SELECT id, role, content, content_key_version, created_at
 		FROM v2_strategy_chat_messages
 		WHERE workspace_id=$1
 		ORDER BY created_at DESC, id DESC
@@ -447,7 +453,11 @@ func (s *Store) RecentChatMessages(ctx context.Context, workspaceID int, limit i
 	messages := []StrategyChatMessage{}
 	for rows.Next() {
 		var item StrategyChatMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		messages = append(messages, item)
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 func (s *Store) CreateSynthesisRun(
@@ -365,7 +367,7 @@ func (s *Store) ChatMessages(ctx context.Context, workspaceID int, limit int) ([
 		limit = 300
 	}
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, role, content, created_at
+		SELECT id, role, content, content_key_version, created_at
 		FROM v2_strategy_chat_messages
 		WHERE workspace_id=$1
 		ORDER BY created_at DESC, id DESC
@@ -379,7 +381,11 @@ func (s *Store) ChatMessages(ctx context.Context, workspaceID int, limit int) ([
 	messages := []StrategyChatMessage{}
 	for rows.Next() {
 		var item StrategyChatMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		messages = append(messages, item)
@@ -550,3 +550,82 @@ func ValidArtifactStatus(status string) bool {
 		return false
 	}
 }
+
+const (
+	ArtifactChangeAdded     = "added"
+	ArtifactChangeRemoved   = "removed"
+	ArtifactChangeChanged   = "changed"
+	ArtifactChangeUnchanged = "unchanged"
+
+	DiffLineContext = "context"
+	DiffLineAdded   = "added"
+	DiffLineRemoved = "removed"
+
+	LineageNodeStrategy     = "strategy"
+	LineageNodeSynthesisRun = "synthesis_run"
+	LineageNodeCourse       = "course"
+	LineageNodeTacticalPlan = "tactical_plan"
+	LineageNodeWorkstream   = "workstream"
+)
+
+type StrategyComparison struct {
+	From      Strategy                  `json:"from"`
+	To        Strategy                  `json:"to"`
+	Fields    []StrategyFieldChange     `json:"fields"`
+	Artifacts []StrategyArtifactChange  `json:"artifacts"`
+	Summary   StrategyComparisonSummary `json:"summary"`
+}
+
+type StrategyFieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type StrategyComparisonSummary struct {
+	ArtifactsAdded     int `json:"artifacts_added"`
+	ArtifactsRemoved   int `json:"artifacts_removed"`
+	ArtifactsChanged   int `json:"artifacts_changed"`
+	ArtifactsUnchanged int `json:"artifacts_unchanged"`
+	LinesAdded         int `json:"lines_added"`
+	LinesRemoved       int `json:"lines_removed"`
+}
+
+type StrategyArtifactChange struct {
+	Type         string         `json:"type"`
+	Title        string         `json:"title"`
+	Change       string         `json:"change"`
+	StatusBefore string         `json:"status_before,omitempty"`
+	StatusAfter  string         `json:"status_after,omitempty"`
+	LinesAdded   int            `json:"lines_added"`
+	LinesRemoved int            `json:"lines_removed"`
+	Diff         []TextDiffLine `json:"diff"`
+}
+
+type TextDiffLine struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+type StrategyLineage struct {
+	Nodes []LineageNode `json:"nodes"`
+	Edges []LineageEdge `json:"edges"`
+}
+
+type LineageNode struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	EntityID   int        `json:"entity_id"`
+	Title      string     `json:"title"`
+	Status     string     `json:"status"`
+	Version    int        `json:"version,omitempty"`
+	TaskCount  *int       `json:"task_count,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+type LineageEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Relation string `json:"relation"`
+}
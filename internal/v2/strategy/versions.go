@@ -0,0 +1,367 @@
+package strategy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDiffLines bounds the quadratic line diff. Artifacts are short documents,
+// so anything larger is compared as a whole-block replacement.
+const maxDiffLines = 2000
+
+func (s *Store) VersionByNumber(ctx context.Context, workspaceID int, version int) (Strategy, error) {
+	row := s.dbx.QueryRowContext(ctx, `
+		SELECT id, workspace_id, status, version, title, summary, source_type,
+			created_by, approved_by, created_at, updated_at, approved_at, activated_at
+		FROM v2_strategies
+		WHERE workspace_id=$1 AND version=$2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`, workspaceID, version)
+	return scanStrategy(row)
+}
+
+// CompareVersions compares two strategy versions artifact by artifact. A
+// non-positive fromVersion means the version right before toVersion.
+func (s *Store) CompareVersions(ctx context.Context, workspaceID int, fromVersion int, toVersion int) (StrategyComparison, error) {
+	to, err := s.VersionByNumber(ctx, workspaceID, toVersion)
+	if err != nil {
+		return StrategyComparison{}, err
+	}
+	var from Strategy
+	if fromVersion > 0 {
+		from, err = s.VersionByNumber(ctx, workspaceID, fromVersion)
+	} else {
+		row := s.dbx.QueryRowContext(ctx, `
+			SELECT id, workspace_id, status, version, title, summary, source_type,
+				created_by, approved_by, created_at, updated_at, approved_at, activated_at
+			FROM v2_strategies
+			WHERE workspace_id=$1 AND version < $2
+			ORDER BY version DESC, created_at DESC, id DESC
+			LIMIT 1
+		`, workspaceID, to.Version)
+		from, err = scanStrategy(row)
+	}
+	if err != nil {
+		return StrategyComparison{}, err
+	}
+	fromArtifacts, err := s.listArtifacts(ctx, workspaceID, from.ID)
+	if err != nil {
+		return StrategyComparison{}, err
+	}
+	toArtifacts, err := s.listArtifacts(ctx, workspaceID, to.ID)
+	if err != nil {
+		return StrategyComparison{}, err
+	}
+	return compareStrategies(from, fromArtifacts, to, toArtifacts), nil
+}
+
+func compareStrategies(from Strategy, fromArtifacts []Artifact, to Strategy, toArtifacts []Artifact) StrategyComparison {
+	comparison := StrategyComparison{From: from, To: to, Fields: []StrategyFieldChange{}, Artifacts: []StrategyArtifactChange{}}
+	if from.Title != to.Title {
+		comparison.Fields = append(comparison.Fields, StrategyFieldChange{Field: "title", Before: from.Title, After: to.Title})
+	}
+	if from.Summary != to.Summary {
+		comparison.Fields = append(comparison.Fields, StrategyFieldChange{Field: "summary", Before: from.Summary, After: to.Summary})
+	}
+
+	before := map[string]Artifact{}
+	for _, artifact := range fromArtifacts {
+		before[artifact.Type] = artifact
+	}
+	seen := map[string]bool{}
+	ordered := append(append([]Artifact{}, toArtifacts...), fromArtifacts...)
+	for _, artifact := range ordered {
+		if seen[artifact.Type] {
+			continue
+		}
+		seen[artifact.Type] = true
+		previous, existed := before[artifact.Type]
+		var current *Artifact
+		for index := range toArtifacts {
+			if toArtifacts[index].Type == artifact.Type {
+				current = &toArtifacts[index]
+				break
+			}
+		}
+
+		change := StrategyArtifactChange{Type: artifact.Type, Title: artifact.Title}
+		beforeContent, afterContent := "", ""
+		if existed {
+			change.StatusBefore = previous.Status
+			beforeContent = previous.Content
+		}
+		if current != nil {
+			change.StatusAfter = current.Status
+			afterContent = current.Content
+		}
+		change.Diff = diffLines(beforeContent, afterContent)
+		for _, line := range change.Diff {
+			switch line.Kind {
+			case DiffLineAdded:
+				change.LinesAdded++
+			case DiffLineRemoved:
+				change.LinesRemoved++
+			}
+		}
+		switch {
+		case !existed:
+			change.Change = ArtifactChangeAdded
+			comparison.Summary.ArtifactsAdded++
+		case current == nil:
+			change.Change = ArtifactChangeRemoved
+			comparison.Summary.ArtifactsRemoved++
+		case change.LinesAdded > 0 || change.LinesRemoved > 0 || change.StatusBefore != change.StatusAfter:
+			change.Change = ArtifactChangeChanged
+			comparison.Summary.ArtifactsChanged++
+		default:
+			change.Change = ArtifactChangeUnchanged
+			comparison.Summary.ArtifactsUnchanged++
+		}
+		comparison.Summary.LinesAdded += change.LinesAdded
+		comparison.Summary.LinesRemoved += change.LinesRemoved
+		comparison.Artifacts = append(comparison.Artifacts, change)
+	}
+	return comparison
+}
+
+// diffLines is a longest-common-subsequence line diff of two texts.
+func diffLines(before string, after string) []TextDiffLine {
+	a := splitDiffLines(before)
+	b := splitDiffLines(after)
+	result := []TextDiffLine{}
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			result = append(result, TextDiffLine{Kind: DiffLineRemoved, Text: line})
+		}
+		for _, line := range b {
+			result = append(result, TextDiffLine{Kind: DiffLineAdded, Text: line})
+		}
+		return result
+	}
+
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, TextDiffLine{Kind: DiffLineContext, Text: a[i]})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			result = append(result, TextDiffLine{Kind: DiffLineRemoved, Text: a[i]})
+			i++
+		default:
+			result = append(result, TextDiffLine{Kind: DiffLineAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, TextDiffLine{Kind: DiffLineRemoved, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, TextDiffLine{Kind: DiffLineAdded, Text: b[j]})
+	}
+	return result
+}
+
+func splitDiffLines(value string) []string {
+	value = strings.TrimRight(strings.ReplaceAll(value, "\r\n", "\n"), "\n")
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// Lineage builds the derivation graph strategy -> synthesis run -> course ->
+// tactical plan -> workstream. A positive strategyID limits it to one version.
+func (s *Store) Lineage(ctx context.Context, workspaceID int, strategyID int) (StrategyLineage, error) {
+	lineage := StrategyLineage{Nodes: []LineageNode{}, Edges: []LineageEdge{}}
+	strategies, err := s.ListVersions(ctx, workspaceID)
+	if err != nil {
+		return StrategyLineage{}, err
+	}
+	for _, strategy := range strategies {
+		if strategyID > 0 && strategy.ID != strategyID {
+			continue
+		}
+		lineage.Nodes = append(lineage.Nodes, LineageNode{
+			ID: lineageNodeID(LineageNodeStrategy, strategy.ID), Type: LineageNodeStrategy, EntityID: strategy.ID,
+			Title: strategy.Title, Status: strategy.Status, Version: strategy.Version, CreatedAt: strategy.CreatedAt,
+		})
+	}
+	if strategyID > 0 && len(lineage.Nodes) == 0 {
+		return StrategyLineage{}, sql.ErrNoRows
+	}
+
+	runs := map[int]bool{}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, strategy_id, version, status, created_at
+		FROM v2_strategy_synthesis_runs
+		WHERE workspace_id=$1 AND status='completed' AND ($2=0 OR strategy_id=$2)
+		ORDER BY created_at ASC, id ASC
+	`, workspaceID, strategyID)
+	if err != nil {
+		return StrategyLineage{}, err
+	}
+	for rows.Next() {
+		var node LineageNode
+		var parentID int
+		if err := rows.Scan(&node.EntityID, &parentID, &node.Version, &node.Status, &node.CreatedAt); err != nil {
+			rows.Close()
+			return StrategyLineage{}, err
+		}
+		node.Type = LineageNodeSynthesisRun
+		node.ID = lineageNodeID(node.Type, node.EntityID)
+		node.Title = fmt.Sprintf("Синтез #%d", node.Version)
+		runs[node.EntityID] = true
+		lineage.Nodes = append(lineage.Nodes, node)
+		lineage.Edges = append(lineage.Edges, LineageEdge{
+			From: lineageNodeID(LineageNodeStrategy, parentID), To: node.ID, Relation: "synthesized",
+		})
+	}
+	if err := closeRows(rows); err != nil {
+		return StrategyLineage{}, err
+	}
+
+	rows, err = s.dbx.QueryContext(ctx, `
+		SELECT id, strategy_id, source_synthesis_run_id, title, status, created_at, archived_at
+		FROM v2_courses
+		WHERE workspace_id=$1 AND ($2=0 OR strategy_id=$2)
+		ORDER BY created_at ASC, id ASC
+	`, workspaceID, strategyID)
+	if err != nil {
+		return StrategyLineage{}, err
+	}
+	courses := map[int]bool{}
+	for rows.Next() {
+		var node LineageNode
+		var parentID int
+		var runID sql.NullInt64
+		var archivedAt sql.NullTime
+		if err := rows.Scan(&node.EntityID, &parentID, &runID, &node.Title, &node.Status, &node.CreatedAt, &archivedAt); err != nil {
+			rows.Close()
+			return StrategyLineage{}, err
+		}
+		node.Type = LineageNodeCourse
+		node.ID = lineageNodeID(node.Type, node.EntityID)
+		node.ArchivedAt = nullTimePointer(archivedAt)
+		courses[node.EntityID] = true
+		lineage.Nodes = append(lineage.Nodes, node)
+		edge := LineageEdge{From: lineageNodeID(LineageNodeStrategy, parentID), To: node.ID, Relation: "course"}
+		if runID.Valid && runs[int(runID.Int64)] {
+			edge = LineageEdge{From: lineageNodeID(LineageNodeSynthesisRun, int(runID.Int64)), To: node.ID, Relation: "course"}
+		}
+		lineage.Edges = append(lineage.Edges, edge)
+	}
+	if err := closeRows(rows); err != nil {
+		return StrategyLineage{}, err
+	}
+
+	rows, err = s.dbx.QueryContext(ctx, `
+		SELECT id, strategy_id, course_id, title, status, created_at, archived_at
+		FROM v2_tactical_plans
+		WHERE workspace_id=$1 AND ($2=0 OR strategy_id=$2)
+		ORDER BY created_at ASC, id ASC
+	`, workspaceID, strategyID)
+	if err != nil {
+		return StrategyLineage{}, err
+	}
+	plans := map[int]bool{}
+	for rows.Next() {
+		var node LineageNode
+		var parentID int
+		var courseID sql.NullInt64
+		var archivedAt sql.NullTime
+		if err := rows.Scan(&node.EntityID, &parentID, &courseID, &node.Title, &node.Status, &node.CreatedAt, &archivedAt); err != nil {
+			rows.Close()
+			return StrategyLineage{}, err
+		}
+		node.Type = LineageNodeTacticalPlan
+		node.ID = lineageNodeID(node.Type, node.EntityID)
+		node.ArchivedAt = nullTimePointer(archivedAt)
+		plans[node.EntityID] = true
+		lineage.Nodes = append(lineage.Nodes, node)
+		edge := LineageEdge{From: lineageNodeID(LineageNodeStrategy, parentID), To: node.ID, Relation: "tactical_plan"}
+		if courseID.Valid && courses[int(courseID.Int64)] {
+			edge.From = lineageNodeID(LineageNodeCourse, int(courseID.Int64))
+		}
+		lineage.Edges = append(lineage.Edges, edge)
+	}
+	if err := closeRows(rows); err != nil {
+		return StrategyLineage{}, err
+	}
+
+	rows, err = s.dbx.QueryContext(ctx, `
+		SELECT workstream.id, workstream.tactical_plan_id, workstream.title, workstream.status,
+			workstream.created_at, workstream.archived_at,
+			COUNT(task.id) FILTER (WHERE task.archived_at IS NULL)
+		FROM v2_tactical_workstreams workstream
+		LEFT JOIN v2_tasks task ON task.workspace_id=workstream.workspace_id AND task.workstream_id=workstream.id
+		WHERE workstream.workspace_id=$1 AND ($2=0 OR workstream.strategy_id=$2)
+		GROUP BY workstream.id
+		ORDER BY workstream.tactical_plan_id ASC, workstream.sort_order ASC, workstream.id ASC
+	`, workspaceID, strategyID)
+	if err != nil {
+		return StrategyLineage{}, err
+	}
+	for rows.Next() {
+		var node LineageNode
+		var planID int
+		var archivedAt sql.NullTime
+		var taskCount int
+		if err := rows.Scan(&node.EntityID, &planID, &node.Title, &node.Status, &node.CreatedAt, &archivedAt, &taskCount); err != nil {
+			rows.Close()
+			return StrategyLineage{}, err
+		}
+		if !plans[planID] {
+			continue
+		}
+		node.Type = LineageNodeWorkstream
+		node.ID = lineageNodeID(node.Type, node.EntityID)
+		node.ArchivedAt = nullTimePointer(archivedAt)
+		node.TaskCount = &taskCount
+		lineage.Nodes = append(lineage.Nodes, node)
+		lineage.Edges = append(lineage.Edges, LineageEdge{
+			From: lineageNodeID(LineageNodeTacticalPlan, planID), To: node.ID, Relation: "workstream",
+		})
+	}
+	if err := closeRows(rows); err != nil {
+		return StrategyLineage{}, err
+	}
+
+	return lineage, nil
+}
+
+func lineageNodeID(nodeType string, id int) string {
+	return fmt.Sprintf("%s:%d", nodeType, id)
+}
+
+func nullTimePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+func closeRows(rows *sql.Rows) error {
+	defer rows.Close()
+	return rows.Err()
+}
@@ -0,0 +1,52 @@
+package strategy
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	diff := diffLines("a\nb\nc\n", "a\nc\nd")
+	want := []TextDiffLine{
+		{Kind: DiffLineContext, Text: "a"},
+		{Kind: DiffLineRemoved, Text: "b"},
+		{Kind: DiffLineContext, Text: "c"},
+		{Kind: DiffLineAdded, Text: "d"},
+	}
+	if len(diff) != len(want) {
+		t.Fatalf("expected %d lines, got %+v", len(want), diff)
+	}
+	for index := range want {
+		if diff[index] != want[index] {
+			t.Fatalf("line %d: expected %+v, got %+v", index, want[index], diff[index])
+		}
+	}
+	if empty := diffLines("", "  \n"); len(empty) != 0 {
+		t.Fatalf("blank texts must produce no diff, got %+v", empty)
+	}
+}
+
+func TestCompareStrategiesSummarizesArtifacts(t *testing.T) {
+	from := Strategy{Version: 1, Title: "v1"}
+	to := Strategy{Version: 2, Title: "v2"}
+	comparison := compareStrategies(from, []Artifact{
+		{Type: "global_goal", Content: "Grow", Status: ArtifactStatusFilled},
+		{Type: "mission", Content: "Same", Status: ArtifactStatusApproved},
+		{Type: "legacy", Content: "Old"},
+	}, to, []Artifact{
+		{Type: "global_goal", Content: "Grow faster", Status: ArtifactStatusFilled},
+		{Type: "mission", Content: "Same", Status: ArtifactStatusApproved},
+		{Type: "values", Content: "New"},
+	})
+
+	summary := comparison.Summary
+	if summary.ArtifactsAdded != 1 || summary.ArtifactsRemoved != 1 || summary.ArtifactsChanged != 1 || summary.ArtifactsUnchanged != 1 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if summary.LinesAdded != 2 || summary.LinesRemoved != 2 {
+		t.Fatalf("unexpected line counts: %+v", summary)
+	}
+	if len(comparison.Fields) != 1 || comparison.Fields[0].Field != "title" {
+		t.Fatalf("unexpected field changes: %+v", comparison.Fields)
+	}
+	if comparison.Artifacts[len(comparison.Artifacts)-1].Type != "legacy" {
+		t.Fatalf("removed artifacts must follow current ones: %+v", comparison.Artifacts)
+	}
+}
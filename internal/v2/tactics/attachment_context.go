@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 const (
@@ -126,14 +128,18 @@ func (s *FacilitatorService) resolveWorkspaceDocument(
 		return TacticsResolvedAttachment{}, ErrInvalidContextAttachment
 	}
 	var title, content string
+	var keyVersion sql.NullInt64
 	err := s.store.dbx.QueryRowContext(ctx, `
-		SELECT title, content
+		SELECT title, content, content_key_version
 		FROM workspace_documents
 		WHERE workspace_id=$1 AND id=$2 AND archived_at IS NULL
-	`, workspaceID, attachment.ID).Scan(&title, &content)
+	`, workspaceID, attachment.ID).Scan(&title, &content, &keyVersion)
 	if err != nil {
 		return TacticsResolvedAttachment{}, err
 	}
+	if content, err = encryption.OpenText(ctx, workspaceID, content, keyVersion); err != nil {
+		return TacticsResolvedAttachment{}, err
+	}
 	return TacticsResolvedAttachment{
 		Type: attachmentWorkspaceDocument, ID: attachment.ID,
 		Label:   firstNonEmpty(attachment.Label, title),
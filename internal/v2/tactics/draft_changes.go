@@ -531,6 +531,7 @@ func (s *Store) applyWorkstreamDraft(ctx context.Context, workspaceID int, userI
 		MetricCurrent: change.MetricCurrent, MetricTarget: change.MetricTarget, Metrics: change.Metrics,
 	}
 	var item Workstream
+	var before []byte
 	var err error
 	if operation == "create" {
 		input.Status = WorkstreamStatusActive
@@ -541,6 +542,7 @@ func (s *Store) applyWorkstreamDraft(ctx context.Context, workspaceID int, userI
 		if lookupErr != nil || current.TacticalPlanID != plan.ID {
 			return AppliedTacticsChange{}, false
 		}
+		before = s.workstreamRestoreState(ctx, workspaceID, current)
 		item, err = s.UpdateWorkstream(ctx, workspaceID, entityID, input)
 	}
 	if err != nil {
@@ -552,7 +554,9 @@ func (s *Store) applyWorkstreamDraft(ctx context.Context, workspaceID int, userI
 	if !s.applyDraftMetrics(ctx, workspaceID, userID, metrics.ScopeWorkstream, item.ID, change.Metrics) {
 		return AppliedTacticsChange{}, false
 	}
-	return appliedChange(operation, EntityWorkstream, item.ID, item.Title, change), true
+	applied := appliedChange(operation, EntityWorkstream, item.ID, item.Title, change)
+	applied.Before = before
+	return applied, true
 }
 
 func (s *Store) applyProjectDraft(ctx context.Context, workspaceID int, userID int, plan TacticalPlan, parentID int, change TacticsDraftChange) (AppliedTacticsChange, bool) {
@@ -594,6 +598,7 @@ func (s *Store) applyProjectDraft(ctx context.Context, workspaceID int, userID i
 		input.MetricName = change.Metrics[0].Name
 	}
 	var item Project
+	var before []byte
 	var err error
 	if operation == "create" {
 		input.Status = ProjectStatusActive
@@ -603,6 +608,7 @@ func (s *Store) applyProjectDraft(ctx context.Context, workspaceID int, userID i
 		if parentErr != nil || parent.TacticalPlanID != plan.ID {
 			return AppliedTacticsChange{}, false
 		}
+		before = tacticsJSON(current)
 		item, err = s.UpdateProject(ctx, workspaceID, entityID, input)
 	}
 	if err != nil {
@@ -614,7 +620,9 @@ func (s *Store) applyProjectDraft(ctx context.Context, workspaceID int, userID i
 	if !s.applyDraftMetrics(ctx, workspaceID, userID, metrics.ScopeProject, item.ID, change.Metrics) {
 		return AppliedTacticsChange{}, false
 	}
-	return appliedChange(operation, EntityProject, item.ID, item.Title, change), true
+	applied := appliedChange(operation, EntityProject, item.ID, item.Title, change)
+	applied.Before = before
+	return applied, true
 }
 
 func (s *Store) applyTaskDraft(
@@ -883,6 +891,7 @@ func (s *Store) applyRiskDraft(ctx context.Context, workspaceID int, userID int,
 		CoverageStatus: change.CoverageStatus,
 	}
 	var item Risk
+	var before []byte
 	var err error
 	if operation == "create" {
 		item, err = s.createRisk(ctx, workspaceID, userID, input, SourceAISuggestion)
@@ -891,12 +900,15 @@ func (s *Store) applyRiskDraft(ctx context.Context, workspaceID int, userID int,
 		if lookupErr != nil || current.TacticalPlanID != plan.ID {
 			return AppliedTacticsChange{}, false
 		}
+		before = tacticsJSON(current)
 		item, err = s.UpdateRisk(ctx, workspaceID, entityID, input)
 	}
 	if err != nil {
 		return AppliedTacticsChange{}, false
 	}
-	return appliedChange(operation, "risk", item.ID, item.Title, change), true
+	applied := appliedChange(operation, "risk", item.ID, item.Title, change)
+	applied.Before = before
+	return applied, true
 }
 
 func (s *Store) applyHypothesisDraft(ctx context.Context, workspaceID int, userID int, plan TacticalPlan, parentID int, change TacticsDraftChange) (AppliedTacticsChange, bool) {
@@ -918,6 +930,7 @@ func (s *Store) applyHypothesisDraft(ctx context.Context, workspaceID int, userI
 		TestMethod: change.TestMethod, Status: change.HypothesisStatus, OwnerUserID: change.OwnerUserID,
 	}
 	var item Hypothesis
+	var before []byte
 	var err error
 	if operation == "create" {
 		item, err = s.createHypothesis(ctx, workspaceID, userID, input, SourceAISuggestion)
@@ -926,12 +939,15 @@ func (s *Store) applyHypothesisDraft(ctx context.Context, workspaceID int, userI
 		if lookupErr != nil || current.TacticalPlanID != plan.ID {
 			return AppliedTacticsChange{}, false
 		}
+		before = tacticsJSON(current)
 		item, err = s.UpdateHypothesis(ctx, workspaceID, int64(entityID), input)
 	}
 	if err != nil {
 		return AppliedTacticsChange{}, false
 	}
-	return appliedChange(operation, EntityHypothesis, int(item.ID), item.Title, change), true
+	applied := appliedChange(operation, EntityHypothesis, int(item.ID), item.Title, change)
+	applied.Before = before
+	return applied, true
 }
 
 func (s *Store) applyOpportunityDraft(ctx context.Context, workspaceID int, userID int, plan TacticalPlan, parentID int, change TacticsDraftChange) (AppliedTacticsChange, bool) {
@@ -952,6 +968,7 @@ func (s *Store) applyOpportunityDraft(ctx context.Context, workspaceID int, user
 	}
 	input := OpportunityInput{EntityType: entityType, EntityID: parentID, Title: change.Title, Description: change.Description, PotentialImpact: change.PotentialImpact, Urgency: change.Urgency, CoverageStatus: change.CoverageStatus}
 	var item Opportunity
+	var before []byte
 	var err error
 	if operation == "create" {
 		item, err = s.createOpportunity(ctx, workspaceID, userID, input, SourceAISuggestion)
@@ -960,12 +977,15 @@ func (s *Store) applyOpportunityDraft(ctx context.Context, workspaceID int, user
 		if lookupErr != nil || current.TacticalPlanID != plan.ID {
 			return AppliedTacticsChange{}, false
 		}
+		before = tacticsJSON(current)
 		item, err = s.UpdateOpportunity(ctx, workspaceID, entityID, input)
 	}
 	if err != nil {
 		return AppliedTacticsChange{}, false
 	}
-	return appliedChange(operation, "opportunity", item.ID, item.Title, change), true
+	applied := appliedChange(operation, "opportunity", item.ID, item.Title, change)
+	applied.Before = before
+	return applied, true
 }
 
 func appliedChange(operation string, entityType string, entityID int, title string, change TacticsDraftChange) AppliedTacticsChange {
@@ -981,11 +1001,12 @@ func (s *Store) recordAppliedTacticsChange(ctx context.Context, workspaceID int,
 	err := s.dbx.QueryRowContext(ctx, `
 		INSERT INTO v2_tactics_applied_changes (
 			workspace_id, tactical_plan_id, source_message_id, operation, entity_type,
-			entity_id, title, change_json, created_by
+			entity_id, title, change_json, before_json, created_by
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
-	`, workspaceID, planID, messageID, item.Operation, item.EntityType, item.EntityID, item.Title, tacticsJSON(item.Fields), userID).Scan(&id)
+	`, workspaceID, planID, messageID, item.Operation, item.EntityType, item.EntityID, item.Title,
+		tacticsJSON(item.Fields), nullableJSON(item.Before), userID).Scan(&id)
 	if err != nil {
 		return 0
 	}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/v2/aiactions"
 	"reup-goals-backend/internal/v2/departments"
 )
@@ -80,10 +81,10 @@ func (s *Store) seedStrategyAdvisorHistory(ctx context.Context, thread AdvisorTh
 	}
 	_, err := s.dbx.ExecContext(ctx, `
 		INSERT INTO v2_tactics_chat_messages (
-			workspace_id, user_id, role, content, metadata_json, scope_type, scope_id, created_at
+			workspace_id, user_id, role, content, content_key_version, metadata_json, scope_type, scope_id, created_at
 		)
 		SELECT
-			message.workspace_id, message.user_id, message.role, message.content,
+			message.workspace_id, message.user_id, message.role, message.content, message.content_key_version,
 			jsonb_build_object(
 				'migrated_from', 'strategy_facilitator',
 				'strategy_message_id', message.id
@@ -213,14 +214,18 @@ func (s *Store) CreateScopedChatMessage(ctx context.Context, workspaceID int, us
 		role = "user"
 	}
 	scopeType, scopeID := tacticsScopeKey(scope)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, strings.TrimSpace(content))
+	if err != nil {
+		return 0, err
+	}
 	var id int
-	err := s.dbx.QueryRowContext(ctx, `
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO v2_tactics_chat_messages (
-			workspace_id, user_id, role, content, metadata_json, scope_type, scope_id
+			workspace_id, user_id, role, content, content_key_version, metadata_json, scope_type, scope_id
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
-	`, workspaceID, userID, role, strings.TrimSpace(content), tacticsJSON(metadata), scopeType, scopeID).Scan(&id)
+	`, workspaceID, userID, role, sealed, keyVersion, tacticsJSON(metadata), scopeType, scopeID).Scan(&id)
 	return id, err
 }
 
@@ -244,15 +249,19 @@ func (s *Store) CreateScopedAgentChatMessage(
 	}
 	metadata["agent_run_id"] = agentRunID
 	scopeType, scopeID := tacticsScopeKey(scope)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, strings.TrimSpace(content))
+	if err != nil {
+		return 0, err
+	}
 	var id int
-	err := s.dbx.QueryRowContext(ctx, `
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO v2_tactics_chat_messages (
-			workspace_id, user_id, role, content, metadata_json, scope_type, scope_id
+			workspace_id, user_id, role, content, content_key_version, metadata_json, scope_type, scope_id
 		)
-		VALUES ($1, NULL, 'assistant', $2, $3, $4, $5)
+		VALUES ($1, NULL, 'assistant', $2, $3, $4, $5, $6)
 		ON CONFLICT DO NOTHING
 		RETURNING id
-	`, workspaceID, strings.TrimSpace(content), tacticsJSON(metadata), scopeType, scopeID).Scan(&id)
+	`, workspaceID, sealed, keyVersion, tacticsJSON(metadata), scopeType, scopeID).Scan(&id)
 	if err == nil {
 		return id, nil
 	}
@@ -280,7 +289,7 @@ func (s *Store) ScopedChatMessages(ctx context.Context, workspaceID int, scope *
 	}
 	scopeType, scopeID := tacticsScopeKey(scope)
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, role, content, metadata_json, created_at
+		SELECT id, role, content, content_key_version, metadata_json, created_at
 		FROM v2_tactics_chat_messages
 		WHERE workspace_id=$1 AND scope_type=$2 AND scope_id=$3
 		ORDER BY created_at DESC, id DESC
@@ -295,7 +304,11 @@ func (s *Store) ScopedChatMessages(ctx context.Context, workspaceID int, scope *
 	for rows.Next() {
 		var item TacticsChatMessage
 		var metadataRaw json.RawMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &metadataRaw, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &metadataRaw, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		var metadata struct {
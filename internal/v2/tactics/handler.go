@@ -477,6 +477,10 @@ func (h *Handler) Current(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) Tactics(w http.ResponseWriter, r *http.Request) {
+	if planID, route, revision, ok := tacticsHistoryPath(r.URL.Path); ok {
+		h.tacticsHistory(w, r, planID, route, revision)
+		return
+	}
 	planID, ok := numericSuffix(r.URL.Path, "/api/v2/tactics/")
 	if !ok {
 		api.WriteError(w, http.StatusNotFound, "not_found")
@@ -531,7 +535,7 @@ func (h *Handler) Tactics(w http.ResponseWriter, r *http.Request) {
 			api.WriteError(w, http.StatusConflict, "tactics_readiness_required")
 			return
 		}
-		snapshot, err := h.store.Current(r.Context(), workspace.ID, userID)
+		snapshot, err := h.store.activationSnapshot(r.Context(), workspace.ID, userID)
 		if err != nil {
 			api.WriteError(w, http.StatusInternalServerError, "tactics_snapshot_failed")
 			return
@@ -564,6 +568,151 @@ func (h *Handler) Tactics(w http.ResponseWriter, r *http.Request) {
 	api.WriteJSON(w, http.StatusOK, map[string]any{"tactical_plan": plan})
 }
 
+func (h *Handler) tacticsHistory(w http.ResponseWriter, r *http.Request, planID int, route string, revision int) {
+	workspace, userID, ok := h.currentWorkspace(w, r)
+	if !ok {
+		return
+	}
+	method := http.MethodGet
+	if route == "rollback" || route == "applied-changes/revert" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		api.WriteError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+		return
+	}
+
+	switch route {
+	case "versions":
+		items, err := h.store.PlanVersions(r.Context(), workspace.ID, planID)
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_versions_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"versions": items})
+	case "version":
+		snapshot, err := h.store.PlanVersionSnapshot(r.Context(), workspace.ID, planID, revision)
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_version_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"revision": revision, "snapshot": snapshot})
+	case "diff":
+		from, err := strconv.Atoi(r.URL.Query().Get("from"))
+		if err != nil || from <= 0 {
+			api.WriteError(w, http.StatusBadRequest, "invalid_from_revision")
+			return
+		}
+		to := 0
+		if value := strings.TrimSpace(r.URL.Query().Get("to")); value != "" && value != "current" {
+			to, err = strconv.Atoi(value)
+			if err != nil || to <= 0 {
+				api.WriteError(w, http.StatusBadRequest, "invalid_to_revision")
+				return
+			}
+		}
+		diff, err := h.store.PlanDiff(r.Context(), workspace.ID, planID, from, to)
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_diff_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"diff": diff})
+	case "rollbacks":
+		items, err := h.store.PlanRollbacks(r.Context(), workspace.ID, planID, 50)
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_rollbacks_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"rollbacks": items})
+	case "applied-changes":
+		items, err := h.store.AppliedChangeGroups(r.Context(), workspace.ID, planID, 30)
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_applied_changes_failed")
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"groups": items})
+	case "rollback", "applied-changes/revert":
+		var body TacticalPlanRollbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "invalid_json")
+			return
+		}
+		var rollback TacticalPlanRollback
+		var err error
+		if route == "rollback" {
+			if body.Revision <= 0 {
+				api.WriteError(w, http.StatusBadRequest, "revision_required")
+				return
+			}
+			rollback, err = h.store.RollbackToRevision(r.Context(), workspace.ID, userID, planID, body)
+		} else {
+			if body.MessageID <= 0 {
+				api.WriteError(w, http.StatusBadRequest, "message_id_required")
+				return
+			}
+			rollback, err = h.store.RevertAppliedChanges(r.Context(), workspace.ID, userID, planID, body)
+		}
+		if errors.Is(err, ErrRollbackNoChanges) && len(rollback.Changes) > 0 {
+			api.WriteJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "changes": rollback.Changes})
+			return
+		}
+		if err != nil {
+			writeTacticsHistoryError(w, err, "tactics_rollback_failed")
+			return
+		}
+		if rollback.TacticalPlan != nil {
+			h.captureTacticsEntity(r.Context(), workspace.ID, userID, strategicmemory.SourceTypeTacticalPlan, rollback.TacticalPlan.ID, rollback.TacticalPlan)
+		}
+		api.WriteJSON(w, http.StatusOK, map[string]any{"rollback": rollback, "tactical_plan": rollback.TacticalPlan})
+	default:
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+func writeTacticsHistoryError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		api.WriteError(w, http.StatusNotFound, "not_found")
+	case errors.Is(err, ErrPlanVersionNotFound):
+		api.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrPlanChangedDuringWrite),
+		errors.Is(err, ErrRollbackNoChanges),
+		errors.Is(err, ErrChangesAlreadyReverted):
+		api.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("[WARN] tactics history: %v", err)
+		api.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// tacticsHistoryPath matches /api/v2/tactics/{id}/versions[/{revision}],
+// /diff, /rollback, /rollbacks and /applied-changes[/revert].
+func tacticsHistoryPath(path string) (int, string, int, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/v2/tactics/"), "/"), "/")
+	if !strings.HasPrefix(path, "/api/v2/tactics/") || len(parts) < 2 || len(parts) > 3 {
+		return 0, "", 0, false
+	}
+	planID, err := strconv.Atoi(parts[0])
+	if err != nil || planID <= 0 {
+		return 0, "", 0, false
+	}
+	switch {
+	case len(parts) == 2 && (parts[1] == "versions" || parts[1] == "diff" || parts[1] == "rollback" ||
+		parts[1] == "rollbacks" || parts[1] == "applied-changes"):
+		return planID, parts[1], 0, true
+	case len(parts) == 3 && parts[1] == "versions":
+		revision, err := strconv.Atoi(parts[2])
+		if err != nil || revision <= 0 {
+			return 0, "", 0, false
+		}
+		return planID, "version", revision, true
+	case len(parts) == 3 && parts[1] == "applied-changes" && parts[2] == "revert":
+		return planID, "applied-changes/revert", 0, true
+	default:
+		return 0, "", 0, false
+	}
+}
+
 func (h *Handler) Workstreams(w http.ResponseWriter, r *http.Request) {
 	workspace, userID, ok := h.currentWorkspace(w, r)
 	if !ok {
@@ -1104,6 +1253,10 @@ func writeEntity(w http.ResponseWriter, err error, key string, value any, intern
 		api.WriteError(w, http.StatusForbidden, "forbidden")
 		return
 	}
+	if errors.Is(err, ErrWorkstreamCourseInvalid) {
+		api.WriteError(w, http.StatusUnprocessableEntity, "workstream_course_invalid")
+		return
+	}
 	if err != nil {
 		api.WriteError(w, http.StatusInternalServerError, internalCode)
 		return
@@ -11,7 +11,10 @@ import (
 	"reup-goals-backend/internal/v2/aiactions"
 )
 
-var ErrNoActiveStrategy = errors.New("no_active_strategy")
+var (
+	ErrNoActiveStrategy        = errors.New("no_active_strategy")
+	ErrWorkstreamCourseInvalid = errors.New("workstream_course_invalid")
+)
 
 type Store struct {
 	dbx       *sql.DB
@@ -278,15 +281,16 @@ func (s *Store) ActivatePlan(ctx context.Context, workspaceID int, userID int, p
 	}
 	_, err = tx.ExecContext(ctx, `
 		INSERT INTO v2_tactical_plan_versions (
-			workspace_id, tactical_plan_id, revision, readiness_run_id, snapshot_json, activated_by
+			workspace_id, tactical_plan_id, revision, kind, readiness_run_id, snapshot_json, activated_by
 		)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (tactical_plan_id, revision) DO UPDATE SET
+			kind=EXCLUDED.kind,
 			readiness_run_id=EXCLUDED.readiness_run_id,
 			snapshot_json=EXCLUDED.snapshot_json,
 			activated_by=EXCLUDED.activated_by,
 			activated_at=NOW()
-	`, workspaceID, planID, plan.Revision, readinessRunID, tacticsJSON(snapshot), userID)
+	`, workspaceID, planID, plan.Revision, PlanVersionKindActivation, readinessRunID, tacticsJSON(snapshot), userID)
 	if err != nil {
 		return TacticalPlan{}, err
 	}
@@ -307,6 +311,11 @@ func (s *Store) createWorkstream(ctx context.Context, workspaceID int, userID in
 		return Workstream{}, err
 	}
 
+	courseID, err := s.workstreamCourseID(ctx, workspaceID, plan.StrategyID, input.CourseID)
+	if err != nil {
+		return Workstream{}, err
+	}
+
 	sortOrder, err := s.nextSortOrder(ctx, "v2_tactical_workstreams", "tactical_plan_id", plan.ID)
 	if err != nil {
 		return Workstream{}, err
@@ -316,16 +325,16 @@ func (s *Store) createWorkstream(ctx context.Context, workspaceID int, userID in
 		INSERT INTO v2_tactical_workstreams (
 			workspace_id, tactical_plan_id, strategy_id, title, description, goal, ckp,
 			reason, closes_risk, metric_name, metric_current, metric_target, metrics_json, status,
-			health_status, contribution_type, source, sort_order, created_by
+			health_status, contribution_type, source, sort_order, created_by, course_id
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
 		RETURNING
 			id, workspace_id, tactical_plan_id, strategy_id, course_id, title, description,
 			goal, ckp, reason, closes_risk, metric_name, metric_current, metric_target, metrics_json,
 			status, health_status, contribution_type, confidence, source, sort_order, created_at, updated_at
 	`, workspaceID, plan.ID, plan.StrategyID, input.Title, input.Description, input.Goal, input.CKP, input.Reason,
 		input.ClosesRisk, input.MetricName, input.MetricCurrent, input.MetricTarget, tacticsJSON(input.Metrics), input.Status,
-		input.HealthStatus, input.ContributionType, source, sortOrder, userID)
+		input.HealthStatus, input.ContributionType, source, sortOrder, userID, nullableInt(courseID))
 
 	return scanWorkstream(row)
 }
@@ -380,8 +389,24 @@ func (s *Store) UpdateWorkstream(ctx context.Context, workspaceID int, workstrea
 	if input.Status == "" {
 		input.Status = current.Status
 	}
+	courseID := 0
+	if current.CourseID != nil {
+		courseID = *current.CourseID
+	}
+	if input.CourseID != nil {
+		courseID, err = s.workstreamCourseID(ctx, workspaceID, current.StrategyID, input.CourseID)
+		if err != nil {
+			return Workstream{}, err
+		}
+	}
 
-	row := s.dbx.QueryRowContext(ctx, `
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return Workstream{}, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
 		UPDATE v2_tactical_workstreams
 		SET title=$1,
 			description=$2,
@@ -396,6 +421,7 @@ func (s *Store) UpdateWorkstream(ctx context.Context, workspaceID int, workstrea
 			status=$11,
 			health_status=$12,
 			contribution_type=$13,
+			course_id=$16,
 			updated_at=NOW()
 		WHERE id=$14 AND workspace_id=$15 AND archived_at IS NULL
 		RETURNING
@@ -404,9 +430,49 @@ func (s *Store) UpdateWorkstream(ctx context.Context, workspaceID int, workstrea
 			status, health_status, contribution_type, confidence, source, sort_order, created_at, updated_at
 	`, input.Title, input.Description, input.Goal, input.CKP, input.Reason, input.ClosesRisk, input.MetricName,
 		input.MetricCurrent, input.MetricTarget, tacticsJSON(input.Metrics), input.Status, input.HealthStatus, input.ContributionType,
-		workstreamID, workspaceID)
+		workstreamID, workspaceID, nullableInt(courseID))
+	updated, err := scanWorkstream(row)
+	if err != nil {
+		return Workstream{}, err
+	}
+	if !intPointerEqual(current.CourseID, updated.CourseID) {
+		// Open tasks follow the workstream to its new course; finished ones
+		// stay credited to the course they were done under.
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE v2_tasks task
+			SET course_id=COALESCE($3, plan.course_id), updated_at=NOW()
+			FROM v2_tactical_plans plan
+			WHERE plan.id=task.tactical_plan_id
+				AND task.workspace_id=$1
+				AND task.workstream_id=$2
+				AND task.status IN ('free', 'in_progress')
+		`, workspaceID, workstreamID, nullableInt(courseID)); err != nil {
+			return Workstream{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Workstream{}, err
+	}
+	return updated, nil
+}
 
-	return scanWorkstream(row)
+// workstreamCourseID resolves the course a workstream is linked to. Zero means
+// the workstream follows the plan's primary course.
+func (s *Store) workstreamCourseID(ctx context.Context, workspaceID int, strategyID int, courseID *int) (int, error) {
+	if courseID == nil || *courseID <= 0 {
+		return 0, nil
+	}
+	var id int
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT id
+		FROM v2_courses
+		WHERE id=$1 AND workspace_id=$2 AND strategy_id=$3
+			AND archived_at IS NULL AND status IN ('draft', 'active', 'needs_review')
+	`, *courseID, workspaceID, strategyID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrWorkstreamCourseInvalid
+	}
+	return id, err
 }
 
 func (s *Store) CreateProject(ctx context.Context, workspaceID int, userID int, input ProjectInput) (Project, error) {
@@ -841,6 +907,7 @@ func (s *Store) currentHeader(ctx context.Context, workspaceID int) (StrategySum
 			WHERE workspace_id=$1
 				AND strategy_id=planning_strategy.id
 				AND status='active'
+				AND is_primary
 				AND archived_at IS NULL
 			ORDER BY updated_at DESC, id DESC
 			LIMIT 1
@@ -945,7 +1012,7 @@ func (s *Store) activeCourse(ctx context.Context, workspaceID int, strategyID in
 			horizon, horizon_unit, start_date::TEXT, end_date::TEXT, key_metric,
 			success_criterion, updated_at, activated_at
 		FROM v2_courses
-		WHERE workspace_id=$1 AND strategy_id=$2 AND status='active' AND archived_at IS NULL
+		WHERE workspace_id=$1 AND strategy_id=$2 AND status='active' AND is_primary AND archived_at IS NULL
 		ORDER BY updated_at DESC, id DESC
 		LIMIT 1
 	`, workspaceID, strategyID).Scan(
@@ -1026,6 +1093,7 @@ func (s *Store) attachActiveCourse(ctx context.Context, workspaceID int, plan Ta
 		WHERE workspace_id=$1
 			AND strategy_id=$2
 			AND status='active'
+			AND is_primary
 			AND archived_at IS NULL
 		ORDER BY created_at DESC
 		LIMIT 1
@@ -1058,6 +1126,7 @@ func activeCourseIDTx(ctx context.Context, tx *sql.Tx, workspaceID int, strategy
 		WHERE workspace_id=$1
 			AND strategy_id=$2
 			AND status='active'
+			AND is_primary
 			AND archived_at IS NULL
 		ORDER BY created_at DESC
 		LIMIT 1
@@ -1736,6 +1805,9 @@ type WorkstreamInput struct {
 	Status           string         `json:"status"`
 	HealthStatus     string         `json:"health_status"`
 	ContributionType string         `json:"contribution_type"`
+	// CourseID links the workstream to one of the strategy's courses. On
+	// update nil keeps the link and 0 returns the workstream to the plan course.
+	CourseID *int `json:"course_id"`
 }
 
 func (i *WorkstreamInput) normalize() {
@@ -504,13 +504,127 @@ type TacticsDraftChange struct {
 }
 
 type AppliedTacticsChange struct {
-	ID         int            `json:"id,omitempty"`
-	Operation  string         `json:"operation"`
-	EntityType string         `json:"entity_type"`
-	EntityID   int            `json:"entity_id"`
-	Title      string         `json:"title"`
-	Status     string         `json:"status"`
-	Fields     map[string]any `json:"fields,omitempty"`
+	ID         int             `json:"id,omitempty"`
+	Operation  string          `json:"operation"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Title      string          `json:"title"`
+	Status     string          `json:"status"`
+	Fields     map[string]any  `json:"fields,omitempty"`
+	Before     json.RawMessage `json:"-"`
+}
+
+const (
+	PlanVersionKindActivation = "activation"
+	PlanVersionKindRollback   = "rollback"
+
+	RollbackTypeRevision       = "revision"
+	RollbackTypeAppliedChanges = "applied_changes"
+
+	DiffChangeAdded   = "added"
+	DiffChangeRemoved = "removed"
+	DiffChangeChanged = "changed"
+
+	EntityMetric = "metric"
+)
+
+type TacticalPlanVersion struct {
+	ID             int       `json:"id"`
+	TacticalPlanID int       `json:"tactical_plan_id"`
+	Revision       int       `json:"revision"`
+	Kind           string    `json:"kind"`
+	ReadinessRunID *int      `json:"readiness_run_id,omitempty"`
+	CreatedBy      *int      `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type TacticalPlanDiff struct {
+	TacticalPlanID int                     `json:"tactical_plan_id"`
+	FromRevision   int                     `json:"from_revision"`
+	ToRevision     int                     `json:"to_revision"`
+	ToCurrent      bool                    `json:"to_current"`
+	Summary        TacticalPlanDiffSummary `json:"summary"`
+	Workstreams    []TacticalEntityDiff    `json:"workstreams"`
+	Projects       []TacticalEntityDiff    `json:"projects"`
+	Risks          []TacticalEntityDiff    `json:"risks"`
+	Opportunities  []TacticalEntityDiff    `json:"opportunities"`
+	Hypotheses     []TacticalEntityDiff    `json:"hypotheses"`
+	Metrics        []TacticalEntityDiff    `json:"metrics"`
+	Warnings       []string                `json:"warnings,omitempty"`
+}
+
+type TacticalPlanDiffSummary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+type TacticalEntityDiff struct {
+	EntityType       string                `json:"entity_type"`
+	EntityID         int                   `json:"entity_id"`
+	ParentEntityType string                `json:"parent_entity_type,omitempty"`
+	ParentEntityID   int                   `json:"parent_entity_id,omitempty"`
+	Title            string                `json:"title"`
+	Change           string                `json:"change"`
+	Fields           []TacticalFieldChange `json:"fields,omitempty"`
+}
+
+type TacticalFieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+type TacticalPlanRollbackRequest struct {
+	Revision         int    `json:"revision"`
+	MessageID        int    `json:"message_id"`
+	ExpectedRevision int    `json:"expected_revision"`
+	Force            bool   `json:"force"`
+	Reason           string `json:"reason"`
+}
+
+type TacticalPlanRollback struct {
+	ID              int                    `json:"id"`
+	TacticalPlanID  int                    `json:"tactical_plan_id"`
+	RollbackType    string                 `json:"rollback_type"`
+	TargetRevision  *int                   `json:"target_revision,omitempty"`
+	SourceMessageID *int                   `json:"source_message_id,omitempty"`
+	BaseRevision    int                    `json:"base_revision"`
+	ResultRevision  int                    `json:"result_revision"`
+	Reason          string                 `json:"reason"`
+	Changes         []TacticalRollbackItem `json:"changes"`
+	CreatedBy       *int                   `json:"created_by,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	TacticalPlan    *TacticalPlan          `json:"tactical_plan,omitempty"`
+}
+
+type TacticalRollbackItem struct {
+	EntityType string `json:"entity_type"`
+	EntityID   int    `json:"entity_id"`
+	Title      string `json:"title"`
+	Action     string `json:"action"`
+	Applied    bool   `json:"applied"`
+	SkipReason string `json:"skip_reason,omitempty"`
+}
+
+type AppliedTacticsChangeGroup struct {
+	SourceMessageID int                       `json:"source_message_id"`
+	CreatedBy       *int                      `json:"created_by,omitempty"`
+	CreatedAt       time.Time                 `json:"created_at"`
+	Reverted        bool                      `json:"reverted"`
+	Changes         []AppliedTacticsChangeLog `json:"changes"`
+}
+
+type AppliedTacticsChangeLog struct {
+	ID         int        `json:"id"`
+	Operation  string     `json:"operation"`
+	EntityType string     `json:"entity_type"`
+	EntityID   int        `json:"entity_id"`
+	Title      string     `json:"title"`
+	Revertible bool       `json:"revertible"`
+	RevertedAt *time.Time `json:"reverted_at,omitempty"`
+	RollbackID *int       `json:"rollback_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 type tacticsFacilitatorModelOutput struct {
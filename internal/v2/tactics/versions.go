@@ -0,0 +1,1175 @@
+package tactics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPlanVersionNotFound    = errors.New("tactics_version_not_found")
+	ErrPlanChangedDuringWrite = errors.New("tactics_plan_changed")
+	ErrRollbackNoChanges      = errors.New("tactics_rollback_no_changes")
+	ErrChangesAlreadyReverted = errors.New("tactics_changes_already_reverted")
+)
+
+// tacticalPlanSnapshot is the JSON stored in v2_tactical_plan_versions. It keeps
+// the public current view and adds flat entity lists, because covered
+// plan-level risks and opportunities never reach the nested workstream view.
+type tacticalPlanSnapshot struct {
+	CurrentResponse
+	Risks         []Risk        `json:"risks"`
+	Opportunities []Opportunity `json:"opportunities"`
+	Hypotheses    []Hypothesis  `json:"hypotheses"`
+	complete      bool
+}
+
+func (s *Store) activationSnapshot(ctx context.Context, workspaceID int, userID int) (tacticalPlanSnapshot, error) {
+	current, err := s.Current(ctx, workspaceID, userID)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	snapshot := tacticalPlanSnapshot{CurrentResponse: current, complete: true}
+	if current.TacticalPlan == nil {
+		return snapshot, nil
+	}
+	if snapshot.Risks, err = s.listRisks(ctx, workspaceID, current.TacticalPlan.ID); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	if snapshot.Opportunities, err = s.listOpportunities(ctx, workspaceID, current.TacticalPlan.ID); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	if snapshot.Hypotheses, err = s.listHypotheses(ctx, workspaceID, current.TacticalPlan.ID); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// planSnapshot reads the live entities of any plan without the header and
+// coverage enrichment that Current performs for the workspace's current plan.
+func (s *Store) planSnapshot(ctx context.Context, workspaceID int, plan TacticalPlan) (tacticalPlanSnapshot, error) {
+	workstreams, err := s.listWorkstreams(ctx, workspaceID, plan.ID)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	risks, err := s.listRisks(ctx, workspaceID, plan.ID)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	opportunities, err := s.listOpportunities(ctx, workspaceID, plan.ID)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	hypotheses, err := s.listHypotheses(ctx, workspaceID, plan.ID)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	hydrateWorkstreams(workstreams, risks, opportunities, hypotheses)
+	if err := s.hydrateWorkstreamParticipants(ctx, workspaceID, workstreams); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	return tacticalPlanSnapshot{
+		CurrentResponse: CurrentResponse{TacticalPlan: &plan, Workstreams: workstreams, Uncovered: emptyUncovered()},
+		Risks:           risks,
+		Opportunities:   opportunities,
+		Hypotheses:      hypotheses,
+		complete:        true,
+	}, nil
+}
+
+func decodePlanSnapshot(raw []byte) (tacticalPlanSnapshot, error) {
+	var snapshot tacticalPlanSnapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	if _, ok := keys["risks"]; ok {
+		snapshot.complete = true
+		return snapshot, nil
+	}
+
+	// Snapshots written before rollbacks existed only carry the nested view.
+	seenRisks := map[int]bool{}
+	seenOpportunities := map[int]bool{}
+	for _, workstream := range snapshot.Workstreams {
+		for _, risk := range workstream.Risks {
+			if !seenRisks[risk.ID] {
+				seenRisks[risk.ID] = true
+				snapshot.Risks = append(snapshot.Risks, risk)
+			}
+		}
+		for _, opportunity := range workstream.Opportunities {
+			if !seenOpportunities[opportunity.ID] {
+				seenOpportunities[opportunity.ID] = true
+				snapshot.Opportunities = append(snapshot.Opportunities, opportunity)
+			}
+		}
+		snapshot.Hypotheses = append(snapshot.Hypotheses, workstream.Hypotheses...)
+	}
+	for _, risk := range snapshot.Uncovered.Risks {
+		if !seenRisks[risk.ID] {
+			seenRisks[risk.ID] = true
+			snapshot.Risks = append(snapshot.Risks, risk)
+		}
+	}
+	for _, opportunity := range snapshot.Uncovered.Opportunities {
+		if !seenOpportunities[opportunity.ID] {
+			seenOpportunities[opportunity.ID] = true
+			snapshot.Opportunities = append(snapshot.Opportunities, opportunity)
+		}
+	}
+	return snapshot, nil
+}
+
+func (s *Store) PlanVersions(ctx context.Context, workspaceID int, planID int) ([]TacticalPlanVersion, error) {
+	if _, err := s.planByID(ctx, workspaceID, planID); err != nil {
+		return nil, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, tactical_plan_id, revision, kind, readiness_run_id, activated_by, activated_at
+		FROM v2_tactical_plan_versions
+		WHERE workspace_id=$1 AND tactical_plan_id=$2
+		ORDER BY revision DESC
+	`, workspaceID, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []TacticalPlanVersion{}
+	for rows.Next() {
+		var item TacticalPlanVersion
+		var readinessRunID sql.NullInt64
+		var createdBy sql.NullInt64
+		if err := rows.Scan(
+			&item.ID, &item.TacticalPlanID, &item.Revision, &item.Kind,
+			&readinessRunID, &createdBy, &item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if readinessRunID.Valid {
+			value := int(readinessRunID.Int64)
+			item.ReadinessRunID = &value
+		}
+		if createdBy.Valid {
+			value := int(createdBy.Int64)
+			item.CreatedBy = &value
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) PlanVersionSnapshot(ctx context.Context, workspaceID int, planID int, revision int) (json.RawMessage, error) {
+	var raw []byte
+	err := s.dbx.QueryRowContext(ctx, `
+		SELECT snapshot_json
+		FROM v2_tactical_plan_versions
+		WHERE workspace_id=$1 AND tactical_plan_id=$2 AND revision=$3
+	`, workspaceID, planID, revision).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPlanVersionNotFound
+	}
+	return raw, err
+}
+
+func (s *Store) versionSnapshot(ctx context.Context, workspaceID int, planID int, revision int) (tacticalPlanSnapshot, error) {
+	raw, err := s.PlanVersionSnapshot(ctx, workspaceID, planID, revision)
+	if err != nil {
+		return tacticalPlanSnapshot{}, err
+	}
+	return decodePlanSnapshot(raw)
+}
+
+// PlanDiff compares two recorded revisions. A non-positive toRevision compares
+// against the live plan, which is what the client shows before a rollback.
+func (s *Store) PlanDiff(ctx context.Context, workspaceID int, planID int, fromRevision int, toRevision int) (TacticalPlanDiff, error) {
+	plan, err := s.planByID(ctx, workspaceID, planID)
+	if err != nil {
+		return TacticalPlanDiff{}, err
+	}
+	from, err := s.versionSnapshot(ctx, workspaceID, planID, fromRevision)
+	if err != nil {
+		return TacticalPlanDiff{}, err
+	}
+	var to tacticalPlanSnapshot
+	if toRevision > 0 {
+		to, err = s.versionSnapshot(ctx, workspaceID, planID, toRevision)
+	} else {
+		to, err = s.planSnapshot(ctx, workspaceID, plan)
+	}
+	if err != nil {
+		return TacticalPlanDiff{}, err
+	}
+	diff := diffPlanSnapshots(from, to)
+	diff.TacticalPlanID = plan.ID
+	diff.FromRevision = fromRevision
+	diff.ToRevision = toRevision
+	if toRevision <= 0 {
+		diff.ToRevision = plan.Revision
+		diff.ToCurrent = true
+	}
+	return diff, nil
+}
+
+type diffEntity struct {
+	entityType string
+	entityID   int
+	parentType string
+	parentID   int
+	title      string
+	fields     map[string]any
+}
+
+var diffIgnoredFields = []string{
+	"created_at", "updated_at", "source", "evaluation", "evaluation_status", "tactical_plan_id",
+}
+
+func diffPlanSnapshots(from tacticalPlanSnapshot, to tacticalPlanSnapshot) TacticalPlanDiff {
+	before := planDiffEntities(from)
+	after := planDiffEntities(to)
+	diff := TacticalPlanDiff{
+		Workstreams:   diffEntityMaps(before[EntityWorkstream], after[EntityWorkstream]),
+		Projects:      diffEntityMaps(before[EntityProject], after[EntityProject]),
+		Risks:         diffEntityMaps(before[EntityRisk], after[EntityRisk]),
+		Opportunities: diffEntityMaps(before[EntityOpportunity], after[EntityOpportunity]),
+		Hypotheses:    diffEntityMaps(before[EntityHypothesis], after[EntityHypothesis]),
+		Metrics:       diffEntityMaps(before[EntityMetric], after[EntityMetric]),
+	}
+	if !from.complete || !to.complete {
+		diff.Warnings = append(diff.Warnings, "legacy_snapshot_without_covered_plan_items")
+	}
+	for _, group := range [][]TacticalEntityDiff{
+		diff.Workstreams, diff.Projects, diff.Risks, diff.Opportunities, diff.Hypotheses, diff.Metrics,
+	} {
+		for _, item := range group {
+			switch item.Change {
+			case DiffChangeAdded:
+				diff.Summary.Added++
+			case DiffChangeRemoved:
+				diff.Summary.Removed++
+			case DiffChangeChanged:
+				diff.Summary.Changed++
+			}
+		}
+	}
+	return diff
+}
+
+func planDiffEntities(snapshot tacticalPlanSnapshot) map[string]map[string]diffEntity {
+	result := map[string]map[string]diffEntity{
+		EntityWorkstream: {}, EntityProject: {}, EntityRisk: {},
+		EntityOpportunity: {}, EntityHypothesis: {}, EntityMetric: {},
+	}
+	for _, workstream := range snapshot.Workstreams {
+		result[EntityWorkstream][fmt.Sprint(workstream.ID)] = diffEntity{
+			entityType: EntityWorkstream, entityID: workstream.ID, title: workstream.Title,
+			fields: diffFields(workstream,
+				"projects", "risks", "opportunities", "hypotheses", "metrics",
+				"metric_name", "metric_current", "metric_target", "strategy_id", "course_id", "confidence"),
+		}
+		for _, project := range workstream.Projects {
+			result[EntityProject][fmt.Sprint(project.ID)] = diffEntity{
+				entityType: EntityProject, entityID: project.ID, title: project.Title,
+				parentType: EntityWorkstream, parentID: workstream.ID,
+				fields: diffFields(project, "confidence"),
+			}
+		}
+		for _, metric := range workstream.Metrics {
+			name := strings.TrimSpace(metric.Name)
+			if name == "" {
+				continue
+			}
+			result[EntityMetric][fmt.Sprintf("%d:%s", workstream.ID, strings.ToLower(name))] = diffEntity{
+				entityType: EntityMetric, title: name,
+				parentType: EntityWorkstream, parentID: workstream.ID,
+				fields: diffFields(metric),
+			}
+		}
+	}
+	for _, risk := range snapshot.Risks {
+		result[EntityRisk][fmt.Sprint(risk.ID)] = diffEntity{
+			entityType: EntityRisk, entityID: risk.ID, title: risk.Title,
+			parentType: risk.EntityType, parentID: risk.EntityID,
+			fields: diffFields(risk),
+		}
+	}
+	for _, opportunity := range snapshot.Opportunities {
+		result[EntityOpportunity][fmt.Sprint(opportunity.ID)] = diffEntity{
+			entityType: EntityOpportunity, entityID: opportunity.ID, title: opportunity.Title,
+			parentType: opportunity.EntityType, parentID: opportunity.EntityID,
+			fields: diffFields(opportunity),
+		}
+	}
+	for _, hypothesis := range snapshot.Hypotheses {
+		result[EntityHypothesis][fmt.Sprint(hypothesis.ID)] = diffEntity{
+			entityType: EntityHypothesis, entityID: int(hypothesis.ID), title: hypothesis.Title,
+			parentType: hypothesis.EntityType, parentID: hypothesis.EntityID,
+			fields: diffFields(hypothesis),
+		}
+	}
+	return result
+}
+
+func diffFields(value any, skip ...string) map[string]any {
+	fields := map[string]any{}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(raw, &fields)
+	for _, key := range diffIgnoredFields {
+		delete(fields, key)
+	}
+	for _, key := range skip {
+		delete(fields, key)
+	}
+	return fields
+}
+
+func diffEntityMaps(before map[string]diffEntity, after map[string]diffEntity) []TacticalEntityDiff {
+	result := []TacticalEntityDiff{}
+	for key, item := range after {
+		previous, existed := before[key]
+		if !existed {
+			result = append(result, item.diff(DiffChangeAdded, nil))
+			continue
+		}
+		if changes := diffFieldChanges(previous.fields, item.fields); len(changes) > 0 {
+			result = append(result, item.diff(DiffChangeChanged, changes))
+		}
+	}
+	for key, item := range before {
+		if _, exists := after[key]; !exists {
+			result = append(result, item.diff(DiffChangeRemoved, nil))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].EntityID != result[j].EntityID {
+			return result[i].EntityID < result[j].EntityID
+		}
+		if result[i].ParentEntityID != result[j].ParentEntityID {
+			return result[i].ParentEntityID < result[j].ParentEntityID
+		}
+		return result[i].Title < result[j].Title
+	})
+	return result
+}
+
+func (item diffEntity) diff(change string, fields []TacticalFieldChange) TacticalEntityDiff {
+	return TacticalEntityDiff{
+		EntityType: item.entityType, EntityID: item.entityID,
+		ParentEntityType: item.parentType, ParentEntityID: item.parentID,
+		Title: item.title, Change: change, Fields: fields,
+	}
+}
+
+func diffFieldChanges(before map[string]any, after map[string]any) []TacticalFieldChange {
+	keys := make([]string, 0, len(before)+len(after))
+	seen := map[string]bool{}
+	for key := range before {
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := []TacticalFieldChange{}
+	for _, key := range keys {
+		if reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+		result = append(result, TacticalFieldChange{Field: key, Before: before[key], After: after[key]})
+	}
+	return result
+}
+
+type planSnapshotIndex struct {
+	workstreams   map[int]Workstream
+	projects      map[int]Project
+	risks         map[int]Risk
+	opportunities map[int]Opportunity
+	hypotheses    map[int]Hypothesis
+}
+
+func indexPlanSnapshot(snapshot tacticalPlanSnapshot) planSnapshotIndex {
+	index := planSnapshotIndex{
+		workstreams: map[int]Workstream{}, projects: map[int]Project{}, risks: map[int]Risk{},
+		opportunities: map[int]Opportunity{}, hypotheses: map[int]Hypothesis{},
+	}
+	for _, workstream := range snapshot.Workstreams {
+		index.workstreams[workstream.ID] = workstream
+		for _, project := range workstream.Projects {
+			index.projects[project.ID] = project
+		}
+	}
+	for _, risk := range snapshot.Risks {
+		index.risks[risk.ID] = risk
+	}
+	for _, opportunity := range snapshot.Opportunities {
+		index.opportunities[opportunity.ID] = opportunity
+	}
+	for _, hypothesis := range snapshot.Hypotheses {
+		index.hypotheses[int(hypothesis.ID)] = hypothesis
+	}
+	return index
+}
+
+// RollbackToRevision writes the content of a recorded revision back onto the
+// live plan. The plan triggers turn the result into a new draft revision, so it
+// goes through readiness and activation again like any other edit.
+func (s *Store) RollbackToRevision(
+	ctx context.Context,
+	workspaceID int,
+	userID int,
+	planID int,
+	request TacticalPlanRollbackRequest,
+) (TacticalPlanRollback, error) {
+	plan, err := s.planByID(ctx, workspaceID, planID)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	if request.ExpectedRevision > 0 && request.ExpectedRevision != plan.Revision {
+		return TacticalPlanRollback{}, ErrPlanChangedDuringWrite
+	}
+	target, err := s.versionSnapshot(ctx, workspaceID, planID, request.Revision)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	live, err := s.planSnapshot(ctx, workspaceID, plan)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	diff := diffPlanSnapshots(live, target)
+	if diff.Summary == (TacticalPlanDiffSummary{}) {
+		return TacticalPlanRollback{}, ErrRollbackNoChanges
+	}
+	index := indexPlanSnapshot(target)
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	defer tx.Rollback()
+	if err := lockPlanRevision(ctx, tx, workspaceID, planID, plan.Revision); err != nil {
+		return TacticalPlanRollback{}, err
+	}
+
+	items := []TacticalRollbackItem{}
+	restoredWorkstreams := map[int]bool{}
+	restoreWorkstream := func(id int, title string, action string) error {
+		if restoredWorkstreams[id] {
+			return nil
+		}
+		restoredWorkstreams[id] = true
+		ok, err := restoreWorkstreamTx(ctx, tx, workspaceID, planID, index.workstreams[id])
+		items = append(items, rollbackItem(EntityWorkstream, id, title, action, ok, "entity_not_found"))
+		return err
+	}
+	for _, item := range diff.Workstreams {
+		switch item.Change {
+		case DiffChangeRemoved:
+			ok, err := archiveEntityTx(ctx, tx, workspaceID, planID, EntityWorkstream, item.EntityID)
+			if err != nil {
+				return TacticalPlanRollback{}, err
+			}
+			items = append(items, rollbackItem(item.EntityType, item.EntityID, item.Title, "archived", ok, "entity_not_found"))
+		default:
+			if err := restoreWorkstream(item.EntityID, item.Title, rollbackAction(item.Change)); err != nil {
+				return TacticalPlanRollback{}, err
+			}
+		}
+	}
+	for _, item := range diff.Metrics {
+		workstream, exists := index.workstreams[item.ParentEntityID]
+		if !exists {
+			continue
+		}
+		if err := restoreWorkstream(workstream.ID, workstream.Title, "restored"); err != nil {
+			return TacticalPlanRollback{}, err
+		}
+	}
+	for _, group := range [][]TacticalEntityDiff{diff.Projects, diff.Risks, diff.Opportunities, diff.Hypotheses} {
+		for _, item := range group {
+			if item.Change == DiffChangeRemoved {
+				if !target.complete && item.ParentEntityType == EntityPlan {
+					items = append(items, rollbackItem(item.EntityType, item.EntityID, item.Title, "archived", false, "legacy_snapshot_incomplete"))
+					continue
+				}
+				ok, err := archiveEntityTx(ctx, tx, workspaceID, planID, item.EntityType, item.EntityID)
+				if err != nil {
+					return TacticalPlanRollback{}, err
+				}
+				items = append(items, rollbackItem(item.EntityType, item.EntityID, item.Title, "archived", ok, "entity_not_found"))
+				continue
+			}
+			ok, err := index.restoreTx(ctx, tx, workspaceID, planID, item.EntityType, item.EntityID)
+			if err != nil {
+				return TacticalPlanRollback{}, err
+			}
+			items = append(items, rollbackItem(item.EntityType, item.EntityID, item.Title, rollbackAction(item.Change), ok, "entity_not_found"))
+		}
+	}
+
+	revision := request.Revision
+	rollback, err := s.recordRollbackTx(ctx, tx, workspaceID, userID, planID, RollbackTypeRevision, &revision, nil, plan.Revision, request.Reason, items)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	rollback.TacticalPlan = s.recordRollbackVersion(ctx, workspaceID, userID, planID)
+	return rollback, nil
+}
+
+// RevertAppliedChanges undoes one confirmed advisor batch, identified by the
+// assistant message whose actions were applied. Creates are archived and
+// updates are restored from the state captured right before they were applied.
+func (s *Store) RevertAppliedChanges(
+	ctx context.Context,
+	workspaceID int,
+	userID int,
+	planID int,
+	request TacticalPlanRollbackRequest,
+) (TacticalPlanRollback, error) {
+	plan, err := s.planByID(ctx, workspaceID, planID)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	if request.ExpectedRevision > 0 && request.ExpectedRevision != plan.Revision {
+		return TacticalPlanRollback{}, ErrPlanChangedDuringWrite
+	}
+	records, err := s.appliedChangesForMessage(ctx, workspaceID, planID, request.MessageID)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	if len(records) == 0 {
+		return TacticalPlanRollback{}, sql.ErrNoRows
+	}
+
+	tx, err := s.dbx.BeginTx(ctx, nil)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	defer tx.Rollback()
+	if err := lockPlanRevision(ctx, tx, workspaceID, planID, plan.Revision); err != nil {
+		return TacticalPlanRollback{}, err
+	}
+
+	items := []TacticalRollbackItem{}
+	revertedIDs := []int{}
+	pending := 0
+	for _, record := range records {
+		if record.RevertedAt != nil {
+			continue
+		}
+		pending++
+		action := "restored"
+		if record.Operation == "create" {
+			action = "archived"
+		}
+		if !record.Revertible {
+			items = append(items, rollbackItem(record.EntityType, record.EntityID, record.Title, action, false, "unsupported_entity"))
+			continue
+		}
+		if !request.Force {
+			changed, err := entityChangedSinceTx(ctx, tx, workspaceID, record.EntityType, record.EntityID, record.CreatedAt)
+			if err != nil {
+				return TacticalPlanRollback{}, err
+			}
+			if changed {
+				items = append(items, rollbackItem(record.EntityType, record.EntityID, record.Title, action, false, "entity_changed_after_apply"))
+				continue
+			}
+		}
+		var ok bool
+		if record.Operation == "create" {
+			ok, err = archiveEntityTx(ctx, tx, workspaceID, planID, record.EntityType, record.EntityID)
+		} else if len(record.before) == 0 {
+			items = append(items, rollbackItem(record.EntityType, record.EntityID, record.Title, action, false, "before_state_unavailable"))
+			continue
+		} else {
+			ok, err = restoreEntityJSONTx(ctx, tx, workspaceID, planID, record.EntityType, record.before)
+		}
+		if err != nil {
+			return TacticalPlanRollback{}, err
+		}
+		items = append(items, rollbackItem(record.EntityType, record.EntityID, record.Title, action, ok, "entity_not_found"))
+		if ok {
+			revertedIDs = append(revertedIDs, record.ID)
+		}
+	}
+	if pending == 0 {
+		return TacticalPlanRollback{}, ErrChangesAlreadyReverted
+	}
+	if len(revertedIDs) == 0 {
+		return TacticalPlanRollback{Changes: items}, ErrRollbackNoChanges
+	}
+
+	messageID := request.MessageID
+	rollback, err := s.recordRollbackTx(ctx, tx, workspaceID, userID, planID, RollbackTypeAppliedChanges, nil, &messageID, plan.Revision, request.Reason, items)
+	if err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	for _, id := range revertedIDs {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE v2_tactics_applied_changes
+			SET reverted_at=NOW(), reverted_by=$1, rollback_id=$2
+			WHERE id=$3 AND workspace_id=$4
+		`, userID, rollback.ID, id, workspaceID); err != nil {
+			return TacticalPlanRollback{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	rollback.TacticalPlan = s.recordRollbackVersion(ctx, workspaceID, userID, planID)
+	return rollback, nil
+}
+
+func (s *Store) AppliedChangeGroups(ctx context.Context, workspaceID int, planID int, limit int) ([]AppliedTacticsChangeGroup, error) {
+	if _, err := s.planByID(ctx, workspaceID, planID); err != nil {
+		return nil, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		WITH recent AS (
+			SELECT source_message_id, MAX(created_at) AS last_created_at
+			FROM v2_tactics_applied_changes
+			WHERE workspace_id=$1 AND tactical_plan_id=$2 AND source_message_id IS NOT NULL
+			GROUP BY source_message_id
+			ORDER BY last_created_at DESC
+			LIMIT $3
+		)
+		SELECT change.id, change.source_message_id, change.operation, change.entity_type,
+			change.entity_id, change.title, change.reverted_at, change.rollback_id,
+			change.created_by, change.created_at
+		FROM v2_tactics_applied_changes change
+		JOIN recent ON recent.source_message_id=change.source_message_id
+		WHERE change.workspace_id=$1 AND change.tactical_plan_id=$2
+		ORDER BY recent.last_created_at DESC, change.id ASC
+	`, workspaceID, planID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []AppliedTacticsChangeGroup{}
+	byMessage := map[int]int{}
+	for rows.Next() {
+		var item AppliedTacticsChangeLog
+		var messageID int
+		var revertedAt sql.NullTime
+		var rollbackID sql.NullInt64
+		var createdBy sql.NullInt64
+		if err := rows.Scan(
+			&item.ID, &messageID, &item.Operation, &item.EntityType, &item.EntityID, &item.Title,
+			&revertedAt, &rollbackID, &createdBy, &item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		item.Revertible = revertibleEntityType(item.EntityType)
+		if revertedAt.Valid {
+			item.RevertedAt = &revertedAt.Time
+		}
+		if rollbackID.Valid {
+			value := int(rollbackID.Int64)
+			item.RollbackID = &value
+		}
+		position, exists := byMessage[messageID]
+		if !exists {
+			group := AppliedTacticsChangeGroup{SourceMessageID: messageID, CreatedAt: item.CreatedAt, Reverted: true}
+			if createdBy.Valid {
+				value := int(createdBy.Int64)
+				group.CreatedBy = &value
+			}
+			result = append(result, group)
+			position = len(result) - 1
+			byMessage[messageID] = position
+		}
+		group := &result[position]
+		group.Changes = append(group.Changes, item)
+		if item.RevertedAt == nil && item.Revertible {
+			group.Reverted = false
+		}
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) PlanRollbacks(ctx context.Context, workspaceID int, planID int, limit int) ([]TacticalPlanRollback, error) {
+	if _, err := s.planByID(ctx, workspaceID, planID); err != nil {
+		return nil, err
+	}
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, tactical_plan_id, rollback_type, target_revision, source_message_id,
+			base_revision, result_revision, reason, changes_json, created_by, created_at
+		FROM v2_tactical_plan_rollbacks
+		WHERE workspace_id=$1 AND tactical_plan_id=$2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, workspaceID, planID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []TacticalPlanRollback{}
+	for rows.Next() {
+		item, err := scanPlanRollback(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+type appliedChangeRecord struct {
+	AppliedTacticsChangeLog
+	before json.RawMessage
+}
+
+func (s *Store) appliedChangesForMessage(ctx context.Context, workspaceID int, planID int, messageID int) ([]appliedChangeRecord, error) {
+	rows, err := s.dbx.QueryContext(ctx, `
+		SELECT id, operation, entity_type, entity_id, title, before_json, reverted_at, created_at
+		FROM v2_tactics_applied_changes
+		WHERE workspace_id=$1 AND tactical_plan_id=$2 AND source_message_id=$3
+		ORDER BY id DESC
+	`, workspaceID, planID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []appliedChangeRecord{}
+	for rows.Next() {
+		var record appliedChangeRecord
+		var before []byte
+		var revertedAt sql.NullTime
+		if err := rows.Scan(
+			&record.ID, &record.Operation, &record.EntityType, &record.EntityID, &record.Title,
+			&before, &revertedAt, &record.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		record.before = before
+		record.Revertible = revertibleEntityType(record.EntityType)
+		if revertedAt.Valid {
+			record.RevertedAt = &revertedAt.Time
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+func revertibleEntityType(entityType string) bool {
+	switch entityType {
+	case EntityWorkstream, EntityProject, EntityRisk, EntityOpportunity, EntityHypothesis:
+		return true
+	default:
+		return false
+	}
+}
+
+func rollbackAction(change string) string {
+	if change == DiffChangeAdded {
+		return "unarchived"
+	}
+	return "restored"
+}
+
+func rollbackItem(entityType string, entityID int, title string, action string, applied bool, skipReason string) TacticalRollbackItem {
+	item := TacticalRollbackItem{EntityType: entityType, EntityID: entityID, Title: title, Action: action, Applied: applied}
+	if !applied {
+		item.SkipReason = skipReason
+	}
+	return item
+}
+
+func lockPlanRevision(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, expectedRevision int) error {
+	var revision int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT revision
+		FROM v2_tactical_plans
+		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+		FOR UPDATE
+	`, planID, workspaceID).Scan(&revision); err != nil {
+		return err
+	}
+	if revision != expectedRevision {
+		return ErrPlanChangedDuringWrite
+	}
+	return nil
+}
+
+func (s *Store) recordRollbackTx(
+	ctx context.Context,
+	tx *sql.Tx,
+	workspaceID int,
+	userID int,
+	planID int,
+	rollbackType string,
+	targetRevision *int,
+	sourceMessageID *int,
+	baseRevision int,
+	reason string,
+	items []TacticalRollbackItem,
+) (TacticalPlanRollback, error) {
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO v2_tactical_plan_rollbacks (
+			workspace_id, tactical_plan_id, rollback_type, target_revision, source_message_id,
+			base_revision, result_revision, reason, changes_json, created_by
+		)
+		SELECT $1, id, $3, $4, $5, $6, revision, $7, $8, $9
+		FROM v2_tactical_plans
+		WHERE id=$2 AND workspace_id=$1
+		RETURNING id, tactical_plan_id, rollback_type, target_revision, source_message_id,
+			base_revision, result_revision, reason, changes_json, created_by, created_at
+	`, workspaceID, planID, rollbackType, targetRevision, sourceMessageID, baseRevision,
+		truncateRunes(strings.TrimSpace(reason), 1000), tacticsJSON(items), userID)
+	return scanPlanRollback(row)
+}
+
+// recordRollbackVersion stores the post-rollback state as a diffable revision.
+// The rollback itself is already committed, so a failure here is only logged.
+func (s *Store) recordRollbackVersion(ctx context.Context, workspaceID int, userID int, planID int) *TacticalPlan {
+	plan, err := s.planByID(ctx, workspaceID, planID)
+	if err != nil {
+		log.Printf("[WARN] load plan after rollback workspace_id=%d plan_id=%d: %v", workspaceID, planID, err)
+		return nil
+	}
+	snapshot, err := s.planSnapshot(ctx, workspaceID, plan)
+	if err != nil {
+		log.Printf("[WARN] snapshot plan after rollback workspace_id=%d plan_id=%d: %v", workspaceID, planID, err)
+		return &plan
+	}
+	if _, err := s.dbx.ExecContext(ctx, `
+		INSERT INTO v2_tactical_plan_versions (
+			workspace_id, tactical_plan_id, revision, kind, snapshot_json, activated_by
+		)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tactical_plan_id, revision) DO NOTHING
+	`, workspaceID, planID, plan.Revision, PlanVersionKindRollback, tacticsJSON(snapshot), userID); err != nil {
+		log.Printf("[WARN] record rollback version workspace_id=%d plan_id=%d: %v", workspaceID, planID, err)
+	}
+	return &plan
+}
+
+func scanPlanRollback(scanner scanner) (TacticalPlanRollback, error) {
+	var item TacticalPlanRollback
+	var targetRevision sql.NullInt64
+	var sourceMessageID sql.NullInt64
+	var createdBy sql.NullInt64
+	var changes []byte
+	if err := scanner.Scan(
+		&item.ID, &item.TacticalPlanID, &item.RollbackType, &targetRevision, &sourceMessageID,
+		&item.BaseRevision, &item.ResultRevision, &item.Reason, &changes, &createdBy, &item.CreatedAt,
+	); err != nil {
+		return TacticalPlanRollback{}, err
+	}
+	if targetRevision.Valid {
+		value := int(targetRevision.Int64)
+		item.TargetRevision = &value
+	}
+	if sourceMessageID.Valid {
+		value := int(sourceMessageID.Int64)
+		item.SourceMessageID = &value
+	}
+	if createdBy.Valid {
+		value := int(createdBy.Int64)
+		item.CreatedBy = &value
+	}
+	item.Changes = []TacticalRollbackItem{}
+	if len(changes) > 0 {
+		_ = json.Unmarshal(changes, &item.Changes)
+	}
+	return item, nil
+}
+
+// workstreamRestoreState captures a workstream with its participants, which
+// live outside the workstream row but are restored together with it.
+func (s *Store) workstreamRestoreState(ctx context.Context, workspaceID int, workstream Workstream) []byte {
+	items := []Workstream{workstream}
+	if err := s.hydrateWorkstreamParticipants(ctx, workspaceID, items); err != nil {
+		return nil
+	}
+	return tacticsJSON(items[0])
+}
+
+func (index planSnapshotIndex) restoreTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, entityType string, entityID int) (bool, error) {
+	switch entityType {
+	case EntityWorkstream:
+		return restoreWorkstreamTx(ctx, tx, workspaceID, planID, index.workstreams[entityID])
+	case EntityProject:
+		return restoreProjectTx(ctx, tx, workspaceID, planID, index.projects[entityID])
+	case EntityRisk:
+		return restoreRiskTx(ctx, tx, workspaceID, planID, index.risks[entityID])
+	case EntityOpportunity:
+		return restoreOpportunityTx(ctx, tx, workspaceID, planID, index.opportunities[entityID])
+	case EntityHypothesis:
+		return restoreHypothesisTx(ctx, tx, workspaceID, planID, index.hypotheses[entityID])
+	default:
+		return false, nil
+	}
+}
+
+func restoreEntityJSONTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, entityType string, raw []byte) (bool, error) {
+	index := planSnapshotIndex{}
+	var entityID int
+	switch entityType {
+	case EntityWorkstream:
+		var item Workstream
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return false, err
+		}
+		index.workstreams, entityID = map[int]Workstream{item.ID: item}, item.ID
+	case EntityProject:
+		var item Project
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return false, err
+		}
+		index.projects, entityID = map[int]Project{item.ID: item}, item.ID
+	case EntityRisk:
+		var item Risk
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return false, err
+		}
+		index.risks, entityID = map[int]Risk{item.ID: item}, item.ID
+	case EntityOpportunity:
+		var item Opportunity
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return false, err
+		}
+		index.opportunities, entityID = map[int]Opportunity{item.ID: item}, item.ID
+	case EntityHypothesis:
+		var item Hypothesis
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return false, err
+		}
+		index.hypotheses, entityID = map[int]Hypothesis{int(item.ID): item}, int(item.ID)
+	default:
+		return false, nil
+	}
+	return index.restoreTx(ctx, tx, workspaceID, planID, entityType, entityID)
+}
+
+func restoreWorkstreamTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, item Workstream) (bool, error) {
+	if item.ID <= 0 {
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_workstreams
+		SET title=$1, description=$2, goal=$3, ckp=$4, reason=$5, closes_risk=$6,
+			metric_name=$7, metric_current=$8, metric_target=$9, metrics_json=$10,
+			status=$11, health_status=$12, contribution_type=$13, sort_order=$14,
+			archived_at=NULL, updated_at=NOW()
+		WHERE id=$15 AND workspace_id=$16 AND tactical_plan_id=$17
+	`, item.Title, item.Description, item.Goal, item.CKP, item.Reason, item.ClosesRisk,
+		item.MetricName, item.MetricCurrent, item.MetricTarget, tacticsJSON(item.Metrics),
+		item.Status, item.HealthStatus, item.ContributionType, item.SortOrder,
+		item.ID, workspaceID, planID)
+	if ok, err := affectedOne(result, err); !ok || err != nil {
+		return ok, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM v2_workstream_participants WHERE workspace_id=$1 AND workstream_id=$2
+	`, workspaceID, item.ID); err != nil {
+		return false, err
+	}
+	insert := func(userID int, role string) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO v2_workstream_participants (workspace_id, workstream_id, user_id, role)
+			SELECT workspace_id, $2, user_id, $4
+			FROM workspace_memberships
+			WHERE workspace_id=$1 AND user_id=$3 AND status='active'
+			ON CONFLICT DO NOTHING
+		`, workspaceID, item.ID, userID, role)
+		return err
+	}
+	if item.OwnerUserID != nil {
+		if err := insert(*item.OwnerUserID, "owner"); err != nil {
+			return false, err
+		}
+	}
+	for _, userID := range normalizedParticipantIDs(item.TeamUserIDs) {
+		if err := insert(userID, "team"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func restoreProjectTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, item Project) (bool, error) {
+	if item.ID <= 0 {
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_projects
+		SET title=$1, description=$2, expected_result=$3, why_needed=$4, success_criteria=$5,
+			failure_criteria=$6, metric_name=$7, expected_value=$8, status=$9, sort_order=$10,
+			archived_at=NULL, updated_at=NOW()
+		WHERE id=$11 AND workspace_id=$12
+			AND workstream_id IN (SELECT id FROM v2_tactical_workstreams WHERE tactical_plan_id=$13)
+	`, item.Title, item.Description, item.ExpectedResult, item.WhyNeeded, item.SuccessCriteria,
+		item.FailureCriteria, item.MetricName, item.ExpectedValue, item.Status, item.SortOrder,
+		item.ID, workspaceID, planID)
+	return affectedOne(result, err)
+}
+
+func restoreRiskTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, item Risk) (bool, error) {
+	if item.ID <= 0 {
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_risks
+		SET title=$1, description=$2, severity=$3, probability=$4, probability_value=$5,
+			impact_score=$6, economic_exposure=$7, currency=$8, owner_user_id=$9,
+			leading_indicators=$10, mitigation_plan=$11, contingency_plan=$12,
+			status=$13, coverage_status=$14, archived_at=NULL, updated_at=NOW()
+		WHERE id=$15 AND workspace_id=$16 AND tactical_plan_id=$17
+	`, item.Title, item.Description, item.Severity, item.Probability, item.ProbabilityValue,
+		item.ImpactScore, item.EconomicExposure, item.Currency, item.OwnerUserID,
+		item.LeadingIndicators, item.MitigationPlan, item.ContingencyPlan,
+		item.Status, item.CoverageStatus, item.ID, workspaceID, planID)
+	if ok, err := affectedOne(result, err); !ok || err != nil {
+		return ok, err
+	}
+	return true, replaceTaskLinksTx(ctx, tx, "v2_task_risks", "risk_id", workspaceID, int64(item.ID), item.LinkedTaskIDs)
+}
+
+func restoreOpportunityTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, item Opportunity) (bool, error) {
+	if item.ID <= 0 {
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_opportunities
+		SET title=$1, description=$2, potential_impact=$3, urgency=$4, status=$5,
+			coverage_status=$6, archived_at=NULL, updated_at=NOW()
+		WHERE id=$7 AND workspace_id=$8 AND tactical_plan_id=$9
+	`, item.Title, item.Description, item.PotentialImpact, item.Urgency, item.Status,
+		item.CoverageStatus, item.ID, workspaceID, planID)
+	return affectedOne(result, err)
+}
+
+func restoreHypothesisTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, item Hypothesis) (bool, error) {
+	if item.ID <= 0 {
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE v2_tactical_hypotheses
+		SET title=$1, statement=$2, expected_effect=$3, metric_target_id=$4, test_method=$5,
+			confidence=$6, status=$7, evidence=$8, owner_user_id=$9,
+			archived_at=NULL, updated_at=NOW()
+		WHERE id=$10 AND workspace_id=$11 AND tactical_plan_id=$12
+	`, item.Title, item.Statement, item.ExpectedEffect, item.MetricTargetID, item.TestMethod,
+		item.Confidence, item.Status, item.Evidence, item.OwnerUserID, item.ID, workspaceID, planID)
+	if ok, err := affectedOne(result, err); !ok || err != nil {
+		return ok, err
+	}
+	return true, replaceTaskLinksTx(ctx, tx, "v2_task_hypotheses", "hypothesis_id", workspaceID, item.ID, item.LinkedTaskIDs)
+}
+
+func archiveEntityTx(ctx context.Context, tx *sql.Tx, workspaceID int, planID int, entityType string, entityID int) (bool, error) {
+	var query string
+	switch entityType {
+	case EntityWorkstream:
+		query = `
+			UPDATE v2_tactical_workstreams
+			SET status='archived', archived_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND workspace_id=$2 AND tactical_plan_id=$3 AND archived_at IS NULL`
+	case EntityProject:
+		query = `
+			UPDATE v2_tactical_projects
+			SET status='archived', archived_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
+				AND workstream_id IN (SELECT id FROM v2_tactical_workstreams WHERE tactical_plan_id=$3)`
+	case EntityRisk:
+		query = `
+			UPDATE v2_tactical_risks
+			SET archived_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND workspace_id=$2 AND tactical_plan_id=$3 AND archived_at IS NULL`
+	case EntityOpportunity:
+		query = `
+			UPDATE v2_tactical_opportunities
+			SET archived_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND workspace_id=$2 AND tactical_plan_id=$3 AND archived_at IS NULL`
+	case EntityHypothesis:
+		query = `
+			UPDATE v2_tactical_hypotheses
+			SET status='archived', archived_at=NOW(), updated_at=NOW()
+			WHERE id=$1 AND workspace_id=$2 AND tactical_plan_id=$3 AND archived_at IS NULL`
+	default:
+		return false, nil
+	}
+	result, err := tx.ExecContext(ctx, query, entityID, workspaceID, planID)
+	return affectedOne(result, err)
+}
+
+func entityChangedSinceTx(ctx context.Context, tx *sql.Tx, workspaceID int, entityType string, entityID int, since time.Time) (bool, error) {
+	var table string
+	switch entityType {
+	case EntityWorkstream:
+		table = "v2_tactical_workstreams"
+	case EntityProject:
+		table = "v2_tactical_projects"
+	case EntityRisk:
+		table = "v2_tactical_risks"
+	case EntityOpportunity:
+		table = "v2_tactical_opportunities"
+	case EntityHypothesis:
+		table = "v2_tactical_hypotheses"
+	default:
+		return false, nil
+	}
+	var changed bool
+	err := tx.QueryRowContext(ctx, "SELECT updated_at > $3 FROM "+table+" WHERE id=$1 AND workspace_id=$2", entityID, workspaceID, since).Scan(&changed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return changed, err
+}
+
+func replaceTaskLinksTx(ctx context.Context, tx *sql.Tx, table string, column string, workspaceID int, entityID int64, taskIDs []int) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE workspace_id=$1 AND "+column+"=$2", workspaceID, entityID); err != nil {
+		return err
+	}
+	for _, taskID := range normalizePositiveTacticsIDs(taskIDs) {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO `+table+` (workspace_id, task_id, `+column+`)
+			SELECT $1, id, $3
+			FROM v2_tasks
+			WHERE id=$2 AND workspace_id=$1 AND archived_at IS NULL
+			ON CONFLICT DO NOTHING
+		`, workspaceID, taskID, entityID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func affectedOne(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func nullableJSON(value []byte) any {
+	if len(value) == 0 {
+		return nil
+	}
+	return value
+}
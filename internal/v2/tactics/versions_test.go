@@ -0,0 +1,92 @@
+package tactics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffPlanSnapshots(t *testing.T) {
+	from := tacticalPlanSnapshot{
+		CurrentResponse: CurrentResponse{Workstreams: []Workstream{
+			{ID: 1, Title: "Sales", Status: "active", Metrics: []TacticMetric{{Name: "Revenue", Current: "10", Target: "20"}}},
+			{ID: 2, Title: "Hiring", Status: "active"},
+		}},
+		Risks:    []Risk{{ID: 5, Title: "Churn", Severity: "high", UpdatedAt: time.Unix(1, 0)}},
+		complete: true,
+	}
+	to := tacticalPlanSnapshot{
+		CurrentResponse: CurrentResponse{Workstreams: []Workstream{
+			{ID: 1, Title: "Sales growth", Status: "active", Metrics: []TacticMetric{{Name: "revenue", Current: "10", Target: "30"}}},
+			{ID: 3, Title: "Partners", Status: "draft"},
+		}},
+		Risks:    []Risk{{ID: 5, Title: "Churn", Severity: "high", UpdatedAt: time.Unix(2, 0)}},
+		complete: true,
+	}
+
+	diff := diffPlanSnapshots(from, to)
+	if diff.Summary != (TacticalPlanDiffSummary{Added: 1, Removed: 1, Changed: 2}) {
+		t.Fatalf("summary = %+v", diff.Summary)
+	}
+	if len(diff.Workstreams) != 3 {
+		t.Fatalf("workstream diffs = %+v", diff.Workstreams)
+	}
+	changed := diff.Workstreams[0]
+	if changed.EntityID != 1 || changed.Change != DiffChangeChanged || len(changed.Fields) != 1 || changed.Fields[0].Field != "title" {
+		t.Fatalf("changed workstream = %+v", changed)
+	}
+	if diff.Workstreams[1].Change != DiffChangeRemoved || diff.Workstreams[2].Change != DiffChangeAdded {
+		t.Fatalf("workstream changes = %+v", diff.Workstreams)
+	}
+	if len(diff.Metrics) != 1 || diff.Metrics[0].ParentEntityID != 1 || diff.Metrics[0].Change != DiffChangeChanged {
+		t.Fatalf("metric diffs = %+v", diff.Metrics)
+	}
+	if len(diff.Risks) != 0 {
+		t.Fatalf("risk with only updated_at change reported: %+v", diff.Risks)
+	}
+	if len(diff.Warnings) != 0 {
+		t.Fatalf("warnings = %v", diff.Warnings)
+	}
+}
+
+func TestDecodeLegacyPlanSnapshot(t *testing.T) {
+	raw := []byte(`{"workstreams":[{"id":1,"risks":[{"id":4,"title":"A"}],"hypotheses":[{"id":9,"title":"H"}]}],` +
+		`"uncovered":{"risks":[{"id":4,"title":"A"},{"id":6,"title":"B"}],"opportunities":[]}}`)
+	snapshot, err := decodePlanSnapshot(raw)
+	if err != nil {
+		t.Fatalf("decodePlanSnapshot() error = %v", err)
+	}
+	if snapshot.complete {
+		t.Fatalf("legacy snapshot marked complete")
+	}
+	if len(snapshot.Risks) != 2 || len(snapshot.Hypotheses) != 1 {
+		t.Fatalf("risks = %+v hypotheses = %+v", snapshot.Risks, snapshot.Hypotheses)
+	}
+	diff := diffPlanSnapshots(snapshot, snapshot)
+	if diff.Summary != (TacticalPlanDiffSummary{}) || len(diff.Warnings) != 1 {
+		t.Fatalf("self diff = %+v", diff)
+	}
+}
+
+func TestTacticsHistoryPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		planID   int
+		route    string
+		revision int
+		ok       bool
+	}{
+		{path: "/api/v2/tactics/4/versions", planID: 4, route: "versions", ok: true},
+		{path: "/api/v2/tactics/4/versions/3", planID: 4, route: "version", revision: 3, ok: true},
+		{path: "/api/v2/tactics/4/diff", planID: 4, route: "diff", ok: true},
+		{path: "/api/v2/tactics/4/applied-changes/revert/", planID: 4, route: "applied-changes/revert", ok: true},
+		{path: "/api/v2/tactics/4", ok: false},
+		{path: "/api/v2/tactics/4/versions/x", ok: false},
+		{path: "/api/v2/tactics/workstreams/4", ok: false},
+	}
+	for _, test := range tests {
+		planID, route, revision, ok := tacticsHistoryPath(test.path)
+		if planID != test.planID || route != test.route || revision != test.revision || ok != test.ok {
+			t.Fatalf("tacticsHistoryPath(%q) = (%d, %q, %d, %v)", test.path, planID, route, revision, ok)
+		}
+	}
+}
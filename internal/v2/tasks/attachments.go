@@ -2,10 +2,13 @@ package tasks
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 
 	"github.com/lib/pq"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 const maxTaskAttachments = 20
@@ -26,14 +29,18 @@ func (s *Store) CreateTaskAttachment(
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
+	sealed, keyVersion, err := encryption.SealBytes(ctx, workspaceID, content)
+	if err != nil {
+		return TaskAttachment{}, err
+	}
 	var item TaskAttachment
-	err := s.dbx.QueryRowContext(ctx, `
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO v2_task_attachments (
-			workspace_id, uploaded_by, filename, content_type, size_bytes, content
+			workspace_id, uploaded_by, filename, content_type, size_bytes, content, content_key_version
 		)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, filename, content_type, size_bytes, created_at
-	`, workspaceID, userID, filename, contentType, len(content), content).Scan(
+	`, workspaceID, userID, filename, contentType, len(content), sealed, keyVersion).Scan(
 		&item.ID, &item.Filename, &item.ContentType, &item.SizeBytes, &item.CreatedAt,
 	)
 	if err != nil {
@@ -46,16 +53,20 @@ func (s *Store) CreateTaskAttachment(
 func (s *Store) TaskAttachmentContent(ctx context.Context, workspaceID int, attachmentID int64) (TaskAttachment, []byte, error) {
 	var item TaskAttachment
 	var content []byte
+	var keyVersion sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
-		SELECT id, filename, content_type, size_bytes, content, created_at
+		SELECT id, filename, content_type, size_bytes, content, content_key_version, created_at
 		FROM v2_task_attachments
 		WHERE workspace_id=$1 AND id=$2
 	`, workspaceID, attachmentID).Scan(
-		&item.ID, &item.Filename, &item.ContentType, &item.SizeBytes, &content, &item.CreatedAt,
+		&item.ID, &item.Filename, &item.ContentType, &item.SizeBytes, &content, &keyVersion, &item.CreatedAt,
 	)
 	if err != nil {
 		return TaskAttachment{}, nil, err
 	}
+	if content, err = encryption.OpenBytes(ctx, workspaceID, content, keyVersion); err != nil {
+		return TaskAttachment{}, nil, err
+	}
 	item.DownloadURL = taskAttachmentDownloadURL(item.ID)
 	return item, content, nil
 }
@@ -2,10 +2,12 @@ package tasks
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
 
+	"reup-goals-backend/internal/encryption"
 	"reup-goals-backend/internal/v2/aiactions"
 )
 
@@ -26,16 +28,20 @@ func (s *Store) CreateBrainstormMessage(
 	if actions == nil {
 		actions = []BrainstormAction{}
 	}
-	var item BrainstormMessage
+	item := BrainstormMessage{Content: strings.TrimSpace(content)}
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, item.Content)
+	if err != nil {
+		return BrainstormMessage{}, err
+	}
 	var actionsRaw json.RawMessage
-	err := s.dbx.QueryRowContext(ctx, `
+	err = s.dbx.QueryRowContext(ctx, `
 		INSERT INTO v2_task_brainstorm_messages (
-			workspace_id, workstream_id, user_id, role, content, actions_json, metadata_json
+			workspace_id, workstream_id, user_id, role, content, content_key_version, actions_json, metadata_json
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, role, content, actions_json, created_at
-	`, workspaceID, workstreamID, nullableInt(userID), role, strings.TrimSpace(content), taskJSON(actions), taskJSON(metadata)).Scan(
-		&item.ID, &item.Role, &item.Content, &actionsRaw, &item.CreatedAt,
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, role, actions_json, created_at
+	`, workspaceID, workstreamID, nullableInt(userID), role, sealed, keyVersion, taskJSON(actions), taskJSON(metadata)).Scan(
+		&item.ID, &item.Role, &actionsRaw, &item.CreatedAt,
 	)
 	if err != nil {
 		return BrainstormMessage{}, err
@@ -53,7 +59,7 @@ func (s *Store) BrainstormMessages(ctx context.Context, workspaceID int, workstr
 		limit = 300
 	}
 	rows, err := s.dbx.QueryContext(ctx, `
-		SELECT id, role, content, actions_json, created_at
+		SELECT id, role, content, content_key_version, actions_json, created_at
 		FROM v2_task_brainstorm_messages
 		WHERE workspace_id=$1 AND workstream_id=$2
 		ORDER BY created_at DESC, id DESC
@@ -68,7 +74,11 @@ func (s *Store) BrainstormMessages(ctx context.Context, workspaceID int, workstr
 	for rows.Next() {
 		var item BrainstormMessage
 		var actionsRaw json.RawMessage
-		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &actionsRaw, &item.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&item.ID, &item.Role, &item.Content, &keyVersion, &actionsRaw, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		_ = json.Unmarshal(actionsRaw, &item.Actions)
@@ -111,16 +121,20 @@ func (s *Store) BrainstormMessages(ctx context.Context, workspaceID int, workstr
 func (s *Store) BrainstormAssistantMessage(ctx context.Context, workspaceID int, workstreamID int, messageID int) (BrainstormMessage, error) {
 	var item BrainstormMessage
 	var actionsRaw json.RawMessage
+	var keyVersion sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
-		SELECT id, role, content, actions_json, created_at
+		SELECT id, role, content, content_key_version, actions_json, created_at
 		FROM v2_task_brainstorm_messages
 		WHERE id=$1 AND workspace_id=$2 AND workstream_id=$3 AND role='assistant'
 	`, messageID, workspaceID, workstreamID).Scan(
-		&item.ID, &item.Role, &item.Content, &actionsRaw, &item.CreatedAt,
+		&item.ID, &item.Role, &item.Content, &keyVersion, &actionsRaw, &item.CreatedAt,
 	)
 	if err != nil {
 		return BrainstormMessage{}, err
 	}
+	if item.Content, err = encryption.OpenText(ctx, workspaceID, item.Content, keyVersion); err != nil {
+		return BrainstormMessage{}, err
+	}
 	_ = json.Unmarshal(actionsRaw, &item.Actions)
 	if item.Actions == nil {
 		item.Actions = []BrainstormAction{}
@@ -314,6 +314,10 @@ func (s *Store) Create(ctx context.Context, workspaceID int, userID int, input T
 	if err := s.validateOwner(ctx, workspaceID, input.OwnerUserID); err != nil {
 		return Task{}, err
 	}
+	courseID := plan.CourseID
+	if workstream.CourseID != nil {
+		courseID = workstream.CourseID
+	}
 	departmentID, err := s.resolveDepartment(ctx, workspaceID, input.DepartmentID, workstream.ID, input.ProjectID)
 	if err != nil {
 		return Task{}, err
@@ -356,7 +360,7 @@ func (s *Store) Create(ctx context.Context, workspaceID int, userID int, input T
 			due_date::TEXT, source_type, source_id, created_by, updated_by, created_at,
 			updated_at, started_at, completed_at, archived_at,
 			completion_result, completion_evidence, completion_learning, hypothesis_outcome, next_step
-	`, workspaceID, nullableInt(plan.CourseID), plan.ID, workstream.ID, departmentID, nullableInt(input.ProjectID),
+	`, workspaceID, nullableInt(courseID), plan.ID, workstream.ID, departmentID, nullableInt(input.ProjectID),
 		nullableInt(input.RiskID), nullableInt(input.OpportunityID), strings.TrimSpace(*input.Title),
 		valueOrEmpty(input.Description), valueOrEmpty(input.ExpectedResult), valueOrEmpty(input.SuccessCriteria),
 		valueOrEmpty(input.WhyNow), status, blocked, backlogCategory, nullableInt(input.PriorityOrder), nullableInt(input.OwnerUserID),
@@ -573,6 +577,7 @@ func (s *Store) Update(ctx context.Context, workspaceID int, userID int, taskID
 			started_at=CASE WHEN $13=$22 THEN COALESCE(started_at, NOW()) ELSE started_at END,
 			completed_at=CASE WHEN $13=$23 THEN CASE WHEN $25=$23 THEN COALESCE(completed_at, NOW()) ELSE NOW() END ELSE NULL END,
 			archived_at=CASE WHEN $13=$24 THEN CASE WHEN $25=$24 THEN COALESCE(archived_at, NOW()) ELSE NOW() END ELSE NULL END,
+			course_id=CASE WHEN $26 THEN $27 ELSE course_id END,
 			updated_at=NOW()
 		WHERE id=$20 AND workspace_id=$21
 		RETURNING
@@ -584,7 +589,7 @@ func (s *Store) Update(ctx context.Context, workspaceID int, userID int, taskID
 			completion_result, completion_evidence, completion_learning, hypothesis_outcome, next_step
 	`, title, description, expectedResult, successCriteria, whyNow, blocked, backlogCategory, nullableInt(projectID), departmentID, workstreamID, nullableInt(ownerUserID), nullableString(dueDate),
 		status, completionResult, completionEvidence, completionLearning, hypothesisOutcome, nextStep, userID, taskID, workspaceID,
-		StatusInProgress, StatusDone, StatusArchived, current.Status)
+		StatusInProgress, StatusDone, StatusArchived, current.Status, workstreamChanged, nullableInt(workstream.CourseID))
 
 	task, err := scanTask(row)
 	if err != nil {
@@ -918,6 +923,9 @@ func (s *Store) workstreams(ctx context.Context, workspaceID int, planID int) ([
 type workstreamRef struct {
 	ID             int
 	TacticalPlanID int
+	// CourseID is the course the workstream serves: its own open course link
+	// or, without one, the plan course.
+	CourseID *int
 }
 
 // ensureDirectionWorkstream keeps the old relational requirements behind the
@@ -1083,11 +1091,18 @@ func isRemovalPriority(task Task) bool {
 
 func (s *Store) workstreamByID(ctx context.Context, workspaceID int, workstreamID int) (workstreamRef, error) {
 	var ref workstreamRef
+	var courseID sql.NullInt64
 	err := s.dbx.QueryRowContext(ctx, `
-		SELECT id, tactical_plan_id
-		FROM v2_tactical_workstreams
-		WHERE id=$1 AND workspace_id=$2 AND archived_at IS NULL
-	`, workstreamID, workspaceID).Scan(&ref.ID, &ref.TacticalPlanID)
+		SELECT workstream.id, workstream.tactical_plan_id, COALESCE(linked.id, plan.course_id)
+		FROM v2_tactical_workstreams workstream
+		JOIN v2_tactical_plans plan ON plan.id=workstream.tactical_plan_id
+		LEFT JOIN v2_courses linked ON linked.id=workstream.course_id AND linked.archived_at IS NULL
+		WHERE workstream.id=$1 AND workstream.workspace_id=$2 AND workstream.archived_at IS NULL
+	`, workstreamID, workspaceID).Scan(&ref.ID, &ref.TacticalPlanID, &courseID)
+	if courseID.Valid {
+		value := int(courseID.Int64)
+		ref.CourseID = &value
+	}
 	return ref, err
 }
 
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+
+	"reup-goals-backend/internal/encryption"
 )
 
 type Store struct {
@@ -20,7 +22,7 @@ func (s *Store) List(ctx context.Context, workspaceID int, includeArchived bool)
 	query := `
 		SELECT id, workspace_id, parent_id, title, content, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids,
-			version, created_by, updated_by, created_at, updated_at, archived_at
+			version, created_by, updated_by, created_at, updated_at, archived_at, content_key_version
 		FROM workspace_documents
 		WHERE workspace_id=$1`
 	if !includeArchived {
@@ -35,7 +37,7 @@ func (s *Store) List(ctx context.Context, workspaceID int, includeArchived bool)
 
 	documents := make([]Document, 0)
 	for rows.Next() {
-		document, err := scanDocument(rows)
+		document, err := scanDocument(ctx, rows)
 		if err != nil {
 			return nil, err
 		}
@@ -48,16 +50,16 @@ func (s *Store) Get(ctx context.Context, workspaceID int, documentID int64) (Doc
 	row := s.db.QueryRowContext(ctx, `
 		SELECT id, workspace_id, parent_id, title, content, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids,
-			version, created_by, updated_by, created_at, updated_at, archived_at
+			version, created_by, updated_by, created_at, updated_at, archived_at, content_key_version
 		FROM workspace_documents
 		WHERE workspace_id=$1 AND id=$2
 	`, workspaceID, documentID)
-	return scanDocument(row)
+	return scanDocument(ctx, row)
 }
 
 func (s *Store) Versions(ctx context.Context, workspaceID int, documentID int64) ([]Version, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, document_id, version, title, content, status, favorite, saved_by, created_at
+		SELECT id, document_id, version, title, content, content_key_version, status, favorite, saved_by, created_at
 		FROM workspace_document_versions
 		WHERE workspace_id=$1 AND document_id=$2
 		ORDER BY version DESC
@@ -70,7 +72,11 @@ func (s *Store) Versions(ctx context.Context, workspaceID int, documentID int64)
 	versions := make([]Version, 0)
 	for rows.Next() {
 		var version Version
-		if err := rows.Scan(&version.ID, &version.DocumentID, &version.Version, &version.Title, &version.Content, &version.Status, &version.Favorite, &version.SavedBy, &version.CreatedAt); err != nil {
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&version.ID, &version.DocumentID, &version.Version, &version.Title, &version.Content, &keyVersion, &version.Status, &version.Favorite, &version.SavedBy, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		if version.Content, err = encryption.OpenText(ctx, workspaceID, version.Content, keyVersion); err != nil {
 			return nil, err
 		}
 		versions = append(versions, version)
@@ -98,6 +104,10 @@ func (s *Store) Create(ctx context.Context, workspaceID, userID int, input Input
 	departmentJSON, _ := json.Marshal(departmentIDs)
 	workstreamJSON, _ := json.Marshal(workstreamIDs)
 	projectJSON, _ := json.Marshal(projectIDs)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, content)
+	if err != nil {
+		return Document{}, err
+	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -108,13 +118,13 @@ func (s *Store) Create(ctx context.Context, workspaceID, userID int, input Input
 		INSERT INTO workspace_documents (
 			workspace_id, parent_id, title, content, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids,
-			created_by, updated_by
-		) VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8::jsonb,$9::jsonb,$10,$10)
+			created_by, updated_by, content_key_version
+		) VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8::jsonb,$9::jsonb,$10,$10,$11)
 		RETURNING id, workspace_id, parent_id, title, content, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids,
-			version, created_by, updated_by, created_at, updated_at, archived_at
-	`, workspaceID, input.ParentID, title, content, status, favorite, departmentJSON, workstreamJSON, projectJSON, userID)
-	document, err := scanDocument(row)
+			version, created_by, updated_by, created_at, updated_at, archived_at, content_key_version
+	`, workspaceID, input.ParentID, title, sealed, status, favorite, departmentJSON, workstreamJSON, projectJSON, userID, keyVersion)
+	document, err := scanDocument(ctx, row)
 	if err != nil {
 		return Document{}, err
 	}
@@ -240,6 +250,10 @@ func (s *Store) Update(ctx context.Context, workspaceID, userID int, documentID
 	departmentJSON, _ := json.Marshal(departmentIDs)
 	workstreamJSON, _ := json.Marshal(workstreamIDs)
 	projectJSON, _ := json.Marshal(projectIDs)
+	sealed, keyVersion, err := encryption.SealText(ctx, workspaceID, content)
+	if err != nil {
+		return Document{}, err
+	}
 
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -248,16 +262,16 @@ func (s *Store) Update(ctx context.Context, workspaceID, userID int, documentID
 	defer tx.Rollback()
 	row := tx.QueryRowContext(ctx, `
 		UPDATE workspace_documents
-		SET parent_id=$3, title=$4, content=$5, status=$6, favorite=$7,
+		SET parent_id=$3, title=$4, content=$5, content_key_version=$13, status=$6, favorite=$7,
 			linked_department_ids=$8::jsonb, linked_workstream_ids=$9::jsonb, linked_project_ids=$10::jsonb,
 			version=version+1, updated_by=$11, updated_at=NOW(),
 			archived_at=CASE WHEN $6='archived' THEN COALESCE(archived_at, NOW()) ELSE NULL END
 		WHERE workspace_id=$1 AND id=$2 AND version=$12
 		RETURNING id, workspace_id, parent_id, title, content, status, favorite,
 			linked_department_ids, linked_workstream_ids, linked_project_ids,
-			version, created_by, updated_by, created_at, updated_at, archived_at
-	`, workspaceID, documentID, parentID, title, content, status, favorite, departmentJSON, workstreamJSON, projectJSON, userID, current.Version)
-	document, err := scanDocument(row)
+			version, created_by, updated_by, created_at, updated_at, archived_at, content_key_version
+	`, workspaceID, documentID, parentID, title, sealed, status, favorite, departmentJSON, workstreamJSON, projectJSON, userID, current.Version, keyVersion)
+	document, err := scanDocument(ctx, row)
 	if errors.Is(err, sql.ErrNoRows) {
 		return Document{}, ErrVersionConflict
 	}
@@ -315,12 +329,16 @@ func saveVersion(ctx context.Context, tx *sql.Tx, document Document, userID int)
 	departmentJSON, _ := json.Marshal(document.LinkedDepartmentIDs)
 	workstreamJSON, _ := json.Marshal(document.LinkedWorkstreamIDs)
 	projectJSON, _ := json.Marshal(document.LinkedProjectIDs)
-	_, err := tx.ExecContext(ctx, `
+	sealed, keyVersion, err := encryption.SealText(ctx, document.WorkspaceID, document.Content)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO workspace_document_versions (
 			document_id, workspace_id, version, title, content, status, favorite,
-			linked_department_ids, linked_workstream_ids, linked_project_ids, saved_by
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9::jsonb,$10::jsonb,$11)
-	`, document.ID, document.WorkspaceID, document.Version, document.Title, document.Content, document.Status, document.Favorite, departmentJSON, workstreamJSON, projectJSON, userID)
+			linked_department_ids, linked_workstream_ids, linked_project_ids, saved_by, content_key_version
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9::jsonb,$10::jsonb,$11,$12)
+	`, document.ID, document.WorkspaceID, document.Version, document.Title, sealed, document.Status, document.Favorite, departmentJSON, workstreamJSON, projectJSON, userID, keyVersion)
 	return err
 }
 
@@ -328,18 +346,22 @@ type scanner interface {
 	Scan(dest ...any) error
 }
 
-func scanDocument(row scanner) (Document, error) {
+func scanDocument(ctx context.Context, row scanner) (Document, error) {
 	var document Document
 	var departmentJSON, workstreamJSON, projectJSON []byte
+	var keyVersion sql.NullInt64
 	err := row.Scan(
 		&document.ID, &document.WorkspaceID, &document.ParentID, &document.Title, &document.Content,
 		&document.Status, &document.Favorite, &departmentJSON, &workstreamJSON, &projectJSON,
 		&document.Version, &document.CreatedBy, &document.UpdatedBy, &document.CreatedAt, &document.UpdatedAt,
-		&document.ArchivedAt,
+		&document.ArchivedAt, &keyVersion,
 	)
 	if err != nil {
 		return Document{}, err
 	}
+	if document.Content, err = encryption.OpenText(ctx, document.WorkspaceID, document.Content, keyVersion); err != nil {
+		return Document{}, err
+	}
 	_ = json.Unmarshal(departmentJSON, &document.LinkedDepartmentIDs)
 	_ = json.Unmarshal(workstreamJSON, &document.LinkedWorkstreamIDs)
 	_ = json.Unmarshal(projectJSON, &document.LinkedProjectIDs)
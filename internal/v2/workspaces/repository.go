@@ -11,6 +11,21 @@ type Store struct {
 }
 
 var ErrWorkspaceSetupRequired = errors.New("workspace_setup_required")
+var ErrWorkspaceUnavailable = errors.New("workspace_unavailable")
+
+type pinnedWorkspaceKey struct{}
+
+// ContextWithWorkspace pins the workspace a request acts on. Workspace API
+// tokens are bound to one workspace, which need not be their creator's
+// default, so GetOrCreateDefault returns the pinned workspace instead.
+func ContextWithWorkspace(ctx context.Context, workspaceID int) context.Context {
+	return context.WithValue(ctx, pinnedWorkspaceKey{}, workspaceID)
+}
+
+func pinnedWorkspace(ctx context.Context) sql.NullInt64 {
+	workspaceID, ok := ctx.Value(pinnedWorkspaceKey{}).(int)
+	return sql.NullInt64{Int64: int64(workspaceID), Valid: ok}
+}
 
 func NewStore(dbx *sql.DB) *Store {
 	return &Store{dbx: dbx}
@@ -35,12 +50,18 @@ func OnboardingPending(ctx context.Context, dbx *sql.DB, workspaceID int) (bool,
 	return pending, err
 }
 
+// GetOrCreateDefault returns the user's current workspace. A pinned
+// workspace the user is no longer an active member of is never replaced by
+// another one.
 func (s *Store) GetOrCreateDefault(ctx context.Context, userID int) (Workspace, Membership, error) {
 	if workspace, membership, err := s.current(ctx, s.dbx, userID); err == nil {
 		return workspace, membership, nil
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		return Workspace{}, Membership{}, err
 	}
+	if pinnedWorkspace(ctx).Valid {
+		return Workspace{}, Membership{}, ErrWorkspaceUnavailable
+	}
 
 	tx, err := s.dbx.BeginTx(ctx, nil)
 	if err != nil {
@@ -174,9 +195,10 @@ func (s *Store) current(ctx context.Context, q queryer, userID int) (Workspace,
 		WHERE m.user_id=$1
 			AND m.status=$2
 			AND w.status=$3
+			AND ($4::INTEGER IS NULL OR w.id=$4)
 		ORDER BY m.is_default DESC, m.created_at ASC
 		LIMIT 1
-	`, userID, MembershipStatusActive, WorkspaceStatusActive).Scan(
+	`, userID, MembershipStatusActive, WorkspaceStatusActive, pinnedWorkspace(ctx)).Scan(
 		&workspace.ID,
 		&workspace.Name,
 		&displayName,
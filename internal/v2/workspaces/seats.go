@@ -0,0 +1,73 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// DefaultMemberLimit is the seat limit of a workspace without a subscription.
+const DefaultMemberLimit = 5
+
+var ErrMemberLimitReached = errors.New("workspace_member_limit_reached")
+
+// MemberLimit returns the seat limit of the workspace's subscription, or of
+// the owner's legacy one. Zero means unlimited.
+func MemberLimit(ctx context.Context, q queryer, workspaceID int) (int, error) {
+	var limit int
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE((
+			SELECT subscription.member_limit
+			FROM subscriptions subscription
+			JOIN workspaces workspace ON workspace.id=$1
+			WHERE subscription.workspace_id=$1
+				OR (subscription.workspace_id IS NULL AND subscription.user_id=workspace.owner_user_id)
+			ORDER BY CASE WHEN subscription.workspace_id=$1 THEN 0 ELSE 1 END, subscription.updated_at DESC
+			LIMIT 1
+		), $2)
+	`, workspaceID, DefaultMemberLimit).Scan(&limit)
+	return limit, err
+}
+
+// ReservedSeats counts active members and pending invitations that have not
+// expired. An invitation for exceptEmail is left out: the caller is about to
+// turn it into a membership or replace it.
+func ReservedSeats(ctx context.Context, q queryer, workspaceID int, exceptEmail string) (int, error) {
+	var seats int
+	err := q.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM workspace_memberships
+			 WHERE workspace_id=$1 AND status='active')
+			+
+			(SELECT COUNT(*) FROM workspace_invitations
+			 WHERE workspace_id=$1 AND status='pending' AND expires_at>NOW() AND lower(email)<>$2)
+	`, workspaceID, strings.ToLower(strings.TrimSpace(exceptEmail))).Scan(&seats)
+	return seats, err
+}
+
+// CheckSeat returns ErrMemberLimitReached unless the person with email can
+// join the workspace. Invitations, SSO sign-ups and SCIM provisioning all
+// go through it so they agree on what a free seat is; callers hold the
+// workspace's advisory lock.
+func CheckSeat(ctx context.Context, q queryer, workspaceID int, email string) error {
+	limit, err := MemberLimit(ctx, q, workspaceID)
+	if err != nil {
+		return err
+	}
+	return CheckSeatWithin(ctx, q, workspaceID, email, limit)
+}
+
+// CheckSeatWithin is CheckSeat for a limit the caller already resolved.
+func CheckSeatWithin(ctx context.Context, q queryer, workspaceID int, email string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	seats, err := ReservedSeats(ctx, q, workspaceID, email)
+	if err != nil {
+		return err
+	}
+	if seats >= limit {
+		return ErrMemberLimitReached
+	}
+	return nil
+}